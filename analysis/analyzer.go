@@ -0,0 +1,251 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package analysis
+
+import (
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func init() {
+	event.RegisterField(event.Field{Name: "analysis.field", Type: event.TypeString, Description: "Field of the event containing the analyzed payload"})
+	event.RegisterField(event.Field{Name: "analysis.sha256", Type: event.TypeString, Description: "SHA256 of the analyzed payload"})
+	event.RegisterField(event.Field{Name: "analysis.format", Type: event.TypeString, Description: "Format of the payload: elf, pe, script or data"})
+	event.RegisterField(event.Field{Name: "analysis.arch", Type: event.TypeString, Description: "Architecture of the executable"})
+	event.RegisterField(event.Field{Name: "analysis.bits", Type: event.TypeInteger, Description: "Word size of the executable"})
+	event.RegisterField(event.Field{Name: "analysis.endianness", Type: event.TypeString, Description: "Byte order of the executable"})
+	event.RegisterField(event.Field{Name: "analysis.interpreter", Type: event.TypeString, Description: "Interpreter of the script"})
+	event.RegisterField(event.Field{Name: "analysis.packer", Type: event.TypeString, Description: "Packer of the executable, unknown for packed executables without known marker"})
+	event.RegisterField(event.Field{Name: "analysis.entropy", Type: event.TypeNumber, Description: "Shannon entropy of the payload in bits per byte"})
+	event.RegisterField(event.Field{Name: "analysis.strings", Type: event.TypeArray, Description: "Printable strings embedded in the executable"})
+	event.RegisterField(event.Field{Name: "analysis.urls", Type: event.TypeArray, Description: "Urls found in the payload"})
+	event.RegisterField(event.Field{Name: "analysis.commands", Type: event.TypeArray, Description: "Shell commands injected in the request"})
+	event.RegisterField(event.Field{Name: "analysis.decoded", Type: event.TypeArray, Description: "Base64 encoded payloads of the request, decoded"})
+	event.RegisterField(event.Field{Name: "analysis.shellcode", Type: event.TypeArray, Description: "Shellcode patterns found in the request"})
+}
+
+// Analyzer analyzes the payloads of the events it receives. The analyzer
+// should be subscribed to the bus before the channels, so the results are
+// available to all channels.
+type Analyzer struct {
+	// Fields are the event fields containing captured payloads
+	Fields []string `toml:"fields"`
+
+	// MinSize and MaxSize are the sizes of the payloads that are analyzed,
+	// a max size of zero doesn't limit the size
+	MinSize int `toml:"min-size"`
+	MaxSize int `toml:"max-size"`
+
+	// Extract are the event fields scanned for injected commands, base64
+	// encoded payloads and shellcode, of all services
+	Extract []string `toml:"extract"`
+
+	Options Options `toml:"-"`
+}
+
+// New returns a new Analyzer.
+func New(options ...func(*Analyzer) error) (*Analyzer, error) {
+	a := &Analyzer{
+		Fields:  []string{"payload", "tftp.file"},
+		MinSize: 16,
+		MaxSize: 16 * 1024 * 1024,
+		Extract: []string{"payload", "http.url", "http.header.user-agent"},
+		Options: DefaultOptions,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the analysis configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Analyzer) error {
+	return func(a *Analyzer) error {
+		return decoder.PrimitiveDecode(c, a)
+	}
+}
+
+// value returns the value of the field as bytes.
+func value(e event.Event, field string) []byte {
+	var data []byte
+
+	e.Range(func(k, v interface{}) bool {
+		if k != field {
+			return true
+		}
+
+		switch v := v.(type) {
+		case []byte:
+			data = v
+		case string:
+			data = []byte(v)
+		case []string:
+			// the values of http headers
+			data = []byte(strings.Join(v, "\n"))
+		}
+
+		return false
+	})
+
+	return data
+}
+
+// payload returns the payload of the event, and the field it was found in.
+func (a *Analyzer) payload(e event.Event) ([]byte, string) {
+	for _, field := range a.Fields {
+		if data := value(e, field); len(data) > 0 {
+			return data, field
+		}
+	}
+
+	return nil, ""
+}
+
+// Send attaches the analysis of the payload to the event, and what has
+// been extracted from the requests.
+func (a *Analyzer) Send(e event.Event) {
+	a.analyze(e)
+	a.extract(e)
+}
+
+// analyze attaches the analysis of the payload to the event, if the
+// payload is an executable or script or contains urls.
+func (a *Analyzer) analyze(e event.Event) {
+	data, field := a.payload(e)
+
+	if len(data) < a.MinSize {
+		return
+	}
+
+	if a.MaxSize > 0 && len(data) > a.MaxSize {
+		data = data[:a.MaxSize]
+	}
+
+	r := a.Options.Analyze(data)
+	if !r.Interesting() {
+		return
+	}
+
+	e.Store("analysis.field", field)
+	e.Store("analysis.sha256", r.SHA256)
+	e.Store("analysis.format", r.Format)
+	e.Store("analysis.entropy", r.Entropy)
+
+	if r.Arch != "" {
+		e.Store("analysis.arch", r.Arch)
+	}
+
+	if r.Bits != 0 {
+		e.Store("analysis.bits", r.Bits)
+	}
+
+	if r.Endianness != "" {
+		e.Store("analysis.endianness", r.Endianness)
+	}
+
+	if r.Interpreter != "" {
+		e.Store("analysis.interpreter", r.Interpreter)
+	}
+
+	if r.Packer != "" {
+		e.Store("analysis.packer", r.Packer)
+	}
+
+	if len(r.Strings) > 0 {
+		e.Store("analysis.strings", r.Strings)
+	}
+
+	if len(r.URLs) > 0 {
+		e.Store("analysis.urls", r.URLs)
+	}
+}
+
+// extract attaches the commands, urls, decoded payloads and shellcode
+// found in the fields to the event.
+func (a *Analyzer) extract(e event.Event) {
+	x := Extraction{}
+
+	for _, field := range a.Extract {
+		data := value(e, field)
+		if len(data) == 0 {
+			continue
+		}
+
+		if a.MaxSize > 0 && len(data) > a.MaxSize {
+			data = data[:a.MaxSize]
+		}
+
+		r := a.Options.Extract(data)
+
+		for _, c := range r.Commands {
+			x.Commands = appendUnique(x.Commands, a.Options.MaxCommands, c)
+		}
+
+		for _, u := range r.URLs {
+			x.URLs = appendUnique(x.URLs, a.Options.MaxURLs, u)
+		}
+
+		for _, d := range r.Decoded {
+			x.Decoded = appendUnique(x.Decoded, a.Options.MaxStrings, d)
+		}
+
+		for _, name := range r.Shellcode {
+			x.Shellcode = appendUnique(x.Shellcode, 0, name)
+		}
+	}
+
+	if len(x.Commands) > 0 {
+		e.Store("analysis.commands", x.Commands)
+	}
+
+	if len(x.Decoded) > 0 {
+		e.Store("analysis.decoded", x.Decoded)
+	}
+
+	if len(x.Shellcode) > 0 {
+		e.Store("analysis.shellcode", x.Shellcode)
+	}
+
+	if len(x.URLs) == 0 {
+		return
+	}
+
+	// the urls of the analysis of the payload
+	urls := []string{}
+
+	e.Range(func(k, v interface{}) bool {
+		if k != "analysis.urls" {
+			return true
+		}
+
+		urls, _ = v.([]string)
+		return false
+	})
+
+	for _, u := range x.URLs {
+		urls = appendUnique(urls, a.Options.MaxURLs, u)
+	}
+
+	e.Store("analysis.urls", urls)
+}
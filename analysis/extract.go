@@ -0,0 +1,234 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package analysis
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxCommandLength is the length the extracted commands and decoded
+// payloads are truncated to.
+const maxCommandLength = 256
+
+// commands are the commands recognized in injected command sequences, like
+// the downloaders of botnets.
+var commands = map[string]bool{
+	"bash": true, "base64": true, "busybox": true, "cat": true, "cd": true,
+	"chmod": true, "cmd": true, "cmd.exe": true, "curl": true, "echo": true,
+	"ftpget": true, "id": true, "kill": true, "mkdir": true, "nc": true,
+	"ncat": true, "nohup": true, "perl": true, "php": true, "pkill": true,
+	"powershell": true, "powershell.exe": true, "python": true,
+	"python3": true, "rm": true, "sh": true, "tftp": true, "uname": true,
+	"wget": true, "whoami": true,
+}
+
+// commandSeparators separate the commands of injected sequences.
+var commandSeparators = regexp.MustCompile("&&|\\|\\||\\$\\(|[;&|`()\"'\r\n]")
+
+var (
+	base64Regexp      = regexp.MustCompile(`[A-Za-z0-9+/]{20,}={0,2}`)
+	escapedHexRegexp  = regexp.MustCompile(`(?:\\x[0-9a-fA-F]{2}){8,}`)
+	percentByteRegexp = regexp.MustCompile(`%[0-9a-fA-F]{2}`)
+)
+
+// shellcodes are the markers of common shellcode.
+var shellcodes = []struct {
+	Name   string
+	Marker []byte
+}{
+	{"nop-sled", bytes.Repeat([]byte{0x90}, 16)},
+	{"nop-sled", []byte("%u9090%u9090")},
+	// push "//sh", push "/bin" of execve shellcode
+	{"execve-binsh", []byte("h//shh/bin")},
+	// xor eax, eax; push eax; push
+	{"xor-push", []byte{0x31, 0xc0, 0x50, 0x68}},
+}
+
+// Extraction contains the commands, urls and shellcode found in captured
+// request bytes.
+type Extraction struct {
+	Commands []string `json:"commands,omitempty"`
+	URLs     []string `json:"urls,omitempty"`
+
+	// Decoded are the base64 encoded payloads that have been decoded
+	Decoded []string `json:"decoded,omitempty"`
+
+	// Shellcode are the names of the shellcode patterns found
+	Shellcode []string `json:"shellcode,omitempty"`
+}
+
+// Empty returns true if nothing has been extracted.
+func (x Extraction) Empty() bool {
+	return len(x.Commands) == 0 && len(x.URLs) == 0 && len(x.Decoded) == 0 && len(x.Shellcode) == 0
+}
+
+// Extract extracts with the default options.
+func Extract(data []byte) Extraction {
+	return DefaultOptions.Extract(data)
+}
+
+// Extract scans the data for injected shell command sequences, base64
+// encoded payloads and shellcode. The encoded payloads are decoded and
+// scanned as well.
+func (o Options) Extract(data []byte) Extraction {
+	x := Extraction{}
+
+	for _, name := range shellcode(data) {
+		x.Shellcode = appendUnique(x.Shellcode, 0, name)
+	}
+
+	text := unescape(data)
+
+	for _, m := range escapedHexRegexp.FindAll(text, -1) {
+		x.Shellcode = appendUnique(x.Shellcode, 0, "escaped-hex")
+
+		decoded, _ := hex.DecodeString(strings.Replace(string(m), `\x`, "", -1))
+		for _, name := range shellcode(decoded) {
+			x.Shellcode = appendUnique(x.Shellcode, 0, name)
+		}
+	}
+
+	scan := func(text []byte) {
+		for _, c := range injectedCommands(text) {
+			x.Commands = appendUnique(x.Commands, o.MaxCommands, c)
+		}
+
+		for _, u := range o.urls(text) {
+			x.URLs = appendUnique(x.URLs, o.MaxURLs, u)
+		}
+	}
+
+	scan(text)
+
+	for _, m := range base64Regexp.FindAll(text, -1) {
+		decoded, err := base64.StdEncoding.DecodeString(string(m))
+		if err != nil {
+			decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(string(m), "="))
+		}
+
+		if err != nil || !printable(decoded) {
+			continue
+		}
+
+		x.Decoded = appendUnique(x.Decoded, o.MaxStrings, string(decoded))
+
+		scan(decoded)
+	}
+
+	return x
+}
+
+// appendUnique appends the value truncated to the maximum command length,
+// unless it has been found before or the maximum of values (if any) is
+// reached.
+func appendUnique(values []string, max int, s string) []string {
+	if len(s) > maxCommandLength {
+		s = s[:maxCommandLength]
+	}
+
+	if max > 0 && len(values) >= max {
+		return values
+	}
+
+	for _, v := range values {
+		if v == s {
+			return values
+		}
+	}
+
+	return append(values, s)
+}
+
+// unescape decodes the percent encoded bytes, and replaces the internal
+// field separators used to avoid spaces in injected commands.
+func unescape(data []byte) []byte {
+	data = percentByteRegexp.ReplaceAllFunc(data, func(m []byte) []byte {
+		b, _ := hex.DecodeString(string(m[1:]))
+		return b
+	})
+
+	data = bytes.Replace(data, []byte("${IFS}"), []byte(" "), -1)
+	return bytes.Replace(data, []byte("$IFS"), []byte(" "), -1)
+}
+
+// injectedCommands returns the command sequences in the text, the commands
+// follow a separator or a parameter.
+func injectedCommands(text []byte) []string {
+	found := []string{}
+
+	for _, segment := range commandSeparators.Split(string(text), -1) {
+		candidates := []string{segment}
+
+		// commands passed as query or parameter, eg. ?cmd=wget
+		for i := strings.IndexAny(segment, "?="); i >= 0; i = strings.IndexAny(segment, "?=") {
+			segment = segment[i+1:]
+			candidates = append(candidates, segment)
+		}
+
+		for _, c := range candidates {
+			c = strings.TrimSpace(c)
+
+			fields := strings.FieldsFunc(c, func(r rune) bool {
+				return r == ' ' || r == '\t' || r == '+'
+			})
+
+			if len(fields) == 0 {
+				continue
+			}
+
+			// executions of the downloaded binaries, eg. ./mirai.arm
+			if name := path.Base(fields[0]); !commands[name] && !strings.HasPrefix(fields[0], "./") {
+				continue
+			}
+
+			found = append(found, strings.Join(fields, " "))
+			break
+		}
+	}
+
+	return found
+}
+
+// shellcode returns the names of the shellcode patterns in the data.
+func shellcode(data []byte) []string {
+	found := []string{}
+
+	for _, s := range shellcodes {
+		if bytes.Contains(data, s.Marker) {
+			found = append(found, s.Name)
+		}
+	}
+
+	return found
+}
+
+// printable returns true if the data is text.
+func printable(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	for _, b := range data {
+		if (b < 0x20 || b >= 0x7f) && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+
+	return true
+}
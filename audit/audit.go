@@ -0,0 +1,281 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package audit connects to the services of a sensor from the outside, and
+// runs the heuristics attackers use to recognize honeypots: default
+// banners, instant responses, generated certificates and accepting any
+// credentials. The findings are scored and come with the configuration
+// options that remediate them.
+package audit
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+// Severities of the findings.
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// weights are the points a finding of the severity adds to the score.
+var weights = map[string]int{
+	SeverityHigh:   25,
+	SeverityMedium: 10,
+	SeverityLow:    5,
+}
+
+// Target is a service of the sensor to audit.
+type Target struct {
+	// Port is the configured port, eg. tcp/22
+	Port string `json:"port"`
+
+	// Address is the address the service is connected to
+	Address string `json:"address"`
+
+	// Service is the name and Type the type of the service
+	Service string `json:"service"`
+	Type    string `json:"type"`
+
+	// TLS is set when the port is wrapped in tls
+	TLS bool `json:"tls"`
+
+	// Latency is set when artificial latency is configured
+	Latency bool `json:"latency"`
+}
+
+// Finding is a trait of a service which gives the honeypot away.
+type Finding struct {
+	Check    string `json:"check"`
+	Port     string `json:"port"`
+	Service  string `json:"service"`
+	Severity string `json:"severity"`
+
+	Description string `json:"description"`
+
+	// Remediation is the configuration change that removes the trait
+	Remediation string `json:"remediation"`
+}
+
+// Report contains the findings of the audit.
+type Report struct {
+	Date    time.Time `json:"date"`
+	Targets []Target  `json:"targets"`
+
+	// Score is the detectability of the sensor, from 0 (no findings) to
+	// 100
+	Score int `json:"score"`
+
+	Findings []Finding `json:"findings"`
+
+	// Errors are the targets that couldn't be audited
+	Errors []string `json:"errors"`
+}
+
+// Auditor audits the targets.
+type Auditor struct {
+	// Host is the host the services are connected to
+	Host string
+
+	// Timeout is the time a service has to respond
+	Timeout time.Duration
+
+	// Samples is the number of connections the timing is measured of
+	Samples int
+
+	targets []Target
+}
+
+// New returns an Auditor.
+func New(options ...func(*Auditor) error) (*Auditor, error) {
+	a := &Auditor{
+		Host:    "127.0.0.1",
+		Timeout: 3 * time.Second,
+		Samples: 5,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// WithHost sets the host the services are connected to.
+func WithHost(host string) func(*Auditor) error {
+	return func(a *Auditor) error {
+		a.Host = host
+		return nil
+	}
+}
+
+// WithTargets adds the targets.
+func WithTargets(targets ...Target) func(*Auditor) error {
+	return func(a *Auditor) error {
+		a.targets = append(a.targets, targets...)
+		return nil
+	}
+}
+
+// WithConfig adds the tcp ports of the configuration as targets, the ports
+// are connected to on the host of the auditor.
+func WithConfig(c *config.Config) func(*Auditor) error {
+	return func(a *Auditor) error {
+		services := map[string]Target{}
+
+		for name, s := range c.Services {
+			x := struct {
+				Type    string `toml:"type"`
+				Latency struct {
+					Distribution string `toml:"distribution"`
+				} `toml:"latency"`
+			}{}
+
+			if err := c.PrimitiveDecode(s, &x); err != nil {
+				return fmt.Errorf("Error parsing configuration of service %s: %s", name, err.Error())
+			}
+
+			services[name] = Target{
+				Service: name,
+				Type:    x.Type,
+				Latency: x.Latency.Distribution != "",
+			}
+		}
+
+		for _, p := range c.Ports {
+			x := struct {
+				Port     string    `toml:"port"`
+				Ports    []string  `toml:"ports"`
+				Services []string  `toml:"services"`
+				TLS      *struct{} `toml:"tls"`
+			}{}
+
+			if err := c.PrimitiveDecode(p, &x); err != nil {
+				return fmt.Errorf("Error parsing configuration of ports: %s", err.Error())
+			}
+
+			ports := x.Ports
+			if x.Port != "" {
+				ports = append(ports, x.Port)
+			}
+
+			for _, port := range ports {
+				parts := strings.SplitN(port, "/", 2)
+				if len(parts) != 2 || parts[0] != "tcp" {
+					continue
+				}
+
+				// the port can be bound to a host, the auditor connects
+				// from the outside
+				_, number, err := net.SplitHostPort(parts[1])
+				if err != nil {
+					number = parts[1]
+				}
+
+				for _, name := range x.Services {
+					t, ok := services[name]
+					if !ok {
+						return fmt.Errorf("Unknown service %s of port %s", name, port)
+					}
+
+					t.Port = port
+					t.Address = net.JoinHostPort(a.Host, number)
+					t.TLS = x.TLS != nil
+
+					a.targets = append(a.targets, t)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// Targets returns the targets of the audit.
+func (a *Auditor) Targets() []Target {
+	return a.targets
+}
+
+// check is a heuristic, returning the findings of the target.
+type check func(a *Auditor, t Target) ([]Finding, error)
+
+// checks are the heuristics, run for every target.
+var checks = []check{
+	checkBanner,
+	checkTiming,
+	checkCertificate,
+	checkCredentials,
+}
+
+// Run audits the targets.
+func (a *Auditor) Run() *Report {
+	r := &Report{
+		Date:     time.Now(),
+		Targets:  a.targets,
+		Findings: []Finding{},
+		Errors:   []string{},
+	}
+
+	// ports with multiple services are audited once, detection looks at
+	// what the port presents
+	audited := map[string]bool{}
+
+	for _, t := range a.targets {
+		if audited[t.Address] {
+			continue
+		}
+
+		audited[t.Address] = true
+
+		conn, err := net.DialTimeout("tcp", t.Address, a.Timeout)
+		if err != nil {
+			r.Errors = append(r.Errors, fmt.Sprintf("%s (%s): %s", t.Port, t.Service, err.Error()))
+			continue
+		}
+
+		conn.Close()
+
+		for _, c := range checks {
+			findings, err := c(a, t)
+			if err != nil {
+				r.Errors = append(r.Errors, fmt.Sprintf("%s (%s): %s", t.Port, t.Service, err.Error()))
+				continue
+			}
+
+			r.Findings = append(r.Findings, findings...)
+		}
+	}
+
+	for _, f := range r.Findings {
+		r.Score += weights[f.Severity]
+	}
+
+	if r.Score > 100 {
+		r.Score = 100
+	}
+
+	sort.SliceStable(r.Findings, func(i, j int) bool {
+		return weights[r.Findings[i].Severity] > weights[r.Findings[j].Severity]
+	})
+
+	return r
+}
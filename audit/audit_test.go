@@ -0,0 +1,240 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package audit
+
+import (
+	"bufio"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+)
+
+// serve accepts connections, handled by the handler.
+func serve(t *testing.T, handler func(net.Conn)) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				handler(conn)
+			}()
+		}
+	}()
+
+	return ln
+}
+
+func summary(findings []Finding) string {
+	s := []string{}
+	for _, f := range findings {
+		s = append(s, f.Check+":"+f.Severity)
+	}
+
+	return strings.Join(s, ",")
+}
+
+func TestBanner(t *testing.T) {
+	ln := serve(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "SSH-2.0-OpenSSH_6.6.1p1 2020Ubuntu-2ubuntu2\r\n")
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		conn.Read(make([]byte, 1))
+	})
+	defer ln.Close()
+
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := checkBanner(a, Target{Port: "tcp/22", Service: "ssh", Address: ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := summary(findings); s != "banner:high,banner:low" {
+		t.Errorf("Expected default and outdated banner, got %s", s)
+	}
+
+	if !strings.Contains(findings[0].Remediation, "[service.ssh]") {
+		t.Errorf("Expected remediation to refer to the service, got %s", findings[0].Remediation)
+	}
+}
+
+func TestTiming(t *testing.T) {
+	ln := serve(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "220 ready\r\n")
+	})
+	defer ln.Close()
+
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := checkTiming(a, Target{Port: "tcp/21", Service: "ftp", Address: ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := summary(findings); s != "timing:medium" {
+		t.Errorf("Expected instant responses, got %s", s)
+	}
+
+	slow := serve(t, func(conn net.Conn) {
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(conn, "220 ready\r\n")
+	})
+	defer slow.Close()
+
+	if findings, err := checkTiming(a, Target{Address: slow.Addr().String()}); err != nil || len(findings) != 0 {
+		t.Errorf("Expected no findings for delayed responses, got %v %v", findings, err)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	ln := serve(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "220-Welcome\r\n220 ready\r\n")
+
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch {
+			case strings.HasPrefix(line, "USER"):
+				fmt.Fprint(conn, "331 Password required\r\n")
+			case strings.HasPrefix(line, "PASS"):
+				fmt.Fprint(conn, "230 Logged in\r\n")
+			}
+		}
+	})
+	defer ln.Close()
+
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := checkCredentials(a, Target{Service: "ftp", Type: "ftp", Address: ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := summary(findings); s != "credentials:high" {
+		t.Errorf("Expected random credentials to be accepted, got %s", s)
+	}
+}
+
+func TestCertificate(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	subject := pkix.Name{CommonName: "localhost"}
+
+	cert := &x509.Certificate{
+		Subject:    subject,
+		NotBefore:  now.Add(-time.Minute),
+		NotAfter:   now.Add(365 * 24 * time.Hour),
+		RawIssuer:  []byte("localhost"),
+		RawSubject: []byte("localhost"),
+	}
+
+	if s := summary(certificateFindings(Target{}, cert, now)); s != "certificate:medium,certificate:medium,certificate:low" {
+		t.Errorf("Expected generated certificate, got %s", s)
+	}
+
+	cert = &x509.Certificate{
+		Subject:    pkix.Name{CommonName: "www.example.com"},
+		NotBefore:  now.Add(-90 * 24 * time.Hour),
+		NotAfter:   now.Add(365 * 24 * time.Hour),
+		RawIssuer:  []byte("ca"),
+		RawSubject: []byte("www.example.com"),
+	}
+
+	if findings := certificateFindings(Target{}, cert, now); len(findings) != 0 {
+		t.Errorf("Expected no findings, got %v", findings)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ln := serve(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "220 Welcome to the Go FTP Server\r\n")
+	})
+	defer ln.Close()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	conf := &config.Config{}
+
+	md, err := toml.Decode(fmt.Sprintf(`
+[service.ftp]
+type = "telnet"
+
+[service.closed]
+type = "telnet"
+
+[[port]]
+port = "tcp/%s"
+services = ["ftp"]
+
+[[port]]
+port = "tcp/1"
+services = ["closed"]
+
+[[port]]
+port = "udp/53"
+services = ["closed"]
+`, port), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.MetaData = md
+
+	a, err := New(WithConfig(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.Targets()) != 2 {
+		t.Fatalf("Expected the tcp ports as targets, got %v", a.Targets())
+	}
+
+	r := a.Run()
+
+	if r.Score != 35 || len(r.Errors) != 1 {
+		t.Errorf("Expected score 35 and the closed port as error, got %d %v", r.Score, r.Errors)
+	}
+
+	if r.Findings[0].Check != "banner" {
+		t.Errorf("Expected the banner finding first, got %v", r.Findings)
+	}
+}
@@ -0,0 +1,421 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signature is a banner known to belong to a honeypot.
+type signature struct {
+	Banner      string
+	Description string
+}
+
+// signatures are the default banners of honeytrap and other well known
+// honeypots, detection tools match them literally.
+var signatures = []signature{
+	{"SSH-2.0-OpenSSH_6.6.1p1 2020Ubuntu-2ubuntu2", "the default ssh banner of honeytrap"},
+	{"SSH-2.0-OpenSSH_6.0p1 Debian-4+deb7u2", "the default ssh banner of cowrie"},
+	{"SSH-2.0-OpenSSH_5.1p1 Debian-5", "the default ssh banner of kippo"},
+	{"Welcome to the Go FTP Server", "the default ftp banner of honeytrap"},
+	{"220 DiskStation FTP server ready.", "the default ftp banner of dionaea"},
+}
+
+var openSSHVersion = regexp.MustCompile(`^SSH-2\.0-OpenSSH_(\d+)\.`)
+
+// tlsPorts are the ports that are expected to speak tls.
+var tlsPorts = map[string]bool{
+	"443": true, "465": true, "636": true, "993": true, "995": true, "8443": true,
+}
+
+func isHTTP(t Target) bool {
+	return strings.HasPrefix(t.Type, "http") || strings.HasSuffix(t.Address, ":80") || strings.HasSuffix(t.Address, ":8080")
+}
+
+// dial connects to the target, over tls when the port is wrapped in tls.
+func (a *Auditor) dial(t Target) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: a.Timeout}
+
+	if t.TLS {
+		return tls.DialWithDialer(dialer, "tcp", t.Address, &tls.Config{InsecureSkipVerify: true})
+	}
+
+	return dialer.Dial("tcp", t.Address)
+}
+
+// response returns the banner of the target, or the response to a http
+// request for http services that wait for the client.
+func (a *Auditor) response(conn net.Conn, t Target) ([]byte, error) {
+	if isHTTP(t) {
+		host, _, _ := net.SplitHostPort(t.Address)
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", host)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(a.Timeout))
+
+	buf := make([]byte, 4096)
+
+	n, err := conn.Read(buf)
+	if n > 0 {
+		return buf[:n], nil
+	}
+
+	// services that don't send a banner time out
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return nil, nil
+	}
+
+	return nil, err
+}
+
+// checkBanner matches the banner against the banners of known honeypots.
+func checkBanner(a *Auditor, t Target) ([]Finding, error) {
+	conn, err := a.dial(t)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	data, err := a.response(conn, t)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	banner := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+
+	findings := []Finding{}
+
+	for _, s := range signatures {
+		if !strings.Contains(banner, s.Banner) {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Check:       "banner",
+			Port:        t.Port,
+			Service:     t.Service,
+			Severity:    SeverityHigh,
+			Description: fmt.Sprintf("The banner %q is %s", banner, s.Description),
+			Remediation: fmt.Sprintf("Set banner in [service.%s] to the banner of a real server", t.Service),
+		})
+	}
+
+	if m := openSSHVersion.FindStringSubmatch(banner); m != nil {
+		if major, _ := strconv.Atoi(m[1]); major < 7 {
+			findings = append(findings, Finding{
+				Check:       "banner",
+				Port:        t.Port,
+				Service:     t.Service,
+				Severity:    SeverityLow,
+				Description: fmt.Sprintf("The banner %q is of an OpenSSH release older than 7.0, which is rarely exposed", banner),
+				Remediation: fmt.Sprintf("Set banner in [service.%s] to a current OpenSSH release", t.Service),
+			})
+		}
+	}
+
+	if !isHTTP(t) {
+		return findings, nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.EqualFold(strings.TrimSpace(line), "Server: Apache") {
+			findings = append(findings, Finding{
+				Check:       "banner",
+				Port:        t.Port,
+				Service:     t.Service,
+				Severity:    SeverityLow,
+				Description: "The Server header is the default of honeytrap, without a version",
+				Remediation: fmt.Sprintf("Set server in [service.%s], eg. Apache/2.4.29 (Ubuntu)", t.Service),
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// instantThreshold is the processing time below which responses are
+// considered instant.
+const instantThreshold = time.Millisecond
+
+// checkTiming measures the time the service takes to respond, after the
+// round trip of the connection. Services that respond instantly and
+// without variation are recognized as emulated.
+func checkTiming(a *Auditor, t Target) ([]Finding, error) {
+	samples := []float64{}
+
+	for i := 0; i < a.Samples; i++ {
+		start := time.Now()
+
+		conn, err := a.dial(t)
+		if err != nil {
+			return nil, err
+		}
+
+		connected := time.Now()
+
+		data, err := a.response(conn, t)
+		conn.Close()
+
+		if err != nil {
+			return nil, err
+		} else if len(data) == 0 {
+			// nothing to time
+			return nil, nil
+		}
+
+		// the time to respond, without the round trip
+		processing := time.Since(connected) - connected.Sub(start)
+		if processing < 0 {
+			processing = 0
+		}
+
+		samples = append(samples, float64(processing))
+	}
+
+	if len(samples) == 0 {
+		return nil, nil
+	}
+
+	mean := 0.0
+	for _, s := range samples {
+		mean += s
+	}
+
+	mean /= float64(len(samples))
+
+	variance := 0.0
+	for _, s := range samples {
+		variance += (s - mean) * (s - mean)
+	}
+
+	stddev := math.Sqrt(variance / float64(len(samples)))
+
+	if mean >= float64(instantThreshold) {
+		return nil, nil
+	}
+
+	severity := SeverityMedium
+	if t.Latency {
+		// latency has been configured, but isn't noticeable
+		severity = SeverityLow
+	}
+
+	return []Finding{{
+		Check:       "timing",
+		Port:        t.Port,
+		Service:     t.Service,
+		Severity:    severity,
+		Description: fmt.Sprintf("The service responds instantly (mean %s, deviation %s over %d connections)", time.Duration(mean), time.Duration(stddev), len(samples)),
+		Remediation: fmt.Sprintf("Configure latency in [service.%s.latency], eg. distribution = \"normal\", delay = \"20ms\", jitter = \"10ms\"", t.Service),
+	}}, nil
+}
+
+// checkCertificate looks for traits of generated certificates.
+func checkCertificate(a *Auditor, t Target) ([]Finding, error) {
+	_, port, _ := net.SplitHostPort(t.Address)
+	if !t.TLS && !tlsPorts[port] {
+		return nil, nil
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: a.Timeout}, "tcp", t.Address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		// not every service on a tls port speaks tls
+		if !t.TLS {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, nil
+	}
+
+	return certificateFindings(t, certs[0], time.Now()), nil
+}
+
+// certificateFindings returns the traits of the certificate that give a
+// generated certificate away.
+func certificateFindings(t Target, cert *x509.Certificate, now time.Time) []Finding {
+	findings := []Finding{}
+
+	add := func(severity, description, remediation string) {
+		findings = append(findings, Finding{
+			Check:       "certificate",
+			Port:        t.Port,
+			Service:     t.Service,
+			Severity:    severity,
+			Description: description,
+			Remediation: remediation,
+		})
+	}
+
+	if now.Sub(cert.NotBefore) < 24*time.Hour {
+		add(SeverityMedium,
+			fmt.Sprintf("The certificate is valid from %s, it was generated when the service started", cert.NotBefore.Format(time.RFC3339)),
+			fmt.Sprintf("Configure certificate and key in the tls section of port %s, or a certificate-profile that backdates the certificate", t.Port))
+	}
+
+	name := strings.ToLower(cert.Subject.CommonName)
+	if (name == "" && len(cert.DNSNames) == 0) || name == "localhost" || strings.Contains(name, "honeytrap") {
+		add(SeverityMedium,
+			fmt.Sprintf("The certificate is issued to %q, not to a host name", cert.Subject.CommonName),
+			fmt.Sprintf("Set common-name in the tls section of port %s", t.Port))
+	}
+
+	if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		add(SeverityLow,
+			"The certificate is self-signed",
+			fmt.Sprintf("Configure a certificate issued by a certificate authority with certificate and key in the tls section of port %s", t.Port))
+	}
+
+	if now.After(cert.NotAfter) {
+		add(SeverityLow,
+			fmt.Sprintf("The certificate expired at %s", cert.NotAfter.Format(time.RFC3339)),
+			fmt.Sprintf("Configure a valid certificate in the tls section of port %s", t.Port))
+	}
+
+	return findings
+}
+
+// randomString returns a random string, used as credentials no real server
+// would accept.
+func randomString() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkCredentials tries to log in with random credentials, honeypots
+// often accept any credentials.
+func checkCredentials(a *Auditor, t Target) ([]Finding, error) {
+	var accepted bool
+	var err error
+
+	switch {
+	case strings.HasPrefix(t.Type, "ssh"):
+		accepted, err = a.sshLogin(t, randomString(), randomString())
+	case strings.HasPrefix(t.Type, "ftp"):
+		accepted, err = a.ftpLogin(t, randomString(), randomString())
+	default:
+		return nil, nil
+	}
+
+	if err != nil || !accepted {
+		return nil, err
+	}
+
+	return []Finding{{
+		Check:       "credentials",
+		Port:        t.Port,
+		Service:     t.Service,
+		Severity:    SeverityHigh,
+		Description: "The service accepts random credentials",
+		Remediation: fmt.Sprintf("Set auth-policy = \"attempts\" or a list of credentials in [service.%s]", t.Service),
+	}}, nil
+}
+
+func (a *Auditor) sshLogin(t Target, username, password string) (bool, error) {
+	client, err := ssh.Dial("tcp", t.Address, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         a.Timeout,
+	})
+	if err == nil {
+		client.Close()
+		return true, nil
+	}
+
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (a *Auditor) ftpLogin(t Target, username, password string) (bool, error) {
+	conn, err := a.dial(t)
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(a.Timeout))
+
+	r := bufio.NewReader(conn)
+
+	// reply returns the code of the reply, multi line replies end with
+	// the line starting with the code and a space
+	reply := func() (string, error) {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return "", err
+			}
+
+			if len(line) >= 4 && line[3] == ' ' {
+				return line[:3], nil
+			}
+		}
+	}
+
+	if code, err := reply(); err != nil {
+		return false, err
+	} else if code != "220" {
+		return false, fmt.Errorf("unexpected ftp greeting %s", code)
+	}
+
+	fmt.Fprintf(conn, "USER %s\r\n", username)
+
+	code, err := reply()
+	if err != nil {
+		return false, err
+	} else if code == "230" {
+		return true, nil
+	} else if code != "331" {
+		return false, nil
+	}
+
+	fmt.Fprintf(conn, "PASS %s\r\n", password)
+
+	if code, err = reply(); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return code == "230", nil
+}
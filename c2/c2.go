@@ -0,0 +1,445 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package c2 keeps an inventory of the command and control infrastructure
+// found in the captured payloads and the commands of the attackers. The
+// liveness of the servers is checked periodically through the outbound
+// proxy, and changes are sent as events, which are exported by the threat
+// intel channels.
+package c2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:c2")
+
+func init() {
+	event.RegisterField(event.Field{Name: "c2.url", Type: event.TypeString, Description: "Url of the command and control server"})
+	event.RegisterField(event.Field{Name: "c2.host", Type: event.TypeString, Description: "Host of the command and control server"})
+	event.RegisterField(event.Field{Name: "c2.ip", Type: event.TypeString, Description: "Address of the command and control server, if the host is an address"})
+	event.RegisterField(event.Field{Name: "c2.status", Type: event.TypeString, Description: "Liveness of the command and control server: unknown, alive or dead"})
+	event.RegisterField(event.Field{Name: "c2.sightings", Type: event.TypeInteger, Description: "Number of times the server has been seen"})
+}
+
+var (
+	SensorC2 = event.Sensor("c2")
+
+	EventCategoryC2 = event.Category("c2")
+)
+
+// Liveness of the servers.
+const (
+	StatusUnknown = "unknown"
+	StatusAlive   = "alive"
+	StatusDead    = "dead"
+)
+
+// urlRegexp matches the download and c2 urls within the commands.
+var urlRegexp = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp)://[^\s'"<>\x60;|&]+`)
+
+// Server is a tracked command and control server.
+type Server struct {
+	URL  string `json:"url"`
+	Host string `json:"host"`
+	IP   string `json:"ip,omitempty"`
+
+	Sightings int      `json:"sightings"`
+	Sources   []string `json:"sources"`
+
+	FirstSeen time.Time `json:"first-seen"`
+	LastSeen  time.Time `json:"last-seen"`
+
+	Status      string    `json:"status"`
+	StatusCode  int       `json:"status-code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	LastChecked time.Time `json:"last-checked,omitempty"`
+}
+
+// Tracker tracks the command and control servers.
+type Tracker struct {
+	Enabled bool `toml:"enabled"`
+
+	// Interval is the interval the liveness is checked, and Timeout the
+	// timeout of a single check
+	Interval config.Delay `toml:"interval"`
+	Timeout  config.Delay `toml:"timeout"`
+
+	// Proxy is the outbound proxy the checks are done through, the
+	// servers are never contacted directly unless configured as "direct"
+	Proxy string `toml:"proxy"`
+
+	// MaxServers limits the size of the inventory
+	MaxServers int `toml:"max-servers"`
+
+	// MaxSources limits the number of sources kept per server
+	MaxSources int `toml:"max-sources"`
+
+	channel pushers.Channel
+	storage storage.Storage
+
+	client *http.Client
+
+	m       sync.RWMutex
+	servers map[string]*Server
+
+	now func() time.Time
+}
+
+// New returns a new Tracker.
+func New(options ...func(*Tracker) error) (*Tracker, error) {
+	t := &Tracker{
+		Interval:   config.Delay(time.Hour),
+		Timeout:    config.Delay(10 * time.Second),
+		MaxServers: 10000,
+		MaxSources: 16,
+		channel:    pushers.MustDummy(),
+		servers:    map[string]*Server{},
+		now:        time.Now,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(t); err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := proxy.New(t.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	t.client = &http.Client{
+		Timeout: t.Timeout.Duration(),
+		Transport: &http.Transport{
+			Proxy: p,
+		},
+		// a redirect is a response of the server
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	if t.storage != nil {
+		if err := t.load(); err != nil {
+			log.Errorf("Error loading c2 inventory: %s", err.Error())
+		}
+	}
+
+	return t, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the c2 configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Tracker) error {
+	return func(t *Tracker) error {
+		return decoder.PrimitiveDecode(c, t)
+	}
+}
+
+// WithChannel sets the channel the status events are sent to.
+func WithChannel(channel pushers.Channel) func(*Tracker) error {
+	return func(t *Tracker) error {
+		t.channel = channel
+		return nil
+	}
+}
+
+// WithStorage persists the inventory in the storage.
+func WithStorage(st storage.Storage) func(*Tracker) error {
+	return func(t *Tracker) error {
+		t.storage = st
+		return nil
+	}
+}
+
+const inventoryKey = "inventory"
+
+func (t *Tracker) load() error {
+	data, err := t.storage.Get(inventoryKey)
+	if err != nil || len(data) == 0 {
+		// nothing stored yet
+		return nil
+	}
+
+	servers := []*Server{}
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return err
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	for _, s := range servers {
+		t.servers[s.URL] = s
+	}
+
+	return nil
+}
+
+func (t *Tracker) save() error {
+	if t.storage == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(t.Servers())
+	if err != nil {
+		return err
+	}
+
+	return t.storage.Set(inventoryKey, data)
+}
+
+// urls returns the urls of the event, found by the payload analysis or in
+// the commands of the attacker.
+func urls(e event.Event) []string {
+	found := []string{}
+
+	e.Range(func(key, value interface{}) bool {
+		k, _ := key.(string)
+
+		switch {
+		case k == "analysis.urls":
+			if v, ok := value.([]string); ok {
+				found = append(found, v...)
+			}
+		case strings.HasSuffix(k, ".command"):
+			found = append(found, urlRegexp.FindAllString(fmt.Sprint(value), -1)...)
+		}
+
+		return true
+	})
+
+	return found
+}
+
+// Send adds the urls of the event to the inventory.
+func (t *Tracker) Send(e event.Event) {
+	if e.Get("category") == "c2" {
+		return
+	}
+
+	found := urls(e)
+	if len(found) == 0 {
+		return
+	}
+
+	source := e.Get("source-ip")
+	now := t.now()
+
+	added := []Server{}
+
+	t.m.Lock()
+
+	for _, raw := range found {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+
+		s, ok := t.servers[raw]
+		if !ok {
+			if len(t.servers) >= t.MaxServers {
+				continue
+			}
+
+			s = &Server{
+				URL:       raw,
+				Host:      u.Hostname(),
+				Sources:   []string{},
+				FirstSeen: now,
+				Status:    StatusUnknown,
+			}
+
+			if ip := net.ParseIP(s.Host); ip != nil {
+				s.IP = ip.String()
+			}
+
+			t.servers[raw] = s
+		}
+
+		s.Sightings++
+		s.LastSeen = now
+
+		if source != "" && len(s.Sources) < t.MaxSources && !contains(s.Sources, source) {
+			s.Sources = append(s.Sources, source)
+		}
+
+		if !ok {
+			added = append(added, *s)
+		}
+	}
+
+	t.m.Unlock()
+
+	for _, s := range added {
+		log.Infof("Tracking c2 server %s", s.URL)
+
+		options := []event.Option{event.Type("c2-discovered")}
+
+		// the attacker is related to the server by the threat intel channels
+		if ip := net.ParseIP(source); ip != nil {
+			options = append(options, event.SourceIP(ip))
+		}
+
+		t.send(s, options...)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Tracker) send(s Server, options ...event.Option) {
+	options = append([]event.Option{
+		SensorC2,
+		EventCategoryC2,
+		event.Custom("c2.url", s.URL),
+		event.Custom("c2.host", s.Host),
+		event.Custom("c2.status", s.Status),
+		event.Custom("c2.sightings", s.Sightings),
+	}, options...)
+
+	if s.IP != "" {
+		options = append(options, event.Custom("c2.ip", s.IP))
+	}
+
+	t.channel.Send(event.New(options...))
+}
+
+// check checks the liveness of the server, a server is alive when it
+// responds, whatever the status code.
+func (t *Tracker) check(rawurl string) (int, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	default:
+		// the proxy only forwards http
+		return 0, fmt.Errorf("Unsupported scheme %s", u.Scheme)
+	}
+
+	req, err := http.NewRequest("HEAD", rawurl, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Check checks the liveness of all servers, and sends an event for every
+// server that changed status.
+func (t *Tracker) Check() error {
+	for _, s := range t.Servers() {
+		if !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://") {
+			continue
+		}
+
+		code, err := t.check(s.URL)
+
+		status := StatusAlive
+		if err != nil {
+			status = StatusDead
+		}
+
+		t.m.Lock()
+
+		current, ok := t.servers[s.URL]
+		if !ok {
+			t.m.Unlock()
+			continue
+		}
+
+		changed := current.Status != status
+
+		current.Status = status
+		current.StatusCode = code
+		current.LastChecked = t.now()
+		current.Error = ""
+
+		if err != nil {
+			current.Error = err.Error()
+		}
+
+		updated := *current
+
+		t.m.Unlock()
+
+		if changed {
+			log.Infof("C2 server %s is %s", s.URL, status)
+
+			t.send(updated, event.Type("c2-"+status))
+		}
+	}
+
+	return t.save()
+}
+
+// Servers returns the tracked servers, most recently seen first.
+func (t *Tracker) Servers() []Server {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	servers := make([]Server, 0, len(t.servers))
+	for _, s := range t.servers {
+		c := *s
+		c.Sources = append([]string{}, s.Sources...)
+
+		servers = append(servers, c)
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		if !servers[i].LastSeen.Equal(servers[j].LastSeen) {
+			return servers[i].LastSeen.After(servers[j].LastSeen)
+		}
+
+		return servers[i].URL < servers[j].URL
+	})
+
+	return servers
+}
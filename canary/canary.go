@@ -0,0 +1,294 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package canary plants unique fake credentials in the decoy services, like
+// shell histories, ftp files and web application configs, and watches for
+// the credentials being replayed against the services.
+//
+// The canaries are derived from a secret, a sensor recognizes the canaries
+// of every sensor in the fleet that shares the secret.
+package canary
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:canary")
+
+func init() {
+	event.RegisterField(event.Field{Name: "severity", Type: event.TypeString, Description: "Severity of the event, eg. critical"})
+	event.RegisterField(event.Field{Name: "canary.id", Type: event.TypeString, Description: "Identifier of the canary credential"})
+	event.RegisterField(event.Field{Name: "canary.location", Type: event.TypeString, Description: "Location the canary was planted"})
+	event.RegisterField(event.Field{Name: "canary.username", Type: event.TypeString, Description: "Username of the canary credential"})
+	event.RegisterField(event.Field{Name: "canary.sensor", Type: event.TypeString, Description: "Token of the sensor that planted the canary"})
+}
+
+var (
+	SensorCanary = event.Sensor("canary")
+
+	EventCategoryCanary = event.Category("canary")
+)
+
+// usernames are the usernames of the canaries, picked by the id.
+var usernames = []string{"deploy", "backup", "dbadmin", "svc_app", "jenkins", "ansible", "monitor", "webadmin"}
+
+// Credential is a planted canary credential.
+type Credential struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Location string `json:"location"`
+}
+
+// Canaries plants and recognizes the canary credentials.
+type Canaries struct {
+	Enabled bool `toml:"enabled"`
+
+	// Secret is shared by the sensors of a fleet, it is generated and kept
+	// in the storage when not set.
+	Secret string `toml:"secret"`
+
+	key    []byte
+	sensor string
+
+	m       sync.Mutex
+	planted map[string]Credential
+
+	c pushers.Channel
+}
+
+// New returns new Canaries.
+func New(options ...func(*Canaries) error) (*Canaries, error) {
+	c := &Canaries{
+		planted: map[string]Credential{},
+		c:       pushers.MustDummy(),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Secret != "" {
+		c.key = []byte(c.Secret)
+	}
+
+	if c.key == nil {
+		c.key = make([]byte, 32)
+		if _, err := rand.Read(c.key); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the canary configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Canaries) error {
+	return func(cs *Canaries) error {
+		return decoder.PrimitiveDecode(c, cs)
+	}
+}
+
+// WithChannel sets the channel the events are sent to.
+func WithChannel(channel pushers.Channel) func(*Canaries) error {
+	return func(cs *Canaries) error {
+		cs.c = channel
+		return nil
+	}
+}
+
+// WithSensor sets the token of the sensor, the canaries of sensors differ.
+func WithSensor(token string) func(*Canaries) error {
+	return func(cs *Canaries) error {
+		cs.sensor = token
+		return nil
+	}
+}
+
+// WithStorage loads the secret from the storage, the secret will be
+// generated and persisted when it doesn't exist yet. The configured secret
+// takes precedence.
+func WithStorage(st storage.Storage) func(*Canaries) error {
+	return func(cs *Canaries) error {
+		if data, err := st.Get("secret"); err == nil && len(data) > 0 {
+			cs.key = data
+			return nil
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+
+		if err := st.Set("secret", key); err != nil {
+			return err
+		}
+
+		cs.key = key
+		return nil
+	}
+}
+
+func (cs *Canaries) mac(data []byte) []byte {
+	mac := hmac.New(sha256.New, cs.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Plant returns the canary for the location, the canary is the same for
+// the location of the sensor across restarts.
+func (cs *Canaries) Plant(location string) Credential {
+	id := cs.mac([]byte(cs.sensor + "/" + location))[:4]
+	tag := cs.mac(id)[:4]
+
+	c := Credential{
+		ID:       hex.EncodeToString(id),
+		Username: usernames[int(id[0])%len(usernames)],
+		Password: base64.RawURLEncoding.EncodeToString(append(append([]byte{}, id...), tag...)),
+		Location: location,
+	}
+
+	cs.m.Lock()
+	_, ok := cs.planted[c.ID]
+	cs.planted[c.ID] = c
+	cs.m.Unlock()
+
+	if !ok {
+		log.Infof("Planted canary %s at %s", c.ID, location)
+
+		cs.c.Send(event.New(
+			SensorCanary,
+			EventCategoryCanary,
+			event.Type("canary-planted"),
+			event.Custom("canary.id", c.ID),
+			event.Custom("canary.location", c.Location),
+			event.Custom("canary.username", c.Username),
+			event.Custom("canary.sensor", cs.sensor),
+		))
+	}
+
+	return c
+}
+
+// Verify returns the id of the canary if the password is a canary of the
+// fleet.
+func (cs *Canaries) Verify(password string) (string, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(password)
+	if err != nil || len(data) != 8 {
+		return "", false
+	}
+
+	if !hmac.Equal(cs.mac(data[:4])[:4], data[4:]) {
+		return "", false
+	}
+
+	return hex.EncodeToString(data[:4]), true
+}
+
+// Send watches the events for replayed canaries.
+func (cs *Canaries) Send(e event.Event) {
+	if e.Get("category") == "canary" {
+		return
+	}
+
+	passwords := []string{}
+
+	e.Range(func(k, v interface{}) bool {
+		if key := fmt.Sprint(k); strings.HasSuffix(key, ".password") {
+			passwords = append(passwords, fmt.Sprint(v))
+		}
+
+		return true
+	})
+
+	for _, password := range passwords {
+		id, ok := cs.Verify(password)
+		if !ok {
+			continue
+		}
+
+		options := []event.Option{
+			SensorCanary,
+			EventCategoryCanary,
+			event.Type("canary-replayed"),
+			event.Custom("severity", "critical"),
+			event.Custom("canary.id", id),
+			event.Service(e.Get("service")),
+			event.Custom("source-ip", e.Get("source-ip")),
+		}
+
+		cs.m.Lock()
+		c, planted := cs.planted[id]
+		cs.m.Unlock()
+
+		// canaries of other sensors are recognized, but their location is
+		// only known by the sensor that planted them
+		if planted {
+			options = append(options,
+				event.Custom("canary.location", c.Location),
+				event.Custom("canary.username", c.Username),
+				event.Custom("canary.sensor", cs.sensor),
+			)
+		}
+
+		log.Warningf("Canary %s replayed by %s", id, e.Get("source-ip"))
+
+		cs.c.Send(event.New(options...))
+	}
+}
+
+var (
+	m        sync.RWMutex
+	defaults *Canaries
+)
+
+// SetDefault sets the canaries the services plant.
+func SetDefault(cs *Canaries) {
+	m.Lock()
+	defer m.Unlock()
+
+	defaults = cs
+}
+
+// Plant plants a canary of the default canaries, false is returned when
+// canaries are disabled.
+func Plant(location string) (Credential, bool) {
+	m.RLock()
+	cs := defaults
+	m.RUnlock()
+
+	if cs == nil {
+		return Credential{}, false
+	}
+
+	return cs.Plant(location), true
+}
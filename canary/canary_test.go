@@ -0,0 +1,141 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package canary
+
+import (
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type collector []event.Event
+
+func (c *collector) Send(e event.Event) {
+	*c = append(*c, e)
+}
+
+func secret(s string) func(*Canaries) error {
+	return func(cs *Canaries) error {
+		cs.Secret = s
+		return nil
+	}
+}
+
+func TestPlant(t *testing.T) {
+	cs, err := New(secret("fleet"), WithSensor("sensor-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cs.Plant("ssh-simulator/.bash_history")
+	if other := cs.Plant("ssh-simulator/.bash_history"); other != c {
+		t.Errorf("Expected the same canary, got %#v and %#v", c, other)
+	}
+
+	if other := cs.Plant("ftp/config.bak"); other.Password == c.Password {
+		t.Errorf("Expected different canaries for different locations")
+	}
+
+	if id, ok := cs.Verify(c.Password); !ok || id != c.ID {
+		t.Errorf("Expected canary %s to verify, got %s %t", c.ID, id, ok)
+	}
+
+	for _, password := range []string{"", "123456", "AAAAAAAAAAA"} {
+		if _, ok := cs.Verify(password); ok {
+			t.Errorf("Expected password %q not to verify", password)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	c := &collector{}
+
+	cs, err := New(secret("fleet"), WithSensor("sensor-1"), WithChannel(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential := cs.Plant("http/.env")
+
+	*c = nil
+
+	cs.Send(event.New(
+		event.Service("ssh"),
+		event.Custom("source-ip", "1.2.3.4"),
+		event.Custom("ssh.username", credential.Username),
+		event.Custom("ssh.password", credential.Password),
+	))
+
+	if len(*c) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(*c))
+	}
+
+	e := (*c)[0]
+	if e.Get("type") != "canary-replayed" {
+		t.Errorf("Expected canary-replayed, got %s", e.Get("type"))
+	}
+
+	if e.Get("canary.location") != "http/.env" {
+		t.Errorf("Expected location http/.env, got %s", e.Get("canary.location"))
+	}
+
+	if e.Get("source-ip") != "1.2.3.4" {
+		t.Errorf("Expected source 1.2.3.4, got %s", e.Get("source-ip"))
+	}
+
+	// the replay itself mustn't trigger another replay
+	cs.Send(e)
+
+	if len(*c) != 1 {
+		t.Errorf("Expected 1 event, got %d", len(*c))
+	}
+}
+
+func TestFleet(t *testing.T) {
+	planter, err := New(secret("fleet"), WithSensor("sensor-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential := planter.Plant("ftp/config.bak")
+
+	c := &collector{}
+
+	cs, err := New(secret("fleet"), WithSensor("sensor-2"), WithChannel(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs.Send(event.New(
+		event.Service("ftp"),
+		event.Custom("ftp.password", credential.Password),
+	))
+
+	if len(*c) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(*c))
+	}
+
+	if id := (*c)[0].Get("canary.id"); id != credential.ID {
+		t.Errorf("Expected canary %s, got %s", credential.ID, id)
+	}
+
+	other, err := New(secret("other"), WithChannel(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := other.Verify(credential.Password); ok {
+		t.Errorf("Expected canary of another fleet not to verify")
+	}
+}
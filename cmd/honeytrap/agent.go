@@ -0,0 +1,146 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package honeytrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/listener/agent"
+	"github.com/honeytrap/honeytrap/storage"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// agentCommand packages agents for deployment.
+var agentCommand = cli.Command{
+	Name:  "agent",
+	Usage: "Manage honeytrap agents",
+	Subcommands: []cli.Command{
+		{
+			Name:  "package",
+			Usage: "Build a ready to deploy agent bundle",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{Name: "server", Usage: "Address of the agent listener, eg. `HOST:1339`, repeat for the servers to fail over to"},
+				cli.StringFlag{Name: "os", Value: "linux", Usage: "Target operating system"},
+				cli.StringFlag{Name: "arch", Value: "amd64", Usage: "Target architecture"},
+				cli.StringFlag{Name: "source", Usage: "Build the agent from the honeytrap-agent source in `DIR`"},
+				cli.StringFlag{Name: "binary", Usage: "Use the prebuilt agent `FILE` instead of building"},
+				cli.StringFlag{Name: "remote-key", Usage: "Public key of the agent listener, read from the data directory when not set"},
+				cli.StringFlag{Name: "token", Usage: "Token of the agent, generated when not set"},
+				cli.StringFlag{Name: "output, o", Value: ".", Usage: "Write the bundle to `DIR`"},
+			},
+			Action: packageAgent,
+		},
+	},
+}
+
+// remoteKey returns the public key of the agent listener from the storage
+// in the data directory. The storage can't be opened while the server is
+// running.
+func remoteKey(dataDir string) (string, error) {
+	if strings.HasPrefix(dataDir, "~") {
+		usr, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+
+		dataDir = filepath.Join(usr.HomeDir, dataDir[1:])
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", err
+	}
+
+	storage.SetDataDir(dataDir)
+
+	s, err := agent.Storage()
+	if err != nil {
+		return "", err
+	}
+
+	keyPair, err := s.KeyPair()
+	if err != nil {
+		return "", err
+	}
+
+	return keyPair.ExportPublicKey(), nil
+}
+
+func packageAgent(c *cli.Context) error {
+	b := &agent.Bundle{
+		GOOS:      c.String("os"),
+		GOARCH:    c.String("arch"),
+		Servers:   c.StringSlice("server"),
+		RemoteKey: c.String("remote-key"),
+		Token:     c.String("token"),
+		Binary:    c.String("binary"),
+	}
+
+	if len(b.Servers) == 0 {
+		return cli.NewExitError("The address of the agent listener (--server) is required", 1)
+	}
+
+	if b.Token == "" {
+		b.Token = agent.NewToken()
+	}
+
+	if b.RemoteKey == "" {
+		key, err := remoteKey(c.GlobalString("data"))
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Error reading agent key: %s", err.Error()), 1)
+		}
+
+		b.RemoteKey = key
+	}
+
+	if b.Binary == "" {
+		source := c.String("source")
+		if source == "" {
+			return cli.NewExitError("Either the agent source (--source) or binary (--binary) is required", 1)
+		}
+
+		dir, err := ioutil.TempDir("", "honeytrap-agent")
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		defer os.RemoveAll(dir)
+
+		if err := b.Build(source, dir); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	p := filepath.Join(c.String("output"), fmt.Sprintf("honeytrap-agent-%s-%s-%s.tar.gz", b.GOOS, b.GOARCH, b.Token[:8]))
+
+	f, err := os.Create(p)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	defer f.Close()
+
+	if err := b.Write(f); err != nil {
+		// no partial bundles are left behind
+		os.Remove(p)
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	fmt.Printf("Agent bundle written to %s (token %s)\n", p, b.Token)
+	return nil
+}
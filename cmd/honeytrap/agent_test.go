@@ -0,0 +1,85 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package honeytrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+func runAgent(t *testing.T, args ...string) error {
+	// exit errors are returned instead of exiting
+	exiter := cli.OsExiter
+	cli.OsExiter = func(int) {}
+	defer func() { cli.OsExiter = exiter }()
+
+	app := New()
+	app.ErrWriter = ioutil.Discard
+	app.Writer = ioutil.Discard
+
+	return app.Run(append([]string{"honeytrap", "agent", "package"}, args...))
+}
+
+func TestPackageAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "honeytrap-agent")
+	if err := ioutil.WriteFile(binary, []byte("agent"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runAgent(t, "--server", "10.0.0.1:1339", "--remote-key", "key", "--token", "0123456789abcdef", "--binary", binary, "-o", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "honeytrap-agent-linux-amd64-01234567.tar.gz")); err != nil {
+		t.Errorf("Expected bundle to be written: %s", err.Error())
+	}
+}
+
+func TestPackageAgentMissingConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--remote-key", "key", "--binary", "agent"}, "--server"},
+		{[]string{"--server", "10.0.0.1:1339", "--remote-key", "key"}, "--source"},
+		{[]string{"--server", "10.0.0.1:1339", "--remote-key", "key", "--binary", filepath.Join(dir, "missing"), "-o", dir}, "no such file"},
+	} {
+		if err := runAgent(t, test.args...); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected error about %s for %v, got %v", test.expected, test.args, err)
+		}
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.tar.gz")); len(files) != 0 {
+		t.Errorf("Expected no bundle to be left behind, got %v", files)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package honeytrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/honeytrap/honeytrap/audit"
+	"github.com/honeytrap/honeytrap/config"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// auditCommand connects to the configured services and reports how
+// detectable the deployment is.
+var auditCommand = cli.Command{
+	Name:  "audit",
+	Usage: "Audit how detectable the configured services are",
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "host", Value: "127.0.0.1", Usage: "Connect to the services on `HOST`, preferably from outside the sensor"},
+		cli.DurationFlag{Name: "timeout", Value: 3 * time.Second, Usage: "Time a service has to respond"},
+		cli.IntFlag{Name: "samples", Value: 5, Usage: "Number of connections the response time is measured of"},
+		cli.BoolFlag{Name: "json", Usage: "Print the report as json"},
+		cli.IntFlag{Name: "max-score", Value: 100, Usage: "Exit with an error when the score exceeds `SCORE`"},
+	},
+	Action: auditServices,
+}
+
+var severityColors = map[string]func(string, ...interface{}) string{
+	audit.SeverityHigh:   color.RedString,
+	audit.SeverityMedium: color.YellowString,
+	audit.SeverityLow:    color.CyanString,
+}
+
+func auditServices(c *cli.Context) error {
+	f, err := os.Open(c.GlobalString("config"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	defer f.Close()
+
+	conf := &config.Config{}
+	if err := conf.Load(f); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	a, err := audit.New(
+		audit.WithHost(c.String("host")),
+		audit.WithConfig(conf),
+	)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	a.Timeout = c.Duration("timeout")
+	a.Samples = c.Int("samples")
+
+	if len(a.Targets()) == 0 {
+		return cli.NewExitError("No tcp services configured", 1)
+	}
+
+	r := a.Run()
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+	} else {
+		printReport(r)
+	}
+
+	if r.Score > c.Int("max-score") {
+		return cli.NewExitError(fmt.Sprintf("Score %d exceeds %d", r.Score, c.Int("max-score")), 2)
+	}
+
+	return nil
+}
+
+func printReport(r *audit.Report) {
+	fmt.Printf("Audited %d services\n\n", len(r.Targets))
+
+	for _, f := range r.Findings {
+		fmt.Printf("[%s] %s %s (%s): %s\n", severityColors[f.Severity]("%-6s", f.Severity), f.Port, f.Service, f.Check, f.Description)
+		fmt.Printf("         %s\n\n", f.Remediation)
+	}
+
+	for _, e := range r.Errors {
+		fmt.Println(color.RedString("Error auditing %s", e))
+	}
+
+	fmt.Printf("\nDetectability score: %d/100 (lower is better)\n", r.Score)
+}
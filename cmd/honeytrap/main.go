@@ -14,6 +14,7 @@
 package honeytrap
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 
@@ -24,10 +25,13 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/honeytrap/honeytrap/cmd"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/listener"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/server"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/transcript"
 	cli "gopkg.in/urfave/cli.v1"
 
 	logging "github.com/op/go-logging"
@@ -206,6 +210,121 @@ func serve(c *cli.Context) error {
 	return nil
 }
 
+// secretCommand generates secret keys and encrypts configuration values.
+var secretCommand = cli.Command{
+	Name:  "secret",
+	Usage: "Manage encrypted configuration values",
+	Subcommands: []cli.Command{
+		{
+			Name:  "generate-key",
+			Usage: "Generate a new secret key",
+			Action: func(c *cli.Context) error {
+				key, err := config.GenerateSecretKey()
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+
+				fmt.Println(key)
+				return nil
+			},
+		},
+		{
+			Name:      "encrypt",
+			Usage:     "Encrypt a value with the key from " + config.SecretKeyEnv + " or " + config.SecretKeyFileEnv,
+			ArgsUsage: "[value]",
+			Action: func(c *cli.Context) error {
+				key, err := config.LoadSecretKey()
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+
+				// the value is read from stdin when not given, to keep it
+				// out of the shell history
+				value := c.Args().First()
+				if value == "" {
+					scanner := bufio.NewScanner(os.Stdin)
+					if scanner.Scan() {
+						value = scanner.Text()
+					}
+				}
+
+				s, err := config.EncryptSecret(key, value)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+
+				fmt.Printf("\"%s\"\n", s)
+				return nil
+			},
+		},
+	},
+}
+
+// pcapCommand converts recorded session transcripts into a pcap file.
+var pcapCommand = cli.Command{
+	Name:      "pcap",
+	Usage:     "Export session transcripts as pcap",
+	ArgsUsage: "transcript.json [transcript.json ...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Value: "-",
+			Usage: "Write the pcap to `FILE`",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return cli.NewExitError("No transcripts given", 1)
+		}
+
+		transcripts := []*transcript.Transcript{}
+
+		for _, p := range c.Args() {
+			t, err := transcript.ReadFile(p)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Error reading transcript %s: %s", p, err.Error()), 1)
+			}
+
+			transcripts = append(transcripts, t)
+		}
+
+		w := os.Stdout
+
+		if o := c.String("output"); o != "-" {
+			f, err := os.Create(o)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+
+			defer f.Close()
+
+			w = f
+		}
+
+		if err := transcript.WritePCAP(w, transcripts...); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		return nil
+	},
+}
+
+// schemaCommand prints the JSON Schema of the events, the fields are
+// registered by the packages that add them.
+var schemaCommand = cli.Command{
+	Name:  "schema",
+	Usage: "Print the JSON Schema of the events",
+	Action: func(c *cli.Context) error {
+		data, err := event.JSONSchema()
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		fmt.Println(string(data))
+		return nil
+	},
+}
+
 func New() *cli.App {
 	cli.VersionPrinter = func(c *cli.Context) {
 		fmt.Fprintf(c.App.Writer,
@@ -222,7 +341,13 @@ Commit-ID: %s
 	app.Flags = globalFlags
 	app.Description = `honeytrap: The honeypot server.`
 	app.CustomAppHelpTemplate = helpTemplate
-	app.Commands = []cli.Command{}
+	app.Commands = []cli.Command{
+		secretCommand,
+		pcapCommand,
+		agentCommand,
+		schemaCommand,
+		auditCommand,
+	}
 	app.Before = func(c *cli.Context) error {
 		return nil
 	}
@@ -17,6 +17,7 @@ import (
 	"regexp"
 
 	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/BurntSushi/toml"
@@ -36,7 +37,8 @@ var format = logging.MustStringFormatter(
 type Config struct {
 	toml.MetaData
 
-	Listener toml.Primitive `toml:"listener"`
+	Listener  toml.Primitive            `toml:"listener"`
+	Listeners map[string]toml.Primitive `toml:"listeners"`
 
 	Web toml.Primitive `toml:"web"`
 
@@ -47,6 +49,52 @@ type Config struct {
 
 	Filters []toml.Primitive `toml:"filter"`
 
+	Reports map[string]toml.Primitive `toml:"report"`
+
+	Signatures toml.Primitive `toml:"signatures"`
+
+	Signing toml.Primitive `toml:"signing"`
+
+	Privacy toml.Primitive `toml:"privacy"`
+
+	Retention toml.Primitive `toml:"retention"`
+
+	Credentials toml.Primitive `toml:"credentials"`
+
+	Scheduler toml.Primitive `toml:"scheduler"`
+
+	Proxy toml.Primitive `toml:"proxy"`
+
+	Transcripts toml.Primitive `toml:"transcripts"`
+
+	Personality toml.Primitive `toml:"personality"`
+
+	Rotation toml.Primitive `toml:"rotation"`
+
+	Canaries toml.Primitive `toml:"canaries"`
+
+	Content toml.Primitive `toml:"content"`
+
+	Analysis toml.Primitive `toml:"analysis"`
+
+	Correlation toml.Primitive `toml:"correlation"`
+
+	Fingerprint toml.Primitive `toml:"fingerprint"`
+
+	Trends toml.Primitive `toml:"trends"`
+
+	Tracing toml.Primitive `toml:"tracing"`
+
+	C2 toml.Primitive `toml:"c2"`
+
+	Severity toml.Primitive `toml:"severity"`
+
+	Metrics toml.Primitive `toml:"metrics"`
+
+	Governor toml.Primitive `toml:"governor"`
+
+	Policies []toml.Primitive `toml:"policy"`
+
 	Logging []struct {
 		Output string `toml:"output"`
 		Level  string `toml:"level"`
@@ -56,9 +104,20 @@ type Config struct {
 // DefaultConfig defines the default Config to be used to set default values.
 var Default = Config{}
 
-// Load attempts to load the giving toml configuration file.
+// Load attempts to load the giving toml configuration file, encrypted values
+// are decrypted with the secret key.
 func (c *Config) Load(r io.Reader) error {
-	md, err := toml.DecodeReader(r, c)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s, err := decryptSecrets(string(data))
+	if err != nil {
+		return err
+	}
+
+	md, err := toml.Decode(s, c)
 	if err != nil {
 		return err
 	}
@@ -13,7 +13,11 @@
 // limitations under the License.
 package config
 
-import "time"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Delay defines a duration type.
 type Delay time.Duration
@@ -23,10 +27,18 @@ func (t *Delay) Duration() time.Duration {
 	return time.Duration(*t)
 }
 
-// UnmarshalText handles unmarshalling duration values from the provided slice.
+// UnmarshalText handles unmarshalling duration values from the provided slice,
+// besides the time.ParseDuration units a number of days (eg. 90d) is accepted.
 func (t *Delay) UnmarshalText(text []byte) error {
 	s := string(text)
 
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			*t = Delay(time.Duration(days) * 24 * time.Hour)
+			return nil
+		}
+	}
+
 	d, err := time.ParseDuration(s)
 	if err != nil {
 		log.Errorf("Error parsing duration (%s): %s", s, err.Error())
@@ -0,0 +1,253 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Encrypted values are stored in the configuration as sops style strings,
+// eg. token = "ENC[AES256_GCM,data:...,iv:...,tag:...,type:str]". The values
+// are decrypted with the key from the environment before the configuration
+// is decoded.
+const (
+	SecretKeyEnv     = "HONEYTRAP_SECRET_KEY"
+	SecretKeyFileEnv = "HONEYTRAP_SECRET_KEY_FILE"
+)
+
+var (
+	ErrNoSecretKey      = fmt.Errorf("Configuration contains encrypted values, but %s or %s is not set", SecretKeyEnv, SecretKeyFileEnv)
+	ErrInvalidSecretKey = errors.New("Secret key should be 32 bytes, base64 encoded")
+	ErrInvalidSecret    = errors.New("Invalid encrypted value")
+)
+
+var encryptedValue = regexp.MustCompile(`"ENC\[[^\]"]*\]"`)
+
+// GenerateSecretKey returns a new base64 encoded key.
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func parseSecretKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidSecretKey
+	}
+
+	return key, nil
+}
+
+// LoadSecretKey returns the key from the HONEYTRAP_SECRET_KEY environment
+// variable, or from the file in HONEYTRAP_SECRET_KEY_FILE. It returns
+// ErrNoSecretKey if neither is set.
+func LoadSecretKey() ([]byte, error) {
+	if s := os.Getenv(SecretKeyEnv); s != "" {
+		return parseSecretKey(s)
+	}
+
+	if p := os.Getenv(SecretKeyFileEnv); p != "" {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		return parseSecretKey(string(data))
+	}
+
+	return nil, ErrNoSecretKey
+}
+
+// EncryptSecret encrypts the value with the key, the result can be used as
+// value in the configuration.
+func EncryptSecret(key []byte, value string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nil, iv, []byte(value), nil)
+	data, tag := sealed[:len(value)], sealed[len(value):]
+
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+	), nil
+}
+
+// DecryptSecret decrypts a value encrypted by EncryptSecret.
+func DecryptSecret(key []byte, s string) (string, error) {
+	if !strings.HasPrefix(s, "ENC[AES256_GCM,") || !strings.HasSuffix(s, "]") {
+		return "", ErrInvalidSecret
+	}
+
+	fields := map[string][]byte{}
+
+	for _, part := range strings.Split(s[len("ENC[AES256_GCM,"):len(s)-1], ",") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 || kv[0] == "type" {
+			continue
+		}
+
+		v, err := base64.StdEncoding.DecodeString(kv[1])
+		if err != nil {
+			return "", ErrInvalidSecret
+		}
+
+		fields[kv[0]] = v
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	if len(fields["iv"]) != aead.NonceSize() {
+		return "", ErrInvalidSecret
+	}
+
+	value, err := aead.Open(nil, fields["iv"], append(fields["data"], fields["tag"]...), nil)
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+
+	return string(value), nil
+}
+
+// quote returns the value as toml basic string.
+func quote(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// uncommented returns the part of the line before its comment, a # inside
+// a basic or literal string doesn't start a comment.
+func uncommented(line string) string {
+	var quoted byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case quoted == 0 && c == '#':
+			return line[:i]
+		case quoted == 0 && (c == '"' || c == '\''):
+			quoted = c
+		case quoted == '"' && c == '\\':
+			i++
+		case c == quoted:
+			quoted = 0
+		}
+	}
+
+	return line
+}
+
+// decryptSecrets replaces the encrypted values in the configuration with
+// their decrypted values. Encrypted values in comments are left untouched,
+// the key is only loaded if the configuration contains encrypted values.
+func decryptSecrets(data string) (string, error) {
+	lines := strings.Split(data, "\n")
+
+	var key []byte
+
+	for i, line := range lines {
+		code := uncommented(line)
+		comment := line[len(code):]
+
+		if !encryptedValue.MatchString(code) {
+			continue
+		}
+
+		if key == nil {
+			var err error
+			if key, err = LoadSecretKey(); err != nil {
+				return "", err
+			}
+		}
+
+		var decryptErr error
+
+		code = encryptedValue.ReplaceAllStringFunc(code, func(s string) string {
+			value, err := DecryptSecret(key, s[1:len(s)-1])
+			if err != nil {
+				decryptErr = err
+				return s
+			}
+
+			return quote(value)
+		})
+
+		if decryptErr != nil {
+			return "", decryptErr
+		}
+
+		lines[i] = code + comment
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
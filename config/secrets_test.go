@@ -0,0 +1,149 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEncryptSecret(t *testing.T) {
+	s, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := parseSecretKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := "token with \"quotes\"\n"
+
+	encrypted, err := EncryptSecret(key, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(encrypted, "token") {
+		t.Fatalf("Value not encrypted: %s", encrypted)
+	}
+
+	decrypted, err := DecryptSecret(key, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted != value {
+		t.Errorf("Expected %q, got %q", value, decrypted)
+	}
+
+	other, _ := GenerateSecretKey()
+	otherKey, _ := parseSecretKey(other)
+
+	if _, err := DecryptSecret(otherKey, encrypted); err != ErrInvalidSecret {
+		t.Errorf("Expected ErrInvalidSecret with wrong key, got %v", err)
+	}
+}
+
+func TestLoadEncrypted(t *testing.T) {
+	s, _ := GenerateSecretKey()
+	key, _ := parseSecretKey(s)
+
+	encrypted, err := EncryptSecret(key, `s3cr\et"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := `
+[channel.slack]
+type="slack"
+token="` + encrypted + `"
+`
+
+	os.Unsetenv(SecretKeyEnv)
+	os.Unsetenv(SecretKeyFileEnv)
+
+	c := Config{}
+	if err := c.Load(strings.NewReader(data)); err != ErrNoSecretKey {
+		t.Fatalf("Expected ErrNoSecretKey, got %v", err)
+	}
+
+	os.Setenv(SecretKeyEnv, s)
+	defer os.Unsetenv(SecretKeyEnv)
+
+	c = Config{}
+	if err := c.Load(strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	x := struct {
+		Token string `toml:"token"`
+	}{}
+
+	if err := c.PrimitiveDecode(c.Channels["slack"], &x); err != nil {
+		t.Fatal(err)
+	}
+
+	if x.Token != `s3cr\et"` {
+		t.Errorf("Expected decrypted token, got %q", x.Token)
+	}
+}
+
+func TestLoadEncryptedComment(t *testing.T) {
+	s, _ := GenerateSecretKey()
+	key, _ := parseSecretKey(s)
+
+	encrypted, err := EncryptSecret(key, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := `
+[channel.slack]
+type="slack"
+# token="ENC[AES256_GCM,data:invalid]"
+token="#plain" # was "` + encrypted + `"
+`
+
+	os.Unsetenv(SecretKeyEnv)
+	os.Unsetenv(SecretKeyFileEnv)
+
+	s, err = decryptSecrets(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s != data {
+		t.Errorf("Expected comments to be untouched, got %q", s)
+	}
+
+	c := Config{}
+	if err := c.Load(strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	x := struct {
+		Token string `toml:"token"`
+	}{}
+
+	if err := c.PrimitiveDecode(c.Channels["slack"], &x); err != nil {
+		t.Fatal(err)
+	}
+
+	if x.Token != "#plain" {
+		t.Errorf("Expected plain token, got %q", x.Token)
+	}
+}
@@ -0,0 +1,514 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package content manages the content packs, versioned archives with the
+// decoy content of the services: web pages, file trees, banner sets and
+// credential dictionaries. The packs are kept in the data directory and are
+// swapped at runtime when the archives change, so the content of a fleet
+// can be updated without redeploying.
+//
+// A content pack is a tar.gz or zip archive with the layout:
+//
+//	pack.toml                 name, version, description and banner sets
+//	www/...                   web pages, served by the http services
+//	files/...                 file trees, presented by the sftp subsystem
+//	credentials/<name>.txt    credential dictionaries, username:password
+//	                          per line
+//
+// Only the highest version of a pack is active. Files of packs are looked
+// up in the order of the pack names.
+package content
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:content")
+
+// File is a file of a content pack.
+type File struct {
+	Data    []byte
+	ModTime time.Time
+}
+
+// Pack is a loaded content pack.
+type Pack struct {
+	Name        string              `toml:"name" json:"name"`
+	Version     string              `toml:"version" json:"version"`
+	Description string              `toml:"description" json:"description,omitempty"`
+	Banners     map[string][]string `toml:"banners" json:"banners,omitempty"`
+
+	// Archive is the name of the archive the pack was loaded from
+	Archive string `toml:"-" json:"archive"`
+
+	files       map[string]*File
+	credentials map[string][]string
+}
+
+// errTooLarge is returned for archives exceeding the maximum size.
+var errTooLarge = errors.New("Content pack exceeds the maximum size")
+
+// cleanPath returns the path of the archive entry, relative paths escaping
+// the archive are rejected.
+func cleanPath(name string) (string, error) {
+	name = strings.Replace(name, "\\", "/", -1)
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("Invalid path %s in content pack", name)
+		}
+	}
+
+	return strings.TrimPrefix(path.Clean("/"+name), "/"), nil
+}
+
+// add adds the entry of the archive to the pack.
+func (p *Pack) add(name string, data []byte, modTime time.Time) error {
+	name, err := cleanPath(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case name == "pack.toml":
+		if _, err := toml.Decode(string(data), p); err != nil {
+			return fmt.Errorf("Error parsing pack.toml: %s", err.Error())
+		}
+	case strings.HasPrefix(name, "credentials/") && path.Ext(name) == ".txt":
+		dictionary := strings.TrimSuffix(path.Base(name), ".txt")
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			if !strings.Contains(line, ":") {
+				return fmt.Errorf("Invalid credential %q in %s, expected username:password", line, name)
+			}
+
+			p.credentials[dictionary] = append(p.credentials[dictionary], line)
+		}
+	default:
+		p.files[name] = &File{Data: data, ModTime: modTime}
+	}
+
+	return nil
+}
+
+// readLimited reads the entry, up to the remaining size of the pack.
+func readLimited(r io.Reader, remaining *int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, *remaining+1))
+	if err != nil {
+		return nil, err
+	}
+
+	*remaining -= int64(len(data))
+	if *remaining < 0 {
+		return nil, errTooLarge
+	}
+
+	return data, nil
+}
+
+// Open loads the content pack from the tar.gz or zip archive, the
+// uncompressed size of the pack is limited to maxSize.
+func Open(name string, maxSize int64) (*Pack, error) {
+	p := &Pack{
+		Archive:     filepath.Base(name),
+		files:       map[string]*File{},
+		credentials: map[string][]string{},
+	}
+
+	remaining := maxSize
+
+	if strings.HasSuffix(name, ".zip") {
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, err
+		}
+
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := readLimited(rc, &remaining)
+			rc.Close()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.add(f.Name, data, f.Modified); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+
+		tr := tar.NewReader(gr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+				continue
+			}
+
+			data, err := readLimited(tr, &remaining)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.add(hdr.Name, data, hdr.ModTime); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.Name == "" || p.Version == "" {
+		return nil, fmt.Errorf("Content pack %s: name and version should be set in pack.toml", p.Archive)
+	}
+
+	return p, nil
+}
+
+// compareVersions compares the dot separated versions numerically, parts
+// that aren't numbers are compared as strings.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(strings.TrimPrefix(a, "v"), "."), strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := "0", "0"
+		if i < len(as) {
+			x = as[i]
+		}
+
+		if i < len(bs) {
+			y = bs[i]
+		}
+
+		xi, xerr := strconv.Atoi(x)
+		yi, yerr := strconv.Atoi(y)
+
+		if xerr == nil && yerr == nil {
+			if xi != yi {
+				if xi < yi {
+					return -1
+				}
+
+				return 1
+			}
+		} else if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// Manager loads the content packs from the directory, and reloads them when
+// the archives change.
+type Manager struct {
+	Directory      string       `toml:"directory"`
+	ReloadInterval config.Delay `toml:"reload-interval"`
+
+	// MaxSize is the maximum uncompressed size of a pack, in bytes
+	MaxSize int64 `toml:"max-size"`
+
+	m     sync.RWMutex
+	packs []*Pack
+
+	// modification state of the archives of the last load
+	state string
+}
+
+// New returns a new Manager.
+func New(options ...func(*Manager) error) (*Manager, error) {
+	m := &Manager{
+		Directory:      "content",
+		ReloadInterval: config.Delay(time.Minute),
+		MaxSize:        64 * 1024 * 1024,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the content configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Manager) error {
+	return func(m *Manager) error {
+		return decoder.PrimitiveDecode(c, m)
+	}
+}
+
+// WithDataDir resolves the content directory relative to the data directory.
+func WithDataDir(dataDir string) func(*Manager) error {
+	return func(m *Manager) error {
+		if !filepath.IsAbs(m.Directory) {
+			m.Directory = filepath.Join(dataDir, m.Directory)
+		}
+
+		return nil
+	}
+}
+
+// archives returns the content pack archives of the directory.
+func (m *Manager) archives() []string {
+	names := []string{}
+
+	for _, pattern := range []string{"*.tar.gz", "*.tgz", "*.zip"} {
+		files, _ := filepath.Glob(filepath.Join(m.Directory, pattern))
+		names = append(names, files...)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// modState returns a description of the names, sizes and modification
+// times of the archives, to detect changes.
+func (m *Manager) modState() string {
+	parts := []string{}
+
+	for _, name := range m.archives() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", name, fi.Size(), fi.ModTime().UnixNano()))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// Reload loads the content packs if the archives have been changed. The
+// active packs are swapped at once, packs that fail to load are skipped
+// and the previous version of the pack stays active.
+func (m *Manager) Reload() error {
+	state := m.modState()
+
+	m.m.RLock()
+	changed := state != m.state
+	previous := m.packs
+	m.m.RUnlock()
+
+	if !changed {
+		return nil
+	}
+
+	latest := map[string]*Pack{}
+
+	var lastErr error
+
+	for _, name := range m.archives() {
+		p, err := Open(name, m.MaxSize)
+		if err != nil {
+			log.Errorf("Error loading content pack %s: %s", name, err.Error())
+			lastErr = err
+
+			// keep the pack that was loaded from this archive before
+			for _, prev := range previous {
+				if prev.Archive == filepath.Base(name) {
+					p = prev
+				}
+			}
+
+			if p == nil {
+				continue
+			}
+		}
+
+		if current, ok := latest[p.Name]; ok && compareVersions(current.Version, p.Version) >= 0 {
+			continue
+		}
+
+		latest[p.Name] = p
+	}
+
+	packs := []*Pack{}
+	for _, p := range latest {
+		packs = append(packs, p)
+	}
+
+	sort.Slice(packs, func(i, j int) bool {
+		return packs[i].Name < packs[j].Name
+	})
+
+	m.m.Lock()
+	m.packs = packs
+	m.state = state
+	m.m.Unlock()
+
+	for _, p := range packs {
+		log.Infof("Content pack %s version %s active (%d files)", p.Name, p.Version, len(p.files))
+	}
+
+	return lastErr
+}
+
+// Packs returns the active packs.
+func (m *Manager) Packs() []*Pack {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	return m.packs
+}
+
+// File returns the file of the first pack that contains the file.
+func (m *Manager) File(name string) (*File, bool) {
+	name, err := cleanPath(name)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, p := range m.Packs() {
+		if f, ok := p.files[name]; ok {
+			return f, true
+		}
+	}
+
+	return nil, false
+}
+
+// Walk calls fn for the files of the packs in the directory, with their
+// path relative to the directory. Files of earlier packs take precedence.
+func (m *Manager) Walk(dir string, fn func(name string, f *File)) {
+	dir = strings.Trim(dir, "/") + "/"
+
+	seen := map[string]bool{}
+
+	for _, p := range m.Packs() {
+		names := []string{}
+		for name := range p.files {
+			if strings.HasPrefix(name, dir) && !seen[name] {
+				names = append(names, name)
+			}
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			seen[name] = true
+			fn(strings.TrimPrefix(name, dir), p.files[name])
+		}
+	}
+}
+
+// Banner returns a banner of the banner set of the service, the key selects
+// the banner of the set, so the same key gets the same banner.
+func (m *Manager) Banner(service, key string) (string, bool) {
+	for _, p := range m.Packs() {
+		banners := p.Banners[service]
+		if len(banners) == 0 {
+			continue
+		}
+
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return banners[int(h.Sum32()%uint32(len(banners)))], true
+	}
+
+	return "", false
+}
+
+// Credentials returns the credentials of the dictionary of all packs.
+func (m *Manager) Credentials(dictionary string) []string {
+	credentials := []string{}
+
+	for _, p := range m.Packs() {
+		credentials = append(credentials, p.credentials[dictionary]...)
+	}
+
+	return credentials
+}
+
+var (
+	dm       sync.RWMutex
+	defaults *Manager
+)
+
+// SetDefault sets the manager of the content the services use.
+func SetDefault(m *Manager) {
+	dm.Lock()
+	defer dm.Unlock()
+
+	defaults = m
+}
+
+// Default returns the manager of the content the services use, the manager
+// has no packs when content packs are disabled.
+func Default() *Manager {
+	dm.RLock()
+	defer dm.RUnlock()
+
+	if defaults == nil {
+		return &Manager{}
+	}
+
+	return defaults
+}
@@ -0,0 +1,255 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package content
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var packDate = time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func writeTarGz(t *testing.T, name string, files map[string]string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	for name, data := range files {
+		tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  packDate,
+			Typeflag: tar.TypeReg,
+		})
+
+		tw.Write([]byte(data))
+	}
+
+	tw.Close()
+	gw.Close()
+}
+
+func writeZip(t *testing.T, name string, files map[string]string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Write([]byte(data))
+	}
+
+	zw.Close()
+}
+
+func newManager(t *testing.T) (*Manager, string) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(WithDataDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(m.Directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return m, dir
+}
+
+func TestReload(t *testing.T) {
+	m, dir := newManager(t)
+	defer os.RemoveAll(dir)
+
+	writeTarGz(t, filepath.Join(m.Directory, "wordpress-1.9.tar.gz"), map[string]string{
+		"pack.toml":      "name = \"wordpress\"\nversion = \"1.9\"\n",
+		"www/index.html": "old",
+	})
+
+	writeTarGz(t, filepath.Join(m.Directory, "wordpress-1.10.tar.gz"), map[string]string{
+		"pack.toml": `
+name = "wordpress"
+version = "1.10"
+
+[banners]
+ssh = ["SSH-2.0-OpenSSH_7.4p1 Debian-10+deb9u6", "SSH-2.0-OpenSSH_7.6p1 Ubuntu-4ubuntu0.3"]
+`,
+		"www/index.html":         "<html>wordpress</html>",
+		"www/wp-login.php":       "login",
+		"files/var/www/wp.conf":  "define('DB_PASSWORD', 'x');",
+		"credentials/common.txt": "# common\nadmin:admin\n\nroot:toor\n",
+	})
+
+	writeZip(t, filepath.Join(m.Directory, "backups-1.0.zip"), map[string]string{
+		"pack.toml":            "name = \"backups\"\nversion = \"1.0\"\n",
+		"www/index.html":       "backups",
+		"files/tmp/backup.sql": "-- dump",
+	})
+
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if packs := m.Packs(); len(packs) != 2 || packs[0].Name != "backups" || packs[1].Version != "1.10" {
+		t.Fatalf("Expected backups and wordpress 1.10 active, got %v", packs)
+	}
+
+	if f, ok := m.File("/www/index.html"); !ok || string(f.Data) != "backups" {
+		t.Errorf("Expected the page of the first pack")
+	}
+
+	if f, ok := m.File("www/wp-login.php"); !ok || string(f.Data) != "login" || !f.ModTime.Equal(packDate) {
+		t.Errorf("Expected the page of the wordpress pack")
+	}
+
+	names := []string{}
+	m.Walk("files", func(name string, f *File) {
+		names = append(names, name)
+	})
+
+	if s := strings.Join(names, ","); s != "tmp/backup.sql,var/www/wp.conf" {
+		t.Errorf("Expected the file trees of the packs, got %s", s)
+	}
+
+	if s := strings.Join(m.Credentials("common"), ","); s != "admin:admin,root:toor" {
+		t.Errorf("Expected the credentials of the dictionary, got %s", s)
+	}
+
+	b1, ok := m.Banner("ssh", "192.0.2.1:22")
+	if !ok || !strings.HasPrefix(b1, "SSH-2.0-OpenSSH_7") {
+		t.Errorf("Expected a banner of the set, got %s", b1)
+	}
+
+	if b2, _ := m.Banner("ssh", "192.0.2.1:22"); b2 != b1 {
+		t.Errorf("Expected the same banner for the same key")
+	}
+
+	if _, ok := m.Banner("ftp", ""); ok {
+		t.Errorf("Expected no banner for unknown sets")
+	}
+
+	// a newer version is swapped in
+	writeTarGz(t, filepath.Join(m.Directory, "wordpress-2.0.tar.gz"), map[string]string{
+		"pack.toml":        "name = \"wordpress\"\nversion = \"2.0\"\n",
+		"www/wp-login.php": "login 2",
+	})
+
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, ok := m.File("www/wp-login.php"); !ok || string(f.Data) != "login 2" {
+		t.Errorf("Expected the page of the new version")
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	m, dir := newManager(t)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(m.Directory, "site.tar.gz")
+
+	writeTarGz(t, name, map[string]string{
+		"pack.toml":      "name = \"site\"\nversion = \"1\"\n",
+		"www/index.html": "site",
+	})
+
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the archive is replaced with a broken one
+	ioutil.WriteFile(name, []byte("broken"), 0644)
+	os.Chtimes(name, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+
+	if err := m.Reload(); err == nil {
+		t.Errorf("Expected error loading the broken archive")
+	}
+
+	if f, ok := m.File("www/index.html"); !ok || string(f.Data) != "site" {
+		t.Errorf("Expected the previous pack to stay active")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		files map[string]string
+		err   string
+	}{
+		{map[string]string{"pack.toml": "name = \"x\"\nversion = \"1\"\n", "../../etc/passwd": "x"}, "Invalid path"},
+		{map[string]string{"www/index.html": "x"}, "name and version should be set"},
+		{map[string]string{"pack.toml": "name = \"x\"\nversion = \"1\"\n", "credentials/x.txt": "admin"}, "expected username:password"},
+		{map[string]string{"pack.toml": "name = \"x\"\nversion = \"1\"\n", "www/large": strings.Repeat("x", 2048)}, "maximum size"},
+	}
+
+	for i, tc := range tests {
+		name := filepath.Join(dir, "pack.tar.gz")
+		writeTarGz(t, name, tc.files)
+
+		if _, err := Open(name, 1024); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%d: expected error %q, got %v", i, tc.err, err)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.10", "1.9", 1},
+		{"v2.0", "2", 0},
+		{"1.0.1", "1.0", 1},
+		{"1.0-beta", "1.0-alpha", 1},
+	}
+
+	for _, tc := range tests {
+		if c := compareVersions(tc.a, tc.b); c != tc.expected {
+			t.Errorf("compareVersions(%s, %s): expected %d, got %d", tc.a, tc.b, tc.expected, c)
+		}
+	}
+}
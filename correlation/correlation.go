@@ -0,0 +1,233 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package correlation stamps the events with the identifiers that link them
+// across the web interface, the storage and the channels: the id of the
+// event, the session and transcript of the connection it was sent for, the
+// attack of the source and the hash of the payload. An alert in a channel
+// can be traced to the transcript and the stored payload with these ids.
+package correlation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func init() {
+	event.RegisterField(event.Field{Name: "id", Type: event.TypeString, Description: "Unique identifier of the event, a ULID"})
+	event.RegisterField(event.Field{Name: "session.id", Type: event.TypeString, Description: "Identifier of the connection the event was sent for"})
+	event.RegisterField(event.Field{Name: "attack.id", Type: event.TypeString, Description: "Identifier of the attack, the activity of the source without pause longer than the attack window"})
+	event.RegisterField(event.Field{Name: "payload.sha256", Type: event.TypeString, Description: "SHA256 of the payload, the payload is stored under the hash"})
+}
+
+// Correlator stamps the ids on the events it receives. The correlator
+// should be subscribed to the bus first, so the ids are available to all
+// subscribers.
+type Correlator struct {
+	// AttackWindow is the time without events of a source after which its
+	// next event starts a new attack
+	AttackWindow config.Delay `toml:"attack-window"`
+
+	m sync.Mutex
+
+	sessions map[string]session
+	attacks  map[string]*attack
+
+	expired time.Time
+
+	now func() time.Time
+}
+
+type session struct {
+	id         string
+	transcript string
+
+	// options are the fields of the connection, eg. the operating system
+	// of the attacker
+	options []event.Option
+}
+
+type attack struct {
+	id       string
+	lastSeen time.Time
+}
+
+// New returns a new Correlator.
+func New(options ...func(*Correlator) error) (*Correlator, error) {
+	c := &Correlator{
+		AttackWindow: config.Delay(30 * time.Minute),
+
+		sessions: map[string]session{},
+		attacks:  map[string]*attack{},
+
+		now: time.Now,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the correlation configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Correlator) error {
+	return func(cr *Correlator) error {
+		return decoder.PrimitiveDecode(c, cr)
+	}
+}
+
+func addr(a net.Addr) (string, int) {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		return v.IP.String(), v.Port
+	case *net.UDPAddr:
+		return v.IP.String(), v.Port
+	}
+
+	return "", 0
+}
+
+func key(ip string, sourcePort, destinationPort int) string {
+	return fmt.Sprintf("%s|%d|%d", ip, sourcePort, destinationPort)
+}
+
+func connKey(conn net.Conn) string {
+	ip, sourcePort := addr(conn.RemoteAddr())
+	_, destinationPort := addr(conn.LocalAddr())
+
+	return key(ip, sourcePort, destinationPort)
+}
+
+// Open registers the session of the connection, the events of the
+// connection are stamped with the session id, the transcript and the
+// options until the session is closed. Returns the session id.
+func (c *Correlator) Open(conn net.Conn, transcript string, options ...event.Option) string {
+	id := event.NewID()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.sessions[connKey(conn)] = session{
+		id:         id,
+		transcript: transcript,
+		options:    options,
+	}
+
+	return id
+}
+
+// Close removes the session of the connection.
+func (c *Correlator) Close(conn net.Conn) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	delete(c.sessions, connKey(conn))
+}
+
+// port returns the port in the field of the event.
+func port(e event.Event, name string) int {
+	port := 0
+
+	e.Range(func(k, v interface{}) bool {
+		if k != name {
+			return true
+		}
+
+		port, _ = v.(int)
+		return false
+	})
+
+	return port
+}
+
+// expire removes the attacks that ended, at most once a minute.
+func (c *Correlator) expire(now time.Time) {
+	if now.Sub(c.expired) < time.Minute {
+		return
+	}
+
+	c.expired = now
+
+	for ip, a := range c.attacks {
+		if now.Sub(a.lastSeen) > c.AttackWindow.Duration() {
+			delete(c.attacks, ip)
+		}
+	}
+}
+
+// Send stamps the ids on the event, ids already set are kept.
+func (c *Correlator) Send(e event.Event) {
+	if e.Get("id") == "" {
+		e.Store("id", event.NewID())
+	}
+
+	if payload := e.Get("payload"); payload != "" && e.Get("payload.sha256") == "" {
+		sum := sha256.Sum256([]byte(payload))
+		e.Store("payload.sha256", hex.EncodeToString(sum[:]))
+	}
+
+	ip := e.Get("source-ip")
+	if ip == "" {
+		return
+	}
+
+	now := c.now()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if s, ok := c.sessions[key(ip, port(e, "source-port"), port(e, "destination-port"))]; !ok {
+	} else if e.Get("session.id") == "" {
+		e.Store("session.id", s.id)
+
+		if s.transcript != "" {
+			e.Store("session.transcript", s.transcript)
+		}
+
+		for _, option := range s.options {
+			option(e)
+		}
+	}
+
+	c.expire(now)
+
+	a, ok := c.attacks[ip]
+	if !ok || now.Sub(a.lastSeen) > c.AttackWindow.Duration() {
+		a = &attack{
+			id: event.NewID(),
+		}
+
+		c.attacks[ip] = a
+	}
+
+	a.lastSeen = now
+
+	if e.Get("attack.id") == "" {
+		e.Store("attack.id", a.id)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package correlation
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// addrConn is a connection between the addresses.
+type addrConn struct {
+	net.Conn
+
+	remote, local net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+
+var (
+	source      = &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 51234}
+	destination = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+)
+
+func newCorrelator(t *testing.T, now *time.Time) *Correlator {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.now = func() time.Time {
+		return *now
+	}
+
+	return c
+}
+
+func newEvent(options ...event.Option) event.Event {
+	return event.New(append([]event.Option{
+		event.SourceAddr(source),
+		event.DestinationAddr(destination),
+	}, options...)...)
+}
+
+func TestSession(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := newCorrelator(t, &now)
+
+	conn := addrConn{remote: source, local: destination}
+
+	id := c.Open(conn, "bk0q8ptmv6f0000b4gm0", event.Custom("os.name", "Linux"))
+
+	e := newEvent(event.Custom("payload", "uname -a"))
+	c.Send(e)
+
+	if len(e.Get("id")) != 26 {
+		t.Errorf("Expected the event to have an id, got %q", e.Get("id"))
+	}
+
+	if got := e.Get("session.id"); got != id {
+		t.Errorf("Expected session id %s, got %q", id, got)
+	}
+
+	if got := e.Get("session.transcript"); got != "bk0q8ptmv6f0000b4gm0" {
+		t.Errorf("Expected the transcript, got %q", got)
+	}
+
+	if got := e.Get("os.name"); got != "Linux" {
+		t.Errorf("Expected the fields of the session, got %q", got)
+	}
+
+	if got := e.Get("payload.sha256"); got != "28ba533b0f3c4df63d6b4a5ead73860697bdf735bb353e4ca928474889eb8a15" {
+		t.Errorf("Expected the hash of the payload, got %q", got)
+	}
+
+	c.Close(conn)
+
+	e = newEvent()
+	c.Send(e)
+
+	if got := e.Get("session.id"); got != "" {
+		t.Errorf("Expected no session after close, got %q", got)
+	}
+}
+
+func TestAttack(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := newCorrelator(t, &now)
+
+	first := newEvent()
+	c.Send(first)
+
+	now = now.Add(20 * time.Minute)
+
+	second := newEvent()
+	c.Send(second)
+
+	if first.Get("attack.id") == "" || first.Get("attack.id") != second.Get("attack.id") {
+		t.Errorf("Expected the events to be of the same attack, got %q and %q", first.Get("attack.id"), second.Get("attack.id"))
+	}
+
+	now = now.Add(time.Hour)
+
+	third := newEvent()
+	c.Send(third)
+
+	if third.Get("attack.id") == second.Get("attack.id") {
+		t.Error("Expected a new attack after the attack window")
+	}
+}
+
+func TestKeepIDs(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := newCorrelator(t, &now)
+
+	// replayed events keep their ids
+	e := newEvent(
+		event.Custom("id", "01ARYZ6S41TSV4RRFFQ69G5FAV"),
+		event.Custom("attack.id", "01ARYZ6S41TSV4RRFFQ69G5FAW"),
+	)
+
+	c.Send(e)
+
+	if e.Get("id") != "01ARYZ6S41TSV4RRFFQ69G5FAV" || e.Get("attack.id") != "01ARYZ6S41TSV4RRFFQ69G5FAW" {
+		t.Errorf("Expected the ids to be kept, got %q and %q", e.Get("id"), e.Get("attack.id"))
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package credentials tracks the credentials attempted against the decoy
+// personas of the http service. Credentials that are only attempted against
+// a single persona indicate a targeted attack, credentials attempted against
+// multiple personas indicate password spraying.
+package credentials
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:credentials")
+
+func init() {
+	event.RegisterField(event.Field{Name: "credentials.persona", Type: event.TypeString, Description: "Persona of the credential summary"})
+	event.RegisterField(event.Field{Name: "credentials.attempts", Type: event.TypeInteger, Description: "Number of login attempts"})
+	event.RegisterField(event.Field{Name: "credentials.unique", Type: event.TypeInteger, Description: "Number of unique credentials"})
+	event.RegisterField(event.Field{Name: "credentials.sources", Type: event.TypeInteger, Description: "Number of unique sources"})
+	event.RegisterField(event.Field{Name: "credentials.targeted", Type: event.TypeInteger, Description: "Number of targeted credentials"})
+	event.RegisterField(event.Field{Name: "credentials.spray", Type: event.TypeInteger, Description: "Number of sprayed credentials"})
+}
+
+var (
+	SensorCredentials = event.Sensor("credentials")
+
+	EventCategoryCredentials = event.Category("credentials")
+)
+
+const (
+	KindTargeted = "targeted"
+	KindSpray    = "spray"
+)
+
+// Credential contains the attempts of a username and password combination.
+type Credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	Attempts int `json:"attempts"`
+	Sources  int `json:"sources"`
+
+	// Personas are all personas the credential has been attempted against
+	Personas []string `json:"personas"`
+
+	// Kind is targeted when the credential has only been attempted against
+	// a single persona, spray otherwise.
+	Kind string `json:"kind"`
+
+	FirstSeen time.Time `json:"first-seen"`
+	LastSeen  time.Time `json:"last-seen"`
+}
+
+// Summary contains the aggregated attempts against a persona.
+type Summary struct {
+	Persona string `json:"persona"`
+
+	Attempts    int `json:"attempts"`
+	Credentials int `json:"credentials"`
+	Sources     int `json:"sources"`
+
+	Targeted int `json:"targeted"`
+	Spray    int `json:"spray"`
+
+	LastSeen time.Time `json:"last-seen"`
+}
+
+type key struct {
+	username string
+	password string
+}
+
+type entry struct {
+	attempts  int
+	sources   map[string]struct{}
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// Tracker tracks the credentials per persona.
+type Tracker struct {
+	// Interval is the interval the summary events are sent
+	Interval config.Delay `toml:"interval"`
+
+	channel pushers.Channel
+
+	m        sync.RWMutex
+	personas map[string]map[key]*entry
+
+	now func() time.Time
+}
+
+// New returns a new Tracker.
+func New(options ...func(*Tracker) error) (*Tracker, error) {
+	t := &Tracker{
+		Interval: config.Delay(time.Hour),
+		channel:  pushers.MustDummy(),
+		personas: map[string]map[key]*entry{},
+		now:      time.Now,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(t); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the credentials configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Tracker) error {
+	return func(t *Tracker) error {
+		return decoder.PrimitiveDecode(c, t)
+	}
+}
+
+// WithChannel sets the channel the summary events are sent to.
+func WithChannel(channel pushers.Channel) func(*Tracker) error {
+	return func(t *Tracker) error {
+		t.channel = channel
+		return nil
+	}
+}
+
+// Send records the login attempts against personas.
+func (t *Tracker) Send(e event.Event) {
+	persona := e.Get("http.persona")
+	if persona == "" || e.Get("type") != "login" {
+		return
+	}
+
+	k := key{
+		username: e.Get("http.username"),
+		password: e.Get("http.password"),
+	}
+
+	now := t.now()
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	credentials, ok := t.personas[persona]
+	if !ok {
+		credentials = map[key]*entry{}
+		t.personas[persona] = credentials
+	}
+
+	en, ok := credentials[k]
+	if !ok {
+		en = &entry{
+			sources:   map[string]struct{}{},
+			firstSeen: now,
+		}
+
+		credentials[k] = en
+	}
+
+	en.attempts++
+	en.lastSeen = now
+
+	if source := e.Get("source-ip"); source != "" {
+		en.sources[source] = struct{}{}
+	}
+}
+
+// attemptedAgainst returns the personas the credential has been attempted
+// against. The lock should be held.
+func (t *Tracker) attemptedAgainst(k key) []string {
+	names := []string{}
+
+	for persona, credentials := range t.personas {
+		if _, ok := credentials[k]; ok {
+			names = append(names, persona)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func kind(personas []string) string {
+	if len(personas) > 1 {
+		return KindSpray
+	}
+
+	return KindTargeted
+}
+
+// Summaries returns the summaries of all personas.
+func (t *Tracker) Summaries() []Summary {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	summaries := []Summary{}
+
+	for persona, credentials := range t.personas {
+		summary := Summary{
+			Persona:     persona,
+			Credentials: len(credentials),
+		}
+
+		sources := map[string]struct{}{}
+
+		for k, en := range credentials {
+			summary.Attempts += en.attempts
+
+			for source := range en.sources {
+				sources[source] = struct{}{}
+			}
+
+			if en.lastSeen.After(summary.LastSeen) {
+				summary.LastSeen = en.lastSeen
+			}
+
+			if kind(t.attemptedAgainst(k)) == KindSpray {
+				summary.Spray++
+			} else {
+				summary.Targeted++
+			}
+		}
+
+		summary.Sources = len(sources)
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Persona < summaries[j].Persona
+	})
+
+	return summaries
+}
+
+// Credentials returns the credentials attempted against the persona, ordered
+// by number of attempts. All credentials are returned when n is zero.
+func (t *Tracker) Credentials(persona string, n int) []Credential {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	result := []Credential{}
+
+	for k, en := range t.personas[persona] {
+		personas := t.attemptedAgainst(k)
+
+		result = append(result, Credential{
+			Username:  k.username,
+			Password:  k.password,
+			Attempts:  en.attempts,
+			Sources:   len(en.sources),
+			Personas:  personas,
+			Kind:      kind(personas),
+			FirstSeen: en.firstSeen,
+			LastSeen:  en.lastSeen,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Attempts != result[j].Attempts {
+			return result[i].Attempts > result[j].Attempts
+		}
+
+		if result[i].Username != result[j].Username {
+			return result[i].Username < result[j].Username
+		}
+
+		return result[i].Password < result[j].Password
+	})
+
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+
+	return result
+}
+
+// Flush sends the summary events of all personas.
+func (t *Tracker) Flush() {
+	for _, s := range t.Summaries() {
+		t.channel.Send(event.New(
+			SensorCredentials,
+			EventCategoryCredentials,
+			event.Type("persona-summary"),
+			event.Custom("credentials.persona", s.Persona),
+			event.Custom("credentials.attempts", s.Attempts),
+			event.Custom("credentials.unique", s.Credentials),
+			event.Custom("credentials.sources", s.Sources),
+			event.Custom("credentials.targeted", s.Targeted),
+			event.Custom("credentials.spray", s.Spray),
+		))
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package credentials
+
+import (
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func login(persona, source, username, password string) event.Event {
+	return event.New(
+		event.Type("login"),
+		event.Custom("source-ip", source),
+		event.Custom("http.persona", persona),
+		event.Custom("http.username", username),
+		event.Custom("http.password", password),
+	)
+}
+
+type testChannel []event.Event
+
+func (c *testChannel) Send(e event.Event) {
+	*c = append(*c, e)
+}
+
+func TestTracker(t *testing.T) {
+	c := &testChannel{}
+
+	tracker, err := New(WithChannel(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker.Send(login("wordpress", "10.0.0.1", "admin", "admin"))
+	tracker.Send(login("wordpress", "10.0.0.2", "admin", "admin"))
+	tracker.Send(login("router", "10.0.0.1", "admin", "admin"))
+	tracker.Send(login("wordpress", "10.0.0.3", "wpadmin", "acme2019"))
+
+	// not a login attempt
+	tracker.Send(event.New(event.Custom("http.persona", "wordpress")))
+
+	summaries := tracker.Summaries()
+	if len(summaries) != 2 {
+		t.Fatalf("Expected 2 personas, got %d", len(summaries))
+	}
+
+	wp := summaries[1]
+	if wp.Persona != "wordpress" || wp.Attempts != 3 || wp.Credentials != 2 || wp.Sources != 3 {
+		t.Errorf("Unexpected wordpress summary: %+v", wp)
+	}
+
+	if wp.Targeted != 1 || wp.Spray != 1 {
+		t.Errorf("Expected 1 targeted and 1 spray credential, got %d and %d", wp.Targeted, wp.Spray)
+	}
+
+	creds := tracker.Credentials("wordpress", 0)
+	if len(creds) != 2 {
+		t.Fatalf("Expected 2 credentials, got %d", len(creds))
+	}
+
+	if creds[0].Username != "admin" || creds[0].Attempts != 2 || creds[0].Kind != KindSpray || len(creds[0].Personas) != 2 {
+		t.Errorf("Unexpected credential: %+v", creds[0])
+	}
+
+	if creds[1].Username != "wpadmin" || creds[1].Kind != KindTargeted {
+		t.Errorf("Unexpected credential: %+v", creds[1])
+	}
+
+	if n := len(tracker.Credentials("wordpress", 1)); n != 1 {
+		t.Errorf("Expected 1 credential, got %d", n)
+	}
+
+	tracker.Flush()
+
+	if len(*c) != 2 {
+		t.Fatalf("Expected 2 summary events, got %d", len(*c))
+	}
+
+	if (*c)[1].Get("credentials.persona") != "wordpress" {
+		t.Errorf("Expected summary of wordpress, got %s", (*c)[1].Get("credentials.persona"))
+	}
+}
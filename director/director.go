@@ -54,6 +54,12 @@ type Director interface {
 	// Run(ctx context.Context)
 }
 
+// ContainerCounter is implemented by the directors that manage containers,
+// the number of containers is exposed in the metrics.
+type ContainerCounter interface {
+	ContainerCount() int
+}
+
 type SetChanneler interface {
 	SetChannel(pushers.Channel)
 }
@@ -58,6 +58,18 @@ func (d *lxcDirector) SetChannel(eb pushers.Channel) {
 	d.eb = eb
 }
 
+// ContainerCount returns the number of containers created by the director.
+func (d *lxcDirector) ContainerCount() int {
+	count := 0
+
+	d.cache.Range(func(k, v interface{}) bool {
+		count++
+		return true
+	})
+
+	return count
+}
+
 func (d *lxcDirector) Dial(conn net.Conn) (net.Conn, error) {
 	h := fnv.New32()
 
@@ -0,0 +1,92 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package event
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// crockford is the base32 alphabet of the ids, without I, L, O and U.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ids = struct {
+	sync.Mutex
+
+	ms      uint64
+	entropy [10]byte
+}{}
+
+// NewID returns a new ULID, a 26 character id that sorts by the time it was
+// created. Ids created within the same millisecond are incremented, so they
+// sort in the order they were created as well.
+func NewID() string {
+	return newID(time.Now())
+}
+
+func newID(t time.Time) string {
+	ids.Lock()
+	defer ids.Unlock()
+
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+
+	if ms != ids.ms {
+		ids.ms = ms
+		rand.Read(ids.entropy[:])
+	} else {
+		// the random part overflows after 2^80 ids in a millisecond
+		for i := len(ids.entropy) - 1; i >= 0; i-- {
+			ids.entropy[i]++
+			if ids.entropy[i] != 0 {
+				break
+			}
+		}
+	}
+
+	data := [16]byte{}
+	for i := 0; i < 6; i++ {
+		data[i] = byte(ms >> uint(40-8*i))
+	}
+
+	copy(data[6:], ids.entropy[:])
+
+	return encodeID(data)
+}
+
+// encodeID encodes the 128 bits of the id in 26 characters, of 5 bits each.
+// The first character holds the 3 most significant bits.
+func encodeID(data [16]byte) string {
+	id := make([]byte, 26)
+
+	for i := range id {
+		v := 0
+
+		for b := 0; b < 5; b++ {
+			v <<= 1
+
+			// the 130 bits of the characters start with 2 zero bits
+			pos := i*5 + b - 2
+			if pos < 0 {
+				continue
+			}
+
+			v |= int(data[pos/8]>>uint(7-pos%8)) & 1
+		}
+
+		id[i] = crockford[v]
+	}
+
+	return string(id)
+}
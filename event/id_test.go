@@ -0,0 +1,54 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package event
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeID(t *testing.T) {
+	if id := encodeID([16]byte{}); id != "00000000000000000000000000" {
+		t.Errorf("Expected zero id, got %s", id)
+	}
+
+	max := [16]byte{}
+	for i := range max {
+		max[i] = 0xff
+	}
+
+	if id := encodeID(max); id != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("Expected maximum id, got %s", id)
+	}
+}
+
+func TestNewID(t *testing.T) {
+	// the time part of the example of the ulid specification
+	date := time.Unix(0, 1469918176385*int64(time.Millisecond))
+
+	id := newID(date)
+	if len(id) != 26 || !strings.HasPrefix(id, "01ARYZ6S41") {
+		t.Errorf("Unexpected id %s", id)
+	}
+
+	// ids of the same millisecond sort in order
+	if next := newID(date); next <= id {
+		t.Errorf("Expected %s to sort after %s", next, id)
+	}
+
+	if later := newID(date.Add(time.Millisecond)); later <= id {
+		t.Errorf("Expected %s to sort after %s", later, id)
+	}
+}
@@ -44,6 +44,7 @@ func New(opts ...Option) Event {
 	}
 
 	e.sm.Store("date", time.Now())
+	e.sm.Store(FieldSchemaVersion, SchemaVersion)
 
 	for _, opt := range opts {
 		if opt == nil {
@@ -56,6 +57,21 @@ func New(opts ...Option) Event {
 	return e
 }
 
+// Copy returns a shallow copy of the event, changes to the copy don't
+// affect the original event.
+func (e Event) Copy() Event {
+	c := Event{
+		sm: new(sync.Map),
+	}
+
+	e.sm.Range(func(key, value interface{}) bool {
+		c.sm.Store(key, value)
+		return true
+	})
+
+	return c
+}
+
 // Range defines a function which ranges the underline key-values with
 // the provided syncmap.
 func (e Event) Range(fx func(interface{}, interface{}) bool) {
@@ -67,6 +83,11 @@ func (e Event) Store(s string, v interface{}) {
 	e.sm.Store(s, v)
 }
 
+// Delete removes the key from the event.
+func (e Event) Delete(s string) {
+	e.sm.Delete(s)
+}
+
 // Has returns true/false if the giving key exists.
 func (e Event) Has(s string) bool {
 	_, ok := e.sm.Load(s)
@@ -0,0 +1,205 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package event
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SchemaVersion is the version of the event schema. The version is
+// incremented when fields are renamed or removed, adding fields doesn't
+// change the version.
+const SchemaVersion = 1
+
+// FieldSchemaVersion is the field containing the schema version of an event.
+const FieldSchemaVersion = "schema-version"
+
+// Field types of the schema, these are the JSON Schema types.
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeArray   = "array"
+	TypeAny     = ""
+)
+
+// Field describes a field of the events. The name may contain a wildcard
+// (eg. *.username) for fields shared by the services.
+type Field struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description"`
+
+	// Since is the schema version the field was introduced
+	Since int `json:"since"`
+}
+
+var schema = struct {
+	sync.RWMutex
+
+	fields  map[string]Field
+	renames map[int]map[string]string
+}{
+	fields:  map[string]Field{},
+	renames: map[int]map[string]string{},
+}
+
+// RegisterField adds the field to the schema, packages register the fields
+// they add to the events in their init.
+func RegisterField(f Field) {
+	schema.Lock()
+	defer schema.Unlock()
+
+	if f.Since == 0 {
+		f.Since = 1
+	}
+
+	schema.fields[f.Name] = f
+}
+
+// RegisterRename records that the field was renamed in the schema version,
+// events can be downgraded to the old name for consumers of older versions.
+func RegisterRename(version int, from, to string) {
+	schema.Lock()
+	defer schema.Unlock()
+
+	if _, ok := schema.renames[version]; !ok {
+		schema.renames[version] = map[string]string{}
+	}
+
+	schema.renames[version][to] = from
+}
+
+// Fields returns the registered fields, sorted by name.
+func Fields() []Field {
+	schema.RLock()
+	defer schema.RUnlock()
+
+	fields := []Field{}
+	for _, f := range schema.fields {
+		fields = append(fields, f)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	return fields
+}
+
+// Downgrade returns a copy of the event with the fields renamed to their
+// names in the schema version. Events of the current version are returned
+// as is.
+func Downgrade(e Event, version int) Event {
+	if version <= 0 || version >= SchemaVersion {
+		return e
+	}
+
+	return downgrade(e, SchemaVersion, version)
+}
+
+func downgrade(e Event, current, version int) Event {
+	schema.RLock()
+	defer schema.RUnlock()
+
+	c := e.Copy()
+
+	// undo the renames from the newest version down
+	for v := current; v > version; v-- {
+		for to, from := range schema.renames[v] {
+			value, ok := c.sm.Load(to)
+			if !ok {
+				continue
+			}
+
+			c.Delete(to)
+			c.Store(from, value)
+		}
+	}
+
+	c.Store(FieldSchemaVersion, version)
+	return c
+}
+
+// JSONSchema returns the schema of the events as JSON Schema document.
+func JSONSchema() ([]byte, error) {
+	properties := map[string]interface{}{}
+	patterns := map[string]interface{}{}
+
+	for _, f := range Fields() {
+		p := map[string]interface{}{
+			"description": f.Description,
+		}
+
+		if f.Type != TypeAny {
+			p["type"] = f.Type
+		}
+
+		if !strings.Contains(f.Name, "*") {
+			properties[f.Name] = p
+			continue
+		}
+
+		pattern := "^" + strings.Replace(regexp.QuoteMeta(f.Name), `\*`, `[^.]+`, -1) + "$"
+		patterns[pattern] = p
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"$id":                  "https://honeytrap.io/schema/event.json",
+		"title":                "Honeytrap event",
+		"version":              SchemaVersion,
+		"type":                 "object",
+		"required":             []string{"date", FieldSchemaVersion},
+		"properties":           properties,
+		"patternProperties":    patterns,
+		"additionalProperties": true,
+	}, "", "  ")
+}
+
+func init() {
+	for _, f := range []Field{
+		{Name: "date", Type: TypeString, Description: "Time the event was created"},
+		{Name: FieldSchemaVersion, Type: TypeInteger, Description: "Version of the event schema"},
+		{Name: "type", Type: TypeString, Description: "Type of the event, eg. SERVICE:ENDED"},
+		{Name: "category", Type: TypeString, Description: "Category of the event, usually the type of the service"},
+		{Name: "sensor", Type: TypeString, Description: "Sensor that created the event"},
+		{Name: "service", Type: TypeString, Description: "Name of the service"},
+		{Name: "protocol", Type: TypeString, Description: "Transport protocol"},
+		{Name: "token", Type: TypeString, Description: "Token of the honeytrap instance"},
+		{Name: "message", Type: TypeString, Description: "Human readable message"},
+		{Name: "error", Description: "Error that occurred"},
+		{Name: "stacktrace", Type: TypeString, Description: "Stacktrace of the error"},
+		{Name: "source-ip", Type: TypeString, Description: "IP address of the attacker"},
+		{Name: "source-port", Type: TypeInteger, Description: "Port of the attacker"},
+		{Name: "source-mac", Type: TypeString, Description: "Hardware address of the attacker"},
+		{Name: "destination-ip", Type: TypeString, Description: "IP address of the honeypot"},
+		{Name: "destination-port", Type: TypeInteger, Description: "Port of the honeypot"},
+		{Name: "destination-mac", Type: TypeString, Description: "Hardware address of the honeypot"},
+		{Name: "remote-addr", Type: TypeString, Description: "Address of the remote end"},
+		{Name: "host-addr", Type: TypeString, Description: "Address of the host"},
+		{Name: "payload", Type: TypeString, Description: "Payload received"},
+		{Name: "payload-hex", Type: TypeString, Description: "Payload received, hex encoded"},
+		{Name: "payload-length", Type: TypeInteger, Description: "Length of the payload"},
+		{Name: "*.username", Type: TypeString, Description: "Username of a login attempt on the service"},
+		{Name: "*.password", Type: TypeString, Description: "Password of a login attempt on the service"},
+	} {
+		RegisterField(f)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package event
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	e := New(Service("ssh"))
+
+	v, ok := e.sm.Load(FieldSchemaVersion)
+	if !ok || v != SchemaVersion {
+		t.Errorf("Expected schema version %d, got %v", SchemaVersion, v)
+	}
+}
+
+func TestDowngrade(t *testing.T) {
+	RegisterRename(SchemaVersion+1, "test.old", "test.new")
+	defer func() {
+		schema.Lock()
+		delete(schema.renames, SchemaVersion+1)
+		schema.Unlock()
+	}()
+
+	e := New(Custom("test.new", "value"))
+
+	d := downgrade(e, SchemaVersion+1, SchemaVersion)
+	if d.Get("test.old") != "value" || d.Has("test.new") {
+		t.Errorf("Expected field to be renamed, got %v", ToMap(d))
+	}
+
+	if e.Get("test.new") != "value" {
+		t.Error("Expected original event to be untouched")
+	}
+
+	if Downgrade(e, SchemaVersion).Get("test.new") != "value" {
+		t.Error("Expected event of current version to be untouched")
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	data, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := struct {
+		Properties        map[string]interface{} `json:"properties"`
+		PatternProperties map[string]interface{} `json:"patternProperties"`
+	}{}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := doc.Properties["source-ip"]; !ok {
+		t.Error("Expected source-ip in schema")
+	}
+
+	if _, ok := doc.PatternProperties[`^[^.]+\.username$`]; !ok {
+		t.Errorf("Expected username pattern in schema, got %v", doc.PatternProperties)
+	}
+}
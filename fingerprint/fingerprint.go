@@ -0,0 +1,196 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package fingerprint guesses the operating system of the attackers from
+// the syn packet of their connections, like p0f. The fingerprinting is
+// passive, the attackers aren't probed. The syn packets are saved by the
+// kernel for the listeners that enable it, which is supported on Linux.
+package fingerprint
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	logging "github.com/op/go-logging"
+	"golang.org/x/time/rate"
+)
+
+var log = logging.MustGetLogger("honeytrap:fingerprint")
+
+func init() {
+	event.RegisterField(event.Field{Name: "os.name", Type: event.TypeString, Description: "Operating system of the attacker, guessed from the syn packet"})
+	event.RegisterField(event.Field{Name: "os.flavor", Type: event.TypeString, Description: "Version of the operating system of the attacker"})
+	event.RegisterField(event.Field{Name: "os.class", Type: event.TypeString, Description: "Class of the tcp/ip stack: generic, embedded or scanner"})
+	event.RegisterField(event.Field{Name: "os.fuzzy", Type: event.TypeBoolean, Description: "Whether the operating system was guessed from the ttl only"})
+	event.RegisterField(event.Field{Name: "os.signature", Type: event.TypeString, Description: "Signature of the syn packet, in the p0f format"})
+	event.RegisterField(event.Field{Name: "os.distance", Type: event.TypeInteger, Description: "Number of hops to the attacker, from the ttl"})
+}
+
+// ErrNotSupported is returned when the syn packets can't be read on the
+// platform or for the connection.
+var ErrNotSupported = errors.New("Reading syn packets not supported")
+
+// Fingerprinter fingerprints the connections, the number of fingerprints
+// per second is limited and the results are cached per source.
+type Fingerprinter struct {
+	Enabled bool `toml:"enabled"`
+
+	// Rate is the maximum number of fingerprints per second
+	Rate int `toml:"rate"`
+
+	// CacheTTL is the time the fingerprint of a source is reused
+	CacheTTL config.Delay `toml:"cache-ttl"`
+
+	limiter *rate.Limiter
+
+	m     sync.Mutex
+	cache map[string]result
+
+	expired time.Time
+
+	now func() time.Time
+}
+
+type result struct {
+	signature *Signature
+	match     Match
+	expires   time.Time
+}
+
+// New returns a new Fingerprinter.
+func New(options ...func(*Fingerprinter) error) (*Fingerprinter, error) {
+	f := &Fingerprinter{
+		Enabled:  true,
+		Rate:     50,
+		CacheTTL: config.Delay(time.Hour),
+
+		cache: map[string]result{},
+
+		now: time.Now,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(f); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Rate <= 0 {
+		return nil, errors.New("Fingerprint rate should be positive")
+	}
+
+	f.limiter = rate.NewLimiter(rate.Limit(f.Rate), f.Rate)
+
+	return f, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the fingerprint configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Fingerprinter) error {
+	return func(f *Fingerprinter) error {
+		return decoder.PrimitiveDecode(c, f)
+	}
+}
+
+func (r result) options() []event.Option {
+	options := []event.Option{
+		event.Custom("os.name", r.match.Name),
+		event.Custom("os.class", r.match.Class),
+		event.Custom("os.fuzzy", r.match.Fuzzy),
+		event.Custom("os.signature", r.signature.String()),
+		event.Custom("os.distance", r.signature.Distance),
+	}
+
+	if r.match.Flavor != "" {
+		options = append(options, event.Custom("os.flavor", r.match.Flavor))
+	}
+
+	return options
+}
+
+// expire removes the expired results, at most once a minute.
+func (f *Fingerprinter) expire(now time.Time) {
+	if now.Sub(f.expired) < time.Minute {
+		return
+	}
+
+	f.expired = now
+
+	for ip, r := range f.cache {
+		if now.After(r.expires) {
+			delete(f.cache, ip)
+		}
+	}
+}
+
+// Fingerprint returns the event options with the operating system of the
+// attacker of the connection. No options are returned when the syn packet
+// isn't available, or the rate has been exceeded.
+func (f *Fingerprinter) Fingerprint(conn net.Conn) []event.Option {
+	if !f.Enabled {
+		return nil
+	}
+
+	ta, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+
+	ip := ta.IP.String()
+	now := f.now()
+
+	f.m.Lock()
+	r, ok := f.cache[ip]
+	f.m.Unlock()
+
+	if ok && now.Before(r.expires) {
+		return r.options()
+	}
+
+	if !f.limiter.AllowN(now, 1) {
+		return nil
+	}
+
+	data, err := SYN(conn)
+	if err != nil {
+		return nil
+	}
+
+	s, err := Parse(data)
+	if err != nil {
+		log.Debugf("Error parsing syn packet of %s: %s", ip, err.Error())
+		return nil
+	}
+
+	r = result{
+		signature: s,
+		match:     Lookup(s),
+		expires:   now.Add(f.CacheTTL.Duration()),
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.expire(now)
+	f.cache[ip] = r
+
+	return r.options()
+}
@@ -0,0 +1,203 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fingerprint
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"golang.org/x/time/rate"
+)
+
+// synPacket returns an ipv4 syn packet with the ttl, window and tcp options.
+func synPacket(ttl int, window int, df bool, options ...byte) []byte {
+	for len(options)%4 != 0 {
+		options = append(options, 0)
+	}
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	ip[8] = byte(ttl)
+	ip[9] = 6
+
+	if df {
+		ip[6] = 0x40
+		binary.BigEndian.PutUint16(ip[4:6], 0x1234)
+	}
+
+	tcp := make([]byte, 20)
+	tcp[12] = byte((20+len(options))/4) << 4
+	tcp[13] = 0x02
+	binary.BigEndian.PutUint16(tcp[14:16], uint16(window))
+
+	return append(append(ip, tcp...), options...)
+}
+
+var (
+	optMSS = []byte{2, 4, 0x05, 0xb4}
+	optSOK = []byte{4, 2}
+	optTS  = []byte{8, 10, 0, 0, 0, 1, 0, 0, 0, 0}
+	optNOP = []byte{1}
+)
+
+func optWS(scale byte) []byte {
+	return []byte{3, 3, scale}
+}
+
+func join(options ...[]byte) []byte {
+	result := []byte{}
+	for _, o := range options {
+		result = append(result, o...)
+	}
+
+	return result
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse(synPacket(57, 64240, true, join(optMSS, optSOK, optTS, optNOP, optWS(7))...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "4:64+7:0:1460:mss*44,7:mss,sok,ts,nop,ws:df,id+:0"; s.String() != expected {
+		t.Errorf("Expected signature %s, got %s", expected, s.String())
+	}
+
+	if _, err := Parse([]byte{0x45, 0}); err != ErrInvalidPacket {
+		t.Errorf("Expected invalid packet, got %v", err)
+	}
+
+	// syn ack
+	packet := synPacket(64, 1024, false)
+	packet[33] = 0x12
+
+	if _, err := Parse(packet); err != ErrInvalidPacket {
+		t.Errorf("Expected invalid packet for syn ack, got %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		packet []byte
+		name   string
+		class  string
+	}{
+		{synPacket(52, 64240, true, join(optMSS, optSOK, optTS, optNOP, optWS(7))...), "Linux", ClassGeneric},
+		{synPacket(117, 64240, true, join(optMSS, optNOP, optWS(8), optNOP, optNOP, optSOK)...), "Windows", ClassGeneric},
+		{synPacket(50, 5840, true, join(optMSS, optSOK, optTS, optNOP, optWS(2))...), "Linux", ClassEmbedded},
+		{synPacket(240, 1024, false), "Masscan", ClassScanner},
+		{synPacket(250, 65535, false, optMSS...), "ZMap", ClassScanner},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m := Lookup(s); m.Name != test.name || m.Class != test.class || m.Fuzzy {
+			t.Errorf("%s: expected %s (%s), got %s (%s)", s, test.name, test.class, m, m.Class)
+		}
+	}
+
+	s, _ := Parse(synPacket(120, 1000, true, join(optMSS, optNOP, optNOP, optTS)...))
+	if m := Lookup(s); m.Name != "Windows" || !m.Fuzzy {
+		t.Errorf("Expected a guess from the ttl, got %s", m)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	lc := net.ListenConfig{
+		Control: SaveSYN,
+	}
+
+	l, err := lc.Listen(context.Background(), "tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	accept := func() net.Conn {
+		go func() {
+			if c, err := net.Dial("tcp4", l.Addr().String()); err == nil {
+				time.Sleep(100 * time.Millisecond)
+				c.Close()
+			}
+		}()
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return conn
+	}
+
+	f, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := accept()
+	defer conn.Close()
+
+	options := f.Fingerprint(conn)
+	if options == nil && runtime.GOOS != "linux" {
+		t.Skip("Reading syn packets not supported")
+	}
+
+	e := event.New(options...)
+	if e.Get("os.name") != "Linux" || e.Get("os.signature") == "" {
+		t.Errorf("Expected the fingerprint of the local stack, got %q (%s)", e.Get("os.name"), e.Get("os.signature"))
+	}
+
+	// the fingerprint of the source is cached
+	f.limiter = rate.NewLimiter(0, 0)
+
+	conn2 := accept()
+	defer conn2.Close()
+
+	if e := event.New(f.Fingerprint(conn2)...); e.Get("os.name") != "Linux" {
+		t.Errorf("Expected the cached fingerprint, got %q", e.Get("os.name"))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	f, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.limiter = rate.NewLimiter(0, 0)
+
+	conn := &net.TCPConn{}
+	if options := f.Fingerprint(addrConn{conn, &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 51234}}); options != nil {
+		t.Errorf("Expected no fingerprint when the rate is exceeded, got %d options", len(options))
+	}
+}
+
+// addrConn is a connection from the remote address.
+type addrConn struct {
+	net.Conn
+
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
@@ -0,0 +1,134 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fingerprint
+
+import (
+	"fmt"
+)
+
+// Classes of the operating systems, scanners send crafted packets from
+// raw sockets and embedded devices run old or stripped down stacks.
+const (
+	ClassGeneric  = "generic"
+	ClassEmbedded = "embedded"
+	ClassScanner  = "scanner"
+)
+
+// Match is the operating system matched by a signature.
+type Match struct {
+	Name   string
+	Flavor string
+	Class  string
+
+	// Fuzzy is set when no signature matched, and the operating system
+	// is guessed from the initial ttl
+	Fuzzy bool
+}
+
+func (m Match) String() string {
+	if m.Flavor == "" {
+		return m.Name
+	}
+
+	return m.Name + " " + m.Flavor
+}
+
+// rule matches the signatures of an operating system.
+type rule struct {
+	Match
+
+	// TTL is the initial ttl, zero matches any ttl
+	TTL int
+
+	Layout string
+
+	// Windows are the window sizes, either a size, a multiple of the
+	// mss (mss*10) or * for any size
+	Windows []string
+
+	// Scale is the window scale, -1 matches any scale
+	Scale int
+}
+
+func (r rule) matchWindow(s *Signature) bool {
+	for _, w := range r.Windows {
+		if w == "*" || w == fmt.Sprint(s.Window) || w == s.WindowString() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r rule) match(s *Signature) bool {
+	if r.TTL != 0 && r.TTL != s.InitialTTL {
+		return false
+	}
+
+	if r.Layout != s.Layout {
+		return false
+	}
+
+	if r.Scale != -1 && r.Scale != s.WindowScale {
+		return false
+	}
+
+	return r.matchWindow(s)
+}
+
+// rules are the signatures of the common operating systems and scanners,
+// in order of precedence.
+var rules = []rule{
+	{Match: Match{Name: "Linux", Flavor: "3.11 and newer", Class: ClassGeneric}, TTL: 64, Layout: "mss,sok,ts,nop,ws", Windows: []string{"mss*20", "mss*44", "mss*45", "64240", "65495"}, Scale: -1},
+	{Match: Match{Name: "Linux", Flavor: "3.1-3.10", Class: ClassGeneric}, TTL: 64, Layout: "mss,sok,ts,nop,ws", Windows: []string{"mss*10"}, Scale: -1},
+	{Match: Match{Name: "Linux", Flavor: "2.4.x", Class: ClassEmbedded}, TTL: 64, Layout: "mss,sok,ts,nop,ws", Windows: []string{"mss*4", "5840"}, Scale: 0},
+	{Match: Match{Name: "Linux", Flavor: "2.6.x", Class: ClassEmbedded}, TTL: 64, Layout: "mss,sok,ts,nop,ws", Windows: []string{"mss*4", "5840"}, Scale: -1},
+	{Match: Match{Name: "Linux", Flavor: "without timestamps", Class: ClassEmbedded}, TTL: 64, Layout: "mss,nop,nop,sok,nop,ws", Windows: []string{"*"}, Scale: -1},
+	{Match: Match{Name: "Linux", Flavor: "2.2.x-2.4.x", Class: ClassEmbedded}, TTL: 64, Layout: "mss,nop,nop,sok", Windows: []string{"*"}, Scale: -1},
+	{Match: Match{Name: "Linux", Flavor: "2.0", Class: ClassEmbedded}, TTL: 64, Layout: "mss", Windows: []string{"mss*12", "16384"}, Scale: -1},
+	{Match: Match{Name: "Windows", Flavor: "10", Class: ClassGeneric}, TTL: 128, Layout: "mss,nop,ws,nop,nop,sok", Windows: []string{"64240", "65535", "mss*44"}, Scale: 8},
+	{Match: Match{Name: "Windows", Flavor: "7 or 8", Class: ClassGeneric}, TTL: 128, Layout: "mss,nop,ws,nop,nop,sok", Windows: []string{"8192"}, Scale: -1},
+	{Match: Match{Name: "Windows", Flavor: "XP", Class: ClassGeneric}, TTL: 128, Layout: "mss,nop,nop,sok", Windows: []string{"65535", "64240", "16384", "mss*44"}, Scale: -1},
+	{Match: Match{Name: "Mac OS X", Class: ClassGeneric}, TTL: 64, Layout: "mss,nop,ws,nop,nop,ts,sok,eol+1", Windows: []string{"65535"}, Scale: -1},
+	{Match: Match{Name: "FreeBSD", Class: ClassGeneric}, TTL: 64, Layout: "mss,nop,ws,sok,ts", Windows: []string{"65535"}, Scale: -1},
+	{Match: Match{Name: "OpenBSD", Class: ClassGeneric}, TTL: 64, Layout: "mss,nop,nop,sok,nop,ws,nop,nop,ts", Windows: []string{"16384"}, Scale: -1},
+	{Match: Match{Name: "Masscan", Class: ClassScanner}, Layout: "", Windows: []string{"1024"}, Scale: -1},
+	{Match: Match{Name: "ZMap", Class: ClassScanner}, TTL: 255, Layout: "mss", Windows: []string{"65535"}, Scale: -1},
+	{Match: Match{Name: "Nmap", Flavor: "SYN scan", Class: ClassScanner}, Layout: "mss", Windows: []string{"1024", "2048", "3072", "4096"}, Scale: -1},
+	{Match: Match{Name: "Unknown", Flavor: "raw packet", Class: ClassScanner}, Layout: "", Windows: []string{"*"}, Scale: -1},
+}
+
+// Lookup returns the operating system of the signature, when no signature
+// matches it is guessed from the initial ttl.
+func Lookup(s *Signature) Match {
+	for _, r := range rules {
+		if r.match(s) {
+			return r.Match
+		}
+	}
+
+	m := Match{Name: "Unknown", Class: ClassGeneric, Fuzzy: true}
+
+	switch s.InitialTTL {
+	case 64:
+		m.Name = "Linux/Unix"
+	case 128:
+		m.Name = "Windows"
+	case 255:
+		m.Name = "Network device"
+		m.Class = ClassEmbedded
+	}
+
+	return m
+}
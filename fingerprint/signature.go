@@ -0,0 +1,265 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fingerprint
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPacket is returned for packets that aren't a tcp syn.
+var ErrInvalidPacket = errors.New("Invalid tcp syn packet")
+
+// Signature is the signature of the tcp/ip stack of a syn packet, the
+// fields of the p0f signatures.
+type Signature struct {
+	// Version is the ip version, 4 or 6
+	Version int
+
+	// TTL is the ttl of the packet, InitialTTL the ttl it was likely sent
+	// with, and Distance the number of hops in between
+	TTL        int
+	InitialTTL int
+	Distance   int
+
+	// OptionsLength is the length of the ip options
+	OptionsLength int
+
+	MSS         int
+	Window      int
+	WindowScale int
+
+	// Layout are the tcp options, in order, eg. mss,sok,ts,nop,ws
+	Layout string
+
+	// Quirks are the peculiarities of the headers, eg. df or id+
+	Quirks []string
+}
+
+// initialTTL returns the ttl the packet was likely sent with, the common
+// initial ttls are 32, 64, 128 and 255.
+func initialTTL(ttl int) int {
+	for _, v := range []int{32, 64, 128} {
+		if ttl <= v {
+			return v
+		}
+	}
+
+	return 255
+}
+
+// WindowString returns the window size as a multiple of the mss when
+// possible, eg. mss*10.
+func (s Signature) WindowString() string {
+	if s.MSS > 0 && s.Window > 0 && s.Window%s.MSS == 0 {
+		return fmt.Sprintf("mss*%d", s.Window/s.MSS)
+	}
+
+	return fmt.Sprint(s.Window)
+}
+
+// String returns the signature in the p0f format:
+// ver:ittl+distance:olen:mss:wsize,scale:olayout:quirks:pclass.
+func (s Signature) String() string {
+	return fmt.Sprintf("%d:%d+%d:%d:%d:%s,%d:%s:%s:0",
+		s.Version,
+		s.InitialTTL,
+		s.Distance,
+		s.OptionsLength,
+		s.MSS,
+		s.WindowString(),
+		s.WindowScale,
+		s.Layout,
+		strings.Join(s.Quirks, ","),
+	)
+}
+
+// HasQuirk returns true if the signature has the quirk.
+func (s Signature) HasQuirk(quirk string) bool {
+	for _, q := range s.Quirks {
+		if q == quirk {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse parses the signature of the syn packet, the packet starts with the
+// ip header.
+func Parse(data []byte) (*Signature, error) {
+	if len(data) < 1 {
+		return nil, ErrInvalidPacket
+	}
+
+	s := &Signature{
+		Quirks: []string{},
+	}
+
+	var tcp []byte
+
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return nil, ErrInvalidPacket
+		}
+
+		ihl := int(data[0]&0x0f) * 4
+		if ihl < 20 || len(data) < ihl || data[9] != 6 {
+			return nil, ErrInvalidPacket
+		}
+
+		s.Version = 4
+		s.TTL = int(data[8])
+		s.OptionsLength = ihl - 20
+
+		if data[1]&0x03 != 0 {
+			s.Quirks = append(s.Quirks, "ecn")
+		}
+
+		id := binary.BigEndian.Uint16(data[4:6])
+		flags := data[6] >> 5
+
+		if flags&0x04 != 0 {
+			s.Quirks = append(s.Quirks, "0+")
+		}
+
+		if flags&0x02 != 0 {
+			s.Quirks = append(s.Quirks, "df")
+
+			if id != 0 {
+				s.Quirks = append(s.Quirks, "id+")
+			}
+		} else if id == 0 {
+			s.Quirks = append(s.Quirks, "id-")
+		}
+
+		tcp = data[ihl:]
+	case 6:
+		// extension headers aren't supported
+		if len(data) < 40 || data[6] != 6 {
+			return nil, ErrInvalidPacket
+		}
+
+		s.Version = 6
+		s.TTL = int(data[7])
+
+		if binary.BigEndian.Uint32(data[0:4])&0x000fffff != 0 {
+			s.Quirks = append(s.Quirks, "flow")
+		}
+
+		if (data[1]>>4)&0x03 != 0 {
+			s.Quirks = append(s.Quirks, "ecn")
+		}
+
+		tcp = data[40:]
+	default:
+		return nil, ErrInvalidPacket
+	}
+
+	if len(tcp) < 20 {
+		return nil, ErrInvalidPacket
+	}
+
+	offset := int(tcp[12]>>4) * 4
+	if offset < 20 || len(tcp) < offset {
+		return nil, ErrInvalidPacket
+	}
+
+	// syn without ack
+	if tcp[13]&0x12 != 0x02 {
+		return nil, ErrInvalidPacket
+	}
+
+	if tcp[13]&0xc0 != 0 && !s.HasQuirk("ecn") {
+		s.Quirks = append(s.Quirks, "ecn")
+	}
+
+	s.Window = int(binary.BigEndian.Uint16(tcp[14:16]))
+	s.InitialTTL = initialTTL(s.TTL)
+	s.Distance = s.InitialTTL - s.TTL
+
+	s.parseOptions(tcp[20:offset])
+
+	return s, nil
+}
+
+// parseOptions parses the tcp options into the layout.
+func (s *Signature) parseOptions(options []byte) {
+	layout := []string{}
+
+	for i := 0; i < len(options); {
+		kind := options[i]
+
+		if kind == 0 {
+			layout = append(layout, fmt.Sprintf("eol+%d", len(options)-i-1))
+
+			for _, b := range options[i+1:] {
+				if b != 0 {
+					s.Quirks = append(s.Quirks, "opt+")
+					break
+				}
+			}
+
+			break
+		} else if kind == 1 {
+			layout = append(layout, "nop")
+			i++
+			continue
+		}
+
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			s.Quirks = append(s.Quirks, "bad")
+			break
+		}
+
+		length := int(options[i+1])
+		value := options[i+2 : i+length]
+
+		switch {
+		case kind == 2 && length == 4:
+			layout = append(layout, "mss")
+			s.MSS = int(binary.BigEndian.Uint16(value))
+		case kind == 3 && length == 3:
+			layout = append(layout, "ws")
+			s.WindowScale = int(value[0])
+
+			if s.WindowScale > 14 {
+				s.Quirks = append(s.Quirks, "exws")
+			}
+		case kind == 4 && length == 2:
+			layout = append(layout, "sok")
+		case kind == 5:
+			layout = append(layout, "sack")
+		case kind == 8 && length == 10:
+			layout = append(layout, "ts")
+
+			if binary.BigEndian.Uint32(value[0:4]) == 0 {
+				s.Quirks = append(s.Quirks, "ts1-")
+			}
+
+			if binary.BigEndian.Uint32(value[4:8]) != 0 {
+				s.Quirks = append(s.Quirks, "ts2+")
+			}
+		default:
+			layout = append(layout, fmt.Sprintf("?%d", kind))
+		}
+
+		i += length
+	}
+
+	s.Layout = strings.Join(layout, ",")
+}
@@ -0,0 +1,80 @@
+// +build linux,!386
+
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fingerprint
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tcpSaveSYN  = 0x1b
+	tcpSavedSYN = 0x1c
+)
+
+// SaveSYN enables saving the syn packets of the connections accepted by the
+// socket, it is the Control func of the listen config.
+func SaveSYN(network, address string, c syscall.RawConn) error {
+	var serr error
+
+	if err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpSaveSYN, 1)
+	}); err != nil {
+		return err
+	}
+
+	return serr
+}
+
+type syscallConn interface {
+	SyscallConn() (syscall.RawConn, error)
+}
+
+// SYN returns the saved syn packet of the connection, starting with the ip
+// header. The kernel releases the packet once read.
+func SYN(conn interface{}) ([]byte, error) {
+	sc, ok := conn.(syscallConn)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 512)
+	size := uint32(len(buf))
+
+	var serr error
+
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, tcpSavedSYN, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			serr = errno
+		}
+	}); err != nil {
+		return nil, err
+	} else if serr != nil {
+		return nil, serr
+	}
+
+	if size == 0 {
+		return nil, ErrNotSupported
+	}
+
+	return buf[:size], nil
+}
@@ -0,0 +1,31 @@
+// +build !linux 386
+
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fingerprint
+
+import (
+	"syscall"
+)
+
+// SaveSYN is a no-op, saving syn packets isn't supported on the platform.
+func SaveSYN(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// SYN returns ErrNotSupported, saving syn packets isn't supported on the
+// platform.
+func SYN(conn interface{}) ([]byte, error) {
+	return nil, ErrNotSupported
+}
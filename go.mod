@@ -7,24 +7,21 @@ require (
 	github.com/BurntSushi/toml v0.3.0
 	github.com/Logicalis/asn1 v0.0.0-20160307192209-c9c836c1a3cd
 	github.com/PromonLogicalis/asn1 v0.0.0-20190312173541-d60463189a56 // indirect
-	github.com/Shopify/sarama v1.16.0
+	github.com/Shopify/sarama v1.22.1
 	github.com/Shopify/toxiproxy v2.1.4+incompatible // indirect
 	github.com/boltdb/bolt v1.3.1
 	github.com/dgraph-io/badger v0.0.0-20180227002726-94594b20babf
 	github.com/dgryski/go-farm v0.0.0-20180109070241-2de33835d102 // indirect
 	github.com/dutchcoders/gobus v0.0.0-20180915095724-ece5a7810d96
-	github.com/eapache/go-resiliency v1.0.0 // indirect
-	github.com/eapache/go-xerial-snappy v0.0.0-20160609142408-bb955e01b934 // indirect
 	github.com/eapache/queue v1.1.0 // indirect
 	github.com/elazarl/go-bindata-assetfs v0.0.0-20180223160309-38087fe4dafb
 	github.com/fatih/color v1.6.0
 	github.com/fortytw2/leaktest v1.3.0 // indirect
-	github.com/fuyufjh/splunk-hec-go v0.3.3
 	github.com/glycerine/goconvey v0.0.0-20190410193231-58a59202ab31 // indirect
 	github.com/glycerine/rbuf v0.0.0-20171031012212-54320fe9f6f3
 	github.com/go-asn1-ber/asn1-ber v0.0.0-20170511165959-379148ca0225
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/golang/protobuf v0.0.0-20180202184318-bbd03ef6da3a
-	github.com/golang/snappy v0.0.0-20170215233205-553a64147049 // indirect
 	github.com/google/btree v1.0.0 // indirect
 	github.com/google/gopacket v1.1.14
 	github.com/google/netstack v0.0.0
@@ -32,6 +29,7 @@ require (
 	github.com/honeytrap/honeytrap-web v0.0.0-20180212153621-02944754979e
 	github.com/honeytrap/protocol v0.0.0-20190410072324-219b95413db0
 	github.com/kr/pretty v0.1.0 // indirect
+	github.com/lib/pq v1.2.0
 	github.com/mailru/easyjson v0.0.0-20171120080333-32fa128f234d // indirect
 	github.com/mattn/go-colorable v0.0.9 // indirect
 	github.com/mattn/go-isatty v0.0.3
@@ -40,11 +38,9 @@ require (
 	github.com/mimoo/disco v0.0.0-20180114190844-15dd4b8476c9
 	github.com/op/go-logging v0.0.0-20160211212156-b2cb9fa56473
 	github.com/oschwald/maxminddb-golang v1.3.0
-	github.com/pierrec/lz4 v0.0.0-20171218195038-2fcda4cb7018 // indirect
 	github.com/pierrec/xxHash v0.1.1 // indirect
 	github.com/pkg/errors v0.8.0 // indirect
 	github.com/pkg/profile v1.2.1
-	github.com/rcrowley/go-metrics v0.0.0-20180125231941-8732c616f529 // indirect
 	github.com/rs/xid v0.0.0-20170604230408-02dd45c33376
 	github.com/satori/go.uuid v1.2.0
 	github.com/smartystreets/goconvey v0.0.0-20190330032615-68dc04aab96a // indirect
@@ -54,8 +50,10 @@ require (
 	github.com/stretchr/testify v1.3.0 // indirect
 	github.com/vishvananda/netlink v1.0.0
 	github.com/vishvananda/netns v0.0.0-20171111001504-be1fbeda1936 // indirect
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c
+	github.com/xdg/stringprep v1.0.0 // indirect
 	github.com/yuin/gopher-lua v0.0.0-20190206043414-8bfc7677f583
-	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	golang.org/x/crypto v0.0.0-20190404164418-38d8ce5564a5
 	golang.org/x/sync v0.0.0-20190412183630-56d357773e84 // indirect
 	golang.org/x/time v0.0.0-20170927054726-6dc17368e09b
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
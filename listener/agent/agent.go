@@ -20,12 +20,15 @@ import (
 	"io"
 	"net"
 	"runtime"
+	"sync/atomic"
+	"time"
 
 	bus "github.com/dutchcoders/gobus"
 	"github.com/fatih/color"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/listener"
 	"github.com/honeytrap/honeytrap/messages"
+	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/mimoo/disco/libdisco"
 
 	logging "github.com/op/go-logging"
@@ -44,11 +47,30 @@ type agentListener struct {
 	ch        chan net.Conn
 	Addresses []net.Addr
 
+	c pushers.Channel
+
 	net.Listener
 }
 
 type agentConfig struct {
 	Listen string `toml:"listen"`
+
+	// Commands are the commands that may be sent to the agents, the
+	// agents are push only when not set
+	Commands []string `toml:"commands"`
+
+	// Uplink configures the batching, compression and jitter of the
+	// messages of the agents
+	Uplink UplinkConfig `toml:"uplink"`
+
+	// Failover configures the servers the agents fail over to
+	Failover FailoverConfig `toml:"failover"`
+}
+
+// SetChannel sets the channel the commands sent to the agents are audited
+// on.
+func (al *agentListener) SetChannel(c pushers.Channel) {
+	al.c = c
 }
 
 // AddAddress will add the addresses to listen to
@@ -69,6 +91,14 @@ func New(options ...func(listener.Listener) error) (listener.Listener, error) {
 		option(&l)
 	}
 
+	if err := l.Uplink.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := l.Failover.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &l, nil
 }
 
@@ -124,8 +154,15 @@ func (al *agentListener) serv(c *conn2) {
 		},
 	})
 
+	// the messages up to the sequence have been received, the agent
+	// resends the messages after it
+	received := resume(token, h.Session)
+
 	c.send(HandshakeResponse{
-		al.Addresses,
+		Addresses: al.Addresses,
+		Uplink:    al.Uplink,
+		Failover:  al.Failover,
+		Sequence:  received,
 	})
 
 	out := make(chan interface{})
@@ -133,9 +170,30 @@ func (al *agentListener) serv(c *conn2) {
 	conns := Connections{}
 
 	ctx, cancel := context.WithCancel(context.Background())
+
+	ra := &remoteAgent{
+		Agent: messages.Agent{
+			Version:       version,
+			ShortCommitID: shortCommitID,
+			Token:         token,
+			RemoteAddr:    c.RemoteAddr().String(),
+		},
+		protocolVersion: h.ProtocolVersion,
+		allowed:         al.Commands,
+		out:             out,
+		ctx:             ctx,
+		c:               al.c,
+		pending:         map[string]chan *CommandResult{},
+	}
+
+	addAgent(ra)
+
 	defer func() {
 		cancel()
 
+		removeAgent(ra)
+		ra.close()
+
 		c.Close()
 
 		go func() {
@@ -151,9 +209,26 @@ func (al *agentListener) serv(c *conn2) {
 		close(out)
 	}()
 
+	acked := received
+
 	go func() {
+		ticker := time.NewTicker(ackInterval)
+		defer ticker.Stop()
+
 		for {
 			select {
+			case <-ticker.C:
+				seq := atomic.LoadUint64(&received)
+				if seq == acked {
+					continue
+				}
+
+				if err := c.send(Ack{Seq: seq}); err != nil {
+					log.Errorf("Error sending ack: %s", err.Error())
+					return
+				}
+
+				acked = seq
 			case p := <-out:
 				if bm, ok := p.(encoding.BinaryMarshaler); !ok {
 					log.Errorf("Error marshalling object")
@@ -177,6 +252,21 @@ func (al *agentListener) serv(c *conn2) {
 			return
 		}
 
+		if sm, ok := o.(*Sequenced); ok {
+			// messages resent after reconnecting are dropped
+			if !accept(token, sm.Seq) {
+				log.Debugf("Dropped duplicate message %d of agent %s", sm.Seq, token)
+				continue
+			}
+
+			if o, err = sm.Message(); err != nil {
+				log.Errorf("Error decoding sequenced message: %s", err.Error())
+				return
+			}
+
+			atomic.StoreUint64(&received, sm.Seq)
+		}
+
 		switch v := o.(type) {
 		case *Hello:
 			ac := &agentConnection{
@@ -225,6 +315,8 @@ func (al *agentListener) serv(c *conn2) {
 			conns.Delete(conn)
 
 			conn.Close()
+		case *CommandResult:
+			ra.result(v)
 		case *Ping:
 			log.Debugf("Received ping from agent: %s", c.RemoteAddr())
 
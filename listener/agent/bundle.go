@@ -0,0 +1,179 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// Bundle is a ready to deploy agent, with the binary, the configuration to
+// connect to the server and the files to install it as service.
+type Bundle struct {
+	GOOS   string
+	GOARCH string
+
+	// Servers are the addresses of the agent listeners, in order of
+	// preference, the agent fails over to the next server
+	Servers []string
+
+	// RemoteKey is the public key of the agent listener
+	RemoteKey string
+
+	// Token identifies the agent
+	Token string
+
+	// Binary is the path of the agent binary
+	Binary string
+}
+
+// NewToken returns a random agent token.
+func NewToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Build cross compiles the agent in the source directory into the
+// directory, and sets the binary of the bundle.
+func (b *Bundle) Build(source string, dir string) error {
+	binary := filepath.Join(dir, "honeytrap-agent")
+	if b.GOOS == "windows" {
+		binary += ".exe"
+	}
+
+	cmd := exec.Command("go", "build", "-o", binary, ".")
+	cmd.Dir = source
+	cmd.Env = append(os.Environ(),
+		"GOOS="+b.GOOS,
+		"GOARCH="+b.GOARCH,
+		"CGO_ENABLED=0",
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Error building agent: %s: %s", err.Error(), string(output))
+	}
+
+	b.Binary = binary
+	return nil
+}
+
+var configTemplate = template.Must(template.New("config").Parse(`# honeytrap agent {{ .GOOS }}/{{ .GOARCH }}
+servers = [{{ range $i, $s := .Servers }}{{ if $i }}, {{ end }}"{{ $s }}"{{ end }}]
+remote-key = "{{ .RemoteKey }}"
+token = "{{ .Token }}"
+`))
+
+var installTemplate = template.Must(template.New("install").Parse(`#!/bin/sh
+# installs the honeytrap agent as systemd service
+set -e
+
+cd "$(dirname "$0")"
+
+install -m 0755 honeytrap-agent /usr/local/bin/honeytrap-agent
+install -d -m 0750 /etc/honeytrap-agent
+install -m 0640 config.toml /etc/honeytrap-agent/config.toml
+install -m 0644 honeytrap-agent.service /etc/systemd/system/honeytrap-agent.service
+
+systemctl daemon-reload
+systemctl enable --now honeytrap-agent
+`))
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=Honeytrap agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=/usr/local/bin/honeytrap-agent{{ range .Servers }} --server {{ . }}{{ end }} --remote-key {{ .RemoteKey }} --token {{ .Token }}
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func (b *Bundle) render(t *template.Template) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, b); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Write writes the bundle as gzipped tar archive.
+func (b *Bundle) Write(w io.Writer) error {
+	binary, err := ioutil.ReadFile(b.Binary)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		mode int64
+		t    *template.Template
+		data []byte
+	}{
+		{name: filepath.Base(b.Binary), mode: 0755, data: binary},
+		{name: "config.toml", mode: 0640, t: configTemplate},
+		{name: "install.sh", mode: 0755, t: installTemplate},
+		{name: "honeytrap-agent.service", mode: 0644, t: unitTemplate},
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	now := time.Now()
+
+	for _, f := range files {
+		data := f.data
+
+		if f.t != nil {
+			if data, err = b.render(f.t); err != nil {
+				return err
+			}
+		}
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    f.mode,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
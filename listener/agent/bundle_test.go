@@ -0,0 +1,125 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readBundle returns the files and modes of the bundle.
+func readBundle(t *testing.T, r io.Reader) (map[string]string, map[string]int64) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, modes := map[string]string{}, map[string]int64{}
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files[hdr.Name] = string(data)
+		modes[hdr.Name] = hdr.Mode
+	}
+
+	return files, modes
+}
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "honeytrap-agent")
+	if err := ioutil.WriteFile(binary, []byte("agent"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Bundle{
+		GOOS:      "linux",
+		GOARCH:    "arm64",
+		Servers:   []string{"10.0.0.1:1339", "10.0.0.2:1339"},
+		RemoteKey: "key",
+		Token:     "token",
+		Binary:    binary,
+	}
+
+	buf := &bytes.Buffer{}
+	if err := b.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	files, modes := readBundle(t, buf)
+
+	for name, mode := range map[string]int64{
+		"honeytrap-agent":         0755,
+		"config.toml":             0640,
+		"install.sh":              0755,
+		"honeytrap-agent.service": 0644,
+	} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in bundle", name)
+		} else if modes[name] != mode {
+			t.Errorf("Expected mode %o of %s, got %o", mode, name, modes[name])
+		}
+	}
+
+	if files["honeytrap-agent"] != "agent" {
+		t.Errorf("Expected agent binary, got %q", files["honeytrap-agent"])
+	}
+
+	config := files["config.toml"]
+	for _, s := range []string{`servers = ["10.0.0.1:1339", "10.0.0.2:1339"]`, `remote-key = "key"`, `token = "token"`} {
+		if !strings.Contains(config, s) {
+			t.Errorf("Expected %s in config, got %s", s, config)
+		}
+	}
+
+	if unit := files["honeytrap-agent.service"]; !strings.Contains(unit, "--server 10.0.0.1:1339 --server 10.0.0.2:1339 --remote-key key --token token") {
+		t.Errorf("Expected servers, key and token in unit, got %s", unit)
+	}
+}
+
+func TestBundleMissingBinary(t *testing.T) {
+	b := &Bundle{
+		Servers: []string{"10.0.0.1:1339"},
+		Binary:  filepath.Join(os.TempDir(), "honeytrap-agent-missing"),
+	}
+
+	if err := b.Write(&bytes.Buffer{}); !os.IsNotExist(err) {
+		t.Errorf("Expected error for missing binary, got %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/messages"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/rs/xid"
+)
+
+// Commands the server can send to the agents.
+const (
+	CommandEnableService   = "enable-service"
+	CommandDisableService  = "disable-service"
+	CommandUpdateBlocklist = "update-blocklist"
+	CommandFetchTranscript = "fetch-transcript"
+)
+
+// CommandProtocolVersion is the first protocol version of the agents that
+// accept commands, older agents are push only.
+const CommandProtocolVersion = 2
+
+var (
+	ErrAgentNotConnected    = errors.New("Agent not connected")
+	ErrCommandNotAllowed    = errors.New("Command not allowed")
+	ErrCommandsNotSupported = errors.New("Agent doesn't support commands")
+	ErrCommandTimeout       = errors.New("Timeout waiting for command result")
+)
+
+// Command is sent by the server to an agent, the agent replies with a
+// CommandResult with the same id.
+type Command struct {
+	ID   string
+	Name string
+	Args []string
+}
+
+func (c *Command) UnmarshalBinary(data []byte) error {
+	d := NewDecoder(data)
+
+	c.ID = d.ReadString()
+	c.Name = d.ReadString()
+
+	n := d.ReadUint8()
+
+	c.Args = make([]string, n)
+	for i := 0; i < n; i++ {
+		c.Args[i] = d.ReadString()
+	}
+
+	return d.LastError
+}
+
+func (c Command) MarshalBinary() ([]byte, error) {
+	buff := bytes.Buffer{}
+
+	e := NewEncoder(&buff, binary.LittleEndian)
+
+	e.WriteString(c.ID)
+	e.WriteString(c.Name)
+
+	e.WriteUint8(len(c.Args))
+
+	for _, arg := range c.Args {
+		e.WriteString(arg)
+	}
+
+	e.Flush()
+
+	return buff.Bytes(), nil
+}
+
+// CommandResult is the result of a command, Error is set when the command
+// failed.
+type CommandResult struct {
+	ID     string
+	Error  string
+	Output []byte
+}
+
+func (r *CommandResult) UnmarshalBinary(data []byte) error {
+	d := NewDecoder(data)
+
+	r.ID = d.ReadString()
+	r.Error = d.ReadString()
+	r.Output = d.ReadData()
+
+	return d.LastError
+}
+
+func (r CommandResult) MarshalBinary() ([]byte, error) {
+	buff := bytes.Buffer{}
+
+	e := NewEncoder(&buff, binary.LittleEndian)
+
+	e.WriteString(r.ID)
+	e.WriteString(r.Error)
+	e.WriteData(r.Output)
+
+	e.Flush()
+
+	return buff.Bytes(), nil
+}
+
+// remoteAgent is a connected agent commands can be sent to.
+type remoteAgent struct {
+	messages.Agent
+
+	protocolVersion int
+
+	// allowed are the commands allowed by the listener
+	allowed []string
+
+	out chan interface{}
+	ctx context.Context
+
+	c pushers.Channel
+
+	m       sync.Mutex
+	pending map[string]chan *CommandResult
+	closed  bool
+}
+
+func (ra *remoteAgent) isAllowed(name string) bool {
+	for _, a := range ra.allowed {
+		if a == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// audit sends the event of the command, so the commands end up in the
+// channels.
+func (ra *remoteAgent) audit(options ...event.Option) {
+	if ra.c == nil {
+		return
+	}
+
+	ra.c.Send(event.New(append([]event.Option{
+		event.Category("agent"),
+		event.Custom("agent", ra.Token),
+		event.Custom("agent.remote-addr", ra.RemoteAddr),
+	}, options...)...))
+}
+
+// close marks the agent as disconnected, before the out channel is closed.
+func (ra *remoteAgent) close() {
+	ra.m.Lock()
+	defer ra.m.Unlock()
+
+	ra.closed = true
+}
+
+// result delivers the result of a command to the waiting sender.
+func (ra *remoteAgent) result(r *CommandResult) {
+	ra.m.Lock()
+	ch, ok := ra.pending[r.ID]
+	delete(ra.pending, r.ID)
+	ra.m.Unlock()
+
+	if !ok {
+		log.Errorf("Received result of unknown command %s from agent %s", r.ID, ra.Token)
+		return
+	}
+
+	ch <- r
+}
+
+func (ra *remoteAgent) send(cmd Command, timeout time.Duration) (*CommandResult, error) {
+	if ra.protocolVersion < CommandProtocolVersion {
+		return nil, ErrCommandsNotSupported
+	} else if !ra.isAllowed(cmd.Name) {
+		return nil, ErrCommandNotAllowed
+	}
+
+	cmd.ID = xid.New().String()
+
+	ch := make(chan *CommandResult, 1)
+
+	defer func() {
+		ra.m.Lock()
+		delete(ra.pending, cmd.ID)
+		ra.m.Unlock()
+	}()
+
+	log.Infof("Sending command %s %v to agent %s", cmd.Name, cmd.Args, ra.Token)
+
+	ra.audit(
+		event.Type("agent-command"),
+		event.Custom("agent.command.id", cmd.ID),
+		event.Custom("agent.command", cmd.Name),
+		event.Custom("agent.command.args", cmd.Args),
+	)
+
+	// the lock is held while sending, the out channel is closed after
+	// the agent has been marked as closed
+	ra.m.Lock()
+
+	if ra.closed {
+		ra.m.Unlock()
+		return nil, ErrAgentNotConnected
+	}
+
+	ra.pending[cmd.ID] = ch
+
+	select {
+	case ra.out <- cmd:
+	case <-ra.ctx.Done():
+		ra.m.Unlock()
+		return nil, ErrAgentNotConnected
+	}
+
+	ra.m.Unlock()
+
+	select {
+	case r := <-ch:
+		ra.audit(
+			event.Type("agent-command-result"),
+			event.Custom("agent.command.id", cmd.ID),
+			event.Custom("agent.command", cmd.Name),
+			event.Custom("agent.command.error", r.Error),
+		)
+
+		return r, nil
+	case <-time.After(timeout):
+		return nil, ErrCommandTimeout
+	case <-ra.ctx.Done():
+		return nil, ErrAgentNotConnected
+	}
+}
+
+// agents are the connected agents by token.
+var agents = struct {
+	sync.Mutex
+	m map[string]*remoteAgent
+}{
+	m: map[string]*remoteAgent{},
+}
+
+func addAgent(ra *remoteAgent) {
+	agents.Lock()
+	defer agents.Unlock()
+
+	agents.m[ra.Token] = ra
+}
+
+func removeAgent(ra *remoteAgent) {
+	agents.Lock()
+	defer agents.Unlock()
+
+	// the agent could have reconnected already
+	if agents.m[ra.Token] == ra {
+		delete(agents.m, ra.Token)
+	}
+}
+
+// Agents returns the connected agents, ordered by token.
+func Agents() []messages.Agent {
+	agents.Lock()
+	defer agents.Unlock()
+
+	result := []messages.Agent{}
+	for _, ra := range agents.m {
+		result = append(result, ra.Agent)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Token < result[j].Token
+	})
+
+	return result
+}
+
+// Send sends the command to the agent with the token, and waits for the
+// result. The command has to be allowed by the configuration of the
+// listener the agent is connected to.
+func Send(token string, name string, args []string, timeout time.Duration) (*CommandResult, error) {
+	agents.Lock()
+	ra, ok := agents.m[token]
+	agents.Unlock()
+
+	if !ok {
+		return nil, ErrAgentNotConnected
+	}
+
+	return ra.send(Command{
+		Name: name,
+		Args: args,
+	}, timeout)
+}
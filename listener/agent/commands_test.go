@@ -0,0 +1,86 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/messages"
+)
+
+func TestCommandMarshal(t *testing.T) {
+	c := Command{
+		ID:   "1",
+		Name: CommandUpdateBlocklist,
+		Args: []string{"1.2.3.4", "5.6.7.0/24"},
+	}
+
+	data, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Command
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c, decoded) {
+		t.Errorf("Expected %#v, got %#v", c, decoded)
+	}
+}
+
+func TestSend(t *testing.T) {
+	out := make(chan interface{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ra := &remoteAgent{
+		Agent:           messages.Agent{Token: "test"},
+		protocolVersion: CommandProtocolVersion,
+		allowed:         []string{CommandEnableService},
+		out:             out,
+		ctx:             ctx,
+		pending:         map[string]chan *CommandResult{},
+	}
+
+	addAgent(ra)
+	defer removeAgent(ra)
+
+	if _, err := Send("test", CommandFetchTranscript, nil, time.Second); err != ErrCommandNotAllowed {
+		t.Errorf("Expected command not allowed, got %v", err)
+	}
+
+	if _, err := Send("unknown", CommandEnableService, nil, time.Second); err != ErrAgentNotConnected {
+		t.Errorf("Expected agent not connected, got %v", err)
+	}
+
+	go func() {
+		cmd := (<-out).(Command)
+		ra.result(&CommandResult{ID: cmd.ID, Output: []byte(cmd.Args[0])})
+	}()
+
+	result, err := Send("test", CommandEnableService, []string{"ssh"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(result.Output) != "ssh" {
+		t.Errorf("Expected output ssh, got %q", result.Output)
+	}
+}
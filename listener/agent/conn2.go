@@ -22,26 +22,22 @@ import (
 )
 
 func Conn2(c net.Conn) *conn2 {
-	return &conn2{c}
+	return &conn2{Conn: c}
 }
 
 type conn2 struct {
 	net.Conn
+
+	// queue are the remaining messages of a batch
+	queue []interface{}
 }
 
 func (c *conn2) Handshake() error {
 	return nil
 }
 
-func (c *conn2) receive() (interface{}, error) {
-	buff := make([]byte, 1)
-
-	if _, err := c.Conn.Read(buff); err != nil {
-		return nil, err
-	}
-
-	msgType := int(buff[0])
-
+// decodeMessage decodes the message of the type.
+func decodeMessage(msgType int, data []byte) (interface{}, error) {
 	var o encoding.BinaryUnmarshaler
 
 	switch msgType {
@@ -59,10 +55,76 @@ func (c *conn2) receive() (interface{}, error) {
 		o = &Ping{}
 	case TypeEOF:
 		o = &EOF{}
+	case TypeCommand:
+		o = &Command{}
+	case TypeCommandResult:
+		o = &CommandResult{}
+	case TypeBatch:
+		o = &Batch{}
+	case TypeSequenced:
+		o = &Sequenced{}
+	case TypeAck:
+		o = &Ack{}
 	default:
 		return nil, fmt.Errorf("Unsupported message receive type %d", msgType)
 	}
 
+	if err := o.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// messageType returns the type of the message.
+func messageType(o encoding.BinaryMarshaler) (int, error) {
+	switch o.(type) {
+	case Hello:
+		return TypeHello, nil
+	case Handshake:
+		return TypeHandshake, nil
+	case HandshakeResponse:
+		return TypeHandshakeResponse, nil
+	case Ping:
+		return TypePing, nil
+	case ReadWriteTCP:
+		return TypeReadWriteTCP, nil
+	case ReadWriteUDP:
+		return TypeReadWriteUDP, nil
+	case EOF:
+		return TypeEOF, nil
+	case Command:
+		return TypeCommand, nil
+	case CommandResult:
+		return TypeCommandResult, nil
+	case Batch:
+		return TypeBatch, nil
+	case Sequenced:
+		return TypeSequenced, nil
+	case Ack:
+		return TypeAck, nil
+	default:
+		return 0, fmt.Errorf("Unsupported message type send %s", reflect.TypeOf(o))
+	}
+}
+
+// receive returns the next message, the messages of batches are returned
+// one by one.
+func (c *conn2) receive() (interface{}, error) {
+	if len(c.queue) > 0 {
+		o := c.queue[0]
+		c.queue = c.queue[1:]
+		return o, nil
+	}
+
+	buff := make([]byte, 1)
+
+	if _, err := c.Conn.Read(buff); err != nil {
+		return nil, err
+	}
+
+	msgType := int(buff[0])
+
 	buff = make([]byte, 2)
 
 	if _, err := c.Conn.Read(buff); err != nil {
@@ -77,34 +139,34 @@ func (c *conn2) receive() (interface{}, error) {
 		return nil, err
 	}
 
-	if err := o.UnmarshalBinary(buff[:]); err != nil {
+	o, err := decodeMessage(msgType, buff[:])
+	if err != nil {
 		return nil, err
 	}
 
-	return o, nil
+	b, ok := o.(*Batch)
+	if !ok {
+		return o, nil
+	}
+
+	messages, err := b.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	c.queue = messages
+	return c.receive()
 }
 
 func (c conn2) send(o encoding.BinaryMarshaler) error {
-	// write type
-	switch o.(type) {
-	case Hello:
-		c.Conn.Write([]byte{uint8(TypeHello)})
-	case Handshake:
-		c.Conn.Write([]byte{uint8(TypeHandshake)})
-	case HandshakeResponse:
-		c.Conn.Write([]byte{uint8(TypeHandshakeResponse)})
-	case Ping:
-		c.Conn.Write([]byte{uint8(TypePing)})
-	case ReadWriteTCP:
-		c.Conn.Write([]byte{uint8(TypeReadWriteTCP)})
-	case ReadWriteUDP:
-		c.Conn.Write([]byte{uint8(TypeReadWriteUDP)})
-	case EOF:
-		c.Conn.Write([]byte{uint8(TypeEOF)})
-	default:
-		return fmt.Errorf("Unsupported message type send %s", reflect.TypeOf(o))
+	msgType, err := messageType(o)
+	if err != nil {
+		return err
 	}
 
+	// write type
+	c.Conn.Write([]byte{uint8(msgType)})
+
 	data, err := o.MarshalBinary()
 	if err != nil {
 		return err
@@ -16,6 +16,7 @@ package agent
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"net"
 
 	"github.com/honeytrap/protocol"
@@ -47,6 +48,20 @@ func (d *Decoder) ReadData() []byte {
 	return buffer
 }
 
+func (d *Decoder) ReadUint64() uint64 {
+	if d.LastError != nil {
+		return 0
+	}
+
+	buffer := make([]byte, 8)
+	if _, err := io.ReadFull(d, buffer); err != nil {
+		d.LastError = err
+		return 0
+	}
+
+	return binary.LittleEndian.Uint64(buffer)
+}
+
 func (d *Decoder) ReadString() string {
 	if d.LastError != nil {
 		return ""
@@ -70,8 +85,12 @@ func (d *Decoder) ReadAddr() net.Addr {
 
 	proto := d.ReadUint8()
 
-	data := d.ReadData()
-	ip := net.IP(data)
+	var ip net.IP
+
+	switch data := d.ReadData(); len(data) {
+	case net.IPv4len, net.IPv6len:
+		ip = net.IP(data)
+	}
 
 	port := d.ReadUint16()
 
@@ -40,6 +40,12 @@ func (e *Encoder) WriteData(data []byte) {
 	e.Write(data)
 }
 
+func (e *Encoder) WriteUint64(v uint64) {
+	buff := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buff, v)
+	e.Write(buff)
+}
+
 func (e *Encoder) WriteAddr(address net.Addr) {
 	var ip net.IP
 	var port int
@@ -54,6 +60,12 @@ func (e *Encoder) WriteAddr(address net.Addr) {
 		e.WriteUint8(17)
 	}
 
+	// ipv4 addresses are sent in their 4 byte form, the receiving
+	// side distinguishes between ipv4 and ipv6 using the length
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
 	e.WriteData(ip)
 	e.WriteUint16(port)
 }
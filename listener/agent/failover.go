@@ -0,0 +1,271 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+// Endpoint is a server the agents connect to.
+type Endpoint struct {
+	Address string `toml:"address"`
+
+	// Priority orders the servers, the agents prefer the servers with the
+	// lowest priority
+	Priority int `toml:"priority"`
+}
+
+// FailoverConfig configures the servers the agents fail over to when the
+// server they're connected to becomes unavailable, so an outage of one
+// collector doesn't blind the agents. The configuration is sent to the
+// agents with the handshake response.
+type FailoverConfig struct {
+	Servers []Endpoint `toml:"servers"`
+
+	// ProbeInterval is the interval the agents probe the servers while
+	// connected to a less preferred server, to fail back
+	ProbeInterval config.Delay `toml:"probe-interval"`
+
+	// ProbeTimeout is the time a server has to accept the probe
+	ProbeTimeout config.Delay `toml:"probe-timeout"`
+}
+
+const (
+	// maxProbeInterval is the maximum probe interval, the interval is
+	// sent in seconds as uint16.
+	maxProbeInterval = 65535 * time.Second
+
+	// maxProbeTimeout is the maximum probe timeout, the timeout is sent
+	// in milliseconds as uint16.
+	maxProbeTimeout = 65535 * time.Millisecond
+
+	// defaultProbeTimeout is used when no probe timeout is configured.
+	defaultProbeTimeout = 5 * time.Second
+)
+
+// Validate returns an error if the configuration can't be sent to the
+// agents.
+func (c FailoverConfig) Validate() error {
+	if len(c.Servers) > 255 {
+		return fmt.Errorf("Too many failover servers %d, maximum is 255", len(c.Servers))
+	}
+
+	for _, s := range c.Servers {
+		if _, _, err := net.SplitHostPort(s.Address); err != nil {
+			return fmt.Errorf("Invalid failover server %s: %s", s.Address, err.Error())
+		}
+
+		if s.Priority < 0 || s.Priority > 255 {
+			return fmt.Errorf("Invalid priority %d of failover server %s", s.Priority, s.Address)
+		}
+	}
+
+	if d := c.ProbeInterval.Duration(); d < 0 || d > maxProbeInterval {
+		return fmt.Errorf("Invalid failover probe interval %s, maximum is %s", d, maxProbeInterval)
+	}
+
+	if d := c.ProbeTimeout.Duration(); d < 0 || d > maxProbeTimeout {
+		return fmt.Errorf("Invalid failover probe timeout %s, maximum is %s", d, maxProbeTimeout)
+	}
+
+	return nil
+}
+
+func (c FailoverConfig) encode(e *Encoder) {
+	e.WriteUint8(len(c.Servers))
+
+	for _, s := range c.Servers {
+		e.WriteString(s.Address)
+		e.WriteUint8(s.Priority)
+	}
+
+	e.WriteUint16(int(c.ProbeInterval.Duration() / time.Second))
+	e.WriteUint16(int(c.ProbeTimeout.Duration() / time.Millisecond))
+}
+
+func (c *FailoverConfig) decode(d *Decoder) {
+	n := d.ReadUint8()
+
+	c.Servers = make([]Endpoint, 0, n)
+
+	for i := 0; i < n && d.LastError == nil; i++ {
+		address := d.ReadString()
+		priority := d.ReadUint8()
+
+		c.Servers = append(c.Servers, Endpoint{Address: address, Priority: priority})
+	}
+
+	c.ProbeInterval = config.Delay(time.Duration(d.ReadUint16()) * time.Second)
+	c.ProbeTimeout = config.Delay(time.Duration(d.ReadUint16()) * time.Millisecond)
+}
+
+// Probe is the result of probing a server.
+type Probe struct {
+	Endpoint
+
+	Latency time.Duration
+	Err     error
+}
+
+// ErrNoServerAvailable is returned when none of the servers can be
+// reached.
+var ErrNoServerAvailable = errors.New("no server available")
+
+// Failover selects the server an agent connects to. The servers are probed
+// with a tcp connect, and the reachable server with the lowest priority is
+// selected, the server with the lowest latency of servers with the same
+// priority.
+type Failover struct {
+	m sync.Mutex
+
+	servers []Endpoint
+
+	timeout time.Duration
+
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// NewFailover returns the failover of the servers, the servers are
+// preferred in order when no priorities are set.
+func NewFailover(servers ...string) *Failover {
+	f := &Failover{
+		timeout: defaultProbeTimeout,
+		dial:    (&net.Dialer{}).DialContext,
+	}
+
+	for i, s := range servers {
+		f.servers = append(f.servers, Endpoint{Address: s, Priority: i})
+	}
+
+	return f
+}
+
+// Update adds the servers of the configuration received from the server,
+// the priorities of the configuration replace those of known servers.
+func (f *Failover) Update(c FailoverConfig) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if d := c.ProbeTimeout.Duration(); d > 0 {
+		f.timeout = d
+	}
+
+	for _, s := range c.Servers {
+		found := false
+
+		for i := range f.servers {
+			if f.servers[i].Address != s.Address {
+				continue
+			}
+
+			f.servers[i].Priority = s.Priority
+			found = true
+		}
+
+		if !found {
+			f.servers = append(f.servers, s)
+		}
+	}
+}
+
+// Servers returns the servers.
+func (f *Failover) Servers() []Endpoint {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return append([]Endpoint{}, f.servers...)
+}
+
+// Probe probes the servers concurrently, and returns the results in order
+// of preference. Unreachable servers are last.
+func (f *Failover) Probe(ctx context.Context) []Probe {
+	f.m.Lock()
+	servers := append([]Endpoint{}, f.servers...)
+	timeout := f.timeout
+	f.m.Unlock()
+
+	probes := make([]Probe, len(servers))
+
+	wg := sync.WaitGroup{}
+
+	for i, s := range servers {
+		probes[i].Endpoint = s
+
+		wg.Add(1)
+
+		go func(p *Probe) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+
+			conn, err := f.dial(ctx, "tcp", p.Address)
+			if err != nil {
+				p.Err = err
+				return
+			}
+
+			p.Latency = time.Since(start)
+
+			conn.Close()
+		}(&probes[i])
+	}
+
+	wg.Wait()
+
+	sort.SliceStable(probes, func(i, j int) bool {
+		if (probes[i].Err == nil) != (probes[j].Err == nil) {
+			return probes[i].Err == nil
+		}
+
+		if probes[i].Priority != probes[j].Priority {
+			return probes[i].Priority < probes[j].Priority
+		}
+
+		return probes[i].Latency < probes[j].Latency
+	})
+
+	return probes
+}
+
+// Select returns the most preferred reachable server.
+func (f *Failover) Select(ctx context.Context) (Endpoint, error) {
+	probes := f.Probe(ctx)
+	if len(probes) == 0 || probes[0].Err != nil {
+		return Endpoint{}, ErrNoServerAvailable
+	}
+
+	return probes[0].Endpoint, nil
+}
+
+// Preferred returns a reachable server with a lower priority than the
+// current server, the agent fails back to it.
+func (f *Failover) Preferred(ctx context.Context, current Endpoint) (Endpoint, bool) {
+	s, err := f.Select(ctx)
+	if err != nil || s.Priority >= current.Priority {
+		return Endpoint{}, false
+	}
+
+	return s, true
+}
@@ -0,0 +1,121 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+func TestHandshakeResponseFailover(t *testing.T) {
+	hr := HandshakeResponse{
+		Failover: FailoverConfig{
+			Servers: []Endpoint{
+				{Address: "eu.example.com:1339", Priority: 0},
+				{Address: "[2001:db8::1]:1339", Priority: 1},
+			},
+			ProbeInterval: config.Delay(5 * time.Minute),
+			ProbeTimeout:  config.Delay(3 * time.Second),
+		},
+	}
+
+	data, err := hr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded HandshakeResponse
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded.Failover, hr.Failover) {
+		t.Errorf("Expected %#v, got %#v", hr.Failover, decoded.Failover)
+	}
+}
+
+func TestFailoverConfigValidate(t *testing.T) {
+	tests := []FailoverConfig{
+		{Servers: []Endpoint{{Address: "example.com"}}},
+		{Servers: []Endpoint{{Address: "example.com:1339", Priority: 256}}},
+		{ProbeTimeout: config.Delay(2 * time.Minute)},
+	}
+
+	for _, c := range tests {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected error for %#v", c)
+		}
+	}
+}
+
+func TestFailoverSelect(t *testing.T) {
+	latency := map[string]time.Duration{
+		"primary:1339": -1,
+		"near:1339":    10 * time.Millisecond,
+		"far:1339":     100 * time.Millisecond,
+	}
+
+	f := NewFailover("primary:1339")
+	f.Update(FailoverConfig{
+		Servers: []Endpoint{
+			{Address: "far:1339", Priority: 1},
+			{Address: "near:1339", Priority: 1},
+		},
+	})
+
+	f.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		d := latency[address]
+		if d < 0 {
+			return nil, errors.New("connection refused")
+		}
+
+		time.Sleep(d)
+
+		c, _ := net.Pipe()
+		return c, nil
+	}
+
+	primary := Endpoint{Address: "primary:1339", Priority: 0}
+
+	// the primary server is down, the nearest server is selected
+	if s, err := f.Select(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if s.Address != "near:1339" {
+		t.Errorf("Expected near:1339, got %s", s.Address)
+	}
+
+	if _, ok := f.Preferred(context.Background(), Endpoint{Address: "near:1339", Priority: 1}); ok {
+		t.Errorf("Expected no preferred server while the primary is down")
+	}
+
+	latency["primary:1339"] = 200 * time.Millisecond
+
+	if s, ok := f.Preferred(context.Background(), Endpoint{Address: "near:1339", Priority: 1}); !ok || s != primary {
+		t.Errorf("Expected fail back to %v, got %v", primary, s)
+	}
+
+	for k := range latency {
+		latency[k] = -1
+	}
+
+	if _, err := f.Select(context.Background()); err != ErrNoServerAvailable {
+		t.Errorf("Expected ErrNoServerAvailable, got %v", err)
+	}
+}
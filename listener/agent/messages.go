@@ -27,6 +27,11 @@ const (
 	TypeEOF               int = 0x04
 	TypePing              int = 0x05
 	TypeReadWriteUDP      int = 0x06
+	TypeCommand           int = 0x07
+	TypeCommandResult     int = 0x08
+	TypeBatch             int = 0x09
+	TypeSequenced         int = 0x0a
+	TypeAck               int = 0x0b
 )
 
 type Handshake struct {
@@ -38,6 +43,10 @@ type Handshake struct {
 	Version string
 
 	Token string
+
+	// Session identifies the process of the agent, it follows the token
+	// and changes when the agent restarts, older agents don't send it
+	Session uint64
 }
 
 func (hs *Handshake) UnmarshalBinary(data []byte) error {
@@ -47,6 +56,7 @@ func (hs *Handshake) UnmarshalBinary(data []byte) error {
 	hs.ShortCommitID = d.ReadString()
 	hs.CommitID = d.ReadString()
 	hs.Token = d.ReadString()
+	hs.Session = d.ReadUint64()
 	return nil
 }
 
@@ -61,12 +71,25 @@ func (hs Handshake) MarshalBinary() ([]byte, error) {
 	e.WriteString(hs.CommitID)
 
 	e.WriteString(hs.Token)
+	e.WriteUint64(hs.Session)
+
+	e.Flush()
 
 	return buff.Bytes(), nil
 }
 
 type HandshakeResponse struct {
 	Addresses []net.Addr
+
+	// Uplink follows the addresses, older agents ignore it
+	Uplink UplinkConfig
+
+	// Failover follows the uplink, older agents ignore it
+	Failover FailoverConfig
+
+	// Sequence is the last sequence number received of the agent, the
+	// agent resends its unacknowledged messages after it
+	Sequence uint64
 }
 
 func (h *HandshakeResponse) UnmarshalBinary(data []byte) error {
@@ -79,6 +102,10 @@ func (h *HandshakeResponse) UnmarshalBinary(data []byte) error {
 		h.Addresses[i] = d.ReadAddr()
 	}
 
+	h.Uplink.decode(d)
+	h.Failover.decode(d)
+	h.Sequence = d.ReadUint64()
+
 	return nil
 }
 
@@ -93,6 +120,10 @@ func (h HandshakeResponse) MarshalBinary() ([]byte, error) {
 		e.WriteAddr(address)
 	}
 
+	h.Uplink.encode(e)
+	h.Failover.encode(e)
+	e.WriteUint64(h.Sequence)
+
 	e.Flush()
 
 	return buff.Bytes(), nil
@@ -0,0 +1,298 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SequenceProtocolVersion is the first protocol version of the agents that
+// send their messages sequenced, the server acknowledges the messages and
+// drops the messages resent after reconnecting.
+const SequenceProtocolVersion = 3
+
+// ackInterval is the interval the received messages are acknowledged.
+const ackInterval = time.Second
+
+// Sequenced wraps a message of the agent with its sequence number. The
+// sequence numbers of an agent increase by one for each message, across
+// connections.
+type Sequenced struct {
+	Seq uint64
+
+	Type int
+	Data []byte
+}
+
+// NewSequenced returns the message wrapped with the sequence number.
+func NewSequenced(seq uint64, m encoding.BinaryMarshaler) (*Sequenced, error) {
+	msgType, err := messageType(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// sequenced messages can't be nested, and batches contain sequenced
+	// messages instead
+	if msgType == TypeSequenced || msgType == TypeBatch {
+		return nil, fmt.Errorf("Unsupported message type %d in sequenced message", msgType)
+	}
+
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sequenced{Seq: seq, Type: msgType, Data: data}, nil
+}
+
+// Message returns the wrapped message.
+func (s *Sequenced) Message() (interface{}, error) {
+	if s.Type == TypeSequenced || s.Type == TypeBatch {
+		return nil, fmt.Errorf("Unsupported message type %d in sequenced message", s.Type)
+	}
+
+	return decodeMessage(s.Type, s.Data)
+}
+
+func (s *Sequenced) UnmarshalBinary(data []byte) error {
+	d := NewDecoder(data)
+
+	s.Seq = d.ReadUint64()
+	s.Type = d.ReadUint8()
+	s.Data = d.ReadData()
+
+	return d.LastError
+}
+
+func (s Sequenced) MarshalBinary() ([]byte, error) {
+	buff := bytes.Buffer{}
+
+	e := NewEncoder(&buff, binary.LittleEndian)
+
+	e.WriteUint64(s.Seq)
+	e.WriteUint8(s.Type)
+	e.WriteData(s.Data)
+
+	e.Flush()
+
+	return buff.Bytes(), nil
+}
+
+// Ack acknowledges the messages of the agent up to and including the
+// sequence number, the agent doesn't have to resend these.
+type Ack struct {
+	Seq uint64
+}
+
+func (a *Ack) UnmarshalBinary(data []byte) error {
+	d := NewDecoder(data)
+
+	a.Seq = d.ReadUint64()
+
+	return d.LastError
+}
+
+func (a Ack) MarshalBinary() ([]byte, error) {
+	buff := bytes.Buffer{}
+
+	e := NewEncoder(&buff, binary.LittleEndian)
+
+	e.WriteUint64(a.Seq)
+
+	e.Flush()
+
+	return buff.Bytes(), nil
+}
+
+// sequence is the last sequence number received of the session of an
+// agent.
+type sequence struct {
+	session uint64
+	last    uint64
+}
+
+// sequences are the last sequence numbers received of the agents, by
+// token, they survive reconnects of the agents.
+var sequences = struct {
+	sync.Mutex
+	m map[string]sequence
+}{
+	m: map[string]sequence{},
+}
+
+// resume returns the last sequence number received of the session of the
+// agent. A restarted agent has a new session and starts its sequence
+// numbers again, the sequence of the previous session is forgotten.
+func resume(token string, session uint64) uint64 {
+	sequences.Lock()
+	defer sequences.Unlock()
+
+	s := sequences.m[token]
+	if s.session != session {
+		s = sequence{session: session}
+		sequences.m[token] = s
+	}
+
+	return s.last
+}
+
+// lastSequence returns the last sequence number received of the agent.
+func lastSequence(token string) uint64 {
+	sequences.Lock()
+	defer sequences.Unlock()
+
+	return sequences.m[token].last
+}
+
+// accept returns true if the message with the sequence number hasn't been
+// received before, and records it as received. Gaps are accepted, the
+// agent dropped the messages.
+func accept(token string, seq uint64) bool {
+	sequences.Lock()
+	defer sequences.Unlock()
+
+	s := sequences.m[token]
+
+	if seq <= s.last {
+		return false
+	}
+
+	if seq > s.last+1 {
+		log.Warningf("Missed %d messages of agent %s", seq-s.last-1, token)
+	}
+
+	s.last = seq
+	sequences.m[token] = s
+	return true
+}
+
+// Sequencer sequences the messages of an agent, and keeps the messages
+// until acknowledged to resend them after reconnecting. The oldest
+// messages are dropped when more than max are unacknowledged.
+type Sequencer struct {
+	// Session identifies the sequence, the agent sends it in the handshake
+	Session uint64
+
+	m sync.Mutex
+
+	seq     uint64
+	pending []*Sequenced
+
+	max int
+
+	// Dropped is the number of messages dropped unacknowledged
+	Dropped uint64
+}
+
+// NewSequencer returns a sequencer keeping at most max unacknowledged
+// messages.
+func NewSequencer(max int) *Sequencer {
+	session := make([]byte, 8)
+	rand.Read(session)
+
+	return &Sequencer{
+		Session: binary.LittleEndian.Uint64(session),
+		max:     max,
+	}
+}
+
+// Next returns the message with the next sequence number.
+func (s *Sequencer) Next(m encoding.BinaryMarshaler) (*Sequenced, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	sm, err := NewSequenced(s.seq+1, m)
+	if err != nil {
+		return nil, err
+	}
+
+	s.seq++
+
+	s.pending = append(s.pending, sm)
+
+	if len(s.pending) > s.max {
+		s.Dropped += uint64(len(s.pending) - s.max)
+		s.pending = s.pending[len(s.pending)-s.max:]
+	}
+
+	return sm, nil
+}
+
+// Ack drops the acknowledged messages.
+func (s *Sequencer) Ack(seq uint64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	i := 0
+	for i < len(s.pending) && s.pending[i].Seq <= seq {
+		i++
+	}
+
+	s.pending = s.pending[i:]
+}
+
+// Pending returns the unacknowledged messages, to resend after
+// reconnecting.
+func (s *Sequencer) Pending() []*Sequenced {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]*Sequenced{}, s.pending...)
+}
+
+// Resume resumes the sequence after the handshake, the server received the
+// messages up to seq, and returns the messages to resend. The tcp
+// connections are closed when the agent disconnects, the messages of the
+// tcp connections aren't resent.
+func (s *Sequencer) Resume(seq uint64) []*Sequenced {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// the server received more messages than were sequenced, of a previous
+	// process of the agent, the messages are numbered after them
+	renumber := s.seq < seq
+
+	pending := []*Sequenced{}
+
+	for _, sm := range s.pending {
+		if sm.Seq <= seq && !renumber {
+			continue
+		}
+
+		switch sm.Type {
+		case TypeHello, TypeReadWriteTCP, TypeEOF:
+			continue
+		}
+
+		if renumber {
+			sm = &Sequenced{Seq: seq + uint64(len(pending)) + 1, Type: sm.Type, Data: sm.Data}
+		}
+
+		pending = append(pending, sm)
+	}
+
+	if renumber {
+		s.seq = seq + uint64(len(pending))
+	}
+
+	s.pending = pending
+
+	return append([]*Sequenced{}, pending...)
+}
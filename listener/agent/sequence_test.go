@@ -0,0 +1,242 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"encoding"
+	"net"
+	"testing"
+)
+
+func TestSequenced(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	laddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	raddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 31337}
+
+	s := NewSequencer(10)
+
+	m1, err := s.Next(Hello{Laddr: laddr, Raddr: raddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := s.Next(ReadWriteTCP{Laddr: laddr, Raddr: raddr, Payload: []byte("root\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBatch(true, *m1, *m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go Conn2(client).send(*b)
+
+	c := Conn2(server)
+
+	for i, expected := range []uint64{1, 2} {
+		o, err := c.receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sm, ok := o.(*Sequenced)
+		if !ok || sm.Seq != expected {
+			t.Fatalf("Expected sequenced message %d, got %#v", expected, o)
+		}
+
+		m, err := sm.Message()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := m.(*Hello); i == 0 && !ok {
+			t.Errorf("Expected hello, got %#v", m)
+		} else if rw, ok := m.(*ReadWriteTCP); i == 1 && (!ok || string(rw.Payload) != "root\n") {
+			t.Errorf("Expected payload, got %#v", m)
+		}
+	}
+
+	if _, err := NewSequenced(1, *m1); err == nil {
+		t.Errorf("Expected error for nested sequenced message")
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	s := NewSequencer(3)
+
+	for i := 0; i < 5; i++ {
+		if _, err := s.Next(Ping{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the oldest unacknowledged messages are dropped
+	if pending := s.Pending(); len(pending) != 3 || pending[0].Seq != 3 || s.Dropped != 2 {
+		t.Fatalf("Expected messages 3 to 5 pending, got %d messages, %d dropped", len(pending), s.Dropped)
+	}
+
+	s.Ack(4)
+
+	if pending := s.Pending(); len(pending) != 1 || pending[0].Seq != 5 {
+		t.Errorf("Expected message 5 pending, got %d messages", len(pending))
+	}
+}
+
+func TestAccept(t *testing.T) {
+	token := NewToken()
+
+	for _, tc := range []struct {
+		seq      uint64
+		accepted bool
+	}{
+		{1, true},
+		{2, true},
+		// resent after reconnecting
+		{1, false},
+		{2, false},
+		{3, true},
+		// gap
+		{5, true},
+		{4, false},
+	} {
+		if accepted := accept(token, tc.seq); accepted != tc.accepted {
+			t.Errorf("Expected accepted %t for message %d, got %t", tc.accepted, tc.seq, accepted)
+		}
+	}
+
+	if seq := lastSequence(token); seq != 5 {
+		t.Errorf("Expected last sequence 5, got %d", seq)
+	}
+
+	hr := HandshakeResponse{Sequence: 1 << 40}
+
+	data, err := hr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded HandshakeResponse
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Sequence != hr.Sequence {
+		t.Errorf("Expected sequence %d, got %d", hr.Sequence, decoded.Sequence)
+	}
+}
+
+func TestAgentRestart(t *testing.T) {
+	token := NewToken()
+
+	agent := NewSequencer(10)
+
+	hs := Handshake{Token: token, Session: agent.Session}
+
+	data, err := hs.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Handshake
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Session != agent.Session {
+		t.Fatalf("Expected session %d, got %d", agent.Session, decoded.Session)
+	}
+
+	if seq := resume(token, decoded.Session); seq != 0 {
+		t.Fatalf("Expected no messages received, got %d", seq)
+	}
+
+	for i := 0; i < 3; i++ {
+		sm, err := agent.Next(Ping{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		accept(token, sm.Seq)
+	}
+
+	// reconnecting resumes the sequence of the session
+	if seq := resume(token, agent.Session); seq != 3 {
+		t.Fatalf("Expected 3 messages received, got %d", seq)
+	}
+
+	// the restarted agent starts its sequence again
+	restarted := NewSequencer(10)
+
+	sm, err := restarted.Next(Hello{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seq := resume(token, restarted.Session)
+	if seq != 0 {
+		t.Fatalf("Expected the sequence of the restarted agent to be reset, got %d", seq)
+	}
+
+	restarted.Resume(seq)
+
+	if !accept(token, sm.Seq) {
+		t.Errorf("Expected the hello of the restarted agent to be accepted")
+	}
+}
+
+func TestSequencerResume(t *testing.T) {
+	laddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	raddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 31337}
+
+	s := NewSequencer(10)
+
+	for _, m := range []encoding.BinaryMarshaler{
+		Ping{},
+		Hello{Laddr: laddr, Raddr: raddr},
+		ReadWriteTCP{Laddr: laddr, Raddr: raddr, Payload: []byte("root\n")},
+		ReadWriteUDP{Laddr: laddr, Raddr: raddr, Payload: []byte("query")},
+		EOF{Laddr: laddr, Raddr: raddr},
+	} {
+		if _, err := s.Next(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the acknowledged messages and the messages of the tcp connections,
+	// closed on disconnect, aren't resent
+	pending := s.Resume(1)
+	if len(pending) != 1 || pending[0].Seq != 4 || pending[0].Type != TypeReadWriteUDP {
+		t.Fatalf("Expected the udp message to be resent, got %#v", pending)
+	}
+
+	// the server received more messages of a previous process of the agent,
+	// the sequence continues after them
+	pending = s.Resume(100)
+	if len(pending) != 1 || pending[0].Seq != 101 {
+		t.Fatalf("Expected the udp message to be renumbered, got %#v", pending)
+	}
+
+	sm, err := s.Next(Ping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sm.Seq != 102 {
+		t.Errorf("Expected sequence 102, got %d", sm.Seq)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+// UplinkConfig configures how the agents send their messages to the
+// server, the configuration is sent to the agents with the handshake
+// response. Batching and jitter spread the load of many agents, and avoid
+// the regular beacons that would reveal the agents.
+type UplinkConfig struct {
+	// BatchSize is the maximum number of messages in a batch, messages
+	// are sent one by one when not set
+	BatchSize int `toml:"batch-size"`
+
+	// FlushInterval is the maximum time messages are held in a batch
+	FlushInterval config.Delay `toml:"flush-interval"`
+
+	// Jitter is the maximum random delay added to the flushes and pings
+	Jitter config.Delay `toml:"jitter"`
+
+	// Compress compresses the batches
+	Compress bool `toml:"compress"`
+}
+
+// maxUplinkDelay is the maximum flush interval and jitter, the delays are
+// sent in milliseconds as uint16.
+const maxUplinkDelay = 65535 * time.Millisecond
+
+// Validate returns an error if the configuration can't be sent to the
+// agents.
+func (c UplinkConfig) Validate() error {
+	if c.BatchSize < 0 || c.BatchSize > 65535 {
+		return fmt.Errorf("Invalid uplink batch size %d", c.BatchSize)
+	}
+
+	if d := c.FlushInterval.Duration(); d < 0 || d > maxUplinkDelay {
+		return fmt.Errorf("Invalid uplink flush interval %s, maximum is %s", d, maxUplinkDelay)
+	}
+
+	if d := c.Jitter.Duration(); d < 0 || d > maxUplinkDelay {
+		return fmt.Errorf("Invalid uplink jitter %s, maximum is %s", d, maxUplinkDelay)
+	}
+
+	return nil
+}
+
+func (c UplinkConfig) encode(e *Encoder) {
+	e.WriteUint16(c.BatchSize)
+	e.WriteUint16(int(c.FlushInterval.Duration() / time.Millisecond))
+	e.WriteUint16(int(c.Jitter.Duration() / time.Millisecond))
+
+	if c.Compress {
+		e.WriteUint8(1)
+	} else {
+		e.WriteUint8(0)
+	}
+}
+
+func (c *UplinkConfig) decode(d *Decoder) {
+	c.BatchSize = d.ReadUint16()
+	c.FlushInterval = config.Delay(time.Duration(d.ReadUint16()) * time.Millisecond)
+	c.Jitter = config.Delay(time.Duration(d.ReadUint16()) * time.Millisecond)
+	c.Compress = d.ReadUint8() == 1
+}
+
+// Batch contains multiple messages of the agent, each message is framed
+// as type, length and data like the messages on the connection.
+type Batch struct {
+	Compressed bool
+
+	Data []byte
+}
+
+// maxBatchSize limits the size of decompressed batches.
+const maxBatchSize = 4 * 1024 * 1024
+
+func (b *Batch) UnmarshalBinary(data []byte) error {
+	d := NewDecoder(data)
+
+	b.Compressed = d.ReadUint8() == 1
+	b.Data = d.ReadData()
+
+	return d.LastError
+}
+
+func (b Batch) MarshalBinary() ([]byte, error) {
+	buff := bytes.Buffer{}
+
+	e := NewEncoder(&buff, binary.LittleEndian)
+
+	if b.Compressed {
+		e.WriteUint8(1)
+	} else {
+		e.WriteUint8(0)
+	}
+
+	e.WriteData(b.Data)
+
+	e.Flush()
+
+	return buff.Bytes(), nil
+}
+
+// NewBatch returns a batch of the messages.
+func NewBatch(compress bool, messages ...encoding.BinaryMarshaler) (*Batch, error) {
+	buff := bytes.Buffer{}
+
+	for _, m := range messages {
+		msgType, err := messageType(m)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := m.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		buff.WriteByte(uint8(msgType))
+		binary.Write(&buff, binary.LittleEndian, uint16(len(data)))
+		buff.Write(data)
+	}
+
+	if !compress {
+		return &Batch{Data: buff.Bytes()}, nil
+	}
+
+	compressed := bytes.Buffer{}
+
+	w, err := flate.NewWriter(&compressed, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(buff.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return &Batch{Compressed: true, Data: compressed.Bytes()}, nil
+}
+
+// Messages returns the messages of the batch.
+func (b *Batch) Messages() ([]interface{}, error) {
+	data := b.Data
+
+	if b.Compressed {
+		r := flate.NewReader(bytes.NewReader(b.Data))
+		defer r.Close()
+
+		var err error
+		if data, err = ioutil.ReadAll(io.LimitReader(r, maxBatchSize+1)); err != nil {
+			return nil, err
+		} else if len(data) > maxBatchSize {
+			return nil, fmt.Errorf("Batch exceeds maximum size of %d bytes", maxBatchSize)
+		}
+	}
+
+	messages := []interface{}{}
+
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		msgType := int(data[0])
+		size := int(binary.LittleEndian.Uint16(data[1:3]))
+
+		if len(data) < 3+size {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		// batches can't be nested
+		if msgType == TypeBatch {
+			return nil, fmt.Errorf("Unsupported message type %d in batch", msgType)
+		}
+
+		o, err := decodeMessage(msgType, data[3:3+size])
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, o)
+
+		data = data[3+size:]
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+func TestBatch(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	laddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	raddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 31337}
+
+	for _, compress := range []bool{false, true} {
+		b, err := NewBatch(compress,
+			Hello{Laddr: laddr, Raddr: raddr},
+			ReadWriteTCP{Laddr: laddr, Raddr: raddr, Payload: []byte("SSH-2.0-OpenSSH\r\n")},
+			Ping{},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go Conn2(client).send(*b)
+
+		c := Conn2(server)
+
+		if o, err := c.receive(); err != nil {
+			t.Fatal(err)
+		} else if h, ok := o.(*Hello); !ok || h.Raddr.String() != raddr.String() {
+			t.Fatalf("Expected hello, got %#v", o)
+		}
+
+		if o, err := c.receive(); err != nil {
+			t.Fatal(err)
+		} else if rw, ok := o.(*ReadWriteTCP); !ok || string(rw.Payload) != "SSH-2.0-OpenSSH\r\n" {
+			t.Fatalf("Expected payload, got %#v", o)
+		}
+
+		if o, err := c.receive(); err != nil {
+			t.Fatal(err)
+		} else if _, ok := o.(*Ping); !ok {
+			t.Fatalf("Expected ping, got %#v", o)
+		}
+	}
+}
+
+func TestHandshakeResponseUplink(t *testing.T) {
+	hr := HandshakeResponse{
+		Addresses: []net.Addr{&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}},
+		Uplink: UplinkConfig{
+			BatchSize:     64,
+			FlushInterval: config.Delay(2 * time.Second),
+			Jitter:        config.Delay(500 * time.Millisecond),
+			Compress:      true,
+		},
+	}
+
+	data, err := hr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded HandshakeResponse
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Uplink != hr.Uplink || len(decoded.Addresses) != 1 {
+		t.Errorf("Expected %#v, got %#v", hr, decoded)
+	}
+}
@@ -0,0 +1,648 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nfqueue
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/listener"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// netfilter queue constants, see linux/netfilter/nfnetlink_queue.h
+const (
+	nfnlSubsysQueue = 3
+
+	nfqnlMsgPacket  = 0
+	nfqnlMsgVerdict = 1
+	nfqnlMsgConfig  = 2
+
+	nfqaPacketHdr  = 1
+	nfqaVerdictHdr = 2
+	nfqaMark       = 3
+	nfqaPayload    = 10
+
+	nfqaCfgCmd    = 1
+	nfqaCfgParams = 2
+
+	nfqnlCfgCmdBind   = 1
+	nfqnlCfgCmdUnbind = 2
+
+	nfqnlCopyPacket = 2
+
+	nfDrop   = 0
+	nfAccept = 1
+
+	ipTransparent = 19
+)
+
+const (
+	// flowTimeout is the time without packets after which a flow is
+	// reported
+	flowTimeout = 5 * time.Second
+
+	// maxFlows limits the flows that are tracked, packets of new flows
+	// are not reported while the limit is reached
+	maxFlows = 4096
+
+	// recvTimeout is the interval the queue checks for shutdown
+	recvTimeout = time.Second
+)
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	v := uint16(1)
+	if *(*byte)(unsafe.Pointer(&v)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+type nfqueueConfig struct {
+	Addresses []net.Addr
+
+	// Queue is the netfilter queue number to bind to, matching the
+	// --queue-num argument of the iptables NFQUEUE target.
+	Queue uint16 `toml:"queue"`
+
+	// Mark will be set on accepted packets destined for configured
+	// ports, so they can be redirected to honeytrap (eg. using TPROXY).
+	Mark uint32 `toml:"mark"`
+
+	// Verdict is the verdict for packets not destined for configured
+	// ports, either "drop" (default) or "accept".
+	Verdict string `toml:"verdict"`
+}
+
+func (nc *nfqueueConfig) AddAddress(a net.Addr) {
+	nc.Addresses = append(nc.Addresses, a)
+}
+
+type nfqueueListener struct {
+	nfqueueConfig
+
+	ch chan net.Conn
+
+	eb pushers.Channel
+
+	fd  int
+	seq uint32
+
+	m       sync.Mutex
+	flows   map[string]*flow
+	dropped int
+
+	now func() time.Time
+}
+
+// flow contains the packets of a source to a destination that isn't a
+// configured port, the flows are reported instead of every packet.
+type flow struct {
+	options []event.Option
+
+	packets int
+	bytes   int
+
+	start time.Time
+	last  time.Time
+}
+
+func (l *nfqueueListener) SetChannel(eb pushers.Channel) {
+	l.eb = eb
+}
+
+// New will return a listener which receives packets from a netfilter queue.
+func New(options ...func(listener.Listener) error) (listener.Listener, error) {
+	l := nfqueueListener{
+		nfqueueConfig: nfqueueConfig{
+			Verdict: "drop",
+		},
+		eb:    pushers.MustDummy(),
+		ch:    make(chan net.Conn),
+		flows: map[string]*flow{},
+		now:   time.Now,
+	}
+
+	for _, option := range options {
+		if err := option(&l); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.Verdict != "drop" && l.Verdict != "accept" {
+		return nil, fmt.Errorf("Unknown verdict %s, expected drop or accept", l.Verdict)
+	}
+
+	return &l, nil
+}
+
+// transparent sets IP_TRANSPARENT on the socket, allowing connections for
+// non local addresses to be accepted when redirected using TPROXY.
+func transparent(network, address string, c syscall.RawConn) error {
+	var serr error
+
+	if err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, ipTransparent, 1)
+	}); err != nil {
+		return err
+	}
+
+	return serr
+}
+
+func (l *nfqueueListener) listen(ctx context.Context) {
+	lc := net.ListenConfig{
+		Control: transparent,
+	}
+
+	for _, address := range l.Addresses {
+		if _, ok := address.(*net.TCPAddr); ok {
+			ln, err := lc.Listen(ctx, address.Network(), address.String())
+			if err != nil {
+				log.Errorf("Error starting listener: %s", err.Error())
+				continue
+			}
+
+			log.Infof("Listener started: tcp/%s", address)
+
+			go func() {
+				for {
+					c, err := ln.Accept()
+					if err != nil {
+						log.Errorf("Error accepting connection: %s", err.Error())
+						continue
+					}
+
+					l.ch <- c
+				}
+			}()
+		} else if _, ok := address.(*net.UDPAddr); ok {
+			pc, err := lc.ListenPacket(ctx, address.Network(), address.String())
+			if err != nil {
+				log.Errorf("Error starting listener: %s", err.Error())
+				continue
+			}
+
+			log.Infof("Listener started: udp/%s", address)
+
+			uc := pc.(*net.UDPConn)
+
+			go func() {
+				for {
+					var buf [65535]byte
+
+					n, raddr, err := uc.ReadFromUDP(buf[:])
+					if err != nil {
+						log.Error("Error reading udp:", err.Error())
+						continue
+					}
+
+					l.ch <- &listener.DummyUDPConn{
+						Buffer: buf[:n],
+						Laddr:  uc.LocalAddr(),
+						Raddr:  raddr,
+						Fn:     uc.WriteToUDP,
+					}
+				}
+			}()
+		}
+	}
+}
+
+// attr encodes a netlink attribute, padded to 4 bytes.
+func attr(typ uint16, data []byte) []byte {
+	b := make([]byte, (4+len(data)+3)&^3)
+	nativeEndian.PutUint16(b[0:2], uint16(4+len(data)))
+	nativeEndian.PutUint16(b[2:4], typ)
+	copy(b[4:], data)
+	return b
+}
+
+// message encodes a nfnetlink queue message with the given attributes.
+func (l *nfqueueListener) message(typ uint16, flags uint16, family uint8, attrs ...[]byte) []byte {
+	l.seq++
+
+	b := make([]byte, syscall.NLMSG_HDRLEN+4)
+	nativeEndian.PutUint16(b[4:6], nfnlSubsysQueue<<8|typ)
+	nativeEndian.PutUint16(b[6:8], syscall.NLM_F_REQUEST|flags)
+	nativeEndian.PutUint32(b[8:12], l.seq)
+
+	// nfgenmsg
+	b[16] = family
+	b[17] = 0 // NFNETLINK_V0
+	binary.BigEndian.PutUint16(b[18:20], l.Queue)
+
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+
+	nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	return b
+}
+
+// request sends the message and waits for the acknowledgement.
+func (l *nfqueueListener) request(msg []byte) error {
+	if err := syscall.Sendto(l.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+
+	n, _, err := syscall.Recvfrom(l.fd, buf, 0)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	for _, m := range msgs {
+		if m.Header.Type != syscall.NLMSG_ERROR || len(m.Data) < 4 {
+			continue
+		}
+
+		if errno := int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+			return syscall.Errno(-errno)
+		}
+	}
+
+	return nil
+}
+
+func (l *nfqueueListener) verdict(id uint32, verdict uint32, mark uint32) error {
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint32(hdr[0:4], verdict)
+	binary.BigEndian.PutUint32(hdr[4:8], id)
+
+	attrs := [][]byte{attr(nfqaVerdictHdr, hdr)}
+
+	if mark != 0 {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, mark)
+		attrs = append(attrs, attr(nfqaMark, b))
+	}
+
+	msg := l.message(nfqnlMsgVerdict, 0, syscall.AF_UNSPEC, attrs...)
+	return syscall.Sendto(l.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+// Start binds to the netfilter queue and starts the configured listeners.
+func (l *nfqueueListener) Start(ctx context.Context) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("Could not create netlink socket: %s", err.Error())
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("Could not bind netlink socket: %s", err.Error())
+	}
+
+	l.fd = fd
+
+	// the receive times out, so the queue can be stopped while no packets
+	// arrive
+	tv := syscall.NsecToTimeval(int64(recvTimeout))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("Could not set timeout of netlink socket: %s", err.Error())
+	}
+
+	// bind to queue
+	cmd := []byte{nfqnlCfgCmdBind, 0, 0, 0}
+	if err := l.request(l.message(nfqnlMsgConfig, syscall.NLM_F_ACK, syscall.AF_UNSPEC, attr(nfqaCfgCmd, cmd))); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("Could not bind to queue %d: %s", l.Queue, err.Error())
+	}
+
+	// copy complete packets
+	params := make([]byte, 5)
+	binary.BigEndian.PutUint32(params[0:4], 0xffff)
+	params[4] = nfqnlCopyPacket
+
+	if err := l.request(l.message(nfqnlMsgConfig, syscall.NLM_F_ACK, syscall.AF_UNSPEC, attr(nfqaCfgParams, params))); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("Could not configure queue %d: %s", l.Queue, err.Error())
+	}
+
+	log.Infof("Bound to netfilter queue %d", l.Queue)
+
+	l.listen(ctx)
+
+	go l.run(ctx)
+	go l.report(ctx)
+
+	return nil
+}
+
+func (l *nfqueueListener) run(ctx context.Context) {
+	defer func() {
+		cmd := []byte{nfqnlCfgCmdUnbind, 0, 0, 0}
+		msg := l.message(nfqnlMsgConfig, 0, syscall.AF_UNSPEC, attr(nfqaCfgCmd, cmd))
+		syscall.Sendto(l.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+
+		syscall.Close(l.fd)
+	}()
+
+	buf := make([]byte, 0xffff+syscall.Getpagesize())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		n, _, err := syscall.Recvfrom(l.fd, buf, 0)
+		if err == syscall.EINTR || err == syscall.EAGAIN {
+			continue
+		} else if err == syscall.ENOBUFS {
+			log.Warning("Netfilter queue overrun, packets have been dropped")
+			continue
+		} else if err != nil {
+			log.Errorf("Error receiving from netfilter queue: %s", err.Error())
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			log.Errorf("Error parsing netlink message: %s", err.Error())
+			continue
+		}
+
+		for _, m := range msgs {
+			if m.Header.Type != nfnlSubsysQueue<<8|nfqnlMsgPacket {
+				continue
+			}
+
+			l.handle(m.Data)
+		}
+	}
+}
+
+// parsePacket returns the id and payload of the queued packet message.
+func parsePacket(data []byte) (id uint32, payload []byte, ok bool) {
+	if len(data) < 4 {
+		return 0, nil, false
+	}
+
+	// skip nfgenmsg
+	for b := data[4:]; len(b) >= 4; {
+		alen := int(nativeEndian.Uint16(b[0:2]))
+		atype := nativeEndian.Uint16(b[2:4]) & 0x3fff
+
+		if alen < 4 || alen > len(b) {
+			break
+		}
+
+		switch atype {
+		case nfqaPacketHdr:
+			if alen >= 8 {
+				id = binary.BigEndian.Uint32(b[4:8])
+				ok = true
+			}
+		case nfqaPayload:
+			payload = b[4:alen]
+		}
+
+		if alen = (alen + 3) &^ 3; alen > len(b) {
+			break
+		}
+
+		b = b[alen:]
+	}
+
+	return id, payload, ok
+}
+
+// handle parses the queued packet and sets the verdict.
+func (l *nfqueueListener) handle(data []byte) {
+	id, payload, ok := parsePacket(data)
+	if !ok {
+		return
+	}
+
+	verdict := uint32(nfDrop)
+	if l.Verdict == "accept" {
+		verdict = nfAccept
+	}
+
+	mark := uint32(0)
+
+	if addr := l.inspect(payload); addr == nil {
+	} else if l.isConfigured(addr) {
+		verdict = nfAccept
+		mark = l.Mark
+	}
+
+	if err := l.verdict(id, verdict, mark); err != nil {
+		log.Errorf("Error setting verdict for packet %d: %s", id, err.Error())
+	}
+}
+
+// inspect decodes the packet, adds packets not destined for configured
+// ports to their flow and returns the destination address.
+func (l *nfqueueListener) inspect(payload []byte) net.Addr {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	var first gopacket.LayerType
+	switch payload[0] >> 4 {
+	case 4:
+		first = layers.LayerTypeIPv4
+	case 6:
+		first = layers.LayerTypeIPv6
+	default:
+		return nil
+	}
+
+	// the flow outlives the receive buffer, the packet is copied
+	packet := gopacket.NewPacket(payload, first, gopacket.Default)
+
+	var src, dst net.IP
+	if ip4, ok := packet.NetworkLayer().(*layers.IPv4); ok {
+		src, dst = ip4.SrcIP, ip4.DstIP
+	} else if ip6, ok := packet.NetworkLayer().(*layers.IPv6); ok {
+		src, dst = ip6.SrcIP, ip6.DstIP
+	} else {
+		return nil
+	}
+
+	var srcAddr, dstAddr net.Addr
+	if tcp, ok := packet.TransportLayer().(*layers.TCP); ok {
+		srcAddr = &net.TCPAddr{IP: src, Port: int(tcp.SrcPort)}
+		dstAddr = &net.TCPAddr{IP: dst, Port: int(tcp.DstPort)}
+	} else if udp, ok := packet.TransportLayer().(*layers.UDP); ok {
+		srcAddr = &net.UDPAddr{IP: src, Port: int(udp.SrcPort)}
+		dstAddr = &net.UDPAddr{IP: dst, Port: int(udp.DstPort)}
+	}
+
+	if dstAddr != nil && l.isConfigured(dstAddr) {
+		return dstAddr
+	}
+
+	key := fmt.Sprintf("%s>%s", src, dst)
+	if srcAddr != nil {
+		key = fmt.Sprintf("%s/%s>%s", dstAddr.Network(), srcAddr, dstAddr)
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	now := l.now()
+
+	if f, ok := l.flows[key]; ok {
+		f.packets++
+		f.bytes += len(payload)
+		f.last = now
+		return dstAddr
+	} else if len(l.flows) >= maxFlows {
+		l.dropped++
+		return dstAddr
+	}
+
+	// the flow is reported with the payload of its first packet
+	options := []event.Option{
+		SensorNfqueue,
+		EventCategoryPacket,
+		event.SourceIP(src),
+		event.DestinationIP(dst),
+		event.Custom("nfqueue.verdict", l.Verdict),
+	}
+
+	if app := packet.ApplicationLayer(); app != nil {
+		options = append(options, event.Payload(app.Payload()))
+	}
+
+	if srcAddr != nil {
+		options = append(options,
+			event.Protocol(dstAddr.Network()),
+			event.SourceAddr(srcAddr),
+			event.DestinationAddr(dstAddr),
+		)
+	}
+
+	l.flows[key] = &flow{
+		options: options,
+		packets: 1,
+		bytes:   len(payload),
+		start:   now,
+		last:    now,
+	}
+
+	return dstAddr
+}
+
+// report reports the flows that ended, until the listener is stopped.
+func (l *nfqueueListener) report(ctx context.Context) {
+	t := time.NewTicker(flowTimeout)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.flush(true)
+			return
+		case <-t.C:
+			l.flush(false)
+		}
+	}
+}
+
+// flush sends the events of the flows without packets for the flow
+// timeout, or all flows.
+func (l *nfqueueListener) flush(all bool) {
+	l.m.Lock()
+
+	now := l.now()
+
+	events := []event.Event{}
+
+	for key, f := range l.flows {
+		if !all && now.Sub(f.last) < flowTimeout {
+			continue
+		}
+
+		events = append(events, event.New(append(f.options,
+			event.Custom("nfqueue.packets", f.packets),
+			event.Custom("nfqueue.bytes", f.bytes),
+			event.Custom("nfqueue.duration", f.last.Sub(f.start).Seconds()),
+		)...))
+
+		delete(l.flows, key)
+	}
+
+	dropped := l.dropped
+	l.dropped = 0
+
+	l.m.Unlock()
+
+	if dropped > 0 {
+		log.Warningf("Flow limit reached, %d packets of new flows have not been reported", dropped)
+	}
+
+	for _, e := range events {
+		l.eb.Send(e)
+	}
+}
+
+// isConfigured returns true if the address matches one of the configured
+// addresses, addresses without ip will match any destination ip.
+func (l *nfqueueListener) isConfigured(addr net.Addr) bool {
+	for _, a := range l.Addresses {
+		if a.Network() != addr.Network() {
+			continue
+		}
+
+		var ip, dip net.IP
+		var port, dport int
+
+		switch v := a.(type) {
+		case *net.TCPAddr:
+			ip, port = v.IP, v.Port
+			dip, dport = addr.(*net.TCPAddr).IP, addr.(*net.TCPAddr).Port
+		case *net.UDPAddr:
+			ip, port = v.IP, v.Port
+			dip, dport = addr.(*net.UDPAddr).IP, addr.(*net.UDPAddr).Port
+		}
+
+		if port != dport {
+			continue
+		}
+
+		if ip == nil || ip.IsUnspecified() || ip.Equal(dip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *nfqueueListener) Accept() (net.Conn, error) {
+	c := <-l.ch
+	return c, nil
+}
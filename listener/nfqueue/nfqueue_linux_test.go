@@ -0,0 +1,231 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nfqueue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type eventChannel chan event.Event
+
+func (c eventChannel) Send(e event.Event) {
+	c <- e
+}
+
+func newListener(t *testing.T, events eventChannel, now *time.Time) *nfqueueListener {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nl := l.(*nfqueueListener)
+	nl.SetChannel(events)
+	nl.AddAddress(&net.TCPAddr{Port: 22})
+
+	nl.now = func() time.Time {
+		return *now
+	}
+
+	return nl
+}
+
+// tcpPacket returns an ipv4 tcp packet.
+func tcpPacket(t *testing.T, src string, srcPort, dstPort int, payload []byte) []byte {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP(src).To4(),
+		DstIP:    net.ParseIP("192.0.2.1").To4(),
+	}
+
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		PSH:     true,
+		ACK:     true,
+		Window:  1024,
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestAttr(t *testing.T) {
+	b := attr(nfqaMark, []byte{1, 2, 3, 4, 5})
+
+	if len(b) != 12 {
+		t.Fatalf("Expected attribute padded to 12 bytes, got %d", len(b))
+	}
+
+	if n := nativeEndian.Uint16(b[0:2]); n != 9 {
+		t.Errorf("Expected length 9, got %d", n)
+	}
+
+	if typ := nativeEndian.Uint16(b[2:4]); typ != nfqaMark {
+		t.Errorf("Expected type %d, got %d", nfqaMark, typ)
+	}
+
+	if !bytes.Equal(b[4:9], []byte{1, 2, 3, 4, 5}) || !bytes.Equal(b[9:], []byte{0, 0, 0}) {
+		t.Errorf("Unexpected data and padding %x", b[4:])
+	}
+}
+
+func TestMessage(t *testing.T) {
+	l := &nfqueueListener{nfqueueConfig: nfqueueConfig{Queue: 7}}
+
+	a := attr(nfqaCfgCmd, []byte{nfqnlCfgCmdBind, 0, 0, 0})
+	b := l.message(nfqnlMsgConfig, syscall.NLM_F_ACK, syscall.AF_UNSPEC, a)
+
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(msgs))
+	}
+
+	m := msgs[0]
+	if m.Header.Type != nfnlSubsysQueue<<8|nfqnlMsgConfig {
+		t.Errorf("Unexpected type %x", m.Header.Type)
+	}
+
+	if m.Header.Flags != syscall.NLM_F_REQUEST|syscall.NLM_F_ACK || m.Header.Seq != 1 {
+		t.Errorf("Unexpected flags %x or sequence %d", m.Header.Flags, m.Header.Seq)
+	}
+
+	if queue := binary.BigEndian.Uint16(m.Data[2:4]); queue != 7 {
+		t.Errorf("Expected queue 7, got %d", queue)
+	}
+
+	if !bytes.Equal(m.Data[4:], a) {
+		t.Errorf("Expected attribute %x, got %x", a, m.Data[4:])
+	}
+
+	if l.message(nfqnlMsgVerdict, 0, syscall.AF_UNSPEC); l.seq != 2 {
+		t.Errorf("Expected sequence to increase")
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	hdr := make([]byte, 7)
+	binary.BigEndian.PutUint32(hdr[0:4], 42)
+
+	payload := []byte("packet")
+
+	data := append([]byte{syscall.AF_INET, 0, 0, 0}, attr(nfqaPacketHdr, hdr)...)
+	data = append(data, attr(nfqaPayload, payload)...)
+
+	id, p, ok := parsePacket(data)
+	if !ok || id != 42 || !bytes.Equal(p, payload) {
+		t.Errorf("Expected packet 42 with payload, got %d %q %t", id, p, ok)
+	}
+
+	// attributes with invalid lengths end the parsing
+	data = append([]byte{syscall.AF_INET, 0, 0, 0}, 0xff, 0xff, 0, 0)
+	if _, _, ok := parsePacket(data); ok {
+		t.Errorf("Expected packet without header to be ignored")
+	}
+
+	if _, _, ok := parsePacket([]byte{0}); ok {
+		t.Errorf("Expected truncated message to be ignored")
+	}
+}
+
+func TestFlows(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := make(eventChannel, 10)
+
+	l := newListener(t, events, &now)
+
+	// configured ports are not reported
+	if addr := l.inspect(tcpPacket(t, "198.51.100.7", 40000, 22, nil)); addr == nil || addr.String() != "192.0.2.1:22" {
+		t.Errorf("Expected configured address, got %v", addr)
+	}
+
+	for i := 0; i < 3; i++ {
+		l.inspect(tcpPacket(t, "198.51.100.7", 40001, 8080, []byte("GET / HTTP/1.0\r\n\r\n")))
+		now = now.Add(time.Second)
+	}
+
+	l.inspect(tcpPacket(t, "198.51.100.8", 40001, 8080, nil))
+
+	if len(l.flows) != 2 {
+		t.Fatalf("Expected 2 flows, got %d", len(l.flows))
+	}
+
+	// the second flow hasn't timed out yet
+	now = now.Add(flowTimeout - time.Second)
+	l.flush(false)
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+
+	e := <-events
+
+	values := map[interface{}]interface{}{}
+	e.Range(func(k, v interface{}) bool {
+		values[k] = v
+		return true
+	})
+
+	if e.Get("source-ip") != "198.51.100.7" || values["destination-port"] != 8080 {
+		t.Errorf("Expected flow of 198.51.100.7 to 8080, got %s %v", e.Get("source-ip"), values["destination-port"])
+	}
+
+	if e.Get("payload") != "GET / HTTP/1.0\r\n\r\n" {
+		t.Errorf("Expected payload of first packet, got %q", e.Get("payload"))
+	}
+
+	if values["nfqueue.packets"] != 3 {
+		t.Errorf("Expected 3 packets, got %v", values["nfqueue.packets"])
+	}
+
+	l.flush(true)
+
+	if len(events) != 1 || len(l.flows) != 0 {
+		t.Errorf("Expected all flows to be reported, got %d events and %d flows", len(events), len(l.flows))
+	}
+}
+
+func TestFlowLimit(t *testing.T) {
+	now := time.Now()
+	events := make(eventChannel, 10)
+
+	l := newListener(t, events, &now)
+
+	for i := 0; i < maxFlows+5; i++ {
+		l.inspect(tcpPacket(t, "198.51.100.7", 1024+i, 8080, nil))
+	}
+
+	if len(l.flows) != maxFlows || l.dropped != 5 {
+		t.Errorf("Expected %d flows and 5 dropped packets, got %d and %d", maxFlows, len(l.flows), l.dropped)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"net"
 
 	"github.com/fatih/color"
+	"github.com/honeytrap/honeytrap/fingerprint"
 	"github.com/honeytrap/honeytrap/listener"
 	logging "github.com/op/go-logging"
 )
@@ -39,6 +40,10 @@ type socketListener struct {
 
 type socketConfig struct {
 	Addresses []net.Addr
+
+	// Interface restricts wildcard addresses to the addresses of
+	// the named network interface.
+	Interface string `toml:"interface"`
 }
 
 func (sc *socketConfig) AddAddress(a net.Addr) {
@@ -60,10 +65,89 @@ func New(options ...func(listener.Listener) error) (listener.Listener, error) {
 	return &l, nil
 }
 
-func (sl *socketListener) Start(ctx context.Context) error {
+// interfaceAddrs returns the ip addresses of the configured interface.
+func (sl *socketListener) interfaceAddrs() ([]net.IP, error) {
+	ifi, err := net.InterfaceByName(sl.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []net.IP{}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		// link local addresses can't be bound without a zone
+		if ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		ips = append(ips, ipnet.IP)
+	}
+
+	return ips, nil
+}
+
+// bindAddresses returns the addresses to bind, when an interface has been
+// configured, wildcard addresses are expanded into the interface addresses.
+func (sl *socketListener) bindAddresses() ([]net.Addr, error) {
+	if sl.Interface == "" {
+		return sl.Addresses, nil
+	}
+
+	ips, err := sl.interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []net.Addr{}
 	for _, address := range sl.Addresses {
+		switch a := address.(type) {
+		case *net.TCPAddr:
+			if a.IP != nil && !a.IP.IsUnspecified() {
+				addresses = append(addresses, a)
+				continue
+			}
+
+			for _, ip := range ips {
+				addresses = append(addresses, &net.TCPAddr{IP: ip, Port: a.Port})
+			}
+		case *net.UDPAddr:
+			if a.IP != nil && !a.IP.IsUnspecified() {
+				addresses = append(addresses, a)
+				continue
+			}
+
+			for _, ip := range ips {
+				addresses = append(addresses, &net.UDPAddr{IP: ip, Port: a.Port})
+			}
+		}
+	}
+
+	return addresses, nil
+}
+
+func (sl *socketListener) Start(ctx context.Context) error {
+	addresses, err := sl.bindAddresses()
+	if err != nil {
+		return fmt.Errorf("error resolving addresses of interface %s: %s", sl.Interface, err.Error())
+	}
+
+	// the syn packets are saved for fingerprinting the attackers
+	lc := net.ListenConfig{
+		Control: fingerprint.SaveSYN,
+	}
+
+	for _, address := range addresses {
 		if _, ok := address.(*net.TCPAddr); ok {
-			l, err := net.Listen(address.Network(), address.String())
+			l, err := lc.Listen(ctx, address.Network(), address.String())
 			if err != nil {
 				fmt.Println(color.RedString("Error starting listener: %s", err.Error()))
 				continue
@@ -0,0 +1,438 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package metrics maintains counters, gauges and histograms of honeytrap and
+// exposes them in the Prometheus text format, so honeytrap can be scraped by
+// Prometheus.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the registry the metrics of honeytrap are registered with.
+var Default = NewRegistry()
+
+// collector writes the samples of a metric.
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry contains the registered metrics.
+type Registry struct {
+	m          sync.Mutex
+	names      map[string]struct{}
+	collectors []collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		names: map[string]struct{}{},
+	}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.names[name]; ok {
+		panic(fmt.Sprintf("Metric %s already registered", name))
+	}
+
+	r.names[name] = struct{}{}
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.m.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.m.Unlock()
+
+	cw := &countingWriter{w: w}
+
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// desc describes a metric and its labels.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) header(w io.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, d.typ)
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// series returns the name of the series with the label values, extra
+// labels are appended as name and value pairs.
+func (d desc) series(suffix string, values []string, extra ...string) string {
+	pairs := []string{}
+
+	for i, l := range d.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, labelReplacer.Replace(values[i])))
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelReplacer.Replace(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return d.name + suffix
+	}
+
+	return d.name + suffix + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// vec keeps the children of a metric by label values.
+type vec struct {
+	desc
+
+	m        sync.Mutex
+	children map[string]interface{}
+	values   map[string][]string
+}
+
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{
+		desc: desc{
+			name:   name,
+			help:   help,
+			typ:    typ,
+			labels: labels,
+		},
+		children: map[string]interface{}{},
+		values:   map[string][]string{},
+	}
+}
+
+// child returns the child of the label values, created by fn if it doesn't
+// exist yet.
+func (v *vec) child(values []string, fn func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("Metric %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	if c, ok := v.children[key]; ok {
+		return c
+	}
+
+	c := fn()
+	v.children[key] = c
+	v.values[key] = append([]string{}, values...)
+	return c
+}
+
+// each calls fn for the children, ordered by label values.
+func (v *vec) each(fn func(values []string, c interface{})) {
+	type entry struct {
+		key    string
+		values []string
+		c      interface{}
+	}
+
+	v.m.Lock()
+	entries := make([]entry, 0, len(v.children))
+	for k, c := range v.children {
+		entries = append(entries, entry{k, v.values[k], c})
+	}
+	v.m.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	for _, e := range entries {
+		fn(e.values, e.c)
+	}
+}
+
+// Counter is a value that only increases.
+type Counter struct {
+	v uint64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+// Add increments the counter by n.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+// Value returns the value of the counter.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec registers a counter with the registry.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{newVec(name, help, "counter", labels)}
+	r.register(name, cv)
+	return cv
+}
+
+// NewCounterVec registers a counter with the default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// With returns the counter of the label values.
+func (cv *CounterVec) With(values ...string) *Counter {
+	return cv.child(values, func() interface{} {
+		return &Counter{}
+	}).(*Counter)
+}
+
+func (cv *CounterVec) write(w io.Writer) {
+	cv.header(w)
+
+	cv.each(func(values []string, c interface{}) {
+		fmt.Fprintf(w, "%s %d\n", cv.series("", values), c.(*Counter).Value())
+	})
+}
+
+// Gauge is a value that can go up and down, or is read from a function when
+// the metrics are collected.
+type Gauge struct {
+	m  sync.Mutex
+	v  float64
+	fn func() float64
+}
+
+// Set sets the value of the gauge.
+func (g *Gauge) Set(v float64) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	g.v = v
+}
+
+// Add adds v to the value of the gauge.
+func (g *Gauge) Add(v float64) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	g.v += v
+}
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// SetFunc reads the value of the gauge from fn when the metrics are
+// collected.
+func (g *Gauge) SetFunc(fn func() float64) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	g.fn = fn
+}
+
+// Value returns the value of the gauge.
+func (g *Gauge) Value() float64 {
+	g.m.Lock()
+	fn := g.fn
+	v := g.v
+	g.m.Unlock()
+
+	if fn != nil {
+		return fn()
+	}
+
+	return v
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec registers a gauge with the registry.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	gv := &GaugeVec{newVec(name, help, "gauge", labels)}
+	r.register(name, gv)
+	return gv
+}
+
+// NewGaugeVec registers a gauge with the default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// With returns the gauge of the label values.
+func (gv *GaugeVec) With(values ...string) *Gauge {
+	return gv.child(values, func() interface{} {
+		return &Gauge{}
+	}).(*Gauge)
+}
+
+func (gv *GaugeVec) write(w io.Writer) {
+	gv.header(w)
+
+	gv.each(func(values []string, c interface{}) {
+		fmt.Fprintf(w, "%s %s\n", gv.series("", values), formatFloat(c.(*Gauge).Value()))
+	})
+}
+
+// DefaultBuckets are the upper bounds of the buckets of histograms of
+// durations in seconds.
+var DefaultBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	m sync.Mutex
+
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// Observe adds the value to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	// the counts are made cumulative when written
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram with the registry, the buckets are
+// the sorted upper bounds of the buckets.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	hv := &HistogramVec{
+		vec:     newVec(name, help, "histogram", labels),
+		buckets: buckets,
+	}
+
+	r.register(name, hv)
+	return hv
+}
+
+// NewHistogramVec registers a histogram with the default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// With returns the histogram of the label values.
+func (hv *HistogramVec) With(values ...string) *Histogram {
+	return hv.child(values, func() interface{} {
+		return &Histogram{
+			buckets: hv.buckets,
+			counts:  make([]uint64, len(hv.buckets)),
+		}
+	}).(*Histogram)
+}
+
+func (hv *HistogramVec) write(w io.Writer) {
+	hv.header(w)
+
+	hv.each(func(values []string, c interface{}) {
+		h := c.(*Histogram)
+
+		h.m.Lock()
+		defer h.m.Unlock()
+
+		cumulative := uint64(0)
+		for i, le := range h.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s %d\n", hv.series("_bucket", values, "le", formatFloat(le)), cumulative)
+		}
+
+		fmt.Fprintf(w, "%s %d\n", hv.series("_bucket", values, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s %s\n", hv.series("_sum", values), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s %d\n", hv.series("_count", values), h.count)
+	})
+}
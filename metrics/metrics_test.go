@@ -0,0 +1,95 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	events := r.NewCounterVec("test_events_total", "Number of events.", "service")
+	events.With("ssh").Inc()
+	events.With("ssh").Add(2)
+	events.With(`"http"`).Inc()
+
+	containers := r.NewGaugeVec("test_containers", "Number of containers.", "director")
+	containers.With("lxc").SetFunc(func() float64 {
+		return 4
+	})
+
+	durations := r.NewHistogramVec("test_duration_seconds", "Duration.", []float64{1, 10}, "service")
+	durations.With("ssh").Observe(0.5)
+	durations.With("ssh").Observe(5)
+	durations.With("ssh").Observe(50)
+
+	buf := bytes.Buffer{}
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"# HELP test_events_total Number of events.",
+		"# TYPE test_events_total counter",
+		`test_events_total{service="\"http\""} 1`,
+		`test_events_total{service="ssh"} 3`,
+		"# TYPE test_containers gauge",
+		`test_containers{director="lxc"} 4`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{service="ssh",le="1"} 1`,
+		`test_duration_seconds_bucket{service="ssh",le="10"} 2`,
+		`test_duration_seconds_bucket{service="ssh",le="+Inf"} 3`,
+		`test_duration_seconds_sum{service="ssh"} 55.5`,
+		`test_duration_seconds_count{service="ssh"} 3`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic registering duplicate metric")
+		}
+	}()
+
+	r.NewGaugeVec("test_total", "Test.")
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeVec("test_gauge", "Test.").With().Set(1.5)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %s", ct)
+	}
+
+	if !strings.Contains(w.Body.String(), "test_gauge 1.5\n") {
+		t.Errorf("Unexpected output:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package personality contains the personalities of a sensor, profiles of
+// a complete host that configure the banners, hostnames and certificates of
+// all services consistently. A personality only provides defaults, the
+// configuration of a service takes precedence.
+package personality
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// placeholder is replaced by the hostname in the values of the services.
+const placeholder = "{hostname}"
+
+// Personality describes the imitated host.
+type Personality struct {
+	Name        string
+	Description string
+
+	// Hostname is the default hostname of the host
+	Hostname string
+
+	// CommonName is the common name of generated tls certificates
+	CommonName string
+
+	// CertificateProfile is the profile of generated tls certificates, eg.
+	// iis
+	CertificateProfile string
+
+	// Ports are the ports the host usually has open, eg. tcp/22
+	Ports []string
+
+	// Services contains the defaults of the configuration per service type
+	Services map[string]map[string]interface{}
+}
+
+var personalities = map[string]*Personality{}
+
+// Register registers the personality.
+func Register(p *Personality) *Personality {
+	personalities[p.Name] = p
+	return p
+}
+
+// Get returns the personality with the name.
+func Get(name string) (*Personality, error) {
+	p, ok := personalities[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown personality %s", name)
+	}
+
+	return p, nil
+}
+
+// Names returns the names of the registered personalities.
+func Names() []string {
+	names := []string{}
+	for name := range personalities {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// WithHostname returns a copy of the personality with the hostname, the
+// default hostname is kept when empty.
+func (p *Personality) WithHostname(hostname string) *Personality {
+	c := *p
+
+	if hostname != "" {
+		c.Hostname = hostname
+	}
+
+	return &c
+}
+
+func (p *Personality) expand(s string) string {
+	return strings.Replace(s, placeholder, p.Hostname, -1)
+}
+
+// TLSCommonName returns the common name for generated certificates.
+func (p *Personality) TLSCommonName() string {
+	return p.expand(p.CommonName)
+}
+
+// Defaults returns the configuration defaults of the service type, false
+// is returned if the service type doesn't belong to the personality.
+func (p *Personality) Defaults(serviceType string) (map[string]interface{}, bool) {
+	values, ok := p.Services[serviceType]
+	if !ok {
+		return nil, false
+	}
+
+	defaults := map[string]interface{}{}
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			v = p.expand(s)
+		}
+
+		defaults[k] = v
+	}
+
+	return defaults, true
+}
+
+// HasPort returns true if the host usually has the port open.
+func (p *Personality) HasPort(proto string, port int) bool {
+	s := fmt.Sprintf("%s/%d", proto, port)
+
+	for _, v := range p.Ports {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package personality
+
+import (
+	"testing"
+)
+
+func TestPersonality(t *testing.T) {
+	p, err := Get("windows-server-2019")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p = p.WithHostname("FILESRV")
+
+	defaults, ok := p.Defaults("rdp")
+	if !ok {
+		t.Fatal("Expected defaults for rdp")
+	}
+
+	if defaults["computer"] != "FILESRV" {
+		t.Errorf("Expected hostname in defaults, got %v", defaults["computer"])
+	}
+
+	if p.TLSCommonName() != "FILESRV" {
+		t.Errorf("Expected hostname as common name, got %s", p.TLSCommonName())
+	}
+
+	if _, ok := p.Defaults("telnet"); ok {
+		t.Error("Expected telnet not to match a windows server")
+	}
+
+	if !p.HasPort("tcp", 3389) || p.HasPort("tcp", 23) {
+		t.Error("Unexpected ports of personality")
+	}
+
+	// the registered personality is left untouched
+	if orig, _ := Get("windows-server-2019"); orig.Hostname != "WIN-SRV01" {
+		t.Errorf("Expected default hostname, got %s", orig.Hostname)
+	}
+}
+
+func TestUnknownPersonality(t *testing.T) {
+	if _, err := Get("unknown"); err == nil {
+		t.Error("Expected error for unknown personality")
+	}
+}
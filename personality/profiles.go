@@ -0,0 +1,120 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package personality
+
+// ssh returns the defaults for all ssh service types.
+func ssh(services map[string]map[string]interface{}, values map[string]interface{}) map[string]map[string]interface{} {
+	for _, t := range []string{"ssh-simulator", "ssh-jail", "ssh-auth", "ssh-proxy"} {
+		services[t] = values
+	}
+
+	return services
+}
+
+var (
+	_ = Register(&Personality{
+		Name:        "ubuntu-20.04-web",
+		Description: "Ubuntu 20.04 web server",
+		Hostname:    "web01",
+		CommonName:  placeholder,
+		Ports:       []string{"tcp/21", "tcp/22", "tcp/25", "tcp/80", "tcp/443", "tcp/6379"},
+		Services: ssh(map[string]map[string]interface{}{
+			"http": {
+				"server": "Apache/2.4.41 (Ubuntu)",
+			},
+			"https": {
+				"server": "Apache/2.4.41 (Ubuntu)",
+			},
+			"ftp": {
+				"banner": "(vsFTPd 3.0.3)",
+				"name":   "vsFTPd",
+			},
+			"smtp": {
+				"banner-fmt": "{{.Host}} {{.Name}}",
+				"host":       placeholder,
+				"name":       "ESMTP Postfix (Ubuntu)",
+			},
+			"redis": {
+				"version": "5.0.7",
+				"os":      "Linux 5.4.0-109-generic x86_64",
+			},
+		}, map[string]interface{}{
+			"banner": "SSH-2.0-OpenSSH_8.2p1 Ubuntu-4ubuntu0.5",
+			"motd":   "Welcome to Ubuntu 20.04.4 LTS (GNU/Linux 5.4.0-109-generic x86_64)\n\n",
+		}),
+	})
+
+	_ = Register(&Personality{
+		Name:               "windows-server-2019",
+		Description:        "Windows Server 2019 with IIS and remote desktop",
+		Hostname:           "WIN-SRV01",
+		CommonName:         placeholder,
+		CertificateProfile: "iis",
+		Ports:              []string{"tcp/21", "tcp/22", "tcp/25", "tcp/80", "tcp/443", "tcp/3389", "tcp/5985"},
+		Services: ssh(map[string]map[string]interface{}{
+			"http": {
+				"server":        "Microsoft-IIS/10.0",
+				"ntlm":          true,
+				"ntlm-domain":   "CORP",
+				"ntlm-computer": placeholder,
+			},
+			"https": {
+				"server":              "Microsoft-IIS/10.0",
+				"ntlm":                true,
+				"ntlm-domain":         "CORP",
+				"ntlm-computer":       placeholder,
+				"certificate-profile": "iis",
+			},
+			"rdp": {
+				"domain":   "CORP",
+				"computer": placeholder,
+			},
+			"ftp": {
+				"banner": "Microsoft FTP Service",
+				"name":   "Microsoft FTP Service",
+			},
+			"smtp": {
+				"banner-fmt": "{{.Host}} {{.Name}}",
+				"host":       placeholder,
+				"name":       "Microsoft ESMTP MAIL Service ready",
+			},
+		}, map[string]interface{}{
+			"banner": "SSH-2.0-OpenSSH_for_Windows_7.7",
+			"motd":   "Microsoft Windows [Version 10.0.17763.1879]\n(c) 2018 Microsoft Corporation. All rights reserved.\n\n",
+		}),
+	})
+
+	_ = Register(&Personality{
+		Name:        "hikvision-camera",
+		Description: "Hikvision IP camera",
+		Hostname:    "IPCamera",
+		CommonName:  placeholder,
+		Ports:       []string{"tcp/22", "tcp/23", "tcp/80", "tcp/443", "tcp/554", "tcp/8000"},
+		Services: ssh(map[string]map[string]interface{}{
+			"http": {
+				"server": "App-webs/",
+			},
+			"https": {
+				"server": "App-webs/",
+			},
+			"telnet": {
+				"motd":   "",
+				"prompt": "# ",
+			},
+		}, map[string]interface{}{
+			"banner": "SSH-2.0-dropbear_2014.63",
+			"motd":   "",
+		}),
+	})
+)
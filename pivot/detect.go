@@ -0,0 +1,604 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pivot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Detect detects the proxy protocols at the start of a message: socks4,
+// socks5, http connect and http requests of absolute urls.
+func Detect(p []byte) []Attempt {
+	if a, ok := socks4(p); ok {
+		return []Attempt{a}
+	}
+
+	if a, ok := socks5(p); ok {
+		return []Attempt{a}
+	}
+
+	if a, ok := httpProxy(p); ok {
+		return []Attempt{a}
+	}
+
+	return nil
+}
+
+// socks4 detects the connect and bind requests of socks4 and socks4a.
+func socks4(p []byte) (Attempt, bool) {
+	if len(p) < 9 || p[0] != 4 || (p[1] != 1 && p[1] != 2) {
+		return Attempt{}, false
+	}
+
+	port := strconv.Itoa(int(binary.BigEndian.Uint16(p[2:4])))
+	ip := net.IP(p[4:8])
+
+	// the user id is null terminated
+	i := bytes.IndexByte(p[8:], 0)
+	if i < 0 {
+		return Attempt{}, false
+	}
+
+	rest := p[8+i+1:]
+
+	// socks4a has an ip address of 0.0.0.x, the host name follows the
+	// user id
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		j := bytes.IndexByte(rest, 0)
+		if j < 0 {
+			return Attempt{}, false
+		}
+
+		return Attempt{Method: "socks4", Target: net.JoinHostPort(string(rest[:j]), port)}, true
+	}
+
+	if len(rest) != 0 {
+		return Attempt{}, false
+	}
+
+	return Attempt{Method: "socks4", Target: net.JoinHostPort(ip.String(), port)}, true
+}
+
+// socks5 detects the requests of socks5, and the method selection that
+// precedes them.
+func socks5(p []byte) (Attempt, bool) {
+	if len(p) < 3 || p[0] != 5 {
+		return Attempt{}, false
+	}
+
+	if target, ok := socks5Request(p); ok {
+		return Attempt{Method: "socks5", Target: target}, true
+	}
+
+	n := int(p[1])
+	if n == 0 || len(p) < 2+n {
+		return Attempt{}, false
+	}
+
+	for _, method := range p[2 : 2+n] {
+		// no authentication, gssapi, username/password and the iana
+		// assigned methods, or the private methods
+		if method > 0x09 && (method < 0x80 || method == 0xff) {
+			return Attempt{}, false
+		}
+	}
+
+	if len(p) == 2+n {
+		return Attempt{Method: "socks5"}, true
+	}
+
+	// clients can send the request without waiting for the method
+	// selection
+	if target, ok := socks5Request(p[2+n:]); ok {
+		return Attempt{Method: "socks5", Target: target}, true
+	}
+
+	return Attempt{}, false
+}
+
+func socks5Request(p []byte) (string, bool) {
+	if len(p) < 4 || p[0] != 5 || p[1] < 1 || p[1] > 3 || p[2] != 0 {
+		return "", false
+	}
+
+	var host string
+
+	addr := p[4:]
+
+	switch p[3] {
+	case 1:
+		if len(addr) != 4+2 {
+			return "", false
+		}
+
+		host, addr = net.IP(addr[:4]).String(), addr[4:]
+	case 3:
+		if len(addr) < 1 || len(addr) != 1+int(addr[0])+2 {
+			return "", false
+		}
+
+		host, addr = string(addr[1:1+int(addr[0])]), addr[1+int(addr[0]):]
+	case 4:
+		if len(addr) != 16+2 {
+			return "", false
+		}
+
+		host, addr = net.IP(addr[:16]).String(), addr[16:]
+	default:
+		return "", false
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(addr)))), true
+}
+
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "TRACE"}
+
+// httpProxy detects http connect requests, and requests of absolute urls
+// which are sent to proxies.
+func httpProxy(p []byte) (Attempt, bool) {
+	line := p
+	if i := bytes.IndexAny(p, "\r\n"); i >= 0 {
+		line = p[:i]
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		return Attempt{}, false
+	}
+
+	if fields[0] == "CONNECT" {
+		return Attempt{Method: "http-connect", Target: fields[1]}, true
+	}
+
+	if !contains(httpMethods, fields[0]) {
+		return Attempt{}, false
+	}
+
+	u, err := url.Parse(fields[1])
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return Attempt{}, false
+	}
+
+	target := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+
+		target = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	return Attempt{Method: "http-proxy", Target: target}, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tools are forwarding and tunneling tools, detected by name.
+var tools = []string{
+	"chisel", "ngrok", "frpc", "frps", "sshuttle", "proxychains", "proxychains4",
+	"microsocks", "3proxy", "rinetd", "gost", "ligolo", "ligolo-ng", "revsocks",
+	"iodine", "dnscat", "dnscat2", "rpivot", "stunnel", "redsocks", "tinyproxy",
+}
+
+// Command detects the commands which set up proxies and port forwards, the
+// line can contain several commands.
+func Command(line string) []Attempt {
+	attempts := []Attempt{}
+
+	listening, connecting := false, ""
+
+	for _, words := range split(line) {
+		words = strip(words)
+		if len(words) == 0 {
+			continue
+		}
+
+		name, args := path.Base(words[0]), words[1:]
+
+		switch {
+		case name == "ssh" || name == "autossh" || name == "plink" || name == "plink.exe":
+			attempts = append(attempts, sshForwards(args)...)
+		case name == "socat":
+			if a, ok := socat(args); ok {
+				attempts = append(attempts, a)
+			}
+		case name == "nc" || name == "ncat" || name == "netcat":
+			nc := netcat(args)
+
+			if nc.exec != "" {
+				// the listener relays the connections to the
+				// command, like ncat -l 8080 --sh-exec "ncat host 22"
+				for _, inner := range split(nc.exec) {
+					if inner = strip(inner); len(inner) > 0 && (path.Base(inner[0]) == "nc" || path.Base(inner[0]) == "ncat") {
+						if target := netcat(inner[1:]).target; target != "" {
+							attempts = append(attempts, Attempt{Method: "netcat-relay", Target: target})
+						}
+					}
+				}
+			}
+
+			if nc.listen && nc.proxy {
+				attempts = append(attempts, Attempt{Method: "ncat-proxy", Target: nc.port})
+			}
+
+			if nc.listen {
+				listening = true
+			} else if nc.target != "" {
+				connecting = nc.target
+			}
+		case name == "iptables":
+			if v, ok := value(args, "--to-destination"); ok && contains(args, "DNAT") {
+				attempts = append(attempts, Attempt{Method: "iptables-dnat", Target: v})
+			} else if v, ok := value(args, "--to-ports"); ok && contains(args, "REDIRECT") {
+				attempts = append(attempts, Attempt{Method: "iptables-redirect", Target: v})
+			}
+		case name == "netsh" || name == "netsh.exe":
+			if contains(args, "portproxy") {
+				attempts = append(attempts, Attempt{Method: "portproxy", Target: portproxy(args)})
+			}
+		case contains(tools, name):
+			attempts = append(attempts, Attempt{Method: name, Target: firstTarget(args)})
+		}
+	}
+
+	// the listener and the connector of a netcat relay are piped, like
+	// nc -l 8080 < f | nc host 22 > f
+	if listening && connecting != "" {
+		attempts = append(attempts, Attempt{Method: "netcat-relay", Target: connecting})
+	}
+
+	if strings.Contains(line, "ip_forward") && (strings.Contains(line, "ip_forward=1") || strings.Contains(line, "echo 1")) {
+		attempts = append(attempts, Attempt{Method: "ip-forward"})
+	}
+
+	for i := range attempts {
+		attempts[i].Command = line
+	}
+
+	return attempts
+}
+
+// split splits the line into the words of its commands, separated by ;, &
+// and |. Quotes are removed and redirections are dropped.
+func split(line string) [][]string {
+	commands := [][]string{}
+
+	words := []string{}
+	word := []byte{}
+	inWord, redirect := false, false
+
+	flushWord := func() {
+		if !inWord {
+			return
+		}
+
+		if !redirect {
+			words = append(words, string(word))
+		}
+
+		word, inWord, redirect = word[:0], false, false
+	}
+
+	flushCommand := func() {
+		flushWord()
+
+		if len(words) > 0 {
+			commands = append(commands, words)
+		}
+
+		words = []string{}
+	}
+
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == '\\' && i+1 < len(line):
+			i++
+			word, inWord = append(word, line[i]), true
+		case c == ' ' || c == '\t':
+			flushWord()
+		case c == '<' || c == '>':
+			flushWord()
+
+			for i+1 < len(line) && (line[i+1] == '>' || line[i+1] == '&') {
+				i++
+			}
+
+			redirect = true
+		case c == ';' || c == '&' || c == '|':
+			flushCommand()
+		default:
+			word, inWord = append(word, c), true
+		}
+	}
+
+	flushCommand()
+
+	return commands
+}
+
+// strip strips the prefixes of commands, like sudo and assignments of
+// environment variables.
+func strip(words []string) []string {
+	for len(words) > 0 {
+		switch w := words[0]; {
+		case w == "sudo" || w == "nohup" || w == "exec" || w == "setsid" || w == "env":
+		case strings.Contains(w, "=") && !strings.HasPrefix(w, "-") && !strings.Contains(strings.SplitN(w, "=", 2)[0], "/"):
+		default:
+			return words
+		}
+
+		words = words[1:]
+	}
+
+	return words
+}
+
+// value returns the value of the flag.
+func value(args []string, flag string) (string, bool) {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1], true
+		} else if strings.HasPrefix(arg, flag+"=") {
+			return arg[len(flag)+1:], true
+		}
+	}
+
+	return "", false
+}
+
+// sshArgs are the options of ssh with an argument.
+const sshArgs = "BbcEeFIiJLlmOopQRSWwD"
+
+// sshForwards detects the forwards of ssh: local (-L), remote (-R),
+// dynamic (-D), stdio (-W) and jump hosts (-J).
+func sshForwards(args []string) []Attempt {
+	attempts := []Attempt{}
+
+	forward := func(option byte, spec string) {
+		switch option {
+		case 'L', 'R':
+			method := "ssh-local-forward"
+			if option == 'R' {
+				method = "ssh-remote-forward"
+			}
+
+			target := spec
+			if parts := strings.Split(spec, ":"); len(parts) >= 3 {
+				target = net.JoinHostPort(strings.Trim(parts[len(parts)-2], "[]"), parts[len(parts)-1])
+			}
+
+			attempts = append(attempts, Attempt{Method: method, Target: target})
+		case 'D':
+			attempts = append(attempts, Attempt{Method: "ssh-dynamic-forward", Target: spec})
+		case 'W':
+			attempts = append(attempts, Attempt{Method: "ssh-stdio-forward", Target: spec})
+		case 'J':
+			attempts = append(attempts, Attempt{Method: "ssh-jump", Target: spec})
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+			continue
+		}
+
+		for j := 1; j < len(arg); j++ {
+			option := arg[j]
+			if !strings.ContainsRune(sshArgs, rune(option)) {
+				continue
+			}
+
+			v := arg[j+1:]
+			if v == "" && i+1 < len(args) {
+				i++
+				v = args[i]
+			}
+
+			if option == 'o' {
+				// -o LocalForward="8080 host:80"
+				if parts := strings.SplitN(strings.Replace(v, "=", " ", 1), " ", 2); len(parts) == 2 {
+					spec := strings.TrimSpace(parts[1])
+
+					switch strings.ToLower(parts[0]) {
+					case "localforward":
+						forward('L', strings.Replace(spec, " ", ":", -1))
+					case "remoteforward":
+						forward('R', strings.Replace(spec, " ", ":", -1))
+					case "dynamicforward":
+						forward('D', spec)
+					case "proxyjump":
+						forward('J', spec)
+					}
+				}
+			} else {
+				forward(option, v)
+			}
+
+			break
+		}
+	}
+
+	return attempts
+}
+
+// socat detects relays of a listening address to a connecting address.
+func socat(args []string) (Attempt, bool) {
+	listening, target := false, ""
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		parts := strings.SplitN(arg, ":", 2)
+		kind := strings.ToUpper(parts[0])
+
+		switch {
+		case strings.HasSuffix(kind, "-LISTEN") || strings.HasSuffix(kind, "-L"):
+			listening = true
+		case len(parts) == 2 && (contains([]string{"TCP", "TCP4", "TCP6", "TCP-CONNECT", "UDP", "UDP4", "UDP6", "OPENSSL", "SSL"}, kind)):
+			target = strings.SplitN(parts[1], ",", 2)[0]
+		case len(parts) == 2 && (kind == "SOCKS4" || kind == "SOCKS4A" || kind == "PROXY"):
+			// the target follows the address of the proxy
+			if proxied := strings.SplitN(strings.SplitN(parts[1], ",", 2)[0], ":", 2); len(proxied) == 2 {
+				target = proxied[1]
+			}
+		}
+	}
+
+	if !listening || target == "" {
+		return Attempt{}, false
+	}
+
+	return Attempt{Method: "socat", Target: target}, true
+}
+
+type netcatArgs struct {
+	listen bool
+	proxy  bool
+	port   string
+	exec   string
+	target string
+}
+
+// netcatValues are the options of netcat with an argument.
+const netcatValues = "cepsiqwxXW"
+
+func netcat(args []string) netcatArgs {
+	nc := netcatArgs{}
+
+	positional := []string{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--listen":
+			nc.listen = true
+		case arg == "--sh-exec" || arg == "--exec" || arg == "--lua-exec":
+			if i+1 < len(args) {
+				i++
+				nc.exec = args[i]
+			}
+		case arg == "--proxy-type":
+			nc.proxy = true
+			i++
+		case strings.HasPrefix(arg, "--"):
+		case len(arg) > 1 && arg[0] == '-':
+			for j := 1; j < len(arg); j++ {
+				if arg[j] == 'l' {
+					nc.listen = true
+				}
+
+				if !strings.ContainsRune(netcatValues, rune(arg[j])) {
+					continue
+				}
+
+				v := arg[j+1:]
+				if v == "" && i+1 < len(args) {
+					i++
+					v = args[i]
+				}
+
+				switch arg[j] {
+				case 'p':
+					nc.port = v
+				case 'e', 'c':
+					nc.exec = v
+				}
+
+				break
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if nc.listen {
+		if nc.port == "" && len(positional) > 0 {
+			nc.port = positional[len(positional)-1]
+		}
+	} else if len(positional) == 2 {
+		nc.target = net.JoinHostPort(positional[0], positional[1])
+	}
+
+	return nc
+}
+
+// portproxy returns the connect address of netsh interface portproxy.
+func portproxy(args []string) string {
+	address, port := "", ""
+
+	for _, arg := range args {
+		if strings.HasPrefix(strings.ToLower(arg), "connectaddress=") {
+			address = arg[len("connectaddress="):]
+		} else if strings.HasPrefix(strings.ToLower(arg), "connectport=") {
+			port = arg[len("connectport="):]
+		}
+	}
+
+	if address == "" || port == "" {
+		return address
+	}
+
+	return net.JoinHostPort(address, port)
+}
+
+// firstTarget returns the first argument that looks like an address or an
+// url.
+func firstTarget(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		if strings.Contains(arg, "://") {
+			return arg
+		}
+
+		if host, port, err := net.SplitHostPort(arg); err == nil && host != "" && port != "" {
+			return arg
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,147 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package pivot detects attackers setting up proxies and port forwards in
+// the decoys, to reach other systems through the decoy. The traffic of the
+// sessions is scanned for proxy protocols and for the commands of
+// forwarding tools.
+package pivot
+
+import (
+	"bytes"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/severity"
+)
+
+func init() {
+	event.RegisterField(event.Field{Name: "pivot.method", Type: event.TypeString, Description: "Method of the pivot attempt, eg. socks5 or ssh-local-forward"})
+	event.RegisterField(event.Field{Name: "pivot.target", Type: event.TypeString, Description: "Intended target of the pivot attempt, host:port if known"})
+	event.RegisterField(event.Field{Name: "pivot.command", Type: event.TypeString, Description: "Command that set up the pivot"})
+}
+
+var (
+	EventCategoryPivot = event.Category("pivot")
+
+	EventTypePivotAttempt = event.Type("pivot-attempt")
+)
+
+// Attempt is a detected attempt to set up a proxy or port forward.
+type Attempt struct {
+	// Method is the protocol or tool used
+	Method string
+
+	// Target is the host:port the attacker intends to reach, or the
+	// address the proxy listens on if the targets are chosen later
+	Target string
+
+	// Command is the command line, for attempts detected in commands
+	Command string
+}
+
+// Options returns the options of the pivot-attempt event of the attempt,
+// pivots are always of high severity.
+func (a Attempt) Options() []event.Option {
+	options := []event.Option{
+		EventCategoryPivot,
+		EventTypePivotAttempt,
+		event.Custom("severity", severity.High),
+		event.Custom("pivot.method", a.Method),
+	}
+
+	if a.Target != "" {
+		options = append(options, event.Custom("pivot.target", a.Target))
+	}
+
+	if a.Command != "" {
+		options = append(options, event.Custom("pivot.command", a.Command))
+	}
+
+	return options
+}
+
+// maxLineLength limits the length of the command lines that are scanned.
+const maxLineLength = 4096
+
+// Scanner scans the data sent by the client of a session. Proxy protocols
+// are detected at the start of messages, commands are detected per line.
+// Each attempt is reported once per scanner.
+type Scanner struct {
+	fn func(Attempt)
+
+	line   []byte
+	escape bool
+
+	seen map[Attempt]bool
+}
+
+// NewScanner returns a scanner which calls fn for the detected attempts.
+func NewScanner(fn func(Attempt)) *Scanner {
+	return &Scanner{
+		fn:   fn,
+		seen: map[Attempt]bool{},
+	}
+}
+
+func (s *Scanner) report(attempts ...Attempt) {
+	for _, a := range attempts {
+		if s.seen[a] {
+			continue
+		}
+
+		s.seen[a] = true
+		s.fn(a)
+	}
+}
+
+// Write scans the data, it never fails.
+func (s *Scanner) Write(p []byte) (int, error) {
+	if len(s.line) == 0 {
+		s.report(Detect(p)...)
+	}
+
+	for _, b := range p {
+		switch {
+		case s.escape:
+			// the escape sequences of terminals end with a letter or ~
+			if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || b == '~' {
+				s.escape = false
+			}
+		case b == 0x1b:
+			s.escape = true
+		case b == '\r' || b == '\n':
+			s.Flush()
+		case b == 0x7f || b == 0x08:
+			if n := len(s.line); n > 0 {
+				s.line = s.line[:n-1]
+			}
+		case b == 0x15:
+			// ctrl-u clears the line
+			s.line = s.line[:0]
+		case b < 0x20 && b != '\t':
+		case len(s.line) < maxLineLength:
+			s.line = append(s.line, b)
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush scans the remainder of the current line.
+func (s *Scanner) Flush() {
+	if line := bytes.TrimSpace(s.line); len(line) > 0 {
+		s.report(Command(string(line))...)
+	}
+
+	s.line = s.line[:0]
+}
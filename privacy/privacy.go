@@ -0,0 +1,194 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package privacy implements the anonymization mode, source addresses are
+// pseudonymized with a keyed hash and configured fields are stripped before
+// events are stored or pushed. The key is rotated every period, so
+// pseudonyms can't be linked across periods.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:privacy")
+
+const keySize = 32
+
+// Anonymizer pseudonymizes and strips the fields of the events it receives.
+// The anonymizer should be subscribed to the bus before the channels.
+type Anonymizer struct {
+	// Fields are the fields that will be pseudonymized, wildcards are
+	// supported.
+	Fields []string `toml:"pseudonymize"`
+
+	// Strip are the fields that will be removed, wildcards are supported.
+	Strip []string `toml:"strip"`
+
+	// Rotation is the period after which the key is replaced
+	Rotation config.Delay `toml:"rotation"`
+
+	storage storage.Storage
+
+	m      sync.Mutex
+	key    []byte
+	period int64
+
+	now func() time.Time
+}
+
+// New returns a new Anonymizer.
+func New(options ...func(*Anonymizer) error) (*Anonymizer, error) {
+	a := &Anonymizer{
+		Fields: []string{
+			"source-ip",
+		},
+		Strip:    []string{},
+		Rotation: config.Delay(24 * time.Hour),
+		period:   -1,
+		now:      time.Now,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the privacy configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Anonymizer) error {
+	return func(a *Anonymizer) error {
+		return decoder.PrimitiveDecode(c, a)
+	}
+}
+
+// WithStorage persists the key of the current period, so pseudonyms stay
+// consistent after a restart.
+func WithStorage(st storage.Storage) func(*Anonymizer) error {
+	return func(a *Anonymizer) error {
+		a.storage = st
+
+		if data, err := st.Get("key"); err == nil && len(data) == 8+keySize {
+			a.period = int64(binary.BigEndian.Uint64(data[:8]))
+			a.key = data[8:]
+		}
+
+		return nil
+	}
+}
+
+// currentKey returns the key of the current period, a new random key is
+// generated when the period has passed. The old key is discarded.
+func (a *Anonymizer) currentKey() []byte {
+	period := a.now().UnixNano() / int64(a.Rotation.Duration())
+	if period == a.period && a.key != nil {
+		return a.key
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		log.Errorf("Error generating key: %s", err.Error())
+		return a.key
+	}
+
+	a.key = key
+	a.period = period
+
+	if a.storage == nil {
+		return key
+	}
+
+	data := make([]byte, 8, 8+keySize)
+	binary.BigEndian.PutUint64(data, uint64(period))
+
+	if err := a.storage.Set("key", append(data, key...)); err != nil {
+		log.Errorf("Error persisting key: %s", err.Error())
+	}
+
+	return key
+}
+
+// Pseudonym returns the pseudonym of the value for the current period.
+func (a *Anonymizer) Pseudonym(value string) string {
+	a.m.Lock()
+	key := a.currentKey()
+	a.m.Unlock()
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func match(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pseudonymizes returns whether the field is pseudonymized.
+func (a *Anonymizer) Pseudonymizes(field string) bool {
+	return match(a.Fields, field)
+}
+
+// Send pseudonymizes and strips the fields of the event.
+func (a *Anonymizer) Send(e event.Event) {
+	strip := []string{}
+	pseudonymize := map[string]string{}
+
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+
+		if match(a.Strip, k) {
+			strip = append(strip, k)
+		} else if v, ok := value.(string); ok && v != "" && match(a.Fields, k) {
+			pseudonymize[k] = v
+		}
+
+		return true
+	})
+
+	for _, k := range strip {
+		e.Delete(k)
+	}
+
+	for k, v := range pseudonymize {
+		e.Store(k, a.Pseudonym(v))
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package privacy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func newEvent() event.Event {
+	return event.New(
+		event.Category("ssh"),
+		event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "toor"),
+	)
+}
+
+func TestAnonymizer(t *testing.T) {
+	a, err := New(func(a *Anonymizer) error {
+		a.Strip = []string{"*.password"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e1 := newEvent()
+	a.Send(e1)
+
+	e2 := newEvent()
+	a.Send(e2)
+
+	if v := e1.Get("source-ip"); !strings.HasPrefix(v, "anon-") {
+		t.Errorf("Expected pseudonymized source-ip, got %s", v)
+	}
+
+	if e1.Get("source-ip") != e2.Get("source-ip") {
+		t.Errorf("Expected consistent pseudonyms within a period")
+	}
+
+	if e1.Has("ssh.password") {
+		t.Errorf("Expected ssh.password to be stripped")
+	}
+
+	if e1.Get("ssh.username") != "root" {
+		t.Errorf("Expected ssh.username to be kept")
+	}
+
+	if !a.Pseudonymizes("source-ip") || a.Pseudonymizes("destination-ip") {
+		t.Errorf("Expected only source-ip to be pseudonymized")
+	}
+}
+
+func TestAnonymizerRotation(t *testing.T) {
+	now := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.now = func() time.Time { return now }
+
+	p1 := a.Pseudonym("192.0.2.1")
+
+	now = now.Add(time.Hour)
+	if p := a.Pseudonym("192.0.2.1"); p != p1 {
+		t.Errorf("Expected same pseudonym within rotation period")
+	}
+
+	now = now.Add(24 * time.Hour)
+	if p := a.Pseudonym("192.0.2.1"); p == p1 {
+		t.Errorf("Expected different pseudonym after rotation")
+	}
+}
+
+type memoryStorage map[string][]byte
+
+func (s memoryStorage) Get(key string) ([]byte, error) {
+	return s[key], nil
+}
+
+func (s memoryStorage) Set(key string, data []byte) error {
+	s[key] = append([]byte{}, data...)
+	return nil
+}
+
+func TestAnonymizerStorage(t *testing.T) {
+	st := memoryStorage{}
+
+	a, err := New(WithStorage(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1 := a.Pseudonym("192.0.2.1")
+
+	// restarted
+	a, err = New(WithStorage(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := a.Pseudonym("192.0.2.1"); p != p1 {
+		t.Errorf("Expected same pseudonym after restart")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package proxy resolves the outbound proxies of the channels and enrichers.
+// A proxy is configured as http, https or socks5 url, or "direct" to
+// connect without proxy. Channels and enrichers without a proxy use the
+// global default, and if that isn't set the proxy from the environment
+// (HTTP_PROXY, HTTPS_PROXY and NO_PROXY).
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Direct disables the proxy.
+const Direct = "direct"
+
+// Func returns the proxy url for the request, as used by http.Transport.
+type Func func(*http.Request) (*url.URL, error)
+
+var (
+	m            sync.RWMutex
+	defaultProxy Func = http.ProxyFromEnvironment
+)
+
+func parse(s string) (Func, error) {
+	if s == Direct {
+		return nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("Proxy %s has no host", s)
+	}
+
+	return http.ProxyURL(u), nil
+}
+
+// SetDefault sets the proxy of the channels and enrichers that don't
+// configure one.
+func SetDefault(s string) error {
+	if s == "" {
+		return nil
+	}
+
+	fn, err := parse(s)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	defaultProxy = fn
+	return nil
+}
+
+// fromDefault uses the default proxy, the default is resolved for each
+// request so it can be set after the channels have been created.
+func fromDefault(req *http.Request) (*url.URL, error) {
+	m.RLock()
+	fn := defaultProxy
+	m.RUnlock()
+
+	if fn == nil {
+		return nil, nil
+	}
+
+	return fn(req)
+}
+
+// New returns the proxy func for the configured proxy, an empty proxy uses
+// the default.
+func New(s string) (Func, error) {
+	if s == "" {
+		return fromDefault, nil
+	}
+
+	return parse(s)
+}
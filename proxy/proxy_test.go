@@ -0,0 +1,90 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func resolve(t *testing.T, fn Func) string {
+	if fn == nil {
+		return ""
+	}
+
+	req, _ := http.NewRequest("GET", "https://hooks.slack.com/", nil)
+
+	u, err := fn(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u == nil {
+		return ""
+	}
+
+	return u.String()
+}
+
+func TestProxy(t *testing.T) {
+	defer func() {
+		defaultProxy = http.ProxyFromEnvironment
+	}()
+
+	for _, s := range []string{"ftp://proxy:21", "http://", "%"} {
+		if _, err := New(s); err == nil {
+			t.Errorf("Expected error for proxy %q", s)
+		}
+	}
+
+	fn, err := New("socks5://10.0.0.1:1080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u := resolve(t, fn); u != "socks5://10.0.0.1:1080" {
+		t.Errorf("Expected socks5 proxy, got %q", u)
+	}
+
+	direct, err := New(Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u := resolve(t, direct); u != "" {
+		t.Errorf("Expected no proxy, got %q", u)
+	}
+
+	// the default is resolved when the request is made
+	def, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetDefault("http://proxy.internal:3128"); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := resolve(t, def); u != "http://proxy.internal:3128" {
+		t.Errorf("Expected default proxy, got %q", u)
+	}
+
+	if err := SetDefault(Direct); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := resolve(t, def); u != "" {
+		t.Errorf("Expected no proxy, got %q", u)
+	}
+}
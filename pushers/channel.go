@@ -52,6 +52,23 @@ func WithConfig(c toml.Primitive, decoder TomlDecoder) func(Channel) error {
 	}
 }
 
+// ChannelSetter is implemented by the channels reporting events of their
+// own, eg. dropped events.
+type ChannelSetter interface {
+	SetChannel(Channel)
+}
+
+// WithChannel sets the channel the events of the channel are reported to.
+func WithChannel(c Channel) func(Channel) error {
+	return func(d Channel) error {
+		if cs, ok := d.(ChannelSetter); ok {
+			cs.SetChannel(c)
+		}
+
+		return nil
+	}
+}
+
 func Get(key string) (ChannelFunc, bool) {
 	d := Dummy
 
@@ -0,0 +1,320 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+// fake is a database/sql driver recording the statements executed.
+type fake struct {
+	m sync.Mutex
+
+	version    int64
+	statements []string
+	args       [][]driver.Value
+	commits    int
+}
+
+var db = &fake{}
+
+func init() {
+	sql.Register("fake", db)
+}
+
+func (f *fake) reset() {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.version = 0
+	f.statements = nil
+	f.args = nil
+	f.commits = 0
+}
+
+// executed returns the statements executed that start with the prefix.
+func (f *fake) executed(prefix string) [][]driver.Value {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	args := [][]driver.Value{}
+	for i, s := range f.statements {
+		if strings.HasPrefix(strings.TrimSpace(s), prefix) {
+			args = append(args, f.args[i])
+		}
+	}
+
+	return args
+}
+
+func (f *fake) Open(name string) (driver.Conn, error) {
+	return &fakeConn{f}, nil
+}
+
+type fakeConn struct {
+	*fake
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c.fake, query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.commits++
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	*fake
+
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.statements = append(s.statements, s.query)
+	s.args = append(s.args, args)
+
+	if strings.HasPrefix(s.query, "INSERT INTO schema_migrations") {
+		s.version = args[0].(int64)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return &fakeRows{values: []driver.Value{s.version}}, nil
+}
+
+type fakeRows struct {
+	values []driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"version"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.values == nil {
+		return io.EOF
+	}
+
+	copy(dest, r.values)
+	r.values = nil
+	return nil
+}
+
+func newBackend(t *testing.T) *Backend {
+	c, err := New(func(c pushers.Channel) error {
+		b := c.(*Backend)
+		b.Driver = "fake"
+		b.Dialect = "postgres"
+		b.DSN = "test"
+		b.BatchSize = 3
+		b.FlushInterval.UnmarshalText([]byte("50ms"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.(*Backend)
+}
+
+func TestMigrate(t *testing.T) {
+	db.reset()
+
+	newBackend(t)
+
+	if n := len(db.executed("CREATE TABLE events")); n != 1 {
+		t.Fatalf("Expected events table to be created, got %d", n)
+	}
+
+	if db.version != int64(len(dialects["postgres"].migrations)) {
+		t.Errorf("Expected schema version %d, got %d", len(dialects["postgres"].migrations), db.version)
+	}
+
+	// the schema is up to date
+	newBackend(t)
+
+	if n := len(db.executed("CREATE TABLE events")); n != 1 {
+		t.Errorf("Expected migrations to be applied once, got %d", n)
+	}
+}
+
+func TestSend(t *testing.T) {
+	db.reset()
+
+	b := newBackend(t)
+
+	b.Send(harness.FixtureEvent("ssh-password"))
+	b.Send(harness.FixtureEvent("telnet-payload"))
+	b.Send(harness.FixtureEvent("http-request"))
+
+	deadline := time.Now().Add(time.Second)
+	for len(db.executed("INSERT INTO events")) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events := db.executed("INSERT INTO events")
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+
+	if !events[0][0].(time.Time).Equal(harness.FixtureDate) {
+		t.Errorf("Expected the date of the event, got %v", events[0][0])
+	}
+
+	if events[0][4] != "198.51.100.7" {
+		t.Errorf("Expected source ip, got %v", events[0][4])
+	}
+
+	if !strings.Contains(events[0][10].(string), `"ssh.password"`) {
+		t.Errorf("Expected the event as json, got %v", events[0][10])
+	}
+
+	if n := len(db.executed("INSERT INTO payloads")); n != 1 {
+		t.Errorf("Expected the payload to be stored, got %d", n)
+	}
+
+	if db.commits < 2 {
+		t.Errorf("Expected the events to be written in a transaction")
+	}
+}
+
+func TestSession(t *testing.T) {
+	db.reset()
+
+	b := newBackend(t)
+
+	b.Send(event.New(
+		event.Category("ssh"),
+		event.Custom("ssh.sessionid", "s1"),
+	))
+
+	deadline := time.Now().Add(time.Second)
+	for len(db.executed("INSERT INTO events")) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sessions := db.executed("INSERT INTO sessions")
+	if len(sessions) != 1 || sessions[0][0] != "s1" || sessions[0][1] != "ssh" {
+		t.Fatalf("Expected session s1 of service ssh, got %v", sessions)
+	}
+
+	if events := db.executed("INSERT INTO events"); events[0][8] != "s1" {
+		t.Errorf("Expected the event to refer to the session, got %v", events[0][8])
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	db.reset()
+
+	b := newBackend(t)
+
+	b.Send(event.New(
+		event.Category("ssh"),
+		event.Service("ssh01"),
+		event.Custom("id", "01ARYZ6S41TSV4RRFFQ69G5FAV"),
+		event.Custom("session.id", "01ARYZ6S41TSV4RRFFQ69G5FAW"),
+		event.Custom("attack.id", "01ARYZ6S41TSV4RRFFQ69G5FAX"),
+		event.Custom("ssh.sessionid", "s1"),
+	))
+
+	deadline := time.Now().Add(time.Second)
+	for len(db.executed("INSERT INTO events")) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the session of the connection takes precedence
+	sessions := db.executed("INSERT INTO sessions")
+	if len(sessions) != 1 || sessions[0][0] != "01ARYZ6S41TSV4RRFFQ69G5FAW" || sessions[0][1] != "ssh01" {
+		t.Fatalf("Expected the session of the connection, got %v", sessions)
+	}
+
+	events := db.executed("INSERT INTO events")
+	if events[0][11] != "01ARYZ6S41TSV4RRFFQ69G5FAV" || events[0][12] != "01ARYZ6S41TSV4RRFFQ69G5FAX" {
+		t.Errorf("Expected the event and attack id, got %v and %v", events[0][11], events[0][12])
+	}
+}
+
+func TestRebind(t *testing.T) {
+	if q := dialects["postgres"].rebind("VALUES (?, ?)"); q != "VALUES ($1, $2)" {
+		t.Errorf("Expected numbered placeholders, got %s", q)
+	}
+
+	if q := dialects["mysql"].rebind("VALUES (?, ?)"); q != "VALUES (?, ?)" {
+		t.Errorf("Expected placeholders unchanged, got %s", q)
+	}
+}
+
+func TestDriverNotAvailable(t *testing.T) {
+	for _, name := range sql.Drivers() {
+		if name == "mysql" {
+			t.Skip("mysql driver is part of the build")
+		}
+	}
+
+	_, err := New(func(c pushers.Channel) error {
+		b := c.(*Backend)
+		b.Driver = "mysql"
+		b.DSN = "honeytrap@/honeytrap"
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "-tags mysql") {
+		t.Errorf("Expected driver not available error, got %v", err)
+	}
+}
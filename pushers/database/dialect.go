@@ -0,0 +1,161 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// dialect contains the statements that differ between the databases.
+type dialect struct {
+	// placeholders are numbered ($1, $2), instead of ?
+	numbered bool
+
+	// migrations are the schema changes, in order, the index of the
+	// migration is its version minus one
+	migrations [][]string
+
+	insertEvent   string
+	upsertSession string
+	upsertPayload string
+}
+
+// rebind replaces the ? placeholders of the query with the placeholders of
+// the dialect.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	sb := strings.Builder{}
+
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			sb.WriteRune(r)
+			continue
+		}
+
+		n++
+
+		sb.WriteString("$")
+		sb.WriteString(strconv.Itoa(n))
+	}
+
+	return sb.String()
+}
+
+const insertEvent = `INSERT INTO events (date, category, type, sensor, source_ip, source_port, destination_ip, destination_port, session_id, payload_sha256, data, event_id, attack_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+var dialects = map[string]*dialect{
+	"postgres": {
+		numbered: true,
+		migrations: [][]string{
+			{
+				`CREATE TABLE sessions (
+					id VARCHAR(128) PRIMARY KEY,
+					service VARCHAR(64) NOT NULL,
+					source_ip VARCHAR(45),
+					first_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+					last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+					events INTEGER NOT NULL
+				)`,
+				`CREATE TABLE payloads (
+					sha256 CHAR(64) PRIMARY KEY,
+					length INTEGER NOT NULL,
+					data BYTEA NOT NULL,
+					first_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+					last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+					count INTEGER NOT NULL
+				)`,
+				`CREATE TABLE events (
+					id BIGSERIAL PRIMARY KEY,
+					date TIMESTAMP WITH TIME ZONE NOT NULL,
+					category VARCHAR(64),
+					type VARCHAR(64),
+					sensor VARCHAR(64),
+					source_ip VARCHAR(45),
+					source_port INTEGER,
+					destination_ip VARCHAR(45),
+					destination_port INTEGER,
+					session_id VARCHAR(128),
+					payload_sha256 CHAR(64),
+					data JSONB NOT NULL
+				)`,
+				`CREATE INDEX events_date ON events (date)`,
+				`CREATE INDEX events_source_ip ON events (source_ip)`,
+				`CREATE INDEX events_session_id ON events (session_id)`,
+			},
+			{
+				`ALTER TABLE events ADD COLUMN event_id CHAR(26)`,
+				`ALTER TABLE events ADD COLUMN attack_id CHAR(26)`,
+				`CREATE INDEX events_event_id ON events (event_id)`,
+				`CREATE INDEX events_attack_id ON events (attack_id)`,
+			},
+		},
+		insertEvent: insertEvent,
+		upsertSession: `INSERT INTO sessions (id, service, source_ip, first_seen, last_seen, events) VALUES (?, ?, ?, ?, ?, 1)
+			ON CONFLICT (id) DO UPDATE SET last_seen = GREATEST(sessions.last_seen, EXCLUDED.last_seen), events = sessions.events + 1`,
+		upsertPayload: `INSERT INTO payloads (sha256, length, data, first_seen, last_seen, count) VALUES (?, ?, ?, ?, ?, 1)
+			ON CONFLICT (sha256) DO UPDATE SET last_seen = GREATEST(payloads.last_seen, EXCLUDED.last_seen), count = payloads.count + 1`,
+	},
+	"mysql": {
+		migrations: [][]string{
+			{
+				`CREATE TABLE sessions (
+					id VARCHAR(128) PRIMARY KEY,
+					service VARCHAR(64) NOT NULL,
+					source_ip VARCHAR(45),
+					first_seen DATETIME(6) NOT NULL,
+					last_seen DATETIME(6) NOT NULL,
+					events INTEGER NOT NULL
+				)`,
+				`CREATE TABLE payloads (
+					sha256 CHAR(64) PRIMARY KEY,
+					length INTEGER NOT NULL,
+					data LONGBLOB NOT NULL,
+					first_seen DATETIME(6) NOT NULL,
+					last_seen DATETIME(6) NOT NULL,
+					count INTEGER NOT NULL
+				)`,
+				`CREATE TABLE events (
+					id BIGINT AUTO_INCREMENT PRIMARY KEY,
+					date DATETIME(6) NOT NULL,
+					category VARCHAR(64),
+					type VARCHAR(64),
+					sensor VARCHAR(64),
+					source_ip VARCHAR(45),
+					source_port INTEGER,
+					destination_ip VARCHAR(45),
+					destination_port INTEGER,
+					session_id VARCHAR(128),
+					payload_sha256 CHAR(64),
+					data JSON NOT NULL,
+					INDEX events_date (date),
+					INDEX events_source_ip (source_ip),
+					INDEX events_session_id (session_id)
+				)`,
+			},
+			{
+				`ALTER TABLE events ADD COLUMN event_id CHAR(26), ADD COLUMN attack_id CHAR(26), ADD INDEX events_event_id (event_id), ADD INDEX events_attack_id (attack_id)`,
+			},
+		},
+		insertEvent: insertEvent,
+		upsertSession: `INSERT INTO sessions (id, service, source_ip, first_seen, last_seen, events) VALUES (?, ?, ?, ?, ?, 1)
+			ON DUPLICATE KEY UPDATE last_seen = GREATEST(last_seen, VALUES(last_seen)), events = events + 1`,
+		upsertPayload: `INSERT INTO payloads (sha256, length, data, first_seen, last_seen, count) VALUES (?, ?, ?, ?, ?, 1)
+			ON DUPLICATE KEY UPDATE last_seen = GREATEST(last_seen, VALUES(last_seen)), count = count + 1`,
+	},
+}
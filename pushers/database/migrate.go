@@ -0,0 +1,59 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// migrate brings the schema up to date, the version of the schema is kept in
+// the schema_migrations table.
+func migrate(db *sql.DB, d *dialect) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY, applied TIMESTAMP NOT NULL)`); err != nil {
+		return err
+	}
+
+	version := 0
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(d.migrations); i++ {
+		log.Infof("Migrating schema to version %d", i+1)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for _, statement := range d.migrations[i] {
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(d.rebind(`INSERT INTO schema_migrations (version, applied) VALUES (?, ?)`), i+1, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func init() {
+	event.RegisterField(event.Field{Name: "dedup.count", Type: event.TypeNumber, Description: "Number of events consolidated into the event"})
+	event.RegisterField(event.Field{Name: "dedup.sensors", Type: event.TypeArray, Description: "Sensors that saw the consolidated events"})
+	event.RegisterField(event.Field{Name: "dedup.hits", Type: event.TypeArray, Description: "Number of events per sensor, in the order of dedup.sensors"})
+	event.RegisterField(event.Field{Name: "dedup.first", Type: event.TypeString, Description: "Time of the first consolidated event"})
+	event.RegisterField(event.Field{Name: "dedup.last", Type: event.TypeString, Description: "Time of the last consolidated event"})
+}
+
+// Dedup consolidates the events of a source hitting the sensors of the
+// fleet within a window into a single event, for alerting channels.
+// Channels without dedup, like storage, still receive all events.
+type Dedup struct {
+	Enabled bool `toml:"enabled"`
+
+	// Window is the time the duplicates are consolidated, starting at the
+	// first event
+	Window config.Delay `toml:"window"`
+
+	// Fields are the fields of the events that are equal for duplicates
+	Fields []string `toml:"fields"`
+
+	// SensorField is the field with the sensor of the event, the agent by
+	// default, falling back to the destination address
+	SensorField string `toml:"sensor-field"`
+
+	// MaxKeys is the maximum number of open windows, other events are
+	// delivered without consolidation
+	MaxKeys int `toml:"max-keys"`
+}
+
+type dedupGroup struct {
+	first       event.Event
+	start, last time.Time
+	count       int
+
+	sensors []string
+	hits    map[string]int
+}
+
+type dedupChannel struct {
+	Channel
+
+	Dedup
+
+	m      sync.Mutex
+	groups map[string]*dedupGroup
+
+	now   func() time.Time
+	after func(time.Duration, func())
+}
+
+// key returns the key of the duplicates of the event, an event without any
+// of the fields has no duplicates.
+func (dc *dedupChannel) key(e event.Event) (string, bool) {
+	values := make([]string, len(dc.Fields))
+
+	found := false
+	for i, field := range dc.Fields {
+		values[i] = e.Get(field)
+		found = found || values[i] != ""
+	}
+
+	return strings.Join(values, "\x00"), found
+}
+
+func (dc *dedupChannel) sensor(e event.Event) string {
+	for _, field := range []string{dc.SensorField, "destination-ip"} {
+		if v := e.Get(field); v != "" {
+			return v
+		}
+	}
+
+	return "unknown"
+}
+
+// Send consolidates the event into the window of its duplicates, the first
+// event of a key opens the window.
+func (dc *dedupChannel) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		dc.Channel.Send(e)
+		return
+	}
+
+	key, ok := dc.key(e)
+	if !ok {
+		dc.Channel.Send(e)
+		return
+	}
+
+	sensor := dc.sensor(e)
+	now := dc.now()
+
+	dc.m.Lock()
+
+	if g, ok := dc.groups[key]; ok {
+		if _, ok := g.hits[sensor]; !ok {
+			g.sensors = append(g.sensors, sensor)
+		}
+
+		g.hits[sensor]++
+		g.count++
+		g.last = now
+
+		dc.m.Unlock()
+		return
+	}
+
+	if len(dc.groups) >= dc.MaxKeys {
+		dc.m.Unlock()
+
+		dc.Channel.Send(e)
+		return
+	}
+
+	dc.groups[key] = &dedupGroup{
+		first:   e,
+		start:   now,
+		last:    now,
+		count:   1,
+		sensors: []string{sensor},
+		hits:    map[string]int{sensor: 1},
+	}
+
+	dc.m.Unlock()
+
+	dc.after(dc.Window.Duration(), func() {
+		dc.flush(key)
+	})
+}
+
+// flush closes the window of the key, and delivers the consolidated event.
+func (dc *dedupChannel) flush(key string) {
+	dc.m.Lock()
+	g, ok := dc.groups[key]
+	delete(dc.groups, key)
+	dc.m.Unlock()
+
+	if !ok {
+		return
+	}
+
+	hits := make([]int, len(g.sensors))
+	for i, sensor := range g.sensors {
+		hits[i] = g.hits[sensor]
+	}
+
+	e := g.first.Copy()
+	e.Store("dedup.count", g.count)
+	e.Store("dedup.sensors", g.sensors)
+	e.Store("dedup.hits", hits)
+	e.Store("dedup.first", g.start)
+	e.Store("dedup.last", g.last)
+
+	dc.Channel.Send(e)
+}
+
+// DedupChannel returns a Channel that consolidates the duplicate events
+// within the window. The consolidated event is the first event, with the
+// number of events and the hits per sensor.
+func DedupChannel(channel Channel, dedup Dedup) Channel {
+	if dedup.Window <= 0 {
+		dedup.Window = config.Delay(time.Minute)
+	}
+
+	if len(dedup.Fields) == 0 {
+		dedup.Fields = []string{"source-ip", "category", "type"}
+	}
+
+	if dedup.SensorField == "" {
+		dedup.SensorField = "agent"
+	}
+
+	if dedup.MaxKeys <= 0 {
+		dedup.MaxKeys = 10000
+	}
+
+	return &dedupChannel{
+		Channel: channel,
+		Dedup:   dedup,
+		groups:  map[string]*dedupGroup{},
+		now:     time.Now,
+		after: func(d time.Duration, fn func()) {
+			time.AfterFunc(d, fn)
+		},
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestDedupChannel(t *testing.T) {
+	rc := &recordChannel{}
+
+	c := DedupChannel(rc, Dedup{Enabled: true}).(*dedupChannel)
+
+	// the windows are closed by the test
+	flushes := []func(){}
+	c.after = func(d time.Duration, fn func()) {
+		if d != time.Minute {
+			t.Errorf("Expected default window of a minute, got %s", d)
+		}
+
+		flushes = append(flushes, fn)
+	}
+
+	for i := 0; i < 50; i++ {
+		c.Send(event.New(
+			event.Category("ssh"),
+			event.Type("connect"),
+			event.Custom("source-ip", "192.0.2.1"),
+			event.Custom("agent", fmt.Sprintf("agent-%d", i%10)),
+		))
+	}
+
+	c.Send(event.New(event.Category("ssh"), event.Type("connect"), event.Custom("source-ip", "198.51.100.1")))
+	c.Send(event.New(event.Category("heartbeat")))
+
+	if len(rc.events) != 1 || rc.events[0].Get("category") != "heartbeat" {
+		t.Fatalf("Expected only the heartbeat before the windows close, got %d events", len(rc.events))
+	}
+
+	if len(flushes) != 2 {
+		t.Fatalf("Expected 2 windows, got %d", len(flushes))
+	}
+
+	for _, fn := range flushes {
+		fn()
+	}
+
+	if len(rc.events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(rc.events))
+	}
+
+	values := map[string]interface{}{}
+	rc.events[1].Range(func(k, v interface{}) bool {
+		values[k.(string)] = v
+		return true
+	})
+
+	if values["dedup.count"] != 50 {
+		t.Errorf("Expected 50 consolidated events, got %v", values["dedup.count"])
+	}
+
+	sensors, _ := values["dedup.sensors"].([]string)
+	hits, _ := values["dedup.hits"].([]int)
+
+	if len(sensors) != 10 || sensors[0] != "agent-0" || len(hits) != 10 || hits[0] != 5 {
+		t.Errorf("Unexpected hits %v %v", sensors, hits)
+	}
+
+	// a new window opens after the window closed
+	c.Send(event.New(event.Category("ssh"), event.Type("connect"), event.Custom("source-ip", "192.0.2.1")))
+
+	if len(flushes) != 3 {
+		t.Errorf("Expected a new window, got %d windows", len(flushes))
+	}
+}
+
+func TestDedupMaxKeys(t *testing.T) {
+	rc := &recordChannel{}
+
+	c := DedupChannel(rc, Dedup{Enabled: true, MaxKeys: 1}).(*dedupChannel)
+	c.after = func(time.Duration, func()) {}
+
+	c.Send(event.New(event.Custom("source-ip", "192.0.2.1")))
+	c.Send(event.New(event.Custom("source-ip", "192.0.2.2")))
+
+	if len(rc.events) != 1 || rc.events[0].Get("source-ip") != "192.0.2.2" {
+		t.Errorf("Expected the events beyond the maximum to be delivered, got %d", len(rc.events))
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/webhook"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:channels:discord")
+
+var (
+	_ = pushers.Register("discord", New)
+)
+
+/*
+Configuration example:
+
+[channel.discord]
+type="discord"
+webhook_url="https://discord.com/api/webhooks/{id}/{token}"
+template="{{.category}} {{.type}} from {{index . \"source-ip\"}}"
+*/
+
+// maxContent is the maximum length of the content of a message.
+const maxContent = 2000
+
+// Config defines a struct which holds configuration field values used by the
+// Backend for its message delivery to a Discord webhook.
+type Config struct {
+	WebhookURL string `toml:"webhook_url"`
+	Username   string `toml:"username"`
+	AvatarURL  string `toml:"avatar_url"`
+
+	// Template is the text/template the events are formatted with
+	Template string `toml:"template"`
+
+	// Interval is the interval the batched events are posted
+	Interval config.Delay `toml:"interval"`
+
+	// MaxEvents is the maximum number of events posted an interval, the
+	// other events are summarized
+	MaxEvents int `toml:"max-events"`
+
+	Proxy string `toml:"proxy"`
+}
+
+// Backend posts the events in batches to a Discord webhook.
+type Backend struct {
+	Config
+
+	client   *http.Client
+	template *template.Template
+	batcher  *webhook.Batcher
+}
+
+// New returns a new instance of a Backend.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := &Backend{
+		Config: Config{
+			Username:  "Honeytrap",
+			Template:  webhook.DefaultTemplate,
+			Interval:  config.Delay(5 * time.Second),
+			MaxEvents: 20,
+		},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.WebhookURL == "" {
+		return nil, errors.New("Invalid Config: webhook_url can not be empty")
+	}
+
+	if c.MaxEvents < 1 {
+		return nil, errors.New("Invalid Config: max-events should be at least 1")
+	}
+
+	t, err := webhook.NewTemplate(c.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	c.template = t
+
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy: p,
+		},
+		Timeout: 20 * time.Second,
+	}
+
+	c.batcher = webhook.NewBatcher("discord", c.Interval.Duration(), c.MaxEvents, c.post)
+
+	return c, nil
+}
+
+// message is a message of a Discord webhook.
+type message struct {
+	Content   string `json:"content"`
+	Username  string `json:"username,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+// post posts the events, split in messages within the maximum content
+// length.
+func (b *Backend) post(lines []string, summarized int) error {
+	if summarized > 0 {
+		lines = append(lines, fmt.Sprintf("... and %d more events", summarized))
+	}
+
+	for _, content := range webhook.Split(lines, maxContent, "\n") {
+		if err := webhook.Post(b.client, b.WebhookURL, message{
+			Content:   content,
+			Username:  b.Username,
+			AvatarURL: b.AvatarURL,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Send formats the event and queues it for the next batch.
+func (b *Backend) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	line, err := webhook.Render(b.template, e)
+	if err != nil {
+		log.Errorf("Error formatting event: %s", err.Error())
+		pushers.DeliveryFailed("discord", 1)
+		return
+	}
+
+	b.batcher.Add(line)
+}
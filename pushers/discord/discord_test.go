@@ -0,0 +1,75 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/utils/harness"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestSend(t *testing.T) {
+	hook := harness.NewHTTPBackend(nil)
+	defer hook.Close()
+
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(fmt.Sprintf(`p = { webhook_url = "%s", interval = "100ms", max-events = 2, template = "{{.category}} {{index . \"source-ip\"}}" }`, hook.URL), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(pushers.WithConfig(s.P, &md))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		c.Send(harness.FixtureEvent("ssh-password"))
+	}
+
+	requests, err := hook.Wait(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := message{}
+	if err := json.Unmarshal(requests[0].Body, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Username != "Honeytrap" {
+		t.Errorf("Expected default username, got %s", m.Username)
+	}
+
+	lines := strings.Split(m.Content, "\n")
+	if len(lines) != 3 || lines[0] != "ssh 198.51.100.7" || lines[2] != "... and 1 more events" {
+		t.Errorf("Unexpected content %q", m.Content)
+	}
+}
+
+func TestNewWithoutWebhook(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Errorf("Expected error without webhook_url")
+	}
+}
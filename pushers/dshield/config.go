@@ -13,6 +13,8 @@
 // limitations under the License.
 package dshield
 
+import "github.com/honeytrap/honeytrap/pushers"
+
 // Config defines a struct which holds configuration values for a SearchBackend.
 type Config struct {
 	URL string `toml:"url"`
@@ -20,6 +22,10 @@ type Config struct {
 	Insecure bool `toml:"insecure"`
 	Debug    bool `toml:"debug"`
 
+	Proxy string `toml:"proxy"`
+
+	pushers.TLSConfig
+
 	UserID string `toml:"user_id"`
 	APIKey string `toml:"api_key"`
 }
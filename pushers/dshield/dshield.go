@@ -29,6 +29,7 @@ import (
 
 	"github.com/honeytrap/honeytrap/cmd"
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
 	"github.com/honeytrap/honeytrap/pushers"
 
 	"io"
@@ -62,6 +63,9 @@ type Backend struct {
 
 	MyIP string
 
+	proxy     proxy.Func
+	tlsConfig *tls.Config
+
 	ch chan json.Marshaler
 }
 
@@ -81,7 +85,21 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 		optionFn(&c)
 	}
 
-	myIP, err := GetMyIP()
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.proxy = p
+
+	tlsConfig, err := c.ClientConfig(c.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	c.tlsConfig = tlsConfig
+
+	myIP, err := GetMyIP(c.client())
 	if err != nil {
 		return nil, err
 	}
@@ -108,8 +126,18 @@ func Insecure(config *tls.Config) *tls.Config {
 	return config
 }
 
-func GetMyIP() (string, error) {
-	resp, err := http.Get("https://www.dshield.org/api/myip?json")
+// client returns the http client used to connect to dshield.
+func (hc Backend) client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           hc.proxy,
+			TLSClientConfig: hc.tlsConfig,
+		},
+	}
+}
+
+func GetMyIP(client *http.Client) (string, error) {
+	resp, err := client.Get("https://www.dshield.org/api/myip?json")
 	if err != nil {
 		return "", err
 	}
@@ -155,18 +183,7 @@ func (hc Backend) MakeAuthHeader() (string, error) {
 }
 
 func (hc Backend) run() {
-
-	tlsClientConfig := &tls.Config{}
-
-	if hc.Insecure {
-		tlsClientConfig = Insecure(tlsClientConfig)
-	}
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsClientConfig,
-		},
-	}
+	client := hc.client()
 
 	docs := make([]json.Marshaler, 0)
 
@@ -208,6 +225,7 @@ func (hc Backend) run() {
 		req, err := http.NewRequest(http.MethodPost, "https://www.dshield.org/submitapi/", r)
 		if err != nil {
 			log.Errorf("Could create new request: %s", err.Error())
+			pushers.DeliveryFailed("dshield", len(docs))
 			return
 		}
 
@@ -217,11 +235,13 @@ func (hc Backend) run() {
 		resp, err := client.Do(req)
 		if err != nil {
 			log.Errorf("Could not submit event to DShield: %s", err.Error())
+			pushers.DeliveryFailed("dshield", len(docs))
 			return
 		}
 
 		if resp.StatusCode != http.StatusOK {
 			log.Errorf("Could not submit event to DShield: %d", resp.StatusCode)
+			pushers.DeliveryFailed("dshield", len(docs))
 			return
 		}
 
@@ -259,6 +279,7 @@ func (hc Backend) send(msg json.Marshaler) {
 	case hc.ch <- msg:
 	default:
 		log.Errorf("Could not send more messages, channel full")
+		pushers.DeliveryFailed("dshield", 1)
 	}
 }
 
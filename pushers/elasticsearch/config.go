@@ -14,14 +14,17 @@
 package elasticsearch
 
 import (
-	"crypto/tls"
 	"errors"
+	"fmt"
 	"strings"
 
 	"net/http"
 	"net/url"
 	"time"
 
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
 	elastic "gopkg.in/olivere/elastic.v5"
 )
 
@@ -45,13 +48,31 @@ type Config struct {
 	// Sniff defines if the client should find all nodes
 	Sniff bool `toml:"sniff"`
 
+	// BatchSize is the number of events indexed with a single bulk request
+	BatchSize int `toml:"batch-size"`
+
+	// FlushInterval is the maximum time events are queued before being
+	// indexed
+	FlushInterval config.Delay `toml:"flush-interval"`
+
+	// QueueSize is the number of events queued while Elasticsearch is
+	// unavailable or can't keep up, events are dropped when the queue is
+	// full
+	QueueSize int `toml:"queue-size"`
+
+	// MaxRetries is the number of times a bulk request is retried after a
+	// 429 or 5xx response
+	MaxRetries int `toml:"max-retries"`
+
+	// Backoff is the time before the first retry, the time is doubled
+	// with every retry
+	Backoff config.Delay `toml:"backoff"`
+
 	index string
 }
 
 // UnmarshalTOML deserializes the giving data into the config.
 func (c *Config) UnmarshalTOML(p interface{}) error {
-	tlsConfig := &tls.Config{}
-
 	c.options = []elastic.ClientOptionFunc{
 		elastic.SetRetrier(&Retrier{}),
 	}
@@ -102,7 +123,10 @@ func (c *Config) UnmarshalTOML(p interface{}) error {
 		c.InsecureSkipVerify = b
 	}
 
-	tlsConfig.InsecureSkipVerify = c.InsecureSkipVerify
+	tlsConfig, err := pushers.TLSConfigFrom(data).ClientConfig(c.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
 
 	c.Sniff = false
 
@@ -114,8 +138,41 @@ func (c *Config) UnmarshalTOML(p interface{}) error {
 
 	c.options = append(c.options, elastic.SetSniff(c.Sniff))
 
+	for key, v := range map[string]*int{
+		"batch-size":  &c.BatchSize,
+		"queue-size":  &c.QueueSize,
+		"max-retries": &c.MaxRetries,
+	} {
+		if n, ok := data[key]; !ok {
+		} else if i, ok := n.(int64); !ok || i < 0 {
+			return fmt.Errorf("Elasticsearch %s should be a positive number", key)
+		} else {
+			*v = int(i)
+		}
+	}
+
+	for key, v := range map[string]*config.Delay{
+		"flush-interval": &c.FlushInterval,
+		"backoff":        &c.Backoff,
+	} {
+		if d, ok := data[key]; !ok {
+		} else if s, ok := d.(string); !ok {
+			return fmt.Errorf("Elasticsearch %s should be a duration", key)
+		} else if err := v.UnmarshalText([]byte(s)); err != nil {
+			return err
+		}
+	}
+
+	proxyURL, _ := data["proxy"].(string)
+
+	fn, err := proxy.New(proxyURL)
+	if err != nil {
+		return err
+	}
+
 	c.options = append(c.options, elastic.SetHttpClient(&http.Client{
 		Transport: &http.Transport{
+			Proxy:               fn,
 			MaxIdleConnsPerHost: 5,
 			TLSClientConfig:     tlsConfig,
 		},
@@ -15,13 +15,18 @@ package elasticsearch
 
 import (
 	"context"
-
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
 	"time"
 
 	uuid "github.com/satori/go.uuid"
 
 	elastic "gopkg.in/olivere/elastic.v5"
 
+	"github.com/honeytrap/honeytrap/config"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 
@@ -34,26 +39,53 @@ var (
 
 var log = logging.MustGetLogger("channels/elasticsearch")
 
+// maxBackoff is the maximum time between retries of a bulk request.
+const maxBackoff = time.Minute
+
 // Backend defines a struct which provides a channel for delivery
 // push messages to an elasticsearch api.
 type Backend struct {
+	// dropped is the number of events dropped since the last report, the
+	// first field to be 64 bit aligned for atomic access
+	dropped uint64
+
 	Config
 
 	es *elastic.Client
 	ch chan map[string]interface{}
+
+	// channel receives the reports of dropped events
+	channel pushers.Channel
 }
 
 func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
-	ch := make(chan map[string]interface{}, 100)
-
 	c := Backend{
-		ch: ch,
+		Config: Config{
+			BatchSize:     100,
+			FlushInterval: config.Delay(10 * time.Second),
+			QueueSize:     1000,
+			MaxRetries:    5,
+			Backoff:       config.Delay(time.Second),
+		},
+		channel: pushers.MustDummy(),
 	}
 
 	for _, optionFn := range options {
-		optionFn(&c)
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
 	}
 
+	if c.BatchSize < 1 {
+		return nil, errors.New("Elasticsearch batch-size should be at least 1")
+	}
+
+	if c.FlushInterval <= 0 {
+		return nil, errors.New("Elasticsearch flush-interval should be positive")
+	}
+
+	c.ch = make(chan map[string]interface{}, c.QueueSize)
+
 	es, err := elastic.NewClient(
 		c.options...,
 	)
@@ -68,49 +100,226 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 	return &c, nil
 }
 
-func (hc Backend) run() {
+// SetChannel sets the channel the dropped events are reported to.
+func (hc *Backend) SetChannel(c pushers.Channel) {
+	hc.channel = c
+}
+
+type document struct {
+	id  string
+	doc map[string]interface{}
+}
+
+func (hc *Backend) run() {
 	log.Debug("Indexer started...")
 	defer log.Debug("Indexer stopped...")
 
-	bulk := hc.es.Bulk()
+	flush := time.NewTicker(hc.FlushInterval.Duration())
+	defer flush.Stop()
+
+	batch := []document{}
 
 	count := 0
 	for {
 		select {
 		case doc := <-hc.ch:
-			messageID := uuid.NewV4()
+			batch = append(batch, document{
+				id:  uuid.NewV4().String(),
+				doc: doc,
+			})
+
+			if len(batch) < hc.BatchSize {
+				continue
+			}
+		case <-flush.C:
+		}
 
+		if len(batch) > 0 {
+			indexed := hc.bulk(batch)
+			count += indexed
+
+			log.Debugf("Bulk indexing: %d total %d", indexed, count)
+
+			batch = []document{}
+		}
+
+		hc.reportDropped()
+	}
+}
+
+// retryable returns true for the statuses of rejected requests, the
+// request can be retried when the cluster is less busy.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// bulk indexes the documents, the requests and documents rejected with a
+// 429 or 5xx status are retried with exponential backoff. Returns the
+// number of indexed documents.
+func (hc *Backend) bulk(docs []document) int {
+	indexed := 0
+
+	backoff := hc.Backoff.Duration()
+
+	for retry := 0; ; retry++ {
+		if retry > hc.MaxRetries {
+			log.Errorf("Error indexing %d events, giving up after %d retries", len(docs), hc.MaxRetries)
+			pushers.DeliveryFailed("elasticsearch", len(docs))
+			return indexed
+		}
+
+		if retry > 0 {
+			log.Warningf("Retrying %d events in %s", len(docs), backoff)
+
+			// the queue fills up while waiting, and drops the events
+			// Elasticsearch can't keep up with
+			time.Sleep(backoff)
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+
+		bulk := hc.es.Bulk()
+
+		for _, d := range docs {
 			bulk = bulk.Add(elastic.NewBulkIndexRequest().
 				Index(hc.index).
 				Type("event").
-				Id(messageID.String()).
-				Doc(doc),
+				Id(d.id).
+				Doc(d.doc),
 			)
-
-			if bulk.NumberOfActions() < 10 {
-				continue
-			}
-		case <-time.After(time.Second * 10):
 		}
 
-		if bulk.NumberOfActions() == 0 {
-		} else if response, err := bulk.Do(context.Background()); err != nil {
+		response, err := bulk.Do(context.Background())
+		if err == nil {
+		} else if e, ok := err.(*elastic.Error); ok && !retryable(e.Status) {
 			log.Errorf("Error indexing: %s", err.Error())
+			pushers.DeliveryFailed("elasticsearch", len(docs))
+			return indexed
 		} else {
-			indexed := response.Indexed()
-			count += len(indexed)
+			// unavailable nodes are retried as well
+			log.Errorf("Error indexing: %s", err.Error())
+			continue
+		}
+
+		indexed += len(response.Succeeded())
+
+		ids := map[string]document{}
+		for _, d := range docs {
+			ids[d.id] = d
+		}
 
-			for _, item := range response.Failed() {
+		rejected := []document{}
+
+		for _, item := range response.Failed() {
+			if retryable(item.Status) {
+				rejected = append(rejected, ids[item.Id])
+				continue
+			}
+
+			if item.Error != nil {
 				log.Errorf("Error indexing item: %s with error: %+v", item.Id, *item.Error)
+			} else {
+				log.Errorf("Error indexing item: %s with status: %d", item.Id, item.Status)
 			}
 
-			log.Debugf("Bulk indexing: %d total %d", len(indexed), count)
+			pushers.DeliveryFailed("elasticsearch", 1)
 		}
+
+		if len(rejected) == 0 {
+			return indexed
+		}
+
+		docs = rejected
 	}
 }
 
+// Deliver indexes the events of the spool. The ids of the documents are
+// derived from the events, so a batch that is delivered again doesn't
+// index the events twice.
+func (hc *Backend) Deliver(events []event.Event) error {
+	bulk := hc.es.Bulk()
+
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			pushers.DeliveryFailed("elasticsearch", 1)
+			continue
+		}
+
+		doc := map[string]interface{}{}
+
+		e.Range(func(key, value interface{}) bool {
+			if keyName, ok := key.(string); ok {
+				doc[keyName] = value
+			}
+			return true
+		})
+
+		bulk = bulk.Add(elastic.NewBulkIndexRequest().
+			Index(hc.index).
+			Type("event").
+			Id(uuid.NewV5(uuid.NamespaceOID, string(data)).String()).
+			Doc(doc),
+		)
+	}
+
+	if bulk.NumberOfActions() == 0 {
+		return nil
+	}
+
+	response, err := bulk.Do(context.Background())
+	if err == nil {
+	} else if e, ok := err.(*elastic.Error); ok && !retryable(e.Status) {
+		log.Errorf("Error indexing: %s", err.Error())
+		pushers.DeliveryFailed("elasticsearch", len(events))
+		return nil
+	} else {
+		return err
+	}
+
+	rejected := 0
+
+	for _, item := range response.Failed() {
+		if retryable(item.Status) {
+			rejected++
+			continue
+		}
+
+		log.Errorf("Error indexing item: %s with status: %d", item.Id, item.Status)
+		pushers.DeliveryFailed("elasticsearch", 1)
+	}
+
+	if rejected > 0 {
+		return fmt.Errorf("%d events rejected", rejected)
+	}
+
+	return nil
+}
+
+// reportDropped sends an event with the number of events dropped since
+// the last report.
+func (hc *Backend) reportDropped() {
+	n := atomic.SwapUint64(&hc.dropped, 0)
+	if n == 0 {
+		return
+	}
+
+	log.Warningf("Queue full, %d events dropped", n)
+
+	hc.channel.Send(event.New(
+		event.Sensor("honeytrap"),
+		event.Category("channel"),
+		event.Type("events-dropped"),
+		event.Custom("channel.type", "elasticsearch"),
+		event.Custom("channel.dropped", n),
+	))
+}
+
 // Send delivers the giving push messages into the internal elastic search endpoint.
-func (hc Backend) Send(message event.Event) {
+// The event is dropped when the queue is full.
+func (hc *Backend) Send(message event.Event) {
 	mp := make(map[string]interface{})
 
 	message.Range(func(key, value interface{}) bool {
@@ -120,5 +329,10 @@ func (hc Backend) Send(message event.Event) {
 		return true
 	})
 
-	hc.ch <- mp
+	select {
+	case hc.ch <- mp:
+	default:
+		atomic.AddUint64(&hc.dropped, 1)
+		pushers.DeliveryFailed("elasticsearch", 1)
+	}
 }
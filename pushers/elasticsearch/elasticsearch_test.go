@@ -11,4 +11,276 @@
 // WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 // See the License for the specific language governing permissions and
 // limitations under the License.
-package elasticsearch_test
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// bulkServer is an Elasticsearch node replying to the bulk requests with
+// the statuses of the reply func.
+type bulkServer struct {
+	*httptest.Server
+
+	m        sync.Mutex
+	requests [][]string
+
+	reply func(n int, ids []string) (int, []int)
+}
+
+func newBulkServer(reply func(n int, ids []string) (int, []int)) *bulkServer {
+	s := &bulkServer{
+		reply: reply,
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *bulkServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/_bulk" {
+		fmt.Fprint(w, `{"version":{"number":"5.6.0"}}`)
+		return
+	}
+
+	ids := []string{}
+
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		action := struct {
+			Index struct {
+				ID string `json:"_id"`
+			} `json:"index"`
+		}{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &action); err == nil && action.Index.ID != "" {
+			ids = append(ids, action.Index.ID)
+		}
+	}
+
+	s.m.Lock()
+	s.requests = append(s.requests, ids)
+	n := len(s.requests)
+	s.m.Unlock()
+
+	status, statuses := s.reply(n, ids)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"status":%d}`, status)
+		return
+	}
+
+	items := []map[string]interface{}{}
+	for i, id := range ids {
+		items = append(items, map[string]interface{}{
+			"index": map[string]interface{}{
+				"_id":    id,
+				"status": statuses[i],
+			},
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": items,
+	})
+}
+
+func (s *bulkServer) Requests() [][]string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([][]string{}, s.requests...)
+}
+
+func newBackend(t *testing.T, url string, options string, channel pushers.Channel) pushers.Channel {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(fmt.Sprintf("[p]\nurl=\"%s/honeytrap\"\n%s", url, options), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(pushers.WithConfig(s.P, &md), pushers.WithChannel(channel))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBulkRetry(t *testing.T) {
+	s := newBulkServer(func(n int, ids []string) (int, []int) {
+		switch n {
+		case 1:
+			return http.StatusTooManyRequests, nil
+		case 2:
+			// the first event is rejected, the second indexed
+			return http.StatusOK, []int{http.StatusTooManyRequests, http.StatusCreated}
+		}
+
+		statuses := []int{}
+		for range ids {
+			statuses = append(statuses, http.StatusCreated)
+		}
+
+		return http.StatusOK, statuses
+	})
+
+	defer s.Close()
+
+	c := newBackend(t, s.URL, "batch-size=2\nbackoff=\"10ms\"", pushers.MustDummy())
+
+	c.Send(event.New(event.Category("test")))
+	c.Send(event.New(event.Category("test")))
+
+	waitFor(t, func() bool {
+		return len(s.Requests()) >= 3
+	})
+
+	requests := s.Requests()
+
+	if len(requests[0]) != 2 || len(requests[1]) != 2 {
+		t.Fatalf("Expected the batch to be retried, got %v", requests)
+	}
+
+	if len(requests[2]) != 1 || requests[2][0] != requests[1][0] {
+		t.Errorf("Expected the rejected event to be retried, got %v", requests)
+	}
+}
+
+type recordingChannel struct {
+	m      sync.Mutex
+	events []event.Event
+}
+
+func (c *recordingChannel) Send(e event.Event) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.events = append(c.events, e)
+}
+
+func (c *recordingChannel) Events() []event.Event {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return append([]event.Event{}, c.events...)
+}
+
+func TestDropped(t *testing.T) {
+	release := make(chan struct{})
+
+	s := newBulkServer(func(n int, ids []string) (int, []int) {
+		<-release
+
+		statuses := []int{}
+		for range ids {
+			statuses = append(statuses, http.StatusCreated)
+		}
+
+		return http.StatusOK, statuses
+	})
+
+	defer s.Close()
+
+	rc := &recordingChannel{}
+
+	c := newBackend(t, s.URL, "batch-size=1\nqueue-size=1\nflush-interval=\"10ms\"", rc)
+
+	c.Send(event.New(event.Category("test")))
+
+	// the indexer is blocked by the first request
+	waitFor(t, func() bool {
+		return len(s.Requests()) == 1
+	})
+
+	for i := 0; i < 5; i++ {
+		c.Send(event.New(event.Category("test")))
+	}
+
+	close(release)
+
+	waitFor(t, func() bool {
+		return len(rc.Events()) > 0
+	})
+
+	e := rc.Events()[0]
+	if e.Get("type") != "events-dropped" || e.Get("channel.type") != "elasticsearch" {
+		t.Errorf("Expected dropped events to be reported, got %v", e)
+	}
+
+	dropped := uint64(0)
+
+	e.Range(func(k, v interface{}) bool {
+		if k == "channel.dropped" {
+			dropped, _ = v.(uint64)
+		}
+
+		return true
+	})
+
+	if dropped != 4 {
+		t.Errorf("Expected 4 dropped events, got %d", dropped)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	s := newBulkServer(func(n int, ids []string) (int, []int) {
+		if n == 1 {
+			return http.StatusServiceUnavailable, nil
+		}
+
+		statuses := []int{}
+		for range ids {
+			statuses = append(statuses, http.StatusCreated)
+		}
+
+		return http.StatusOK, statuses
+	})
+
+	defer s.Close()
+
+	c := newBackend(t, s.URL, "", pushers.MustDummy())
+
+	events := []event.Event{
+		event.New(event.Category("test"), event.Custom("date", time.Unix(0, 0))),
+	}
+
+	if err := c.(pushers.Deliverer).Deliver(events); err == nil {
+		t.Fatal("Expected an error while the cluster is unavailable")
+	}
+
+	if err := c.(pushers.Deliverer).Deliver(events); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := s.Requests()
+	if len(requests) != 2 || requests[0][0] != requests[1][0] {
+		t.Errorf("Expected the event to be delivered again with the same id, got %v", requests)
+	}
+}
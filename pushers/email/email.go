@@ -0,0 +1,418 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/webhook"
+	"github.com/honeytrap/honeytrap/reporter"
+	"github.com/honeytrap/honeytrap/severity"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:channels:email")
+
+var (
+	_ = pushers.Register("email", New)
+)
+
+/*
+Configuration example:
+
+[channel.email]
+type="email"
+host="smtp.example.com"
+port=587
+username="honeytrap"
+password="secret"
+from="honeytrap@example.com"
+to=["soc@example.com"]
+alert-severity="high"
+digest-interval="24h"
+*/
+
+// Security modes of the connection to the mail server.
+const (
+	SecurityStartTLS = "starttls"
+	SecurityTLS      = "tls"
+	SecurityNone     = "none"
+)
+
+// DefaultSubject is the default subject of the alerts.
+const DefaultSubject = `[honeytrap] {{.severity}} {{.category}} {{.type}}{{with index . "source-ip"}} from {{.}}{{end}}`
+
+// DefaultBody is the default body of the alerts.
+const DefaultBody = `{{range $key, $value := .}}{{$key}}: {{$value}}
+{{end}}`
+
+// DefaultDigestSubject is the default subject of the digests.
+const DefaultDigestSubject = `[honeytrap] {{.Events}} events since {{.Start.UTC.Format "2006-01-02 15:04"}}`
+
+// DefaultDigestBody is the default body of the digests.
+const DefaultDigestBody = `Honeytrap digest {{.Start.UTC.Format "2006-01-02 15:04"}} - {{.End.UTC.Format "2006-01-02 15:04"}} UTC
+
+Events:           {{.Events}}
+Unique attackers: {{.UniqueAttackers}}
+{{with .TopAttackers}}
+Top attackers:
+{{range .}}  {{printf "%6d" .Count}}  {{.Value}}
+{{end}}{{end}}{{with .Services}}
+Top services:
+{{range .}}  {{printf "%6d" .Count}}  {{.Value}}
+{{end}}{{end}}{{with .Severities}}
+Severities:
+{{range .}}  {{printf "%6d" .Count}}  {{.Value}}
+{{end}}{{end}}{{with .TopCredentials}}
+Top credentials:
+{{range .}}  {{printf "%6d" .Count}}  {{.Value}}
+{{end}}{{end}}`
+
+// Config defines a struct which holds configuration field values used by the
+// Backend for its delivery of mails.
+type Config struct {
+	Host string `toml:"host"`
+	Port int    `toml:"port"`
+
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+
+	// Security is the security of the connection: starttls, tls or none
+	Security string `toml:"security"`
+
+	Insecure bool `toml:"insecure"`
+
+	pushers.TLSConfig
+
+	From string   `toml:"from"`
+	To   []string `toml:"to"`
+
+	// AlertSeverity is the minimum severity of the events that are mailed
+	// immediately, empty disables the alerts
+	AlertSeverity string `toml:"alert-severity"`
+
+	// Subject and Template are the text/templates of the alerts
+	Subject  string `toml:"subject"`
+	Template string `toml:"template"`
+
+	// DigestInterval is the interval of the digests of all events, zero
+	// disables the digests
+	DigestInterval config.Delay `toml:"digest-interval"`
+
+	// DigestTop is the number of entries in the top lists of the digests
+	DigestTop int `toml:"digest-top"`
+
+	// DigestSubject and DigestTemplate are the text/templates of the
+	// digests, executed with the summary of the interval
+	DigestSubject  string `toml:"digest-subject"`
+	DigestTemplate string `toml:"digest-template"`
+}
+
+// mail is a single message.
+type mail struct {
+	subject string
+	body    string
+}
+
+// Backend mails alerts of the important events and digests of all events.
+type Backend struct {
+	Config
+
+	subject        *template.Template
+	body           *template.Template
+	digestSubject  *template.Template
+	digestTemplate *template.Template
+
+	digest *reporter.Reporter
+
+	// send delivers the message to the recipients
+	send func(msg []byte) error
+
+	ch chan mail
+}
+
+// New returns a new instance of a Backend.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	b, err := newBackend(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	go b.run()
+
+	if b.digest != nil {
+		go b.digests()
+	}
+
+	return b, nil
+}
+
+func newBackend(options ...func(pushers.Channel) error) (*Backend, error) {
+	b := &Backend{
+		Config: Config{
+			Port:           587,
+			Security:       SecurityStartTLS,
+			AlertSeverity:  severity.High,
+			Subject:        DefaultSubject,
+			Template:       DefaultBody,
+			DigestTop:      10,
+			DigestSubject:  DefaultDigestSubject,
+			DigestTemplate: DefaultDigestBody,
+		},
+		ch: make(chan mail, 100),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.Host == "" {
+		return nil, errors.New("Invalid Config: host can not be empty")
+	}
+
+	if b.From == "" || len(b.To) == 0 {
+		return nil, errors.New("Invalid Config: from and to can not be empty")
+	}
+
+	switch b.Security {
+	case SecurityStartTLS, SecurityTLS, SecurityNone:
+	default:
+		return nil, fmt.Errorf("Invalid Config: unknown security %q, should be starttls, tls or none", b.Security)
+	}
+
+	var err error
+	for _, t := range []struct {
+		dst  **template.Template
+		text string
+	}{
+		{&b.subject, b.Subject},
+		{&b.body, b.Template},
+		{&b.digestSubject, b.DigestSubject},
+		{&b.digestTemplate, b.DigestTemplate},
+	} {
+		if *t.dst, err = webhook.NewTemplate(t.text); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.DigestInterval.Duration() > 0 {
+		if b.digest, err = reporter.New("email", func(r *reporter.Reporter) error {
+			r.Interval = b.DigestInterval
+			r.Top = b.DigestTop
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	b.send = b.smtp
+
+	return b, nil
+}
+
+// run delivers the queued mails.
+func (b *Backend) run() {
+	for m := range b.ch {
+		if err := b.send(b.message(m, time.Now())); err != nil {
+			log.Errorf("Error sending mail to %s: %s", b.Host, err.Error())
+			pushers.DeliveryFailed("email", 1)
+		}
+	}
+}
+
+// digests queues the digest of the events every interval.
+func (b *Backend) digests() {
+	for now := range time.Tick(b.DigestInterval.Duration()) {
+		if m, ok := b.summarize(now); ok {
+			b.queue(m)
+		}
+	}
+}
+
+// summarize renders the digest of the interval, there is no digest of an
+// interval without events.
+func (b *Backend) summarize(now time.Time) (mail, bool) {
+	s := b.digest.Summarize(now)
+	if s.Events == 0 {
+		return mail{}, false
+	}
+
+	subject, body := &bytes.Buffer{}, &bytes.Buffer{}
+
+	if err := b.digestSubject.Execute(subject, s); err != nil {
+		log.Errorf("Error formatting digest: %s", err.Error())
+		return mail{}, false
+	}
+
+	if err := b.digestTemplate.Execute(body, s); err != nil {
+		log.Errorf("Error formatting digest: %s", err.Error())
+		return mail{}, false
+	}
+
+	return mail{subject: subject.String(), body: body.String()}, true
+}
+
+func (b *Backend) queue(m mail) {
+	select {
+	case b.ch <- m:
+	default:
+		log.Errorf("Could not queue mail, queue is full")
+		pushers.DeliveryFailed("email", 1)
+	}
+}
+
+// message returns the message with the headers of the mail.
+func (b *Backend) message(m mail, now time.Time) []byte {
+	// headers can't span lines
+	subject := strings.Join(strings.Fields(m.subject), " ")
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", b.From)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(b.To, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(buf, "\r\n")
+
+	for _, line := range strings.Split(strings.TrimRight(m.body, "\n"), "\n") {
+		// lines with a single dot end the data
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+
+		fmt.Fprintf(buf, "%s\r\n", strings.TrimRight(line, "\r"))
+	}
+
+	return buf.Bytes()
+}
+
+// smtp delivers the message to the mail server.
+func (b *Backend) smtp(msg []byte) error {
+	addr := net.JoinHostPort(b.Host, strconv.Itoa(b.Port))
+
+	tc, err := b.TLSConfig.ClientConfig(b.Insecure)
+	if err != nil {
+		return err
+	}
+
+	if tc.ServerName == "" {
+		tc.ServerName = b.Host
+	}
+
+	var conn net.Conn
+	if b.Security == SecurityTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 20 * time.Second}, "tcp", addr, tc)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 20*time.Second)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	c, err := smtp.NewClient(conn, b.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	defer c.Close()
+
+	if b.Security == SecurityStartTLS {
+		if err := c.StartTLS(tc); err != nil {
+			return err
+		}
+	}
+
+	if b.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", b.Username, b.Password, b.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(b.From); err != nil {
+		return err
+	}
+
+	for _, to := range b.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// Send adds the event to the digest, and mails it immediately if its
+// severity is at least the alert severity.
+func (b *Backend) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	if b.digest != nil {
+		b.digest.Send(e)
+	}
+
+	if b.AlertSeverity == "" || severity.Compare(e.Get("severity"), b.AlertSeverity) < 0 {
+		return
+	}
+
+	subject, err := webhook.Render(b.subject, e)
+	if err != nil {
+		log.Errorf("Error formatting alert: %s", err.Error())
+		pushers.DeliveryFailed("email", 1)
+		return
+	}
+
+	body, err := webhook.Render(b.body, e)
+	if err != nil {
+		log.Errorf("Error formatting alert: %s", err.Error())
+		pushers.DeliveryFailed("email", 1)
+		return
+	}
+
+	b.queue(mail{subject: subject, body: body})
+}
@@ -0,0 +1,236 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package email
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+func withConfig(c Config) func(pushers.Channel) error {
+	return func(ch pushers.Channel) error {
+		b := ch.(*Backend)
+		c.Security = b.Security
+		c.Subject, c.Template = b.Subject, b.Template
+		c.DigestSubject, c.DigestTemplate = b.DigestSubject, b.DigestTemplate
+		c.DigestTop = b.DigestTop
+		if c.AlertSeverity == "" {
+			c.AlertSeverity = b.AlertSeverity
+		}
+		b.Config = c
+		return nil
+	}
+}
+
+func TestAlert(t *testing.T) {
+	b, err := newBackend(withConfig(Config{
+		Host: "localhost",
+		From: "honeytrap@example.com",
+		To:   []string{"soc@example.com"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Send(event.New(
+		event.Category("ssh"),
+		event.Custom("severity", "medium"),
+	))
+
+	b.Send(event.New(
+		event.Category("ssh"),
+		event.Type("login"),
+		event.Custom("severity", "critical"),
+		event.Custom("source-ip", "192.0.2.1"),
+	))
+
+	if len(b.ch) != 1 {
+		t.Fatalf("Expected 1 alert, got %d", len(b.ch))
+	}
+
+	m := <-b.ch
+	if m.subject != "[honeytrap] critical ssh login from 192.0.2.1" {
+		t.Errorf("Unexpected subject %q", m.subject)
+	}
+
+	if !strings.Contains(m.body, "source-ip: 192.0.2.1\n") {
+		t.Errorf("Unexpected body %q", m.body)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	b, err := newBackend(withConfig(Config{
+		Host:           "localhost",
+		From:           "honeytrap@example.com",
+		To:             []string{"soc@example.com"},
+		DigestInterval: config.Delay(time.Hour),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := b.summarize(time.Now()); ok {
+		t.Errorf("Expected no digest without events")
+	}
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+		b.Send(event.New(
+			event.Category("telnet"),
+			event.Custom("source-ip", ip),
+		))
+	}
+
+	m, ok := b.summarize(time.Now())
+	if !ok {
+		t.Fatal("Expected a digest")
+	}
+
+	if !strings.HasPrefix(m.subject, "[honeytrap] 3 events since ") {
+		t.Errorf("Unexpected subject %q", m.subject)
+	}
+
+	for _, s := range []string{"Unique attackers: 2", "     2  192.0.2.1", "     3  telnet"} {
+		if !strings.Contains(m.body, s) {
+			t.Errorf("Expected %q in digest %q", s, m.body)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	b := &Backend{
+		Config: Config{
+			From: "honeytrap@example.com",
+			To:   []string{"a@example.com", "b@example.com"},
+		},
+	}
+
+	msg := string(b.message(mail{subject: "alert\r\nBcc: x@example.com", body: "a\n.\nb\n"}, time.Unix(0, 0).UTC()))
+
+	expected := "From: honeytrap@example.com\r\n" +
+		"To: a@example.com, b@example.com\r\n" +
+		"Subject: alert Bcc: x@example.com\r\n" +
+		"Date: Thu, 01 Jan 1970 00:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"a\r\n..\r\nb\r\n"
+
+	if msg != expected {
+		t.Errorf("Expected message %q, got %q", expected, msg)
+	}
+}
+
+// serve answers a single smtp session and returns the received data.
+func serve(l net.Listener) <-chan string {
+	data := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			data <- ""
+			return
+		}
+
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+
+		received := ""
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO", "HELO":
+				conn.Write([]byte("250 localhost\r\n"))
+			case "DATA":
+				conn.Write([]byte("354 go ahead\r\n"))
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+
+					received += line
+				}
+
+				conn.Write([]byte("250 ok\r\n"))
+			case "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				data <- received
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+
+		data <- received
+	}()
+
+	return data
+}
+
+func TestSMTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	data := serve(l)
+
+	b, err := newBackend(withConfig(Config{
+		Host: "127.0.0.1",
+		Port: l.Addr().(*net.TCPAddr).Port,
+		From: "honeytrap@example.com",
+		To:   []string{"soc@example.com"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Security = SecurityNone
+
+	if err := b.send(b.message(mail{subject: "test", body: "hello"}, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	if received := <-data; !strings.Contains(received, "Subject: test\r\n") || !strings.HasSuffix(received, "\r\nhello\r\n") {
+		t.Errorf("Unexpected data %q", received)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for i, c := range []Config{
+		{From: "a@example.com", To: []string{"b@example.com"}},
+		{Host: "localhost", To: []string{"b@example.com"}},
+		{Host: "localhost", From: "a@example.com"},
+	} {
+		if _, err := newBackend(withConfig(c)); err == nil {
+			t.Errorf("Expected an error for config %d", i)
+		}
+	}
+}
@@ -0,0 +1,554 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+/*
+Filter expressions select the events delivered to a channel, eg.
+
+	filter = "category != 'heartbeat' && destination.port == 22"
+
+Fields are referred to by name, dots can be used instead of dashes
+(destination.port is destination-port). Values are strings ('' or ""),
+numbers, true and false, or lists ([22, 2222]).
+
+Operators, from low to high precedence:
+
+	||                          or
+	&&                          and
+	!                           not
+	== != < <= > >= =~ !~ in    comparison, =~ matches a regular expression
+
+A field without comparison is true when the event contains the field and the
+value isn't empty, false or zero.
+*/
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// operators are the operators, longest first.
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func isIdent(r rune, first bool) bool {
+	if unicode.IsLetter(r) || r == '_' {
+		return true
+	}
+
+	return !first && (unicode.IsDigit(r) || r == '.' || r == '-')
+}
+
+func tokenize(s string) ([]token, error) {
+	tokens := []token{}
+
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			j := i + 1
+
+			sb := strings.Builder{}
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+
+				sb.WriteRune(runes[j])
+			}
+
+			if j == len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[i : j+1]), value: sb.String(), pos: i})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+
+			f, err := strconv.ParseFloat(string(runes[i:j]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s at %d", string(runes[i:j]), i)
+			}
+
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[i:j]), value: f, pos: i})
+			i = j
+		case isIdent(r, true):
+			j := i + 1
+			for j < len(runes) && isIdent(runes[j], false) {
+				j++
+			}
+
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[i:j]), pos: i})
+			i = j
+		default:
+			found := false
+
+			for _, op := range operators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len([]rune(op))
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// valueFunc returns a value of the event, a string, float64, bool, list of
+// values or nil when the field is missing.
+type valueFunc func(event.Event) interface{}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == op {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	if t.kind == tokenEOF {
+		return fmt.Errorf("%s at end of expression", fmt.Sprintf(format, args...))
+	}
+
+	return fmt.Errorf("%s at %d", fmt.Sprintf(format, args...), t.pos)
+}
+
+func (p *parser) or() (FilterFunc, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(e event.Event) bool {
+			return l(e) || right(e)
+		}
+	}
+
+	return left, nil
+}
+
+func (p *parser) and() (FilterFunc, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(e event.Event) bool {
+			return l(e) && right(e)
+		}
+	}
+
+	return left, nil
+}
+
+func (p *parser) not() (FilterFunc, error) {
+	if !p.accept("!") {
+		return p.comparison()
+	}
+
+	fn, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e event.Event) bool {
+		return !fn(e)
+	}, nil
+}
+
+func (p *parser) comparison() (FilterFunc, error) {
+	if p.accept("(") {
+		fn, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if t := p.next(); t.kind != tokenOperator || t.text != ")" {
+			return nil, p.errorf(t, "expected )")
+		}
+
+		return fn, nil
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+
+	op := t.text
+	if t.kind == tokenIdent && op == "in" {
+	} else if t.kind != tokenOperator {
+		return truthy(left), nil
+	}
+
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+
+		return compareFunc(op, left, right), nil
+	case "=~", "!~":
+		p.next()
+
+		t := p.next()
+		if t.kind != tokenString {
+			return nil, p.errorf(t, "expected regular expression")
+		}
+
+		rx, err := regexp.Compile(t.value.(string))
+		if err != nil {
+			return nil, p.errorf(t, "invalid regular expression: %s", err.Error())
+		}
+
+		return func(e event.Event) bool {
+			v := left(e)
+			return (v != nil && rx.MatchString(toString(v))) == (op == "=~")
+		}, nil
+	case "in":
+		p.next()
+
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(e event.Event) bool {
+			v := left(e)
+
+			list, ok := right(e).([]interface{})
+			if !ok {
+				return false
+			}
+
+			for _, item := range list {
+				if compare(v, item) == 0 {
+					return true
+				}
+			}
+
+			return false
+		}, nil
+	}
+
+	return truthy(left), nil
+}
+
+func (p *parser) operand() (valueFunc, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenString, tokenNumber:
+		v := t.value
+		return func(event.Event) interface{} { return v }, nil
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			v := t.text == "true"
+			return func(event.Event) interface{} { return v }, nil
+		}
+
+		return fieldFunc(t.text), nil
+	case tokenOperator:
+		if t.text != "[" {
+			break
+		}
+
+		items := []valueFunc{}
+
+		for !p.accept("]") {
+			if len(items) > 0 && !p.accept(",") {
+				return nil, p.errorf(p.peek(), "expected , or ]")
+			}
+
+			item, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		return func(e event.Event) interface{} {
+			list := make([]interface{}, len(items))
+			for i, item := range items {
+				list[i] = item(e)
+			}
+
+			return list
+		}, nil
+	}
+
+	return nil, p.errorf(t, "expected field or value")
+}
+
+// fieldFunc returns the value of the field, with dots instead of dashes
+// when the event doesn't contain the field.
+func fieldFunc(name string) valueFunc {
+	alternative := strings.Replace(name, ".", "-", -1)
+
+	return func(e event.Event) interface{} {
+		var v, alt interface{}
+
+		e.Range(func(key, value interface{}) bool {
+			switch key {
+			case name:
+				v = value
+				return false
+			case alternative:
+				alt = value
+			}
+
+			return true
+		})
+
+		if v == nil {
+			v = alt
+		}
+
+		return normalize(v)
+	}
+}
+
+// normalize converts the numbers of the event to float64.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case nil, string, bool, float64:
+		return v
+	case int:
+		return float64(n)
+	case int8:
+		return float64(n)
+	case int16:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint:
+		return float64(n)
+	case uint8:
+		return float64(n)
+	case uint16:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+
+	return fmt.Sprint(v)
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
+
+func truthy(fn valueFunc) FilterFunc {
+	return func(e event.Event) bool {
+		switch v := fn(e).(type) {
+		case nil:
+			return false
+		case bool:
+			return v
+		case float64:
+			return v != 0
+		case string:
+			return v != ""
+		}
+
+		return true
+	}
+}
+
+// compare compares the values numerically when both are numbers, or a
+// number and a numeric string, and as strings otherwise. Missing values
+// only equal missing values.
+func compare(a, b interface{}) int {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0
+		}
+
+		return 2
+	}
+
+	af, aok := a.(float64)
+	bf, bok := b.(float64)
+
+	if aok && !bok {
+		bf, bok = parseNumber(b)
+	} else if bok && !aok {
+		af, aok = parseNumber(a)
+	}
+
+	if aok && bok {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+
+		return 0
+	}
+
+	return strings.Compare(toString(a), toString(b))
+}
+
+func parseNumber(v interface{}) (float64, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+func compareFunc(op string, left, right valueFunc) FilterFunc {
+	return func(e event.Event) bool {
+		c := compare(left(e), right(e))
+
+		switch op {
+		case "==":
+			return c == 0
+		case "!=":
+			return c != 0
+		}
+
+		// missing values can't be ordered
+		if c == 2 {
+			return false
+		}
+
+		switch op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		}
+
+		return c >= 0
+	}
+}
+
+// ExpressionFilterFunc returns a function for filtering events by the
+// expression.
+func ExpressionFilterFunc(expression string) (FilterFunc, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter expression: %s", err.Error())
+	}
+
+	p := &parser{tokens: tokens}
+
+	fn, err := p.or()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter expression: %s", err.Error())
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("Invalid filter expression: %s", p.errorf(t, "unexpected %s", t.text).Error())
+	}
+
+	return fn, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestExpressionFilterFunc(t *testing.T) {
+	e := event.New(
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+		event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 51234}),
+		event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "123456"),
+		event.Custom("secure", false),
+	)
+
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{`category != 'heartbeat' && destination.port == 22`, true},
+		{`category == "heartbeat" || destination-port == 2222`, false},
+		{`destination.port in [22, 2222]`, true},
+		{`category in ['http', 'https']`, false},
+		{`destination.port >= 1024`, false},
+		{`source.port > 1024 && source.port < 65536`, true},
+		{`ssh.password == 123456`, true},
+		{`ssh.username =~ '^ro+t$'`, true},
+		{`source.ip !~ '^198\.51\.100\.'`, false},
+		{`!(category == 'ssh')`, false},
+		{`!secure`, true},
+		{`ssh.username && !http.url`, true},
+		{`http.url == ''`, false},
+		{`http.url != 'x'`, true},
+		{`http.port < 80`, false},
+		{`true && (false || category == 'ssh')`, true},
+	}
+
+	for _, tc := range tests {
+		fn, err := ExpressionFilterFunc(tc.expression)
+		if err != nil {
+			t.Errorf("%s: %s", tc.expression, err.Error())
+			continue
+		}
+
+		if v := fn(e); v != tc.expected {
+			t.Errorf("%s: expected %t, got %t", tc.expression, tc.expected, v)
+		}
+	}
+}
+
+func TestExpressionFilterFuncErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{`category == 'ssh`, "unterminated string at 12"},
+		{`category ==`, "expected field or value at end of expression"},
+		{`(category == 'ssh'`, "expected ) at end of expression"},
+		{`category == 'ssh' port`, "unexpected port at 18"},
+		{`category =~ '['`, "invalid regular expression"},
+		{`category in [1 2]`, "expected , or ] at 15"},
+		{`category = 'ssh'`, "unexpected '=' at 9"},
+	}
+
+	for _, tc := range tests {
+		_, err := ExpressionFilterFunc(tc.expression)
+		if err == nil {
+			t.Errorf("%s: expected error", tc.expression)
+		} else if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error %q, got %q", tc.expression, tc.err, err.Error())
+		}
+	}
+}
@@ -156,6 +156,9 @@ func (f *FileBackend) syncLoop() {
 	ticker := time.NewTimer(f.timeout)
 	var buf bytes.Buffer
 
+	// pending is the number of events in the buffer
+	pending := 0
+
 	{
 	writeSync:
 		for {
@@ -179,9 +182,12 @@ func (f *FileBackend) syncLoop() {
 
 				if err := json.NewEncoder(&buf).Encode(req); err != nil {
 					log.Errorf("Failed to marshal PushMessage to JSON : %+q", err)
+					pushers.DeliveryFailed("file", 1)
 					continue writeSync
 				}
 
+				pending++
+
 				if buf.Len() < (500 * 1024) {
 					continue
 				}
@@ -190,6 +196,7 @@ func (f *FileBackend) syncLoop() {
 
 			if _, err := io.Copy(f.dest, &buf); err != nil && err != io.EOF {
 				log.Errorf("Failed to copy data to File : %+q", err)
+				pushers.DeliveryFailed("file", pending)
 			}
 
 			if err := f.dest.Sync(); err != nil {
@@ -198,6 +205,7 @@ func (f *FileBackend) syncLoop() {
 
 			// Reset the buffer for reuse.
 			buf.Reset()
+			pending = 0
 		}
 	}
 }
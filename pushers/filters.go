@@ -81,10 +81,10 @@ type tokenChannel struct {
 	Token string
 }
 
-// Send delivers the slice of PushMessages and using the internal filters
-// to filter out the desired messages allowed for all registered backends.
+// Send delivers a copy of the event with the token set, the event itself is
+// shared by all channels.
 func (mc tokenChannel) Send(e event.Event) {
-	mc.Channel.Send(event.Apply(e, event.Token(mc.Token)))
+	mc.Channel.Send(event.Apply(e.Copy(), event.Token(mc.Token)))
 }
 
 // TokenChannel returns a Channel to set token value.
@@ -0,0 +1,73 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/signing"
+)
+
+func TestTokenChannelSigned(t *testing.T) {
+	s, err := signing.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &recordChannel{}
+
+	c := LabelChannel(TokenChannel(rc, "sensor-1"), map[string]string{
+		"segment": "dmz",
+	})
+
+	for i := 0; i < 2; i++ {
+		e := event.New(
+			event.Category("ssh"),
+			event.Custom("ssh.password", "toor"),
+		)
+
+		s.Send(e)
+		c.Send(e)
+
+		if e.Has("token") {
+			t.Error("Expected token not to be set on the shared event")
+		}
+	}
+
+	events := []map[string]interface{}{}
+	for _, e := range rc.events {
+		if v := e.Get("token"); v != "sensor-1" {
+			t.Errorf("Expected token sensor-1, got %s", v)
+		}
+
+		// verify the events as exported by the channels
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, fields)
+	}
+
+	if err := signing.Verify(s.PublicKey(), events); err != nil {
+		t.Fatal(err)
+	}
+}
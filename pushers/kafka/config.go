@@ -13,8 +13,31 @@
 // limitations under the License.
 package kafka
 
+import "github.com/honeytrap/honeytrap/pushers"
+
 // Config defines a struct which holds configuration values for a SearchBackend.
 type Config struct {
 	Brokers []string `toml:"brokers"`
 	Topic   string   `toml:"topic"`
+
+	// Key is the field of the event used as key of the messages (eg.
+	// source-ip), the events with the same key are published to the same
+	// partition. The messages have no key when not set.
+	Key string `toml:"key"`
+
+	// Acks is the acknowledgement of the brokers a message is delivered
+	// with: none, leader or all
+	Acks string `toml:"acks"`
+
+	// TLS connects to the brokers over tls, this is enabled as well when
+	// any of the tls options is set
+	TLS bool `toml:"tls"`
+
+	pushers.TLSConfig
+
+	// SASL authenticates with the brokers when the username is set, the
+	// mechanism is PLAIN (default), SCRAM-SHA-256 or SCRAM-SHA-512
+	SASLMechanism string `toml:"sasl_mechanism"`
+	SASLUsername  string `toml:"sasl_username"`
+	SASLPassword  string `toml:"sasl_password"`
 }
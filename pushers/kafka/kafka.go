@@ -15,6 +15,9 @@ package kafka
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 
 	sarama "github.com/Shopify/sarama"
 
@@ -30,29 +33,46 @@ var (
 
 var log = logging.MustGetLogger("channels/kafka")
 
+var acks = map[string]sarama.RequiredAcks{
+	"none":   sarama.NoResponse,
+	"leader": sarama.WaitForLocal,
+	"all":    sarama.WaitForAll,
+}
+
 // Backend defines a struct which provides a channel for delivery
-// push messages to an elasticsearch api.
+// push messages to a kafka topic.
 type Backend struct {
 	Config
 
 	producer sarama.AsyncProducer
 
-	ch chan map[string]interface{}
+	ch chan *sarama.ProducerMessage
 }
 
 func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
-	ch := make(chan map[string]interface{}, 100)
+	ch := make(chan *sarama.ProducerMessage, 100)
 
 	c := Backend{
+		Config: Config{
+			Acks: "leader",
+		},
 		ch: ch,
 	}
 
 	for _, optionFn := range options {
-		optionFn(&c)
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
 	}
 
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
+	if c.Topic == "" {
+		return nil, errors.New("Kafka topic not set")
+	}
+
+	config, err := c.config()
+	if err != nil {
+		return nil, err
+	}
 
 	producer, err := sarama.NewAsyncProducer(c.Brokers, config)
 	if err != nil {
@@ -61,38 +81,130 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 	c.producer = producer
 
 	go c.run()
+	go c.report()
 
 	return &c, nil
 }
 
-func (hc Backend) run() {
-	defer hc.producer.AsyncClose()
+// config returns the configuration of the producer.
+func (hc *Backend) config() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+
+	if v, ok := acks[hc.Acks]; !ok {
+		return nil, fmt.Errorf("Kafka acks should be none, leader or all: %s", hc.Acks)
+	} else {
+		config.Producer.RequiredAcks = v
+	}
 
-	for doc := range <-hc.ch {
-		data, err := json.Marshal(doc)
+	if hc.TLS || hc.TLSConfig.Enabled() {
+		tlsConfig, err := hc.ClientConfig(false)
 		if err != nil {
-			log.Errorf("Error marshaling event: %s", err.Error())
-			continue
+			return nil, err
 		}
 
-		hc.producer.Input() <- &sarama.ProducerMessage{
-			Topic: hc.Topic,
-			Key:   nil,
-			Value: sarama.ByteEncoder(data),
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if hc.SASLUsername == "" {
+		return config, nil
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = hc.SASLUsername
+	config.Net.SASL.Password = hc.SASLPassword
+
+	switch m := strings.ToUpper(hc.SASLMechanism); m {
+	case "", sarama.SASLTypePlaintext:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLMechanism(m)
+
+		if m == sarama.SASLTypeSCRAMSHA256 {
+			config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClient(sha256Generator)
+		} else {
+			config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClient(sha512Generator)
 		}
+
+		// the SCRAM exchange uses the authenticate requests of kafka 1.0
+		config.Version = sarama.V1_0_0_0
+	default:
+		return nil, fmt.Errorf("Kafka sasl mechanism should be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512: %s", hc.SASLMechanism)
 	}
+
+	return config, nil
 }
 
-// Send delivers the giving push messages into the internal elastic search endpoint.
-func (hc Backend) Send(message event.Event) {
-	mp := make(map[string]interface{})
+func (hc *Backend) run() {
+	defer hc.producer.AsyncClose()
+
+	for msg := range hc.ch {
+		hc.producer.Input() <- msg
+	}
+}
 
-	message.Range(func(key, value interface{}) bool {
-		if keyName, ok := key.(string); ok {
-			mp[keyName] = value
+// report handles the delivery reports of the producer, the messages that
+// failed to be delivered are logged.
+func (hc *Backend) report() {
+	successes, errors := hc.producer.Successes(), hc.producer.Errors()
+
+	for successes != nil || errors != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+
+			log.Debugf("Delivered event to %s partition %d offset %d", msg.Topic, msg.Partition, msg.Offset)
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+
+			log.Errorf("Error delivering event to %s: %s", err.Msg.Topic, err.Err.Error())
+			pushers.DeliveryFailed("kafka", 1)
 		}
-		return true
-	})
+	}
+}
+
+// message returns the message of the event, keyed by the configured field.
+func (hc *Backend) message(e event.Event) (*sarama.ProducerMessage, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
 
-	hc.ch <- mp
+	msg := &sarama.ProducerMessage{
+		Topic: hc.Topic,
+		Value: sarama.ByteEncoder(data),
+	}
+
+	if hc.Key == "" {
+	} else if key := e.Get(hc.Key); key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+
+	return msg, nil
+}
+
+// Send queues the event to be published, the event is dropped when the
+// brokers can't keep up.
+func (hc *Backend) Send(e event.Event) {
+	msg, err := hc.message(e)
+	if err != nil {
+		log.Errorf("Error marshaling event: %s", err.Error())
+		pushers.DeliveryFailed("kafka", 1)
+		return
+	}
+
+	select {
+	case hc.ch <- msg:
+	default:
+		log.Warningf("Queue of %s full, event dropped", hc.Topic)
+		pushers.DeliveryFailed("kafka", 1)
+	}
 }
@@ -15,12 +15,15 @@ package kafka
 
 import (
 	"fmt"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Shopify/sarama"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/xdg/scram"
 )
 
 const config = `
@@ -38,7 +41,7 @@ func TestChannelsKafkaSend(t *testing.T) {
 
 	metadataResponse := new(sarama.MetadataResponse)
 	metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
-	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, sarama.ErrNoError)
+	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, sarama.ErrNoError)
 
 	seedBroker.Returns(metadataResponse)
 
@@ -67,14 +70,135 @@ func TestChannelsKafkaSend(t *testing.T) {
 
 	c.Send(event.New())
 
-	kb := c.(*Backend)
+	deadline := time.Now().Add(5 * time.Second)
 
-	select {
-	case <-kb.producer.Successes():
-	case msg := <-kb.producer.Errors():
-		t.Error(msg.Err)
+	for produced := false; !produced; {
+		for _, rr := range leader.History() {
+			_, produced = rr.Request.(*sarama.ProduceRequest)
+			if produced {
+				break
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the event to be produced")
+		}
+
+		time.Sleep(10 * time.Millisecond)
 	}
 
-	close(kb.ch)
+	close(c.(*Backend).ch)
+}
+
+func TestChannelsKafkaKey(t *testing.T) {
+	kb := &Backend{
+		Config: Config{
+			Topic: "my_topic",
+			Key:   "source-ip",
+		},
+	}
 
+	msg, err := kb.message(event.New(event.SourceIP(net.ParseIP("10.0.0.1"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key, _ := msg.Key.Encode(); string(key) != "10.0.0.1" {
+		t.Errorf("Expected source ip as key, got %s", key)
+	}
+
+	if msg, _ = kb.message(event.New()); msg.Key != nil {
+		t.Errorf("Expected no key for event without source ip")
+	}
+}
+
+func TestChannelsKafkaSASL(t *testing.T) {
+	for _, tc := range []struct {
+		mechanism string
+		expected  sarama.SASLMechanism
+	}{
+		{"", sarama.SASLTypePlaintext},
+		{"plain", sarama.SASLTypePlaintext},
+		{"SCRAM-SHA-256", sarama.SASLTypeSCRAMSHA256},
+		{"scram-sha-512", sarama.SASLTypeSCRAMSHA512},
+	} {
+		kb := &Backend{
+			Config: Config{
+				Acks:          "leader",
+				SASLMechanism: tc.mechanism,
+				SASLUsername:  "honeytrap",
+				SASLPassword:  "secret",
+			},
+		}
+
+		config, err := kb.config()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.Net.SASL.Mechanism != tc.expected {
+			t.Errorf("Expected mechanism %s for %q, got %s", tc.expected, tc.mechanism, config.Net.SASL.Mechanism)
+		}
+
+		if err := config.Validate(); err != nil {
+			t.Errorf("Expected valid configuration for %q, got %v", tc.mechanism, err)
+		}
+	}
+
+	kb := &Backend{
+		Config: Config{
+			Acks:          "leader",
+			SASLMechanism: "GSSAPI",
+			SASLUsername:  "honeytrap",
+		},
+	}
+
+	if _, err := kb.config(); err == nil {
+		t.Errorf("Expected unsupported sasl mechanism")
+	}
+}
+
+func TestSCRAMClient(t *testing.T) {
+	for _, generator := range []scram.HashGeneratorFcn{sha256Generator, sha512Generator} {
+		client, err := generator.NewClient("honeytrap", "secret", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		credentials := client.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+
+		server, err := generator.NewServer(func(username string) (scram.StoredCredentials, error) {
+			return credentials, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sc := newSCRAMClient(generator)()
+		if err := sc.Begin("honeytrap", "secret", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		conversation := server.NewConversation()
+
+		challenge := ""
+		for !sc.Done() {
+			response, err := sc.Step(challenge)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sc.Done() {
+				break
+			}
+
+			if challenge, err = conversation.Step(response); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !conversation.Valid() {
+			t.Errorf("Expected the client to be authenticated")
+		}
+	}
 }
@@ -0,0 +1,61 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+
+	sarama "github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+var (
+	sha256Generator scram.HashGeneratorFcn = func() hash.Hash { return sha256.New() }
+	sha512Generator scram.HashGeneratorFcn = func() hash.Hash { return sha512.New() }
+)
+
+// scramClient implements the SCRAM authentication of sarama.
+type scramClient struct {
+	generator scram.HashGeneratorFcn
+
+	conversation *scram.ClientConversation
+}
+
+// newSCRAMClient returns the generator of the SCRAM clients of the
+// mechanism.
+func newSCRAMClient(generator scram.HashGeneratorFcn) func() sarama.SCRAMClient {
+	return func() sarama.SCRAMClient {
+		return &scramClient{generator: generator}
+	}
+}
+
+func (c *scramClient) Begin(username, password, authzID string) error {
+	client, err := c.generator.NewClient(username, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,45 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type labelChannel struct {
+	Channel
+
+	Labels map[string]string
+}
+
+// Send delivers a copy of the event with the labels stamped on it, the event
+// itself is shared by all channels.
+func (lc labelChannel) Send(e event.Event) {
+	options := make([]event.Option, 0, len(lc.Labels))
+
+	for k, v := range lc.Labels {
+		options = append(options, event.Custom("labels."+k, v))
+	}
+
+	lc.Channel.Send(event.Apply(e.Copy(), options...))
+}
+
+// LabelChannel returns a Channel that stamps static labels on the events as
+// labels.<name> fields, to route and report events by the role of the decoy.
+func LabelChannel(channel Channel, labels map[string]string) Channel {
+	return labelChannel{
+		Channel: channel,
+		Labels:  labels,
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestLabelChannel(t *testing.T) {
+	rc := &recordChannel{}
+
+	c := LabelChannel(rc, map[string]string{
+		"segment": "dmz",
+		"decoy":   "billing-app",
+	})
+
+	c.Send(event.New(
+		event.Category("http"),
+	))
+
+	if len(rc.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(rc.events))
+	}
+
+	e := rc.events[0]
+
+	if v := e.Get("labels.segment"); v != "dmz" {
+		t.Errorf("Expected labels.segment dmz, got %s", v)
+	}
+
+	if v := e.Get("labels.decoy"); v != "billing-app" {
+		t.Errorf("Expected labels.decoy billing-app, got %s", v)
+	}
+
+	if v := e.Get("category"); v != "http" {
+		t.Errorf("Expected category http, got %s", v)
+	}
+}
@@ -13,9 +13,15 @@
 // limitations under the License.
 package marija
 
+import "github.com/honeytrap/honeytrap/pushers"
+
 // Config defines a struct which holds configuration values for a SearchBackend.
 type Config struct {
 	URL string `toml:"url"`
 
 	Insecure bool `toml:"insecure"`
+
+	Proxy string `toml:"proxy"`
+
+	pushers.TLSConfig
 }
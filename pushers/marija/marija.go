@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
 	"github.com/honeytrap/honeytrap/pushers"
 
 	"io"
@@ -39,6 +40,9 @@ var log = logging.MustGetLogger("channels:marija")
 type Backend struct {
 	Config
 
+	proxy     proxy.Func
+	tlsConfig *tls.Config
+
 	ch chan map[string]interface{}
 }
 
@@ -53,6 +57,20 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 		optionFn(&c)
 	}
 
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.proxy = p
+
+	tlsConfig, err := c.ClientConfig(c.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	c.tlsConfig = tlsConfig
+
 	go c.run()
 
 	return &c, nil
@@ -67,15 +85,10 @@ func (hc Backend) run() {
 	log.Debug("Marija channel started...")
 	defer log.Debug("Marija channel stopped...")
 
-	tlsClientConfig := &tls.Config{}
-
-	if hc.Insecure {
-		tlsClientConfig = Insecure(tlsClientConfig)
-	}
-
 	client := &http.Client{
 		Transport: &http.Transport{
-			TLSClientConfig: tlsClientConfig,
+			Proxy:           hc.proxy,
+			TLSClientConfig: hc.tlsConfig,
 		},
 	}
 
@@ -103,17 +116,20 @@ func (hc Backend) run() {
 		req, err := http.NewRequest(http.MethodPost, hc.URL, pr)
 		if err != nil {
 			log.Errorf("Could create new request: %s", err.Error())
+			pushers.DeliveryFailed("marija", len(docs))
 			return
 		}
 
 		resp, err := client.Do(req)
 		if err != nil {
 			log.Errorf("Could not submit event to Marija: %s", err.Error())
+			pushers.DeliveryFailed("marija", len(docs))
 			return
 		}
 
 		if resp.StatusCode != http.StatusOK {
 			log.Errorf("Could not submit event to Marija: %d", resp.StatusCode)
+			pushers.DeliveryFailed("marija", len(docs))
 			return
 		}
 	}
@@ -174,5 +190,6 @@ func (hc Backend) Send(message event.Event) {
 	case hc.ch <- mp:
 	default:
 		log.Errorf("Could not send more messages, channel full")
+		pushers.DeliveryFailed("marija", 1)
 	}
 }
@@ -0,0 +1,30 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"github.com/honeytrap/honeytrap/metrics"
+)
+
+var deliveryFailures = metrics.NewCounterVec(
+	"honeytrap_channel_delivery_failures_total",
+	"Number of events the channels failed to deliver, by type of channel.",
+	"channel",
+)
+
+// DeliveryFailed counts the events the channel failed to deliver, the
+// channel is the type of the channel.
+func DeliveryFailed(channel string, n int) {
+	deliveryFailures.With(channel).Add(uint64(n))
+}
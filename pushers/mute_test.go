@@ -0,0 +1,75 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestMuteChannel(t *testing.T) {
+	if _, err := MuteChannel(&recordChannel{}, []MuteRule{{Sources: []string{"192.0.2.0/33"}}}); err == nil {
+		t.Errorf("Expected error for invalid source")
+	}
+
+	if _, err := MuteChannel(&recordChannel{}, []MuteRule{{Schedule: "22-06"}}); err == nil {
+		t.Errorf("Expected error for invalid schedule")
+	}
+
+	now := time.Date(2019, 4, 15, 23, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		rule  MuteRule
+		ip    string
+		sigs  []string
+		muted bool
+	}{
+		{MuteRule{Sources: []string{"192.0.2.0/24"}}, "192.0.2.1", nil, true},
+		{MuteRule{Sources: []string{"192.0.2.0/24"}}, "198.51.100.1", nil, false},
+		{MuteRule{Sources: []string{"198.51.100.1"}}, "198.51.100.1", nil, true},
+		{MuteRule{Signatures: []string{"mirai-scan"}}, "192.0.2.1", []string{"ssh-bruteforce", "mirai-scan"}, true},
+		{MuteRule{Signatures: []string{"mirai-scan"}}, "192.0.2.1", []string{"ssh-bruteforce"}, false},
+		{MuteRule{Signatures: []string{"mirai-scan"}}, "192.0.2.1", nil, false},
+		{MuteRule{Sources: []string{"192.0.2.0/24"}, Signatures: []string{"mirai-scan"}}, "198.51.100.1", []string{"mirai-scan"}, false},
+		{MuteRule{Schedule: "22:00-06:00"}, "192.0.2.1", nil, true},
+		{MuteRule{Schedule: "08:00-18:00"}, "192.0.2.1", nil, false},
+		{MuteRule{Until: now.Add(time.Hour)}, "192.0.2.1", nil, true},
+		{MuteRule{Until: now.Add(-time.Hour)}, "192.0.2.1", nil, false},
+	}
+
+	for i, test := range tests {
+		rc := &recordChannel{}
+
+		c, err := MuteChannel(rc, []MuteRule{test.rule})
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err.Error())
+		}
+
+		mc := c.(muteChannel)
+		mc.now = func() time.Time { return now }
+
+		e := event.New(event.Custom("source-ip", test.ip))
+		if test.sigs != nil {
+			e.Store("signature.id", test.sigs)
+		}
+
+		mc.Send(e)
+
+		if muted := len(rc.events) == 0; muted != test.muted {
+			t.Errorf("Test %d: expected muted %t, got %t", i, test.muted, muted)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package opencti
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:channels:opencti")
+
+var (
+	_ = pushers.Register("opencti", New)
+)
+
+// urlRegexp matches the urls (eg. c2 or download locations) within payloads
+// and commands.
+var urlRegexp = regexp.MustCompile(`(?i)\b(?:https?|ftp|tftp)://[^\s'"<>\x60;|]+`)
+
+// Config defines a struct which holds configuration field values used by the
+// Backend to connect to the OpenCTI GraphQL api.
+type Config struct {
+	URL   string `toml:"url"`
+	Token string `toml:"token"`
+	Proxy string `toml:"proxy"`
+
+	// Interval is the interval the collected observables are pushed
+	Interval config.Delay `toml:"interval"`
+}
+
+type observable struct {
+	Type  string
+	Value string
+}
+
+type relationship struct {
+	From observable
+	To   observable
+}
+
+// Backend collects the observables and relationships of the events and
+// pushes them into OpenCTI on a schedule.
+type Backend struct {
+	Config
+
+	client *http.Client
+
+	m             sync.Mutex
+	observables   map[observable]struct{}
+	relationships map[relationship]struct{}
+
+	// ids of the observables and the relationships already created in OpenCTI
+	ids    map[observable]string
+	pushed map[relationship]struct{}
+}
+
+// New returns a new instance of a Backend.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := &Backend{
+		Config: Config{
+			Interval: config.Delay(5 * time.Minute),
+		},
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		observables:   map[observable]struct{}{},
+		relationships: map[relationship]struct{}{},
+		ids:           map[observable]string{},
+		pushed:        map[relationship]struct{}{},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.URL == "" {
+		return nil, errors.New("Invalid Config: url can not be empty")
+	}
+
+	if c.Token == "" {
+		return nil, errors.New("Invalid Config: token can not be empty")
+	}
+
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client.Transport = &http.Transport{
+		Proxy: p,
+	}
+
+	go c.run()
+
+	return c, nil
+}
+
+func ipObservable(s string) (observable, bool) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return observable{}, false
+	} else if ip.To4() != nil {
+		return observable{Type: "IPv4-Addr", Value: ip.String()}, true
+	}
+
+	return observable{Type: "IPv6-Addr", Value: ip.String()}, true
+}
+
+// Send extracts the observables and relationships (attacker, payload, urls)
+// from the event.
+func (b *Backend) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	attacker, hasAttacker := ipObservable(e.Get("source-ip"))
+
+	texts := []string{}
+
+	var payload *observable
+	if v := e.Get("payload"); v != "" {
+		hash := sha256.Sum256([]byte(v))
+
+		payload = &observable{Type: "StixFile", Value: hex.EncodeToString(hash[:])}
+		texts = append(texts, v)
+	}
+
+	e.Range(func(key, value interface{}) bool {
+		k, _ := key.(string)
+		if strings.HasSuffix(k, ".url") || strings.HasSuffix(k, ".command") {
+			texts = append(texts, fmt.Sprint(value))
+		}
+
+		return true
+	})
+
+	urls := []observable{}
+	for _, text := range texts {
+		for _, u := range urlRegexp.FindAllString(text, -1) {
+			urls = append(urls, observable{Type: "Url", Value: u})
+		}
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	add := func(o observable) {
+		if _, ok := b.ids[o]; ok {
+			return
+		}
+
+		b.observables[o] = struct{}{}
+	}
+
+	relate := func(from, to observable) {
+		r := relationship{From: from, To: to}
+		if _, ok := b.pushed[r]; ok {
+			return
+		}
+
+		b.relationships[r] = struct{}{}
+	}
+
+	if hasAttacker {
+		add(attacker)
+	}
+
+	if payload != nil {
+		add(*payload)
+
+		if hasAttacker {
+			relate(attacker, *payload)
+		}
+	}
+
+	for _, u := range urls {
+		add(u)
+
+		if payload != nil {
+			relate(*payload, u)
+		} else if hasAttacker {
+			relate(attacker, u)
+		}
+	}
+}
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+func (b *Backend) query(query string, variables map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(graphQLRequest{
+		Query:     query,
+		Variables: variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(b.URL, "/")+"/graphql", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", b.Token))
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	result := struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphQLError  `json:"errors"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if len(result.Errors) > 0 {
+		return errors.New(result.Errors[0].Message)
+	}
+
+	return json.Unmarshal(result.Data, v)
+}
+
+const observableAddQuery = `mutation ObservableAdd($type: String!, $IPv4Addr: IPv4AddrAddInput, $IPv6Addr: IPv6AddrAddInput, $Url: UrlAddInput, $StixFile: StixFileAddInput) {
+  stixCyberObservableAdd(type: $type, IPv4Addr: $IPv4Addr, IPv6Addr: $IPv6Addr, Url: $Url, StixFile: $StixFile) {
+    id
+  }
+}`
+
+const relationshipAddQuery = `mutation RelationshipAdd($input: StixCoreRelationshipAddInput) {
+  stixCoreRelationshipAdd(input: $input) {
+    id
+  }
+}`
+
+func (b *Backend) addObservable(o observable) (string, error) {
+	variables := map[string]interface{}{
+		"type": o.Type,
+	}
+
+	switch o.Type {
+	case "IPv4-Addr":
+		variables["IPv4Addr"] = map[string]interface{}{"value": o.Value}
+	case "IPv6-Addr":
+		variables["IPv6Addr"] = map[string]interface{}{"value": o.Value}
+	case "Url":
+		variables["Url"] = map[string]interface{}{"value": o.Value}
+	case "StixFile":
+		variables["StixFile"] = map[string]interface{}{
+			"hashes": []map[string]string{
+				{"algorithm": "SHA-256", "hash": o.Value},
+			},
+		}
+	}
+
+	result := struct {
+		StixCyberObservableAdd struct {
+			ID string `json:"id"`
+		} `json:"stixCyberObservableAdd"`
+	}{}
+
+	if err := b.query(observableAddQuery, variables, &result); err != nil {
+		return "", err
+	}
+
+	return result.StixCyberObservableAdd.ID, nil
+}
+
+func (b *Backend) addRelationship(fromID, toID string) error {
+	result := struct {
+		StixCoreRelationshipAdd struct {
+			ID string `json:"id"`
+		} `json:"stixCoreRelationshipAdd"`
+	}{}
+
+	return b.query(relationshipAddQuery, map[string]interface{}{
+		"input": map[string]interface{}{
+			"fromId":            fromID,
+			"toId":              toID,
+			"relationship_type": "related-to",
+		},
+	}, &result)
+}
+
+// push creates the collected observables and relationships in OpenCTI,
+// failed items are kept and retried with the next push.
+func (b *Backend) push() {
+	b.m.Lock()
+	observables := b.observables
+	relationships := b.relationships
+
+	b.observables = map[observable]struct{}{}
+	b.relationships = map[relationship]struct{}{}
+	b.m.Unlock()
+
+	failedObservables := map[observable]struct{}{}
+	failedRelationships := map[relationship]struct{}{}
+
+	for o := range observables {
+		id, err := b.addObservable(o)
+		if err != nil {
+			log.Errorf("Error adding observable %s %s: %s", o.Type, o.Value, err.Error())
+
+			failedObservables[o] = struct{}{}
+			continue
+		}
+
+		b.m.Lock()
+		b.ids[o] = id
+		b.m.Unlock()
+	}
+
+	for r := range relationships {
+		b.m.Lock()
+		fromID, fromOK := b.ids[r.From]
+		toID, toOK := b.ids[r.To]
+		b.m.Unlock()
+
+		if !fromOK || !toOK {
+			failedRelationships[r] = struct{}{}
+			continue
+		}
+
+		if err := b.addRelationship(fromID, toID); err != nil {
+			log.Errorf("Error adding relationship %s -> %s: %s", r.From.Value, r.To.Value, err.Error())
+
+			failedRelationships[r] = struct{}{}
+			continue
+		}
+
+		b.m.Lock()
+		b.pushed[r] = struct{}{}
+		b.m.Unlock()
+	}
+
+	b.m.Lock()
+	for o := range failedObservables {
+		b.observables[o] = struct{}{}
+	}
+
+	for r := range failedRelationships {
+		b.relationships[r] = struct{}{}
+	}
+	b.m.Unlock()
+
+	log.Debugf("Pushed %d observables and %d relationships", len(observables)-len(failedObservables), len(relationships)-len(failedRelationships))
+}
+
+func (b *Backend) run() {
+	for {
+		time.Sleep(b.Interval.Duration())
+
+		b.push()
+	}
+}
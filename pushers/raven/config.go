@@ -13,10 +13,16 @@
 // limitations under the License.
 package raven
 
+import "github.com/honeytrap/honeytrap/pushers"
+
 // Config defines a struct which holds configuration values for a SearchBackend.
 type Config struct {
 	Token  string `toml:"token"`
 	Server string `toml:"server"`
 
 	Insecure bool `toml:"insecure"`
+
+	Proxy string `toml:"proxy"`
+
+	pushers.TLSConfig
 }
@@ -24,6 +24,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/honeytrap/honeytrap/cmd"
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
 	"github.com/honeytrap/honeytrap/pushers"
 
 	logging "github.com/op/go-logging"
@@ -40,6 +41,9 @@ var log = logging.MustGetLogger("channels/raven")
 type Backend struct {
 	Config
 
+	proxy     proxy.Func
+	tlsConfig *tls.Config
+
 	ch chan event.Event
 }
 
@@ -54,6 +58,20 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 		optionFn(&c)
 	}
 
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.proxy = p
+
+	tlsConfig, err := c.ClientConfig(c.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	c.tlsConfig = tlsConfig
+
 	go c.run()
 
 	return &c, nil
@@ -65,15 +83,9 @@ func Insecure(config *tls.Config) *tls.Config {
 }
 
 func (hc Backend) run() {
-	tlsClientConfig := &tls.Config{}
-
-	if hc.Insecure {
-		tlsClientConfig = Insecure(tlsClientConfig)
-	}
-
 	d := &websocket.Dialer{
-		Proxy:           http.ProxyFromEnvironment,
-		TLSClientConfig: tlsClientConfig,
+		Proxy:           hc.proxy,
+		TLSClientConfig: hc.tlsConfig,
 	}
 
 	for {
@@ -128,12 +140,14 @@ func (hc Backend) run() {
 						if err != nil {
 							// handle errors
 							log.Errorf("Error occurred while marshalling: %s", err.Error())
+							pushers.DeliveryFailed("raven", 1)
 							continue
 						}
 
 						err = c.WriteMessage(websocket.BinaryMessage, data)
 						if err != nil {
 							log.Errorf("Could not write: %s", err.Error())
+							pushers.DeliveryFailed("raven", 1)
 							return
 						}
 					}
@@ -153,5 +167,6 @@ func (hc Backend) Send(message event.Event) {
 	case hc.ch <- message:
 	default:
 		log.Errorf("Could not send more messages, channel full")
+		pushers.DeliveryFailed("raven", 1)
 	}
 }
@@ -0,0 +1,113 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"path"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// Redaction defines the fields that will be dropped, masked or truncated
+// before events are delivered to a channel. Field names support wildcards
+// (eg. *.password). Redacted events no longer verify against their
+// signature, when signing is enabled.
+type Redaction struct {
+	Drop     []string       `toml:"drop"`
+	Mask     []string       `toml:"mask"`
+	Truncate map[string]int `toml:"truncate"`
+}
+
+// Empty returns true if the redaction has no rules.
+func (r Redaction) Empty() bool {
+	return len(r.Drop) == 0 && len(r.Mask) == 0 && len(r.Truncate) == 0
+}
+
+func matchField(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Apply returns a copy of the event with the redaction rules applied, the
+// original event is left untouched as it is shared by all channels.
+func (r Redaction) Apply(e event.Event) event.Event {
+	c := e.Copy()
+
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+
+		if matchField(r.Drop, k) {
+			c.Delete(k)
+			return true
+		}
+
+		if matchField(r.Mask, k) {
+			c.Store(k, strings.Repeat("*", 8))
+			return true
+		}
+
+		for p, n := range r.Truncate {
+			if ok, _ := path.Match(p, k); !ok {
+				continue
+			}
+
+			// only textual values are truncated
+			switch v := value.(type) {
+			case string:
+				if len(v) > n {
+					c.Store(k, v[:n])
+				}
+			case []byte:
+				if len(v) > n {
+					c.Store(k, v[:n])
+				}
+			}
+
+			break
+		}
+
+		return true
+	})
+
+	return c
+}
+
+type redactChannel struct {
+	Channel
+
+	Redaction Redaction
+}
+
+// Send delivers the redacted event to the channel.
+func (rc redactChannel) Send(e event.Event) {
+	rc.Channel.Send(rc.Redaction.Apply(e))
+}
+
+// RedactChannel returns a Channel that applies the redaction rules to the
+// events before delivery.
+func RedactChannel(channel Channel, redaction Redaction) Channel {
+	return redactChannel{
+		Channel:   channel,
+		Redaction: redaction,
+	}
+}
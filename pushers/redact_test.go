@@ -0,0 +1,76 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type recordChannel struct {
+	events []event.Event
+}
+
+func (rc *recordChannel) Send(e event.Event) {
+	rc.events = append(rc.events, e)
+}
+
+func TestRedactChannel(t *testing.T) {
+	rc := &recordChannel{}
+
+	c := RedactChannel(rc, Redaction{
+		Drop: []string{"*.password"},
+		Mask: []string{"ssh.username"},
+		Truncate: map[string]int{
+			"payload": 4,
+		},
+	})
+
+	e := event.New(
+		event.Category("ssh"),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "toor"),
+		event.Payload([]byte("uname -a")),
+	)
+
+	c.Send(e)
+
+	if len(rc.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(rc.events))
+	}
+
+	r := rc.events[0]
+
+	if r.Has("ssh.password") {
+		t.Errorf("Expected ssh.password to be dropped")
+	}
+
+	if v := r.Get("ssh.username"); v != "********" {
+		t.Errorf("Expected ssh.username to be masked, got %s", v)
+	}
+
+	if v := r.Get("payload"); v != "unam" {
+		t.Errorf("Expected payload to be truncated, got %s", v)
+	}
+
+	if v := r.Get("category"); v != "ssh" {
+		t.Errorf("Expected category to be kept, got %s", v)
+	}
+
+	// the original event is shared with the other channels
+	if v := e.Get("ssh.password"); v != "toor" {
+		t.Errorf("Expected original event to be untouched, got %s", v)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package s3 archives the events as compressed newline delimited json
+// objects in S3 compatible object storage, for cheap long term retention.
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+	"github.com/rs/xid"
+)
+
+var (
+	_ = pushers.Register("s3", New)
+)
+
+var log = logging.MustGetLogger("channels/s3")
+
+// Config configures the s3 channel.
+type Config struct {
+	// Endpoint is the url of the object storage, eg.
+	// https://s3.eu-west-1.amazonaws.com
+	Endpoint string `toml:"endpoint"`
+	Region   string `toml:"region"`
+	Bucket   string `toml:"bucket"`
+
+	// Prefix is prepended to the names of the objects, the objects are
+	// named by date, eg. honeytrap/2019/06/01/120000-<id>.ndjson.gz
+	Prefix string `toml:"prefix"`
+
+	AccessKey    string `toml:"access-key"`
+	SecretKey    string `toml:"secret-key"`
+	SessionToken string `toml:"session-token"`
+
+	// PathStyle addresses the bucket in the path instead of the host,
+	// most S3 compatible storage requires path style
+	PathStyle bool `toml:"path-style"`
+
+	// Format is the format of the objects, only ndjson is supported
+	Format string `toml:"format"`
+
+	// Compress compresses the objects with gzip
+	Compress bool `toml:"compress"`
+
+	// MaxSize is the size in bytes of the events, before compression, an
+	// object is uploaded at
+	MaxSize int `toml:"max-size"`
+
+	// FlushInterval is the maximum time events are buffered before the
+	// object is uploaded
+	FlushInterval config.Delay `toml:"flush-interval"`
+
+	// Buffer is the number of events queued while an object is uploaded,
+	// events are dropped when the queue is full
+	Buffer int `toml:"buffer"`
+
+	// MaxRetries is the number of times a failed upload is retried
+	MaxRetries int `toml:"max-retries"`
+
+	Proxy string `toml:"proxy"`
+
+	Insecure bool `toml:"insecure"`
+
+	pushers.TLSConfig
+}
+
+type s3Channel struct {
+	Config
+
+	client *http.Client
+
+	ch chan []byte
+}
+
+// New returns a channel archiving the events in object storage.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := s3Channel{
+		Config: Config{
+			Endpoint:      "https://s3.amazonaws.com",
+			Region:        "us-east-1",
+			Prefix:        "honeytrap/",
+			Format:        "ndjson",
+			Compress:      true,
+			MaxSize:       64 * 1024 * 1024,
+			FlushInterval: config.Delay(5 * time.Minute),
+			Buffer:        1000,
+			MaxRetries:    5,
+		},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Bucket == "" {
+		return nil, errors.New("S3 channel: bucket not set")
+	}
+
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return nil, errors.New("S3 channel: access-key and secret-key are required")
+	}
+
+	if c.Format != "ndjson" {
+		return nil, fmt.Errorf("S3 channel: unsupported format %s", c.Format)
+	}
+
+	if c.MaxSize < 1 || c.Buffer < 1 {
+		return nil, errors.New("S3 channel: max-size and buffer should be at least 1")
+	}
+
+	if _, err := url.Parse(c.Endpoint); err != nil {
+		return nil, err
+	}
+
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.ClientConfig(c.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           p,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: 5 * time.Minute,
+	}
+
+	c.ch = make(chan []byte, c.Buffer)
+
+	go c.run()
+
+	return &c, nil
+}
+
+// object is the object being buffered.
+type object struct {
+	buf   bytes.Buffer
+	w     io.WriteCloser
+	size  int
+	count int
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func (c *s3Channel) newObject() *object {
+	o := &object{}
+
+	if c.Compress {
+		o.w = gzip.NewWriter(&o.buf)
+	} else {
+		o.w = nopCloser{&o.buf}
+	}
+
+	return o
+}
+
+// key returns the name of a new object.
+func (c *s3Channel) key(now time.Time) string {
+	name := now.UTC().Format("2006/01/02/150405") + "-" + xid.New().String() + ".ndjson"
+	if c.Compress {
+		name += ".gz"
+	}
+
+	return c.Prefix + name
+}
+
+// objectURL returns the url of the object.
+func (c *s3Channel) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.PathStyle {
+		u.Path = path.Join("/", u.Path, c.Bucket, key)
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+		u.Path = path.Join("/", u.Path, key)
+	}
+
+	return u, nil
+}
+
+// upload puts the object.
+func (c *s3Channel) upload(key string, data []byte) error {
+	u, err := c.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	// the objects are stored compressed, and not decompressed on download
+	if c.Compress {
+		req.Header.Set("Content-Type", "application/gzip")
+	} else {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+
+	req.Header.Set("X-Amz-Content-Sha256", hashPayload(data))
+
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	sign(req, c.Region, c.AccessKey, c.SecretKey, time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Unexpected status %s: %s", resp.Status, string(body))
+	}
+
+	return nil
+}
+
+// flush uploads the object, failed uploads are retried with backoff.
+func (c *s3Channel) flush(o *object) {
+	if err := o.w.Close(); err != nil {
+		log.Errorf("Error compressing object: %s", err.Error())
+		pushers.DeliveryFailed("s3", o.count)
+		return
+	}
+
+	key := c.key(time.Now())
+
+	backoff := time.Second
+
+	for i := 0; ; i++ {
+		err := c.upload(key, o.buf.Bytes())
+		if err == nil {
+			log.Infof("Uploaded %s with %d events", key, o.count)
+			return
+		}
+
+		log.Errorf("Error uploading %s: %s", key, err.Error())
+
+		if i >= c.MaxRetries {
+			pushers.DeliveryFailed("s3", o.count)
+			return
+		}
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+	}
+}
+
+func (c *s3Channel) run() {
+	ticker := time.NewTicker(c.FlushInterval.Duration())
+	defer ticker.Stop()
+
+	o := c.newObject()
+
+	for {
+		select {
+		case data := <-c.ch:
+			o.w.Write(data)
+
+			o.size += len(data)
+			o.count++
+
+			if o.size < c.MaxSize {
+				continue
+			}
+		case <-ticker.C:
+			if o.count == 0 {
+				continue
+			}
+		}
+
+		c.flush(o)
+
+		o = c.newObject()
+	}
+}
+
+// Send queues the event, the event is dropped when the queue is full.
+func (c *s3Channel) Send(e event.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Error marshalling event: %s", err.Error())
+		pushers.DeliveryFailed("s3", 1)
+		return
+	}
+
+	select {
+	case c.ch <- append(data, '\n'):
+	default:
+		log.Warningf("Queue of %s full, event dropped", c.Bucket)
+		pushers.DeliveryFailed("s3", 1)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hashPayload returns the hex encoded sha256 of the payload.
+func hashPayload(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode encodes the path as S3 expects, all characters except the
+// unreserved characters and slashes are percent encoded.
+func uriEncode(path string) string {
+	b := strings.Builder{}
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteByte(c)
+		case c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// signedHeader returns true if the header is signed, the host and content
+// headers and the amz headers are signed.
+func signedHeader(name string) bool {
+	switch name {
+	case "host", "content-type", "content-md5", "date":
+		return true
+	default:
+		return strings.HasPrefix(name, "x-amz-")
+	}
+}
+
+// sign signs the request with AWS signature version 4, the
+// x-amz-content-sha256 header has to be set to the hash of the payload.
+func sign(req *http.Request, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{
+		"host": req.Host,
+	}
+
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+
+	for k, v := range req.Header {
+		if k := strings.ToLower(k); signedHeader(k) {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := []string{}
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashPayload([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
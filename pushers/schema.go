@@ -0,0 +1,40 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type schemaChannel struct {
+	Channel
+
+	Version int
+}
+
+// Send delivers the event downgraded to the schema version.
+func (sc schemaChannel) Send(e event.Event) {
+	sc.Channel.Send(event.Downgrade(e, sc.Version))
+}
+
+// SchemaChannel returns a Channel that delivers the events with the field
+// names of an older schema version, for consumers that can't parse the
+// current version yet. Downgraded events no longer verify against their
+// signature, when signing is enabled.
+func SchemaChannel(channel Channel, version int) Channel {
+	return schemaChannel{
+		Channel: channel,
+		Version: version,
+	}
+}
@@ -21,9 +21,11 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
 	"github.com/honeytrap/honeytrap/pushers"
 	logging "github.com/op/go-logging"
 )
@@ -41,6 +43,7 @@ type Config struct {
 	Username   string `toml:"username"`
 	IconURL    string `toml:"icon_url"`
 	IconEmoji  string `toml:"icon_emoji"`
+	Proxy      string `toml:"proxy"`
 }
 
 // Backend provides a struct which holds the configured means by which
@@ -48,6 +51,9 @@ type Config struct {
 type Backend struct {
 	Config
 
+	proxy  proxy.Func
+	client *http.Client
+
 	ch chan map[string]interface{}
 }
 
@@ -65,134 +71,179 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 		return nil, errors.New("Invalid Config: WebhookURL can not be empty")
 	}
 
-	go c.run()
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
 
-	return &c, nil
-}
+	c.proxy = p
 
-func (b Backend) run() {
-	client := &http.Client{
+	c.client = &http.Client{
 		Transport: &http.Transport{
+			Proxy:               c.proxy,
 			MaxIdleConnsPerHost: 5,
 		},
 		Timeout: time.Duration(20) * time.Second,
 	}
 
-	for {
-		ev := <-b.ch
+	go c.run()
 
-		//Attempt to encode message body first and if failed, log and continue.
-		var messageBuffer bytes.Buffer
+	return &c, nil
+}
 
-		category, ok := ev["category"].(string)
-		if !ok {
-			log.Errorf("Error event has no category value")
-			return
+func (b Backend) run() {
+	for ev := range b.ch {
+		if err := b.post(ev); err != nil {
+			log.Errorf("Error posting to endpoint(%q): %s", b.WebhookURL, err.Error())
+			pushers.DeliveryFailed("slack", 1)
 		}
+	}
+}
 
-		sensor, ok := ev["sensor"].(string)
-		if !ok {
-			log.Errorf("Error event has no sensor value")
-			return
-		}
+// statusError is returned for unexpected statuses of the webhook.
+type statusError int
 
-		etype, ok := ev["type"].(string)
-		if !ok {
-			log.Errorf("Error event has no type value")
-			return
-		}
+func (e statusError) Error() string {
+	return fmt.Sprintf("API Response with unexpected Status Code[%d]", int(e))
+}
 
-		var newMessage Message
-		newMessage.Text = fmt.Sprintf("Event with category %q of type %q for sensor %q occurred", category, etype, sensor)
+// post posts the message of the event to the webhook.
+func (b Backend) post(ev map[string]interface{}) error {
+	//Attempt to encode message body first and if failed, log and continue.
+	var messageBuffer bytes.Buffer
 
-		if m, ok := ev["message"].(string); ok {
-			newMessage.Text = m
-		}
+	category, ok := ev["category"].(string)
+	if !ok {
+		return errors.New("Event has no category value")
+	}
 
-		newMessage.IconURL = b.IconURL
-		newMessage.IconEmoji = b.IconEmoji
-		newMessage.Username = b.Username
+	sensor, ok := ev["sensor"].(string)
+	if !ok {
+		return errors.New("Event has no sensor value")
+	}
 
-		idAttachment := Attachment{
-			Title:    "Event Identification",
-			Author:   "HoneyTrap",
-			Text:     "Event Sensor and Category",
-			Fallback: "Event Sensor and Category",
-		}
+	etype, ok := ev["type"].(string)
+	if !ok {
+		return errors.New("Event has no type value")
+	}
 
-		idAttachment.AddField("Sensor", string(sensor)).
-			AddField("Category", string(category)).
-			AddField("Type", string(etype))
+	var newMessage Message
+	newMessage.Text = fmt.Sprintf("Event with category %q of type %q for sensor %q occurred", category, etype, sensor)
 
-		fieldAttachment := Attachment{
-			Title:    "Event Fields",
-			Author:   "HoneyTrap",
-			Text:     "Fields for events",
-			Fallback: "Fields for events",
-		}
+	if m, ok := ev["message"].(string); ok {
+		newMessage.Text = m
+	}
+
+	newMessage.IconURL = b.IconURL
+	newMessage.IconEmoji = b.IconEmoji
+	newMessage.Username = b.Username
+
+	idAttachment := Attachment{
+		Title:    "Event Identification",
+		Author:   "HoneyTrap",
+		Text:     "Event Sensor and Category",
+		Fallback: "Event Sensor and Category",
+	}
 
-		fieldAttachment.AddField("Sensor", string(sensor)).
-			AddField("Category", string(category)).
-			AddField("Type", string(etype))
-
-		for name, value := range ev {
-			switch vo := value.(type) {
-			case string:
-				fieldAttachment.AddField(name, vo)
-			default:
-				data, err := json.Marshal(value)
-				if err != nil {
-					continue
-				}
-
-				fieldAttachment.AddField(name, string(data))
+	idAttachment.AddField("Sensor", string(sensor)).
+		AddField("Category", string(category)).
+		AddField("Type", string(etype))
+
+	fieldAttachment := Attachment{
+		Title:    "Event Fields",
+		Author:   "HoneyTrap",
+		Text:     "Fields for events",
+		Fallback: "Fields for events",
+	}
+
+	fieldAttachment.AddField("Sensor", string(sensor)).
+		AddField("Category", string(category)).
+		AddField("Type", string(etype))
+
+	for name, value := range ev {
+		switch vo := value.(type) {
+		case string:
+			fieldAttachment.AddField(name, vo)
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				continue
 			}
+
+			fieldAttachment.AddField(name, string(data))
 		}
+	}
 
-		newMessage.AddAttachment(idAttachment)
-		newMessage.AddAttachment(fieldAttachment)
+	newMessage.AddAttachment(idAttachment)
+	newMessage.AddAttachment(fieldAttachment)
 
-		newMessage.AddAttachment(Attachment{
-			Title:    "Event Data",
-			Author:   "HoneyTrap",
-			Fallback: messageBuffer.String(),
-			Text:     messageBuffer.String(),
-		})
+	newMessage.AddAttachment(Attachment{
+		Title:    "Event Data",
+		Author:   "HoneyTrap",
+		Fallback: messageBuffer.String(),
+		Text:     messageBuffer.String(),
+	})
 
-		data := new(bytes.Buffer)
-		if err := json.NewEncoder(data).Encode(newMessage); err != nil {
-			log.Errorf("Error encoding new SlackMessage: %+q", err)
-			return
-		}
+	data := new(bytes.Buffer)
+	if err := json.NewEncoder(data).Encode(newMessage); err != nil {
+		return err
+	}
 
-		req, err := http.NewRequest("POST", b.WebhookURL, data)
-		if err != nil {
-			log.Errorf("Error while creating new request object: %+q", err)
-			return
-		}
+	req, err := http.NewRequest("POST", b.WebhookURL, data)
+	if err != nil {
+		return err
+	}
 
-		req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", "application/json")
 
-		res, err := client.Do(req)
-		if err != nil {
-			log.Errorf("Error while making request to endpoint(%q): %q", b.WebhookURL, err.Error())
-			return
-		}
+	res, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	// Though we expect slack not to deliver any messages to us but to be safe
+	// discard and close body.
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return statusError(res.StatusCode)
+	}
+
+	return nil
+}
+
+// Deliver posts the events of the spool, events without the category,
+// sensor or type are dropped.
+func (b Backend) Deliver(events []event.Event) error {
+	for _, e := range events {
+		mp := make(map[string]interface{})
 
-		defer res.Body.Close()
+		e.Range(func(key, value interface{}) bool {
+			if keyName, ok := key.(string); ok {
+				mp[keyName] = value
+			}
+			return true
+		})
 
-		// Though we expect slack not to deliver any messages to us but to be safe
-		// discard and close body.
-		io.Copy(ioutil.Discard, res.Body)
+		err := b.post(mp)
+		if err == nil {
+			continue
+		}
 
-		if res.StatusCode == http.StatusOK {
-		} else if res.StatusCode == http.StatusCreated {
-		} else {
-			log.Errorf("API Response with unexpected Status Code[%d] to endpoint: %q", res.StatusCode, b.WebhookURL)
-			return
+		// the webhook is unavailable, the events are delivered again
+		if _, ok := err.(*url.Error); ok {
+			return err
+		} else if status, ok := err.(statusError); ok && (status == http.StatusTooManyRequests || status >= http.StatusInternalServerError) {
+			return err
 		}
 
+		log.Errorf("Error posting to endpoint(%q): %s", b.WebhookURL, err.Error())
+		pushers.DeliveryFailed("slack", 1)
 	}
+
+	return nil
 }
 
 // Send delivers the giving push messages to the required slack channel.
@@ -0,0 +1,142 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package socket writes the events as newline delimited json to a unix
+// socket, or a named pipe on windows, to hand the events to a collector on
+// the same host (eg. Vector or fluent-bit).
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("socket", New)
+)
+
+var log = logging.MustGetLogger("channels/socket")
+
+// reconnectDelay is the time between connection attempts.
+const reconnectDelay = 5 * time.Second
+
+// Config configures the socket channel.
+type Config struct {
+	// Path is the unix socket, or the named pipe (\\.\pipe\name) on
+	// windows
+	Path string `toml:"path"`
+
+	// Buffer is the number of events queued while the collector is
+	// unavailable, events are dropped when the queue is full
+	Buffer int `toml:"buffer"`
+}
+
+type socketChannel struct {
+	Config
+
+	ch chan []byte
+}
+
+// New returns a channel writing the events to a unix socket or named pipe.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := socketChannel{
+		Config: Config{
+			Buffer: 1000,
+		},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Path == "" {
+		return nil, errors.New("Socket channel: path not set")
+	}
+
+	c.ch = make(chan []byte, c.Buffer)
+
+	go c.run()
+
+	return &c, nil
+}
+
+// write writes the queued events to the connection, the event that failed
+// to be written is returned to be retried.
+func (c *socketChannel) write(w io.Writer, pending []byte) ([]byte, error) {
+	if pending != nil {
+		if _, err := w.Write(pending); err != nil {
+			return pending, err
+		}
+	}
+
+	for data := range c.ch {
+		if _, err := w.Write(data); err != nil {
+			return data, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *socketChannel) run() {
+	var pending []byte
+
+	for {
+		conn, err := dial(c.Path)
+		if err != nil {
+			log.Errorf("Error connecting to %s: %s", c.Path, err.Error())
+
+			time.Sleep(reconnectDelay)
+			continue
+		}
+
+		log.Infof("Connected to %s", c.Path)
+
+		pending, err = c.write(conn, pending)
+		conn.Close()
+
+		if err == nil {
+			return
+		}
+
+		log.Errorf("Error writing to %s: %s", c.Path, err.Error())
+
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// Send queues the event, the event is dropped when the collector can't
+// keep up.
+func (c *socketChannel) Send(e event.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Error marshalling event: %s", err.Error())
+		pushers.DeliveryFailed("socket", 1)
+		return
+	}
+
+	select {
+	case c.ch <- append(data, '\n'):
+	default:
+		log.Warningf("Queue of %s full, event dropped", c.Path)
+		pushers.DeliveryFailed("socket", 1)
+	}
+}
@@ -0,0 +1,94 @@
+// +build !windows
+
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package socket
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+func TestSocketChannel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "honeytrap.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	c, err := New(func(c pushers.Channel) error {
+		c.(*socketChannel).Path = path
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Send(event.New(
+		event.Category("test"),
+		event.Custom("source-ip", "127.0.0.1"),
+	))
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(line, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m["category"] != "test" {
+		t.Errorf("Expected category test, got %v", m["category"])
+	}
+
+	if m["source-ip"] != "127.0.0.1" {
+		t.Errorf("Expected source-ip 127.0.0.1, got %v", m["source-ip"])
+	}
+}
+
+func TestSocketChannelNoPath(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Error("Expected error without path")
+	}
+}
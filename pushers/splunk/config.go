@@ -16,7 +16,13 @@ package splunk
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/url"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
 )
 
 var (
@@ -29,13 +35,43 @@ type Config struct {
 	Endpoints []string
 	Token     string
 
+	// Index, SourceType, Source and Host are the metadata of the events,
+	// the defaults of the token are used when not set
+	Index      string
+	SourceType string
+	Source     string
+	Host       string
+
+	// BatchSize is the number of events sent with a single request
+	BatchSize int
+
+	// FlushInterval is the maximum time events are queued before being
+	// sent
+	FlushInterval config.Delay
+
+	// QueueSize is the number of events queued while Splunk is
+	// unavailable or can't keep up, events are dropped when the queue is
+	// full
+	QueueSize int
+
+	// Compress compresses the requests with gzip
+	Compress bool
+
+	// Ack waits for the acknowledgment of the indexing of the events,
+	// indexer acknowledgment has to be enabled for the token
+	Ack bool
+
+	// AckTimeout is the time the events have to be acknowledged, the
+	// events are sent to the next endpoint when not acknowledged in time
+	AckTimeout config.Delay
+
 	tlsConfig *tls.Config
+
+	proxy proxy.Func
 }
 
 // UnmarshalTOML deserializes the giving data into the config.
 func (c *Config) UnmarshalTOML(p interface{}) error {
-	c.tlsConfig = &tls.Config{}
-
 	data, _ := p.(map[string]interface{})
 
 	if v, ok := data["endpoints"]; !ok {
@@ -48,7 +84,7 @@ func (c *Config) UnmarshalTOML(p interface{}) error {
 			} else if u, err := url.Parse(e.(string)); err != nil {
 				return err
 			} else {
-				c.Endpoints = append(c.Endpoints, u.String())
+				c.Endpoints = append(c.Endpoints, strings.TrimSuffix(u.String(), "/"))
 			}
 		}
 
@@ -62,13 +98,79 @@ func (c *Config) UnmarshalTOML(p interface{}) error {
 		c.Token = v
 	}
 
+	for key, v := range map[string]*string{
+		"index":      &c.Index,
+		"sourcetype": &c.SourceType,
+		"source":     &c.Source,
+		"host":       &c.Host,
+	} {
+		if s, ok := data[key]; !ok {
+		} else if s, ok := s.(string); !ok {
+			return fmt.Errorf("Splunk %s should be a string", key)
+		} else {
+			*v = s
+		}
+	}
+
+	for key, v := range map[string]*int{
+		"batch-size": &c.BatchSize,
+		"queue-size": &c.QueueSize,
+	} {
+		if n, ok := data[key]; !ok {
+		} else if i, ok := n.(int64); !ok || i < 1 {
+			return fmt.Errorf("Splunk %s should be a positive number", key)
+		} else {
+			*v = int(i)
+		}
+	}
+
+	for key, v := range map[string]*config.Delay{
+		"flush-interval": &c.FlushInterval,
+		"ack-timeout":    &c.AckTimeout,
+	} {
+		if d, ok := data[key]; !ok {
+		} else if s, ok := d.(string); !ok {
+			return fmt.Errorf("Splunk %s should be a duration", key)
+		} else if err := v.UnmarshalText([]byte(s)); err != nil {
+			return err
+		}
+	}
+
+	for key, v := range map[string]*bool{
+		"compress": &c.Compress,
+		"ack":      &c.Ack,
+	} {
+		if b, ok := data[key]; !ok {
+		} else if b, ok := b.(bool); !ok {
+			return fmt.Errorf("Splunk %s should be a boolean", key)
+		} else {
+			*v = b
+		}
+	}
+
+	proxyURL, _ := data["proxy"].(string)
+
+	fn, err := proxy.New(proxyURL)
+	if err != nil {
+		return err
+	}
+
+	c.proxy = fn
+
+	insecure := false
+
 	if v, ok := data["verify"]; !ok {
 	} else if v, ok := v.(bool); !ok {
 	} else {
-		c.tlsConfig = &tls.Config{
-			InsecureSkipVerify: !v,
-		}
+		insecure = !v
 	}
 
+	tlsConfig, err := pushers.TLSConfigFrom(data).ClientConfig(insecure)
+	if err != nil {
+		return err
+	}
+
+	c.tlsConfig = tlsConfig
+
 	return nil
 }
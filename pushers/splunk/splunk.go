@@ -14,14 +14,20 @@
 package splunk
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-
 	"time"
 
-	hec "github.com/fuyufjh/splunk-hec-go"
-
+	"github.com/honeytrap/honeytrap/config"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
+	uuid "github.com/satori/go.uuid"
 
 	logging "github.com/op/go-logging"
 )
@@ -32,85 +38,269 @@ var (
 
 var log = logging.MustGetLogger("channels:splunk")
 
+// ackPollInterval is the interval the acknowledgments are checked.
+const ackPollInterval = time.Second
+
 // Backend defines a struct which provides a channel for delivery
-// push messages to an elasticsearch api.
+// push messages to the Splunk HTTP Event Collector.
 type Backend struct {
 	Config
 
-	ch chan map[string]interface{}
+	client *http.Client
+
+	// channel identifies the sender, the acknowledgments are per
+	// channel
+	channel string
+
+	// endpoint is the index of the endpoint the events are sent to, the
+	// next endpoint is tried when sending fails
+	endpoint int
+
+	ch chan []byte
 }
 
 func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
-	ch := make(chan map[string]interface{}, 100)
-
 	c := Backend{
-		ch: ch,
+		Config: Config{
+			SourceType:    "honeytrap",
+			BatchSize:     100,
+			FlushInterval: config.Delay(10 * time.Second),
+			QueueSize:     1000,
+			Compress:      true,
+			AckTimeout:    config.Delay(time.Minute),
+		},
+		channel: uuid.NewV4().String(),
 	}
 
 	for _, optionFn := range options {
-		optionFn(&c)
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.Endpoints) == 0 {
+		return nil, ErrEndpointsNotSet
 	}
 
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           c.proxy,
+			TLSClientConfig: c.tlsConfig,
+		},
+		Timeout: 20 * time.Second,
+	}
+
+	c.ch = make(chan []byte, c.QueueSize)
+
 	go c.run()
 
 	return &c, nil
 }
 
-func (hc Backend) run() {
+// response is the response of the event collector.
+type response struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+func (r response) Error() string {
+	return fmt.Sprintf("%s (code %d)", r.Text, r.Code)
+}
+
+// post posts the body to the path of the endpoint, and decodes the
+// response into v.
+func (hc *Backend) post(endpoint, path string, body []byte, v interface{}) error {
+	var r io.Reader = bytes.NewReader(body)
+
+	if hc.Compress {
+		buf := &bytes.Buffer{}
+
+		w := gzip.NewWriter(buf)
+		w.Write(body)
+		w.Close()
+
+		r = buf
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+path, r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Splunk "+hc.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Splunk-Request-Channel", hc.channel)
+
+	if hc.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		res := response{}
+		if err := json.Unmarshal(data, &res); err != nil || res.Text == "" {
+			return fmt.Errorf("Unexpected status %s", resp.Status)
+		}
+
+		return res
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+var errNotAcknowledged = errors.New("Events not acknowledged in time")
+
+// acknowledged waits until the events with the ack id have been indexed.
+func (hc *Backend) acknowledged(endpoint string, id int64) error {
+	body, _ := json.Marshal(map[string][]int64{
+		"acks": {id},
+	})
+
+	deadline := time.Now().Add(hc.AckTimeout.Duration())
+
+	for time.Now().Before(deadline) {
+		time.Sleep(ackPollInterval)
+
+		res := struct {
+			Acks map[string]bool `json:"acks"`
+		}{}
+
+		if err := hc.post(endpoint, "/services/collector/ack", body, &res); err != nil {
+			return err
+		}
+
+		if res.Acks[fmt.Sprint(id)] {
+			return nil
+		}
+	}
+
+	return errNotAcknowledged
+}
+
+// send sends the events to the endpoints, starting with the endpoint that
+// succeeded last.
+func (hc *Backend) send(batch [][]byte) error {
+	body := bytes.Join(batch, []byte("\n"))
+
+	var err error
+
+	for range hc.Endpoints {
+		endpoint := hc.Endpoints[hc.endpoint]
+
+		res := response{}
+
+		if err = hc.post(endpoint, "/services/collector/event", body, &res); err != nil {
+		} else if !hc.Ack {
+			return nil
+		} else if res.AckID == nil {
+			return errors.New("Indexer acknowledgment not enabled for the token")
+		} else if err = hc.acknowledged(endpoint, *res.AckID); err == nil {
+			return nil
+		}
+
+		log.Errorf("Error sending to %s: %s", endpoint, err.Error())
+
+		hc.endpoint = (hc.endpoint + 1) % len(hc.Endpoints)
+	}
+
+	return err
+}
+
+func (hc *Backend) run() {
 	log.Debug("Splunk indexer started...")
 	defer log.Debug("Splunk indexer stopped...")
 
-	client := hec.NewCluster(
-		hc.Config.Endpoints,
-		hc.Config.Token,
-	)
+	ticker := time.NewTicker(hc.FlushInterval.Duration())
+	defer ticker.Stop()
 
-	client.SetHTTPClient(&http.Client{Transport: &http.Transport{
-		TLSClientConfig: hc.tlsConfig,
-	}})
-
-	batch := []*hec.Event{}
+	batch := [][]byte{}
 
 	count := 0
 	for {
 		select {
-		case doc := <-hc.ch:
-			event := hec.NewEvent(doc)
-			event.SetTime(time.Now())
-
-			batch = append(batch, event)
-			if len(batch) < 10 {
+		case data := <-hc.ch:
+			batch = append(batch, data)
+			if len(batch) < hc.BatchSize {
 				continue
 			}
-		case <-time.After(time.Second * 10):
+		case <-ticker.C:
 		}
 
 		if len(batch) == 0 {
 			continue
 		}
 
-		if err := client.WriteBatch(batch); err != nil {
+		if err := hc.send(batch); err != nil {
 			log.Errorf("Error indexing: %s", err.Error())
+			pushers.DeliveryFailed("splunk", len(batch))
 		} else {
 			count += len(batch)
 
 			log.Infof("Bulk indexing: %d total %d", len(batch), count)
-
-			batch = []*hec.Event{}
 		}
+
+		batch = [][]byte{}
 	}
 }
 
-// Send delivers the giving push messages into the internal elastic search endpoint.
-func (hc Backend) Send(message event.Event) {
-	mp := make(map[string]interface{})
+// eventTime returns the date of the event in seconds since epoch.
+func eventTime(e event.Event) float64 {
+	t := time.Now()
 
-	message.Range(func(key, value interface{}) bool {
-		if keyName, ok := key.(string); ok {
-			mp[keyName] = value
+	e.Range(func(key, value interface{}) bool {
+		if key != "date" {
+			return true
 		}
-		return true
+
+		if d, ok := value.(time.Time); ok {
+			t = d
+		}
+
+		return false
 	})
 
-	hc.ch <- mp
+	return float64(t.UnixNano()/int64(time.Millisecond)) / 1000
+}
+
+// Send queues the event, the event is dropped when Splunk can't keep up.
+func (hc *Backend) Send(message event.Event) {
+	data, err := json.Marshal(struct {
+		Time       float64     `json:"time"`
+		Host       string      `json:"host,omitempty"`
+		Source     string      `json:"source,omitempty"`
+		SourceType string      `json:"sourcetype,omitempty"`
+		Index      string      `json:"index,omitempty"`
+		Event      event.Event `json:"event"`
+	}{
+		Time:       eventTime(message),
+		Host:       hc.Host,
+		Source:     hc.Source,
+		SourceType: hc.SourceType,
+		Index:      hc.Index,
+		Event:      message,
+	})
+	if err != nil {
+		log.Errorf("Error marshalling event: %s", err.Error())
+		pushers.DeliveryFailed("splunk", 1)
+		return
+	}
+
+	select {
+	case hc.ch <- data:
+	default:
+		log.Warningf("Queue full, event dropped")
+		pushers.DeliveryFailed("splunk", 1)
+	}
 }
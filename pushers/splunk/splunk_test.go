@@ -12,3 +12,223 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 package splunk_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/splunk"
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+// collector emulates the http event collector.
+type collector struct {
+	m sync.Mutex
+
+	events  []map[string]interface{}
+	channel string
+	acked   bool
+
+	received chan struct{}
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if r.Header.Get("Authorization") != "Splunk secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"text":"Invalid authorization","code":3}`)
+		return
+	}
+
+	var body io.Reader = r.Body
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body = gr
+	}
+
+	c.channel = r.Header.Get("X-Splunk-Request-Channel")
+
+	switch r.URL.Path {
+	case "/services/collector/event":
+		d := json.NewDecoder(bufio.NewReader(body))
+
+		for d.More() {
+			e := map[string]interface{}{}
+			if err := d.Decode(&e); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"text":"Invalid data format","code":6}`)
+				return
+			}
+
+			c.events = append(c.events, e)
+		}
+
+		fmt.Fprint(w, `{"text":"Success","code":0,"ackId":7}`)
+
+		c.received <- struct{}{}
+	case "/services/collector/ack":
+		req := struct {
+			Acks []int64 `json:"acks"`
+		}{}
+
+		json.NewDecoder(body).Decode(&req)
+
+		c.acked = len(req.Acks) == 1 && req.Acks[0] == 7
+
+		fmt.Fprintf(w, `{"acks":{"7":%t}}`, c.acked)
+	}
+}
+
+func newChannel(t *testing.T, config string) pushers.Channel {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(config, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := splunk.New(pushers.WithConfig(s.P, &md))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestSplunk(t *testing.T) {
+	hec := &collector{received: make(chan struct{}, 1)}
+
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	c := newChannel(t, fmt.Sprintf(`p = { endpoints = ["%s"], token = "secret", index = "honeypot", batch-size = 2, ack = true }`, server.URL))
+
+	for i := 0; i < 2; i++ {
+		c.Send(event.New(
+			event.Category("ssh"),
+			event.Custom("ssh.username", "root"),
+		))
+	}
+
+	select {
+	case <-hec.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected events to be sent")
+	}
+
+	// the ack is checked after the poll interval
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		hec.m.Lock()
+		acked := hec.acked
+		hec.m.Unlock()
+
+		if acked {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("Expected ack to be checked")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	hec.m.Lock()
+	defer hec.m.Unlock()
+
+	if len(hec.events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(hec.events))
+	}
+
+	if hec.channel == "" {
+		t.Errorf("Expected request channel to be set")
+	}
+
+	e := hec.events[0]
+
+	if e["index"] != "honeypot" || e["sourcetype"] != "honeytrap" {
+		t.Errorf("Expected index and sourcetype, got %v", e)
+	}
+
+	if fields, ok := e["event"].(map[string]interface{}); !ok || fields["ssh.username"] != "root" {
+		t.Errorf("Expected event fields, got %v", e["event"])
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, config := range []string{
+		`p = { token = "secret" }`,
+		`p = { endpoints = ["http://127.0.0.1:8088"] }`,
+		`p = { endpoints = ["http://127.0.0.1:8088"], token = "secret", batch-size = 0 }`,
+		`p = { endpoints = ["http://127.0.0.1:8088"], token = "secret", compress = "yes" }`,
+	} {
+		s := struct {
+			P toml.Primitive
+		}{}
+
+		md, err := toml.Decode(config, &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := splunk.New(pushers.WithConfig(s.P, &md)); err == nil {
+			t.Errorf("Expected error for %s", config)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	unavailable := harness.NewHTTPBackend(nil)
+	defer unavailable.Close()
+
+	unavailable.Fail = 1 << 30
+
+	hec := harness.NewHTTPBackend(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"text":"Success","code":0}`)
+	}))
+	defer hec.Close()
+
+	c := newChannel(t, fmt.Sprintf(`p = { endpoints = ["%s", "%s"], token = "secret", batch-size = 1 }`, unavailable.URL, hec.URL))
+
+	c.Send(harness.FixtureEvent("ssh-password"))
+
+	requests, err := hec.Wait(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unavailable.Requests()) != 1 {
+		t.Errorf("Expected the first endpoint to be tried, got %d requests", len(unavailable.Requests()))
+	}
+
+	e := map[string]interface{}{}
+	if err := json.Unmarshal(requests[0].Body, &e); err != nil {
+		t.Fatal(err)
+	}
+
+	// the time of the event is sent in seconds since epoch
+	if e["time"] != float64(harness.FixtureDate.Unix()) {
+		t.Errorf("Expected time of the event, got %v", e["time"])
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:channels")
+
+// Deliverer is implemented by the channels that can deliver events
+// synchronously, reporting whether the events have been delivered. Only
+// these channels can be spooled.
+type Deliverer interface {
+	Deliver([]event.Event) error
+}
+
+// Spool defines the write-ahead spool of a channel. The events are written
+// to disk before delivery and replayed until the channel delivers them, so
+// events aren't lost while the downstream service is unavailable.
+type Spool struct {
+	Enabled bool `toml:"enabled"`
+
+	// MaxSize is the maximum size of the spool in bytes, the oldest events
+	// are evicted when the spool is full
+	MaxSize int64 `toml:"max-size"`
+
+	// SegmentSize is the size of the files of the spool, the files are
+	// removed when all their events have been delivered or evicted
+	SegmentSize int64 `toml:"segment-size"`
+
+	// BatchSize is the number of events delivered at once
+	BatchSize int `toml:"batch-size"`
+
+	// RetryInterval is the time between attempts to deliver the events
+	// while the channel is failing
+	RetryInterval config.Delay `toml:"retry-interval"`
+}
+
+const cursorFile = "cursor"
+
+type spoolChannel struct {
+	Spool
+
+	// name is the type of the channel, for the metrics
+	name string
+	dir  string
+	d    Deliverer
+
+	m sync.Mutex
+
+	// the segment written to, and the position of the first event that
+	// hasn't been delivered
+	w          *os.File
+	wseq       int
+	wsize      int64
+	rseq, roff int64
+
+	// size is the total size of the segments
+	size int64
+
+	notify chan struct{}
+}
+
+func (s *spoolChannel) segment(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d.spool", seq))
+}
+
+// segments returns the sequence numbers of the segments, in order.
+func (s *spoolChannel) segments() ([]int64, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.spool"))
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := []int64{}
+	for _, name := range files {
+		var seq int64
+		if _, err := fmt.Sscanf(filepath.Base(name), "%d.spool", &seq); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+
+	return seqs, nil
+}
+
+// openSpool opens the spool in the directory, events spooled before are
+// replayed from the cursor.
+func openSpool(name, dir string, d Deliverer, spool Spool) (*spoolChannel, error) {
+	if spool.MaxSize <= 0 {
+		spool.MaxSize = 256 * 1024 * 1024
+	}
+
+	if spool.SegmentSize <= 0 {
+		spool.SegmentSize = 8 * 1024 * 1024
+	}
+
+	// eviction removes whole segments
+	if spool.SegmentSize > spool.MaxSize/2 {
+		spool.SegmentSize = spool.MaxSize / 2
+	}
+
+	if spool.BatchSize <= 0 {
+		spool.BatchSize = 100
+	}
+
+	if spool.RetryInterval <= 0 {
+		spool.RetryInterval = config.Delay(10 * time.Second)
+	}
+
+	s := &spoolChannel{
+		Spool:  spool,
+		name:   name,
+		dir:    dir,
+		d:      d,
+		notify: make(chan struct{}, 1),
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	seqs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, cursorFile)); err == nil {
+		fmt.Sscanf(string(data), "%d %d", &s.rseq, &s.roff)
+	}
+
+	for _, seq := range seqs {
+		if seq < s.rseq {
+			os.Remove(s.segment(seq))
+			continue
+		}
+
+		if fi, err := os.Stat(s.segment(seq)); err == nil {
+			s.size += fi.Size()
+		}
+
+		s.wseq = int(seq)
+	}
+
+	if s.wseq == 0 {
+		s.wseq = 1
+	}
+
+	if s.rseq == 0 || s.rseq > int64(s.wseq) {
+		s.rseq, s.roff = int64(s.wseq), 0
+	}
+
+	if s.w, err = os.OpenFile(s.segment(int64(s.wseq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	}
+
+	fi, err := s.w.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	s.wsize = fi.Size()
+
+	return s, nil
+}
+
+func (s *spoolChannel) writeCursor() {
+	if err := ioutil.WriteFile(filepath.Join(s.dir, cursorFile), []byte(fmt.Sprintf("%d %d\n", s.rseq, s.roff)), 0600); err != nil {
+		log.Errorf("Error writing spool cursor: %s", err.Error())
+	}
+}
+
+// evict removes the oldest segment, the events that haven't been
+// delivered are counted as failed.
+func (s *spoolChannel) evict() {
+	name := s.segment(s.rseq)
+
+	if data, err := ioutil.ReadFile(name); err == nil && s.roff <= int64(len(data)) {
+		n := bytes.Count(data[s.roff:], []byte("\n"))
+
+		log.Warningf("Spool of channel %s full, %d events evicted", s.name, n)
+		DeliveryFailed(s.name, n)
+
+		s.size -= int64(len(data))
+	}
+
+	os.Remove(name)
+
+	s.rseq, s.roff = s.rseq+1, 0
+	s.writeCursor()
+}
+
+// Send writes the event to the spool.
+func (s *spoolChannel) Send(e event.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Error spooling event: %s", err.Error())
+		DeliveryFailed(s.name, 1)
+		return
+	}
+
+	data = append(data, '\n')
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.wsize > 0 && s.wsize+int64(len(data)) > s.SegmentSize {
+		s.w.Close()
+
+		s.wseq++
+		s.wsize = 0
+
+		if s.w, err = os.OpenFile(s.segment(int64(s.wseq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			log.Errorf("Error spooling event: %s", err.Error())
+			DeliveryFailed(s.name, 1)
+			return
+		}
+	}
+
+	n, err := s.w.Write(data)
+
+	s.wsize += int64(n)
+	s.size += int64(n)
+
+	if err != nil {
+		log.Errorf("Error spooling event: %s", err.Error())
+		DeliveryFailed(s.name, 1)
+		return
+	}
+
+	for s.size > s.MaxSize && s.rseq < int64(s.wseq) {
+		s.evict()
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// decodeEvent decodes the spooled event.
+func decodeEvent(data []byte) (event.Event, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	m := map[string]interface{}{}
+	if err := d.Decode(&m); err != nil {
+		return event.Event{}, err
+	}
+
+	e := event.New(event.CopyFrom(m))
+
+	if s, ok := m["date"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			e.Store("date", t)
+		}
+	}
+
+	return e, nil
+}
+
+// read reads the next batch of events, it returns the position after the
+// batch.
+func (s *spoolChannel) read() ([]event.Event, int64, int64, error) {
+	s.m.Lock()
+	seq, off, last := s.rseq, s.roff, int64(s.wseq)
+	s.m.Unlock()
+
+	events := []event.Event{}
+
+	for len(events) < s.BatchSize {
+		f, err := os.Open(s.segment(seq))
+		if os.IsNotExist(err) && seq < last {
+			// evicted
+			seq, off = seq+1, 0
+			continue
+		} else if err != nil {
+			return events, seq, off, err
+		}
+
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			f.Close()
+			return events, seq, off, err
+		}
+
+		r := bufio.NewReader(f)
+
+		for len(events) < s.BatchSize {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				// incomplete lines are being written
+				break
+			}
+
+			off += int64(len(line))
+
+			e, err := decodeEvent(line)
+			if err != nil {
+				log.Errorf("Error reading spooled event: %s", err.Error())
+				DeliveryFailed(s.name, 1)
+				continue
+			}
+
+			events = append(events, e)
+		}
+
+		f.Close()
+
+		if len(events) == s.BatchSize || seq >= last {
+			break
+		}
+
+		// the segment has been read
+		seq, off = seq+1, 0
+	}
+
+	return events, seq, off, nil
+}
+
+// commit moves the cursor to the position, the segments that have been
+// delivered are removed.
+func (s *spoolChannel) commit(seq, off int64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// the events have been evicted while delivering
+	if seq < s.rseq || (seq == s.rseq && off <= s.roff) {
+		return
+	}
+
+	for ; s.rseq < seq; s.rseq++ {
+		if fi, err := os.Stat(s.segment(s.rseq)); err == nil {
+			s.size -= fi.Size()
+		}
+
+		os.Remove(s.segment(s.rseq))
+	}
+
+	s.roff = off
+	s.writeCursor()
+}
+
+// flush delivers the next batch of events, it returns false when there are
+// no events to deliver.
+func (s *spoolChannel) flush() (bool, error) {
+	events, seq, off, err := s.read()
+	if err != nil {
+		return false, err
+	}
+
+	if len(events) == 0 {
+		// undecodable events are skipped
+		s.commit(seq, off)
+		return false, nil
+	}
+
+	if err := s.d.Deliver(events); err != nil {
+		return true, err
+	}
+
+	s.commit(seq, off)
+	return true, nil
+}
+
+func (s *spoolChannel) run() {
+	retry := time.NewTicker(s.RetryInterval.Duration())
+	defer retry.Stop()
+
+	failing := false
+
+	for {
+		more, err := s.flush()
+		if err != nil {
+			if !failing {
+				log.Errorf("Error delivering spooled events of channel %s, retrying: %s", s.name, err.Error())
+			}
+
+			failing = true
+
+			<-retry.C
+			continue
+		}
+
+		if failing {
+			log.Infof("Channel %s recovered, replaying spooled events", s.name)
+			failing = false
+		}
+
+		if more {
+			continue
+		}
+
+		select {
+		case <-s.notify:
+		case <-retry.C:
+		}
+	}
+}
+
+// SpoolChannel returns a Channel that writes the events to the spool in the
+// directory, and delivers them from the spool. The name is the type of the
+// channel.
+func SpoolChannel(channel Channel, name, dir string, spool Spool) (Channel, error) {
+	d, ok := channel.(Deliverer)
+	if !ok {
+		return nil, fmt.Errorf("Channel %s doesn't support spooling", strings.TrimSpace(name))
+	}
+
+	s, err := openSpool(name, dir, d, spool)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.run()
+
+	return s, nil
+}
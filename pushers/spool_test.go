@@ -0,0 +1,211 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// deliverChannel records the delivered events, failing while fail is set.
+type deliverChannel struct {
+	recordChannel
+
+	fail bool
+}
+
+func (c *deliverChannel) Deliver(events []event.Event) error {
+	if c.fail {
+		return errors.New("unavailable")
+	}
+
+	for _, e := range events {
+		c.Send(e)
+	}
+
+	return nil
+}
+
+func spoolEvent(i int) event.Event {
+	return event.New(
+		event.Custom("date", time.Date(2019, 6, 1, 12, 0, i, 0, time.UTC)),
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+		event.Custom("ssh.password", "123456"),
+		event.Custom("destination-port", 22),
+	)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	dc := &deliverChannel{fail: true}
+
+	s, err := openSpool("test", dir, dc, Spool{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		s.Send(spoolEvent(i))
+	}
+
+	if _, err := s.flush(); err == nil {
+		t.Fatal("Expected the delivery to fail")
+	}
+
+	dc.fail = false
+
+	for {
+		more, err := s.flush()
+		if err != nil {
+			t.Fatal(err)
+		} else if !more {
+			break
+		}
+	}
+
+	if len(dc.events) != 3 {
+		t.Fatalf("Expected 3 events to be delivered, got %d", len(dc.events))
+	}
+
+	e := dc.events[2]
+
+	if category := e.Get("category"); category != "ssh" {
+		t.Errorf("Expected category ssh, got %q", category)
+	}
+
+	var date interface{}
+	e.Range(func(key, value interface{}) bool {
+		if key == "date" {
+			date = value
+		}
+		return true
+	})
+
+	if d, ok := date.(time.Time); !ok || !d.Equal(time.Date(2019, 6, 1, 12, 0, 2, 0, time.UTC)) {
+		t.Errorf("Expected the date to be restored, got %v", date)
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	dc := &deliverChannel{}
+
+	s, err := openSpool("test", dir, dc, Spool{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		s.Send(spoolEvent(i))
+	}
+
+	if _, err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.w.Close()
+
+	// the events not delivered before the restart are replayed
+	dc = &deliverChannel{}
+
+	s, err = openSpool("test", dir, dc, Spool{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.w.Close()
+
+	if _, err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dc.events) != 1 {
+		t.Fatalf("Expected 1 event to be replayed, got %d", len(dc.events))
+	}
+}
+
+func TestSpoolEviction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	dc := &deliverChannel{}
+
+	data, _ := spoolEvent(0).MarshalJSON()
+	size := int64(len(data) + 1)
+
+	// segments of two events, the spool holds at most four
+	s, err := openSpool("test", dir, dc, Spool{MaxSize: 4 * size, SegmentSize: 2 * size, BatchSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.w.Close()
+
+	for i := 0; i < 7; i++ {
+		s.Send(spoolEvent(i))
+	}
+
+	if s.size > s.MaxSize {
+		t.Errorf("Expected the spool to be at most %d bytes, got %d", s.MaxSize, s.size)
+	}
+
+	if _, err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dc.events) != 3 {
+		t.Fatalf("Expected the oldest events to be evicted, got %d events", len(dc.events))
+	}
+
+	var date interface{}
+	dc.events[0].Range(func(key, value interface{}) bool {
+		if key == "date" {
+			date = value
+		}
+		return true
+	})
+
+	if d, ok := date.(time.Time); !ok || d.Second() != 4 {
+		t.Errorf("Expected the first event after eviction, got %v", date)
+	}
+
+	if seqs, _ := s.segments(); len(seqs) != 1 {
+		t.Errorf("Expected the delivered segments to be removed, got %v", seqs)
+	}
+}
+
+func TestSpoolChannelDeliverer(t *testing.T) {
+	if _, err := SpoolChannel(&recordChannel{}, "test", "", Spool{}); err == nil {
+		t.Error("Expected an error for a channel without delivery")
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package syslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/cmd"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/severity"
+)
+
+const (
+	vendor  = "Honeytrap"
+	product = "Honeytrap"
+)
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// formatter formats the event as the message of the syslog message.
+type formatter func(event.Event) ([]byte, error)
+
+var formats = map[string]formatter{
+	"json": formatJSON,
+	"cef":  formatCEF,
+	"leef": formatLEEF,
+}
+
+// syslogSeverity returns the syslog severity of the severity of the event,
+// informational when not scored.
+func syslogSeverity(e event.Event) int {
+	switch e.Get("severity") {
+	case severity.Critical:
+		return 2
+	case severity.High:
+		return 3
+	case severity.Medium:
+		return 4
+	case severity.Low:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// scale returns the severity of the event on the 0 to 10 scale of CEF and
+// LEEF.
+func scale(e event.Event) int {
+	switch e.Get("severity") {
+	case severity.Critical:
+		return 10
+	case severity.High:
+		return 8
+	case severity.Medium:
+		return 5
+	case severity.Low:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// date returns the date of the event, or the current time.
+func date(e event.Event) time.Time {
+	d := time.Now()
+
+	e.Range(func(k, v interface{}) bool {
+		if k != "date" {
+			return true
+		}
+
+		if t, ok := v.(time.Time); ok {
+			d = t
+		}
+
+		return false
+	})
+
+	return d
+}
+
+// headerField returns the value as RFC 5424 header field: printable ascii
+// without spaces, the nil value when empty.
+func headerField(s string, max int) string {
+	b := strings.Builder{}
+
+	for _, r := range s {
+		if b.Len() >= max {
+			break
+		}
+
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+	}
+
+	if b.Len() == 0 {
+		return "-"
+	}
+
+	return b.String()
+}
+
+// message returns the RFC 5424 message, without structured data.
+func (c *syslogChannel) message(e event.Event, msg []byte) []byte {
+	buf := bytes.Buffer{}
+
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s - %s - ",
+		c.facility*8+syslogSeverity(e),
+		date(e).UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(c.Hostname, 255),
+		headerField(c.AppName, 48),
+		headerField(e.Get("category"), 32),
+	)
+
+	buf.Write(msg)
+	return buf.Bytes()
+}
+
+func formatJSON(e event.Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// fields returns the fields of the event as strings.
+func fields(e event.Event) map[string]string {
+	m := map[string]string{}
+
+	e.Range(func(k, v interface{}) bool {
+		key, ok := k.(string)
+		if !ok {
+			return true
+		}
+
+		switch v := v.(type) {
+		case string:
+			m[key] = v
+		case []byte:
+			m[key] = string(v)
+		case time.Time:
+			m[key] = v.UTC().Format(time.RFC3339)
+		case []string:
+			m[key] = strings.Join(v, ",")
+		default:
+			m[key] = fmt.Sprint(v)
+		}
+
+		return true
+	})
+
+	return m
+}
+
+// extension writes the fields as key value pairs, the mapped fields with
+// their key first, the other fields in order of name.
+func extension(m map[string]string, mapping [][2]string, write func(k, v string)) {
+	done := map[string]bool{}
+
+	for _, kv := range mapping {
+		if v, ok := m[kv[0]]; ok && v != "" && !done[kv[0]] {
+			write(kv[1], v)
+			done[kv[0]] = true
+		}
+	}
+
+	keys := []string{}
+	for k := range m {
+		if !done[k] {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		write(extensionKey(k), m[k])
+	}
+}
+
+// extensionKey returns the name of a field usable as key, characters other
+// than letters, digits, dots and underscores are replaced.
+func extensionKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// eventID returns the category and type of the event, which identify the
+// kind of event.
+func eventID(m map[string]string) string {
+	if m["type"] == "" {
+		return m["category"]
+	}
+
+	return m["category"] + ":" + m["type"]
+}
+
+var cefMapping = [][2]string{
+	{"source-ip", "src"},
+	{"source-port", "spt"},
+	{"destination-ip", "dst"},
+	{"destination-port", "dpt"},
+	{"protocol", "proto"},
+	{"date", "rt"},
+	{"service", "app"},
+	{"sensor", "dvchost"},
+	{"message", "msg"},
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// formatCEF formats the event as ArcSight Common Event Format.
+func formatCEF(e event.Event) ([]byte, error) {
+	m := fields(e)
+
+	// the receipt time is in milliseconds since epoch
+	if _, ok := m["date"]; ok {
+		m["date"] = fmt.Sprint(date(e).UnixNano() / int64(time.Millisecond))
+	}
+
+	name := m["message"]
+	if name == "" {
+		name = eventID(m)
+	}
+
+	buf := bytes.Buffer{}
+
+	fmt.Fprintf(&buf, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(vendor),
+		cefHeaderEscaper.Replace(product),
+		cefHeaderEscaper.Replace(cmd.Version),
+		cefHeaderEscaper.Replace(eventID(m)),
+		cefHeaderEscaper.Replace(name),
+		scale(e),
+	)
+
+	first := true
+
+	extension(m, cefMapping, func(k, v string) {
+		if !first {
+			buf.WriteByte(' ')
+		}
+
+		first = false
+
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(cefExtensionEscaper.Replace(v))
+	})
+
+	return buf.Bytes(), nil
+}
+
+var leefMapping = [][2]string{
+	{"source-ip", "src"},
+	{"source-port", "srcPort"},
+	{"destination-ip", "dst"},
+	{"destination-port", "dstPort"},
+	{"protocol", "proto"},
+	{"category", "cat"},
+	{"date", "devTime"},
+	{"service", "service"},
+}
+
+var (
+	leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	leefValueEscaper  = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+// formatLEEF formats the event as QRadar Log Event Extended Format 1.0,
+// the attributes are separated by tabs.
+func formatLEEF(e event.Event) ([]byte, error) {
+	m := fields(e)
+
+	buf := bytes.Buffer{}
+
+	fmt.Fprintf(&buf, "LEEF:1.0|%s|%s|%s|%s|",
+		leefHeaderEscaper.Replace(vendor),
+		leefHeaderEscaper.Replace(product),
+		leefHeaderEscaper.Replace(cmd.Version),
+		leefHeaderEscaper.Replace(eventID(m)),
+	)
+
+	first := true
+
+	write := func(k, v string) {
+		if !first {
+			buf.WriteByte('\t')
+		}
+
+		first = false
+
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(leefValueEscaper.Replace(v))
+	}
+
+	write("sev", fmt.Sprint(scale(e)))
+
+	if _, ok := m["date"]; ok {
+		write("devTimeFormat", "yyyy-MM-dd'T'HH:mm:ssX")
+	}
+
+	extension(m, leefMapping, write)
+
+	return buf.Bytes(), nil
+}
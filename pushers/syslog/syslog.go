@@ -0,0 +1,222 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package syslog sends the events as RFC 5424 syslog messages over udp, tcp
+// or tls, formatted as CEF, LEEF or json, to feed SIEMs like ArcSight and
+// QRadar.
+package syslog
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("syslog", New)
+)
+
+var log = logging.MustGetLogger("channels/syslog")
+
+// reconnectDelay is the time between connection attempts.
+const reconnectDelay = 5 * time.Second
+
+// Config configures the syslog channel.
+type Config struct {
+	// Address is the host and port of the syslog server
+	Address string `toml:"address"`
+
+	// Transport is udp, tcp or tls, messages over tcp and tls are framed
+	// with octet counting (RFC 6587)
+	Transport string `toml:"transport"`
+
+	// Format is the format of the message: cef, leef or json
+	Format string `toml:"format"`
+
+	// Facility is the name of the facility, eg. local0 or auth
+	Facility string `toml:"facility"`
+
+	// Hostname is the hostname in the header, the hostname of the
+	// sensor when not set
+	Hostname string `toml:"hostname"`
+
+	// AppName is the application in the header
+	AppName string `toml:"app-name"`
+
+	// Buffer is the number of events queued while the server is
+	// unavailable, events are dropped when the queue is full
+	Buffer int `toml:"buffer"`
+
+	// Insecure skips the verification of the server certificate
+	Insecure bool `toml:"insecure"`
+
+	pushers.TLSConfig
+}
+
+type syslogChannel struct {
+	Config
+
+	facility int
+	format   formatter
+
+	ch chan []byte
+}
+
+// New returns a channel sending the events to a syslog server.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := syslogChannel{
+		Config: Config{
+			Transport: "udp",
+			Format:    "json",
+			Facility:  "local0",
+			AppName:   "honeytrap",
+			Buffer:    1000,
+		},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Address == "" {
+		return nil, errors.New("Syslog channel: address not set")
+	}
+
+	switch c.Transport {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("Syslog channel: unsupported transport %s", c.Transport)
+	}
+
+	format, ok := formats[c.Format]
+	if !ok {
+		return nil, fmt.Errorf("Syslog channel: unsupported format %s", c.Format)
+	}
+
+	c.format = format
+
+	facility, ok := facilities[c.Facility]
+	if !ok {
+		return nil, fmt.Errorf("Syslog channel: unknown facility %s", c.Facility)
+	}
+
+	c.facility = facility
+
+	if c.Hostname == "" {
+		c.Hostname, _ = os.Hostname()
+	}
+
+	if c.Buffer < 1 {
+		return nil, errors.New("Syslog channel: buffer should be at least 1")
+	}
+
+	c.ch = make(chan []byte, c.Buffer)
+
+	go c.run()
+
+	return &c, nil
+}
+
+func (c *syslogChannel) dial() (net.Conn, error) {
+	if c.Transport != "tls" {
+		return net.Dial(c.Transport, c.Address)
+	}
+
+	config, err := c.ClientConfig(c.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.Dial("tcp", c.Address, config)
+}
+
+// write writes the queued messages to the connection, the message that
+// failed to be written is returned to be retried.
+func (c *syslogChannel) write(w io.Writer, pending []byte) ([]byte, error) {
+	if pending != nil {
+		if _, err := w.Write(pending); err != nil {
+			return pending, err
+		}
+	}
+
+	for data := range c.ch {
+		if _, err := w.Write(data); err != nil {
+			return data, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *syslogChannel) run() {
+	var pending []byte
+
+	for {
+		conn, err := c.dial()
+		if err != nil {
+			log.Errorf("Error connecting to %s: %s", c.Address, err.Error())
+
+			time.Sleep(reconnectDelay)
+			continue
+		}
+
+		log.Infof("Connected to %s over %s", c.Address, c.Transport)
+
+		pending, err = c.write(conn, pending)
+		conn.Close()
+
+		if err == nil {
+			return
+		}
+
+		log.Errorf("Error writing to %s: %s", c.Address, err.Error())
+
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// Send queues the event, the event is dropped when the server can't keep
+// up.
+func (c *syslogChannel) Send(e event.Event) {
+	msg, err := c.format(e)
+	if err != nil {
+		log.Errorf("Error formatting event: %s", err.Error())
+		pushers.DeliveryFailed("syslog", 1)
+		return
+	}
+
+	data := c.message(e, msg)
+
+	// udp sends a message per datagram, the stream transports frame the
+	// messages with their length
+	if c.Transport != "udp" {
+		data = append([]byte(fmt.Sprintf("%d ", len(data))), data...)
+	}
+
+	select {
+	case c.ch <- data:
+	default:
+		log.Warningf("Queue of %s full, event dropped", c.Address)
+		pushers.DeliveryFailed("syslog", 1)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+func testEvent() event.Event {
+	return event.New(
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+		event.Service("ssh"),
+		event.Custom("source-ip", "1.2.3.4"),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "a=b|c"),
+		event.Custom("severity", "high"),
+	)
+}
+
+func TestFormatCEF(t *testing.T) {
+	data, err := formatCEF(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := string(data)
+
+	for _, expected := range []string{
+		"CEF:0|Honeytrap|Honeytrap|",
+		"|ssh:password-authentication|ssh:password-authentication|8|",
+		"src=1.2.3.4",
+		"app=ssh",
+		`ssh.password=a\=b|c`,
+		"ssh.username=root",
+	} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("Expected %q in %s", expected, s)
+		}
+	}
+}
+
+func TestFormatLEEF(t *testing.T) {
+	data, err := formatLEEF(testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := string(data)
+
+	if !strings.HasPrefix(s, "LEEF:1.0|Honeytrap|Honeytrap|") {
+		t.Errorf("Expected LEEF header, got %s", s)
+	}
+
+	attributes := strings.Split(s[strings.Index(s, "ssh:password-authentication|")+len("ssh:password-authentication|"):], "\t")
+
+	for _, expected := range []string{"sev=8", "src=1.2.3.4", "cat=ssh", "ssh.username=root"} {
+		found := false
+
+		for _, a := range attributes {
+			found = found || a == expected
+		}
+
+		if !found {
+			t.Errorf("Expected attribute %s in %q", expected, attributes)
+		}
+	}
+}
+
+var headerRegexp = regexp.MustCompile(`^<(\d+)>1 \S+ sensor honeytrap - ssh - `)
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	c, err := New(func(c pushers.Channel) error {
+		c.(*syslogChannel).Address = conn.LocalAddr().String()
+		c.(*syslogChannel).Hostname = "sensor"
+		c.(*syslogChannel).Format = "cef"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Send(testEvent())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 65535)
+
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := headerRegexp.FindStringSubmatch(string(buf[:n]))
+	if m == nil {
+		t.Fatalf("Expected RFC 5424 header, got %s", buf[:n])
+	}
+
+	// local0 and error
+	if m[1] != strconv.Itoa(16*8+3) {
+		t.Errorf("Expected priority %d, got %s", 16*8+3, m[1])
+	}
+
+	if !strings.Contains(string(buf[:n]), " - CEF:0|") {
+		t.Errorf("Expected CEF message, got %s", buf[:n])
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	c, err := New(func(c pushers.Channel) error {
+		c.(*syslogChannel).Address = l.Addr().String()
+		c.(*syslogChannel).Transport = "tcp"
+		c.(*syslogChannel).Hostname = "sensor"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Send(testEvent())
+	c.Send(testEvent())
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+
+	// the messages are framed with octet counting
+	for i := 0; i < 2; i++ {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if !headerRegexp.Match(msg) || !strings.Contains(string(msg), `"ssh.username":"root"`) {
+			t.Errorf("Expected json message, got %s", msg)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, fn := range []func(*syslogChannel){
+		func(c *syslogChannel) {},
+		func(c *syslogChannel) { c.Address = "127.0.0.1:514"; c.Transport = "sctp" },
+		func(c *syslogChannel) { c.Address = "127.0.0.1:514"; c.Format = "xml" },
+		func(c *syslogChannel) { c.Address = "127.0.0.1:514"; c.Facility = "local8" },
+	} {
+		fn := fn
+
+		if _, err := New(func(c pushers.Channel) error {
+			fn(c.(*syslogChannel))
+			return nil
+		}); err == nil {
+			t.Errorf("Expected error")
+		}
+	}
+}
+
+func TestFormatGolden(t *testing.T) {
+	for name, format := range map[string]formatter{
+		"cef":  formatCEF,
+		"leef": formatLEEF,
+	} {
+		for _, f := range harness.Fixtures() {
+			data, err := format(f.Event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			harness.Golden(t, filepath.Join(name, f.Name), append(data, '\n'))
+		}
+	}
+}
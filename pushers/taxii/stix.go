@@ -0,0 +1,287 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package taxii
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	uuid "github.com/satori/go.uuid"
+)
+
+// stixNamespace is the namespace of the deterministic identifiers of the
+// cyber observables.
+var stixNamespace = uuid.Must(uuid.FromString("00abedb4-aa42-466c-9c01-fed23315a9b7"))
+
+// object is a STIX 2.1 object.
+type object map[string]interface{}
+
+// ID returns the identifier of the object.
+func (o object) ID() string {
+	id, _ := o["id"].(string)
+	return id
+}
+
+// Type returns the type of the object.
+func (o object) Type() string {
+	t, _ := o["type"].(string)
+	return t
+}
+
+// timestamp formats the time as STIX timestamp, in utc with millisecond
+// precision.
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// canonical returns the json serialization of the value, with the keys
+// sorted and without escaping html characters.
+func canonical(v interface{}) string {
+	buf := &bytes.Buffer{}
+
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// deterministicID returns the identifier of the object, based on the
+// properties contributing to the identifier, for the same observable to
+// have the same identifier everywhere.
+func deterministicID(typ string, properties map[string]interface{}) string {
+	return typ + "--" + uuid.NewV5(stixNamespace, canonical(properties)).String()
+}
+
+// randomID returns a new identifier of the type.
+func randomID(typ string) string {
+	return typ + "--" + uuid.NewV4().String()
+}
+
+// escape escapes the value within a pattern.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+func addressObject(s string) (object, bool) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, false
+	}
+
+	typ := "ipv6-addr"
+	if ip.To4() != nil {
+		typ = "ipv4-addr"
+	}
+
+	return object{
+		"type":         typ,
+		"spec_version": "2.1",
+		"id":           deterministicID(typ, map[string]interface{}{"value": ip.String()}),
+		"value":        ip.String(),
+	}, true
+}
+
+func fileObject(payload string) object {
+	hash := sha256.Sum256([]byte(payload))
+
+	hashes := map[string]interface{}{
+		"SHA-256": hex.EncodeToString(hash[:]),
+	}
+
+	return object{
+		"type":         "file",
+		"spec_version": "2.1",
+		"id":           deterministicID("file", map[string]interface{}{"hashes": hashes}),
+		"hashes":       hashes,
+		"size":         len(payload),
+	}
+}
+
+// eventDate returns the date of the event.
+func eventDate(e event.Event) time.Time {
+	t := time.Now()
+
+	e.Range(func(key, value interface{}) bool {
+		if key != "date" {
+			return true
+		}
+
+		if d, ok := value.(time.Time); ok {
+			t = d
+		}
+
+		return false
+	})
+
+	return t
+}
+
+// converter converts the events into STIX objects, created by the identity
+// of the sensor.
+type converter struct {
+	identity object
+
+	// indicators creates indicators of the observables
+	indicators bool
+
+	now func() time.Time
+}
+
+func newConverter(name string, indicators bool) *converter {
+	now := timestamp(time.Now())
+
+	return &converter{
+		identity: object{
+			"type":           "identity",
+			"spec_version":   "2.1",
+			"id":             deterministicID("identity", map[string]interface{}{"name": name}),
+			"created":        now,
+			"modified":       now,
+			"name":           name,
+			"identity_class": "system",
+		},
+		indicators: indicators,
+		now:        time.Now,
+	}
+}
+
+func (c *converter) indicator(name, pattern string, validFrom time.Time) object {
+	now := timestamp(c.now())
+
+	return object{
+		"type":            "indicator",
+		"spec_version":    "2.1",
+		"id":              deterministicID("indicator", map[string]interface{}{"pattern": pattern}),
+		"created_by_ref":  c.identity.ID(),
+		"created":         now,
+		"modified":        now,
+		"name":            name,
+		"indicator_types": []string{"anomalous-activity"},
+		"pattern":         pattern,
+		"pattern_type":    "stix",
+		"pattern_version": "2.1",
+		"valid_from":      timestamp(validFrom),
+	}
+}
+
+func (c *converter) relationship(typ, from, to string) object {
+	now := timestamp(c.now())
+
+	return object{
+		"type":              "relationship",
+		"spec_version":      "2.1",
+		"id":                randomID("relationship"),
+		"created_by_ref":    c.identity.ID(),
+		"created":           now,
+		"modified":          now,
+		"relationship_type": typ,
+		"source_ref":        from,
+		"target_ref":        to,
+	}
+}
+
+// Convert returns the observables (source address and payload) of the
+// event, the observed data referring to them and the indicators based on
+// the observed data. Events without observables aren't converted.
+func (c *converter) Convert(e event.Event) []object {
+	date := eventDate(e)
+
+	category := e.Get("category")
+
+	observables := []object{}
+	indicators := []object{}
+
+	if o, ok := addressObject(e.Get("source-ip")); ok {
+		observables = append(observables, o)
+
+		if c.indicators {
+			pattern := fmt.Sprintf("[%s:value = '%s']", o.Type(), escape(o["value"].(string)))
+			indicators = append(indicators, c.indicator(fmt.Sprintf("Honeytrap %s source %s", category, o["value"]), pattern, date))
+		}
+	}
+
+	if v := e.Get("payload"); v != "" {
+		o := fileObject(v)
+		observables = append(observables, o)
+
+		if c.indicators {
+			hash := o["hashes"].(map[string]interface{})["SHA-256"].(string)
+
+			pattern := fmt.Sprintf("[file:hashes.'SHA-256' = '%s']", hash)
+			indicators = append(indicators, c.indicator(fmt.Sprintf("Honeytrap %s payload %s", category, hash), pattern, date))
+		}
+	}
+
+	if len(observables) == 0 {
+		return nil
+	}
+
+	refs := []string{}
+	for _, o := range observables {
+		refs = append(refs, o.ID())
+	}
+
+	now := timestamp(c.now())
+
+	observed := object{
+		"type":            "observed-data",
+		"spec_version":    "2.1",
+		"id":              randomID("observed-data"),
+		"created_by_ref":  c.identity.ID(),
+		"created":         now,
+		"modified":        now,
+		"first_observed":  timestamp(date),
+		"last_observed":   timestamp(date),
+		"number_observed": 1,
+		"object_refs":     refs,
+	}
+
+	for _, key := range []string{"category", "type", "destination-port"} {
+		if v, ok := eventValue(e, key); ok {
+			observed["x_honeytrap_"+strings.Replace(key, "-", "_", -1)] = v
+		}
+	}
+
+	objects := append(observables, observed)
+
+	for _, indicator := range indicators {
+		objects = append(objects, indicator, c.relationship("based-on", indicator.ID(), observed.ID()))
+	}
+
+	return objects
+}
+
+// eventValue returns the value of the key of the event.
+func eventValue(e event.Event, key string) (interface{}, bool) {
+	var v interface{}
+
+	e.Range(func(k, value interface{}) bool {
+		if k != key {
+			return true
+		}
+
+		v = value
+		return false
+	})
+
+	return v, v != nil
+}
@@ -0,0 +1,347 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package taxii
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:channels:taxii")
+
+var (
+	_ = pushers.Register("taxii", New)
+)
+
+/*
+Configuration example:
+
+[channel.taxii]
+type="taxii"
+url="https://taxii.example.com/api1/"
+collection="91a7b528-80eb-42ed-a74d-c6fbd5a26116"
+username="honeytrap"
+password="{password}"
+*/
+
+// mediaType is the media type of the TAXII 2.1 requests and responses.
+const mediaType = "application/taxii+json;version=2.1"
+
+// Config defines a struct which holds configuration field values used by the
+// Backend to publish to a TAXII 2.1 collection.
+type Config struct {
+	// URL is the url of the api root
+	URL string `toml:"url"`
+
+	// Collection is the id of the collection the objects are added to
+	Collection string `toml:"collection"`
+
+	// Username and Password authenticate with basic authentication, Token
+	// authenticates with a bearer token
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	Token    string `toml:"token"`
+
+	// Identity is the name of the identity the objects are created by
+	Identity string `toml:"identity"`
+
+	// Indicators creates indicators of the source addresses and payloads,
+	// besides the observed data
+	Indicators bool `toml:"indicators"`
+
+	// BatchSize is the maximum number of events in an envelope
+	BatchSize int `toml:"batch-size"`
+
+	// FlushInterval is the maximum time events are buffered
+	FlushInterval config.Delay `toml:"flush-interval"`
+
+	// QueueSize is the number of events queued, events are dropped when
+	// the queue is full
+	QueueSize int `toml:"queue-size"`
+
+	Proxy string `toml:"proxy"`
+
+	Insecure bool `toml:"insecure"`
+
+	pushers.TLSConfig
+}
+
+// Backend converts the events into STIX 2.1 objects and publishes them to a
+// TAXII 2.1 collection.
+type Backend struct {
+	Config
+
+	client *http.Client
+
+	converter *converter
+
+	// seen are the ids of the observables and indicators published
+	seen map[string]struct{}
+
+	ch chan event.Event
+}
+
+// New returns a new instance of a Backend.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := &Backend{
+		Config: Config{
+			Identity:      "Honeytrap",
+			Indicators:    true,
+			BatchSize:     100,
+			FlushInterval: config.Delay(10 * time.Second),
+			QueueSize:     1000,
+		},
+		seen: map[string]struct{}{},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.URL == "" {
+		return nil, errors.New("Invalid Config: url can not be empty")
+	}
+
+	if c.Collection == "" {
+		return nil, errors.New("Invalid Config: collection can not be empty")
+	}
+
+	if c.BatchSize < 1 || c.QueueSize < 1 {
+		return nil, errors.New("Invalid Config: batch-size and queue-size should be at least 1")
+	}
+
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.ClientConfig(c.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           p,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: 30 * time.Second,
+	}
+
+	c.converter = newConverter(c.Identity, c.Indicators)
+
+	c.ch = make(chan event.Event, c.QueueSize)
+
+	go c.run()
+
+	return c, nil
+}
+
+// envelope returns the objects of the events, without the observables and
+// indicators already published, and the ids of the observables and
+// indicators within.
+func (b *Backend) envelope(events []event.Event) ([]object, []string) {
+	objects := []object{b.converter.identity}
+	published := []string{}
+
+	ids := map[string]struct{}{}
+
+	for _, e := range events {
+		for _, o := range b.converter.Convert(e) {
+			switch o.Type() {
+			case "observed-data", "relationship":
+				objects = append(objects, o)
+				continue
+			}
+
+			if _, ok := b.seen[o.ID()]; ok {
+				continue
+			} else if _, ok := ids[o.ID()]; ok {
+				continue
+			}
+
+			ids[o.ID()] = struct{}{}
+
+			objects = append(objects, o)
+			published = append(published, o.ID())
+		}
+	}
+
+	return objects, published
+}
+
+// status is the status resource returned when adding objects.
+type status struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	SuccessCount int    `json:"success_count"`
+	FailureCount int    `json:"failure_count"`
+	PendingCount int    `json:"pending_count"`
+	Failures     []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"failures"`
+}
+
+// add adds the objects to the collection.
+func (b *Backend) add(objects []object) (*status, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"objects": objects,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	u := fmt.Sprintf("%s/collections/%s/objects/", strings.TrimSuffix(b.URL, "/"), b.Collection)
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", mediaType)
+	req.Header.Set("Content-Type", mediaType)
+
+	if b.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.Token)
+	} else if b.Username != "" {
+		req.SetBasicAuth(b.Username, b.Password)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		// errors are returned as error message resource
+		res := struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+		}{}
+
+		if err := json.Unmarshal(data, &res); err != nil || res.Title == "" {
+			return nil, fmt.Errorf("Unexpected status %s", resp.Status)
+		}
+
+		return nil, fmt.Errorf("%s: %s", res.Title, res.Description)
+	}
+
+	s := status{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// publish publishes the objects of the events, the observables and
+// indicators are published once.
+func (b *Backend) publish(events []event.Event) error {
+	objects, published := b.envelope(events)
+
+	// only the identity
+	if len(objects) == 1 {
+		return nil
+	}
+
+	s, err := b.add(objects)
+	if err != nil {
+		return err
+	}
+
+	failed := map[string]struct{}{}
+	for _, f := range s.Failures {
+		log.Errorf("Error adding object %s: %s", f.ID, f.Message)
+
+		failed[f.ID] = struct{}{}
+	}
+
+	for _, id := range published {
+		if _, ok := failed[id]; ok {
+			continue
+		}
+
+		b.seen[id] = struct{}{}
+	}
+
+	log.Debugf("Published %d objects (status %s %s, %d failed)", len(objects), s.ID, s.Status, s.FailureCount)
+
+	return nil
+}
+
+func (b *Backend) run() {
+	ticker := time.NewTicker(b.FlushInterval.Duration())
+	defer ticker.Stop()
+
+	batch := []event.Event{}
+
+	for {
+		select {
+		case e := <-b.ch:
+			batch = append(batch, e)
+			if len(batch) < b.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		if err := b.publish(batch); err != nil {
+			log.Errorf("Error publishing to %s: %s", b.Collection, err.Error())
+			pushers.DeliveryFailed("taxii", len(batch))
+		}
+
+		batch = []event.Event{}
+	}
+}
+
+// Send queues the event, the event is dropped when the collection can't
+// keep up.
+func (b *Backend) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	select {
+	case b.ch <- e:
+	default:
+		log.Warningf("Queue full, event dropped")
+		pushers.DeliveryFailed("taxii", 1)
+	}
+}
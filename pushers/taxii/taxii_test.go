@@ -0,0 +1,184 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package taxii
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+func TestDeterministicID(t *testing.T) {
+	a, _ := addressObject("192.0.2.1")
+	b, _ := addressObject("192.0.2.1")
+
+	if a.ID() != b.ID() {
+		t.Errorf("Expected the same id for the same address, got %s and %s", a.ID(), b.ID())
+	}
+
+	if !strings.HasPrefix(a.ID(), "ipv4-addr--") || a.ID()[len("ipv4-addr--")+14] != '5' {
+		t.Errorf("Expected a version 5 uuid, got %s", a.ID())
+	}
+
+	if c, _ := addressObject("2001:db8::1"); c.Type() != "ipv6-addr" {
+		t.Errorf("Expected ipv6-addr, got %s", c.Type())
+	}
+
+	if v := canonical(map[string]interface{}{"value": "<a&b>"}); v != `{"value":"<a&b>"}` {
+		t.Errorf("Unexpected canonical serialization %s", v)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	c := newConverter("Honeytrap", true)
+
+	objects := c.Convert(harness.FixtureEvent("telnet-payload"))
+
+	types := map[string]int{}
+	for _, o := range objects {
+		types[o.Type()]++
+	}
+
+	expected := map[string]int{"ipv4-addr": 1, "file": 1, "observed-data": 1, "indicator": 2, "relationship": 2}
+	for k, v := range expected {
+		if types[k] != v {
+			t.Errorf("Expected %d %s objects, got %v", v, k, types)
+		}
+	}
+
+	for _, o := range objects {
+		if o.Type() != "observed-data" {
+			continue
+		}
+
+		if o["first_observed"] != "2019-06-01T12:00:00.000Z" {
+			t.Errorf("Expected the date of the event, got %v", o["first_observed"])
+		}
+
+		if refs := o["object_refs"].([]string); len(refs) != 2 {
+			t.Errorf("Expected 2 object refs, got %v", refs)
+		}
+	}
+
+	if objects := c.Convert(event.New(event.Category("heartbeat"))); objects != nil {
+		t.Errorf("Expected no objects without observables, got %v", objects)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if v := escape(`it's \ here`); v != `it\'s \\ here` {
+		t.Errorf("Unexpected escaped value %s", v)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	var m sync.Mutex
+
+	envelopes := [][]map[string]interface{}{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, _ := r.BasicAuth(); username != "honeytrap" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != "/api1/collections/c1/objects/" || r.Header.Get("Content-Type") != mediaType {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		envelope := struct {
+			Objects []map[string]interface{} `json:"objects"`
+		}{}
+
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			t.Error(err)
+		}
+
+		m.Lock()
+		envelopes = append(envelopes, envelope.Objects)
+		m.Unlock()
+
+		w.Header().Set("Content-Type", mediaType)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id": "s1", "status": "complete", "success_count": 1}`))
+	}))
+	defer ts.Close()
+
+	b := &Backend{
+		Config: Config{
+			URL:        ts.URL + "/api1/",
+			Collection: "c1",
+			Username:   "honeytrap",
+			Password:   "secret",
+		},
+		client:    ts.Client(),
+		converter: newConverter("Honeytrap", true),
+		seen:      map[string]struct{}{},
+	}
+
+	e := event.New(
+		event.Category("ssh"),
+		event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}),
+	)
+
+	if err := b.publish([]event.Event{e, e}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the observables and indicators are published once
+	if err := b.publish([]event.Event{e}); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(objects []map[string]interface{}, typ string) int {
+		n := 0
+		for _, o := range objects {
+			if o["type"] == typ {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	if len(envelopes) != 2 {
+		t.Fatalf("Expected 2 envelopes, got %d", len(envelopes))
+	}
+
+	if count(envelopes[0], "ipv4-addr") != 1 || count(envelopes[0], "indicator") != 1 || count(envelopes[0], "observed-data") != 2 {
+		t.Errorf("Unexpected first envelope: %v", envelopes[0])
+	}
+
+	if count(envelopes[1], "ipv4-addr") != 0 || count(envelopes[1], "indicator") != 0 || count(envelopes[1], "observed-data") != 1 {
+		t.Errorf("Unexpected second envelope: %v", envelopes[1])
+	}
+
+	if count(envelopes[1], "identity") != 1 {
+		t.Errorf("Expected the identity in every envelope")
+	}
+}
+
+func TestNewWithoutCollection(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Errorf("Expected error without url and collection")
+	}
+}
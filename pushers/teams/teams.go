@@ -0,0 +1,191 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package teams
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/proxy"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/webhook"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:channels:teams")
+
+var (
+	_ = pushers.Register("teams", New)
+)
+
+/*
+Configuration example:
+
+[channel.teams]
+type="teams"
+webhook_url="https://example.webhook.office.com/webhookb2/{id}"
+title="Honeytrap"
+*/
+
+// maxText is the maximum length of the text of a card, the payload of
+// incoming webhooks is limited to 28KB.
+const maxText = 20000
+
+// Config defines a struct which holds configuration field values used by the
+// Backend for its message delivery to a Microsoft Teams incoming webhook.
+type Config struct {
+	WebhookURL string `toml:"webhook_url"`
+
+	// Title is the title of the cards
+	Title string `toml:"title"`
+
+	// ThemeColor is the hex color of the cards
+	ThemeColor string `toml:"theme_color"`
+
+	// Template is the text/template the events are formatted with
+	Template string `toml:"template"`
+
+	// Interval is the interval the batched events are posted
+	Interval config.Delay `toml:"interval"`
+
+	// MaxEvents is the maximum number of events posted an interval, the
+	// other events are summarized
+	MaxEvents int `toml:"max-events"`
+
+	Proxy string `toml:"proxy"`
+}
+
+// Backend posts the events in batches to a Microsoft Teams webhook.
+type Backend struct {
+	Config
+
+	client   *http.Client
+	template *template.Template
+	batcher  *webhook.Batcher
+}
+
+// New returns a new instance of a Backend.
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := &Backend{
+		Config: Config{
+			Title:      "Honeytrap",
+			ThemeColor: "F5A623",
+			Template:   webhook.DefaultTemplate,
+			Interval:   config.Delay(5 * time.Second),
+			MaxEvents:  20,
+		},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.WebhookURL == "" {
+		return nil, errors.New("Invalid Config: webhook_url can not be empty")
+	}
+
+	if c.MaxEvents < 1 {
+		return nil, errors.New("Invalid Config: max-events should be at least 1")
+	}
+
+	t, err := webhook.NewTemplate(c.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	c.template = t
+
+	p, err := proxy.New(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy: p,
+		},
+		Timeout: 20 * time.Second,
+	}
+
+	c.batcher = webhook.NewBatcher("teams", c.Interval.Duration(), c.MaxEvents, c.post)
+
+	return c, nil
+}
+
+// card is a message card of an incoming webhook.
+type card struct {
+	Type       string `json:"@type"`
+	Context    string `json:"@context"`
+	Summary    string `json:"summary"`
+	Title      string `json:"title"`
+	ThemeColor string `json:"themeColor,omitempty"`
+	Text       string `json:"text"`
+}
+
+// escape escapes the markdown of the text of a card.
+var escape = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `#`, `\#`, `<`, `&lt;`, `>`, `&gt;`)
+
+// post posts the events, split in cards within the maximum text length.
+func (b *Backend) post(lines []string, summarized int) error {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = escape.Replace(line)
+	}
+
+	if summarized > 0 {
+		escaped = append(escaped, fmt.Sprintf("... and %d more events", summarized))
+	}
+
+	summary := fmt.Sprintf("%d events", len(lines)+summarized)
+
+	// markdown paragraphs are separated by an empty line
+	for _, text := range webhook.Split(escaped, maxText, "\n\n") {
+		if err := webhook.Post(b.client, b.WebhookURL, card{
+			Type:       "MessageCard",
+			Context:    "https://schema.org/extensions",
+			Summary:    summary,
+			Title:      b.Title,
+			ThemeColor: b.ThemeColor,
+			Text:       text,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Send formats the event and queues it for the next batch.
+func (b *Backend) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	line, err := webhook.Render(b.template, e)
+	if err != nil {
+		log.Errorf("Error formatting event: %s", err.Error())
+		pushers.DeliveryFailed("teams", 1)
+		return
+	}
+
+	b.batcher.Add(line)
+}
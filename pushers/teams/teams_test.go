@@ -0,0 +1,58 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package teams
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+func TestPost(t *testing.T) {
+	hook := harness.NewHTTPBackend(nil)
+	defer hook.Close()
+
+	b := &Backend{
+		Config: Config{
+			WebhookURL: hook.URL,
+			Title:      "Honeytrap",
+		},
+		client: http.DefaultClient,
+	}
+
+	if err := b.post([]string{"http GET /admin_login.php", "ssh <root>"}, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := hook.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(requests))
+	}
+
+	c := card{}
+	if err := json.Unmarshal(requests[0].Body, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Type != "MessageCard" || c.Summary != "7 events" {
+		t.Errorf("Unexpected card %+v", c)
+	}
+
+	expected := "http GET /admin\\_login.php\n\nssh &lt;root&gt;\n\n... and 5 more events"
+	if c.Text != expected {
+		t.Errorf("Expected text %q, got %q", expected, c.Text)
+	}
+}
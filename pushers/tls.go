@@ -0,0 +1,87 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig configures the client certificate and the verification of the
+// server certificate, for channels that connect over tls.
+type TLSConfig struct {
+	// Cert and Key are the files of the client certificate
+	Cert string `toml:"tls_cert"`
+	Key  string `toml:"tls_key"`
+
+	// CA is the file with the certificate authorities the server
+	// certificate is verified with, instead of the system roots
+	CA string `toml:"tls_ca"`
+
+	// ServerName is the name the server certificate should be valid for,
+	// instead of the host that is connected to
+	ServerName string `toml:"tls_server_name"`
+}
+
+// TLSConfigFrom returns the tls configuration of channels that decode their
+// configuration themselves.
+func TLSConfigFrom(data map[string]interface{}) TLSConfig {
+	c := TLSConfig{}
+	c.Cert, _ = data["tls_cert"].(string)
+	c.Key, _ = data["tls_key"].(string)
+	c.CA, _ = data["tls_ca"].(string)
+	c.ServerName, _ = data["tls_server_name"].(string)
+	return c
+}
+
+// Enabled returns true if any of the tls options has been set.
+func (c TLSConfig) Enabled() bool {
+	return c.Cert != "" || c.Key != "" || c.CA != "" || c.ServerName != ""
+}
+
+// ClientConfig returns the tls client configuration with the certificates
+// loaded.
+func (c TLSConfig) ClientConfig(insecure bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+		ServerName:         c.ServerName,
+	}
+
+	if c.Cert != "" || c.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client certificate: %s", err.Error())
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CA != "" {
+		data, err := ioutil.ReadFile(c.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in %s", c.CA)
+		}
+
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// writeCertificate writes a self signed certificate and its key.
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "honeytrap"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return certPath, keyPath
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	certPath, keyPath := writeCertificate(t, dir)
+
+	c := struct {
+		URL string `toml:"url"`
+		TLSConfig
+	}{}
+
+	data := `
+url = "https://127.0.0.1:9200/"
+tls_cert = "` + certPath + `"
+tls_key = "` + keyPath + `"
+tls_ca = "` + certPath + `"
+tls_server_name = "ingest.internal"
+`
+
+	if _, err := toml.Decode(data, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Enabled() {
+		t.Fatalf("Expected tls options to be decoded")
+	}
+
+	config, err := c.ClientConfig(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Certificates) != 1 || config.RootCAs == nil || config.ServerName != "ingest.internal" {
+		t.Errorf("Unexpected tls configuration %+v", config)
+	}
+
+	if _, err := (TLSConfig{CA: keyPath}).ClientConfig(false); err == nil {
+		t.Errorf("Expected error for ca without certificates")
+	}
+
+	if (TLSConfigFrom(map[string]interface{}{"tls_ca": certPath})) != (TLSConfig{CA: certPath}) {
+		t.Errorf("Expected tls ca from configuration")
+	}
+}
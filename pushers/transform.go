@@ -0,0 +1,255 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// Transform defines the changes made to the events before delivery to a
+// channel, for consumers that expect differently shaped events. The
+// changes are applied in order: fields are set, renamed, mapped and
+// dropped. Transformed events no longer verify against their signature,
+// when signing is enabled.
+type Transform struct {
+	// Set sets the fields to the result of the Go templates, the fields of
+	// the event are available as {{index . "source-ip"}}
+	Set map[string]string `toml:"set"`
+
+	// Rename renames the fields, from the current name to the new name
+	Rename map[string]string `toml:"rename"`
+
+	// Mapping maps the field names to a schema, ecs maps to the Elastic
+	// Common Schema
+	Mapping string `toml:"mapping"`
+
+	// Drop drops the fields, field names support wildcards (eg. *.password)
+	Drop []string `toml:"drop"`
+}
+
+// Empty returns true if the transform has no changes.
+func (t Transform) Empty() bool {
+	return len(t.Set) == 0 && len(t.Rename) == 0 && t.Mapping == "" && len(t.Drop) == 0
+}
+
+// fieldMapping maps field names to the names of a schema, with the fields
+// added to every event.
+type fieldMapping struct {
+	Fields map[string]string
+	Static map[string]interface{}
+}
+
+// name returns the mapped name of the field, names of the mapping support
+// wildcards.
+func (m fieldMapping) name(key string) (string, bool) {
+	if name, ok := m.Fields[key]; ok {
+		return name, true
+	}
+
+	for p, name := range m.Fields {
+		if !strings.Contains(p, "*") {
+			continue
+		}
+
+		if ok, _ := path.Match(p, key); ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+var mappings = map[string]fieldMapping{
+	"ecs": {
+		Fields: map[string]string{
+			"date":                   "@timestamp",
+			"type":                   "event.action",
+			"category":               "event.dataset",
+			"sensor":                 "event.provider",
+			"service":                "service.name",
+			"protocol":               "network.transport",
+			"source-ip":              "source.ip",
+			"source-port":            "source.port",
+			"source-mac":             "source.mac",
+			"destination-ip":         "destination.ip",
+			"destination-port":       "destination.port",
+			"destination-mac":        "destination.mac",
+			"http.method":            "http.request.method",
+			"http.url":               "url.original",
+			"http.host":              "url.domain",
+			"http.header.user-agent": "user_agent.original",
+			"*.username":             "user.name",
+		},
+		Static: map[string]interface{}{
+			"ecs.version":      "1.0.0",
+			"event.kind":       "event",
+			"observer.type":    "honeypot",
+			"observer.product": "honeytrap",
+		},
+	},
+}
+
+type transformChannel struct {
+	Channel
+
+	Transform Transform
+
+	templates map[string]*template.Template
+	mapping   *fieldMapping
+}
+
+// templateData returns the fields of the event as strings, for use in the
+// templates.
+func templateData(e event.Event) map[string]string {
+	data := map[string]string{}
+
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+
+		switch v := value.(type) {
+		case string:
+			data[k] = v
+		case time.Time:
+			data[k] = v.Format(time.RFC3339)
+		default:
+			data[k] = fmt.Sprint(v)
+		}
+
+		return true
+	})
+
+	return data
+}
+
+// apply returns a copy of the event with the transform applied, the
+// original event is left untouched as it is shared by all channels.
+func (tc transformChannel) apply(e event.Event) event.Event {
+	c := e.Copy()
+
+	if len(tc.templates) > 0 {
+		data := templateData(e)
+
+		for k, t := range tc.templates {
+			buf := &bytes.Buffer{}
+			if err := t.Execute(buf, data); err != nil {
+				// the field is left as is
+				continue
+			}
+
+			c.Store(k, buf.String())
+		}
+	}
+
+	rename := func(from, to string) {
+		var value interface{}
+
+		c.Range(func(key, v interface{}) bool {
+			if key != from {
+				return true
+			}
+
+			value = v
+			return false
+		})
+
+		if value == nil {
+			return
+		}
+
+		c.Delete(from)
+		c.Store(to, value)
+	}
+
+	for from, to := range tc.Transform.Rename {
+		rename(from, to)
+	}
+
+	if tc.mapping != nil {
+		renames := map[string]string{}
+
+		c.Range(func(key, _ interface{}) bool {
+			if k, ok := key.(string); !ok {
+			} else if name, ok := tc.mapping.name(k); ok {
+				renames[k] = name
+			}
+
+			return true
+		})
+
+		for from, to := range renames {
+			rename(from, to)
+		}
+
+		for k, v := range tc.mapping.Static {
+			c.Store(k, v)
+		}
+	}
+
+	if len(tc.Transform.Drop) > 0 {
+		c.Range(func(key, _ interface{}) bool {
+			if k, ok := key.(string); ok && matchField(tc.Transform.Drop, k) {
+				c.Delete(k)
+			}
+
+			return true
+		})
+	}
+
+	return c
+}
+
+// Send delivers the transformed event to the channel.
+func (tc transformChannel) Send(e event.Event) {
+	tc.Channel.Send(tc.apply(e))
+}
+
+// TransformChannel returns a Channel that applies the transform to the
+// events before delivery.
+func TransformChannel(channel Channel, transform Transform) (Channel, error) {
+	tc := transformChannel{
+		Channel:   channel,
+		Transform: transform,
+		templates: map[string]*template.Template{},
+	}
+
+	for k, s := range transform.Set {
+		t, err := template.New(k).Option("missingkey=zero").Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template of field %s: %s", k, err.Error())
+		}
+
+		tc.templates[k] = t
+	}
+
+	if transform.Mapping != "" {
+		m, ok := mappings[transform.Mapping]
+		if !ok {
+			return nil, fmt.Errorf("Unknown mapping %s", transform.Mapping)
+		}
+
+		tc.mapping = &m
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func transformEvent() event.Event {
+	return event.New(
+		event.Custom("date", time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)),
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+		event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 51234}),
+		event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "123456"),
+	)
+}
+
+func TestTransformChannel(t *testing.T) {
+	rc := &recordChannel{}
+
+	c, err := TransformChannel(rc, Transform{
+		Set: map[string]string{
+			"summary": `{{.category}} login {{index . "ssh.username"}} from {{index . "source-ip"}} {{.missing}}`,
+		},
+		Rename: map[string]string{
+			"source-ip": "src",
+			"missing":   "other",
+		},
+		Drop: []string{"*.password", "destination-*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := transformEvent()
+	c.Send(e)
+
+	if len(rc.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(rc.events))
+	}
+
+	te := rc.events[0]
+
+	if v := te.Get("summary"); v != "ssh login root from 198.51.100.7 " {
+		t.Errorf("Expected summary from template, got %q", v)
+	}
+
+	if v := te.Get("src"); v != "198.51.100.7" || te.Has("source-ip") {
+		t.Errorf("Expected source-ip renamed to src, got %s", v)
+	}
+
+	if te.Has("other") {
+		t.Errorf("Expected missing fields not to be renamed")
+	}
+
+	for _, k := range []string{"ssh.password", "destination-ip", "destination-port"} {
+		if te.Has(k) {
+			t.Errorf("Expected %s to be dropped", k)
+		}
+	}
+
+	if !e.Has("source-ip") || !e.Has("ssh.password") {
+		t.Errorf("Expected the original event to be untouched")
+	}
+}
+
+func TestTransformChannelECS(t *testing.T) {
+	rc := &recordChannel{}
+
+	c, err := TransformChannel(rc, Transform{
+		Mapping: "ecs",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Send(transformEvent())
+
+	te := rc.events[0]
+
+	for k, expected := range map[string]string{
+		"source.ip":      "198.51.100.7",
+		"event.dataset":  "ssh",
+		"event.action":   "password-authentication",
+		"user.name":      "root",
+		"ssh.password":   "123456",
+		"observer.type":  "honeypot",
+		"destination.ip": "192.0.2.1",
+	} {
+		if v := te.Get(k); v != expected {
+			t.Errorf("Expected %s to be %s, got %s", k, expected, v)
+		}
+	}
+
+	var port interface{}
+	var date interface{}
+
+	te.Range(func(k, v interface{}) bool {
+		switch k {
+		case "destination.port":
+			port = v
+		case "@timestamp":
+			date = v
+		}
+
+		return true
+	})
+
+	if port != 22 {
+		t.Errorf("Expected destination.port to keep its type, got %#v", port)
+	}
+
+	if _, ok := date.(time.Time); !ok {
+		t.Errorf("Expected @timestamp to be the date, got %#v", date)
+	}
+
+	if te.Has("source-ip") || te.Has("date") {
+		t.Errorf("Expected the fields to be renamed")
+	}
+}
+
+func TestTransformChannelErrors(t *testing.T) {
+	if _, err := TransformChannel(&recordChannel{}, Transform{Mapping: "cef"}); err == nil {
+		t.Errorf("Expected unknown mapping error")
+	}
+
+	if _, err := TransformChannel(&recordChannel{}, Transform{Set: map[string]string{"x": "{{.a"}}); err == nil {
+		t.Errorf("Expected template error")
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package webhook contains the templating and batching of the channels
+// posting messages to chat webhooks.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:channels:webhook")
+
+// DefaultTemplate is the default format of the events.
+const DefaultTemplate = `{{.category}} {{.type}}{{with index . "source-ip"}} from {{.}}{{end}}{{with index . "destination-port"}} on port {{.}}{{end}}{{with .message}}: {{.}}{{end}}`
+
+// NewTemplate parses the template of the events, the fields of the event
+// are accessible by name, eg. {{.category}} or {{index . "source-ip"}}.
+func NewTemplate(text string) (*template.Template, error) {
+	return template.New("event").Option("missingkey=zero").Parse(text)
+}
+
+// Render formats the event with the template.
+func Render(t *template.Template, e event.Event) (string, error) {
+	fields := map[string]string{}
+
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+
+		switch v := value.(type) {
+		case string:
+			fields[k] = v
+		case time.Time:
+			fields[k] = v.UTC().Format(time.RFC3339)
+		default:
+			fields[k] = fmt.Sprint(v)
+		}
+
+		return true
+	})
+
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, fields); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// maxRetries is the number of times a rate limited message is retried.
+const maxRetries = 3
+
+// RateLimitError is returned when the webhook is rate limited.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limited, retry after %s", e.RetryAfter)
+}
+
+// retryAfter returns the time to wait of the rate limited response, the
+// Retry-After header or the retry_after field (discord) in seconds.
+func retryAfter(resp *http.Response, body []byte) time.Duration {
+	if v, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+		return time.Duration(v * float64(time.Second))
+	}
+
+	v := struct {
+		RetryAfter float64 `json:"retry_after"`
+	}{}
+
+	if err := json.Unmarshal(body, &v); err == nil && v.RetryAfter > 0 {
+		return time.Duration(v.RetryAfter * float64(time.Second))
+	}
+
+	return time.Second
+}
+
+// Post posts the message as json to the url, rate limited messages are
+// retried after the time the webhook asks for.
+func Post(client *http.Client, url string, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		err := post(client, url, data)
+
+		rle, ok := err.(RateLimitError)
+		if !ok || i == maxRetries {
+			return err
+		}
+
+		log.Warningf("Webhook rate limited, retrying after %s", rle.RetryAfter)
+
+		time.Sleep(rle.RetryAfter)
+	}
+}
+
+func post(client *http.Client, url string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return RateLimitError{RetryAfter: retryAfter(resp, body)}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("Unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// teams returns errors with status 200
+	if bytes.HasPrefix(body, []byte("Webhook message delivery failed")) {
+		return fmt.Errorf("%s", body)
+	}
+
+	return nil
+}
+
+// Split splits the lines in chunks of at most max characters, longer lines
+// are truncated.
+func Split(lines []string, max int, separator string) []string {
+	chunks := []string{}
+
+	chunk := ""
+	for _, line := range lines {
+		if len(line) > max {
+			line = line[:max-3] + "..."
+		}
+
+		if chunk != "" && len(chunk)+len(separator)+len(line) > max {
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+
+		if chunk != "" {
+			chunk += separator
+		}
+
+		chunk += line
+	}
+
+	if chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// Batcher batches the formatted events, posting them every interval. When
+// more events arrive within an interval than fit in a batch, the remaining
+// events are summarized, so high volumes of events don't exceed the rate
+// limits of the webhooks.
+type Batcher struct {
+	// Interval is the interval the batches are posted
+	Interval time.Duration
+
+	// Max is the maximum number of events in a batch
+	Max int
+
+	ch chan string
+
+	// post posts a batch, the number of summarized events is passed
+	post func(lines []string, summarized int) error
+
+	name string
+}
+
+// NewBatcher returns a batcher posting the batches of the channel with the
+// name.
+func NewBatcher(name string, interval time.Duration, max int, post func([]string, int) error) *Batcher {
+	b := &Batcher{
+		Interval: interval,
+		Max:      max,
+		ch:       make(chan string, 100),
+		post:     post,
+		name:     name,
+	}
+
+	go b.run()
+
+	return b
+}
+
+// Add queues the line, it is dropped when the queue is full.
+func (b *Batcher) Add(line string) {
+	select {
+	case b.ch <- line:
+	default:
+		log.Warningf("Queue of %s full, event dropped", b.name)
+		pushers.DeliveryFailed(b.name, 1)
+	}
+}
+
+func (b *Batcher) run() {
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+
+	lines := []string{}
+	summarized := 0
+
+	for {
+		select {
+		case line := <-b.ch:
+			if len(lines) < b.Max {
+				lines = append(lines, line)
+			} else {
+				summarized++
+			}
+
+			continue
+		case <-ticker.C:
+		}
+
+		if len(lines) == 0 {
+			continue
+		}
+
+		if err := b.post(lines, summarized); err != nil {
+			log.Errorf("Error posting to %s: %s", b.name, err.Error())
+			pushers.DeliveryFailed(b.name, len(lines)+summarized)
+		}
+
+		lines = []string{}
+		summarized = 0
+	}
+}
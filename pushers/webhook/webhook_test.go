@@ -0,0 +1,143 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+func TestRender(t *testing.T) {
+	tmpl, err := NewTemplate(DefaultTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Render(tmpl, harness.FixtureEvent("ssh-password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(s, "ssh password-authentication from 198.51.100.7") {
+		t.Errorf("Unexpected rendered event %q", s)
+	}
+
+	// missing fields are empty
+	s, err = Render(tmpl, event.New(event.Category("heartbeat")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s != "heartbeat " {
+		t.Errorf("Unexpected rendered event %q", s)
+	}
+
+	if _, err := NewTemplate("{{.category"); err == nil {
+		t.Errorf("Expected error for invalid template")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	chunks := Split([]string{"aaaa", "bbbb", "cccc", strings.Repeat("d", 20)}, 10, "\n")
+
+	expected := []string{"aaaa\nbbbb", "cccc", "ddddddd..."}
+	if fmt.Sprint(chunks) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q", expected, chunks)
+	}
+}
+
+func TestPostRateLimited(t *testing.T) {
+	requests := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		switch requests {
+		case 1:
+			w.Header().Set("Retry-After", "0.01")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			// discord returns the time to wait in the body
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"message": "You are being rate limited.", "retry_after": 0.01, "global": false}`)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	if err := Post(ts.Client(), ts.URL, map[string]string{"content": "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+}
+
+func TestPostFailed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Webhook message delivery failed with error: Microsoft Teams endpoint returned HTTP error 413")
+	}))
+	defer ts.Close()
+
+	if err := Post(ts.Client(), ts.URL, map[string]string{}); err == nil {
+		t.Errorf("Expected error for failed delivery")
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	var m sync.Mutex
+
+	batches := [][]string{}
+	summaries := []int{}
+
+	done := make(chan struct{}, 1)
+
+	b := NewBatcher("test", 50*time.Millisecond, 2, func(lines []string, summarized int) error {
+		m.Lock()
+		defer m.Unlock()
+
+		batches = append(batches, lines)
+		summaries = append(summaries, summarized)
+
+		done <- struct{}{}
+		return nil
+	})
+
+	for i := 0; i < 5; i++ {
+		b.Add(fmt.Sprintf("event %d", i))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected batch to be posted")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if len(batches[0]) != 2 || summaries[0] != 3 {
+		t.Errorf("Expected 2 events and 3 summarized, got %v and %d", batches[0], summaries[0])
+	}
+}
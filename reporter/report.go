@@ -0,0 +1,461 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package reporter generates periodic summaries of the events seen by
+// honeytrap, the summaries are written as html to the data directory and
+// delivered as event to the configured channels.
+package reporter
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/trends"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:reporter")
+
+const (
+	// maxDigests is the number of payload digests remembered to tell new
+	// payloads apart, the least recently seen digests are forgotten first.
+	maxDigests = 100000
+
+	// maxSample is the length payload samples are truncated to.
+	maxSample = 64
+)
+
+var (
+	SensorReport = event.Sensor("report")
+
+	EventCategoryReport = event.Category("report")
+)
+
+// Count is a value with the number of occurrences.
+type Count struct {
+	Value string
+	Count int
+}
+
+// Summary contains the aggregated events of a single period.
+type Summary struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+
+	Events int
+
+	TopAttackers    []Count
+	TopCredentials  []Count
+	Services        []Count
+	Severities      []Count
+	NewPayloads     []Count
+	UniqueAttackers int
+
+	// Trend contains the number of events per day of the last days
+	Trend []Count
+}
+
+// String returns a plain text version of the summary.
+func (s *Summary) String() string {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "Honeytrap report %s (%s - %s)\n", s.Name, s.Start.Format(time.RFC3339), s.End.Format(time.RFC3339))
+	fmt.Fprintf(b, "%d events from %d unique sources\n", s.Events, s.UniqueAttackers)
+
+	section := func(title string, counts []Count) {
+		if len(counts) == 0 {
+			return
+		}
+
+		fmt.Fprintf(b, "\n%s:\n", title)
+		for _, c := range counts {
+			fmt.Fprintf(b, "  %6d  %s\n", c.Count, c.Value)
+		}
+	}
+
+	section("Top attackers", s.TopAttackers)
+	section("Services", s.Services)
+	section("Severities", s.Severities)
+	section("Top credentials", s.TopCredentials)
+	section("New payloads", s.NewPayloads)
+	section("Events per day", s.Trend)
+
+	return b.String()
+}
+
+// Reporter aggregates events and generates a summary every interval.
+type Reporter struct {
+	name string
+
+	Interval config.Delay `toml:"interval"`
+
+	// Top is the number of entries in the top lists, and the number of new
+	// payloads sampled per period
+	Top int `toml:"top"`
+
+	// Directory is the directory the html reports will be written to,
+	// relative to the data directory.
+	Directory string `toml:"directory"`
+
+	// Channels are the names of the channels the report will be delivered to
+	Channels []string `toml:"channels"`
+
+	Format string `toml:"format"`
+
+	// TrendDays is the number of days in the trend of the report
+	TrendDays int `toml:"trend-days"`
+
+	dataDir string
+
+	trends *trends.Store
+
+	channels []pushers.Channel
+
+	m sync.Mutex
+
+	start      time.Time
+	events     int
+	attackers  map[string]int
+	creds      map[string]int
+	services   map[string]int
+	severities map[string]int
+	payloads   map[string]int
+	newSamples map[string]string
+
+	seen    map[string]*list.Element
+	digests *list.List
+}
+
+// New returns a new Reporter.
+func New(name string, options ...func(*Reporter) error) (*Reporter, error) {
+	r := &Reporter{
+		name:      name,
+		Interval:  config.Delay(24 * time.Hour),
+		Top:       10,
+		Directory: "reports",
+		Format:    "html",
+		TrendDays: 14,
+		seen:      map[string]*list.Element{},
+		digests:   list.New(),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Format != "html" {
+		return nil, fmt.Errorf("Unsupported report format %s, only html is supported", r.Format)
+	}
+
+	if r.Interval.Duration() <= 0 {
+		return nil, fmt.Errorf("Report interval should be positive")
+	}
+
+	r.reset(time.Now())
+
+	return r, nil
+}
+
+// WithChannel adds a channel the reports will be delivered to.
+func WithChannel(channel pushers.Channel) func(*Reporter) error {
+	return func(r *Reporter) error {
+		r.channels = append(r.channels, channel)
+		return nil
+	}
+}
+
+// WithTrends sets the trend store the trend of the report is read from.
+func WithTrends(s *trends.Store) func(*Reporter) error {
+	return func(r *Reporter) error {
+		r.trends = s
+		return nil
+	}
+}
+
+// WithDataDir sets the data directory the reports will be written to.
+func WithDataDir(dataDir string) func(*Reporter) error {
+	return func(r *Reporter) error {
+		r.dataDir = dataDir
+		return nil
+	}
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the report configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Reporter) error {
+	return func(r *Reporter) error {
+		return decoder.PrimitiveDecode(c, r)
+	}
+}
+
+func (r *Reporter) reset(start time.Time) {
+	r.start = start
+	r.events = 0
+	r.attackers = map[string]int{}
+	r.creds = map[string]int{}
+	r.services = map[string]int{}
+	r.severities = map[string]int{}
+	r.payloads = map[string]int{}
+	r.newSamples = map[string]string{}
+}
+
+// Send aggregates the event into the current period.
+func (r *Reporter) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" || e.Get("sensor") == "report" {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.events++
+
+	if v := e.Get("source-ip"); v != "" {
+		r.attackers[v]++
+	}
+
+	if v := e.Get("category"); v != "" {
+		r.services[v]++
+	}
+
+	if v := e.Get("severity"); v != "" {
+		r.severities[v]++
+	}
+
+	username, password := "", ""
+	e.Range(func(key, value interface{}) bool {
+		k, _ := key.(string)
+		v, _ := value.(string)
+
+		if strings.HasSuffix(k, ".username") {
+			username = v
+		} else if strings.HasSuffix(k, ".password") {
+			password = v
+		}
+
+		return true
+	})
+
+	if username != "" || password != "" {
+		r.creds[username+":"+password]++
+	}
+
+	if v := e.Get("payload"); v != "" {
+		hash := sha256.Sum256([]byte(v))
+		digest := hex.EncodeToString(hash[:])
+
+		if _, ok := r.newSamples[digest]; ok {
+			r.payloads[digest]++
+		} else if r.isNew(digest) && len(r.newSamples) < r.Top {
+			if len(v) > maxSample {
+				v = v[:maxSample] + "..."
+			}
+
+			r.remember(digest)
+
+			r.newSamples[digest] = v
+			r.payloads[digest]++
+		}
+	}
+}
+
+// isNew returns whether the digest hasn't been seen before, seen digests are
+// marked as recently used.
+func (r *Reporter) isNew(digest string) bool {
+	elem, ok := r.seen[digest]
+	if !ok {
+		return true
+	}
+
+	r.digests.MoveToFront(elem)
+	return false
+}
+
+// remember adds the digest to the seen digests, forgetting the least recently
+// seen digest when there are too many.
+func (r *Reporter) remember(digest string) {
+	r.seen[digest] = r.digests.PushFront(digest)
+
+	if r.digests.Len() <= maxDigests {
+		return
+	}
+
+	elem := r.digests.Back()
+	r.digests.Remove(elem)
+	delete(r.seen, elem.Value.(string))
+}
+
+func top(m map[string]int, n int) []Count {
+	counts := make([]Count, 0, len(m))
+	for k, v := range m {
+		counts = append(counts, Count{Value: k, Count: v})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+
+		return counts[i].Value < counts[j].Value
+	})
+
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+
+	return counts
+}
+
+// Summarize returns the summary of the current period and starts a new period.
+func (r *Reporter) Summarize(now time.Time) *Summary {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	payloads := top(r.payloads, r.Top)
+	for i := range payloads {
+		sample := r.newSamples[payloads[i].Value]
+		payloads[i].Value = fmt.Sprintf("%s %q", payloads[i].Value[:12], sample)
+	}
+
+	s := &Summary{
+		Name:            r.name,
+		Start:           r.start,
+		End:             now,
+		Events:          r.events,
+		UniqueAttackers: len(r.attackers),
+		TopAttackers:    top(r.attackers, r.Top),
+		TopCredentials:  top(r.creds, r.Top),
+		Services:        top(r.services, 0),
+		Severities:      top(r.severities, 0),
+		NewPayloads:     payloads,
+	}
+
+	if r.trends != nil {
+		since := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -r.TrendDays+1)
+
+		days, _ := r.trends.Series(trends.Daily, since)
+		for _, day := range days {
+			s.Trend = append(s.Trend, Count{Value: day.Time.Format("2006-01-02"), Count: day.Events})
+		}
+	}
+
+	r.reset(now)
+
+	return s
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Honeytrap report {{ .Name }}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Honeytrap report {{ .Name }}</h1>
+<p>{{ .Start.Format "2006-01-02 15:04" }} - {{ .End.Format "2006-01-02 15:04" }}</p>
+<p>{{ .Events }} events from {{ .UniqueAttackers }} unique sources.</p>
+{{ define "counts" }}<table><tr><th>Count</th><th>Value</th></tr>{{ range . }}<tr><td>{{ .Count }}</td><td>{{ .Value }}</td></tr>{{ end }}</table>{{ end }}
+<h2>Top attackers</h2>
+{{ template "counts" .TopAttackers }}
+<h2>Services</h2>
+{{ template "counts" .Services }}
+{{ if .Severities }}<h2>Severities</h2>
+{{ template "counts" .Severities }}{{ end }}
+<h2>Top credentials</h2>
+{{ template "counts" .TopCredentials }}
+<h2>New payloads</h2>
+{{ template "counts" .NewPayloads }}
+{{ if .Trend }}<h2>Events per day</h2>
+{{ template "counts" .Trend }}{{ end }}
+</body>
+</html>
+`))
+
+// write renders the summary as html into the report directory.
+func (r *Reporter) write(s *Summary) (string, error) {
+	dir := r.Directory
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(r.dataDir, dir)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(dir, fmt.Sprintf("%s-%s.html", r.name, s.End.Format("20060102-150405")))
+
+	f, err := os.Create(p)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	if err := reportTemplate.Execute(f, s); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+// Generate summarizes the events until now, writes the report and sends
+// the summary event to the channels.
+func (r *Reporter) Generate(now time.Time) error {
+	s := r.Summarize(now)
+
+	p, err := r.write(s)
+	if err != nil {
+		err = fmt.Errorf("Error writing report %s: %s", r.name, err.Error())
+	}
+
+	e := event.New(
+		SensorReport,
+		EventCategoryReport,
+		event.Type("summary"),
+		event.Message("%s", s.String()),
+		event.Custom("report.name", r.name),
+		event.Custom("report.file", p),
+		event.Custom("report.events", s.Events),
+		event.Custom("report.unique-sources", s.UniqueAttackers),
+	)
+
+	for _, channel := range r.channels {
+		channel.Send(e)
+	}
+
+	return err
+}
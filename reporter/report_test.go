@@ -0,0 +1,221 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package reporter
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/trends"
+)
+
+func TestSummarize(t *testing.T) {
+	r, err := New("daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		r.Send(event.New(
+			event.Category("ssh"),
+			event.Custom("source-ip", "192.0.2.1"),
+			event.Custom("ssh.username", "root"),
+			event.Custom("ssh.password", "toor"),
+		))
+	}
+
+	r.Send(event.New(
+		event.Category("http"),
+		event.Custom("source-ip", "192.0.2.2"),
+		event.Payload([]byte("GET / HTTP/1.1")),
+	))
+
+	s := r.Summarize(time.Now())
+
+	if s.Events != 4 {
+		t.Errorf("Expected 4 events, got %d", s.Events)
+	}
+
+	if s.UniqueAttackers != 2 {
+		t.Errorf("Expected 2 unique attackers, got %d", s.UniqueAttackers)
+	}
+
+	if len(s.TopAttackers) == 0 || s.TopAttackers[0].Value != "192.0.2.1" || s.TopAttackers[0].Count != 3 {
+		t.Errorf("Unexpected top attackers: %v", s.TopAttackers)
+	}
+
+	if len(s.TopCredentials) != 1 || s.TopCredentials[0].Value != "root:toor" {
+		t.Errorf("Unexpected top credentials: %v", s.TopCredentials)
+	}
+
+	if len(s.NewPayloads) != 1 {
+		t.Errorf("Expected 1 new payload, got %d", len(s.NewPayloads))
+	}
+
+	// the same payload in the next period is not new anymore
+	r.Send(event.New(
+		event.Payload([]byte("GET / HTTP/1.1")),
+	))
+
+	s = r.Summarize(time.Now())
+	if len(s.NewPayloads) != 0 {
+		t.Errorf("Expected no new payloads, got %v", s.NewPayloads)
+	}
+}
+
+func TestNewPayloads(t *testing.T) {
+	r, err := New("daily", func(r *Reporter) error {
+		r.Top = 2
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Send(event.New(event.Payload([]byte(strings.Repeat("A", 1024)))))
+	r.Send(event.New(event.Payload([]byte("B"))))
+	r.Send(event.New(event.Payload([]byte("B"))))
+	r.Send(event.New(event.Payload([]byte("C"))))
+
+	if len(r.newSamples) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(r.newSamples))
+	}
+
+	for _, sample := range r.newSamples {
+		if len(sample) > maxSample+3 {
+			t.Errorf("Expected truncated sample, got %d bytes", len(sample))
+		}
+	}
+
+	s := r.Summarize(time.Now())
+	if len(s.NewPayloads) != 2 || s.NewPayloads[0].Count != 2 {
+		t.Fatalf("Unexpected new payloads: %v", s.NewPayloads)
+	}
+
+	// the payload that didn't fit is still new in the next period
+	r.Send(event.New(event.Payload([]byte("C"))))
+
+	s = r.Summarize(time.Now())
+	if len(s.NewPayloads) != 1 {
+		t.Fatalf("Unexpected new payloads: %v", s.NewPayloads)
+	}
+}
+
+func TestDigestsLimit(t *testing.T) {
+	r, err := New("daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.remember("first")
+	r.remember("second")
+
+	// seeing the first digest again makes the second the least recent
+	if r.isNew("first") {
+		t.Fatal("Expected first digest to be seen")
+	}
+
+	for i := 0; i < maxDigests-1; i++ {
+		r.remember(strconv.Itoa(i))
+	}
+
+	if len(r.seen) != maxDigests || r.digests.Len() != maxDigests {
+		t.Fatalf("Expected %d digests, got %d", maxDigests, len(r.seen))
+	}
+
+	if !r.isNew("second") {
+		t.Error("Expected second digest to be forgotten")
+	}
+
+	if r.isNew("first") {
+		t.Error("Expected first digest to be remembered")
+	}
+}
+
+func TestTrend(t *testing.T) {
+	store, err := trends.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Send(event.New(event.Category("ssh")))
+	store.Send(event.New(event.Category("http")))
+
+	r, err := New("daily", WithTrends(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := r.Summarize(time.Now())
+	if len(s.Trend) != 1 || s.Trend[0].Count != 2 {
+		t.Fatalf("Unexpected trend: %v", s.Trend)
+	}
+
+	if !strings.Contains(s.String(), "Events per day") {
+		t.Errorf("Expected the trend in the report")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	r, err := New("weekly", WithDataDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Send(event.New(
+		event.Category("telnet"),
+		event.Custom("source-ip", "192.0.2.1"),
+	))
+
+	p, err := r.write(r.Summarize(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(p, dir) {
+		t.Errorf("Expected report in %s, got %s", dir, p)
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), "192.0.2.1") {
+		t.Errorf("Expected attacker in report")
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	_, err := New("daily", func(r *Reporter) error {
+		r.Format = "pdf"
+		return nil
+	})
+
+	if err == nil {
+		t.Errorf("Expected error for unsupported format")
+	}
+}
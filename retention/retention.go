@@ -0,0 +1,212 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package retention implements the janitor that removes stored artifacts
+// (payloads, transcripts, event logs) after their configured retention
+// period, and reports the reclaimed space.
+package retention
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:retention")
+
+var (
+	SensorRetention = event.Sensor("retention")
+
+	EventCategoryRetention = event.Category("retention")
+)
+
+// Policy defines the retention of a type of artifact.
+type Policy struct {
+	// Paths are the glob patterns of the artifacts, relative to the data
+	// directory. Matching directories are cleaned recursively.
+	Paths []string `toml:"paths"`
+
+	MaxAge config.Delay `toml:"max-age"`
+}
+
+// Result contains the outcome of a cleanup of an artifact type.
+type Result struct {
+	Artifact       string
+	FilesRemoved   int
+	BytesReclaimed int64
+}
+
+// Janitor removes the artifacts that are older than their policy allows.
+type Janitor struct {
+	Interval config.Delay      `toml:"interval"`
+	Policies map[string]Policy `toml:"policy"`
+
+	dataDir string
+
+	channel pushers.Channel
+
+	now func() time.Time
+
+	// totals since start, per artifact type
+	filesRemoved   map[string]int
+	bytesReclaimed map[string]int64
+}
+
+// New returns a new Janitor.
+func New(options ...func(*Janitor) error) (*Janitor, error) {
+	j := &Janitor{
+		Interval:       config.Delay(time.Hour),
+		Policies:       map[string]Policy{},
+		channel:        pushers.MustDummy(),
+		now:            time.Now,
+		filesRemoved:   map[string]int{},
+		bytesReclaimed: map[string]int64{},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(j); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, p := range j.Policies {
+		if p.MaxAge.Duration() <= 0 {
+			return nil, fmt.Errorf("Retention policy %s: max-age should be positive", name)
+		}
+
+		for _, pattern := range p.Paths {
+			if filepath.IsAbs(pattern) {
+				return nil, fmt.Errorf("Retention policy %s: path %s should be relative to the data directory", name, pattern)
+			}
+		}
+	}
+
+	return j, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the retention configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Janitor) error {
+	return func(j *Janitor) error {
+		return decoder.PrimitiveDecode(c, j)
+	}
+}
+
+// WithDataDir sets the data directory the artifacts are stored in.
+func WithDataDir(dataDir string) func(*Janitor) error {
+	return func(j *Janitor) error {
+		j.dataDir = dataDir
+		return nil
+	}
+}
+
+// WithChannel sets the channel the cleanup metrics are sent to.
+func WithChannel(channel pushers.Channel) func(*Janitor) error {
+	return func(j *Janitor) error {
+		j.channel = channel
+		return nil
+	}
+}
+
+// clean removes the files older than the policy allows.
+func (j *Janitor) clean(name string, p Policy) Result {
+	result := Result{
+		Artifact: name,
+	}
+
+	deadline := j.now().Add(-p.MaxAge.Duration())
+
+	remove := func(path string, fi os.FileInfo) {
+		if !fi.Mode().IsRegular() || !fi.ModTime().Before(deadline) {
+			return
+		}
+
+		if err := os.Remove(path); err != nil {
+			log.Errorf("Error removing %s: %s", path, err.Error())
+			return
+		}
+
+		result.FilesRemoved++
+		result.BytesReclaimed += fi.Size()
+	}
+
+	for _, pattern := range p.Paths {
+		matches, err := filepath.Glob(filepath.Join(j.dataDir, pattern))
+		if err != nil {
+			log.Errorf("Invalid path %s for retention policy %s: %s", pattern, name, err.Error())
+			continue
+		}
+
+		for _, match := range matches {
+			filepath.Walk(match, func(path string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+
+				remove(path, fi)
+				return nil
+			})
+		}
+	}
+
+	return result
+}
+
+// Clean applies all retention policies once.
+func (j *Janitor) Clean() []Result {
+	names := []string{}
+	for name := range j.Policies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	results := []Result{}
+
+	for _, name := range names {
+		result := j.clean(name, j.Policies[name])
+
+		j.filesRemoved[name] += result.FilesRemoved
+		j.bytesReclaimed[name] += result.BytesReclaimed
+
+		if result.FilesRemoved > 0 {
+			log.Infof("Retention %s: removed %d files, reclaimed %d bytes", name, result.FilesRemoved, result.BytesReclaimed)
+		}
+
+		j.channel.Send(event.New(
+			SensorRetention,
+			EventCategoryRetention,
+			event.Type("cleanup"),
+			event.Custom("retention.artifact", name),
+			event.Custom("retention.files-removed", result.FilesRemoved),
+			event.Custom("retention.bytes-reclaimed", result.BytesReclaimed),
+			event.Custom("retention.total-files-removed", j.filesRemoved[name]),
+			event.Custom("retention.total-bytes-reclaimed", j.bytesReclaimed[name]),
+		))
+
+		results = append(results, result)
+	}
+
+	return results
+}
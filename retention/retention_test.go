@@ -0,0 +1,145 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package retention
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+)
+
+func write(t *testing.T, p string, size int, modTime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(p, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(p, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func TestClean(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+
+	write(t, filepath.Join(dir, "payloads", "old.bin"), 100, now.Add(-100*24*time.Hour))
+	write(t, filepath.Join(dir, "payloads", "sub", "old.bin"), 50, now.Add(-100*24*time.Hour))
+	write(t, filepath.Join(dir, "payloads", "new.bin"), 100, now.Add(-time.Hour))
+	write(t, filepath.Join(dir, "transcripts", "old.log"), 10, now.Add(-40*24*time.Hour))
+
+	j, err := New(WithDataDir(dir), func(j *Janitor) error {
+		j.Policies = map[string]Policy{
+			"payloads": {
+				Paths:  []string{"payloads"},
+				MaxAge: config.Delay(90 * 24 * time.Hour),
+			},
+			"transcripts": {
+				Paths:  []string{"transcripts/*.log"},
+				MaxAge: config.Delay(30 * 24 * time.Hour),
+			},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := j.Clean()
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	if results[0].Artifact != "payloads" || results[0].FilesRemoved != 2 || results[0].BytesReclaimed != 150 {
+		t.Errorf("Unexpected result for payloads: %+v", results[0])
+	}
+
+	if results[1].Artifact != "transcripts" || results[1].FilesRemoved != 1 || results[1].BytesReclaimed != 10 {
+		t.Errorf("Unexpected result for transcripts: %+v", results[1])
+	}
+
+	if !exists(filepath.Join(dir, "payloads", "new.bin")) {
+		t.Errorf("Expected new payload to be kept")
+	}
+
+	if exists(filepath.Join(dir, "payloads", "old.bin")) {
+		t.Errorf("Expected old payload to be removed")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	s := struct {
+		Retention toml.Primitive `toml:"retention"`
+	}{}
+
+	md, err := toml.Decode(`
+[retention]
+interval = "30m"
+
+[retention.policy.events]
+paths = ["events.log-*"]
+max-age = "365d"
+`, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := New(WithConfig(s.Retention, &md))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Duration(j.Policies["events"].MaxAge); d != 365*24*time.Hour {
+		t.Errorf("Expected 365 days, got %s", d)
+	}
+
+	if d := j.Interval.Duration(); d != 30*time.Minute {
+		t.Errorf("Expected 30 minutes, got %s", d)
+	}
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	_, err := New(func(j *Janitor) error {
+		j.Policies = map[string]Policy{
+			"payloads": {
+				Paths: []string{"/tmp"},
+			},
+		}
+		return nil
+	})
+
+	if err == nil {
+		t.Errorf("Expected error for invalid policy")
+	}
+}
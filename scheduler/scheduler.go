@@ -0,0 +1,285 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package scheduler runs the periodic background jobs of honeytrap, like
+// database refreshes, retention cleanups and report generation. Every job
+// can be configured with its own interval and jitter, and the status of the
+// last run is kept for the api.
+package scheduler
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:scheduler")
+
+// JobConfig overrides the defaults of a job.
+type JobConfig struct {
+	Interval config.Delay `toml:"interval"`
+	Jitter   config.Delay `toml:"jitter"`
+	Disabled bool         `toml:"disabled"`
+}
+
+// Job is a function that is run every interval.
+type Job struct {
+	Name string
+
+	Interval time.Duration
+
+	// Jitter is the maximum random delay added to every interval, to
+	// spread the load of jobs that start at the same time.
+	Jitter time.Duration
+
+	// Immediate runs the job when the scheduler starts
+	Immediate bool
+
+	Run func() error
+
+	disabled bool
+
+	m            sync.Mutex
+	running      bool
+	runs         int
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastError    error
+	nextRun      time.Time
+}
+
+// Status is the status of a job.
+type Status struct {
+	Name     string `json:"name"`
+	Interval string `json:"interval"`
+	Jitter   string `json:"jitter"`
+	Enabled  bool   `json:"enabled"`
+	Running  bool   `json:"running"`
+	Runs     int    `json:"runs"`
+
+	LastRun      *time.Time `json:"last-run,omitempty"`
+	LastDuration string     `json:"last-duration,omitempty"`
+	LastError    string     `json:"last-error,omitempty"`
+	NextRun      *time.Time `json:"next-run,omitempty"`
+}
+
+// Scheduler runs the jobs.
+type Scheduler struct {
+	// Jitter is the default jitter of the jobs
+	Jitter config.Delay `toml:"jitter"`
+
+	Jobs map[string]JobConfig `toml:"job"`
+
+	m    sync.Mutex
+	jobs []*Job
+
+	started bool
+	done    <-chan struct{}
+
+	now func() time.Time
+}
+
+// New returns a new Scheduler.
+func New(options ...func(*Scheduler) error) (*Scheduler, error) {
+	s := &Scheduler{
+		Jobs: map[string]JobConfig{},
+		now:  time.Now,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the scheduler configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Scheduler) error {
+	return func(s *Scheduler) error {
+		return decoder.PrimitiveDecode(c, s)
+	}
+}
+
+// Add adds the job, the configuration of the job overrides its interval
+// and jitter. Jobs added after the scheduler has started, are started
+// immediately.
+func (s *Scheduler) Add(j *Job) error {
+	if j.Jitter == 0 {
+		j.Jitter = s.Jitter.Duration()
+	}
+
+	if c, ok := s.Jobs[j.Name]; ok {
+		if c.Interval > 0 {
+			j.Interval = c.Interval.Duration()
+		}
+
+		if c.Jitter > 0 {
+			j.Jitter = c.Jitter.Duration()
+		}
+
+		j.disabled = c.Disabled
+	}
+
+	if j.Interval <= 0 {
+		return fmt.Errorf("Job %s: interval should be positive", j.Name)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, other := range s.jobs {
+		if other.Name == j.Name {
+			return fmt.Errorf("Job %s already exists", j.Name)
+		}
+	}
+
+	s.jobs = append(s.jobs, j)
+
+	if s.started && !j.disabled {
+		go s.loop(j, s.done)
+	}
+
+	return nil
+}
+
+// delay returns the interval of the job, with a random jitter.
+func (j *Job) delay() time.Duration {
+	d := j.Interval
+	if j.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(j.Jitter)))
+	}
+
+	return d
+}
+
+func (s *Scheduler) run(j *Job) {
+	j.m.Lock()
+	j.running = true
+	j.m.Unlock()
+
+	start := s.now()
+
+	err := j.Run()
+	if err != nil {
+		log.Errorf("Error running job %s: %s", j.Name, err.Error())
+	}
+
+	j.m.Lock()
+	j.running = false
+	j.runs++
+	j.lastRun = start
+	j.lastDuration = s.now().Sub(start)
+	j.lastError = err
+	j.m.Unlock()
+}
+
+func (s *Scheduler) loop(j *Job, done <-chan struct{}) {
+	if j.Immediate {
+		s.run(j)
+	}
+
+	for {
+		d := j.delay()
+
+		j.m.Lock()
+		j.nextRun = s.now().Add(d)
+		j.m.Unlock()
+
+		timer := time.NewTimer(d)
+
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.run(j)
+		}
+	}
+}
+
+// Run starts the jobs, until the done channel is closed.
+func (s *Scheduler) Run(done <-chan struct{}) {
+	s.m.Lock()
+	s.started = true
+	s.done = done
+
+	for _, j := range s.jobs {
+		if j.disabled {
+			log.Infof("Job %s is disabled", j.Name)
+			continue
+		}
+
+		go s.loop(j, done)
+	}
+
+	s.m.Unlock()
+}
+
+// Status returns the status of all jobs, ordered by name.
+func (s *Scheduler) Status() []Status {
+	s.m.Lock()
+	jobs := append([]*Job{}, s.jobs...)
+	s.m.Unlock()
+
+	result := []Status{}
+
+	for _, j := range jobs {
+		j.m.Lock()
+
+		st := Status{
+			Name:     j.Name,
+			Interval: j.Interval.String(),
+			Jitter:   j.Jitter.String(),
+			Enabled:  !j.disabled,
+			Running:  j.running,
+			Runs:     j.runs,
+		}
+
+		if !j.lastRun.IsZero() {
+			lastRun := j.lastRun
+			st.LastRun = &lastRun
+			st.LastDuration = j.lastDuration.String()
+		}
+
+		if j.lastError != nil {
+			st.LastError = j.lastError.Error()
+		}
+
+		if !j.nextRun.IsZero() {
+			nextRun := j.nextRun
+			st.NextRun = &nextRun
+		}
+
+		j.m.Unlock()
+
+		result = append(result, st)
+	}
+
+	sort.Slice(result, func(i, k int) bool {
+		return result[i].Name < result[k].Name
+	})
+
+	return result
+}
@@ -0,0 +1,119 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+func TestScheduler(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan struct{}, 10)
+
+	if err := s.Add(&Job{
+		Name:     "test",
+		Interval: 10 * time.Millisecond,
+		Run: func() error {
+			ran <- struct{}{}
+			return errors.New("failed")
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	s.Run(done)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("Job didn't run")
+		}
+	}
+
+	// wait for the status of the second run
+	time.Sleep(5 * time.Millisecond)
+
+	status := s.Status()
+	if len(status) != 1 {
+		t.Fatalf("Expected 1 job, got %d", len(status))
+	}
+
+	if status[0].Runs < 2 {
+		t.Errorf("Expected at least 2 runs, got %d", status[0].Runs)
+	}
+
+	if status[0].LastRun == nil || status[0].NextRun == nil {
+		t.Errorf("Expected last and next run to be set")
+	}
+
+	if status[0].LastError != "failed" {
+		t.Errorf("Expected last error failed, got %q", status[0].LastError)
+	}
+}
+
+func TestSchedulerJobConfig(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Jitter = config.Delay(time.Second)
+	s.Jobs["retention"] = JobConfig{
+		Interval: config.Delay(time.Minute),
+		Disabled: true,
+	}
+
+	if err := s.Add(&Job{Name: "retention", Interval: time.Hour, Run: func() error { return nil }}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Add(&Job{Name: "retention", Interval: time.Hour, Run: func() error { return nil }}); err == nil {
+		t.Errorf("Expected error adding duplicate job")
+	}
+
+	if err := s.Add(&Job{Name: "invalid", Run: func() error { return nil }}); err == nil {
+		t.Errorf("Expected error adding job without interval")
+	}
+
+	status := s.Status()
+	if len(status) != 1 {
+		t.Fatalf("Expected 1 job, got %d", len(status))
+	}
+
+	if status[0].Interval != "1m0s" || status[0].Jitter != "1s" || status[0].Enabled {
+		t.Errorf("Unexpected job status %+v", status[0])
+	}
+}
+
+func TestDelay(t *testing.T) {
+	j := &Job{Interval: time.Minute, Jitter: time.Second}
+
+	for i := 0; i < 100; i++ {
+		if d := j.delay(); d < time.Minute || d >= time.Minute+time.Second {
+			t.Fatalf("Delay %s out of range", d)
+		}
+	}
+}
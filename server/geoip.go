@@ -0,0 +1,204 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+func init() {
+	event.RegisterField(event.Field{Name: "source.country.isocode", Type: event.TypeString, Description: "ISO code of the country of the attacker"})
+	event.RegisterField(event.Field{Name: "source.city", Type: event.TypeString, Description: "City of the attacker, with the GeoLite2-City database"})
+	event.RegisterField(event.Field{Name: "source.location.latitude", Type: event.TypeNumber, Description: "Latitude of the attacker, with the GeoLite2-City database"})
+	event.RegisterField(event.Field{Name: "source.location.longitude", Type: event.TypeNumber, Description: "Longitude of the attacker, with the GeoLite2-City database"})
+	event.RegisterField(event.Field{Name: "source.asn", Type: event.TypeNumber, Description: "Autonomous system number of the attacker, with the GeoLite2-ASN database"})
+	event.RegisterField(event.Field{Name: "source.as.org", Type: event.TypeString, Description: "Organization of the autonomous system of the attacker"})
+}
+
+// cityRecord is the record of the country and city databases, the city and
+// location are empty with the country database.
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+func (r cityRecord) store(evt event.Event) {
+	evt.Store("source.country.isocode", r.Country.ISOCode)
+
+	if name := r.City.Names["en"]; name != "" {
+		evt.Store("source.city", name)
+	}
+
+	if r.Location.Latitude != 0 || r.Location.Longitude != 0 {
+		evt.Store("source.location.latitude", r.Location.Latitude)
+		evt.Store("source.location.longitude", r.Location.Longitude)
+	}
+}
+
+// asnRecord is the record of the autonomous systems database.
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+func (r asnRecord) store(evt event.Event) {
+	if r.Number == 0 {
+		return
+	}
+
+	evt.Store("source.asn", int(r.Number))
+	evt.Store("source.as.org", r.Organization)
+}
+
+// geoDB is a GeoLite2 database in the data dir, reopened when the web
+// module replaced it.
+type geoDB struct {
+	// paths are the editions in order of preference
+	paths []string
+
+	path    string
+	modTime time.Time
+	db      *maxminddb.Reader
+}
+
+// reload opens the preferred database when it changed, the previous
+// database is returned to be closed.
+func (g *geoDB) reload() (*maxminddb.Reader, error) {
+	for _, p := range g.paths {
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if p == g.path && fi.ModTime().Equal(g.modTime) {
+			return nil, nil
+		}
+
+		db, err := maxminddb.Open(p)
+		if err != nil {
+			return nil, err
+		}
+
+		prev := g.db
+
+		g.path, g.modTime, g.db = p, fi.ModTime(), db
+		return prev, nil
+	}
+
+	return nil, nil
+}
+
+// geoEnricher stores the location and autonomous system of the sources,
+// with the GeoLite2 databases downloaded by the web module. It is
+// subscribed before the channels and signing, so all channels receive the
+// same fields and the signatures cover them.
+type geoEnricher struct {
+	m sync.RWMutex
+
+	city *geoDB
+	asn  *geoDB
+}
+
+func newGeoEnricher(dataDir string) *geoEnricher {
+	return &geoEnricher{
+		city: &geoDB{
+			paths: []string{
+				filepath.Join(dataDir, "GeoLite2-City.mmdb"),
+				filepath.Join(dataDir, "GeoLite2-Country.mmdb"),
+			},
+		},
+		asn: &geoDB{
+			paths: []string{
+				filepath.Join(dataDir, "GeoLite2-ASN.mmdb"),
+			},
+		},
+	}
+}
+
+// Reload opens the databases that were downloaded or replaced since the
+// last reload.
+func (g *geoEnricher) Reload() error {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	for _, db := range []*geoDB{g.city, g.asn} {
+		prev, err := db.reload()
+		if err != nil {
+			return err
+		}
+
+		if prev != nil {
+			prev.Close()
+		}
+	}
+
+	return nil
+}
+
+// Send stores the location and autonomous system of the source of the
+// event, events are passed unresolved while the databases are unavailable.
+func (g *geoEnricher) Send(e event.Event) {
+	v := e.Get("source-ip")
+	if v == "" {
+		return
+	}
+
+	// the databases contain both ipv4 and ipv6 networks
+	ip := net.ParseIP(v)
+	if ip == nil {
+		return
+	}
+
+	g.m.RLock()
+	defer g.m.RUnlock()
+
+	if db := g.city.db; db != nil {
+		var record cityRecord
+
+		if err := db.Lookup(ip, &record); err != nil {
+			log.Errorf("Error looking up location of %s: %s", ip, err.Error())
+		} else {
+			record.store(e)
+		}
+	}
+
+	if db := g.asn.db; db != nil {
+		var record asnRecord
+
+		if err := db.Lookup(ip, &record); err != nil {
+			log.Errorf("Error looking up autonomous system of %s: %s", ip, err.Error())
+		} else {
+			record.store(e)
+		}
+	}
+}
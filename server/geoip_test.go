@@ -0,0 +1,79 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestGeoIPRecords(t *testing.T) {
+	var city cityRecord
+	city.Country.ISOCode = "NL"
+	city.City.Names = map[string]string{"en": "Amsterdam"}
+	city.Location.Latitude = 52.37
+	city.Location.Longitude = 4.89
+
+	e := event.New()
+	city.store(e)
+	asnRecord{Number: 1136, Organization: "KPN B.V."}.store(e)
+
+	if e.Get("source.country.isocode") != "NL" || e.Get("source.city") != "Amsterdam" || e.Get("source.as.org") != "KPN B.V." {
+		t.Errorf("Expected location and organization, got %s %s %s", e.Get("source.country.isocode"), e.Get("source.city"), e.Get("source.as.org"))
+	}
+
+	// the country database has no city and location
+	e = event.New()
+	cityRecord{}.store(e)
+	asnRecord{}.store(e)
+
+	if e.Has("source.city") || e.Has("source.location.latitude") || e.Has("source.asn") {
+		t.Errorf("Expected only the country to be stored")
+	}
+}
+
+func TestGeoEnricher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	g := newGeoEnricher(dir)
+
+	// the events are passed unresolved until the databases are downloaded
+	if err := g.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := event.New(event.Custom("source-ip", "198.51.100.7"))
+	g.Send(e)
+
+	if e.Has("source.country.isocode") {
+		t.Errorf("Expected no country without database")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "GeoLite2-City.mmdb"), []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.Reload(); err == nil {
+		t.Errorf("Expected error opening invalid database")
+	}
+}
@@ -15,21 +15,32 @@ package server
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mattn/go-isatty"
 
+	"github.com/BurntSushi/toml"
 	"github.com/fatih/color"
 
+	"github.com/honeytrap/honeytrap/analysis"
+	"github.com/honeytrap/honeytrap/c2"
+	"github.com/honeytrap/honeytrap/canary"
 	"github.com/honeytrap/honeytrap/cmd"
 	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/content"
+	"github.com/honeytrap/honeytrap/correlation"
 
+	"github.com/honeytrap/honeytrap/credentials"
 	"github.com/honeytrap/honeytrap/director"
 	_ "github.com/honeytrap/honeytrap/director/forward"
 	_ "github.com/honeytrap/honeytrap/director/lxc"
@@ -37,8 +48,26 @@ import (
 	// _ "github.com/honeytrap/honeytrap/director/qemu"
 	// Import your directors here.
 
+	"github.com/honeytrap/honeytrap/fingerprint"
+	"github.com/honeytrap/honeytrap/governor"
+	"github.com/honeytrap/honeytrap/personality"
+	"github.com/honeytrap/honeytrap/pivot"
+	"github.com/honeytrap/honeytrap/privacy"
+	"github.com/honeytrap/honeytrap/proxy"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/pushers/eventbus"
+	"github.com/honeytrap/honeytrap/reporter"
+	"github.com/honeytrap/honeytrap/retention"
+	"github.com/honeytrap/honeytrap/scheduler"
+	"github.com/honeytrap/honeytrap/severity"
+	"github.com/honeytrap/honeytrap/signatures"
+	"github.com/honeytrap/honeytrap/signing"
+	"github.com/honeytrap/honeytrap/stats"
+	"github.com/honeytrap/honeytrap/storage"
+	"github.com/honeytrap/honeytrap/tracing"
+	"github.com/honeytrap/honeytrap/transcript"
+	"github.com/honeytrap/honeytrap/trends"
+	"github.com/honeytrap/honeytrap/web"
 
 	"github.com/honeytrap/honeytrap/services"
 	_ "github.com/honeytrap/honeytrap/services/bannerfmt"
@@ -47,19 +76,27 @@ import (
 	_ "github.com/honeytrap/honeytrap/services/ethereum"
 	_ "github.com/honeytrap/honeytrap/services/ftp"
 	_ "github.com/honeytrap/honeytrap/services/ipp"
+	_ "github.com/honeytrap/honeytrap/services/jetdirect"
 	_ "github.com/honeytrap/honeytrap/services/ldap"
+	_ "github.com/honeytrap/honeytrap/services/mysql"
+	_ "github.com/honeytrap/honeytrap/services/nfs"
+	_ "github.com/honeytrap/honeytrap/services/postgres"
+	_ "github.com/honeytrap/honeytrap/services/rdp"
 	_ "github.com/honeytrap/honeytrap/services/redis"
+	_ "github.com/honeytrap/honeytrap/services/rsync"
 	_ "github.com/honeytrap/honeytrap/services/smtp"
 	_ "github.com/honeytrap/honeytrap/services/snmp"
 	_ "github.com/honeytrap/honeytrap/services/ssh"
 	_ "github.com/honeytrap/honeytrap/services/telnet"
 	_ "github.com/honeytrap/honeytrap/services/vnc"
+	_ "github.com/honeytrap/honeytrap/services/x11"
 
 	"github.com/honeytrap/honeytrap/listener"
 	_ "github.com/honeytrap/honeytrap/listener/agent"
 	_ "github.com/honeytrap/honeytrap/listener/canary"
 	_ "github.com/honeytrap/honeytrap/listener/netstack"
 	_ "github.com/honeytrap/honeytrap/listener/netstack-experimental"
+	_ "github.com/honeytrap/honeytrap/listener/nfqueue"
 	_ "github.com/honeytrap/honeytrap/listener/socket"
 	_ "github.com/honeytrap/honeytrap/listener/tap"
 	_ "github.com/honeytrap/honeytrap/listener/tun"
@@ -70,22 +107,40 @@ import (
 	"github.com/honeytrap/honeytrap/server/profiler"
 
 	_ "github.com/honeytrap/honeytrap/pushers/console"
+	_ "github.com/honeytrap/honeytrap/pushers/database"
+	_ "github.com/honeytrap/honeytrap/pushers/discord"
 	_ "github.com/honeytrap/honeytrap/pushers/dshield"
 	_ "github.com/honeytrap/honeytrap/pushers/elasticsearch"
+	_ "github.com/honeytrap/honeytrap/pushers/email"
 	_ "github.com/honeytrap/honeytrap/pushers/file"
 	_ "github.com/honeytrap/honeytrap/pushers/kafka"
 	_ "github.com/honeytrap/honeytrap/pushers/marija"
+	_ "github.com/honeytrap/honeytrap/pushers/misp"
+	_ "github.com/honeytrap/honeytrap/pushers/opencti"
 	_ "github.com/honeytrap/honeytrap/pushers/pulsar"
 	_ "github.com/honeytrap/honeytrap/pushers/rabbitmq"
 	_ "github.com/honeytrap/honeytrap/pushers/raven"
+	_ "github.com/honeytrap/honeytrap/pushers/s3"
 	_ "github.com/honeytrap/honeytrap/pushers/slack"
+	_ "github.com/honeytrap/honeytrap/pushers/socket"
 	_ "github.com/honeytrap/honeytrap/pushers/splunk"
+	_ "github.com/honeytrap/honeytrap/pushers/syslog"
+	_ "github.com/honeytrap/honeytrap/pushers/taxii"
+	_ "github.com/honeytrap/honeytrap/pushers/teams"
 
 	"github.com/op/go-logging"
 )
 
 var log = logging.MustGetLogger("honeytrap/server")
 
+func init() {
+	event.RegisterField(event.Field{Name: "session.duration", Type: event.TypeNumber, Description: "Duration of the session in seconds"})
+	event.RegisterField(event.Field{Name: "session.bytes-read", Type: event.TypeInteger, Description: "Bytes read from the attacker"})
+	event.RegisterField(event.Field{Name: "session.bytes-written", Type: event.TypeInteger, Description: "Bytes written to the attacker"})
+	event.RegisterField(event.Field{Name: "session.timeout", Type: event.TypeString, Description: "Timeout that ended the session: connect, read, write, idle or session, empty if it didn't time out"})
+	event.RegisterField(event.Field{Name: "session.transcript", Type: event.TypeString, Description: "Identifier of the session transcript"})
+}
+
 // Honeytrap defines a struct which coordinates the internal logic for the honeytrap
 // container infrastructure.
 type Honeytrap struct {
@@ -102,8 +157,49 @@ type Honeytrap struct {
 
 	dataDir string
 
-	// Maps a port and a protocol to an array of pointers to services
-	ports map[net.Addr][]*ServiceMap
+	// Aggregated counters of all events, maintained incrementally
+	stats *stats.Stats
+
+	// Rollups of the events, kept for a long time
+	trends *trends.Store
+
+	// Maps a listener name to the listener and its configured ports
+	listeners map[string]*ListenerMap
+
+	// Records the sessions, nil when recording is disabled
+	transcripts *transcript.Store
+
+	// Pseudonymizes the sources, nil when privacy mode is disabled
+	anonymizer *privacy.Anonymizer
+
+	correlator *correlation.Correlator
+
+	// Records the journeys of the events, nil when tracing is disabled
+	tracer *tracing.Tracer
+
+	fingerprinter *fingerprint.Fingerprinter
+
+	// Rotates the exposed services, nil when rotation is disabled
+	rotation *rotation
+
+	// Looks up the countries of sources for the routes of the ports
+	countries *countryDB
+
+	// Looks up the autonomous systems of sources for the policies
+	asns *asnDB
+
+	// Policies change the response to sources, the first matching policy
+	// applies
+	policies []*policy
+
+	// Sheds load when the resources exceed the budget, nil when no limits
+	// are set
+	governor *governor.Governor
+
+	// The web interface, stopped when honeytrap stops
+	web interface {
+		Stop(context.Context) error
+	}
 }
 
 // New returns a new instance of a Honeytrap struct.
@@ -119,6 +215,7 @@ func New(options ...OptionFn) (*Honeytrap, error) {
 		director: director.MustDummy(),
 		bus:      bus,
 		profiler: profiler.Dummy(),
+		stats:    stats.New(),
 	}
 
 	for _, fn := range options {
@@ -150,12 +247,106 @@ func (hc *Honeytrap) PrepareRun() {
 
 // Wraps a Servicer, adding some metadata
 type ServiceMap struct {
+	m sync.RWMutex
+
+	// Service is replaced when the personality rotates, use Servicer
 	Service services.Servicer
 
 	Name string
 	Type string
+
+	Session SessionConfig
+	Latency LatencyConfig
+
+	// Labels are stamped on the events of the service
+	Labels map[string]string
+
+	config   toml.Primitive
+	director director.Director
 }
 
+// Servicer returns the current service.
+func (sm *ServiceMap) Servicer() services.Servicer {
+	sm.m.RLock()
+	defer sm.m.RUnlock()
+
+	return sm.Service
+}
+
+// SetServicer replaces the service, connections that are being handled
+// finish with the previous service.
+func (sm *ServiceMap) SetServicer(s services.Servicer) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+
+	sm.Service = s
+}
+
+// channel returns the channel the events of the service are sent to, the
+// labels of the service are stamped on the events.
+func (sm *ServiceMap) channel(c pushers.Channel) pushers.Channel {
+	if len(sm.Labels) == 0 {
+		return c
+	}
+
+	return pushers.LabelChannel(c, sm.Labels)
+}
+
+// newServicer creates the service of the service map, the configuration of
+// the service takes precedence over the defaults of the personality.
+func (hc *Honeytrap) newServicer(sm *ServiceMap, p *personality.Personality) services.Servicer {
+	options := []services.ServicerFunc{
+		services.WithChannel(sm.channel(hc.bus)),
+	}
+
+	if p == nil {
+	} else if defaults, ok := p.Defaults(sm.Type); ok {
+		options = append(options, services.WithDefaults(defaults))
+	}
+
+	options = append(options, services.WithConfig(sm.config, hc.config))
+
+	if sm.director != nil {
+		options = append(options, services.WithDirector(sm.director))
+	}
+
+	fn, _ := services.Get(sm.Type)
+	return fn(options...)
+}
+
+// ListenerMap wraps a Listener, adding the ports it serves
+type ListenerMap struct {
+	Listener listener.Listener
+
+	Name string
+	Type string
+
+	// Maps a port and a protocol to an array of pointers to services
+	ports map[net.Addr][]*ServiceMap
+
+	// Maps a port and a protocol to the tls configuration of the port
+	tls map[net.Addr]*tlsWrapper
+
+	// Maps a port and a protocol to the routes of the port, evaluated in
+	// order before the services of the port
+	routes map[net.Addr][]*route
+}
+
+// tlsWrapper returns the tls wrapper for the local address of the
+// connection, nil if the port has no tls configured.
+func (lm *ListenerMap) tlsWrapper(conn net.Conn) *tlsWrapper {
+	for k, w := range lm.tls {
+		if compareAddr(k, conn.LocalAddr()) {
+			return w
+		}
+	}
+
+	return nil
+}
+
+// DefaultListener is the name of the listener configured in the [listener] section
+const DefaultListener = "default"
+
 var (
 	ErrNoServicesGivenPort = fmt.Errorf("no services for the given ports")
 )
@@ -170,17 +361,28 @@ var (
  *         - If it implements CanHandle, peek the connection and pass the peeked
  *           data to CanHandle. If it returns true, pick it
  */
-func (hc *Honeytrap) findService(conn net.Conn) (*ServiceMap, net.Conn, error) {
+func (hc *Honeytrap) findService(lm *ListenerMap, conn net.Conn, p *policy) (*ServiceMap, net.Conn, error) {
 	localAddr := conn.LocalAddr()
 
 	var serviceCandidates []*ServiceMap
 
-	for k, sc := range hc.ports {
+	for k, sc := range lm.ports {
 		if !compareAddr(k, localAddr) {
 			continue
 		}
 
-		serviceCandidates = sc
+		// routes take precedence over the services of the port
+		if routed := hc.route(lm.routes[k], conn.RemoteAddr()); routed != nil {
+			sc = routed
+		}
+
+		// services that are rotated out or not allowed by the policy
+		// are not exposed
+		for _, sm := range sc {
+			if hc.rotation.Active(sm.Name) && p.Allows(sm) {
+				serviceCandidates = append(serviceCandidates, sm)
+			}
+		}
 	}
 
 	if len(serviceCandidates) == 0 {
@@ -195,7 +397,7 @@ func (hc *Honeytrap) findService(conn net.Conn) (*ServiceMap, net.Conn, error) {
 	var n int
 	buffer := make([]byte, 1024)
 	for _, service := range serviceCandidates {
-		ch, ok := service.Service.(services.CanHandlerer)
+		ch, ok := service.Servicer().(services.CanHandlerer)
 		if !ok {
 			// Service does not implement CanHandle, assume it can handle the connection
 			return service, conn, nil
@@ -251,7 +453,10 @@ func ToAddr(input string) (net.Addr, string, int, error) {
 	proto := parts[0]
 
 	host, port, err := net.SplitHostPort(parts[1])
-	if err != nil {
+	if err == nil {
+	} else if strings.Count(parts[1], ":") > 0 {
+		return nil, "", 0, fmt.Errorf("error parsing address %s: ipv6 addresses need to be enclosed in brackets (eg \"tcp/[::1]:22\")", parts[1])
+	} else {
 		port = parts[1]
 	}
 
@@ -282,6 +487,12 @@ func IsTerminal(f *os.File) bool {
 	return false
 }
 
+// isWildcard returns true if the ip matches any address, this is the case for
+// both an empty ip and the unspecified addresses 0.0.0.0 and [::].
+func isWildcard(ip net.IP) bool {
+	return ip == nil || ip.IsUnspecified()
+}
+
 func compareAddr(addr1 net.Addr, addr2 net.Addr) bool {
 	if ta1, ok := addr1.(*net.TCPAddr); ok {
 		ta2, ok := addr2.(*net.TCPAddr)
@@ -293,8 +504,8 @@ func compareAddr(addr1 net.Addr, addr2 net.Addr) bool {
 			return false
 		}
 
-		if ta1.IP == nil {
-		} else if ta2.IP == nil {
+		if isWildcard(ta1.IP) {
+		} else if isWildcard(ta2.IP) {
 		} else if !ta1.IP.Equal(ta2.IP) {
 			return false
 		}
@@ -310,8 +521,8 @@ func compareAddr(addr1 net.Addr, addr2 net.Addr) bool {
 			return false
 		}
 
-		if ua1.IP == nil {
-		} else if ua2.IP == nil {
+		if isWildcard(ua1.IP) {
+		} else if isWildcard(ua2.IP) {
 		} else if !ua1.IP.Equal(ua2.IP) {
 			return false
 		}
@@ -322,6 +533,203 @@ func compareAddr(addr1 net.Addr, addr2 net.Addr) bool {
 	return false
 }
 
+// personality returns the configured personality, nil when not configured.
+func (hc *Honeytrap) personality() (*personality.Personality, error) {
+	x := struct {
+		Profile  string `toml:"profile"`
+		Hostname string `toml:"hostname"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(hc.config.Personality, &x); err != nil {
+		return nil, err
+	}
+
+	if x.Profile == "" {
+		return nil, nil
+	}
+
+	p, err := personality.Get(x.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	p = p.WithHostname(x.Hostname)
+
+	log.Infof("Using personality %s (%s) with hostname %s", p.Name, p.Description, p.Hostname)
+	return p, nil
+}
+
+// outboundProxy sets the default proxy of the channels and enrichers.
+func (hc *Honeytrap) outboundProxy() error {
+	x := struct {
+		URL string `toml:"url"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(hc.config.Proxy, &x); err != nil {
+		return err
+	}
+
+	return proxy.SetDefault(x.URL)
+}
+
+// privacy subscribes the anonymizer to the bus when the privacy mode is
+// enabled, so the events are anonymized before they are stored or pushed.
+func (hc *Honeytrap) privacy() error {
+	x := struct {
+		Enabled bool `toml:"enabled"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(hc.config.Privacy, &x); err != nil {
+		return err
+	} else if !x.Enabled {
+		return nil
+	}
+
+	st, err := storage.Namespace("privacy")
+	if err != nil {
+		return err
+	}
+
+	a, err := privacy.New(
+		privacy.WithConfig(hc.config.Privacy, hc.config),
+		privacy.WithStorage(st),
+	)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Privacy mode enabled, pseudonymizing %s", strings.Join(a.Fields, ", "))
+
+	hc.anonymizer = a
+
+	return hc.bus.Subscribe(hc.tracer.Channel(tracing.StageEnricher, "privacy", a))
+}
+
+// signing subscribes the signer to the bus when enabled, the public key is
+// written to the data directory to verify the exported events with.
+func (hc *Honeytrap) signing() error {
+	x := struct {
+		Enabled bool `toml:"enabled"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(hc.config.Signing, &x); err != nil {
+		return err
+	} else if !x.Enabled {
+		return nil
+	}
+
+	st, err := storage.Namespace("signing")
+	if err != nil {
+		return err
+	}
+
+	s, err := signing.New(
+		signing.WithConfig(hc.config.Signing, hc.config),
+		signing.WithStorage(st),
+	)
+	if err != nil {
+		return err
+	}
+
+	p := filepath.Join(hc.dataDir, "signing.pub")
+	if err := ioutil.WriteFile(p, []byte(hex.EncodeToString(s.PublicKey())+"\n"), 0644); err != nil {
+		return err
+	}
+
+	log.Infof("Signing events with key %s (%s)", s.KeyID(), p)
+
+	return hc.bus.Subscribe(hc.tracer.Channel(tracing.StageEnricher, "signing", s))
+}
+
+// canaries plants the canary credentials in the services, and watches the
+// events for replays of the canaries.
+func (hc *Honeytrap) canaries() error {
+	x := struct {
+		Enabled bool `toml:"enabled"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(hc.config.Canaries, &x); err != nil {
+		return err
+	} else if !x.Enabled {
+		return nil
+	}
+
+	st, err := storage.Namespace("canary")
+	if err != nil {
+		return err
+	}
+
+	cs, err := canary.New(
+		canary.WithStorage(st),
+		canary.WithConfig(hc.config.Canaries, hc.config),
+		canary.WithChannel(hc.bus),
+		canary.WithSensor(hc.token),
+	)
+	if err != nil {
+		return err
+	}
+
+	canary.SetDefault(cs)
+
+	return hc.bus.Subscribe(cs)
+}
+
+// c2 returns the tracker of the c2 servers, or nil when c2 tracking is
+// disabled.
+func (hc *Honeytrap) c2() (*c2.Tracker, error) {
+	x := struct {
+		Enabled bool `toml:"enabled"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(hc.config.C2, &x); err != nil {
+		return nil, err
+	} else if !x.Enabled {
+		return nil, nil
+	}
+
+	st, err := storage.Namespace("c2")
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := c2.New(
+		c2.WithStorage(st),
+		c2.WithConfig(hc.config.C2, hc.config),
+		c2.WithChannel(hc.bus),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, hc.bus.Subscribe(t)
+}
+
+// trendStore returns the store of the hourly and daily rollups of the events.
+func (hc *Honeytrap) trendStore() (*trends.Store, error) {
+	st, err := storage.Namespace("trends")
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := trends.New(
+		trends.WithConfig(hc.config.Trends, hc.config),
+		trends.WithStorage(st),
+		trends.WithCountry(hc.countries.Country),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, hc.bus.Subscribe(t)
+}
+
+// schedule adds the background job to the scheduler.
+func (hc *Honeytrap) schedule(s *scheduler.Scheduler, j *scheduler.Job) {
+	if err := s.Add(j); err != nil {
+		log.Errorf("Error scheduling job %s: %s", j.Name, err.Error())
+	}
+}
+
 // Run will start honeytrap
 func (hc *Honeytrap) Run(ctx context.Context) {
 	if IsTerminal(os.Stdout) {
@@ -340,17 +748,280 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 
 	log.Debugf("Using datadir: %s", hc.dataDir)
 
+	// the country database is downloaded by the web module
+	hc.countries = &countryDB{path: filepath.Join(hc.dataDir, "GeoLite2-Country.mmdb")}
+	hc.asns = &asnDB{path: filepath.Join(hc.dataDir, "GeoLite2-ASN.mmdb")}
+
+	if err := hc.configurePolicies(); err != nil {
+		log.Fatalf("Error parsing configuration of policies: %s", err.Error())
+	}
+
 	go hc.heartbeat()
 
 	hc.profiler.Start()
 
+	if err := hc.outboundProxy(); err != nil {
+		log.Fatalf("Error initializing outbound proxy: %s", err.Error())
+	}
+
+	sched, err := scheduler.New(
+		scheduler.WithConfig(hc.config.Scheduler, hc.config),
+	)
+	if err != nil {
+		log.Fatalf("Error initializing scheduler: %s", err.Error())
+	}
+
+	// the tracer is subscribed before the enrichers, it starts the
+	// journeys of the events
+	if t, err := tracing.New(
+		tracing.WithConfig(hc.config.Tracing, hc.config),
+	); err != nil {
+		log.Error("Error parsing configuration of tracing: %s", err.Error())
+	} else if t.Enabled {
+		log.Warningf("Tracing of the event pipeline enabled, keeping the journeys of the last %d events", t.Size)
+
+		hc.tracer = t
+		hc.bus.Subscribe(t)
+	}
+
+	// the correlator is subscribed first, so the ids of the events are
+	// available for all other subscribers
+	if c, err := correlation.New(
+		correlation.WithConfig(hc.config.Correlation, hc.config),
+	); err != nil {
+		log.Error("Error parsing configuration of correlation: %s", err.Error())
+	} else {
+		hc.correlator = c
+		hc.bus.Subscribe(hc.tracer.Channel(tracing.StageEnricher, "correlation", c))
+	}
+
+	// the sources are located before privacy mode pseudonymizes them
+	geo := newGeoEnricher(hc.dataDir)
+	hc.bus.Subscribe(hc.tracer.Channel(tracing.StageEnricher, "geoip", geo))
+
+	hc.schedule(sched, &scheduler.Job{
+		Name:      "geoip-reload",
+		Interval:  time.Hour,
+		Immediate: true,
+		Run:       geo.Reload,
+	})
+
+	if f, err := fingerprint.New(
+		fingerprint.WithConfig(hc.config.Fingerprint, hc.config),
+	); err != nil {
+		log.Error("Error parsing configuration of fingerprint: %s", err.Error())
+	} else {
+		hc.fingerprinter = f
+	}
+
+	// the signature tagger is subscribed next, so the tags are available
+	// for all other subscribers
+	if t, err := signatures.New(
+		signatures.WithConfig(hc.config.Signatures, hc.config),
+		signatures.WithDataDir(hc.dataDir),
+	); err != nil {
+		log.Error("Error parsing configuration of signatures: %s", err.Error())
+	} else {
+		hc.bus.Subscribe(hc.tracer.Channel(tracing.StageEnricher, "signatures", t))
+
+		hc.schedule(sched, &scheduler.Job{
+			Name:      "signatures",
+			Interval:  t.ReloadInterval.Duration(),
+			Immediate: true,
+			Run:       t.Reload,
+		})
+	}
+
+	// payloads are analyzed before privacy mode, which could remove them
+	if a, err := analysis.New(
+		analysis.WithConfig(hc.config.Analysis, hc.config),
+	); err != nil {
+		log.Error("Error parsing configuration of analysis: %s", err.Error())
+	} else {
+		hc.bus.Subscribe(hc.tracer.Channel(tracing.StageEnricher, "analysis", a))
+	}
+
+	// severity is scored after all enrichments it depends on
+	if sc, err := severity.New(
+		severity.WithConfig(hc.config.Severity, hc.config),
+		severity.WithDataDir(hc.dataDir),
+	); err != nil {
+		log.Fatalf("Error initializing severity scoring: %s", err.Error())
+	} else if sc.Enabled {
+		hc.bus.Subscribe(hc.tracer.Channel(tracing.StageEnricher, "severity", sc))
+	}
+
+	if err := hc.privacy(); err != nil {
+		log.Fatalf("Error initializing privacy mode: %s", err.Error())
+	}
+
+	// events are signed after all enrichments, before the channels
+	if err := hc.signing(); err != nil {
+		log.Fatalf("Error initializing signing: %s", err.Error())
+	}
+
+	hc.bus.Subscribe(hc.stats)
+
+	if t, err := hc.trendStore(); err != nil {
+		log.Error("Error initializing trends: %s", err.Error())
+	} else {
+		hc.trends = t
+
+		hc.schedule(sched, &scheduler.Job{
+			Name:     "trends",
+			Interval: t.FlushInterval.Duration(),
+			Run:      t.Flush,
+		})
+	}
+
+	if err := hc.serveMetrics(); err != nil {
+		log.Fatalf("Error initializing metrics: %s", err.Error())
+	}
+
+	if g, err := governor.New(
+		governor.WithConfig(hc.config.Governor, hc.config),
+		governor.WithDataDir(hc.dataDir),
+		governor.WithChannel(hc.bus),
+	); err != nil {
+		log.Fatalf("Error initializing governor: %s", err.Error())
+	} else if g.Enabled() {
+		hc.governor = g
+
+		hc.schedule(sched, &scheduler.Job{
+			Name:     "governor",
+			Interval: g.Interval.Duration(),
+			Run:      g.Check,
+		})
+	}
+
+	ct, err := credentials.New(
+		credentials.WithConfig(hc.config.Credentials, hc.config),
+		credentials.WithChannel(hc.bus),
+	)
+	if err != nil {
+		log.Fatalf("Error initializing credential tracking: %s", err.Error())
+	}
+
+	hc.bus.Subscribe(ct)
+
+	// canaries are planted when the services are created
+	if err := hc.canaries(); err != nil {
+		log.Error("Error initializing canaries: %s", err.Error())
+	}
+
+	// content packs are loaded before the services are created, and
+	// swapped when the archives change
+	if cm, err := content.New(
+		content.WithConfig(hc.config.Content, hc.config),
+		content.WithDataDir(hc.dataDir),
+	); err != nil {
+		log.Error("Error parsing configuration of content: %s", err.Error())
+	} else {
+		if err := cm.Reload(); err != nil {
+			log.Error("Error loading content packs: %s", err.Error())
+		}
+
+		content.SetDefault(cm)
+
+		hc.schedule(sched, &scheduler.Job{
+			Name:     "content",
+			Interval: cm.ReloadInterval.Duration(),
+			Run:      cm.Reload,
+		})
+	}
+
+	hc.schedule(sched, &scheduler.Job{
+		Name:     "credentials",
+		Interval: ct.Interval.Duration(),
+		Run: func() error {
+			ct.Flush()
+			return nil
+		},
+	})
+
+	c2t, err := hc.c2()
+	if err != nil {
+		log.Error("Error initializing c2 tracking: %s", err.Error())
+	} else if c2t != nil {
+		hc.schedule(sched, &scheduler.Job{
+			Name:     "c2",
+			Interval: c2t.Interval.Duration(),
+			Run:      c2t.Check,
+		})
+	}
+
+	transcriptOptions := []func(*transcript.Store) error{
+		transcript.WithConfig(hc.config.Transcripts, hc.config),
+		transcript.WithDataDir(hc.dataDir),
+	}
+
+	// the transcripts keep the sources of the sessions as well
+	if hc.anonymizer != nil && hc.anonymizer.Pseudonymizes("source-ip") {
+		transcriptOptions = append(transcriptOptions, transcript.WithPseudonymizer(hc.anonymizer.Pseudonym))
+	}
+
+	ts, err := transcript.New(transcriptOptions...)
+	if err != nil {
+		log.Fatalf("Error initializing transcripts: %s", err.Error())
+	}
+
+	if ts.Enabled {
+		hc.transcripts = ts
+	}
+
+	w, err := web.New(
+		web.WithEventBus(hc.bus),
+		web.WithDataDir(hc.dataDir),
+		web.WithStats(hc.stats),
+		web.WithTrends(hc.trends),
+		web.WithCredentials(ct),
+		web.WithC2(c2t),
+		web.WithScheduler(sched),
+		web.WithTranscripts(ts),
+		web.WithTracer(hc.tracer),
+		web.WithConfig(hc.config.Web, hc.config),
+	)
+	if err != nil {
+		log.Error("Error parsing configuration of web: %s", err.Error())
+	} else {
+		w.Start()
+
+		hc.web = w
+
+		// the databases downloaded when the web interface started
+		if err := geo.Reload(); err != nil {
+			log.Errorf("Error opening GeoLite2 databases: %s", err.Error())
+		}
+
+		if w.Enabled && w.GeoIP.Interval > 0 {
+			hc.schedule(sched, &scheduler.Job{
+				Name:     "geoip",
+				Interval: w.GeoIP.Interval.Duration(),
+				Run: func() error {
+					if err := w.RefreshGeoIP(); err != nil {
+						return err
+					}
+
+					return geo.Reload()
+				},
+			})
+		}
+	}
+
 	channels := map[string]pushers.Channel{}
 	isChannelUsed := make(map[string]bool)
 	// sane defaults!
 
 	for key, s := range hc.config.Channels {
 		x := struct {
-			Type string `toml:"type"`
+			Type          string             `toml:"type"`
+			Redact        pushers.Redaction  `toml:"redact"`
+			Transform     pushers.Transform  `toml:"transform"`
+			Mute          []pushers.MuteRule `toml:"mute"`
+			SchemaVersion int                `toml:"schema-version"`
+			Filter        string             `toml:"filter"`
+			Spool         pushers.Spool      `toml:"spool"`
+			Dedup         pushers.Dedup      `toml:"dedup"`
 		}{}
 
 		err := hc.config.PrimitiveDecode(s, &x)
@@ -368,9 +1039,83 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			log.Error("Channel %s not supported on platform (%s)", x.Type, key)
 		} else if d, err := channelFunc(
 			pushers.WithConfig(s, hc.config),
+			pushers.WithChannel(hc.bus),
 		); err != nil {
 			log.Fatalf("Error initializing channel %s(%s): %s", key, x.Type, err)
 		} else {
+			// the layers of the channel are traced by their name, the
+			// events that reach the channel itself are delivered
+			d = hc.tracer.Channel(tracing.StageDelivery, key, d)
+
+			layer := func(name string) {
+				d = hc.tracer.Channel(tracing.StageChannel, key+"/"+name, d)
+			}
+
+			// the spool holds the events while the channel is
+			// unavailable, as they are delivered
+			if x.Spool.Enabled {
+				if d, err = pushers.SpoolChannel(d, x.Type, filepath.Join(hc.dataDir, "spool", key), x.Spool); err != nil {
+					log.Fatalf("Error initializing channel %s(%s): %s", key, x.Type, err)
+				}
+
+				layer("spool")
+			}
+
+			if x.SchemaVersion > event.SchemaVersion {
+				log.Fatalf("Error initializing channel %s(%s): unknown schema version %d", key, x.Type, x.SchemaVersion)
+			} else if x.SchemaVersion != 0 && x.SchemaVersion != event.SchemaVersion {
+				d = pushers.SchemaChannel(d, x.SchemaVersion)
+				layer("schema")
+			}
+
+			// the transform shapes the event for the consumer, after
+			// redaction and before the downgrade
+			if !x.Transform.Empty() {
+				if d, err = pushers.TransformChannel(d, x.Transform); err != nil {
+					log.Fatalf("Error initializing transform of channel %s(%s): %s", key, x.Type, err)
+				}
+
+				layer("transform")
+			}
+
+			// fields are redacted by their current name, before the downgrade
+			if !x.Redact.Empty() {
+				d = pushers.RedactChannel(d, x.Redact)
+				layer("redact")
+			}
+
+			// duplicates are consolidated after the mute rules and
+			// filters, with the fields as sent
+			if x.Dedup.Enabled {
+				d = pushers.DedupChannel(d, x.Dedup)
+				layer("dedup")
+			}
+
+			if len(x.Mute) > 0 {
+				if d, err = pushers.MuteChannel(d, x.Mute); err != nil {
+					log.Fatalf("Error initializing mute rules of channel %s(%s): %s", key, x.Type, err)
+				}
+
+				layer("mute")
+			}
+
+			// the expression is evaluated on the event as sent, before
+			// redaction and the downgrade
+			if x.Filter != "" {
+				fn, err := pushers.ExpressionFilterFunc(x.Filter)
+				if err != nil {
+					log.Fatalf("Error initializing filter of channel %s(%s): %s", key, x.Type, err)
+				}
+
+				d = pushers.FilterChannel(d, hc.tracer.Filter(key+"/filter", fn))
+			}
+
+			// the events are sampled while shedding load
+			if hc.governor != nil {
+				d = hc.governor.Channel(d)
+				layer("governor")
+			}
+
 			channels[key] = d
 			isChannelUsed[key] = false
 		}
@@ -381,11 +1126,17 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 	bc := pushers.NewBusChannel()
 	hc.bus.Subscribe(bc)
 
-	for _, s := range hc.config.Filters {
+	for i, s := range hc.config.Filters {
 		x := struct {
 			Channels   []string `toml:"channel"`
 			Services   []string `toml:"services"`
 			Categories []string `toml:"categories"`
+
+			// Severity is the minimum severity of the events
+			Severity string `toml:"severity"`
+
+			// Expression is a filter expression, eg. destination.port == 22
+			Expression string `toml:"expression"`
 		}{}
 
 		err := hc.config.PrimitiveDecode(s, &x)
@@ -394,6 +1145,19 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			continue
 		}
 
+		if x.Severity != "" && severity.Compare(x.Severity, severity.Info) < 0 {
+			log.Error("Error parsing configuration of filter: unknown severity %s", x.Severity)
+			continue
+		}
+
+		var expression pushers.FilterFunc
+		if x.Expression != "" {
+			if expression, err = pushers.ExpressionFilterFunc(x.Expression); err != nil {
+				log.Error("Error parsing configuration of filter: %s", err.Error())
+				continue
+			}
+		}
+
 		for _, name := range x.Channels {
 			channel, ok := channels[name]
 			if !ok {
@@ -404,12 +1168,27 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			isChannelUsed[name] = true
 			channel = pushers.TokenChannel(channel, hc.token)
 
+			// the filters are traced by the filter and the channel
+			prefix := fmt.Sprintf("filter[%d]/%s/", i, name)
+
 			if len(x.Categories) != 0 {
-				channel = pushers.FilterChannel(channel, pushers.RegexFilterFunc("category", x.Categories))
+				channel = pushers.FilterChannel(channel, hc.tracer.Filter(prefix+"categories", pushers.RegexFilterFunc("category", x.Categories)))
 			}
 
 			if len(x.Services) != 0 {
-				channel = pushers.FilterChannel(channel, pushers.RegexFilterFunc("service", x.Services))
+				channel = pushers.FilterChannel(channel, hc.tracer.Filter(prefix+"services", pushers.RegexFilterFunc("service", x.Services)))
+			}
+
+			if x.Severity != "" {
+				min := x.Severity
+
+				channel = pushers.FilterChannel(channel, hc.tracer.Filter(prefix+"severity", func(e event.Event) bool {
+					return severity.Compare(e.Get("severity"), min) >= 0
+				}))
+			}
+
+			if expression != nil {
+				channel = pushers.FilterChannel(channel, hc.tracer.Filter(prefix+"expression", expression))
 			}
 
 			if err := hc.bus.Subscribe(channel); err != nil {
@@ -418,6 +1197,76 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 		}
 	}
 
+	// initialize reports, reports are delivered to the configured channels
+	// directly, without passing the filters.
+	for key, s := range hc.config.Reports {
+		x := struct {
+			Channels []string `toml:"channels"`
+		}{}
+
+		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
+			log.Error("Error parsing configuration of report %s: %s", key, err.Error())
+			continue
+		}
+
+		options := []func(*reporter.Reporter) error{
+			reporter.WithConfig(s, hc.config),
+			reporter.WithDataDir(hc.dataDir),
+			reporter.WithTrends(hc.trends),
+		}
+
+		for _, name := range x.Channels {
+			channel, ok := channels[name]
+			if !ok {
+				log.Error("Could not find channel %s for report %s", name, key)
+				continue
+			}
+
+			isChannelUsed[name] = true
+			options = append(options, reporter.WithChannel(pushers.TokenChannel(channel, hc.token)))
+		}
+
+		r, err := reporter.New(key, options...)
+		if err != nil {
+			log.Fatalf("Error initializing report %s: %s", key, err)
+		}
+
+		if err := hc.bus.Subscribe(r); err != nil {
+			log.Error("Could not add report %s to bus: %s", key, err.Error())
+			continue
+		}
+
+		hc.schedule(sched, &scheduler.Job{
+			Name:     "report." + key,
+			Interval: r.Interval.Duration(),
+			Run: func() error {
+				return r.Generate(time.Now())
+			},
+		})
+
+		log.Infof("Configured report %s", key)
+	}
+
+	if j, err := retention.New(
+		retention.WithConfig(hc.config.Retention, hc.config),
+		retention.WithDataDir(hc.dataDir),
+		retention.WithChannel(hc.bus),
+	); err != nil {
+		log.Fatalf("Error initializing retention: %s", err.Error())
+	} else if len(j.Policies) > 0 {
+		hc.schedule(sched, &scheduler.Job{
+			Name:      "retention",
+			Interval:  j.Interval.Duration(),
+			Immediate: true,
+			Run: func() error {
+				j.Clean()
+				return nil
+			},
+		})
+	}
+
+	go sched.Run(ctx.Done())
+
 	for name, isUsed := range isChannelUsed {
 		if !isUsed {
 			log.Warningf("Channel %s is unused. Did you forget to add a filter?", name)
@@ -453,26 +1302,39 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			log.Fatalf("Error initializing director %s(%s): %s", key, x.Type, err)
 		} else {
 			directors[key] = d
+
+			if cc, ok := d.(director.ContainerCounter); ok {
+				directorContainers.With(key).SetFunc(func() float64 {
+					return float64(cc.ContainerCount())
+				})
+			}
 		}
 	}
 
-	// initialize listener
-	x := struct {
-		Type string `toml:"type"`
-	}{}
+	var enabledDirectorNames []string
+	for key := range directors {
+		enabledDirectorNames = append(enabledDirectorNames, key)
+	}
 
-	if err := hc.config.PrimitiveDecode(hc.config.Listener, &x); err != nil {
-		log.Error("Error parsing configuration of listener: %s", err.Error())
-		return
+	p, err := hc.personality()
+	if err != nil {
+		log.Fatalf("Error initializing personality: %s", err.Error())
 	}
 
-	if x.Type == "" {
-		fmt.Println(color.RedString("Listener not set"))
+	rc := DefaultRotationConfig
+	if err := hc.config.PrimitiveDecode(hc.config.Rotation, &rc); err != nil {
+		log.Fatalf("Error parsing configuration of rotation: %s", err.Error())
 	}
 
-	var enabledDirectorNames []string
-	for key := range directors {
-		enabledDirectorNames = append(enabledDirectorNames, key)
+	if rc.Enabled() {
+		if hc.rotation, err = newRotation(rc); err != nil {
+			log.Fatalf("Error initializing rotation: %s", err.Error())
+		}
+
+		// the rotated personalities replace the configured personality
+		if rp := hc.rotation.Personality(); rp != nil {
+			p = rp
+		}
 	}
 
 	serviceList := make(map[string]*ServiceMap)
@@ -483,7 +1345,16 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			Type     string `toml:"type"`
 			Director string `toml:"director"`
 			Port     string `toml:"port"`
-		}{}
+
+			SessionConfig
+
+			Latency LatencyConfig `toml:"latency"`
+
+			Labels map[string]string `toml:"labels"`
+		}{
+			SessionConfig: DefaultSessionConfig,
+			Latency:       DefaultLatencyConfig,
+		}
 
 		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
 			log.Error("Error parsing configuration of service %s: %s", key, err.Error())
@@ -495,56 +1366,130 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			continue
 		}
 
-		// individual configuration per service
-		options := []services.ServicerFunc{
-			services.WithChannel(hc.bus),
-			services.WithConfig(s, hc.config),
+		if err := x.Latency.Validate(); err != nil {
+			log.Error("Error parsing configuration of service %s: %s", key, err.Error())
+			continue
+		}
+
+		if err := validateLabels(x.Labels); err != nil {
+			log.Error("Error parsing configuration of service %s: %s", key, err.Error())
+			continue
 		}
 
+		var d director.Director
+
 		if x.Director == "" {
-		} else if d, ok := directors[x.Director]; ok {
-			options = append(options, services.WithDirector(d))
+		} else if dd, ok := directors[x.Director]; ok {
+			d = dd
 		} else {
 			log.Error(color.RedString("Could not find director=%s for service=%s. Enabled directors: %s", x.Director, key, strings.Join(enabledDirectorNames, ", ")))
 			continue
 		}
 
-		fn, ok := services.Get(x.Type)
-		if !ok {
+		if _, ok := services.Get(x.Type); !ok {
 			log.Error(color.RedString("Could not find type %s for service %s", x.Type, key))
 			continue
 		}
 
-		service := fn(options...)
-		serviceList[key] = &ServiceMap{
-			Service: service,
+		sm := &ServiceMap{
 			Name:    key,
 			Type:    x.Type,
+			Session: x.SessionConfig,
+			Latency: x.Latency,
+			Labels:  x.Labels,
+
+			config:   s,
+			director: d,
 		}
+
+		if p != nil {
+			if _, ok := p.Defaults(x.Type); !ok {
+				log.Warningf("Service %s (%s) doesn't match personality %s", key, x.Type, p.Name)
+			}
+		}
+
+		sm.SetServicer(hc.newServicer(sm, p))
+
+		serviceList[key] = sm
 		isServiceUsed[key] = false
 		log.Infof("Configured service %s (%s)", x.Type, key)
 	}
 
-	listenerFunc, ok := listener.Get(x.Type)
-	if !ok {
-		fmt.Println(color.RedString("Listener %s not support on platform", x.Type))
-		return
+	// initialize listeners, the [listener] section is the default listener,
+	// additional listeners can be defined in [listeners.<name>] sections.
+	listenerConfigs := map[string]toml.Primitive{
+		DefaultListener: hc.config.Listener,
 	}
 
-	l, err := listenerFunc(
-		listener.WithChannel(hc.bus),
-		listener.WithConfig(hc.config.Listener, hc.config),
-	)
-	if err != nil {
-		log.Fatalf("Error initializing listener %s: %s", x.Type, err)
+	for key, s := range hc.config.Listeners {
+		if key == DefaultListener {
+			log.Errorf("Listener name %s is reserved for the [listener] section", key)
+			continue
+		}
+
+		listenerConfigs[key] = s
+	}
+
+	hc.listeners = map[string]*ListenerMap{}
+	for key, s := range listenerConfigs {
+		x := struct {
+			Type string `toml:"type"`
+		}{}
+
+		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
+			log.Error("Error parsing configuration of listener %s: %s", key, err.Error())
+			continue
+		}
+
+		if x.Type == "" {
+			if key == DefaultListener && len(hc.config.Listeners) > 0 {
+				continue
+			}
+
+			fmt.Println(color.RedString("Listener %s not set", key))
+			continue
+		}
+
+		listenerFunc, ok := listener.Get(x.Type)
+		if !ok {
+			fmt.Println(color.RedString("Listener %s not support on platform (%s)", x.Type, key))
+			continue
+		}
+
+		l, err := listenerFunc(
+			listener.WithChannel(hc.bus),
+			listener.WithConfig(s, hc.config),
+		)
+		if err != nil {
+			log.Fatalf("Error initializing listener %s(%s): %s", key, x.Type, err)
+		}
+
+		hc.listeners[key] = &ListenerMap{
+			Listener: l,
+			Name:     key,
+			Type:     x.Type,
+			ports:    make(map[net.Addr][]*ServiceMap),
+			tls:      make(map[net.Addr]*tlsWrapper),
+			routes:   make(map[net.Addr][]*route),
+		}
+
+		log.Infof("Configured listener %s (%s)", x.Type, key)
+	}
+
+	if len(hc.listeners) == 0 {
+		fmt.Println(color.RedString("No listeners configured"))
+		return
 	}
 
-	hc.ports = make(map[net.Addr][]*ServiceMap)
 	for _, s := range hc.config.Ports {
 		x := struct {
-			Port     string   `toml:"port"`
-			Ports    []string `toml:"ports"`
-			Services []string `toml:"services"`
+			Port     string     `toml:"port"`
+			Ports    []string   `toml:"ports"`
+			Services []string   `toml:"services"`
+			Listener string     `toml:"listener"`
+			TLS      *TLSConfig `toml:"tls"`
+
+			Routes []RouteConfig `toml:"route"`
 		}{}
 
 		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
@@ -552,6 +1497,16 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			continue
 		}
 
+		if x.Listener == "" {
+			x.Listener = DefaultListener
+		}
+
+		lm, ok := hc.listeners[x.Listener]
+		if !ok {
+			log.Errorf("Unknown listener '%s' for port configuration", x.Listener)
+			continue
+		}
+
 		var ports []string
 		if x.Ports != nil {
 			ports = x.Ports
@@ -570,12 +1525,51 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			log.Warning("No services defined for port(s) " + strings.Join(ports, ", "))
 		}
 
+		if p != nil && x.TLS != nil && x.TLS.Certificate == "" && x.TLS.CommonName == "" {
+			x.TLS.CommonName = p.TLSCommonName()
+		}
+
+		if p != nil && x.TLS != nil && x.TLS.Certificate == "" && x.TLS.Profile == "" {
+			x.TLS.Profile = p.CertificateProfile
+		}
+
+		var tw *tlsWrapper
+		if x.TLS != nil {
+			var err error
+			if tw, err = newTLSWrapper(x.TLS, hc.bus); err != nil {
+				log.Errorf("Error configuring tls for port(s) %s: %s", strings.Join(ports, ", "), err.Error())
+				continue
+			}
+		}
+
+		var routes []*route
+		for _, rc := range x.Routes {
+			r, err := newRoute(rc, func(name string) (*ServiceMap, bool) {
+				sm, ok := serviceList[name]
+				if ok {
+					isServiceUsed[name] = true
+				}
+
+				return sm, ok
+			})
+			if err != nil {
+				log.Errorf("Error configuring route for port(s) %s: %s", strings.Join(ports, ", "), err.Error())
+				continue
+			}
+
+			routes = append(routes, r)
+		}
+
 		for _, portStr := range ports {
-			addr, _, _, err := ToAddr(portStr)
+			addr, proto, port, err := ToAddr(portStr)
 			if err != nil {
 				log.Error("Error parsing port string: %s", err.Error())
 				continue
 			}
+
+			if p != nil && !p.HasPort(proto, port) {
+				log.Warningf("Port %s isn't usually open on personality %s", portStr, p.Name)
+			}
 			if addr == nil {
 				log.Error("Failed to bind: addr is nil")
 				continue
@@ -598,7 +1592,7 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			}
 
 			found := false
-			for k, _ := range hc.ports {
+			for k := range lm.ports {
 				if !compareAddr(k, addr) {
 					continue
 				}
@@ -611,16 +1605,24 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 				continue
 			}
 
-			hc.ports[addr] = servicePtrs
+			lm.ports[addr] = servicePtrs
 
-			a, ok := l.(listener.AddAddresser)
+			if tw != nil {
+				lm.tls[addr] = tw
+			}
+
+			if len(routes) > 0 {
+				lm.routes[addr] = routes
+			}
+
+			a, ok := lm.Listener.(listener.AddAddresser)
 			if !ok {
 				log.Error("Listener error")
 				continue
 			}
 			a.AddAddress(addr)
 
-			log.Infof("Configured port %s/%s", addr.Network(), addr.String())
+			log.Infof("Configured port %s/%s (%s)", addr.Network(), addr.String(), lm.Name)
 		}
 	}
 
@@ -630,43 +1632,99 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 		}
 	}
 
+	if hc.rotation != nil {
+		for name := range hc.rotation.rotated {
+			if _, ok := serviceList[name]; !ok {
+				log.Warningf("Unknown service %s in rotation", name)
+			}
+		}
+
+		hc.rotation.rebuild = func(p *personality.Personality) {
+			for _, sm := range serviceList {
+				sm.SetServicer(hc.newServicer(sm, p))
+			}
+		}
+
+		hc.schedule(sched, &scheduler.Job{
+			Name:     "rotation",
+			Interval: rc.Interval.Duration(),
+			Run:      hc.rotation.Rotate,
+		})
+	}
+
 	if len(hc.config.Undecoded()) != 0 {
 		log.Warningf("Unrecognized keys in configuration: %v", hc.config.Undecoded())
 	}
 
-	if err := l.Start(ctx); err != nil {
-		fmt.Println(color.RedString("Error starting listener: %s", err.Error()))
-		return
-	}
+	hc.serve(ctx, hc.handle)
+}
 
-	incoming := make(chan net.Conn)
+// serve starts the listeners and dispatches their connections to handle,
+// until the context is done. A listener that fails to start or accept is
+// stopped, the other listeners keep serving.
+func (hc *Honeytrap) serve(ctx context.Context, handle func(*ListenerMap, net.Conn)) {
+	type incomingConn struct {
+		net.Conn
 
-	go func() {
-		for {
-			conn, err := l.Accept()
-			if err != nil {
-				panic(err)
-			}
+		lm *ListenerMap
+	}
 
-			incoming <- conn
+	incoming := make(chan incomingConn)
 
-			// in case of goroutine starvation
-			// with many connection and single procs
-			runtime.Gosched()
+	started := 0
+
+	for _, lm := range hc.listeners {
+		if err := lm.Listener.Start(ctx); err != nil {
+			fmt.Println(color.RedString("Error starting listener %s: %s", lm.Name, err.Error()))
+			continue
 		}
-	}()
+
+		started++
+
+		go func(lm *ListenerMap) {
+			for {
+				conn, err := lm.Listener.Accept()
+				if err != nil {
+					log.Errorf("Error accepting connection of listener %s, listener stopped: %s", lm.Name, err.Error())
+					return
+				}
+
+				if hc.governor != nil && !hc.governor.Accept() {
+					log.Debug("Refused connection for %s => %s: resources exceeded", conn.RemoteAddr(), conn.LocalAddr())
+					conn.Close()
+					continue
+				}
+
+				select {
+				case incoming <- incomingConn{conn, lm}:
+				case <-ctx.Done():
+					conn.Close()
+					return
+				}
+
+				// in case of goroutine starvation
+				// with many connection and single procs
+				runtime.Gosched()
+			}
+		}(lm)
+	}
+
+	if started == 0 {
+		fmt.Println(color.RedString("No listeners started"))
+		return
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case conn := <-incoming:
-			go hc.handle(conn)
+		case ic := <-incoming:
+			go handle(ic.lm, ic.Conn)
 		}
 	}
 }
 
-func (hc *Honeytrap) handle(conn net.Conn) {
+func (hc *Honeytrap) handle(lm *ListenerMap, conn net.Conn) {
 	defer func() {
 		if err := recover(); err != nil {
 			trace := make([]byte, 1024)
@@ -679,6 +1737,11 @@ func (hc *Honeytrap) handle(conn net.Conn) {
 
 	defer conn.Close()
 
+	connectionsTotal.With(lm.Name).Inc()
+
+	connectionsActive.With(lm.Name).Inc()
+	defer connectionsActive.With(lm.Name).Dec()
+
 	defer func() {
 		if r := recover(); r != nil {
 			message := event.Message("%+v", r)
@@ -699,10 +1762,37 @@ func (hc *Honeytrap) handle(conn net.Conn) {
 	log.Debug("Accepted connection for %s => %s", conn.RemoteAddr(), conn.LocalAddr())
 	defer log.Debug("Disconnected connection for %s => %s", conn.RemoteAddr(), conn.LocalAddr())
 
+	// the syn packet is read from the accepted connection, before it is
+	// wrapped
+	var fingerprintOptions []event.Option
+	if hc.fingerprinter != nil {
+		fingerprintOptions = hc.fingerprinter.Fingerprint(conn)
+	}
+
+	// policies are applied before the connection is dispatched, dropped
+	// connections are closed without a response
+	conn, p := hc.applyPolicy(conn)
+	if conn == nil {
+		return
+	}
+
+	// ports with tls configured will be decrypted before passing the
+	// connection to the services of the port.
+	var connOptions event.Option
+
+	if tw := lm.tlsWrapper(conn); tw == nil {
+	} else if tlsConn, err := tw.Wrap(conn); err != nil {
+		log.Debug("Error during tls handshake for %s => %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err.Error())
+		return
+	} else {
+		connOptions = tlsConn.Options()
+		conn = tlsConn
+	}
+
 	/* conn is the original connection. newConn can be either the same
 	 * connection, or a wrapper in the form of a PeekConnection.
 	 */
-	sm, newConn, err := hc.findService(conn)
+	sm, newConn, err := hc.findService(lm, conn, p)
 	if sm == nil {
 		log.Debug("No suitable handler for %s => %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err.Error())
 		return
@@ -710,17 +1800,95 @@ func (hc *Honeytrap) handle(conn net.Conn) {
 
 	log.Debug("Handling connection for %s => %s %s(%s)", conn.RemoteAddr(), conn.LocalAddr(), sm.Name, sm.Type)
 
-	newConn = TimeoutConn(newConn, time.Second*30)
+	sc := SessionConn(newConn, sm.Session)
+	defer sc.Close()
+
+	newConn = sc
+
+	transcriptID := ""
+
+	// recording is paused when the captures exceed their disk budget
+	if hc.transcripts != nil && (hc.governor == nil || hc.governor.Capture()) {
+		tc := hc.transcripts.Record(sc, sm.Name)
+		defer tc.Close()
+
+		transcriptID = tc.ID()
+		newConn = tc
+	}
+
+	// the events of the connection are stamped with the session id, until
+	// the service ended event has been sent
+	sessionID := ""
+
+	if hc.correlator != nil {
+		sessionID = hc.correlator.Open(conn, transcriptID, fingerprintOptions...)
+		defer hc.correlator.Close(conn)
+	}
+
+	if sm.Latency.Enabled() {
+		newConn = LatencyConn(newConn, sm.Latency)
+	}
+
+	// the traffic to the decoys is scanned for attackers pivoting to
+	// other systems through the decoy
+	if sm.director != nil {
+		newConn = PivotConn(newConn, func(a pivot.Attempt) {
+			sm.channel(hc.bus).Send(event.New(
+				append(a.Options(),
+					event.Sensor("honeytrap"),
+					event.Service(sm.Name),
+					event.SourceAddr(conn.RemoteAddr()),
+					event.DestinationAddr(conn.LocalAddr()),
+				)...,
+			))
+		})
+	}
+
+	if connOptions != nil {
+		newConn = event.WithConn(newConn, connOptions)
+	}
 
 	ctx := context.Background()
-	if err := sm.Service.Handle(ctx, newConn); err != nil {
+	if err := sm.Servicer().Handle(ctx, newConn); err != nil {
 		log.Errorf(color.RedString("Error handling service: %s: %s", sm.Name, err.Error()))
 	}
+
+	options := []event.Option{
+		event.Sensor("honeytrap"),
+		event.Category(sm.Type),
+		event.ServiceEnded,
+		event.Service(sm.Name),
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("session.duration", sc.Duration().Seconds()),
+		event.Custom("session.bytes-read", sc.BytesRead()),
+		event.Custom("session.bytes-written", sc.BytesWritten()),
+		event.Custom("session.timeout", sc.Timeout()),
+	}
+
+	if sessionID != "" {
+		options = append(options, event.Custom("session.id", sessionID))
+	}
+
+	if transcriptID != "" {
+		options = append(options, event.Custom("session.transcript", transcriptID))
+	}
+
+	sm.channel(hc.bus).Send(event.New(options...))
 }
 
 // Stop will stop Honeytrap
 func (hc *Honeytrap) Stop() {
 	hc.profiler.Stop()
 
+	if hc.web != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := hc.web.Stop(ctx); err != nil {
+			log.Errorf("Error stopping web interface: %s", err.Error())
+		}
+	}
+
 	fmt.Println(color.YellowString("Honeytrap stopped."))
 }
@@ -14,7 +14,11 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestBigPortToAddr(t *testing.T) {
@@ -48,3 +52,146 @@ func TestUnknownProtoToAddr(t *testing.T) {
 		t.Errorf("No error thrown with incorrect protocol")
 	}
 }
+
+func TestIPv6ToAddr(t *testing.T) {
+	addr, proto, port, err := ToAddr("tcp/[::1]:8022")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr.String() != "[::1]:8022" {
+		t.Errorf("Expected [::1]:8022 but got %s", addr)
+	}
+	if proto != "tcp" {
+		t.Errorf("Expected tcp but got %s", proto)
+	}
+	if port != 8022 {
+		t.Errorf("Expected 8022 but got %d", port)
+	}
+}
+
+func TestUnbracketedIPv6ToAddr(t *testing.T) {
+	_, _, _, err := ToAddr("udp/::1:53")
+	if err == nil {
+		t.Errorf("No error thrown with unbracketed ipv6 address")
+	}
+}
+
+func TestCompareAddrWildcard(t *testing.T) {
+	conn := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 22}
+
+	for _, s := range []string{"tcp/22", "tcp/[::]:22", "tcp/0.0.0.0:22", "tcp/[2001:db8::1]:22"} {
+		addr, _, _, err := ToAddr(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !compareAddr(addr, conn) {
+			t.Errorf("Expected %s to match %s", s, conn)
+		}
+	}
+
+	addr, _, _, _ := ToAddr("tcp/[2001:db8::2]:22")
+	if compareAddr(addr, conn) {
+		t.Errorf("Expected %s not to match %s", addr, conn)
+	}
+}
+
+type testListener struct {
+	err   error
+	conns chan net.Conn
+}
+
+func (l *testListener) Start(ctx context.Context) error {
+	return l.err
+}
+
+func (l *testListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, errors.New("listener closed")
+	}
+
+	return c, nil
+}
+
+// addrConn is a connection to the local address.
+type addrConn struct {
+	net.Conn
+
+	local net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}
+}
+
+func TestServeListeners(t *testing.T) {
+	port := &net.TCPAddr{Port: 22}
+
+	listenerMap := func(name string, l *testListener) *ListenerMap {
+		return &ListenerMap{
+			Listener: l,
+			Name:     name,
+			ports: map[net.Addr][]*ServiceMap{
+				port: {{Name: "ssh-" + name}},
+			},
+		}
+	}
+
+	public := &testListener{conns: make(chan net.Conn)}
+	internal := &testListener{conns: make(chan net.Conn)}
+	broken := &testListener{conns: make(chan net.Conn)}
+
+	hc := &Honeytrap{
+		listeners: map[string]*ListenerMap{
+			"public":   listenerMap("public", public),
+			"internal": listenerMap("internal", internal),
+			"broken":   listenerMap("broken", broken),
+			"failed":   listenerMap("failed", &testListener{err: errors.New("permission denied")}),
+		},
+	}
+
+	handled := make(chan string)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hc.serve(ctx, func(lm *ListenerMap, conn net.Conn) {
+		sm, _, err := hc.findService(lm, conn, nil)
+		if err != nil {
+			handled <- err.Error()
+			return
+		}
+
+		handled <- sm.Name
+	})
+
+	// the failing listener doesn't stop the other listeners
+	close(broken.conns)
+
+	for _, test := range []struct {
+		l        *testListener
+		expected string
+	}{
+		{public, "ssh-public"},
+		{internal, "ssh-internal"},
+		{public, "ssh-public"},
+	} {
+		c, _ := net.Pipe()
+		test.l.conns <- addrConn{Conn: c, local: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}}
+
+		select {
+		case name := <-handled:
+			if name != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected connection to be handled by %s", test.expected)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// labelName matches the names of the labels of services, the names become
+// part of the field names of the events.
+var labelName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// validateLabels returns an error if the name of a label is invalid.
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelName.MatchString(name) {
+			return fmt.Errorf("Invalid label name %q, names consist of lowercase letters, digits, - and _", name)
+		}
+	}
+
+	return nil
+}
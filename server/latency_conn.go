@@ -0,0 +1,159 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+// Latency distributions.
+const (
+	LatencyFixed    = "fixed"
+	LatencyNormal   = "normal"
+	LatencyLongTail = "long-tail"
+)
+
+// LatencyConfig contains the artificial latency of the responses of a
+// service, decoys answering instantly are easily recognized.
+type LatencyConfig struct {
+	// Distribution is fixed, normal or long-tail, latency is disabled
+	// when empty
+	Distribution string `toml:"distribution"`
+
+	// Delay is the fixed delay, the mean of the normal distribution or the
+	// minimum of the long-tail distribution
+	Delay config.Delay `toml:"delay"`
+
+	// Jitter is the standard deviation of the normal distribution
+	Jitter config.Delay `toml:"jitter"`
+
+	// Shape is the shape of the long-tail (pareto) distribution, lower
+	// values give a longer tail
+	Shape float64 `toml:"shape"`
+
+	// Max caps the delay, slowing scanners down without tarpitting them
+	Max config.Delay `toml:"max"`
+}
+
+// DefaultLatencyConfig disables latency.
+var DefaultLatencyConfig = LatencyConfig{
+	Shape: 1.5,
+	Max:   config.Delay(time.Second * 10),
+}
+
+// Enabled returns true if latency has been configured.
+func (lc LatencyConfig) Enabled() bool {
+	return lc.Distribution != ""
+}
+
+// Validate returns an error for unknown distributions.
+func (lc LatencyConfig) Validate() error {
+	switch lc.Distribution {
+	case "", LatencyFixed, LatencyNormal:
+	case LatencyLongTail:
+		if lc.Shape <= 0 {
+			return fmt.Errorf("Shape of the long-tail latency should be positive")
+		}
+	default:
+		return fmt.Errorf("Unknown latency distribution %s", lc.Distribution)
+	}
+
+	return nil
+}
+
+// Sample returns a delay of the distribution.
+func (lc LatencyConfig) Sample(r *rand.Rand) time.Duration {
+	delay := float64(lc.Delay.Duration())
+
+	switch lc.Distribution {
+	case LatencyNormal:
+		delay += r.NormFloat64() * float64(lc.Jitter.Duration())
+	case LatencyLongTail:
+		// pareto distributed, the minimum is the delay
+		delay = delay / math.Pow(1-r.Float64(), 1/lc.Shape)
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	if max := float64(lc.Max.Duration()); max > 0 && delay > max {
+		delay = max
+	}
+
+	return time.Duration(delay)
+}
+
+// LatencyConn returns a connection which delays the responses of the
+// service. The first write after data has been read is delayed, as is the
+// first write of the connection (eg. a banner), a response written in
+// multiple writes is delayed only once.
+func LatencyConn(conn net.Conn, lc LatencyConfig) net.Conn {
+	return &latencyConn{
+		Conn:    conn,
+		config:  lc,
+		pending: true,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		sleep:   time.Sleep,
+	}
+}
+
+type latencyConn struct {
+	net.Conn
+
+	config LatencyConfig
+
+	m       sync.Mutex
+	pending bool
+	rand    *rand.Rand
+
+	sleep func(time.Duration)
+}
+
+func (c *latencyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	if n > 0 {
+		c.m.Lock()
+		c.pending = true
+		c.m.Unlock()
+	}
+
+	return n, err
+}
+
+func (c *latencyConn) Write(b []byte) (int, error) {
+	c.m.Lock()
+
+	var delay time.Duration
+	if c.pending {
+		delay = c.config.Sample(c.rand)
+		c.pending = false
+	}
+
+	c.m.Unlock()
+
+	if delay > 0 {
+		c.sleep(delay)
+	}
+
+	return c.Conn.Write(b)
+}
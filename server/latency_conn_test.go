@@ -0,0 +1,96 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+func TestLatencySample(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	fixed := LatencyConfig{Distribution: LatencyFixed, Delay: config.Delay(100 * time.Millisecond)}
+	if d := fixed.Sample(r); d != 100*time.Millisecond {
+		t.Errorf("Expected fixed delay of 100ms, got %s", d)
+	}
+
+	normal := LatencyConfig{Distribution: LatencyNormal, Delay: config.Delay(100 * time.Millisecond), Jitter: config.Delay(10 * time.Millisecond)}
+
+	sum := time.Duration(0)
+	for i := 0; i < 1000; i++ {
+		sum += normal.Sample(r)
+	}
+
+	if mean := sum / 1000; mean < 95*time.Millisecond || mean > 105*time.Millisecond {
+		t.Errorf("Expected mean around 100ms, got %s", mean)
+	}
+
+	longTail := DefaultLatencyConfig
+	longTail.Distribution = LatencyLongTail
+	longTail.Delay = config.Delay(10 * time.Millisecond)
+	longTail.Max = config.Delay(time.Second)
+
+	for i := 0; i < 1000; i++ {
+		if d := longTail.Sample(r); d < 10*time.Millisecond || d > time.Second {
+			t.Fatalf("Expected long-tail delay between 10ms and 1s, got %s", d)
+		}
+	}
+
+	if err := (LatencyConfig{Distribution: "uniform"}).Validate(); err == nil {
+		t.Errorf("Expected error for unknown distribution")
+	}
+}
+
+func TestLatencyConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	lc := LatencyConn(server, LatencyConfig{
+		Distribution: LatencyFixed,
+		Delay:        config.Delay(time.Second),
+	}).(*latencyConn)
+
+	delays := 0
+	lc.sleep = func(d time.Duration) {
+		delays++
+	}
+
+	go func() {
+		buf := make([]byte, 16)
+
+		// banner, response in two writes
+		client.Read(buf)
+		client.Write([]byte("request"))
+		client.Read(buf)
+		client.Read(buf)
+	}()
+
+	lc.Write([]byte("banner"))
+
+	if _, err := lc.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	lc.Write([]byte("response"))
+	lc.Write([]byte("more"))
+
+	if delays != 2 {
+		t.Errorf("Expected the banner and response to be delayed once, got %d delays", delays)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/metrics"
+)
+
+// MetricsConfig configures the endpoint the metrics are scraped from by
+// Prometheus.
+type MetricsConfig struct {
+	// Listen is the address of the dedicated listener of the endpoint, the
+	// metrics are not exposed when not set
+	Listen string `toml:"listen"`
+
+	// Path is the path of the endpoint
+	Path string `toml:"path"`
+}
+
+// DefaultMetricsConfig exposes the metrics at /metrics.
+var DefaultMetricsConfig = MetricsConfig{
+	Path: "/metrics",
+}
+
+var (
+	eventsTotal = metrics.NewCounterVec(
+		"honeytrap_events_total",
+		"Number of events, by category and service.",
+		"category", "service",
+	)
+
+	sessionDuration = metrics.NewHistogramVec(
+		"honeytrap_session_duration_seconds",
+		"Duration of the sessions, by service.",
+		metrics.DefaultBuckets,
+		"service",
+	)
+
+	connectionsTotal = metrics.NewCounterVec(
+		"honeytrap_connections_total",
+		"Number of connections accepted, by listener.",
+		"listener",
+	)
+
+	connectionsActive = metrics.NewGaugeVec(
+		"honeytrap_connections_active",
+		"Number of connections being handled, by listener.",
+		"listener",
+	)
+
+	directorContainers = metrics.NewGaugeVec(
+		"honeytrap_director_containers",
+		"Number of containers, by director.",
+		"director",
+	)
+)
+
+// metricsCollector counts the events on the bus.
+type metricsCollector struct{}
+
+func (metricsCollector) Send(e event.Event) {
+	eventsTotal.With(e.Get("category"), e.Get("service")).Inc()
+
+	// the duration is a number, which Get doesn't return
+	e.Range(func(k, v interface{}) bool {
+		if k != "session.duration" {
+			return true
+		}
+
+		if d, ok := v.(float64); ok {
+			sessionDuration.With(e.Get("service")).Observe(d)
+		}
+
+		return false
+	})
+}
+
+// serveMetrics starts the listener of the metrics endpoint when enabled,
+// the events are only counted when the metrics are exposed.
+func (hc *Honeytrap) serveMetrics() error {
+	mc := DefaultMetricsConfig
+
+	if err := hc.config.PrimitiveDecode(hc.config.Metrics, &mc); err != nil {
+		return err
+	} else if mc.Listen == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", mc.Listen)
+	if err != nil {
+		return err
+	}
+
+	if err := hc.bus.Subscribe(metricsCollector{}); err != nil {
+		ln.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(mc.Path, metrics.Default)
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Errorf("Error serving metrics: %s", err.Error())
+		}
+	}()
+
+	log.Infof("Metrics available at http://%s%s", ln.Addr().String(), mc.Path)
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+
+	"github.com/honeytrap/honeytrap/pivot"
+)
+
+// PivotConn returns a connection which scans the data of the client for
+// attempts to use the decoy as a proxy or to set up port forwards.
+func PivotConn(conn net.Conn, fn func(pivot.Attempt)) net.Conn {
+	return &pivotConn{
+		Conn:    conn,
+		scanner: pivot.NewScanner(fn),
+	}
+}
+
+type pivotConn struct {
+	net.Conn
+
+	scanner *pivot.Scanner
+}
+
+func (c *pivotConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.scanner.Write(b[:n])
+	}
+
+	return n, err
+}
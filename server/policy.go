@@ -0,0 +1,300 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// Policy actions.
+const (
+	// PolicyTarpit trickles the responses to the source
+	PolicyTarpit = "tarpit"
+
+	// PolicyDrop closes the connections of the source
+	PolicyDrop = "drop"
+
+	// PolicyLowInteraction never presents high interaction services
+	// (services with a director) to the source
+	PolicyLowInteraction = "low-interaction"
+)
+
+// PolicyConfig changes the response to the connections of sources,
+// depending on the country or the autonomous system of the source. The
+// policies are evaluated before the connection is dispatched to a service,
+// the first matching policy applies. All conditions that are set have to
+// match.
+type PolicyConfig struct {
+	Name string `toml:"name"`
+
+	// Countries are the iso codes of the country of the source, the
+	// GeoLite2 country database in the data dir is used
+	Countries []string `toml:"countries"`
+
+	// ASNs are the numbers of the autonomous system of the source, the
+	// GeoLite2 ASN database (GeoLite2-ASN.mmdb) in the data dir is used
+	ASNs []uint `toml:"asns"`
+
+	// Sources are the networks of the source, eg. 10.0.0.0/8
+	Sources []string `toml:"sources"`
+
+	// Action is tarpit, drop or low-interaction
+	Action string `toml:"action"`
+
+	// Delay is the delay between the bytes written to tarpitted
+	// connections
+	Delay config.Delay `toml:"delay"`
+}
+
+// DefaultPolicyConfig tarpits with a byte a second.
+var DefaultPolicyConfig = PolicyConfig{
+	Delay: config.Delay(time.Second),
+}
+
+// source is the enriched source of a connection.
+type source struct {
+	IP      net.IP
+	Country string
+	ASN     uint
+}
+
+type policy struct {
+	PolicyConfig
+
+	networks  []*net.IPNet
+	countries map[string]bool
+	asns      map[uint]bool
+}
+
+func newPolicy(pc PolicyConfig) (*policy, error) {
+	p := &policy{
+		PolicyConfig: pc,
+		countries:    map[string]bool{},
+		asns:         map[uint]bool{},
+	}
+
+	switch pc.Action {
+	case PolicyTarpit:
+		if pc.Delay <= 0 {
+			return nil, fmt.Errorf("Delay of policy %s should be positive", pc.Name)
+		}
+	case PolicyDrop, PolicyLowInteraction:
+	default:
+		return nil, fmt.Errorf("Unknown action %s of policy %s", pc.Action, pc.Name)
+	}
+
+	for _, s := range pc.Sources {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+
+		p.networks = append(p.networks, network)
+	}
+
+	for _, c := range pc.Countries {
+		p.countries[strings.ToUpper(c)] = true
+	}
+
+	for _, asn := range pc.ASNs {
+		p.asns[asn] = true
+	}
+
+	if len(p.networks) == 0 && len(p.countries) == 0 && len(p.asns) == 0 {
+		return nil, fmt.Errorf("Policy %s has no conditions", pc.Name)
+	}
+
+	return p, nil
+}
+
+// Match returns true if the source matches the conditions of the policy.
+func (p *policy) Match(s source) bool {
+	if len(p.networks) > 0 {
+		found := false
+		for _, network := range p.networks {
+			if network.Contains(s.IP) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(p.countries) > 0 && !p.countries[s.Country] {
+		return false
+	}
+
+	if len(p.asns) > 0 && !p.asns[s.ASN] {
+		return false
+	}
+
+	return true
+}
+
+// Apply applies the action of the policy to the connection, nil is
+// returned when the connection is dropped.
+func (p *policy) Apply(conn net.Conn) net.Conn {
+	switch p.Action {
+	case PolicyDrop:
+		return nil
+	case PolicyTarpit:
+		return TarpitConn(conn, p.Delay.Duration())
+	}
+
+	return conn
+}
+
+// Allows returns false for the services the policy doesn't present to the
+// source.
+func (p *policy) Allows(sm *ServiceMap) bool {
+	return p == nil || p.Action != PolicyLowInteraction || sm.director == nil
+}
+
+// asnDB looks up the autonomous system of sources for the policies, the
+// database is opened when first used.
+type asnDB struct {
+	path string
+
+	once sync.Once
+	db   *maxminddb.Reader
+}
+
+// ASN returns the number of the autonomous system of the ip, 0 if unknown.
+func (a *asnDB) ASN(ip net.IP) uint {
+	a.once.Do(func() {
+		db, err := maxminddb.Open(a.path)
+		if err != nil {
+			log.Errorf("Error opening asn database for policies, asn policies won't match: %s", err.Error())
+			return
+		}
+
+		a.db = db
+	})
+
+	if a.db == nil {
+		return 0
+	}
+
+	var record struct {
+		ASN uint `maxminddb:"autonomous_system_number"`
+	}
+
+	if err := a.db.Lookup(ip, &record); err != nil {
+		return 0
+	}
+
+	return record.ASN
+}
+
+// policy returns the first policy matching the source of the connection,
+// nil if none of the policies match. The source is enriched with the
+// country and autonomous system, when policies depend on them.
+func (hc *Honeytrap) policy(addr net.Addr) (*policy, source) {
+	s := source{}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		s.IP = a.IP
+	case *net.UDPAddr:
+		s.IP = a.IP
+	default:
+		return nil, s
+	}
+
+	for _, p := range hc.policies {
+		if len(p.countries) > 0 && s.Country == "" {
+			s.Country = hc.countries.Country(s.IP)
+		}
+
+		if len(p.asns) > 0 && s.ASN == 0 {
+			s.ASN = hc.asns.ASN(s.IP)
+		}
+
+		if p.Match(s) {
+			return p, s
+		}
+	}
+
+	return nil, s
+}
+
+// applyPolicy applies the policy matching the source of the connection, nil
+// is returned when the connection is dropped.
+func (hc *Honeytrap) applyPolicy(conn net.Conn) (net.Conn, *policy) {
+	p, s := hc.policy(conn.RemoteAddr())
+	if p == nil {
+		return conn, nil
+	}
+
+	options := []event.Option{
+		event.Sensor("honeytrap"),
+		event.Category("policy"),
+		event.Type(p.Action),
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("policy.name", p.Name),
+	}
+
+	if s.Country != "" {
+		options = append(options, event.Custom("policy.country", s.Country))
+	}
+
+	if s.ASN != 0 {
+		options = append(options, event.Custom("policy.asn", s.ASN))
+	}
+
+	hc.bus.Send(event.New(options...))
+
+	return p.Apply(conn), p
+}
+
+// configurePolicies compiles the configured policies.
+func (hc *Honeytrap) configurePolicies() error {
+	hc.policies = nil
+
+	for _, c := range hc.config.Policies {
+		pc := DefaultPolicyConfig
+		if err := hc.config.PrimitiveDecode(c, &pc); err != nil {
+			return err
+		}
+
+		p, err := newPolicy(pc)
+		if err != nil {
+			return err
+		}
+
+		hc.policies = append(hc.policies, p)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/director"
+	"github.com/honeytrap/honeytrap/pushers/eventbus"
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+func TestNewPolicy(t *testing.T) {
+	if _, err := newPolicy(PolicyConfig{Name: "p", Action: "reject", Countries: []string{"nl"}}); err == nil {
+		t.Errorf("Expected error for unknown action")
+	}
+
+	if _, err := newPolicy(PolicyConfig{Name: "p", Action: PolicyDrop}); err == nil {
+		t.Errorf("Expected error for policy without conditions")
+	}
+
+	if _, err := newPolicy(PolicyConfig{Name: "p", Action: PolicyTarpit, ASNs: []uint{64496}}); err == nil {
+		t.Errorf("Expected error for tarpit without delay")
+	}
+}
+
+func TestPolicyMatch(t *testing.T) {
+	p, err := newPolicy(PolicyConfig{
+		Action:    PolicyLowInteraction,
+		Countries: []string{"nl"},
+		ASNs:      []uint{64496},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("192.0.2.1")
+
+	if !p.Match(source{IP: ip, Country: "NL", ASN: 64496}) {
+		t.Errorf("Expected source to match")
+	}
+
+	if p.Match(source{IP: ip, Country: "NL", ASN: 64497}) {
+		t.Errorf("Expected source of other asn not to match")
+	}
+
+	if p.Match(source{IP: ip, ASN: 64496}) {
+		t.Errorf("Expected source of unknown country not to match")
+	}
+
+	high := &ServiceMap{director: director.MustDummy()}
+	low := &ServiceMap{}
+
+	if p.Allows(high) || !p.Allows(low) {
+		t.Errorf("Expected only low interaction services to be allowed")
+	}
+
+	var none *policy
+	if !none.Allows(high) {
+		t.Errorf("Expected all services to be allowed without policy")
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	drop, err := newPolicy(PolicyConfig{Name: "internal", Action: PolicyDrop, Sources: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := harness.NewRecorder()
+
+	bus := eventbus.New()
+	bus.Subscribe(r)
+
+	hc := &Honeytrap{
+		bus:       bus,
+		countries: &countryDB{},
+		asns:      &asnDB{},
+		policies:  []*policy{drop},
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the local source doesn't match the policy
+	if c, p := hc.applyPolicy(conn); c != conn || p != nil {
+		t.Errorf("Expected connection not to be changed")
+	}
+
+	drop.networks = append(drop.networks, &net.IPNet{IP: net.ParseIP("127.0.0.0").To4(), Mask: net.CIDRMask(8, 32)})
+
+	if c, p := hc.applyPolicy(conn); c != nil || p != drop {
+		t.Errorf("Expected connection to be dropped")
+	}
+
+	events, err := r.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if events[0].Get("category") != "policy" || events[0].Get("type") != PolicyDrop || events[0].Get("policy.name") != "internal" {
+		t.Errorf("Unexpected policy event %v", events[0])
+	}
+}
+
+func TestTarpitConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	delays := 0
+
+	tc := TarpitConn(server, time.Second).(*tarpitConn)
+	tc.sleep = func(d time.Duration) {
+		delays++
+	}
+
+	go func() {
+		tc.Write([]byte("SSH-2.0-OpenSSH_7.4\r\n"))
+		tc.Close()
+	}()
+
+	data, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "SSH-2.0-OpenSSH_7.4\r\n" {
+		t.Errorf("Unexpected data %q", data)
+	}
+
+	if delays != len(data)-1 {
+		t.Errorf("Expected a delay between every byte, got %d delays", delays)
+	}
+}
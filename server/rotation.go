@@ -0,0 +1,198 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/personality"
+)
+
+// RotationConfig configures the rotation of the services and personalities
+// a sensor exposes, which makes long term fingerprinting of the sensor
+// harder.
+type RotationConfig struct {
+	Interval config.Delay `toml:"interval"`
+
+	// Random picks a random set instead of the next one
+	Random bool `toml:"random"`
+
+	// Sets are the sets of services that are exposed together, services
+	// that are not part of a set are always exposed.
+	Sets [][]string `toml:"sets"`
+
+	// Personalities are the personalities the services rotate through
+	Personalities []string `toml:"personalities"`
+}
+
+// DefaultRotationConfig rotates once a day.
+var DefaultRotationConfig = RotationConfig{
+	Interval: config.Delay(24 * time.Hour),
+}
+
+// Enabled returns true if there is something to rotate.
+func (rc RotationConfig) Enabled() bool {
+	return len(rc.Sets) > 0 || len(rc.Personalities) > 0
+}
+
+type rotation struct {
+	RotationConfig
+
+	m sync.RWMutex
+
+	// rotated contains the services of all sets, active the services of
+	// the current set
+	rotated map[string]bool
+	active  map[string]bool
+
+	set         int
+	personality int
+
+	personalities []*personality.Personality
+
+	// rebuild recreates the services with the personality
+	rebuild func(*personality.Personality)
+
+	rand *rand.Rand
+}
+
+func newRotation(rc RotationConfig) (*rotation, error) {
+	r := &rotation{
+		RotationConfig: rc,
+
+		rotated: map[string]bool{},
+
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for i, set := range rc.Sets {
+		if len(set) == 0 {
+			return nil, fmt.Errorf("Rotation set %d has no services", i)
+		}
+
+		for _, name := range set {
+			r.rotated[name] = true
+		}
+	}
+
+	for _, name := range rc.Personalities {
+		p, err := personality.Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		r.personalities = append(r.personalities, p)
+	}
+
+	if rc.Random {
+		r.set = r.next(-1, len(rc.Sets))
+		r.personality = r.next(-1, len(rc.Personalities))
+	}
+
+	r.activate()
+	return r, nil
+}
+
+// next returns the index following i of n items.
+func (r *rotation) next(i, n int) int {
+	if n <= 1 {
+		return 0
+	}
+
+	if !r.Random {
+		return (i + 1) % n
+	}
+
+	if i < 0 {
+		return r.rand.Intn(n)
+	}
+
+	// a random index other than the current one
+	j := r.rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	return j
+}
+
+func (r *rotation) activate() {
+	if len(r.Sets) == 0 {
+		return
+	}
+
+	r.active = map[string]bool{}
+	for _, name := range r.Sets[r.set] {
+		r.active[name] = true
+	}
+}
+
+// Active returns true if the service is exposed.
+func (r *rotation) Active(name string) bool {
+	if r == nil {
+		return true
+	}
+
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return !r.rotated[name] || r.active[name]
+}
+
+// Personality returns the current personality, nil if personalities
+// aren't rotated.
+func (r *rotation) Personality() *personality.Personality {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	if len(r.personalities) == 0 {
+		return nil
+	}
+
+	return r.personalities[r.personality]
+}
+
+// Rotate activates the next set of services and personality.
+func (r *rotation) Rotate() error {
+	r.m.Lock()
+
+	r.set = r.next(r.set, len(r.Sets))
+	r.activate()
+
+	previous := r.personality
+	r.personality = r.next(r.personality, len(r.personalities))
+
+	if len(r.Sets) > 0 {
+		log.Infof("Rotated to services %s", strings.Join(r.Sets[r.set], ", "))
+	}
+
+	var p *personality.Personality
+	if len(r.personalities) > 0 && r.personality != previous {
+		p = r.personalities[r.personality]
+	}
+
+	r.m.Unlock()
+
+	if p != nil && r.rebuild != nil {
+		log.Infof("Rotated to personality %s", p.Name)
+		r.rebuild(p)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"testing"
+
+	"github.com/honeytrap/honeytrap/personality"
+)
+
+func TestRotation(t *testing.T) {
+	r, err := newRotation(RotationConfig{
+		Sets:          [][]string{{"ssh", "http"}, {"telnet"}},
+		Personalities: []string{"ubuntu-20.04-web", "hikvision-camera"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt := ""
+	r.rebuild = func(p *personality.Personality) {
+		rebuilt = p.Name
+	}
+
+	if !r.Active("ssh") || r.Active("telnet") {
+		t.Error("Expected first set to be active")
+	}
+
+	// services outside the sets are always active
+	if !r.Active("ftp") {
+		t.Error("Expected service outside the sets to be active")
+	}
+
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Active("ssh") || !r.Active("telnet") {
+		t.Error("Expected second set to be active")
+	}
+
+	if rebuilt != "hikvision-camera" || r.Personality().Name != "hikvision-camera" {
+		t.Errorf("Expected services rebuilt with next personality, got %q", rebuilt)
+	}
+
+	r.Rotate()
+
+	if !r.Active("http") {
+		t.Error("Expected rotation to wrap around")
+	}
+}
+
+func TestRotationRandom(t *testing.T) {
+	r, err := newRotation(RotationConfig{
+		Random: true,
+		Sets:   [][]string{{"a"}, {"b"}, {"c"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		previous := r.set
+
+		r.Rotate()
+
+		if r.set == previous {
+			t.Fatal("Expected random rotation to change the set")
+		}
+	}
+}
+
+func TestRotationUnknownPersonality(t *testing.T) {
+	if _, err := newRotation(RotationConfig{Personalities: []string{"unknown"}}); err == nil {
+		t.Error("Expected error for unknown personality")
+	}
+}
+
+func TestRotationDisabled(t *testing.T) {
+	var r *rotation
+
+	if !r.Active("ssh") {
+		t.Error("Expected all services to be active without rotation")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// RouteConfig routes the connections of a port to other services than the
+// services of the port, depending on the source or the time of the
+// connection. All conditions that are set have to match.
+type RouteConfig struct {
+	// Sources are the networks of the source, eg. 10.0.0.0/8
+	Sources []string `toml:"sources"`
+
+	// Countries are the iso codes of the country of the source, the
+	// GeoLite2 country database in the data dir is used
+	Countries []string `toml:"countries"`
+
+	// Schedule is the time of day in local time, eg. 08:00-18:00
+	Schedule string `toml:"schedule"`
+
+	// Days are the days of the week, eg. mon, tue
+	Days []string `toml:"days"`
+
+	Services []string `toml:"services"`
+}
+
+type route struct {
+	networks  []*net.IPNet
+	countries map[string]bool
+	days      map[time.Weekday]bool
+
+	// from and to are minutes since midnight, the schedule wraps around
+	// midnight when to is before from
+	scheduled bool
+	from, to  int
+
+	services []*ServiceMap
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time %s, expected hh:mm", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// newRoute compiles the route configuration, the services are resolved by
+// the lookup function.
+func newRoute(rc RouteConfig, lookup func(string) (*ServiceMap, bool)) (*route, error) {
+	r := &route{
+		countries: map[string]bool{},
+		days:      map[time.Weekday]bool{},
+	}
+
+	for _, s := range rc.Sources {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+
+		r.networks = append(r.networks, network)
+	}
+
+	for _, c := range rc.Countries {
+		r.countries[strings.ToUpper(c)] = true
+	}
+
+	for _, d := range rc.Days {
+		day, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return nil, fmt.Errorf("Invalid day %s", d)
+		}
+
+		r.days[day] = true
+	}
+
+	if rc.Schedule != "" {
+		parts := strings.Split(rc.Schedule, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid schedule %s, expected hh:mm-hh:mm", rc.Schedule)
+		}
+
+		var err error
+		if r.from, err = parseClock(parts[0]); err != nil {
+			return nil, err
+		} else if r.to, err = parseClock(parts[1]); err != nil {
+			return nil, err
+		}
+
+		r.scheduled = true
+	}
+
+	if len(rc.Services) == 0 {
+		return nil, fmt.Errorf("Route has no services")
+	}
+
+	for _, name := range rc.Services {
+		sm, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("Unknown service %s", name)
+		}
+
+		r.services = append(r.services, sm)
+	}
+
+	return r, nil
+}
+
+// Match returns true if the connection of the source at the time matches
+// the conditions of the route.
+func (r *route) Match(ip net.IP, country func(net.IP) string, now time.Time) bool {
+	if len(r.networks) > 0 {
+		found := false
+		for _, network := range r.networks {
+			if network.Contains(ip) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.countries) > 0 && !r.countries[country(ip)] {
+		return false
+	}
+
+	if len(r.days) > 0 && !r.days[now.Weekday()] {
+		return false
+	}
+
+	if !r.scheduled {
+		return true
+	}
+
+	minutes := now.Hour()*60 + now.Minute()
+	if r.from <= r.to {
+		return minutes >= r.from && minutes < r.to
+	}
+
+	return minutes >= r.from || minutes < r.to
+}
+
+// countryDB looks up the country of sources for the routes, the database is
+// opened when first used.
+type countryDB struct {
+	path string
+
+	once sync.Once
+	db   *maxminddb.Reader
+}
+
+// Country returns the iso code of the country of the ip, empty if unknown.
+func (c *countryDB) Country(ip net.IP) string {
+	c.once.Do(func() {
+		db, err := maxminddb.Open(c.path)
+		if err != nil {
+			log.Errorf("Error opening country database for routes, country routes won't match: %s", err.Error())
+			return
+		}
+
+		c.db = db
+	})
+
+	if c.db == nil {
+		return ""
+	}
+
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+
+	if err := c.db.Lookup(ip, &record); err != nil {
+		return ""
+	}
+
+	return record.Country.ISOCode
+}
+
+// route returns the services of the first route matching the connection,
+// nil if none of the routes match.
+func (hc *Honeytrap) route(routes []*route, addr net.Addr) []*ServiceMap {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	var ip net.IP
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return nil
+	}
+
+	now := time.Now()
+
+	for _, r := range routes {
+		if r.Match(ip, hc.countries.Country, now) {
+			return r.services
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRoute(t *testing.T) {
+	services := map[string]*ServiceMap{
+		"ssh":    {Name: "ssh"},
+		"canary": {Name: "canary"},
+	}
+
+	lookup := func(name string) (*ServiceMap, bool) {
+		sm, ok := services[name]
+		return sm, ok
+	}
+
+	if _, err := newRoute(RouteConfig{Services: []string{"unknown"}}, lookup); err == nil {
+		t.Errorf("Expected error for unknown service")
+	}
+
+	if _, err := newRoute(RouteConfig{Schedule: "8-18", Services: []string{"ssh"}}, lookup); err == nil {
+		t.Errorf("Expected error for invalid schedule")
+	}
+
+	country := func(ip net.IP) string {
+		if ip.Equal(net.ParseIP("192.0.2.1")) {
+			return "NL"
+		}
+
+		return "US"
+	}
+
+	// monday
+	day := time.Date(2019, 4, 15, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		config RouteConfig
+		ip     string
+		now    time.Time
+		match  bool
+	}{
+		{RouteConfig{Sources: []string{"10.0.0.0/8"}}, "10.1.2.3", day, true},
+		{RouteConfig{Sources: []string{"10.0.0.0/8"}}, "192.0.2.1", day, false},
+		{RouteConfig{Sources: []string{"192.0.2.1"}}, "192.0.2.1", day, true},
+		{RouteConfig{Countries: []string{"nl"}}, "192.0.2.1", day, true},
+		{RouteConfig{Countries: []string{"nl"}}, "192.0.2.2", day, false},
+		{RouteConfig{Schedule: "08:00-18:00"}, "192.0.2.1", day.Add(9 * time.Hour), true},
+		{RouteConfig{Schedule: "08:00-18:00"}, "192.0.2.1", day.Add(19 * time.Hour), false},
+		{RouteConfig{Schedule: "22:00-06:00"}, "192.0.2.1", day.Add(23 * time.Hour), true},
+		{RouteConfig{Schedule: "22:00-06:00"}, "192.0.2.1", day.Add(5 * time.Hour), true},
+		{RouteConfig{Schedule: "22:00-06:00"}, "192.0.2.1", day.Add(12 * time.Hour), false},
+		{RouteConfig{Days: []string{"mon"}}, "192.0.2.1", day, true},
+		{RouteConfig{Days: []string{"sat", "sun"}}, "192.0.2.1", day, false},
+		{RouteConfig{Sources: []string{"192.0.2.0/24"}, Countries: []string{"US"}}, "192.0.2.1", day, false},
+	}
+
+	for i, test := range tests {
+		test.config.Services = []string{"canary"}
+
+		r, err := newRoute(test.config, lookup)
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err.Error())
+		}
+
+		if match := r.Match(net.ParseIP(test.ip), country, test.now); match != test.match {
+			t.Errorf("Test %d: expected match %t, got %t", i, test.match, match)
+		}
+	}
+}
+
+func TestRouteServices(t *testing.T) {
+	canary := &ServiceMap{Name: "canary"}
+
+	r, err := newRoute(RouteConfig{
+		Sources:  []string{"10.0.0.0/8"},
+		Services: []string{"canary"},
+	}, func(name string) (*ServiceMap, bool) {
+		return canary, true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc := &Honeytrap{countries: &countryDB{}}
+
+	if services := hc.route([]*route{r}, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}); len(services) != 1 || services[0] != canary {
+		t.Errorf("Expected internal source to be routed to the canary")
+	}
+
+	if services := hc.route([]*route{r}, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}); services != nil {
+		t.Errorf("Expected external source not to be routed")
+	}
+}
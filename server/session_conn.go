@@ -0,0 +1,186 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+// SessionConfig contains the timeouts of a service, a timeout of zero
+// disables the timeout.
+type SessionConfig struct {
+	// ConnectTimeout is the maximum time to wait for the first data of the client
+	ConnectTimeout config.Delay `toml:"connect-timeout"`
+
+	// ReadTimeout is the maximum duration of a single read
+	ReadTimeout config.Delay `toml:"read-timeout"`
+
+	// WriteTimeout is the maximum duration of a single write
+	WriteTimeout config.Delay `toml:"write-timeout"`
+
+	// IdleTimeout is the maximum time between reads or writes
+	IdleTimeout config.Delay `toml:"idle-timeout"`
+
+	// SessionTimeout is the maximum duration of the complete session
+	SessionTimeout config.Delay `toml:"session-timeout"`
+}
+
+// DefaultSessionConfig contains the timeouts used if a service doesn't
+// configure timeouts.
+var DefaultSessionConfig = SessionConfig{
+	ReadTimeout:  config.Delay(time.Second * 30),
+	WriteTimeout: config.Delay(time.Second * 30),
+}
+
+// SessionConn returns a connection which enforces the timeouts of the
+// session configuration and counts the transferred bytes.
+func SessionConn(conn net.Conn, sc SessionConfig) *sessionConn {
+	c := &sessionConn{
+		Conn:   conn,
+		config: sc,
+		start:  time.Now(),
+	}
+
+	if d := sc.SessionTimeout.Duration(); d > 0 {
+		c.timer = time.AfterFunc(d, func() {
+			c.setReason("session")
+			conn.Close()
+		})
+	}
+
+	return c
+}
+
+type sessionConn struct {
+	net.Conn
+
+	config SessionConfig
+
+	start time.Time
+	timer *time.Timer
+
+	bytesRead    int64
+	bytesWritten int64
+
+	m      sync.Mutex
+	reason string
+}
+
+func (c *sessionConn) setReason(reason string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.reason == "" {
+		c.reason = reason
+	}
+}
+
+// deadline returns the deadline for the next operation and the timeout
+// which will be reached at that deadline.
+func (c *sessionConn) deadline(timeout time.Duration, reason string) (time.Time, string) {
+	now := time.Now()
+
+	deadline := time.Time{}
+
+	update := func(d time.Duration, base time.Time, r string) {
+		if d <= 0 {
+			return
+		}
+
+		if t := base.Add(d); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+			reason = r
+		}
+	}
+
+	update(timeout, now, reason)
+	update(c.config.IdleTimeout.Duration(), now, "idle")
+	update(c.config.SessionTimeout.Duration(), c.start, "session")
+
+	return deadline, reason
+}
+
+func (c *sessionConn) Read(b []byte) (int, error) {
+	timeout, reason := c.config.ReadTimeout.Duration(), "read"
+	if atomic.LoadInt64(&c.bytesRead) == 0 && c.config.ConnectTimeout.Duration() > 0 {
+		timeout, reason = c.config.ConnectTimeout.Duration(), "connect"
+	}
+
+	deadline, reason := c.deadline(timeout, reason)
+	if err := c.Conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.bytesRead, int64(n))
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		c.setReason(reason)
+	}
+
+	return n, err
+}
+
+func (c *sessionConn) Write(b []byte) (int, error) {
+	deadline, reason := c.deadline(c.config.WriteTimeout.Duration(), "write")
+	if err := c.Conn.SetWriteDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.bytesWritten, int64(n))
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		c.setReason(reason)
+	}
+
+	return n, err
+}
+
+func (c *sessionConn) Close() error {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+
+	return c.Conn.Close()
+}
+
+// Duration returns the duration of the session.
+func (c *sessionConn) Duration() time.Duration {
+	return time.Since(c.start)
+}
+
+// BytesRead returns the number of bytes read from the connection.
+func (c *sessionConn) BytesRead() int64 {
+	return atomic.LoadInt64(&c.bytesRead)
+}
+
+// BytesWritten returns the number of bytes written to the connection.
+func (c *sessionConn) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.bytesWritten)
+}
+
+// Timeout returns the timeout which ended the session, empty if the
+// session didn't time out.
+func (c *sessionConn) Timeout() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.reason
+}
@@ -0,0 +1,93 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+func TestSessionConnCounters(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	sc := SessionConn(server, DefaultSessionConfig)
+	defer sc.Close()
+
+	go func() {
+		client.Write([]byte("hello"))
+
+		buf := make([]byte, 3)
+		client.Read(buf)
+	}()
+
+	buf := make([]byte, 5)
+	if _, err := sc.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sc.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+
+	if sc.BytesRead() != 5 {
+		t.Errorf("Expected 5 bytes read but got %d", sc.BytesRead())
+	}
+	if sc.BytesWritten() != 3 {
+		t.Errorf("Expected 3 bytes written but got %d", sc.BytesWritten())
+	}
+	if sc.Timeout() != "" {
+		t.Errorf("Expected no timeout but got %s", sc.Timeout())
+	}
+}
+
+func TestSessionConnConnectTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	sc := SessionConn(server, SessionConfig{
+		ConnectTimeout: config.Delay(time.Millisecond * 50),
+		ReadTimeout:    config.Delay(time.Second * 30),
+	})
+	defer sc.Close()
+
+	if _, err := sc.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected timeout error")
+	}
+
+	if sc.Timeout() != "connect" {
+		t.Errorf("Expected connect timeout but got %s", sc.Timeout())
+	}
+}
+
+func TestSessionConnSessionTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	sc := SessionConn(server, SessionConfig{
+		SessionTimeout: config.Delay(time.Millisecond * 50),
+	})
+	defer sc.Close()
+
+	if _, err := sc.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected error after session timeout")
+	}
+
+	if sc.Timeout() != "session" {
+		t.Errorf("Expected session timeout but got %s", sc.Timeout())
+	}
+}
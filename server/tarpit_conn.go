@@ -0,0 +1,60 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// TarpitConn returns a connection which trickles the responses of the
+// service, a byte at a time with the delay in between, keeping the client
+// busy while hardly using any resources.
+func TarpitConn(conn net.Conn, delay time.Duration) net.Conn {
+	return &tarpitConn{
+		Conn:  conn,
+		delay: delay,
+		sleep: time.Sleep,
+	}
+}
+
+type tarpitConn struct {
+	net.Conn
+
+	delay time.Duration
+
+	// m serializes the writes, the bytes of concurrent writes are not
+	// interleaved
+	m sync.Mutex
+
+	sleep func(time.Duration)
+}
+
+func (c *tarpitConn) Write(b []byte) (int, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for i := range b {
+		if i > 0 {
+			c.sleep(c.delay)
+		}
+
+		if _, err := c.Conn.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services/certprofile"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+)
+
+// TLSConfig contains the tls configuration of a port, when set connections
+// are decrypted before being passed to the plaintext services of the port.
+type TLSConfig struct {
+	Certificate string `toml:"certificate"`
+	Key         string `toml:"key"`
+
+	// CommonName is used for the generated certificate if no certificate
+	// has been configured.
+	CommonName string `toml:"common-name"`
+
+	// Profile is the name of the certificate profile of the generated
+	// certificate, eg. iis
+	Profile string `toml:"certificate-profile"`
+
+	// Protocols are the application protocols advertised using alpn.
+	Protocols []string `toml:"alpn"`
+}
+
+type tlsWrapper struct {
+	config *tls.Config
+
+	c pushers.Channel
+}
+
+// newTLSWrapper returns a tlsWrapper for the given configuration, a self
+// signed certificate of the profile is generated if no certificate has been
+// configured.
+func newTLSWrapper(tc *TLSConfig, c pushers.Channel) (*tlsWrapper, error) {
+	var cert tls.Certificate
+
+	if tc.Certificate != "" {
+		var err error
+		if cert, err = tls.LoadX509KeyPair(tc.Certificate, tc.Key); err != nil {
+			return nil, err
+		}
+	} else {
+		name := tc.Profile
+		if name == "" {
+			name = certprofile.Default
+		}
+
+		p, err := certprofile.Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := certprofile.NewGenerator(p).Certificate(tc.CommonName)
+		if err != nil {
+			return nil, err
+		}
+
+		cert = *c
+	}
+
+	return &tlsWrapper{
+		config: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   tc.Protocols,
+		},
+		c: c,
+	}, nil
+}
+
+// Wrap performs the tls handshake and returns the decrypted connection, the
+// connection will carry the ja3 digest, server name and alpn protocols.
+func (w *tlsWrapper) Wrap(conn net.Conn) (*event.Conn, error) {
+	ja3Digest := ""
+	serverName := ""
+	protocols := []string{}
+
+	config := w.config.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		ja3Digest = hello.JA3Digest()
+		serverName = hello.ServerName
+		protocols = hello.SupportedProtos
+		return nil, nil
+	}
+
+	tlsConn := tls.Server(conn, config)
+
+	conn.SetDeadline(time.Now().Add(time.Second * 30))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := tlsConn.Handshake(); err != nil {
+		w.c.Send(event.New(
+			event.Sensor("tls"),
+			event.Category("tls"),
+			event.Type("handshake-failed"),
+			event.SourceAddr(conn.RemoteAddr()),
+			event.DestinationAddr(conn.LocalAddr()),
+			event.Custom("tls.ja3-digest", ja3Digest),
+			event.Custom("tls.server-name", serverName),
+			event.Custom("tls.alpn", protocols),
+			event.Error(err),
+		))
+
+		return nil, err
+	}
+
+	return event.WithConn(
+		tlsConn,
+		event.Custom("tls.ja3-digest", ja3Digest),
+		event.Custom("tls.server-name", serverName),
+		event.Custom("tls.alpn", protocols),
+		event.Custom("tls.negotiated-protocol", tlsConn.ConnectionState().NegotiatedProtocol),
+	), nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+)
+
+func TestTLSWrapper(t *testing.T) {
+	tw, err := newTLSWrapper(&TLSConfig{
+		CommonName: "example.com",
+		Protocols:  []string{"imap"},
+	}, pushers.MustDummy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+
+	go func() {
+		tlsConn := tls.Client(client, &tls.Config{
+			ServerName:         "example.com",
+			NextProtos:         []string{"imap"},
+			InsecureSkipVerify: true,
+		})
+
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+
+		tlsConn.Write([]byte("a001 CAPABILITY\r\n"))
+	}()
+
+	conn, err := tw.Wrap(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 17)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "a001 CAPABILITY\r\n" {
+		t.Errorf("Expected plaintext but got %q", buf)
+	}
+
+	evt := event.New(conn.Options())
+
+	if v := evt.Get("tls.server-name"); v != "example.com" {
+		t.Errorf("Expected server name example.com but got %s", v)
+	}
+	if v := evt.Get("tls.negotiated-protocol"); v != "imap" {
+		t.Errorf("Expected negotiated protocol imap but got %s", v)
+	}
+	if v := evt.Get("tls.ja3-digest"); v == "" {
+		t.Errorf("Expected ja3 digest to be set")
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package authpolicy implements the authentication acceptance policies
+// shared by the services, it allows operators to tune how easy it is to
+// break into a decoy.
+package authpolicy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/content"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/authpolicy")
+
+const (
+	// PolicyCredentials accepts the configured credentials.
+	PolicyCredentials = "credentials"
+
+	// PolicyAlways accepts any credentials.
+	PolicyAlways = "always"
+
+	// PolicyNever rejects any credentials.
+	PolicyNever = "never"
+
+	// PolicyAttempts accepts any credentials after a number of failed
+	// attempts from the same address.
+	PolicyAttempts = "attempts"
+)
+
+const (
+	// failureWindow is the time without attempts after which the failed
+	// attempts of an address are forgotten
+	failureWindow = time.Hour
+
+	// maxFailures limits the addresses of which the failed attempts are
+	// counted, the address seen longest ago is forgotten first
+	maxFailures = 10000
+)
+
+// Config contains the authentication policy of a service, it is meant to
+// be embedded in the configuration of the service.
+type Config struct {
+	AuthPolicy string `toml:"auth-policy"`
+
+	// AuthAttempts is the number of rejected attempts before the attempts
+	// policy accepts the credentials.
+	AuthAttempts int `toml:"auth-attempts"`
+
+	// Credentials contains username:password combinations accepted by the
+	// credentials policy, "*" will accept any combination.
+	Credentials []string `toml:"credentials"`
+
+	// Dictionary is the name of the credential dictionary of the content
+	// packs, its credentials are accepted by the credentials policy as well.
+	Dictionary string `toml:"dictionary"`
+}
+
+// Policy decides if credentials will be accepted.
+type Policy struct {
+	policy   string
+	attempts int
+
+	credentials [][2]string
+	wildcard    bool
+	dictionary  string
+
+	m        sync.Mutex
+	failures map[string]*failures
+	expired  time.Time
+
+	now func() time.Time
+}
+
+// failures are the failed attempts of an address.
+type failures struct {
+	count    int
+	lastSeen time.Time
+}
+
+// New returns the Policy for the configuration.
+func New(c Config) (*Policy, error) {
+	p := &Policy{
+		policy:     c.AuthPolicy,
+		attempts:   c.AuthAttempts,
+		dictionary: c.Dictionary,
+		failures:   map[string]*failures{},
+		now:        time.Now,
+	}
+
+	if p.policy == "" {
+		p.policy = PolicyCredentials
+	}
+
+	switch p.policy {
+	case PolicyCredentials, PolicyAlways, PolicyNever:
+	case PolicyAttempts:
+		if p.attempts < 0 {
+			return nil, fmt.Errorf("auth-attempts should not be negative")
+		}
+	default:
+		return nil, fmt.Errorf("unknown auth-policy %s", p.policy)
+	}
+
+	for _, credential := range c.Credentials {
+		if credential == "*" {
+			p.wildcard = true
+			continue
+		}
+
+		parts := strings.SplitN(credential, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid credential %q, expected username:password", credential)
+		}
+
+		p.credentials = append(p.credentials, [2]string{parts[0], parts[1]})
+	}
+
+	return p, nil
+}
+
+// MustNew returns the Policy for the configuration, it falls back to rejecting
+// all credentials for invalid configurations.
+func MustNew(c Config) *Policy {
+	p, err := New(c)
+	if err == nil {
+		return p
+	}
+
+	log.Errorf("Invalid authentication policy, rejecting all credentials: %s", err.Error())
+
+	p, _ = New(Config{AuthPolicy: PolicyNever})
+	return p
+}
+
+// Accept returns true if the username and password will be accepted for
+// the remote address.
+func (p *Policy) Accept(addr net.Addr, username, password string) bool {
+	return p.AcceptFunc(addr, func(u, pw string) bool {
+		return u == username && pw == password
+	})
+}
+
+// AcceptFunc returns true if the credentials will be accepted, for the
+// credentials policy match will be called with the configured credentials.
+// This allows protocols which don't disclose the password, like vnc, to
+// verify the response against the configured credentials.
+func (p *Policy) AcceptFunc(addr net.Addr, match func(username, password string) bool) bool {
+	switch p.policy {
+	case PolicyAlways:
+		return true
+	case PolicyNever:
+		return false
+	case PolicyAttempts:
+		return p.attempt(addr)
+	}
+
+	if p.wildcard {
+		return true
+	}
+
+	for _, credential := range p.credentials {
+		if match(credential[0], credential[1]) {
+			return true
+		}
+	}
+
+	if p.dictionary == "" {
+		return false
+	}
+
+	// the dictionary is looked up for every attempt, the content packs
+	// can be updated at runtime
+	for _, credential := range content.Default().Credentials(p.dictionary) {
+		parts := strings.SplitN(credential, ":", 2)
+		if match(parts[0], parts[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attempt registers an attempt for the address and returns true if the
+// number of failed attempts has been reached, the count starts over after
+// an accepted attempt.
+func (p *Policy) attempt(addr net.Addr) bool {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	now := p.now()
+	p.expire(now)
+
+	f, ok := p.failures[host]
+	if !ok {
+		if len(p.failures) >= maxFailures {
+			p.evict()
+		}
+
+		f = &failures{}
+		p.failures[host] = f
+	}
+
+	if f.count >= p.attempts {
+		delete(p.failures, host)
+		return true
+	}
+
+	f.count++
+	f.lastSeen = now
+	return false
+}
+
+// expire forgets the failed attempts of the addresses that haven't been
+// seen during the failure window.
+func (p *Policy) expire(now time.Time) {
+	if now.Sub(p.expired) < time.Minute {
+		return
+	}
+
+	p.expired = now
+
+	for host, f := range p.failures {
+		if now.Sub(f.lastSeen) > failureWindow {
+			delete(p.failures, host)
+		}
+	}
+}
+
+// evict forgets the failed attempts of the address seen longest ago.
+func (p *Policy) evict() {
+	oldest := ""
+
+	for host, f := range p.failures {
+		if oldest == "" || f.lastSeen.Before(p.failures[oldest].lastSeen) {
+			oldest = host
+		}
+	}
+
+	delete(p.failures, oldest)
+}
@@ -0,0 +1,140 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package authpolicy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+var addr = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+
+func TestCredentials(t *testing.T) {
+	p, err := New(Config{
+		Credentials: []string{"root:root", "admin:pass:word"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.Accept(addr, "root", "root") {
+		t.Errorf("Expected root:root to be accepted")
+	}
+	if !p.Accept(addr, "admin", "pass:word") {
+		t.Errorf("Expected admin:pass:word to be accepted")
+	}
+	if p.Accept(addr, "root", "toor") {
+		t.Errorf("Expected root:toor to be rejected")
+	}
+}
+
+func TestDictionaryWithoutContent(t *testing.T) {
+	p := MustNew(Config{
+		Credentials: []string{"root:root"},
+		Dictionary:  "common",
+	})
+
+	if !p.Accept(addr, "root", "root") {
+		t.Errorf("Expected root:root to be accepted")
+	}
+	if p.Accept(addr, "admin", "admin") {
+		t.Errorf("Expected admin:admin to be rejected without content packs")
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	p := MustNew(Config{
+		Credentials: []string{"*"},
+	})
+
+	if !p.Accept(addr, "any", "thing") {
+		t.Errorf("Expected any credentials to be accepted")
+	}
+}
+
+func TestNever(t *testing.T) {
+	p := MustNew(Config{
+		AuthPolicy:  PolicyNever,
+		Credentials: []string{"*"},
+	})
+
+	if p.Accept(addr, "root", "root") {
+		t.Errorf("Expected credentials to be rejected")
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	p := MustNew(Config{
+		AuthPolicy:   PolicyAttempts,
+		AuthAttempts: 2,
+	})
+
+	other := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 2222}
+
+	expected := []bool{false, false, true, false}
+	for i, v := range expected {
+		if p.Accept(addr, "root", "root") != v {
+			t.Errorf("Expected attempt %d to return %t", i, v)
+		}
+	}
+
+	if p.Accept(other, "root", "root") {
+		t.Errorf("Expected attempts to be counted per address")
+	}
+}
+
+func TestAttemptsExpire(t *testing.T) {
+	p := MustNew(Config{
+		AuthPolicy:   PolicyAttempts,
+		AuthAttempts: 2,
+	})
+
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time {
+		return now
+	}
+
+	p.Accept(addr, "root", "root")
+
+	// the failures are forgotten after the window
+	now = now.Add(failureWindow + time.Minute)
+
+	if p.Accept(addr, "root", "root") {
+		t.Errorf("Expected the failed attempts to be forgotten")
+	}
+
+	if len(p.failures) != 1 || p.failures["192.0.2.1"].count != 1 {
+		t.Errorf("Expected the attempts to start over, got %d addresses", len(p.failures))
+	}
+
+	for i := 0; i < maxFailures+10; i++ {
+		now = now.Add(time.Millisecond)
+		p.Accept(&net.TCPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))}, "root", "root")
+	}
+
+	if len(p.failures) != maxFailures {
+		t.Errorf("Expected at most %d addresses, got %d", maxFailures, len(p.failures))
+	}
+
+	if _, ok := p.failures["192.0.2.1"]; ok {
+		t.Errorf("Expected the address seen longest ago to be forgotten")
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	if _, err := New(Config{AuthPolicy: "sometimes"}); err == nil {
+		t.Errorf("Expected error for unknown policy")
+	}
+}
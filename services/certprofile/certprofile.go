@@ -0,0 +1,273 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package certprofile generates the tls certificates of the services,
+// imitating the self signed certificates of common products. The default
+// certificates generated by Go are an instant honeypot tell.
+package certprofile
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+)
+
+// Default is the name of the profile used when no profile is configured.
+const Default = "snakeoil"
+
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// Profile describes the certificates of a product. The common names can
+// contain placeholders, that are replaced once for every generator:
+// {host} is the requested server name, {alnum} 11 random uppercase letters
+// and digits and {digits} 7 random digits.
+type Profile struct {
+	Name string
+
+	Subject pkix.Name
+
+	// Issuer is the certificate authority the certificate is signed by,
+	// the certificate is self signed when nil
+	Issuer *pkix.Name
+
+	// Email is added as email address to the subject and issuer
+	Email string
+
+	// DefaultHost is the host of clients that didn't send a server name
+	DefaultHost string
+
+	// DNSNames adds the host as subject alternative name
+	DNSNames bool
+
+	KeyBits            int
+	SignatureAlgorithm x509.SignatureAlgorithm
+	SerialBytes        int
+
+	// Validity is the period the certificate is valid, the certificate
+	// was issued up to MaxAge ago
+	Validity time.Duration
+	MaxAge   time.Duration
+
+	// NotAfter is the fixed expiry of the certificate, instead of the
+	// validity
+	NotAfter time.Time
+
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+}
+
+var (
+	m        sync.RWMutex
+	profiles = map[string]*Profile{}
+)
+
+// Register registers the profile.
+func Register(p *Profile) *Profile {
+	m.Lock()
+	defer m.Unlock()
+
+	profiles[p.Name] = p
+	return p
+}
+
+// Get returns the profile with the name.
+func Get(name string) (*Profile, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown certificate profile %s", name)
+	}
+
+	return p, nil
+}
+
+// Names returns the names of the registered profiles.
+func Names() []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// random returns a random string of the characters, the names differ for
+// every sensor.
+func random(chars string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[randInt64(int64(len(chars)))]
+	}
+
+	return string(b)
+}
+
+func randInt64(n int64) int64 {
+	v, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		return 0
+	}
+
+	return v.Int64()
+}
+
+// Generator generates the certificates of a profile, the certificates are
+// cached by host.
+type Generator struct {
+	profile *Profile
+
+	replacer *strings.Replacer
+
+	m     sync.Mutex
+	cache map[string]*tls.Certificate
+
+	// the key of the issuer, the same for all certificates
+	issuerKey *rsa.PrivateKey
+}
+
+// NewGenerator returns a generator of certificates of the profile.
+func NewGenerator(p *Profile) *Generator {
+	return &Generator{
+		profile: p,
+		replacer: strings.NewReplacer(
+			"{alnum}", random("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 11),
+			"{digits}", random("0123456789", 7),
+		),
+		cache: map[string]*tls.Certificate{},
+	}
+}
+
+// name returns the name with the placeholders replaced.
+func (g *Generator) name(n pkix.Name, host string) pkix.Name {
+	n.CommonName = strings.Replace(g.replacer.Replace(n.CommonName), "{host}", host, -1)
+
+	if g.profile.Email != "" {
+		n.ExtraNames = append(append([]pkix.AttributeTypeAndValue{}, n.ExtraNames...), pkix.AttributeTypeAndValue{
+			Type:  oidEmailAddress,
+			Value: g.profile.Email,
+		})
+	}
+
+	return n
+}
+
+// Host returns the host of the certificate served to clients without
+// server name.
+func (g *Generator) Host() string {
+	return g.replacer.Replace(g.profile.DefaultHost)
+}
+
+func keyID(pub *rsa.PublicKey) []byte {
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha1.Sum(der)
+	return sum[:]
+}
+
+// Certificate returns the certificate for the host.
+func (g *Generator) Certificate(host string) (*tls.Certificate, error) {
+	if host == "" {
+		host = g.Host()
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if cert, ok := g.cache[host]; ok {
+		return cert, nil
+	}
+
+	p := g.profile
+
+	priv, err := rsa.GenerateKey(rand.Reader, p.KeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(p.SerialBytes*8-1)))
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Now().Add(-time.Duration(randInt64(int64(p.MaxAge)))).Truncate(time.Second)
+
+	notAfter := p.NotAfter
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(p.Validity)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               g.name(p.Subject, host),
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		SignatureAlgorithm:    p.SignatureAlgorithm,
+		SubjectKeyId:          keyID(&priv.PublicKey),
+		BasicConstraintsValid: true,
+		KeyUsage:              p.KeyUsage,
+		ExtKeyUsage:           p.ExtKeyUsage,
+	}
+
+	if p.DNSNames {
+		template.DNSNames = []string{host}
+	}
+
+	// self signed
+	parent, parentKey := template, priv
+
+	if p.Issuer != nil {
+		if g.issuerKey == nil {
+			if g.issuerKey, err = rsa.GenerateKey(rand.Reader, p.KeyBits); err != nil {
+				return nil, err
+			}
+		}
+
+		parent = &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      g.name(*p.Issuer, host),
+			SubjectKeyId: keyID(&g.issuerKey.PublicKey),
+		}
+
+		parentKey = g.issuerKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &priv.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+	}
+
+	g.cache[host] = cert
+
+	return cert, nil
+}
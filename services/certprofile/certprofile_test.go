@@ -0,0 +1,115 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package certprofile
+
+import (
+	"crypto/x509"
+	"regexp"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	for _, name := range Names() {
+		p, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		g := NewGenerator(p)
+
+		cert, err := g.Certificate("")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		c, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if c.NotBefore.After(c.NotAfter) || len(c.SubjectKeyId) == 0 {
+			t.Errorf("%s: unexpected certificate %+v", name, c)
+		}
+
+		// self signed certificates verify with their own key
+		if p.Issuer == nil {
+			if err := c.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature); err != nil {
+				t.Errorf("%s: expected self signed certificate: %s", name, err)
+			}
+		} else if c.Issuer.String() == c.Subject.String() {
+			t.Errorf("%s: expected certificate signed by issuer", name)
+		}
+
+		if again, _ := g.Certificate(""); again != cert {
+			t.Errorf("%s: expected certificate to be cached", name)
+		}
+	}
+}
+
+func TestIIS(t *testing.T) {
+	p, err := Get("iis")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGenerator(p)
+
+	if host := g.Host(); !regexp.MustCompile(`^WIN-[A-Z0-9]{11}$`).MatchString(host) {
+		t.Errorf("Expected windows computer name, got %s", host)
+	}
+
+	cert, err := g.Certificate("mail.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := x509.ParseCertificate(cert.Certificate[0])
+
+	if c.Subject.CommonName != "mail.example.com" || c.Issuer.CommonName != "mail.example.com" {
+		t.Errorf("Expected host as subject and issuer, got %s %s", c.Subject, c.Issuer)
+	}
+
+	if len(c.DNSNames) != 1 || c.DNSNames[0] != "mail.example.com" {
+		t.Errorf("Expected host as dns name, got %v", c.DNSNames)
+	}
+}
+
+func TestVMware(t *testing.T) {
+	p, err := Get("vmware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := NewGenerator(p).Certificate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := x509.ParseCertificate(cert.Certificate[0])
+
+	if c.Subject.CommonName != "localhost.localdomain" || c.Subject.Organization[0] != "VMware, Inc" || c.Issuer.Organization[0] != "VMware Installer" {
+		t.Errorf("Expected vmware certificate, got %s issued by %s", c.Subject, c.Issuer)
+	}
+
+	email := ""
+	for _, n := range c.Subject.Names {
+		if n.Type.Equal(oidEmailAddress) {
+			email, _ = n.Value.(string)
+		}
+	}
+
+	if email != "ssl-certificates@vmware.com" {
+		t.Errorf("Expected email address, got %s", email)
+	}
+}
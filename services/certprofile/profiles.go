@@ -0,0 +1,151 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package certprofile
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+)
+
+const year = 365 * 24 * time.Hour
+
+var (
+	// the self signed certificate of debian and ubuntu
+	_ = Register(&Profile{
+		Name: "snakeoil",
+		Subject: pkix.Name{
+			CommonName: "{host}",
+		},
+		DefaultHost:        "localhost",
+		DNSNames:           true,
+		KeyBits:            2048,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		SerialBytes:        20,
+		Validity:           10 * year,
+		MaxAge:             2 * year,
+	})
+
+	// the self signed certificate created with the iis manager
+	_ = Register(&Profile{
+		Name: "iis",
+		Subject: pkix.Name{
+			CommonName: "{host}",
+		},
+		DefaultHost:        "WIN-{alnum}",
+		DNSNames:           true,
+		KeyBits:            2048,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		SerialBytes:        16,
+		Validity:           year,
+		MaxAge:             year / 2,
+		KeyUsage:           x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	// the self signed certificate installed with exchange
+	_ = Register(&Profile{
+		Name: "exchange",
+		Subject: pkix.Name{
+			CommonName: "{host}",
+		},
+		DefaultHost:        "WIN-{alnum}",
+		DNSNames:           true,
+		KeyBits:            2048,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		SerialBytes:        16,
+		Validity:           5 * year,
+		MaxAge:             3 * year,
+		KeyUsage:           x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	// the default certificate of esxi
+	_ = Register(&Profile{
+		Name: "vmware",
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Province:           []string{"California"},
+			Locality:           []string{"Palo Alto"},
+			Organization:       []string{"VMware, Inc"},
+			OrganizationalUnit: []string{"VMware ESX Server Default Certificate"},
+			CommonName:         "{host}",
+		},
+		Issuer: &pkix.Name{
+			Country:            []string{"US"},
+			Province:           []string{"California"},
+			Locality:           []string{"Palo Alto"},
+			Organization:       []string{"VMware Installer"},
+			OrganizationalUnit: []string{"VMware ESX Server Default Certificate"},
+		},
+		Email:              "ssl-certificates@vmware.com",
+		DefaultHost:        "localhost.localdomain",
+		DNSNames:           true,
+		KeyBits:            2048,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		SerialBytes:        8,
+		Validity:           10 * year,
+		MaxAge:             3 * year,
+		KeyUsage:           x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	// the default certificate of the qts nas firmware
+	_ = Register(&Profile{
+		Name: "qnap",
+		Subject: pkix.Name{
+			Country:            []string{"TW"},
+			Province:           []string{"Taipei"},
+			Locality:           []string{"Taipei"},
+			Organization:       []string{"QNAP Systems Inc."},
+			OrganizationalUnit: []string{"NAS"},
+			CommonName:         "QNAP NAS",
+		},
+		DefaultHost:        "QNAP NAS",
+		KeyBits:            2048,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		SerialBytes:        9,
+		Validity:           10 * year,
+		MaxAge:             4 * year,
+	})
+
+	// the factory certificate of fortigate appliances, named after the
+	// serial number
+	_ = Register(&Profile{
+		Name: "fortinet",
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Province:           []string{"California"},
+			Locality:           []string{"Sunnyvale"},
+			Organization:       []string{"Fortinet"},
+			OrganizationalUnit: []string{"FortiGate"},
+			CommonName:         "FGT60E4Q1{digits}",
+		},
+		Issuer: &pkix.Name{
+			Country:            []string{"US"},
+			Province:           []string{"California"},
+			Locality:           []string{"Sunnyvale"},
+			Organization:       []string{"Fortinet"},
+			OrganizationalUnit: []string{"Certificate Authority"},
+			CommonName:         "fortinet-subca2001",
+		},
+		Email:              "support@fortinet.com",
+		DefaultHost:        "FGT60E4Q1{digits}",
+		KeyBits:            2048,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		SerialBytes:        3,
+		MaxAge:             3 * year,
+		NotAfter:           time.Date(2038, 1, 19, 3, 14, 7, 0, time.UTC),
+	})
+)
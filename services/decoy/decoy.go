@@ -0,0 +1,216 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package decoy contains the personas of the http service, templates that
+// imitate the login pages of popular web applications and devices.
+package decoy
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Persona describes the login page of the imitated application.
+type Persona struct {
+	Name string
+
+	// Server is the value of the server header
+	Server string
+
+	// Certificate is the name of the certificate profile of the https
+	// service, eg. the factory certificate of the appliance
+	Certificate string
+
+	// LoginPaths are the paths the login page is served on, credentials
+	// posted to these paths are captured.
+	LoginPaths []string
+
+	UsernameField string
+	PasswordField string
+
+	// Page is the html of the login page, FailurePage is returned after a
+	// login attempt. The login page is returned when empty.
+	Page        string
+	FailurePage string
+
+	// Endpoints are the telltale paths of the application, that scanners
+	// use to fingerprint it.
+	Endpoints map[string]Endpoint
+}
+
+// Endpoint is a static resource of the persona.
+type Endpoint struct {
+	ContentType string
+	Body        string
+}
+
+var personas = map[string]*Persona{}
+
+// Register registers the persona.
+func Register(p *Persona) *Persona {
+	personas[p.Name] = p
+	return p
+}
+
+// Get returns the persona with the name.
+func Get(name string) (*Persona, error) {
+	p, ok := personas[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown persona %s", name)
+	}
+
+	return p, nil
+}
+
+// Names returns the names of the registered personas.
+func Names() []string {
+	names := []string{}
+	for name := range personas {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// IsLoginPath returns true if the path is one of the login paths.
+func (p *Persona) IsLoginPath(path string) bool {
+	for _, lp := range p.LoginPaths {
+		if strings.EqualFold(path, lp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Endpoint returns the static resource on the path.
+func (p *Persona) Endpoint(path string) (Endpoint, bool) {
+	e, ok := p.Endpoints[path]
+	return e, ok
+}
+
+// Credentials returns the credentials of the posted login form.
+func (p *Persona) Credentials(form url.Values) (username string, password string, ok bool) {
+	username, password = form.Get(p.UsernameField), form.Get(p.PasswordField)
+	return username, password, username != "" || password != ""
+}
+
+// Response returns the page that should be returned, after a login attempt
+// the failure page is returned.
+func (p *Persona) Response(attempt bool) string {
+	if attempt && p.FailurePage != "" {
+		return p.FailurePage
+	}
+
+	return p.Page
+}
+
+var (
+	_ = Register(&Persona{
+		Name:          "wordpress",
+		Server:        "Apache/2.4.29 (Ubuntu)",
+		LoginPaths:    []string{"/wp-login.php", "/wp-admin/", "/wp-admin"},
+		UsernameField: "log",
+		PasswordField: "pwd",
+		Page: `<!DOCTYPE html>
+<html lang="en-US">
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+<title>Log In &lsaquo; Blog &#8212; WordPress</title>
+<meta name='robots' content='noindex,noarchive' />
+</head>
+<body class="login login-action-login wp-core-ui locale-en-us">
+<div id="login">
+<h1><a href="https://wordpress.org/">Powered by WordPress</a></h1>
+<form name="loginform" id="loginform" action="/wp-login.php" method="post">
+<p><label for="user_login">Username or Email Address</label>
+<input type="text" name="log" id="user_login" class="input" value="" size="20" /></p>
+<p><label for="user_pass">Password</label>
+<input type="password" name="pwd" id="user_pass" class="input" value="" size="20" /></p>
+<p class="forgetmenot"><input name="rememberme" type="checkbox" id="rememberme" value="forever" /> <label for="rememberme">Remember Me</label></p>
+<p class="submit"><input type="submit" name="wp-submit" id="wp-submit" class="button button-primary button-large" value="Log In" />
+<input type="hidden" name="redirect_to" value="/wp-admin/" />
+<input type="hidden" name="testcookie" value="1" /></p>
+</form>
+</div>
+</body>
+</html>
+`,
+		FailurePage: `<!DOCTYPE html>
+<html lang="en-US">
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+<title>Log In &lsaquo; Blog &#8212; WordPress</title>
+</head>
+<body class="login login-action-login wp-core-ui locale-en-us">
+<div id="login">
+<h1><a href="https://wordpress.org/">Powered by WordPress</a></h1>
+<div id="login_error"><strong>ERROR</strong>: The password you entered for the username is incorrect. <a href="/wp-login.php?action=lostpassword">Lost your password?</a><br /></div>
+<form name="loginform" id="loginform" action="/wp-login.php" method="post">
+<p><label for="user_login">Username or Email Address</label>
+<input type="text" name="log" id="user_login" class="input" value="" size="20" /></p>
+<p><label for="user_pass">Password</label>
+<input type="password" name="pwd" id="user_pass" class="input" value="" size="20" /></p>
+<p class="submit"><input type="submit" name="wp-submit" id="wp-submit" class="button button-primary button-large" value="Log In" /></p>
+</form>
+</div>
+</body>
+</html>
+`,
+	})
+
+	_ = Register(&Persona{
+		Name:          "router",
+		Server:        "mini_httpd/1.19 19dec2003",
+		LoginPaths:    []string{"/", "/login.htm", "/login.cgi"},
+		UsernameField: "username",
+		PasswordField: "password",
+		Page: `<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<title>Wireless Router</title>
+</head>
+<body>
+<form method="post" action="/login.cgi">
+<table align="center">
+<tr><td colspan="2"><b>Please log in to continue.</b></td></tr>
+<tr><td>Username:</td><td><input type="text" name="username" maxlength="15"></td></tr>
+<tr><td>Password:</td><td><input type="password" name="password" maxlength="15"></td></tr>
+<tr><td colspan="2"><input type="submit" value="Login"></td></tr>
+</table>
+</form>
+</body>
+</html>
+`,
+		FailurePage: `<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<title>Wireless Router</title>
+</head>
+<body>
+<form method="post" action="/login.cgi">
+<table align="center">
+<tr><td colspan="2"><font color="red">Username or password error, try again!</font></td></tr>
+<tr><td>Username:</td><td><input type="text" name="username" maxlength="15"></td></tr>
+<tr><td>Password:</td><td><input type="password" name="password" maxlength="15"></td></tr>
+<tr><td colspan="2"><input type="submit" value="Login"></td></tr>
+</table>
+</form>
+</body>
+</html>
+`,
+	})
+)
@@ -0,0 +1,99 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package decoy
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/signatures"
+)
+
+func TestPersonas(t *testing.T) {
+	for _, name := range Names() {
+		p, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(p.LoginPaths) == 0 || p.Page == "" || p.UsernameField == "" || p.PasswordField == "" {
+			t.Errorf("Persona %s is incomplete", name)
+		}
+	}
+
+	if _, err := Get("unknown"); err == nil {
+		t.Errorf("Expected error for unknown persona")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	p, _ := Get("globalprotect")
+
+	if !p.IsLoginPath("/global-protect/login.esp") {
+		t.Errorf("Expected login path")
+	}
+
+	form, _ := url.ParseQuery("prot=https%3A&user=vpnuser&passwd=Summer2019")
+
+	username, password, ok := p.Credentials(form)
+	if !ok || username != "vpnuser" || password != "Summer2019" {
+		t.Errorf("Expected credentials vpnuser:Summer2019, got %s:%s", username, password)
+	}
+
+	if _, _, ok := p.Credentials(url.Values{}); ok {
+		t.Errorf("Expected no credentials")
+	}
+}
+
+func TestVPNSignatures(t *testing.T) {
+	tagger, err := signatures.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		URL      string
+		Expected []string
+	}{
+		{"/remote/fgt_lang?lang=/../../../..//////////dev/cmdb/sslvpn_websession", []string{"CVE-2018-13379"}},
+		{"/dana-na/../dana/html5acc/guacamole/../../../../../../etc/passwd?/dana/html5acc/guacamole/", []string{"CVE-2019-11510"}},
+		{"/vpn/../vpns/cfg/smb.conf", []string{"CVE-2019-19781"}},
+		{"/api/v1/totp/user-backup-code/../../system/maintenance/archiving/cloud-server-test-connection", []string{"CVE-2023-46805", "CVE-2024-21887"}},
+		{"/global-protect/login.esp", nil},
+	}
+
+	for _, tst := range tests {
+		e := event.New(
+			event.Category("http"),
+			event.Custom("http.url", tst.URL),
+		)
+
+		tagger.Send(e)
+
+		var cves []string
+		e.Range(func(key, value interface{}) bool {
+			if key == "signature.cve" {
+				cves = value.([]string)
+			}
+
+			return true
+		})
+
+		if !reflect.DeepEqual(cves, tst.Expected) {
+			t.Errorf("%s: expected %v, got %v", tst.URL, tst.Expected, cves)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package decoy
+
+import "github.com/honeytrap/honeytrap/signatures"
+
+// The vpn gateway personas imitate the remote access portals that are
+// targeted most, the exploitation paths that are probed are tagged by the
+// signatures below.
+
+var (
+	_ = Register(&Persona{
+		Name:          "fortinet",
+		Server:        "xxxxxxxx-xxxxx",
+		Certificate:   "fortinet",
+		LoginPaths:    []string{"/remote/login", "/remote/logincheck"},
+		UsernameField: "username",
+		PasswordField: "credential",
+		Page: `<!DOCTYPE html>
+<html lang="en" class="main-app">
+<head>
+<meta charset="UTF-8">
+<meta http-equiv="X-UA-Compatible" content="IE=8; IE=EDGE">
+<title>FortiGate - SSL VPN</title>
+<link href="/sslvpn/css/legacy_theme_setup.css" rel="stylesheet" type="text/css">
+</head>
+<body class="main">
+<div class="view">
+<form class="prompt legacy-prompt" action="/remote/logincheck" method="post" name="f">
+<div class="content with-header">
+<div class="sub-content sub-content-lg"><h1>Please Login</h1></div>
+<div class="wide-inputs">
+<input id="username" name="username" type="text" autocomplete="off" placeholder="Username">
+<input id="credential" name="credential" type="password" autocomplete="off" placeholder="Password">
+</div>
+<div class="button-actions wide"><button class="primary" type="submit" id="login_button">Login</button></div>
+</div>
+</form>
+</div>
+</body>
+</html>
+`,
+		FailurePage: `ret=0,redir=/remote/login?&err=sslvpn_login_permission_denied&lang=en`,
+		Endpoints: map[string]Endpoint{
+			"/remote/fgt_lang": {
+				ContentType: "application/javascript",
+				Body:        `var fgt_lang = {"sslvpn_login_permission_denied":"Permission denied."};`,
+			},
+			"/sslvpn/css/legacy_theme_setup.css": {
+				ContentType: "text/css",
+				Body:        `.legacy-prompt{max-width:400px}`,
+			},
+		},
+	})
+
+	_ = Register(&Persona{
+		Name:          "pulse",
+		LoginPaths:    []string{"/dana-na/auth/url_default/welcome.cgi", "/dana-na/auth/url_default/login.cgi"},
+		UsernameField: "username",
+		PasswordField: "password",
+		Page: `<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<title>Pulse Connect Secure</title>
+<link rel="stylesheet" href="/dana-na/css/ds.css" type="text/css">
+</head>
+<body>
+<form name="frmLogin" action="login.cgi" method="POST" autocomplete="off">
+<input type="hidden" name="tz_offset">
+<table id="table_LoginPage_1">
+<tr><td colspan="2"><h1>Welcome to<br>Pulse Connect Secure</h1></td></tr>
+<tr><td><label for="username_5">Username</label></td><td><input id="username_5" type="text" name="username" size="20"></td></tr>
+<tr><td><label for="password_5">Password</label></td><td><input id="password_5" type="password" name="password" size="20"></td></tr>
+<tr><td colspan="2"><input type="hidden" name="realm" value="Users"><input type="submit" value="Sign In" name="btnSubmit"></td></tr>
+</table>
+</form>
+</body>
+</html>
+`,
+		FailurePage: `<html>
+<head>
+<title>Pulse Connect Secure</title>
+</head>
+<body>
+<table id="table_LoginPage_1">
+<tr><td><span class="cssLarge">Invalid username or password. Please re-enter your user information.</span></td></tr>
+<tr><td><a href="/dana-na/auth/url_default/welcome.cgi">Sign In</a></td></tr>
+</table>
+</body>
+</html>
+`,
+		Endpoints: map[string]Endpoint{
+			"/dana-na/css/ds.css": {
+				ContentType: "text/css",
+				Body:        `body{font-family:Verdana,Arial,sans-serif}`,
+			},
+			"/dana-na/nc/nc_gina_ver.txt": {
+				ContentType: "text/plain",
+				Body:        `9.1.1.2339`,
+			},
+		},
+	})
+
+	_ = Register(&Persona{
+		Name:          "citrix",
+		Server:        "Apache",
+		LoginPaths:    []string{"/vpn/index.html", "/logon/LogonPoint/index.html", "/cgi/login"},
+		UsernameField: "login",
+		PasswordField: "passwd",
+		Page: `<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="X-UA-Compatible" content="IE=edge">
+<title>Citrix Gateway</title>
+<link rel="stylesheet" type="text/css" href="/vpn/images/AccessGateway.ico">
+</head>
+<body>
+<div id="logonbox-container">
+<form action="/cgi/login" method="post" name="vpnForm" autocomplete="off">
+<div class="field"><label for="login">User name</label><input type="text" id="login" name="login" size="30" maxlength="127"></div>
+<div class="field"><label for="passwd">Password</label><input type="password" id="passwd" name="passwd" size="30" maxlength="127"></div>
+<input type="submit" id="Log_On" value="Log On" class="button">
+</form>
+</div>
+</body>
+</html>
+`,
+		FailurePage: `<!DOCTYPE html>
+<html>
+<head>
+<title>Citrix Gateway</title>
+</head>
+<body>
+<div id="logonbox-container">
+<div class="error-message">Incorrect credentials. Try again.</div>
+<a href="/vpn/index.html">Log On</a>
+</div>
+</body>
+</html>
+`,
+		Endpoints: map[string]Endpoint{
+			"/vpn/js/gateway_login_view.js": {
+				ContentType: "application/javascript",
+				Body:        `// gateway login view`,
+			},
+			"/vpn/pluginlist.xml": {
+				ContentType: "text/xml",
+				Body:        `<?xml version="1.0" encoding="UTF-8"?><repository><plugin name="Netscaler Gateway EPA plug-in for Windows" version="13.0.47.22"></plugin></repository>`,
+			},
+		},
+	})
+
+	_ = Register(&Persona{
+		Name:          "globalprotect",
+		Server:        "PanWeb Server/ -",
+		LoginPaths:    []string{"/global-protect/login.esp", "/ssl-vpn/login.esp"},
+		UsernameField: "user",
+		PasswordField: "passwd",
+		Page: `<html>
+<head>
+<title>GlobalProtect Portal</title>
+<link rel="stylesheet" type="text/css" href="/global-protect/portal/css/login.css">
+</head>
+<body class="login-body">
+<div id="content">
+<form name="login" method="post" action="/global-protect/login.esp">
+<input type="hidden" name="prot" value="https:">
+<input type="hidden" name="server" value="">
+<table>
+<tr><td colspan="2"><h1>GlobalProtect Portal</h1></td></tr>
+<tr><td>Name</td><td><input type="text" name="user" id="user"></td></tr>
+<tr><td>Password</td><td><input type="password" name="passwd" id="passwd"></td></tr>
+<tr><td colspan="2"><input type="submit" name="ok" value="LOG IN"></td></tr>
+</table>
+</form>
+</div>
+</body>
+</html>
+`,
+		FailurePage: `<html>
+<head>
+<title>GlobalProtect Portal</title>
+</head>
+<body class="login-body">
+<div id="content">
+<p id="errorMessage">Authentication failed: Invalid username or password</p>
+<a href="/global-protect/login.esp">LOG IN</a>
+</div>
+</body>
+</html>
+`,
+		Endpoints: map[string]Endpoint{
+			"/global-protect/portal/css/login.css": {
+				ContentType: "text/css",
+				Body:        `.login-body{background-color:#f4f4f4}`,
+			},
+			"/ssl-vpn/hipreport.esp": {
+				ContentType: "application/xml",
+				Body:        `<response status="error"><error>Invalid parameters</error></response>`,
+			},
+		},
+	})
+)
+
+var (
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "fortinet-sslvpn-path-traversal",
+		Category: "^http$",
+		Fields:   []string{"http.url"},
+		Pattern:  `(?i)/remote/fgt_lang\?.*lang=.*(\.\.|%2e%2e)`,
+		CVE:      []string{"CVE-2018-13379"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "fortinet-sslvpn-magic-backdoor",
+		Category: "^http$",
+		Fields:   []string{"http.url", "payload"},
+		Pattern:  `magic=4tinet2095866`,
+		CVE:      []string{"CVE-2018-13382"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "fortinet-admin-auth-bypass",
+		Category: "^http$",
+		Fields:   []string{"http.url"},
+		Pattern:  `(?i)^/api/v2/cmdb/system/admin`,
+		CVE:      []string{"CVE-2022-40684"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "pulse-arbitrary-file-read",
+		Category: "^http$",
+		Fields:   []string{"http.url"},
+		Pattern:  `(?i)/dana-na/.*(\.\.|%2e%2e).*/dana/html5acc/guacamole/`,
+		CVE:      []string{"CVE-2019-11510"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "pulse-auth-bypass-command-injection",
+		Category: "^http$",
+		Fields:   []string{"http.url"},
+		Pattern:  `(?i)/api/v1/(totp/user-backup-code|cav/client/status)/(\.\.|%2e%2e)/`,
+		CVE:      []string{"CVE-2023-46805", "CVE-2024-21887"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "citrix-adc-path-traversal",
+		Category: "^http$",
+		Fields:   []string{"http.url"},
+		Pattern:  `(?i)/(\.\.|%2e%2e)/vpns/`,
+		CVE:      []string{"CVE-2019-19781"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "citrix-adc-formssso-overflow",
+		Category: "^http$",
+		Fields:   []string{"http.url"},
+		Pattern:  `(?i)^/gwtest/formssso`,
+		CVE:      []string{"CVE-2023-3519"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "citrix-bleed",
+		Category: "^http$",
+		Fields:   []string{"http.url"},
+		Pattern:  `(?i)^/oauth/idp/\.well-known/openid-configuration`,
+		CVE:      []string{"CVE-2023-4966"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "globalprotect-sslmgr-format-string",
+		Category: "^http$",
+		Fields:   []string{"http.url"},
+		Pattern:  `(?i)^/sslmgr`,
+		CVE:      []string{"CVE-2019-1579"},
+	})
+
+	_ = signatures.Register(&signatures.Signature{
+		ID:       "globalprotect-session-path-traversal",
+		Category: "^http$",
+		Fields:   []string{"http.cookie.sessid"},
+		Pattern:  `(\.\.|%2e%2e)/`,
+		CVE:      []string{"CVE-2024-3400"},
+	})
+)
@@ -0,0 +1,136 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/services/certprofile"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+)
+
+var (
+	_ = Register("dot", DoT)
+)
+
+// dotMaxQueries is the maximum number of queries handled per connection.
+const dotMaxQueries = 32
+
+// DoT is a dns over tls (port 853) endpoint, the queries are captured with
+// the tls fingerprint of the client and refused.
+func DoT(options ...ServicerFunc) Servicer {
+	s := &dotService{
+		dnsService: dnsService{
+			dnsTunnelConfig: defaultDNSTunnelConfig,
+		},
+		certificates: certificates{
+			profile: certprofile.Default,
+		},
+	}
+
+	s.tunnel = newDNSTunnelDetector(&s.dnsTunnelConfig)
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+type dotService struct {
+	dnsService
+
+	certificates
+}
+
+func (s *dotService) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	ja3Digest := ""
+	serverName := ""
+
+	config := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			ja3Digest = hello.JA3Digest()
+			serverName = hello.ServerName
+			return s.getCertificate(hello)
+		},
+		NextProtos: []string{"dot"},
+	}
+
+	tlsConn := tls.Server(conn, config)
+
+	if err := tlsConn.Handshake(); err != nil {
+		s.c.Send(event.New(
+			EventOptions,
+			event.Category("dns"),
+			event.Type("handshake-failed"),
+			event.SourceAddr(conn.RemoteAddr()),
+			event.DestinationAddr(conn.LocalAddr()),
+			event.Custom("dns.transport", "dot"),
+			event.Custom("dns.ja3-digest", ja3Digest),
+			event.Custom("dns.server-name", serverName),
+		))
+
+		return err
+	}
+
+	for i := 0; i < dotMaxQueries; i++ {
+		// messages are prefixed with their length, as dns over tcp
+		var length uint16
+		if err := binary.Read(tlsConn, binary.BigEndian, &length); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		buff := make([]byte, length)
+		if _, err := io.ReadFull(tlsConn, buff); err != nil {
+			return err
+		}
+
+		req := new(dns.Msg)
+		if err := req.Unpack(buff); err != nil {
+			return err
+		}
+
+		options := append(s.query(req, conn),
+			event.Custom("dns.transport", "dot"),
+			event.Custom("dns.ja3-digest", ja3Digest),
+			event.Custom("dns.server-name", serverName),
+		)
+
+		s.c.Send(event.New(options...))
+
+		reply, err := refuse(req)
+		if err != nil {
+			return err
+		}
+
+		frame := make([]byte, 2, 2+len(reply))
+		binary.BigEndian.PutUint16(frame, uint16(len(reply)))
+
+		if _, err := tlsConn.Write(append(frame, reply...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
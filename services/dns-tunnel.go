@@ -0,0 +1,187 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Indicators of dns tunneling.
+const (
+	dnsTunnelEntropy     = "entropy"
+	dnsTunnelLabelLength = "label-length"
+	dnsTunnelRate        = "rate"
+)
+
+// dnsTunnelConfig contains the thresholds of the tunnel detection.
+type dnsTunnelConfig struct {
+	// Entropy is the shannon entropy, in bits per character, of the
+	// subdomain above which the query is considered encoded data
+	Entropy float64 `toml:"tunnel-entropy"`
+
+	// MinLength is the minimum length of the subdomain the entropy is
+	// calculated for, the entropy of short names is meaningless
+	MinLength int `toml:"tunnel-min-length"`
+
+	// LabelLength is the length of a label above which the query is
+	// considered encoded data
+	LabelLength int `toml:"tunnel-label-length"`
+
+	// Rate is the number of queries per source and domain within the window
+	// above which the queries are considered a tunnel
+	Rate   int           `toml:"tunnel-rate"`
+	Window time.Duration `toml:"-"`
+}
+
+var defaultDNSTunnelConfig = dnsTunnelConfig{
+	Entropy:     3.8,
+	MinLength:   20,
+	LabelLength: 40,
+	Rate:        30,
+	Window:      time.Minute,
+}
+
+// dnsTunnel is the result of the analysis of a query.
+type dnsTunnel struct {
+	Domain     string
+	Subdomain  string
+	Entropy    float64
+	Indicators []string
+}
+
+// Detected returns true if the query looks like tunneled data.
+func (t dnsTunnel) Detected() bool {
+	return len(t.Indicators) > 0
+}
+
+type dnsRate struct {
+	start time.Time
+	count int
+}
+
+// dnsTunnelDetector detects tunneling and exfiltration over dns, using the
+// entropy and length of the queried names and the query rate per source.
+type dnsTunnelDetector struct {
+	*dnsTunnelConfig
+
+	m      sync.Mutex
+	rates  map[string]*dnsRate
+	pruned time.Time
+
+	now func() time.Time
+}
+
+func newDNSTunnelDetector(config *dnsTunnelConfig) *dnsTunnelDetector {
+	return &dnsTunnelDetector{
+		dnsTunnelConfig: config,
+		rates:           map[string]*dnsRate{},
+		now:             time.Now,
+	}
+}
+
+// splitDomain splits the name in the subdomain and the domain, the domain
+// being the last two labels of the name.
+func splitDomain(name string) (string, string) {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".")
+	if len(labels) <= 2 {
+		return "", strings.Join(labels, ".")
+	}
+
+	return strings.Join(labels[:len(labels)-2], "."), strings.Join(labels[len(labels)-2:], ".")
+}
+
+// entropy returns the shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+
+	n := float64(len(s))
+
+	h := 0.0
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+
+	return h
+}
+
+// Analyze analyzes the query of the source.
+func (d *dnsTunnelDetector) Analyze(source, name string) dnsTunnel {
+	subdomain, domain := splitDomain(name)
+
+	t := dnsTunnel{
+		Domain:     domain,
+		Subdomain:  subdomain,
+		Indicators: []string{},
+	}
+
+	// the dots separating the labels aren't part of the data
+	data := strings.Replace(subdomain, ".", "", -1)
+
+	t.Entropy = entropy(data)
+	if len(data) >= d.MinLength && t.Entropy > d.Entropy {
+		t.Indicators = append(t.Indicators, dnsTunnelEntropy)
+	}
+
+	for _, label := range strings.Split(subdomain, ".") {
+		if len(label) > d.LabelLength {
+			t.Indicators = append(t.Indicators, dnsTunnelLabelLength)
+			break
+		}
+	}
+
+	if d.count(source+"/"+domain) > d.Rate {
+		t.Indicators = append(t.Indicators, dnsTunnelRate)
+	}
+
+	return t
+}
+
+// count counts the query and returns the number of queries of the key
+// within the window.
+func (d *dnsTunnelDetector) count(key string) int {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	now := d.now()
+
+	if now.Sub(d.pruned) > d.Window {
+		d.pruned = now
+
+		for k, r := range d.rates {
+			if now.Sub(r.start) > d.Window {
+				delete(d.rates, k)
+			}
+		}
+	}
+
+	r, ok := d.rates[key]
+	if !ok || now.Sub(r.start) > d.Window {
+		r = &dnsRate{start: now}
+		d.rates[key] = r
+	}
+
+	r.count++
+	return r.count
+}
@@ -32,7 +32,12 @@ var (
 
 // Dns is a placeholder
 func DNS(options ...ServicerFunc) Servicer {
-	s := &dnsService{}
+	s := &dnsService{
+		dnsTunnelConfig: defaultDNSTunnelConfig,
+	}
+
+	s.tunnel = newDNSTunnelDetector(&s.dnsTunnelConfig)
+
 	for _, o := range options {
 		o(s)
 	}
@@ -40,6 +45,10 @@ func DNS(options ...ServicerFunc) Servicer {
 }
 
 type dnsService struct {
+	dnsTunnelConfig
+
+	tunnel *dnsTunnelDetector
+
 	c pushers.Channel
 }
 
@@ -76,7 +85,16 @@ func (s *dnsService) Handle(ctx context.Context, conn net.Conn) error {
 		return err
 	}
 
-	s.c.Send(event.New(
+	s.c.Send(event.New(s.query(req, conn)...))
+
+	return nil
+}
+
+// query returns the options of the event of the dns request, queries
+// carrying encoded data are tagged as tunnel, instead of ordinary
+// resolution probes.
+func (s *dnsService) query(req *dns.Msg, conn net.Conn) []event.Option {
+	options := []event.Option{
 		EventOptions,
 		event.Category("dns"),
 		event.Type("dns"),
@@ -86,7 +104,36 @@ func (s *dnsService) Handle(ctx context.Context, conn net.Conn) error {
 		event.Custom("dns.opcode", fmt.Sprintf("%d", req.Opcode)),
 		event.Custom("dns.message", fmt.Sprintf("Querying for: %#q", req.Question)),
 		event.Custom("dns.questions", req.Question),
-	))
+	}
 
-	return nil
+	source := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		source = host
+	}
+
+	for _, q := range req.Question {
+		t := s.tunnel.Analyze(source, q.Name)
+		if !t.Detected() {
+			continue
+		}
+
+		options = append(options,
+			event.Type("dns-tunnel"),
+			event.Custom("dns.tunnel.domain", t.Domain),
+			event.Custom("dns.tunnel.entropy", fmt.Sprintf("%.2f", t.Entropy)),
+			event.Custom("dns.tunnel.indicators", t.Indicators),
+		)
+
+		break
+	}
+
+	return options
+}
+
+// refuse returns the reply refusing the request, the encrypted endpoints
+// have to answer for the client to continue.
+func refuse(req *dns.Msg) ([]byte, error) {
+	reply := new(dns.Msg)
+	reply.SetRcode(req, dns.RcodeRefused)
+	return reply.Pack()
 }
@@ -0,0 +1,74 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDNSTunnel(t *testing.T) {
+	config := defaultDNSTunnelConfig
+
+	tests := []struct {
+		name       string
+		indicators []string
+	}{
+		{"www.example.com.", []string{}},
+		{"mail.google.com.", []string{}},
+		{"autodiscover.corp.example.com.", []string{}},
+		{"mzxw6ytboi4dmnzqgy3tsmbrgu2dqnjz.t.example.com.", []string{dnsTunnelEntropy}},
+		{"6d7973656372657470617373776f72643132333435363738393061626364656667.example.com.", []string{dnsTunnelLabelLength}},
+	}
+
+	for _, test := range tests {
+		d := newDNSTunnelDetector(&config)
+
+		tunnel := d.Analyze("1.2.3.4", test.name)
+		if !reflect.DeepEqual(tunnel.Indicators, test.indicators) {
+			t.Errorf("%s: expected indicators %v, got %v (entropy %.2f)", test.name, test.indicators, tunnel.Indicators, tunnel.Entropy)
+		}
+	}
+}
+
+func TestDNSTunnelRate(t *testing.T) {
+	config := defaultDNSTunnelConfig
+	config.Rate = 3
+
+	now := time.Now()
+
+	d := newDNSTunnelDetector(&config)
+	d.now = func() time.Time { return now }
+
+	for i := 0; i < config.Rate; i++ {
+		if d.Analyze("1.2.3.4", "www.example.com.").Detected() {
+			t.Fatalf("Expected query %d not to be detected", i)
+		}
+	}
+
+	if tunnel := d.Analyze("1.2.3.4", "a.example.com."); !tunnel.Detected() {
+		t.Errorf("Expected rate to be detected")
+	}
+
+	if d.Analyze("5.6.7.8", "www.example.com.").Detected() {
+		t.Errorf("Expected rate to be per source")
+	}
+
+	now = now.Add(2 * config.Window)
+
+	if d.Analyze("1.2.3.4", "www.example.com.").Detected() {
+		t.Errorf("Expected rate to reset after the window")
+	}
+}
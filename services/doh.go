@@ -0,0 +1,113 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func (s *httpService) setupDoH() {
+	s.doh = &dnsService{
+		dnsTunnelConfig: defaultDNSTunnelConfig,
+	}
+
+	s.doh.tunnel = newDNSTunnelDetector(&s.doh.dnsTunnelConfig)
+}
+
+// handleDoH answers a dns over https (rfc 8484) query, the query is sent
+// base64url encoded in the dns parameter (GET) or as body (POST). The query
+// is refused, the tls fingerprint is attached by the https service.
+func (s *httpService) handleDoH(conn net.Conn, req *http.Request, body []byte, connOptions event.Option) error {
+	var msg []byte
+
+	switch req.Method {
+	case http.MethodGet:
+		// padding is allowed by some clients, though not by the rfc
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.URL.Query().Get("dns"), "="))
+		if err != nil {
+			return s.dohError(conn, req, http.StatusBadRequest)
+		}
+
+		msg = data
+	case http.MethodPost:
+		if req.Header.Get("Content-Type") != "application/dns-message" {
+			return s.dohError(conn, req, http.StatusUnsupportedMediaType)
+		}
+
+		msg = body
+	default:
+		return s.dohError(conn, req, http.StatusMethodNotAllowed)
+	}
+
+	query := new(dns.Msg)
+	if err := query.Unpack(msg); err != nil {
+		return s.dohError(conn, req, http.StatusBadRequest)
+	}
+
+	options := append(s.doh.query(query, conn),
+		connOptions,
+		event.Custom("dns.transport", "doh"),
+		event.Custom("http.url", req.URL.String()),
+		event.Custom("http.user-agent", req.UserAgent()),
+	)
+
+	s.c.Send(event.New(options...))
+
+	reply, err := refuse(query)
+	if err != nil {
+		return err
+	}
+
+	resp := http.Response{
+		StatusCode: http.StatusOK,
+		Status:     http.StatusText(http.StatusOK),
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Request:    req,
+		Header: http.Header{
+			"Server":       []string{s.Server},
+			"Content-Type": []string{"application/dns-message"},
+		},
+		ContentLength: int64(len(reply)),
+		Body:          ioutil.NopCloser(bytes.NewReader(reply)),
+	}
+
+	return resp.Write(conn)
+}
+
+func (s *httpService) dohError(conn net.Conn, req *http.Request, status int) error {
+	resp := http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Request:    req,
+		Header: http.Header{
+			"Server": []string{s.Server},
+		},
+	}
+
+	return resp.Write(conn)
+}
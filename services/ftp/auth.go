@@ -13,20 +13,21 @@
 // limitations under the License.
 package ftp
 
+import (
+	"net"
+
+	"github.com/honeytrap/honeytrap/services/authpolicy"
+)
+
 type Auth interface {
-	CheckPasswd(string, string) (bool, error)
+	CheckPasswd(net.Addr, string, string) (bool, error)
 }
 
-type User struct {
-	users map[string]string
+// PolicyAuth checks passwords using an authentication policy.
+type PolicyAuth struct {
+	*authpolicy.Policy
 }
 
-func (u *User) CheckPasswd(name, password string) (bool, error) {
-	login := false
-
-	if pw, ok := u.users[name]; ok && pw == password {
-		login = true
-	}
-
-	return login, nil
+func (a *PolicyAuth) CheckPasswd(addr net.Addr, name, password string) (bool, error) {
+	return a.Accept(addr, name, password), nil
 }
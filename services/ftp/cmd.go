@@ -15,6 +15,7 @@ package ftp
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -303,7 +304,7 @@ func (cmd commandEprt) Execute(conn *Conn, param string) {
 
 // commandEpsv responds to the EPSV FTP command. It allows the client to
 // request a passive data socket with more options than the original PASV
-// command. It mainly adds ipv6 support, although we don't support that yet.
+// command. It mainly adds ipv6 support.
 type commandEpsv struct{}
 
 func (cmd commandEpsv) IsExtend() bool {
@@ -319,14 +320,7 @@ func (cmd commandEpsv) RequireAuth() bool {
 }
 
 func (cmd commandEpsv) Execute(conn *Conn, param string) {
-	addr := conn.passiveListenIP()
-	lastIdx := strings.LastIndex(addr, ":")
-	if lastIdx <= 0 {
-		conn.writeMessage(425, "Data connection failed")
-		return
-	}
-
-	socket, err := newPassiveSocket(addr[:lastIdx], conn.PassivePort(), conn.sessionid, conn.tlsConfig)
+	socket, err := newPassiveSocket(conn.passiveListenIP(), conn.PassivePort(), conn.sessionid, conn.tlsConfig)
 	if err != nil {
 		log.Debug(err.Error())
 		conn.writeMessage(425, "Data connection failed")
@@ -511,7 +505,7 @@ func (cmd commandPass) RequireAuth() bool {
 }
 
 func (cmd commandPass) Execute(conn *Conn, param string) {
-	ok, err := conn.server.Auth.CheckPasswd(conn.reqUser, param)
+	ok, err := conn.server.Auth.CheckPasswd(conn.conn.RemoteAddr(), conn.reqUser, param)
 	if err != nil {
 		conn.writeMessage(550, "Checking password error")
 		return
@@ -547,6 +541,12 @@ func (cmd commandPasv) RequireAuth() bool {
 func (cmd commandPasv) Execute(conn *Conn, param string) {
 	listenIP := conn.passiveListenIP()
 
+	// PASV can only express IPv4 addresses, IPv6 clients need to use EPSV
+	if ip := net.ParseIP(listenIP); ip == nil || ip.To4() == nil {
+		conn.writeMessage(522, "Network protocol not supported, use EPSV")
+		return
+	}
+
 	socket, err := newPassiveSocket(listenIP, conn.PassivePort(), conn.sessionid, conn.tlsConfig)
 	if err != nil {
 		conn.writeMessage(425, "Data connection failed, socket")
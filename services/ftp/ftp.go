@@ -15,12 +15,16 @@ package ftp
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"strings"
 
+	"github.com/honeytrap/honeytrap/canary"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/authpolicy"
 	"github.com/honeytrap/honeytrap/services/filesystem"
 	logging "github.com/op/go-logging"
 )
@@ -43,7 +47,13 @@ func FTP(options ...services.ServicerFunc) services.Servicer {
 	}
 
 	s := &ftpService{
-		Opts: Opts{},
+		Opts: Opts{
+			Config: authpolicy.Config{
+				Credentials: []string{
+					"anonymous:anonymous",
+				},
+			},
+		},
 		recv: make(chan string),
 	}
 
@@ -52,10 +62,8 @@ func FTP(options ...services.ServicerFunc) services.Servicer {
 	}
 
 	opts := &ServerOpts{
-		Auth: &User{
-			users: map[string]string{
-				"anonymous": "anonymous",
-			},
+		Auth: &PolicyAuth{
+			authpolicy.MustNew(s.Config),
 		},
 		Name:           s.ServerName,
 		WelcomeMessage: s.Banner,
@@ -82,17 +90,36 @@ func FTP(options ...services.ServicerFunc) services.Servicer {
 
 	log.Debugf("FileSystem rooted at %s", fs.RealPath("/"))
 
+	if c, ok := canary.Plant("ftp/config.bak"); ok {
+		data := fmt.Sprintf(config, c.Username, c.Password)
+
+		if err := ioutil.WriteFile(fs.RealPath("/config.bak"), []byte(data), 0644); err != nil {
+			log.Errorf("Error planting canary: %s", err.Error())
+		}
+	}
+
 	s.driver = NewFileDriver(fs)
 
 	return s
 }
 
+// config is a backup of an application config with a canary credential.
+var config = `[database]
+host = 10.0.3.12
+port = 3306
+name = app_prod
+user = %s
+password = %s
+`
+
 type Opts struct {
 	Banner string `toml:"banner"`
 
 	PsvPortRange string `toml:"passive-port-range"`
 
 	ServerName string `toml:"name"`
+
+	authpolicy.Config
 }
 
 type ftpService struct {
@@ -17,7 +17,6 @@ import (
 	"crypto/tls"
 	"net"
 	"strconv"
-	"strings"
 	"sync"
 )
 
@@ -160,15 +159,7 @@ func (socket *ftpPassiveSocket) GoListenAndServe(sessionid string) (err error) {
 		return
 	}
 
-	add := listener.Addr()
-	parts := strings.Split(add.String(), ":")
-	port, err := strconv.Atoi(parts[len(parts)-1])
-	if err != nil {
-		log.Debug(sessionid, err.Error())
-		return
-	}
-
-	socket.port = port
+	socket.port = listener.Addr().(*net.TCPAddr).Port
 	socket.wg.Add(1)
 
 	if socket.tlsConfig != nil {
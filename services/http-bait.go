@@ -0,0 +1,268 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/rs/xid"
+)
+
+// maxBaitSize is the maximum size of the configured bait files, the files
+// are kept in memory.
+const maxBaitSize = 16 * 1024 * 1024
+
+// baitFile is a file served to lure attackers, like a fake backup or an
+// exposed configuration. Range and conditional requests are supported, so
+// the downloaded byte ranges reveal targeted data theft.
+type baitFile struct {
+	contentType string
+	modTime     time.Time
+	body        []byte
+}
+
+func newBaitFile(contentType string, modTime time.Time, body []byte) *baitFile {
+	return &baitFile{
+		contentType: contentType,
+		modTime:     modTime,
+		body:        body,
+	}
+}
+
+func (f *baitFile) etag() string {
+	hash := sha1.Sum(f.body)
+	return `"` + hex.EncodeToString(hash[:8]) + `"`
+}
+
+// baitModTime is the modification time of the generated bait files.
+var baitModTime = time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+
+// loadBait reads the configured bait files.
+func (s *httpService) loadBait() {
+	for p, name := range s.Bait {
+		fi, err := os.Stat(name)
+		if err != nil {
+			log.Errorf("Error loading bait file %s: %s", name, err.Error())
+			continue
+		} else if fi.Size() > maxBaitSize {
+			log.Errorf("Error loading bait file %s: exceeds maximum size of %d bytes", name, maxBaitSize)
+			continue
+		}
+
+		body, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Errorf("Error loading bait file %s: %s", name, err.Error())
+			continue
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		s.addBait(p, newBaitFile(contentType, fi.ModTime(), body))
+	}
+}
+
+func (s *httpService) addBait(path string, f *baitFile) {
+	if s.bait == nil {
+		s.bait = map[string]*baitFile{}
+	}
+
+	s.bait[path] = f
+}
+
+// byteRange is an inclusive range of bytes.
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) String() string {
+	return fmt.Sprintf("%d-%d", r.start, r.end)
+}
+
+// parseRanges resolves the ranges of the range header against the size,
+// like http.ServeContent does. Ranges starting beyond the size are
+// skipped.
+func parseRanges(s string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("Invalid range: %s", s)
+	}
+
+	ranges := []byteRange{}
+
+	for _, ra := range strings.Split(s[len(prefix):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid range: %s", s)
+		}
+
+		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+
+		var r byteRange
+
+		if start == "" {
+			// suffix range, the last bytes of the file
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid range: %s", s)
+			}
+
+			if n > size {
+				n = size
+			}
+
+			r = byteRange{size - n, size - 1}
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("Invalid range: %s", s)
+			}
+
+			if i >= size {
+				continue
+			}
+
+			r = byteRange{i, size - 1}
+
+			if end != "" {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || i > j {
+					return nil, fmt.Errorf("Invalid range: %s", s)
+				}
+
+				if j < size {
+					r.end = j
+				}
+			}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// responseBuffer is a http.ResponseWriter that buffers the response, to
+// write it to the connection as http.Response.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) WriteHeader(status int) {
+	if rb.status == 0 {
+		rb.status = status
+	}
+}
+
+func (rb *responseBuffer) Write(p []byte) (int, error) {
+	rb.WriteHeader(http.StatusOK)
+	return rb.body.Write(p)
+}
+
+// serveBait serves the bait file, and sends an event with the byte ranges
+// that were downloaded. The server header is omitted when server is empty.
+func (s *httpService) serveBait(conn net.Conn, req *http.Request, f *baitFile, server string, id xid.ID, connOptions event.Option) error {
+	rb := &responseBuffer{
+		header: http.Header{
+			"Content-Type": []string{f.contentType},
+			"Etag":         []string{f.etag()},
+		},
+	}
+
+	if server != "" {
+		rb.header.Set("Server", server)
+	}
+
+	http.ServeContent(rb, req, "", f.modTime, bytes.NewReader(f.body))
+
+	size := int64(len(f.body))
+
+	ranges := []string{}
+	downloaded := int64(0)
+
+	if req.Method == http.MethodHead {
+	} else if rb.status == http.StatusOK {
+		ranges = append(ranges, byteRange{0, size - 1}.String())
+		downloaded = size
+	} else if rb.status == http.StatusPartialContent {
+		served, _ := parseRanges(req.Header.Get("Range"), size)
+
+		for _, r := range served {
+			ranges = append(ranges, r.String())
+			downloaded += r.end - r.start + 1
+		}
+	}
+
+	s.c.Send(event.New(
+		EventOptions,
+		connOptions,
+		event.Category("http"),
+		event.Type("download"),
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("http.sessionid", id.String()),
+		event.Custom("http.url", req.URL.String()),
+		event.Custom("http.status", rb.status),
+		event.Custom("http.download.size", size),
+		event.Custom("http.download.bytes", downloaded),
+		event.Custom("http.download.ranges", ranges),
+		event.Custom("http.download.partial", downloaded > 0 && downloaded < size),
+	))
+
+	resp := http.Response{
+		StatusCode: rb.status,
+		Status:     http.StatusText(rb.status),
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Request:    req,
+		Header:     rb.header,
+		Body:       ioutil.NopCloser(&rb.body),
+	}
+
+	// the content length of responses to head requests is the size of
+	// the omitted body
+	if n, err := strconv.ParseInt(rb.header.Get("Content-Length"), 10, 64); err == nil {
+		resp.ContentLength = n
+	}
+
+	return resp.Write(conn)
+}
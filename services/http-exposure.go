@@ -0,0 +1,465 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/canary"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/rs/xid"
+)
+
+// The exposures emulate repository metadata and environment files left on
+// web servers, that are dumped with tools like git-dumper and dvcs-ripper.
+// The generated repositories can be reconstructed, and contain canary
+// credentials.
+const (
+	exposureGit = "git"
+	exposureSVN = "svn"
+	exposureEnv = "env"
+)
+
+// exposedFile is a file of the generated repository.
+type exposedFile struct {
+	name string
+	body string
+}
+
+// exposedFiles returns the files of the generated repository, the database
+// configuration contains the credential.
+func exposedFiles(c canary.Credential) []exposedFile {
+	return []exposedFile{
+		{".gitignore", "/vendor/\n/storage/logs/\n.env\n"},
+		{"README.md", "# portal\n\nCustomer portal.\n\n## Deployment\n\nDeployed by the CI pipeline, see config/database.php for the database settings.\n"},
+		{"config/database.php", fmt.Sprintf(`<?php
+
+return [
+    'default' => 'mysql',
+
+    'connections' => [
+        'mysql' => [
+            'driver'   => 'mysql',
+            'host'     => '10.0.3.12',
+            'port'     => '3306',
+            'database' => 'portal_prod',
+            'username' => '%s',
+            'password' => '%s',
+            'charset'  => 'utf8mb4',
+        ],
+    ],
+];
+`, c.Username, c.Password)},
+		{"index.php", "<?php\n\nrequire __DIR__ . '/vendor/autoload.php';\n\n$app = require_once __DIR__ . '/bootstrap/app.php';\n\n$app->run();\n"},
+	}
+}
+
+// exposureCredential returns the canary of the location, the credential is
+// generated when canaries are disabled.
+func exposureCredential(location string) canary.Credential {
+	if c, ok := canary.Plant(location); ok {
+		return c
+	}
+
+	password := make([]byte, 12)
+	rand.Read(password)
+
+	return canary.Credential{
+		Username: "deploy",
+		Password: base64.RawURLEncoding.EncodeToString(password),
+		Location: location,
+	}
+}
+
+// plantExposures plants the files of the configured exposures.
+func (s *httpService) plantExposures() {
+	s.exposed = map[string]bool{}
+
+	for _, kind := range s.Exposures {
+		switch kind {
+		case exposureGit:
+			s.plantGit(exposureCredential("http/.git"))
+		case exposureSVN:
+			s.plantSVN(exposureCredential("http/.svn"))
+		case exposureEnv:
+			c := exposureCredential("http/.env")
+			s.addBait("/.env", newBaitFile("text/plain", baitModTime, []byte(fmt.Sprintf(env, c.Username, c.Password))))
+		default:
+			log.Errorf("Unknown exposure: %s", kind)
+			continue
+		}
+
+		s.exposed[kind] = true
+	}
+}
+
+// gitObject returns the id and the compressed loose object.
+func gitObject(typ string, data []byte) ([]byte, []byte) {
+	raw := append([]byte(fmt.Sprintf("%s %d\x00", typ, len(data))), data...)
+
+	id := sha1.Sum(raw)
+
+	var buf bytes.Buffer
+
+	w := zlib.NewWriter(&buf)
+	w.Write(raw)
+	w.Close()
+
+	return id[:], buf.Bytes()
+}
+
+// gitTree is a directory of the generated repository.
+type gitTree struct {
+	blobs map[string][]byte
+	trees map[string]*gitTree
+}
+
+func (t *gitTree) add(name string, id []byte) {
+	if i := strings.Index(name, "/"); i >= 0 {
+		sub, ok := t.trees[name[:i]]
+		if !ok {
+			sub = &gitTree{blobs: map[string][]byte{}, trees: map[string]*gitTree{}}
+			t.trees[name[:i]] = sub
+		}
+
+		sub.add(name[i+1:], id)
+		return
+	}
+
+	t.blobs[name] = id
+}
+
+// write adds the objects of the tree, and returns the id of the tree.
+func (t *gitTree) write(addObject func(string, []byte) []byte) []byte {
+	type entry struct {
+		mode, name string
+		id         []byte
+	}
+
+	entries := []entry{}
+
+	for name, id := range t.blobs {
+		entries = append(entries, entry{"100644", name, id})
+	}
+
+	for name, sub := range t.trees {
+		entries = append(entries, entry{"40000", name, sub.write(addObject)})
+	}
+
+	// trees are sorted as if their names end with a slash
+	key := func(e entry) string {
+		if e.mode == "40000" {
+			return e.name + "/"
+		}
+
+		return e.name
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return key(entries[i]) < key(entries[j])
+	})
+
+	var buf bytes.Buffer
+
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%s %s\x00", e.mode, e.name)
+		buf.Write(e.id)
+	}
+
+	return addObject("tree", buf.Bytes())
+}
+
+// gitIndex returns the index (version 2) of the files.
+func gitIndex(files []exposedFile, ids map[string][]byte, modTime time.Time) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, []uint32{2, uint32(len(files))})
+
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+
+	sort.Strings(names)
+
+	sizes := map[string]int{}
+	for _, f := range files {
+		sizes[f.name] = len(f.body)
+	}
+
+	for i, name := range names {
+		start := buf.Len()
+
+		binary.Write(&buf, binary.BigEndian, []uint32{
+			// ctime and mtime
+			uint32(modTime.Unix()), 0,
+			uint32(modTime.Unix()), 0,
+			// dev, ino, mode, uid, gid and size
+			0x803, uint32(393219 + i), 0100644, 1000, 1000, uint32(sizes[name]),
+		})
+
+		buf.Write(ids[name])
+		binary.Write(&buf, binary.BigEndian, uint16(len(name)))
+		buf.WriteString(name)
+
+		// the entries are padded with one to eight nul bytes
+		n := 8 - (buf.Len()-start)%8
+		buf.Write(make([]byte, n))
+	}
+
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	return buf.Bytes()
+}
+
+// plantGit plants an exposed git repository, the credential is in the
+// url of the remote and in the database configuration.
+func (s *httpService) plantGit(c canary.Credential) {
+	add := func(name string, body []byte) {
+		s.addBait("/.git/"+name, newBaitFile("application/octet-stream", baitModTime, body))
+	}
+
+	addObject := func(typ string, data []byte) []byte {
+		id, object := gitObject(typ, data)
+
+		name := hex.EncodeToString(id)
+		add("objects/"+name[:2]+"/"+name[2:], object)
+
+		return id
+	}
+
+	files := exposedFiles(c)
+
+	root := &gitTree{blobs: map[string][]byte{}, trees: map[string]*gitTree{}}
+	ids := map[string][]byte{}
+
+	for _, f := range files {
+		id := addObject("blob", []byte(f.body))
+
+		ids[f.name] = id
+		root.add(f.name, id)
+	}
+
+	tree := root.write(addObject)
+
+	author := fmt.Sprintf("%s <%s@portal.corp.local> %d +0000", c.Username, c.Username, baitModTime.Unix())
+	message := "Update database configuration"
+
+	commit := hex.EncodeToString(addObject("commit", []byte(fmt.Sprintf("tree %x\nauthor %s\ncommitter %s\n\n%s\n", tree, author, author, message))))
+
+	reflog := fmt.Sprintf("%s %s %s\tcommit (initial): %s\n", strings.Repeat("0", 40), commit, author, message)
+
+	add("HEAD", []byte("ref: refs/heads/master\n"))
+	add("refs/heads/master", []byte(commit+"\n"))
+	add("logs/HEAD", []byte(reflog))
+	add("logs/refs/heads/master", []byte(reflog))
+	add("COMMIT_EDITMSG", []byte(message+"\n"))
+	add("description", []byte("Unnamed repository; edit this file 'description' to name the repository.\n"))
+	add("info/exclude", []byte("# git ls-files --others --exclude-from=.git/info/exclude\n# Lines that start with '#' are comments.\n"))
+	add("index", gitIndex(files, ids, baitModTime))
+	add("config", []byte(fmt.Sprintf(`[core]
+	repositoryformatversion = 0
+	filemode = true
+	bare = false
+	logallrefupdates = true
+[remote "origin"]
+	url = https://%s:%s@git.corp.local/web/portal.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[branch "master"]
+	remote = origin
+	merge = refs/heads/master
+`, c.Username, c.Password)))
+}
+
+// plantSVN plants an exposed subversion working copy, in the format of
+// subversion 1.6 with the pristine files in the text-base directories.
+func (s *httpService) plantSVN(c canary.Credential) {
+	const (
+		revision = 12
+		repos    = "https://svn.corp.local/repos/portal"
+	)
+
+	date := baitModTime.UTC().Format("2006-01-02T15:04:05.000000Z")
+
+	// entries by directory
+	dirs := map[string][]string{"": {}}
+
+	for _, f := range exposedFiles(c) {
+		dir, name := path.Split(f.name)
+		dir = strings.TrimSuffix(dir, "/")
+
+		if _, ok := dirs[dir]; !ok {
+			dirs[dir] = []string{}
+			dirs[""] = append(dirs[""], fmt.Sprintf("%s\ndir\n", dir))
+		}
+
+		checksum := md5.Sum([]byte(f.body))
+
+		// name, kind, revision, url, repos, schedule, text-time, checksum,
+		// cmt-date, cmt-rev and cmt-author
+		dirs[dir] = append(dirs[dir], fmt.Sprintf("%s\nfile\n\n\n\n\n%s\n%x\n%s\n%d\n%s\n", name, date, checksum, date, revision, c.Username))
+
+		s.addBait(path.Join("/", dir, ".svn/text-base", name+".svn-base"), newBaitFile("application/octet-stream", baitModTime, []byte(f.body)))
+	}
+
+	for dir, entries := range dirs {
+		url := repos + "/trunk"
+		if dir != "" {
+			url += "/" + dir
+		}
+
+		sort.Strings(entries)
+
+		body := fmt.Sprintf("10\n\ndir\n%d\n%s\n%s\n\n\n\n%s\n%d\n%s\n\f\n", revision, url, repos, date, revision, c.Username)
+		for _, e := range entries {
+			body += e + "\f\n"
+		}
+
+		s.addBait(path.Join("/", dir, ".svn/entries"), newBaitFile("application/octet-stream", baitModTime, []byte(body)))
+	}
+}
+
+// exposure returns the kind of exposure the path probes for.
+func exposure(p string) string {
+	switch {
+	case p == "/.git" || strings.HasPrefix(p, "/.git/"):
+		return exposureGit
+	case strings.Contains(p+"/", "/.svn/"):
+		return exposureSVN
+	case p == "/.env" || strings.HasPrefix(p, "/.env."):
+		return exposureEnv
+	}
+
+	return ""
+}
+
+// exposureTools recognize the tools dumping the repositories, by their user
+// agent or the files they request.
+var exposureTools = []struct {
+	name  string
+	match func(*http.Request) bool
+}{
+	{"git-dumper", func(req *http.Request) bool {
+		// requests the sample hooks of a new repository
+		return strings.Contains(req.UserAgent(), "git-dumper") || strings.HasPrefix(req.URL.Path, "/.git/hooks/")
+	}},
+	{"gittools", func(req *http.Request) bool {
+		return strings.Contains(strings.ToLower(req.UserAgent()), "gittools")
+	}},
+	{"dvcs-ripper", func(req *http.Request) bool {
+		return strings.Contains(req.UserAgent(), "libwww-perl") || strings.Contains(req.UserAgent(), "dvcs-ripper")
+	}},
+	{"svn-extractor", func(req *http.Request) bool {
+		return strings.Contains(req.UserAgent(), "svn-extractor") || strings.HasSuffix(req.URL.Path, "/.svn/wc.db")
+	}},
+	{"goop", func(req *http.Request) bool {
+		return strings.Contains(req.UserAgent(), "Go-http-client") && strings.HasPrefix(req.URL.Path, "/.git/")
+	}},
+}
+
+// exposureTool returns the tool that requested the file, or the product of
+// the user agent when the tool isn't recognized.
+func exposureTool(req *http.Request) string {
+	for _, tool := range exposureTools {
+		if tool.match(req) {
+			return tool.name
+		}
+	}
+
+	product := strings.Fields(req.UserAgent())
+	if len(product) == 0 {
+		return ""
+	}
+
+	return strings.Split(product[0], "/")[0]
+}
+
+// reconstruction returns true for the requests of the contents of the
+// repository, the repository is being reconstructed.
+func reconstruction(p string) bool {
+	return strings.HasPrefix(p, "/.git/objects/") || strings.Contains(p, "/.svn/text-base/") || strings.Contains(p, "/.svn/pristine/")
+}
+
+// handleExposure logs the probes of the exposed files, the files that don't
+// exist are not found. Returns false when the request is not a probe of an
+// exposure.
+func (s *httpService) handleExposure(conn net.Conn, req *http.Request, id xid.ID, connOptions event.Option) (bool, error) {
+	kind := exposure(req.URL.Path)
+	if !s.exposed[kind] {
+		return false, nil
+	}
+
+	f, found := s.bait[req.URL.Path]
+
+	tool := exposureTool(req)
+
+	if reconstruction(req.URL.Path) {
+		log.Warningf("Reconstruction of %s repository by %s (%s)", kind, conn.RemoteAddr(), tool)
+	}
+
+	s.c.Send(event.New(
+		EventOptions,
+		connOptions,
+		event.Category("http"),
+		event.Type("exposure"),
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("http.sessionid", id.String()),
+		event.Custom("http.url", req.URL.String()),
+		event.Custom("http.exposure", kind),
+		event.Custom("http.exposure.found", found),
+		event.Custom("http.exposure.tool", tool),
+		event.Custom("http.exposure.reconstruction", reconstruction(req.URL.Path)),
+	))
+
+	if found {
+		return true, s.serveBait(conn, req, f, s.Server, id, connOptions)
+	}
+
+	// directory listings are forbidden, like the default of Apache
+	status := http.StatusNotFound
+	if strings.HasSuffix(req.URL.Path, "/") {
+		status = http.StatusForbidden
+	}
+
+	resp := http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Request:    req,
+		Header: http.Header{
+			"Server": []string{s.Server},
+		},
+	}
+
+	return true, resp.Write(conn)
+}
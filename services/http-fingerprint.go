@@ -0,0 +1,318 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// headerRecorder records the data read up to the end of the http headers,
+// the order and casing of the header names are lost when parsed.
+type headerRecorder struct {
+	r   io.Reader
+	buf bytes.Buffer
+
+	done bool
+}
+
+func (hr *headerRecorder) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+
+	if !hr.done {
+		hr.buf.Write(p[:n])
+		hr.done = bytes.Contains(hr.buf.Bytes(), []byte("\r\n\r\n"))
+	}
+
+	return n, err
+}
+
+// headerOrder returns the names of the recorded headers, as sent.
+func (hr *headerRecorder) headerOrder() []string {
+	data := hr.buf.Bytes()
+	if idx := bytes.Index(data, []byte("\r\n\r\n")); idx >= 0 {
+		data = data[:idx]
+	}
+
+	names := []string{}
+
+	// the first line is the request line
+	for i, line := range strings.Split(string(data), "\r\n") {
+		if i == 0 {
+			continue
+		}
+
+		if idx := strings.IndexByte(line, ':'); idx > 0 {
+			names = append(names, line[:idx])
+		}
+	}
+
+	return names
+}
+
+// fingerprintHash returns the md5 digest of the fingerprint, like ja3.
+func fingerprintHash(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// httpFingerprint returns the fingerprint of a http/1 client, which is the
+// protocol and the order and casing of the header names.
+func httpFingerprint(proto string, names []string) string {
+	return proto + "|" + strings.Join(names, ",")
+}
+
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// http2 frame types and flags used for the fingerprint.
+const (
+	http2FrameHeaders      = 0x1
+	http2FramePriority     = 0x2
+	http2FrameSettings     = 0x4
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+
+	http2FlagPadded   = 0x8
+	http2FlagPriority = 0x20
+
+	// http2ErrHTTP11Required asks the client to fall back to http/1.1
+	http2ErrHTTP11Required = 0xd
+
+	// http2MaxFrames is the number of frames read before the headers
+	http2MaxFrames = 16
+	http2MaxFrame  = 1 << 16
+)
+
+var errHTTP2Frame = errors.New("invalid http2 frame")
+
+// pseudoHeaders are the indexes of the pseudo headers in the static hpack
+// table, by the letter used in the fingerprint.
+var pseudoHeaders = map[int]string{
+	1: "a", // :authority
+	2: "m", // :method GET
+	3: "m", // :method POST
+	4: "p", // :path /
+	5: "p", // :path /index.html
+	6: "s", // :scheme http
+	7: "s", // :scheme https
+}
+
+type http2Frame struct {
+	Type     byte
+	Flags    byte
+	StreamID uint32
+	Payload  []byte
+}
+
+func readHTTP2Frame(r io.Reader) (*http2Frame, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if length > http2MaxFrame {
+		return nil, errHTTP2Frame
+	}
+
+	f := &http2Frame{
+		Type:     header[3],
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+		Payload:  make([]byte, length),
+	}
+
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func writeHTTP2Frame(w io.Writer, t byte, payload []byte) error {
+	header := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), t, 0, 0, 0, 0, 0}
+
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// hpackInteger decodes an integer with a prefix of n bits.
+func hpackInteger(data []byte, n uint) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, errHTTP2Frame
+	}
+
+	limit := (1 << n) - 1
+
+	i := int(data[0]) & limit
+	data = data[1:]
+
+	if i < limit {
+		return i, data, nil
+	}
+
+	for m := uint(0); len(data) > 0 && m < 28; m += 7 {
+		b := data[0]
+		data = data[1:]
+
+		i += int(b&0x7f) << m
+		if b&0x80 == 0 {
+			return i, data, nil
+		}
+	}
+
+	return 0, nil, errHTTP2Frame
+}
+
+// pseudoHeaderOrder returns the order of the pseudo headers in the header
+// block. Only the names are needed, the pseudo headers are sent first and
+// refer to the static table.
+func pseudoHeaderOrder(block []byte) []string {
+	order := []string{}
+
+	for len(block) > 0 {
+		var (
+			index int
+			err   error
+		)
+
+		b := block[0]
+
+		switch {
+		case b&0x80 != 0:
+			// indexed header field
+			index, block, err = hpackInteger(block, 7)
+		case b&0xc0 == 0x40:
+			// literal with incremental indexing
+			index, block, err = hpackInteger(block, 6)
+		case b&0xe0 == 0x20:
+			// dynamic table size update
+			if _, block, err = hpackInteger(block, 5); err != nil {
+				return order
+			}
+
+			continue
+		default:
+			// literal without indexing or never indexed
+			index, block, err = hpackInteger(block, 4)
+		}
+
+		if err != nil {
+			return order
+		}
+
+		letter, ok := pseudoHeaders[index]
+		if !ok {
+			// the regular headers follow the pseudo headers
+			return order
+		}
+
+		order = append(order, letter)
+
+		if b&0x80 != 0 {
+			continue
+		}
+
+		// skip the value of the literal
+		length, rest, err := hpackInteger(block, 7)
+		if err != nil || length > len(rest) {
+			return order
+		}
+
+		block = rest[length:]
+	}
+
+	return order
+}
+
+// http2Fingerprint reads the frames of the connection, after the preface,
+// up to the first headers frame and returns the fingerprint as proposed by
+// Akamai: settings|window update|priority frames|pseudo header order.
+func http2Fingerprint(r io.Reader) (string, error) {
+	settings := []string{}
+	windowUpdate := "00"
+	priorities := []string{}
+
+	for i := 0; i < http2MaxFrames; i++ {
+		f, err := readHTTP2Frame(r)
+		if err != nil {
+			return "", err
+		}
+
+		switch f.Type {
+		case http2FrameSettings:
+			for p := f.Payload; len(p) >= 6; p = p[6:] {
+				settings = append(settings, fmt.Sprintf("%d:%d", binary.BigEndian.Uint16(p), binary.BigEndian.Uint32(p[2:])))
+			}
+		case http2FrameWindowUpdate:
+			if f.StreamID == 0 && len(f.Payload) >= 4 {
+				windowUpdate = fmt.Sprintf("%d", binary.BigEndian.Uint32(f.Payload)&0x7fffffff)
+			}
+		case http2FramePriority:
+			if len(f.Payload) >= 5 {
+				priorities = append(priorities, priority(f.StreamID, f.Payload))
+			}
+		case http2FrameHeaders:
+			block := f.Payload
+
+			if f.Flags&http2FlagPadded != 0 {
+				if len(block) < 1 || int(block[0]) >= len(block) {
+					return "", errHTTP2Frame
+				}
+
+				block = block[1 : len(block)-int(block[0])]
+			}
+
+			// the priority of the headers isn't part of the fingerprint
+			if f.Flags&http2FlagPriority != 0 {
+				if len(block) < 5 {
+					return "", errHTTP2Frame
+				}
+
+				block = block[5:]
+			}
+
+			if len(priorities) == 0 {
+				priorities = append(priorities, "0")
+			}
+
+			return strings.Join([]string{
+				strings.Join(settings, ";"),
+				windowUpdate,
+				strings.Join(priorities, ","),
+				strings.Join(pseudoHeaderOrder(block), ","),
+			}, "|"), nil
+		}
+	}
+
+	return "", errHTTP2Frame
+}
+
+// priority formats the priority as stream:exclusive:dependency:weight.
+func priority(streamID uint32, p []byte) string {
+	dependency := binary.BigEndian.Uint32(p)
+
+	exclusive := 0
+	if dependency&0x80000000 != 0 {
+		exclusive = 1
+	}
+
+	return fmt.Sprintf("%d:%d:%d:%d", streamID, exclusive, dependency&0x7fffffff, int(p[4])+1)
+}
@@ -17,15 +17,25 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
+	"path"
 	"strings"
 
+	"github.com/honeytrap/honeytrap/canary"
+	"github.com/honeytrap/honeytrap/content"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services/decoy"
+	"github.com/honeytrap/honeytrap/services/ntlm"
 	"github.com/rs/xid"
 )
 
@@ -37,7 +47,10 @@ var (
 func HTTP(options ...ServicerFunc) Servicer {
 	s := &httpService{
 		httpServiceConfig: httpServiceConfig{
-			Server: "Apache",
+			Server:       "Apache",
+			NTLMDomain:   "CORP",
+			NTLMComputer: "WEB01",
+			DoHPath:      "/dns-query",
 		},
 	}
 
@@ -45,16 +58,85 @@ func HTTP(options ...ServicerFunc) Servicer {
 		o(s)
 	}
 
+	s.plantCanaries()
+	s.plantExposures()
+	s.loadBait()
+	s.setupDoH()
+
 	return s
 }
 
+// env is an exposed environment file with a canary credential.
+var env = `APP_ENV=production
+APP_DEBUG=false
+DB_CONNECTION=mysql
+DB_HOST=10.0.3.12
+DB_PORT=3306
+DB_DATABASE=app_prod
+DB_USERNAME=%s
+DB_PASSWORD=%s
+`
+
+// plantCanaries plants the canary files, that scanners for exposed
+// configs will find.
+func (s *httpService) plantCanaries() {
+	c, ok := canary.Plant("http/.env")
+	if !ok {
+		return
+	}
+
+	s.addBait("/.env", newBaitFile("text/plain", baitModTime, []byte(fmt.Sprintf(env, c.Username, c.Password))))
+}
+
 type httpServiceConfig struct {
 	Server string `toml:"server"`
+
+	// Persona is the name of the decoy login page that will be served,
+	// posted credentials are captured.
+	Persona string `toml:"persona"`
+
+	// NTLM requests NTLM authentication (eg. to imitate WinRM or IIS), the
+	// exchange is captured up to the NetNTLM response.
+	NTLM         bool   `toml:"ntlm"`
+	NTLMDomain   string `toml:"ntlm-domain"`
+	NTLMComputer string `toml:"ntlm-computer"`
+
+	// HTTP2 advertises h2 with alpn, the http/2 client is fingerprinted and
+	// asked to fall back to http/1.1.
+	HTTP2 bool `toml:"http2"`
+
+	// DoH answers dns over https queries on the path, the queries are
+	// captured as dns events.
+	DoH     bool   `toml:"doh"`
+	DoHPath string `toml:"doh-path"`
+
+	// Bait maps paths to local files that are served to lure attackers,
+	// like fake backups. The downloaded byte ranges are captured.
+	Bait map[string]string `toml:"bait"`
+
+	// Exposures emulates exposed repository metadata and environment
+	// files: git, svn and env. The files contain canary credentials.
+	Exposures []string `toml:"exposures"`
+
+	// ContentRoot is the directory of the content packs with the pages
+	// that are served (eg. www), pages are looked up for every request so
+	// updated packs are served right away.
+	ContentRoot string `toml:"content-root"`
 }
 
 type httpService struct {
 	httpServiceConfig
 
+	// bait are the bait files by path, including the files with canary
+	// credentials
+	bait map[string]*baitFile
+
+	// exposed are the kinds of planted exposures
+	exposed map[string]bool
+
+	// doh analyzes the dns over https queries
+	doh *dnsService
+
 	c pushers.Channel
 }
 
@@ -77,6 +159,8 @@ func (s *httpService) CanHandle(payload []byte) bool {
 		return true
 	} else if bytes.HasPrefix(payload, []byte("OPTIONS")) {
 		return true
+	} else if bytes.HasPrefix(payload, []byte("PRI * HTTP/2.0")) {
+		return true
 	}
 
 	return false
@@ -105,8 +189,12 @@ func Cookies(cookies []*http.Cookie) event.Option {
 func (s *httpService) Handle(ctx context.Context, conn net.Conn) error {
 	id := xid.New()
 
+	// NTLM authenticates the connection, the state is kept for the exchange
+	var ntlmServer *ntlm.Server
+
 	for {
-		br := bufio.NewReader(conn)
+		hr := &headerRecorder{r: conn}
+		br := bufio.NewReader(hr)
 
 		req, err := http.ReadRequest(br)
 		if err == io.EOF {
@@ -115,6 +203,10 @@ func (s *httpService) Handle(ctx context.Context, conn net.Conn) error {
 			return err
 		}
 
+		if req.Method == "PRI" && req.Proto == "HTTP/2.0" {
+			return s.handleHTTP2(conn, br, id)
+		}
+
 		defer req.Body.Close()
 
 		body := make([]byte, 1024)
@@ -147,11 +239,59 @@ func (s *httpService) Handle(ctx context.Context, conn net.Conn) error {
 			event.Custom("http.proto", req.Proto),
 			event.Custom("http.host", req.Host),
 			event.Custom("http.url", req.URL.String()),
+			event.Custom("http.header-order", strings.Join(hr.headerOrder(), ",")),
+			event.Custom("http.fingerprint", fingerprintHash(httpFingerprint(req.Proto, hr.headerOrder()))),
 			event.Payload(body),
 			Headers(req.Header),
 			Cookies(req.Cookies()),
 		))
 
+		if s.DoH && req.URL.Path == s.DoHPath {
+			if err := s.handleDoH(conn, req, body, connOptions); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if ok, err := s.handleExposure(conn, req, id, connOptions); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+
+		if f, ok := s.bait[req.URL.Path]; ok {
+			if err := s.serveBait(conn, req, f, s.Server, id, connOptions); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if s.NTLM {
+			if err := s.handleNTLM(conn, req, &ntlmServer, id, connOptions); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if s.Persona != "" {
+			if err := s.handlePersona(conn, req, body, id, connOptions); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if f, ok := s.contentFile(req.URL.Path); ok {
+			if err := s.serveBait(conn, req, f, s.Server, id, connOptions); err != nil {
+				return err
+			}
+
+			continue
+		}
+
 		resp := http.Response{
 			StatusCode: http.StatusOK,
 			Status:     http.StatusText(http.StatusOK),
@@ -169,3 +309,220 @@ func (s *httpService) Handle(ctx context.Context, conn net.Conn) error {
 		}
 	}
 }
+
+// contentFile returns the page of the content packs for the path, the
+// index.html of directories is served.
+func (s *httpService) contentFile(p string) (*baitFile, bool) {
+	if s.ContentRoot == "" {
+		return nil, false
+	}
+
+	name := path.Join(s.ContentRoot, p)
+	if strings.HasSuffix(p, "/") {
+		name = path.Join(name, "index.html")
+	}
+
+	f, ok := content.Default().File(name)
+	if !ok {
+		return nil, false
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(f.Data)
+	}
+
+	return newBaitFile(contentType, f.ModTime, f.Data), true
+}
+
+// handleHTTP2 fingerprints http/2 clients using prior knowledge, or h2
+// negotiated with alpn. The client is asked to fall back to http/1.1 after
+// the first headers frame.
+func (s *httpService) handleHTTP2(conn net.Conn, br *bufio.Reader, id xid.ID) error {
+	// the request line of the preface has been read as request
+	preface := make([]byte, len(http2Preface)-len("PRI * HTTP/2.0\r\n\r\n"))
+	if _, err := io.ReadFull(br, preface); err != nil {
+		return err
+	}
+
+	if err := writeHTTP2Frame(conn, http2FrameSettings, nil); err != nil {
+		return err
+	}
+
+	fingerprint, err := http2Fingerprint(br)
+	if err != nil {
+		return err
+	}
+
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	s.c.Send(event.New(
+		EventOptions,
+		connOptions,
+		event.Category("http"),
+		event.Type("request"),
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("http.sessionid", id.String()),
+		event.Custom("http.proto", "HTTP/2.0"),
+		event.Custom("http.h2-fingerprint", fingerprint),
+		event.Custom("http.fingerprint", fingerprintHash(fingerprint)),
+	))
+
+	goAway := make([]byte, 8)
+	binary.BigEndian.PutUint32(goAway[4:], http2ErrHTTP11Required)
+
+	return writeHTTP2Frame(conn, http2FrameGoAway, goAway)
+}
+
+// handleNTLM responds with an NTLM challenge, the negotiate, challenge and
+// authenticate messages are captured when the client authenticates.
+func (s *httpService) handleNTLM(conn net.Conn, req *http.Request, server **ntlm.Server, id xid.ID, connOptions event.Option) error {
+	scheme, token := "NTLM", ""
+
+	if parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2); len(parts) == 2 {
+		scheme, token = parts[0], strings.TrimSpace(parts[1])
+	}
+
+	header := http.Header{
+		"Server":           []string{s.Server},
+		"WWW-Authenticate": []string{"Negotiate", "NTLM"},
+	}
+
+	data, err := base64.StdEncoding.DecodeString(token)
+	if err != nil || !ntlm.IsNTLMSSP(data) {
+		return s.unauthorized(conn, req, header)
+	}
+
+	t, _ := ntlm.MessageType(data)
+
+	switch {
+	case t == ntlm.MessageNegotiate:
+		*server = ntlm.NewServer(s.NTLMDomain, s.NTLMComputer)
+
+		challenge, err := (*server).ChallengeMessage(data)
+		if err != nil {
+			return s.unauthorized(conn, req, header)
+		}
+
+		header["WWW-Authenticate"] = []string{fmt.Sprintf("%s %s", scheme, base64.StdEncoding.EncodeToString(challenge))}
+	case t == ntlm.MessageAuthenticate && *server != nil:
+		a, err := (*server).ParseAuthenticate(data)
+		if err != nil {
+			return s.unauthorized(conn, req, header)
+		}
+
+		negotiate, challenge := (*server).Messages()
+
+		s.c.Send(event.New(
+			EventOptions,
+			connOptions,
+			event.Category("http"),
+			event.Type("ntlm-authentication"),
+			event.SourceAddr(conn.RemoteAddr()),
+			event.DestinationAddr(conn.LocalAddr()),
+			event.Custom("http.sessionid", id.String()),
+			event.Custom("http.url", req.URL.String()),
+			event.Custom("ntlm.negotiate", hex.EncodeToString(negotiate)),
+			event.Custom("ntlm.challenge", hex.EncodeToString(challenge)),
+			event.Custom("ntlm.authenticate", hex.EncodeToString(data)),
+			a.Options(),
+		))
+
+		*server = nil
+	}
+
+	return s.unauthorized(conn, req, header)
+}
+
+// handlePersona serves the login page of the persona, and captures the
+// credentials posted to the login paths.
+func (s *httpService) handlePersona(conn net.Conn, req *http.Request, body []byte, id xid.ID, connOptions event.Option) error {
+	p, err := decoy.Get(s.Persona)
+	if err != nil {
+		return err
+	}
+
+	status := http.StatusNotFound
+	page := http.StatusText(http.StatusNotFound)
+	contentType := "text/html; charset=UTF-8"
+
+	if e, ok := p.Endpoint(req.URL.Path); ok {
+		if e.ContentType != "" {
+			contentType = e.ContentType
+		}
+
+		// the endpoints are served like bait files
+		return s.serveBait(conn, req, newBaitFile(contentType, baitModTime, []byte(e.Body)), p.Server, id, connOptions)
+	} else if p.IsLoginPath(req.URL.Path) {
+		attempt := false
+
+		if req.Method == http.MethodPost {
+			form, _ := url.ParseQuery(string(body))
+
+			if username, password, ok := p.Credentials(form); ok {
+				attempt = true
+
+				s.c.Send(event.New(
+					EventOptions,
+					connOptions,
+					event.Category("http"),
+					event.Type("login"),
+					event.SourceAddr(conn.RemoteAddr()),
+					event.DestinationAddr(conn.LocalAddr()),
+					event.Custom("http.sessionid", id.String()),
+					event.Custom("http.url", req.URL.String()),
+					event.Custom("http.persona", p.Name),
+					event.Custom("http.username", username),
+					event.Custom("http.password", password),
+				))
+			}
+		}
+
+		status = http.StatusOK
+		page = p.Response(attempt)
+	}
+
+	resp := http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Request:    req,
+		Header: http.Header{
+			"Content-Type": []string{contentType},
+		},
+		ContentLength: int64(len(page)),
+		Body:          ioutil.NopCloser(strings.NewReader(page)),
+	}
+
+	// some appliances don't send a server header
+	if p.Server != "" {
+		resp.Header.Set("Server", p.Server)
+	}
+
+	return resp.Write(conn)
+}
+
+func (s *httpService) unauthorized(conn net.Conn, req *http.Request, header http.Header) error {
+	body := http.StatusText(http.StatusUnauthorized)
+
+	resp := http.Response{
+		StatusCode:    http.StatusUnauthorized,
+		Status:        body,
+		Proto:         req.Proto,
+		ProtoMajor:    req.ProtoMajor,
+		ProtoMinor:    req.ProtoMinor,
+		Request:       req,
+		Header:        header,
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+	}
+
+	return resp.Write(conn)
+}
@@ -0,0 +1,485 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type eventChannel chan event.Event
+
+func (c eventChannel) Send(e event.Event) {
+	c <- e
+}
+
+func TestHTTPNTLM(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	events := make(eventChannel, 10)
+
+	s := HTTP(WithChannel(events))
+	s.(*httpService).NTLM = true
+
+	go s.Handle(context.TODO(), server)
+
+	br := bufio.NewReader(client)
+
+	request := func(authorization string) *http.Response {
+		if authorization != "" {
+			authorization = fmt.Sprintf("Authorization: %s\r\n", authorization)
+		}
+
+		if _, err := fmt.Fprintf(client, "GET /wsman HTTP/1.1\r\nHost: test\r\n%s\r\n", authorization); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := request("")
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("Expected authentication request, got %d", resp.StatusCode)
+	}
+
+	<-events
+
+	negotiate := make([]byte, 32)
+	copy(negotiate, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(negotiate[8:], 1)
+	binary.LittleEndian.PutUint32(negotiate[12:], 0x00000201)
+
+	resp = request("NTLM " + base64.StdEncoding.EncodeToString(negotiate))
+
+	challenge := strings.TrimPrefix(resp.Header.Get("WWW-Authenticate"), "NTLM ")
+	if data, err := base64.StdEncoding.DecodeString(challenge); err != nil || len(data) < 32 {
+		t.Fatalf("Expected challenge message, got %q", challenge)
+	}
+
+	<-events
+
+	// authenticate message with only the user name set
+	user := []byte{'a', 0, 'd', 0, 'm', 0}
+	authenticate := make([]byte, 64)
+	copy(authenticate, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(authenticate[8:], 3)
+	binary.LittleEndian.PutUint16(authenticate[36:], uint16(len(user)))
+	binary.LittleEndian.PutUint32(authenticate[40:], 64)
+	binary.LittleEndian.PutUint32(authenticate[60:], 0x00000001)
+	authenticate = append(authenticate, user...)
+
+	request("NTLM " + base64.StdEncoding.EncodeToString(authenticate))
+
+	<-events
+
+	e := <-events
+	if e.Get("type") != "ntlm-authentication" || e.Get("ntlm.username") != "adm" {
+		t.Errorf("Expected ntlm-authentication event for adm, got %s %s", e.Get("type"), e.Get("ntlm.username"))
+	}
+}
+
+func TestHTTPPersona(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	events := make(eventChannel, 10)
+
+	s := HTTP(WithChannel(events))
+	s.(*httpService).Persona = "wordpress"
+
+	go s.Handle(context.TODO(), server)
+
+	br := bufio.NewReader(client)
+
+	form := "log=admin&pwd=secret&wp-submit=Log+In"
+	if _, err := fmt.Fprintf(client, "POST /wp-login.php HTTP/1.1\r\nHost: test\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: %d\r\n\r\n%s", len(form), form); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "login_error") {
+		t.Errorf("Expected login failure page, got %d", resp.StatusCode)
+	}
+
+	<-events
+
+	e := <-events
+	if e.Get("type") != "login" || e.Get("http.persona") != "wordpress" {
+		t.Fatalf("Expected login event for wordpress, got %s %s", e.Get("type"), e.Get("http.persona"))
+	}
+
+	if e.Get("http.username") != "admin" || e.Get("http.password") != "secret" {
+		t.Errorf("Expected credentials admin:secret, got %s:%s", e.Get("http.username"), e.Get("http.password"))
+	}
+}
+
+func TestHTTPFingerprint(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	events := make(eventChannel, 10)
+
+	s := HTTP(WithChannel(events))
+
+	go s.Handle(context.TODO(), server)
+	go io.Copy(ioutil.Discard, client)
+
+	if _, err := fmt.Fprintf(client, "GET / HTTP/1.1\r\nhost: test\r\nuser-agent: scanner\r\naccept: */*\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-events
+	if e.Get("http.header-order") != "host,user-agent,accept" {
+		t.Errorf("Expected header order host,user-agent,accept, got %s", e.Get("http.header-order"))
+	}
+
+	if expected := fingerprintHash("HTTP/1.1|host,user-agent,accept"); e.Get("http.fingerprint") != expected {
+		t.Errorf("Expected fingerprint %s, got %s", expected, e.Get("http.fingerprint"))
+	}
+}
+
+func TestHTTP2Fingerprint(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	events := make(eventChannel, 10)
+
+	s := HTTP(WithChannel(events))
+
+	if !s.(*httpService).CanHandle([]byte(http2Preface)) {
+		t.Fatal("Expected the http/2 preface to be handled")
+	}
+
+	go s.Handle(context.TODO(), server)
+	go io.Copy(ioutil.Discard, client)
+
+	frame := func(t byte, flags byte, stream uint32, payload []byte) []byte {
+		header := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), t, flags, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(header[5:], stream)
+		return append(header, payload...)
+	}
+
+	settings := []byte{}
+	for _, setting := range [][2]uint32{{1, 65536}, {3, 1000}, {4, 6291456}, {6, 262144}} {
+		p := make([]byte, 6)
+		binary.BigEndian.PutUint16(p, uint16(setting[0]))
+		binary.BigEndian.PutUint32(p[2:], setting[1])
+		settings = append(settings, p...)
+	}
+
+	windowUpdate := make([]byte, 4)
+	binary.BigEndian.PutUint32(windowUpdate, 15663105)
+
+	// priority, :method GET, :authority test, :scheme https, :path /,
+	// user-agent test
+	headers := []byte{0x80, 0, 0, 0, 255, 0x82, 0x41, 4, 't', 'e', 's', 't', 0x87, 0x84, 0x7a, 4, 't', 'e', 's', 't'}
+
+	data := []byte(http2Preface)
+	data = append(data, frame(http2FrameSettings, 0, 0, settings)...)
+	data = append(data, frame(http2FrameWindowUpdate, 0, 0, windowUpdate)...)
+	data = append(data, frame(http2FrameHeaders, 0x24, 1, headers)...)
+
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-events
+
+	expected := "1:65536;3:1000;4:6291456;6:262144|15663105|0|m,a,s,p"
+	if e.Get("http.h2-fingerprint") != expected {
+		t.Errorf("Expected fingerprint %s, got %s", expected, e.Get("http.h2-fingerprint"))
+	}
+}
+
+func TestHTTPDoH(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	events := make(eventChannel, 10)
+
+	s := HTTP(WithChannel(events))
+	s.(*httpService).DoH = true
+
+	go s.Handle(context.TODO(), server)
+
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+
+	data, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fmt.Fprintf(client, "GET /dns-query?dns=%s HTTP/1.1\r\nHost: test\r\n\r\n", base64.RawURLEncoding.EncodeToString(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Id != query.Id || reply.Rcode != dns.RcodeRefused {
+		t.Errorf("Expected refused reply for %d, got %d rcode %d", query.Id, reply.Id, reply.Rcode)
+	}
+
+	<-events
+
+	e := <-events
+	if e.Get("category") != "dns" || e.Get("dns.transport") != "doh" {
+		t.Errorf("Expected dns event over doh, got %s %s", e.Get("category"), e.Get("dns.transport"))
+	}
+}
+
+func TestHTTPBait(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	events := make(eventChannel, 10)
+
+	s := HTTP(WithChannel(events))
+
+	body := strings.Repeat("0123456789", 10)
+	s.(*httpService).addBait("/backup.sql", newBaitFile("application/sql", baitModTime, []byte(body)))
+
+	go s.Handle(context.TODO(), server)
+
+	br := bufio.NewReader(client)
+
+	request := func(header string) (*http.Response, event.Event) {
+		if _, err := fmt.Fprintf(client, "GET /backup.sql HTTP/1.1\r\nHost: test\r\n%s\r\n", header); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		<-events
+		return resp, <-events
+	}
+
+	resp, e := request("Range: bytes=10-19\r\n")
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != "bytes 10-19/100" {
+		t.Fatalf("Expected partial content of bytes 10-19, got %d %s", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+
+	if e.Get("type") != "download" {
+		t.Fatalf("Expected download event, got %s", e.Get("type"))
+	}
+
+	e.Range(func(k, v interface{}) bool {
+		switch k {
+		case "http.download.ranges":
+			if ranges, ok := v.([]string); !ok || strings.Join(ranges, ",") != "10-19" {
+				t.Errorf("Expected range 10-19, got %v", v)
+			}
+		case "http.download.bytes":
+			if v != int64(10) {
+				t.Errorf("Expected 10 bytes downloaded, got %v", v)
+			}
+		case "http.download.partial":
+			if v != true {
+				t.Errorf("Expected partial download")
+			}
+		}
+
+		return true
+	})
+
+	resp, _ = request(fmt.Sprintf("If-None-Match: %s\r\n", resp.Header.Get("Etag")))
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected not modified, got %d", resp.StatusCode)
+	}
+
+	resp, _ = request("")
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 100 {
+		t.Errorf("Expected complete file, got %d with length %d", resp.StatusCode, resp.ContentLength)
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		header string
+		ranges string
+	}{
+		{"bytes=0-9", "0-9"},
+		{"bytes=90-", "90-99"},
+		{"bytes=-5", "95-99"},
+		{"bytes=0-0, 50-200", "0-0,50-99"},
+		{"bytes=200-300", ""},
+	}
+
+	for _, tt := range tests {
+		ranges, err := parseRanges(tt.header, 100)
+		if err != nil {
+			t.Errorf("Error parsing %s: %s", tt.header, err.Error())
+			continue
+		}
+
+		s := []string{}
+		for _, r := range ranges {
+			s = append(s, r.String())
+		}
+
+		if strings.Join(s, ",") != tt.ranges {
+			t.Errorf("Expected ranges %s for %s, got %s", tt.ranges, tt.header, strings.Join(s, ","))
+		}
+	}
+
+	if _, err := parseRanges("items=0-9", 100); err == nil {
+		t.Errorf("Expected error for invalid unit")
+	}
+}
+
+func TestHTTPExposure(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	events := make(eventChannel, 10)
+
+	s := HTTP(WithChannel(events))
+	s.(*httpService).Exposures = []string{"git"}
+	s.(*httpService).plantExposures()
+
+	go s.Handle(context.TODO(), server)
+
+	br := bufio.NewReader(client)
+
+	request := func(path string) (*http.Response, []byte, event.Event) {
+		if _, err := fmt.Fprintf(client, "GET %s HTTP/1.1\r\nHost: test\r\nUser-Agent: python-requests/2.25.1\r\n\r\n", path); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		<-events
+		return resp, body, <-events
+	}
+
+	resp, body, e := request("/.git/HEAD")
+	if resp.StatusCode != http.StatusOK || string(body) != "ref: refs/heads/master\n" {
+		t.Fatalf("Expected HEAD, got %d %q", resp.StatusCode, body)
+	}
+
+	if e.Get("type") != "exposure" || e.Get("http.exposure") != "git" || e.Get("http.exposure.tool") != "python-requests" {
+		t.Errorf("Expected exposure event, got %v", e)
+	}
+
+	// the download event
+	<-events
+
+	_, body, _ = request("/.git/refs/heads/master")
+	<-events
+
+	commit := strings.TrimSpace(string(body))
+
+	resp, body, e = request(fmt.Sprintf("/.git/objects/%s/%s", commit[:2], commit[2:]))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected commit object, got %d", resp.StatusCode)
+	}
+
+	<-events
+
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id := sha1.Sum(object); hex.EncodeToString(id[:]) != commit || !bytes.HasPrefix(object, []byte("commit ")) {
+		t.Errorf("Expected commit %s, got %q", commit, object)
+	}
+
+	e.Range(func(k, v interface{}) bool {
+		if k == "http.exposure.reconstruction" && v != true {
+			t.Errorf("Expected reconstruction")
+		}
+
+		return true
+	})
+
+	resp, _, e = request("/.git/packed-refs")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected not found, got %d", resp.StatusCode)
+	}
+
+	e.Range(func(k, v interface{}) bool {
+		if k == "http.exposure.found" && v != false {
+			t.Errorf("Expected packed-refs not to be found")
+		}
+
+		return true
+	})
+}
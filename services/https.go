@@ -15,17 +15,12 @@ package services
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"fmt"
-	"math/big"
 	"net"
 	"sync"
-	"time"
 
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/services/certprofile"
+	"github.com/honeytrap/honeytrap/services/decoy"
 	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
 
 	"github.com/honeytrap/honeytrap/pushers"
@@ -39,110 +34,103 @@ func HTTPS(options ...ServicerFunc) Servicer {
 	s := &httpsService{
 		httpService: httpService{
 			httpServiceConfig: httpServiceConfig{
-				Server: "Apache",
+				Server:  "Apache",
+				DoHPath: "/dns-query",
 			},
 		},
 		tlsConfig: &tls.Config{},
-		n:         0,
-		m:         sync.Mutex{},
-		cache:     map[string]*tls.Certificate{},
 	}
 
 	for _, o := range options {
 		o(s)
 	}
 
+	s.certificates.profile = s.CertificateProfile
+
+	// the certificate of the persona, eg. of the appliance
+	if s.certificates.profile != "" {
+	} else if p, err := decoy.Get(s.Persona); err == nil && p.Certificate != "" {
+		s.certificates.profile = p.Certificate
+	} else {
+		s.certificates.profile = certprofile.Default
+	}
+
+	s.plantCanaries()
+	s.plantExposures()
+	s.loadBait()
+	s.setupDoH()
+
 	return s
 }
 
 type httpsService struct {
 	httpService
 
+	// CertificateProfile is the name of the profile of the generated
+	// certificates, eg. iis or vmware. The profile of the persona is used
+	// when not set.
+	CertificateProfile string `toml:"certificate-profile"`
+
 	tlsConfig *tls.Config
 
-	c pushers.Channel
+	certificates
 
-	n int64
+	c pushers.Channel
+}
 
-	m sync.Mutex
+// certificates generates the certificates for the requested server names,
+// imitating the certificates of the profile.
+type certificates struct {
+	// profile is the name of the certificate profile
+	profile string
 
-	cache map[string]*tls.Certificate
+	m         sync.Mutex
+	generator *certprofile.Generator
 }
 
 func (s *httpsService) SetChannel(c pushers.Channel) {
 	s.c = c
 }
 
-func (s *httpsService) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+func (s *certificates) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	s.m.Lock()
-	defer s.m.Unlock()
-
-	if cert, ok := s.cache[hello.ServerName]; ok {
-		return cert, nil
-	}
 
-	s.n++
+	if s.generator == nil {
+		p, err := certprofile.Get(s.profile)
+		if err != nil {
+			log.Errorf("Error generating certificate: %s", err.Error())
 
-	ca := &x509.Certificate{
-		SerialNumber: big.NewInt(s.n),
-		Subject: pkix.Name{
-			Country:            []string{""},
-			Organization:       []string{""},
-			OrganizationalUnit: []string{""},
-		},
-		Issuer: pkix.Name{
-			Country:            []string{""},
-			Organization:       []string{""},
-			OrganizationalUnit: []string{""},
-			Locality:           []string{""},
-			Province:           []string{""},
-			StreetAddress:      []string{""},
-			PostalCode:         []string{""},
-			SerialNumber:       fmt.Sprintf("%d", 0),
-			CommonName:         hello.ServerName,
-		},
-		SignatureAlgorithm:    x509.SHA512WithRSA,
-		PublicKeyAlgorithm:    x509.ECDSA,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(1, 0, 0),
-		SubjectKeyId:          []byte{},
-		BasicConstraintsValid: true,
-		IsCA:        false,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-	}
-
-	priv, _ := rsa.GenerateKey(rand.Reader, 4096)
-
-	pub := &priv.PublicKey
+			p, _ = certprofile.Get(certprofile.Default)
+		}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, pub, priv)
-	if err != nil {
-		return nil, err
+		s.generator = certprofile.NewGenerator(p)
 	}
 
-	cert := &tls.Certificate{
-		Certificate: [][]byte{certBytes},
-		PrivateKey:  priv,
-	}
+	g := s.generator
 
-	s.cache[hello.ServerName] = cert
+	s.m.Unlock()
 
-	return cert, nil
+	return g.Certificate(hello.ServerName)
 }
 
 func (s *httpsService) Handle(ctx context.Context, conn net.Conn) error {
 	ja3Digest := ""
 	serverName := ""
 
-	tlsConn := tls.Server(conn, &tls.Config{
+	config := &tls.Config{
 		Certificates: []tls.Certificate{},
 		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 			ja3Digest = hello.JA3Digest()
 			serverName = hello.ServerName
 			return s.getCertificate(hello)
 		},
-	})
+	}
+
+	if s.HTTP2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	tlsConn := tls.Server(conn, config)
 
 	if err := tlsConn.Handshake(); err != nil {
 		s.c.Send(event.New(
@@ -109,6 +109,9 @@ func (s *ippService) Handle(ctx context.Context, conn net.Conn) error {
 		return err
 	}
 
+	document := ippResp.data
+	file := ""
+
 	if len(ippResp.data) == 0 {
 		// no print data
 	} else if s.StorageDir == "" {
@@ -130,22 +133,36 @@ func (s *ippService) Handle(ctx context.Context, conn net.Conn) error {
 		p := path.Join(s.StorageDir, fmt.Sprintf("%s%s", time.Now().Format("ipp-20060102150405"), ext))
 		log.Debugf("Data size %v, file %v", len(ippResp.data), p)
 
-		ioutil.WriteFile(p, ippResp.data, 0644)
+		if err := ioutil.WriteFile(p, ippResp.data, 0644); err != nil {
+			log.Errorf("Error storing print data: %s", err.Error())
+		} else {
+			file = p
+		}
+	}
+
+	operation, configRead := operationName(ippResp.operation)
 
-		ippResp.data = []byte("Print data stored to " + p)
+	eventType := "request"
+	if configRead {
+		eventType = "config-read"
+	} else if len(document) > 0 {
+		eventType = "print-job"
 	}
 
 	s.ch.Send(event.New(
 		services.EventOptions,
 		event.Category("ipp"),
-		event.Type("request"),
+		event.Type(eventType),
 		event.SourceAddr(conn.RemoteAddr()),
 		event.DestinationAddr(conn.LocalAddr()),
 		event.Custom("http.url", req.URL.String()),
+		event.Custom("ipp.operation", operation),
 		event.Custom("ipp.uri", ippResp.uri),
 		event.Custom("ipp.user", ippResp.username),
 		event.Custom("ipp.job-name", string(ippResp.jobname)),
-		event.Custom("ipp.data", string(ippResp.data)),
+		event.Custom("ipp.document-format", ippResp.format),
+		event.Custom("ipp.file", file),
+		event.Payload(document),
 	))
 
 	rbody := ippResp.encode()
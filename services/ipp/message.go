@@ -15,6 +15,7 @@ package ipp
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/honeytrap/honeytrap/services/decoder"
 )
@@ -53,7 +54,9 @@ const (
 	opPrintJob         int16 = 0x0002
 	opValidateJob      int16 = 0x0004
 	opCreateJob        int16 = 0x0005
+	opSendDocument     int16 = 0x0006
 	opGetJobAttrib     int16 = 0x0009
+	opGetJobs          int16 = 0x000a
 	opGetPrinterAttrib int16 = 0x000b
 	opCupsGetDevices   int16 = 0x400b
 
@@ -71,11 +74,12 @@ type ippMsg struct {
 	attributes   []*attribGroup
 
 	//Extra, for our own use
-	data     []byte //if there is data otherwise nil
-	username string
-	uri      string
-	format   string
-	jobname  string
+	operation int16  //operation-id of the request
+	data      []byte //if there is data otherwise nil
+	username  string
+	uri       string
+	format    string
+	jobname   string
 }
 
 func (m *ippMsg) decode(raw []byte) error {
@@ -170,6 +174,31 @@ func (m *ippMsg) setGetDevices() {
 	m.attributes = append(m.attributes, grp)
 }
 
+// operationName returns the name of the operation, and whether the operation
+// reads the printer configuration.
+func operationName(op int16) (string, bool) {
+	switch op {
+	case opPrintJob:
+		return "print-job", false
+	case opValidateJob:
+		return "validate-job", false
+	case opCreateJob:
+		return "create-job", false
+	case opSendDocument:
+		return "send-document", false
+	case opGetJobAttrib:
+		return "get-job-attributes", true
+	case opGetJobs:
+		return "get-jobs", true
+	case opGetPrinterAttrib:
+		return "get-printer-attributes", true
+	case opCupsGetDevices:
+		return "cups-get-devices", true
+	}
+
+	return fmt.Sprintf("0x%04x", uint16(op)), false
+}
+
 // Returns a IPP response based on the IPP request
 func ippHandler(ippBody []byte) (*ippMsg, error) {
 	body := &ippMsg{}
@@ -185,6 +214,7 @@ func ippHandler(ippBody []byte) (*ippMsg, error) {
 		versionMinor: body.versionMinor,
 		statusCode:   sOk,
 		requestID:    body.requestID,
+		operation:    body.statusCode,
 		data:         body.data,
 	}
 
@@ -203,10 +233,15 @@ func ippHandler(ippBody []byte) (*ippMsg, error) {
 	case opPrintJob:
 		log.Debug("Print Job")
 		rbody.setPrintJobResponse(body)
+	case opSendDocument:
+		log.Debug("Send Document")
+		rbody.setPrintJobResponse(body)
 	case opValidateJob:
 		log.Debug("Validate Job")
 	case opGetJobAttrib:
 		log.Debug("IPP: Get Job Attributes")
+	case opGetJobs:
+		log.Debug("IPP: Get Jobs")
 	case opCupsGetDevices:
 		log.Debug("IPP: CUPS Get Devices")
 		rbody.setGetDevices()
@@ -0,0 +1,332 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jetdirect
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/jetdirect")
+
+var (
+	_ = services.Register("jetdirect", JetDirect)
+)
+
+const (
+	// readTimeout is the time the printer waits for data
+	readTimeout = time.Minute
+
+	// maxLineSize is the size of the chunks the data is read in, print
+	// data without newlines isn't buffered whole
+	maxLineSize = 64 * 1024
+
+	// maxCommands limits the pjl commands recorded per connection
+	maxCommands = 256
+)
+
+// uel is the universal exit language sequence, that starts and ends pjl jobs
+var uel = []byte("\x1b%-12345X")
+
+// JetDirect returns the raw printing (port 9100) service. Print jobs are
+// captured as payload, the PJL commands that read or change the printer
+// configuration are reported separately.
+func JetDirect(options ...services.ServicerFunc) services.Servicer {
+	s := &jetdirectService{
+		Config: Config{
+			Model:      "HP LaserJet 4250",
+			Serial:     "CNRXT12345",
+			StorageDir: "",
+			SizeLimit:  104857600,
+		},
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+type Config struct {
+	Model  string `toml:"model"`
+	Serial string `toml:"serial"`
+
+	StorageDir string `toml:"storage-dir"`
+
+	SizeLimit int `toml:"size-limit"`
+}
+
+type jetdirectService struct {
+	Config
+
+	ch pushers.Channel
+}
+
+func (s *jetdirectService) SetChannel(c pushers.Channel) {
+	s.ch = c
+}
+
+// pjlAccess returns how the pjl command accesses the printer: read, write
+// or job for job control and printing.
+func pjlAccess(command string) string {
+	fields := strings.Fields(strings.ToUpper(command))
+	if len(fields) < 2 {
+		return "job"
+	}
+
+	switch fields[1] {
+	case "INFO", "INQUIRE", "DINQUIRE", "USTATUS", "FSDIRLIST", "FSQUERY", "FSUPLOAD":
+		return "read"
+	case "SET", "DEFAULT", "INITIALIZE", "RESET", "RDYMSG", "OPMSG", "STMSG",
+		"FSDOWNLOAD", "FSAPPEND", "FSDELETE", "FSMKDIR":
+		return "write"
+	}
+
+	return "job"
+}
+
+// response returns the response of the printer to the pjl command, or nil
+// if the command doesn't have a response.
+func (s *jetdirectService) response(command string) []byte {
+	fields := strings.Fields(strings.ToUpper(command))
+	if len(fields) < 2 {
+		return nil
+	}
+
+	body := ""
+
+	switch fields[1] {
+	case "INFO":
+		category := ""
+		if len(fields) > 2 {
+			category = fields[2]
+		}
+
+		switch category {
+		case "ID":
+			body = fmt.Sprintf("\"%s\"\r\n", s.Model)
+		case "STATUS":
+			body = "CODE=10001\r\nDISPLAY=\"Ready\"\r\nONLINE=TRUE\r\n"
+		case "CONFIG":
+			body = "IN TRAYS [2 ENUMERATED]\r\n\tINTRAY1 MP\r\n\tINTRAY2 PC\r\nDUPLEX\r\nMEMORY=67108864\r\nDISPLAY LINES=4\r\nDISPLAY CHARACTER SIZE=20\r\n"
+		case "PRODINFO":
+			body = fmt.Sprintf("SERIALNUMBER=%s\r\n", s.Serial)
+		case "FILESYS":
+			body = "VOLUME TOTAL SIZE FREE SIZE LOCATION LABEL STATUS\r\n0:\t8388608\t8044544\tRAM\t?\tREAD-WRITE\r\n"
+		default:
+			body = "?\r\n"
+		}
+	case "INQUIRE", "DINQUIRE":
+		body = "?\r\n"
+	case "FSDIRLIST", "FSQUERY", "FSUPLOAD":
+		// the file doesn't exist
+		body = "FILEERROR=3\r\n"
+	case "ECHO":
+	default:
+		return nil
+	}
+
+	return []byte(fmt.Sprintf("%s\r\n%s\f", strings.TrimSpace(command), body))
+}
+
+// pjlValue returns the value of the variable in the pjl command.
+func pjlValue(command string, name string) string {
+	i := strings.Index(strings.ToUpper(command), name+"=")
+	if i < 0 {
+		return ""
+	}
+
+	v := strings.TrimSpace(command[i+len(name)+1:])
+	if strings.HasPrefix(v, "\"") {
+		if j := strings.Index(v[1:], "\""); j >= 0 {
+			return v[1 : j+1]
+		}
+	}
+
+	if fields := strings.Fields(v); len(fields) > 0 {
+		return fields[0]
+	}
+
+	return ""
+}
+
+func (s *jetdirectService) store(data []byte, language string) (string, error) {
+	ext := ".prn"
+
+	switch strings.ToUpper(language) {
+	case "POSTSCRIPT":
+		ext = ".ps"
+	case "PCL", "PCLXL":
+		ext = ".pcl"
+	case "PDF":
+		ext = ".pdf"
+	}
+
+	p := path.Join(s.StorageDir, fmt.Sprintf("%s%s", time.Now().Format("jetdirect-20060102150405"), ext))
+	return p, ioutil.WriteFile(p, data, 0644)
+}
+
+func (s *jetdirectService) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("jetdirect"),
+		connOptions,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+	}
+
+	br := bufio.NewReaderSize(conn, maxLineSize)
+
+	job := &bytes.Buffer{}
+	commands := []string{}
+	jobName, language := "", ""
+	truncated, commandsTruncated := false, false
+
+	var err error
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		var line []byte
+
+		// lines longer than the buffer are read in chunks, these are
+		// print data
+		line, err = br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+
+		l := line
+		for bytes.HasPrefix(l, uel) {
+			l = l[len(uel):]
+		}
+
+		if len(l) >= 4 && bytes.EqualFold(l[:4], []byte("@PJL")) {
+			command := strings.TrimSpace(string(l))
+
+			if len(commands) >= maxCommands {
+				commandsTruncated = true
+			} else {
+				commands = append(commands, command)
+			}
+
+			switch access := pjlAccess(command); {
+			case commandsTruncated:
+				// commands beyond the limit aren't reported
+			case access == "read":
+				s.ch.Send(event.New(
+					append(options,
+						event.Type("config-read"),
+						event.Custom("pjl.command", command),
+					)...,
+				))
+			case access == "write":
+				s.ch.Send(event.New(
+					append(options,
+						event.Type("config-write"),
+						event.Custom("pjl.command", command),
+					)...,
+				))
+			}
+
+			if v := pjlValue(command, "NAME"); v != "" && jobName == "" {
+				jobName = v
+			}
+
+			if v := pjlValue(command, "LANGUAGE"); v != "" {
+				language = v
+			}
+
+			if resp := s.response(command); resp != nil {
+				if _, err = conn.Write(resp); err != nil {
+					break
+				}
+			}
+		} else if job.Len()+len(line) <= s.SizeLimit {
+			job.Write(line)
+		} else {
+			// the rest of the job isn't read
+			job.Write(line[:s.SizeLimit-job.Len()])
+
+			truncated = true
+			break
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	data := bytes.TrimSpace(bytes.Replace(job.Bytes(), uel, nil, -1))
+	if len(data) == 0 && len(commands) == 0 {
+		return nil
+	}
+
+	// connections without print data only query or configure the printer
+	eventType := "print-job"
+	if len(data) == 0 {
+		eventType = "pjl-session"
+	}
+
+	file := ""
+
+	if len(data) == 0 || s.StorageDir == "" {
+		// nothing to store
+	} else if p, err := s.store(data, language); err != nil {
+		log.Errorf("Error storing print job: %s", err.Error())
+	} else {
+		file = p
+	}
+
+	s.ch.Send(event.New(
+		append(options,
+			event.Type(eventType),
+			event.Custom("pjl.commands", commands),
+			event.Custom("pjl.commands-truncated", commandsTruncated),
+			event.Custom("pjl.job-name", jobName),
+			event.Custom("pjl.language", language),
+			event.Custom("jetdirect.truncated", truncated),
+			event.Custom("jetdirect.file", file),
+			event.Payload(data),
+		)...,
+	))
+
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package jetdirect
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+type eventChannel chan event.Event
+
+func (c eventChannel) Send(e event.Event) {
+	c <- e
+}
+
+func TestJetDirect(t *testing.T) {
+	events := make(eventChannel, 10)
+
+	s := JetDirect()
+	s.SetChannel(pushers.Channel(events))
+
+	server, client := net.Pipe()
+
+	done := make(chan error)
+	go func() {
+		done <- s.Handle(context.TODO(), server)
+	}()
+
+	if _, err := client.Write([]byte("\x1b%-12345X@PJL INFO ID\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := bufio.NewReader(client).ReadString('\f')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(response, "HP LaserJet 4250") {
+		t.Errorf("Expected printer model, got %q", response)
+	}
+
+	job := "@PJL JOB NAME=\"invoice\"\r\n@PJL ENTER LANGUAGE=POSTSCRIPT\r\n%!PS\nshowpage\n\x1b%-12345X"
+	if _, err := client.Write([]byte(job)); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-events
+	if e.Get("type") != "config-read" || e.Get("pjl.command") != "@PJL INFO ID" {
+		t.Errorf("Expected config-read event, got %s %s", e.Get("type"), e.Get("pjl.command"))
+	}
+
+	e = <-events
+	if e.Get("type") != "print-job" {
+		t.Fatalf("Expected print-job event, got %s", e.Get("type"))
+	}
+
+	if e.Get("pjl.job-name") != "invoice" || e.Get("pjl.language") != "POSTSCRIPT" {
+		t.Errorf("Expected job invoice in postscript, got %s %s", e.Get("pjl.job-name"), e.Get("pjl.language"))
+	}
+
+	if e.Get("payload") != "%!PS\nshowpage" {
+		t.Errorf("Expected postscript payload, got %q", e.Get("payload"))
+	}
+}
+
+func TestJetDirectLimits(t *testing.T) {
+	events := make(eventChannel, maxCommands+10)
+
+	s := JetDirect().(*jetdirectService)
+	s.SizeLimit = 1024
+	s.SetChannel(pushers.Channel(events))
+
+	server, client := net.Pipe()
+
+	done := make(chan error)
+	go func() {
+		done <- s.Handle(context.TODO(), server)
+	}()
+
+	go func() {
+		for i := 0; i < maxCommands+10; i++ {
+			if _, err := client.Write([]byte("@PJL SET COPIES=1\r\n")); err != nil {
+				return
+			}
+		}
+
+		// print data without newlines, larger than the size limit
+		client.Write(bytes.Repeat([]byte{0x1b}, 4*maxLineSize))
+	}()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+
+	for i := 0; i < maxCommands; i++ {
+		if e := <-events; e.Get("type") != "config-write" {
+			t.Fatalf("Expected config-write event, got %s", e.Get("type"))
+		}
+	}
+
+	e := <-events
+	if e.Get("type") != "print-job" {
+		t.Fatalf("Expected print-job event, got %s", e.Get("type"))
+	}
+
+	values := map[string]interface{}{}
+	e.Range(func(k, v interface{}) bool {
+		values[k.(string)] = v
+		return true
+	})
+
+	if values["jetdirect.truncated"] != true || values["pjl.commands-truncated"] != true {
+		t.Errorf("Expected truncated job and commands, got %v %v", values["jetdirect.truncated"], values["pjl.commands-truncated"])
+	}
+
+	if n := len(values["pjl.commands"].([]string)); n != maxCommands {
+		t.Errorf("Expected %d commands, got %d", maxCommands, n)
+	}
+
+	if n := len(e.Get("payload")); n > s.SizeLimit {
+		t.Errorf("Expected payload within size limit, got %d bytes", n)
+	}
+}
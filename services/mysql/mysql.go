@@ -0,0 +1,512 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/authpolicy"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/mysql")
+
+var (
+	_ = services.Register("mysql", MySQL)
+)
+
+// Authentication plugins.
+const (
+	PluginNative = "mysql_native_password"
+	PluginClear  = "mysql_clear_password"
+)
+
+/*
+Configuration example:
+
+[service.mysql]
+type="mysql"
+version="5.7.33-log"
+auth-plugin="mysql_native_password"
+credentials=["root:root", "root:"]
+
+[[service.mysql.query]]
+match="^select \\* from users"
+columns=["id", "name"]
+rows=[["1", "admin"]]
+*/
+
+// MySQL returns the mysql service. The service completes the handshake,
+// records the credentials and answers the queries of scanners with canned
+// result sets.
+func MySQL(options ...services.ServicerFunc) services.Servicer {
+	s := &mysqlService{
+		Config: Config{
+			Version:        "5.7.33-log",
+			VersionComment: "MySQL Community Server (GPL)",
+			Hostname:       "db01",
+			AuthPlugin:     PluginNative,
+			Databases:      []string{"information_schema", "mysql", "performance_schema", "sys", "wordpress"},
+			Config: authpolicy.Config{
+				Credentials: []string{
+					"root:",
+					"root:root",
+					"root:123456",
+					"root:password",
+					"admin:admin",
+				},
+			},
+		},
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	s.policy = authpolicy.MustNew(s.Config.Config)
+
+	for _, q := range s.Queries {
+		re, err := regexp.Compile("(?i)" + q.Match)
+		if err != nil {
+			log.Errorf("Invalid query %q, ignoring: %s", q.Match, err.Error())
+			continue
+		}
+
+		s.queries = append(s.queries, query{Query: q, re: re})
+	}
+
+	return s
+}
+
+// Query is a canned response to the queries matching the case insensitive
+// regular expression.
+type Query struct {
+	Match string `toml:"match"`
+
+	Columns []string   `toml:"columns"`
+	Rows    [][]string `toml:"rows"`
+
+	// Error is the message of the error returned instead of the result set
+	Error string `toml:"error"`
+}
+
+type query struct {
+	Query
+
+	re *regexp.Regexp
+}
+
+type Config struct {
+	Version        string `toml:"version"`
+	VersionComment string `toml:"version-comment"`
+	Hostname       string `toml:"hostname"`
+
+	// AuthPlugin is mysql_native_password, or mysql_clear_password to ask
+	// the clients for the password in cleartext
+	AuthPlugin string `toml:"auth-plugin"`
+
+	// Databases are listed by show databases
+	Databases []string `toml:"databases"`
+
+	// Queries are checked before the builtin responses
+	Queries []Query `toml:"query"`
+
+	authpolicy.Config
+}
+
+type mysqlService struct {
+	Config
+
+	queries []query
+
+	policy *authpolicy.Policy
+
+	ch pushers.Channel
+}
+
+func (s *mysqlService) SetChannel(c pushers.Channel) {
+	s.ch = c
+}
+
+// salt returns the scramble of the handshake, printable characters only.
+func salt() []byte {
+	b := make([]byte, 20)
+	rand.Read(b)
+
+	for i := range b {
+		b[i] = b[i]%94 + 33
+	}
+
+	return b
+}
+
+// scramble returns the mysql_native_password response of the password:
+// SHA1(password) XOR SHA1(salt + SHA1(SHA1(password))).
+func scramble(salt []byte, password string) []byte {
+	if password == "" {
+		return []byte{}
+	}
+
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+
+	h := sha1.New()
+	h.Write(salt)
+	h.Write(stage2[:])
+
+	token := h.Sum(nil)
+	for i := range token {
+		token[i] ^= stage1[i]
+	}
+
+	return token
+}
+
+// session is the state of the command phase.
+type session struct {
+	username string
+	host     string
+	database string
+	id       uint32
+}
+
+func (s *mysqlService) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("mysql"),
+		connOptions,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+	}
+
+	send := func(t string, o ...event.Option) {
+		o = append([]event.Option{event.Type(t)}, o...)
+
+		s.ch.Send(event.New(
+			append(o, options...)...,
+		))
+	}
+
+	c := newPacketConn(conn)
+
+	sess := &session{}
+
+	b := make([]byte, 4)
+	rand.Read(b)
+	sess.id = binary.LittleEndian.Uint32(b) % 100000
+
+	sess.host = conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(sess.host); err == nil {
+		sess.host = host
+	}
+
+	scrambleData := salt()
+
+	if err := c.writePacket(handshake(s.Version, sess.id, scrambleData, PluginNative)); err != nil {
+		return err
+	}
+
+	payload, err := c.readPacket()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	hr, err := parseHandshakeResponse(payload)
+	if err != nil {
+		return err
+	}
+
+	plugin := hr.plugin
+	if plugin == "" {
+		plugin = PluginNative
+	}
+
+	response := hr.response
+
+	// clients which support pluggable authentication are switched to the
+	// configured plugin, this also switches the caching_sha2_password
+	// clients of mysql 8 to native passwords
+	if hr.capabilities&clientPluginAuth != 0 && plugin != s.AuthPlugin {
+		plugin = s.AuthPlugin
+
+		buf := []byte{0xfe}
+		buf = append(buf, plugin...)
+		buf = append(buf, 0)
+
+		if plugin == PluginNative {
+			buf = append(buf, scrambleData...)
+			buf = append(buf, 0)
+		}
+
+		if err := c.writePacket(buf); err != nil {
+			return err
+		}
+
+		if response, err = c.readPacket(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	password := ""
+	if plugin == PluginClear {
+		password = string(bytes.TrimRight(response, "\x00"))
+	}
+
+	accepted := s.policy.AcceptFunc(conn.RemoteAddr(), func(username, pw string) bool {
+		if username != hr.username {
+			return false
+		}
+
+		if plugin == PluginClear {
+			return pw == password
+		}
+
+		return bytes.Equal(scramble(scrambleData, pw), response)
+	})
+
+	sess.username = hr.username
+	sess.database = hr.database
+
+	authOptions := []event.Option{
+		event.Custom("mysql.username", hr.username),
+		event.Custom("mysql.database", hr.database),
+		event.Custom("mysql.auth-plugin", plugin),
+		event.Custom("mysql.accepted", accepted),
+	}
+
+	if plugin == PluginClear {
+		authOptions = append(authOptions, event.Custom("mysql.password", password))
+	} else {
+		// the salt and response can be cracked offline, the hash is in
+		// the format of hashcat (mode 11200)
+		authOptions = append(authOptions,
+			event.Custom("mysql.salt", hex.EncodeToString(scrambleData)),
+			event.Custom("mysql.response", hex.EncodeToString(response)),
+			event.Custom("mysql.hash", fmt.Sprintf("$mysqlna$%x*%x", scrambleData, response)),
+		)
+	}
+
+	for key, field := range map[string]string{
+		"_client_name":    "mysql.client-name",
+		"_client_version": "mysql.client-version",
+		"_os":             "mysql.client-os",
+		"_platform":       "mysql.client-platform",
+		"program_name":    "mysql.program-name",
+	} {
+		if v, ok := hr.attributes[key]; ok {
+			authOptions = append(authOptions, event.Custom(field, v))
+		}
+	}
+
+	send("authentication", authOptions...)
+
+	if !accepted {
+		usingPassword := "YES"
+		if len(response) == 0 {
+			usingPassword = "NO"
+		}
+
+		return c.writeError(1045, "28000", fmt.Sprintf("Access denied for user '%s'@'%s' (using password: %s)", hr.username, sess.host, usingPassword))
+	}
+
+	if err := c.writeOK(); err != nil {
+		return err
+	}
+
+	options = append(options, event.Custom("mysql.username", hr.username))
+
+	for {
+		payload, err := c.readPacket()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if len(payload) == 0 {
+			return nil
+		}
+
+		switch payload[0] {
+		case comQuit:
+			return nil
+		case comPing:
+			err = c.writeOK()
+		case comInitDB:
+			sess.database = string(payload[1:])
+
+			send("init-db", event.Custom("mysql.database", sess.database))
+
+			err = c.writeOK()
+		case comQuery:
+			q := string(payload[1:])
+
+			send("query",
+				event.Custom("mysql.database", sess.database),
+				event.Custom("mysql.query", q),
+			)
+
+			err = s.query(c, sess, q)
+		case comFieldList:
+			err = c.writeEOF()
+		case comStatistics:
+			err = c.writePacket([]byte("Uptime: 2938112  Threads: 2  Questions: 71263  Slow queries: 0  Opens: 412  Flush tables: 1  Open tables: 405  Queries per second avg: 0.024"))
+		default:
+			send("command", event.Custom("mysql.command", int(payload[0])))
+
+			err = c.writeError(1047, "08S01", "Unknown command")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// query answers the query with the configured responses first, and the
+// builtin responses after. Other queries succeed without results.
+func (s *mysqlService) query(c *packetConn, sess *session, q string) error {
+	q = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(q), ";"))
+	if q == "" {
+		return c.writeError(1065, "42000", "Query was empty")
+	}
+
+	for _, cq := range s.queries {
+		if !cq.re.MatchString(q) {
+			continue
+		}
+
+		if cq.Error != "" {
+			return c.writeError(1064, "42000", cq.Error)
+		}
+
+		return c.writeResultSet(cq.Columns, cq.Rows)
+	}
+
+	fields := strings.Fields(strings.ToLower(q))
+
+	switch {
+	case len(fields) == 2 && fields[0] == "show" && (fields[1] == "databases" || fields[1] == "schemas"):
+		rows := [][]string{}
+		for _, db := range s.Databases {
+			rows = append(rows, []string{db})
+		}
+
+		return c.writeResultSet([]string{"Database"}, rows)
+	case len(fields) == 2 && fields[0] == "show" && fields[1] == "tables":
+		if sess.database == "" {
+			return c.writeError(1046, "3D000", "No database selected")
+		}
+
+		return c.writeResultSet([]string{"Tables_in_" + sess.database}, [][]string{})
+	case len(fields) == 2 && fields[0] == "use":
+		sess.database = strings.Trim(strings.Fields(q)[1], "`")
+		return c.writeOK()
+	case fields[0] == "select":
+		if columns, row, ok := s.selectExpressions(sess, q[len("select"):]); ok {
+			return c.writeResultSet(columns, [][]string{row})
+		}
+	}
+
+	return c.writeOK()
+}
+
+var (
+	limitRegexp = regexp.MustCompile(`(?i)\s+limit\s+\d+$`)
+	aliasRegexp = regexp.MustCompile(`(?i)\s+as\s+`)
+)
+
+// selectExpressions evaluates the select of known variables and functions,
+// like select @@version_comment limit 1 of the mysql client.
+func (s *mysqlService) selectExpressions(sess *session, exprs string) ([]string, []string, bool) {
+	exprs = limitRegexp.ReplaceAllString(strings.TrimSpace(exprs), "")
+
+	columns, row := []string{}, []string{}
+
+	for _, expr := range strings.Split(exprs, ",") {
+		expr = strings.TrimSpace(expr)
+
+		name := expr
+		if parts := aliasRegexp.Split(expr, 2); len(parts) == 2 {
+			expr, name = parts[0], strings.Trim(parts[1], "`'\"")
+		}
+
+		value, ok := s.variable(sess, strings.ToLower(strings.Replace(expr, " ", "", -1)))
+		if !ok {
+			return nil, nil, false
+		}
+
+		columns = append(columns, name)
+		row = append(row, value)
+	}
+
+	return columns, row, true
+}
+
+func (s *mysqlService) variable(sess *session, expr string) (string, bool) {
+	switch expr {
+	case "@@version", "version()", "@@global.version", "@@session.version":
+		return s.Version, true
+	case "@@version_comment":
+		return s.VersionComment, true
+	case "@@hostname":
+		return s.Hostname, true
+	case "database()", "schema()":
+		return sess.database, true
+	case "user()", "session_user()", "system_user()":
+		return sess.username + "@" + sess.host, true
+	case "current_user()", "current_user":
+		return sess.username + "@%", true
+	case "connection_id()":
+		return fmt.Sprintf("%d", sess.id), true
+	case "@@max_allowed_packet":
+		return "4194304", true
+	case "@@tx_isolation", "@@transaction_isolation", "@@session.tx_isolation":
+		return "REPEATABLE-READ", true
+	case "@@autocommit":
+		return "1", true
+	}
+
+	// literals, like select 1 of connection checks
+	if expr != "" && strings.Trim(expr, "0123456789") == "" {
+		return expr, true
+	}
+
+	return "", false
+}
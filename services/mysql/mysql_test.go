@@ -0,0 +1,307 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mysql
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+// connect connects to the service, and returns the connection of the client
+// and the salt of the handshake.
+func connect(t *testing.T, events *harness.Recorder, options ...services.ServicerFunc) (*packetConn, net.Conn, []byte) {
+	s := MySQL(options...)
+	s.SetChannel(events)
+
+	server, client := net.Pipe()
+
+	go s.Handle(context.TODO(), server)
+
+	c := newPacketConn(client)
+
+	payload, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &reader{buf: payload}
+	if version := r.next(1); version == nil || version[0] != 10 {
+		t.Fatalf("Expected protocol version 10, got %v", version)
+	}
+
+	if version := r.nullString(); version != "5.7.33-log" {
+		t.Fatalf("Expected server version, got %q", version)
+	}
+
+	r.next(4)
+	salt := append([]byte{}, r.next(8)...)
+	r.next(1 + 2 + 1 + 2 + 2 + 1 + 10)
+	salt = append(salt, r.next(12)...)
+
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	return c, client, salt
+}
+
+// login sends the handshake response with the auth response of the native
+// plugin.
+func login(t *testing.T, c *packetConn, username string, response []byte, plugin string) {
+	capabilities := uint32(clientProtocol41 | clientSecureConnection | clientConnectAttrs)
+	if plugin != "" {
+		capabilities |= clientPluginAuth
+	}
+
+	buf := []byte{byte(capabilities), byte(capabilities >> 8), byte(capabilities >> 16), byte(capabilities >> 24)}
+	buf = append(buf, 0, 0, 0, 1, charsetUTF8)
+	buf = append(buf, make([]byte, 23)...)
+	buf = append(buf, username...)
+	buf = append(buf, 0, byte(len(response)))
+	buf = append(buf, response...)
+
+	if plugin != "" {
+		buf = append(buf, plugin...)
+		buf = append(buf, 0)
+	}
+
+	attrs := appendLenEncString(nil, "_client_name")
+	attrs = appendLenEncString(attrs, "libmysql")
+	buf = append(buf, appendLenEncString(nil, string(attrs))...)
+
+	if err := c.writePacket(buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// accepted returns the mysql.accepted field of the event.
+func accepted(e event.Event) bool {
+	result := false
+
+	e.Range(func(key, value interface{}) bool {
+		if key == "mysql.accepted" {
+			result, _ = value.(bool)
+		}
+
+		return true
+	})
+
+	return result
+}
+
+func command(t *testing.T, c *packetConn, cmd byte, arg string) {
+	c.seq = 0
+
+	if err := c.writePacket(append([]byte{cmd}, arg...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resultSet reads the rows of a result set.
+func resultSet(t *testing.T, c *packetConn) [][]string {
+	payload, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload[0] == 0xff {
+		t.Fatalf("Expected result set, got error %q", payload[9:])
+	}
+
+	columns := int((&reader{buf: payload}).lenEncInt())
+
+	for i := 0; i < columns+1; i++ {
+		if _, err := c.readPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows := [][]string{}
+	for {
+		payload, err := c.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payload[0] == 0xfe && len(payload) < 9 {
+			return rows
+		}
+
+		r := &reader{buf: payload}
+
+		row := []string{}
+		for i := 0; i < columns; i++ {
+			row = append(row, r.lenEncString())
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+func TestMySQLAccessDenied(t *testing.T) {
+	events := harness.NewRecorder()
+
+	c, client, salt := connect(t, events)
+	defer client.Close()
+
+	login(t, c, "root", scramble(salt, "toor"), PluginNative)
+
+	payload, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload[0] != 0xff || string(payload[9:]) != "Access denied for user 'root'@'pipe' (using password: YES)" {
+		t.Errorf("Expected access denied, got %q", payload)
+	}
+
+	recorded, err := events.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := recorded[0]
+	if e.Get("type") != "authentication" || e.Get("mysql.username") != "root" || e.Get("mysql.client-name") != "libmysql" {
+		t.Errorf("Expected authentication event of root, got %s %s", e.Get("type"), e.Get("mysql.username"))
+	}
+
+	if accepted(e) {
+		t.Errorf("Expected credentials to be rejected")
+	}
+
+	if e.Get("mysql.hash") != "$mysqlna$"+e.Get("mysql.salt")+"*"+e.Get("mysql.response") {
+		t.Errorf("Expected hash of salt and response, got %s", e.Get("mysql.hash"))
+	}
+}
+
+func TestMySQLQuery(t *testing.T) {
+	events := harness.NewRecorder()
+
+	c, client, salt := connect(t, events)
+	defer client.Close()
+
+	login(t, c, "root", scramble(salt, "root"), PluginNative)
+
+	if payload, err := c.readPacket(); err != nil {
+		t.Fatal(err)
+	} else if payload[0] != 0x00 {
+		t.Fatalf("Expected ok, got %q", payload)
+	}
+
+	recorded, err := events.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !accepted(recorded[0]) {
+		t.Errorf("Expected credentials to be accepted")
+	}
+
+	command(t, c, comQuery, "select @@version_comment limit 1")
+
+	if rows := resultSet(t, c); len(rows) != 1 || rows[0][0] != "MySQL Community Server (GPL)" {
+		t.Errorf("Expected version comment, got %q", rows)
+	}
+
+	if recorded, err = events.Wait(2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := recorded[1]; e.Get("type") != "query" || e.Get("mysql.query") != "select @@version_comment limit 1" {
+		t.Errorf("Expected query event, got %s %s", e.Get("type"), e.Get("mysql.query"))
+	}
+
+	command(t, c, comQuery, "SHOW DATABASES;")
+
+	if rows := resultSet(t, c); len(rows) != 5 || rows[4][0] != "wordpress" {
+		t.Errorf("Expected databases, got %q", rows)
+	}
+
+	command(t, c, comQuery, "SELECT version() AS v, 1")
+
+	if rows := resultSet(t, c); len(rows) != 1 || rows[0][0] != "5.7.33-log" || rows[0][1] != "1" {
+		t.Errorf("Expected version, got %q", rows)
+	}
+}
+
+func TestMySQLConfiguredQuery(t *testing.T) {
+	events := harness.NewRecorder()
+
+	c, client, salt := connect(t, events, func(s services.Servicer) error {
+		s.(*mysqlService).Queries = []Query{
+			{Match: `^select \* from wp_users`, Columns: []string{"user_login", "user_pass"}, Rows: [][]string{{"admin", "$P$B1"}}},
+		}
+		return nil
+	})
+	defer client.Close()
+
+	login(t, c, "root", scramble(salt, "root"), PluginNative)
+
+	if _, err := c.readPacket(); err != nil {
+		t.Fatal(err)
+	}
+
+	command(t, c, comQuery, "SELECT * FROM wp_users")
+
+	if rows := resultSet(t, c); len(rows) != 1 || rows[0][0] != "admin" || rows[0][1] != "$P$B1" {
+		t.Errorf("Expected configured rows, got %q", rows)
+	}
+}
+
+func TestMySQLClearPassword(t *testing.T) {
+	events := harness.NewRecorder()
+
+	c, client, _ := connect(t, events, func(s services.Servicer) error {
+		s.(*mysqlService).AuthPlugin = PluginClear
+		return nil
+	})
+	defer client.Close()
+
+	login(t, c, "admin", []byte{1, 2, 3}, PluginNative)
+
+	payload, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload[0] != 0xfe || string(payload[1:len(payload)-1]) != PluginClear {
+		t.Fatalf("Expected switch to clear password, got %q", payload)
+	}
+
+	if err := c.writePacket([]byte("admin\x00")); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload, err := c.readPacket(); err != nil {
+		t.Fatal(err)
+	} else if payload[0] != 0x00 {
+		t.Fatalf("Expected ok, got %q", payload)
+	}
+
+	recorded, err := events.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := recorded[0]
+	if e.Get("mysql.password") != "admin" || e.Get("mysql.auth-plugin") != PluginClear || !accepted(e) {
+		t.Errorf("Expected accepted clear password, got %q", e.Get("mysql.password"))
+	}
+}
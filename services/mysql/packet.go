@@ -0,0 +1,386 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrPacketTooLarge = errors.New("Packet too large")
+
+// maxPacketSize limits the size of the packets that are read, the packets
+// of the handshake and the queries of scanners are small.
+const maxPacketSize = 1 << 20
+
+// Capabilities of the protocol.
+const (
+	clientLongPassword     = 0x00000001
+	clientFoundRows        = 0x00000002
+	clientLongFlag         = 0x00000004
+	clientConnectWithDB    = 0x00000008
+	clientNoSchema         = 0x00000010
+	clientODBC             = 0x00000040
+	clientLocalFiles       = 0x00000080
+	clientIgnoreSpace      = 0x00000100
+	clientProtocol41       = 0x00000200
+	clientInteractive      = 0x00000400
+	clientSSL              = 0x00000800
+	clientTransactions     = 0x00002000
+	clientSecureConnection = 0x00008000
+	clientMultiStatements  = 0x00010000
+	clientMultiResults     = 0x00020000
+	clientPSMultiResults   = 0x00040000
+	clientPluginAuth       = 0x00080000
+	clientConnectAttrs     = 0x00100000
+	clientPluginAuthLenenc = 0x00200000
+)
+
+// serverCapabilities are the capabilities advertised by the server, ssl
+// isn't offered so the handshake is readable.
+const serverCapabilities = clientLongPassword | clientFoundRows | clientLongFlag |
+	clientConnectWithDB | clientNoSchema | clientODBC | clientLocalFiles |
+	clientIgnoreSpace | clientProtocol41 | clientInteractive | clientTransactions |
+	clientSecureConnection | clientMultiStatements | clientMultiResults |
+	clientPSMultiResults | clientPluginAuth | clientConnectAttrs | clientPluginAuthLenenc
+
+const (
+	serverStatusAutocommit = 0x0002
+
+	charsetUTF8 = 0x21
+
+	typeVarString = 0xfd
+)
+
+// Commands of the command phase.
+const (
+	comQuit       = 0x01
+	comInitDB     = 0x02
+	comQuery      = 0x03
+	comFieldList  = 0x04
+	comStatistics = 0x09
+	comPing       = 0x0e
+)
+
+// packetConn reads and writes the packets of the protocol, the sequence id
+// of the replies follows the sequence id of the last packet read.
+type packetConn struct {
+	br *bufio.Reader
+	w  io.Writer
+
+	seq byte
+}
+
+func newPacketConn(rw io.ReadWriter) *packetConn {
+	return &packetConn{
+		br: bufio.NewReader(rw),
+		w:  rw,
+	}
+}
+
+func (c *packetConn) readPacket() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.br, header); err != nil {
+		return nil, err
+	}
+
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if size > maxPacketSize {
+		return nil, ErrPacketTooLarge
+	}
+
+	c.seq = header[3] + 1
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+func (c *packetConn) writePacket(payload []byte) error {
+	size := len(payload)
+
+	packet := append([]byte{byte(size), byte(size >> 8), byte(size >> 16), c.seq}, payload...)
+	c.seq++
+
+	_, err := c.w.Write(packet)
+	return err
+}
+
+func (c *packetConn) writeOK() error {
+	buf := []byte{0x00, 0x00, 0x00}
+	buf = append(buf, serverStatusAutocommit, 0x00, 0x00, 0x00)
+	return c.writePacket(buf)
+}
+
+func (c *packetConn) writeEOF() error {
+	return c.writePacket([]byte{0xfe, 0x00, 0x00, serverStatusAutocommit, 0x00})
+}
+
+func (c *packetConn) writeError(code uint16, state, message string) error {
+	buf := []byte{0xff, byte(code), byte(code >> 8), '#'}
+	buf = append(buf, state...)
+	buf = append(buf, message...)
+	return c.writePacket(buf)
+}
+
+// writeResultSet writes a text result set, the values are all strings.
+func (c *packetConn) writeResultSet(columns []string, rows [][]string) error {
+	if err := c.writePacket(appendLenEncInt(nil, uint64(len(columns)))); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		buf := appendLenEncString(nil, "def")
+		buf = appendLenEncString(buf, "")
+		buf = appendLenEncString(buf, "")
+		buf = appendLenEncString(buf, "")
+		buf = appendLenEncString(buf, column)
+		buf = appendLenEncString(buf, column)
+		buf = append(buf, 0x0c, charsetUTF8, 0x00)
+		buf = append(buf, 0x00, 0x01, 0x00, 0x00)
+		buf = append(buf, typeVarString, 0x00, 0x00, 0x1f, 0x00, 0x00)
+
+		if err := c.writePacket(buf); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeEOF(); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		buf := []byte{}
+		for i := range columns {
+			if i < len(row) {
+				buf = appendLenEncString(buf, row[i])
+			} else {
+				// null
+				buf = append(buf, 0xfb)
+			}
+		}
+
+		if err := c.writePacket(buf); err != nil {
+			return err
+		}
+	}
+
+	return c.writeEOF()
+}
+
+// handshake returns the initial handshake (protocol version 10) packet.
+func handshake(version string, id uint32, salt []byte, plugin string) []byte {
+	buf := []byte{10}
+	buf = append(buf, version...)
+	buf = append(buf, 0)
+
+	buf = append(buf, byte(id), byte(id>>8), byte(id>>16), byte(id>>24))
+	buf = append(buf, salt[:8]...)
+	buf = append(buf, 0)
+
+	caps := uint32(serverCapabilities)
+
+	buf = append(buf, byte(caps), byte(caps>>8))
+	buf = append(buf, charsetUTF8)
+	buf = append(buf, serverStatusAutocommit, 0x00)
+	buf = append(buf, byte(caps>>16), byte(caps>>24))
+	buf = append(buf, byte(len(salt)+1))
+	buf = append(buf, make([]byte, 10)...)
+	buf = append(buf, salt[8:]...)
+	buf = append(buf, 0)
+	buf = append(buf, plugin...)
+	buf = append(buf, 0)
+
+	return buf
+}
+
+// handshakeResponse is the response of the client to the handshake.
+type handshakeResponse struct {
+	capabilities uint32
+
+	username string
+	response []byte
+	database string
+	plugin   string
+
+	attributes map[string]string
+}
+
+var errMalformed = errors.New("Malformed packet")
+
+// reader reads the fields of a packet, errors are sticky.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n < 0 || n > len(r.buf) {
+		r.err = errMalformed
+		return nil
+	}
+
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) nullString() string {
+	if r.err != nil {
+		return ""
+	}
+
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		// the last string of a packet isn't always terminated
+		s := string(r.buf)
+		r.buf = nil
+		return s
+	}
+
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
+
+func (r *reader) lenEncInt() uint64 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+
+	switch b[0] {
+	case 0xfc:
+		if v := r.next(2); v != nil {
+			return uint64(binary.LittleEndian.Uint16(v))
+		}
+	case 0xfd:
+		if v := r.next(3); v != nil {
+			return uint64(v[0]) | uint64(v[1])<<8 | uint64(v[2])<<16
+		}
+	case 0xfe:
+		if v := r.next(8); v != nil {
+			return binary.LittleEndian.Uint64(v)
+		}
+	default:
+		return uint64(b[0])
+	}
+
+	return 0
+}
+
+func (r *reader) lenEncString() string {
+	n := r.lenEncInt()
+	if n > uint64(len(r.buf)) {
+		r.err = errMalformed
+		return ""
+	}
+
+	return string(r.next(int(n)))
+}
+
+// parseHandshakeResponse parses the HandshakeResponse41, or the older
+// HandshakeResponse320 of clients without protocol 41 support.
+func parseHandshakeResponse(payload []byte) (*handshakeResponse, error) {
+	r := &reader{buf: payload}
+
+	hr := &handshakeResponse{
+		attributes: map[string]string{},
+	}
+
+	if len(payload) < 2 {
+		return nil, errMalformed
+	}
+
+	if binary.LittleEndian.Uint16(payload)&clientProtocol41 == 0 {
+		hr.capabilities = uint32(binary.LittleEndian.Uint16(r.next(2)))
+		r.next(3)
+
+		hr.username = r.nullString()
+		hr.response = []byte(r.nullString())
+		return hr, r.err
+	}
+
+	if b := r.next(4); b != nil {
+		hr.capabilities = binary.LittleEndian.Uint32(b)
+	}
+
+	// max packet size, character set and filler
+	r.next(4 + 1 + 23)
+
+	hr.username = r.nullString()
+
+	switch {
+	case hr.capabilities&clientPluginAuthLenenc != 0:
+		hr.response = []byte(r.lenEncString())
+	case hr.capabilities&clientSecureConnection != 0:
+		if b := r.next(1); b != nil {
+			hr.response = r.next(int(b[0]))
+		}
+	default:
+		hr.response = []byte(r.nullString())
+	}
+
+	if hr.capabilities&clientConnectWithDB != 0 && len(r.buf) > 0 {
+		hr.database = r.nullString()
+	}
+
+	if hr.capabilities&clientPluginAuth != 0 && len(r.buf) > 0 {
+		hr.plugin = r.nullString()
+	}
+
+	if hr.capabilities&clientConnectAttrs != 0 && len(r.buf) > 0 {
+		attrs := &reader{buf: r.next(int(r.lenEncInt()))}
+
+		for attrs.err == nil && len(attrs.buf) > 0 {
+			key := attrs.lenEncString()
+			value := attrs.lenEncString()
+
+			if attrs.err == nil {
+				hr.attributes[key] = value
+			}
+		}
+	}
+
+	return hr, r.err
+}
+
+func appendLenEncInt(buf []byte, v uint64) []byte {
+	switch {
+	case v < 251:
+		return append(buf, byte(v))
+	case v < 1<<16:
+		return append(buf, 0xfc, byte(v), byte(v>>8))
+	case v < 1<<24:
+		return append(buf, 0xfd, byte(v), byte(v>>8), byte(v>>16))
+	}
+
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return append(append(buf, 0xfe), b...)
+}
+
+func appendLenEncString(buf []byte, s string) []byte {
+	return append(appendLenEncInt(buf, uint64(len(s))), s...)
+}
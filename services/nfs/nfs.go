@@ -0,0 +1,478 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/nfs")
+
+var (
+	_ = services.Register("nfs", NFS)
+)
+
+const (
+	progPortmap = 100000
+	progNFS     = 100003
+	progMount   = 100005
+)
+
+const (
+	mountStatusOK     = 0
+	mountStatusNoEnt  = 2
+	nfsStatusOK       = 0
+	nfsStatusAccess   = 13
+	nfsStatusStale    = 70
+	fileTypeDirectory = 2
+)
+
+// NFS returns the nfs service, that emulates the portmapper, mountd and
+// nfs v3 programs on a single port. The service can be configured on the
+// portmapper (111), mountd and nfs (2049) ports.
+func NFS(options ...services.ServicerFunc) services.Servicer {
+	s := &nfsService{
+		Config: Config{
+			Exports: []Export{
+				{Path: "/export/home", Clients: []string{"*"}},
+				{Path: "/srv/backup", Clients: []string{"*"}},
+			},
+			MountPort: 20048,
+			NFSPort:   2049,
+		},
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+// Export is a fake share advertised by mountd.
+type Export struct {
+	Path    string   `toml:"path"`
+	Clients []string `toml:"clients"`
+}
+
+type Config struct {
+	Exports []Export `toml:"export"`
+
+	// MountPort and NFSPort are the ports returned by the portmapper
+	MountPort int `toml:"mount-port"`
+	NFSPort   int `toml:"nfs-port"`
+}
+
+type nfsService struct {
+	Config
+
+	ch pushers.Channel
+}
+
+func (s *nfsService) SetChannel(c pushers.Channel) {
+	s.ch = c
+}
+
+// handle returns the file handle of the export.
+func handle(path string) []byte {
+	h := sha256.Sum256([]byte("honeytrap-nfs:" + path))
+	return h[:]
+}
+
+// exportOf returns the export of the file handle.
+func (s *nfsService) exportOf(fh []byte) (string, bool) {
+	for _, e := range s.Exports {
+		if bytes.Equal(handle(e.Path), fh) {
+			return e.Path, true
+		}
+	}
+
+	return "", false
+}
+
+func (s *nfsService) exported(path string) bool {
+	for _, e := range s.Exports {
+		if e.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func programName(prog uint32) string {
+	switch prog {
+	case progPortmap:
+		return "portmap"
+	case progNFS:
+		return "nfs"
+	case progMount:
+		return "mount"
+	}
+
+	return fmt.Sprintf("%d", prog)
+}
+
+var procedureNames = map[uint32]map[uint32]string{
+	progPortmap: {0: "null", 1: "set", 2: "unset", 3: "getport", 4: "dump", 5: "callit"},
+	progMount:   {0: "null", 1: "mnt", 2: "dump", 3: "umnt", 4: "umntall", 5: "export"},
+	progNFS: {
+		0: "null", 1: "getattr", 2: "setattr", 3: "lookup", 4: "access", 5: "readlink",
+		6: "read", 7: "write", 8: "create", 9: "mkdir", 10: "symlink", 11: "mknod",
+		12: "remove", 13: "rmdir", 14: "rename", 15: "link", 16: "readdir",
+		17: "readdirplus", 18: "fsstat", 19: "fsinfo", 20: "pathconf", 21: "commit",
+	},
+}
+
+func procedureName(prog, proc uint32) string {
+	if name, ok := procedureNames[prog][proc]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("%d", proc)
+}
+
+// result is the outcome of a call, the reply and the event fields.
+type result struct {
+	stat    uint32
+	results []byte
+
+	eventType string
+	options   []event.Option
+}
+
+func (s *nfsService) portmap(c *rpcCall) result {
+	w := &xdrWriter{}
+
+	switch c.Proc {
+	case 0:
+	case 3:
+		prog, vers, prot := c.args.Uint32(), c.args.Uint32(), c.args.Uint32()
+
+		port := uint32(0)
+
+		switch prog {
+		case progPortmap:
+			port = 111
+		case progMount:
+			port = uint32(s.MountPort)
+		case progNFS:
+			port = uint32(s.NFSPort)
+		}
+
+		w.Uint32(port)
+
+		return result{
+			stat:      acceptSuccess,
+			results:   w.Bytes(),
+			eventType: "portmap-getport",
+			options: []event.Option{
+				event.Custom("nfs.getport-program", programName(prog)),
+				event.Custom("nfs.getport-version", vers),
+				event.Custom("nfs.getport-protocol", prot),
+			},
+		}
+	case 4:
+		for _, m := range []struct {
+			prog, vers, port uint32
+		}{
+			{progPortmap, 2, 111},
+			{progMount, 3, uint32(s.MountPort)},
+			{progNFS, 3, uint32(s.NFSPort)},
+		} {
+			for _, prot := range []uint32{6, 17} {
+				w.Bool(true)
+				w.Uint32(m.prog)
+				w.Uint32(m.vers)
+				w.Uint32(prot)
+				w.Uint32(m.port)
+			}
+		}
+
+		w.Bool(false)
+
+		return result{stat: acceptSuccess, results: w.Bytes(), eventType: "portmap-dump"}
+	default:
+		return result{stat: acceptProcUnavail}
+	}
+
+	return result{stat: acceptSuccess}
+}
+
+func (s *nfsService) mount(c *rpcCall) result {
+	if c.Version < 1 || c.Version > 3 {
+		return result{stat: acceptProgMismatch, results: mismatch(1, 3)}
+	}
+
+	w := &xdrWriter{}
+
+	switch c.Proc {
+	case 0:
+	case 1:
+		path := c.args.Str()
+		granted := s.exported(path)
+
+		if !granted {
+			w.Uint32(mountStatusNoEnt)
+		} else if c.Version == 3 {
+			w.Uint32(mountStatusOK)
+			w.Opaque(handle(path))
+
+			// auth flavors
+			w.Uint32(1)
+			w.Uint32(authUnix)
+		} else {
+			// fixed size file handle
+			w.Uint32(mountStatusOK)
+			w.Write(handle(path))
+		}
+
+		return result{
+			stat:      acceptSuccess,
+			results:   w.Bytes(),
+			eventType: "mount",
+			options: []event.Option{
+				event.Custom("nfs.path", path),
+				event.Custom("nfs.granted", granted),
+			},
+		}
+	case 2:
+		w.Bool(false)
+		return result{stat: acceptSuccess, results: w.Bytes()}
+	case 3:
+		return result{
+			stat:      acceptSuccess,
+			eventType: "unmount",
+			options: []event.Option{
+				event.Custom("nfs.path", c.args.Str()),
+			},
+		}
+	case 4:
+	case 5:
+		for _, e := range s.Exports {
+			w.Bool(true)
+			w.Str(e.Path)
+
+			for _, client := range e.Clients {
+				w.Bool(true)
+				w.Str(client)
+			}
+
+			w.Bool(false)
+		}
+
+		w.Bool(false)
+
+		return result{stat: acceptSuccess, results: w.Bytes(), eventType: "export-list"}
+	default:
+		return result{stat: acceptProcUnavail}
+	}
+
+	return result{stat: acceptSuccess}
+}
+
+// directoryAttributes writes the fattr3 of the root directory of an export.
+func directoryAttributes(w *xdrWriter) {
+	now := uint32(time.Now().Unix())
+
+	w.Uint32(fileTypeDirectory)
+	w.Uint32(0755)
+	w.Uint32(2)    // nlink
+	w.Uint32(0)    // uid
+	w.Uint32(0)    // gid
+	w.Uint64(4096) // size
+	w.Uint64(4096) // used
+	w.Uint32(0)    // rdev
+	w.Uint32(0)
+	w.Uint64(1) // fsid
+	w.Uint64(2) // fileid
+
+	// atime, mtime and ctime
+	for i := 0; i < 3; i++ {
+		w.Uint32(now)
+		w.Uint32(0)
+	}
+}
+
+func (s *nfsService) nfs(c *rpcCall) result {
+	if c.Version != 3 {
+		return result{stat: acceptProgMismatch, results: mismatch(3, 3)}
+	}
+
+	if c.Proc == 0 {
+		return result{stat: acceptSuccess}
+	}
+
+	if _, ok := procedureNames[progNFS][c.Proc]; !ok {
+		return result{stat: acceptProcUnavail}
+	}
+
+	// all procedures start with the file handle
+	fh := c.args.Opaque()
+
+	path, ok := s.exportOf(fh)
+
+	w := &xdrWriter{}
+
+	switch {
+	case !ok:
+		w.Uint32(nfsStatusStale)
+
+		if c.Proc != 1 {
+			// post op attributes
+			w.Bool(false)
+		}
+	case c.Proc == 1:
+		w.Uint32(nfsStatusOK)
+		directoryAttributes(w)
+	case c.Proc == 16 || c.Proc == 17:
+		// an empty directory
+		w.Uint32(nfsStatusOK)
+		w.Bool(false)
+		w.Uint64(0) // cookie verifier
+		w.Bool(false)
+		w.Bool(true) // eof
+	default:
+		w.Uint32(nfsStatusAccess)
+		w.Bool(false)
+	}
+
+	eventType := "nfs-call"
+	if c.Proc == 16 || c.Proc == 17 {
+		eventType = "readdir"
+	}
+
+	return result{
+		stat:      acceptSuccess,
+		results:   w.Bytes(),
+		eventType: eventType,
+		options: []event.Option{
+			event.Custom("nfs.path", path),
+			event.Custom("nfs.file-handle", fmt.Sprintf("%x", fh)),
+		},
+	}
+}
+
+// call handles a single rpc call, and returns the reply.
+func (s *nfsService) call(data []byte, options []event.Option) ([]byte, error) {
+	c, err := parseCall(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var r result
+
+	switch c.Program {
+	case progPortmap:
+		r = s.portmap(c)
+	case progMount:
+		r = s.mount(c)
+	case progNFS:
+		r = s.nfs(c)
+	default:
+		r = result{stat: acceptProgUnavail}
+	}
+
+	if c.args.err != nil {
+		r = result{stat: acceptGarbageArgs}
+	}
+
+	if r.eventType == "" {
+		r.eventType = "rpc-call"
+	}
+
+	options = append(options,
+		event.Type(r.eventType),
+		event.Custom("nfs.program", programName(c.Program)),
+		event.Custom("nfs.version", c.Version),
+		event.Custom("nfs.procedure", procedureName(c.Program, c.Proc)),
+	)
+
+	if c.CredFlavor == authUnix {
+		options = append(options,
+			event.Custom("nfs.auth-machine", c.MachineName),
+			event.Custom("nfs.auth-uid", c.UID),
+			event.Custom("nfs.auth-gid", c.GID),
+		)
+	}
+
+	s.ch.Send(event.New(append(options, r.options...)...))
+
+	return reply(c.XID, r.stat, r.results), nil
+}
+
+func (s *nfsService) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("nfs"),
+		connOptions,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+	}
+
+	if conn.RemoteAddr().Network() == "udp" {
+		// a single call per datagram, without record marking
+		buf := make([]byte, 65536)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.call(buf[:n], options)
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.Write(resp)
+		return err
+	}
+
+	for {
+		data, err := readRecord(conn)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		resp, err := s.call(data, options)
+		if err != nil {
+			return err
+		}
+
+		if err := writeRecord(conn, resp); err != nil {
+			return err
+		}
+	}
+}
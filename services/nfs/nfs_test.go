@@ -0,0 +1,190 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+type eventChannel chan event.Event
+
+func (c eventChannel) Send(e event.Event) {
+	c <- e
+}
+
+// callMessage returns a rpc call with auth unix credentials.
+func callMessage(xid, prog, vers, proc uint32, args []byte) []byte {
+	cred := &xdrWriter{}
+	cred.Uint32(0)
+	cred.Str("scanner")
+	cred.Uint32(1000)
+	cred.Uint32(1000)
+	cred.Uint32(0)
+
+	w := &xdrWriter{}
+	w.Uint32(xid)
+	w.Uint32(msgCall)
+	w.Uint32(2)
+	w.Uint32(prog)
+	w.Uint32(vers)
+	w.Uint32(proc)
+	w.Uint32(authUnix)
+	w.Opaque(cred.Bytes())
+	w.Uint32(authNone)
+	w.Opaque(nil)
+	w.Write(args)
+	return w.Bytes()
+}
+
+// readReply returns the results of an accepted reply.
+func readReply(t *testing.T, conn net.Conn, xid uint32) *xdrReader {
+	data, err := readRecord(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &xdrReader{data: data}
+	if v := r.Uint32(); v != xid {
+		t.Fatalf("Expected xid %d, got %d", xid, v)
+	}
+
+	if r.Uint32() != msgReply || r.Uint32() != replyAccepted {
+		t.Fatalf("Expected accepted reply")
+	}
+
+	r.Uint32()
+	r.Opaque()
+
+	if stat := r.Uint32(); stat != acceptSuccess {
+		t.Fatalf("Expected success, got %d", stat)
+	}
+
+	return r
+}
+
+func TestNFS(t *testing.T) {
+	events := make(eventChannel, 10)
+
+	s := NFS()
+	s.SetChannel(pushers.Channel(events))
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	// portmapper getport of mountd
+	args := &xdrWriter{}
+	args.Uint32(progMount)
+	args.Uint32(3)
+	args.Uint32(6)
+	args.Uint32(0)
+
+	if err := writeRecord(client, callMessage(1, progPortmap, 2, 3, args.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if port := readReply(t, client, 1).Uint32(); port != 20048 {
+		t.Errorf("Expected mountd port 20048, got %d", port)
+	}
+
+	e := <-events
+	if e.Get("type") != "portmap-getport" || e.Get("nfs.getport-program") != "mount" {
+		t.Errorf("Expected getport event, got %s", e.Get("type"))
+	}
+
+	// export list
+	if err := writeRecord(client, callMessage(2, progMount, 3, 5, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := readReply(t, client, 2)
+	if !(r.Uint32() == 1 && r.Str() == "/export/home") {
+		t.Errorf("Expected export /export/home")
+	}
+
+	if e := <-events; e.Get("type") != "export-list" {
+		t.Errorf("Expected export-list event, got %s", e.Get("type"))
+	}
+
+	// mount
+	args = &xdrWriter{}
+	args.Str("/srv/backup")
+
+	if err := writeRecord(client, callMessage(3, progMount, 3, 1, args.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	r = readReply(t, client, 3)
+	if status := r.Uint32(); status != mountStatusOK {
+		t.Fatalf("Expected mount to succeed, got %d", status)
+	}
+
+	fh := r.Opaque()
+
+	e = <-events
+	if e.Get("type") != "mount" || e.Get("nfs.path") != "/srv/backup" || e.Get("nfs.auth-machine") != "scanner" {
+		t.Errorf("Expected mount event of /srv/backup, got %s %s", e.Get("type"), e.Get("nfs.path"))
+	}
+
+	// readdir of the export
+	args = &xdrWriter{}
+	args.Opaque(fh)
+	args.Uint64(0)
+	args.Uint64(0)
+	args.Uint32(4096)
+
+	if err := writeRecord(client, callMessage(4, progNFS, 3, 16, args.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := readReply(t, client, 4).Uint32(); status != nfsStatusOK {
+		t.Errorf("Expected readdir to succeed, got %d", status)
+	}
+
+	e = <-events
+	if e.Get("type") != "readdir" || e.Get("nfs.path") != "/srv/backup" {
+		t.Errorf("Expected readdir event of /srv/backup, got %s %s", e.Get("type"), e.Get("nfs.path"))
+	}
+}
+
+func TestXDR(t *testing.T) {
+	w := &xdrWriter{}
+	w.Str("abcde")
+	w.Uint64(1 << 40)
+
+	if w.Len() != 4+8+8 {
+		t.Fatalf("Expected padded length 20, got %d", w.Len())
+	}
+
+	r := &xdrReader{data: w.Bytes()}
+	if v := r.Str(); v != "abcde" {
+		t.Errorf("Expected abcde, got %s", v)
+	}
+
+	if v := r.Uint64(); v != 1<<40 {
+		t.Errorf("Expected %d, got %d", uint64(1<<40), v)
+	}
+
+	r = &xdrReader{data: bytes.Repeat([]byte{0xff}, 4)}
+	if r.Opaque(); r.err == nil {
+		t.Errorf("Expected error for invalid length")
+	}
+}
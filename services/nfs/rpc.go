@@ -0,0 +1,168 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// https://tools.ietf.org/html/rfc5531
+const (
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+
+	acceptSuccess      = 0
+	acceptProgUnavail  = 1
+	acceptProgMismatch = 2
+	acceptProcUnavail  = 3
+	acceptGarbageArgs  = 4
+
+	authNone = 0
+	authUnix = 1
+)
+
+var (
+	ErrNotCall        = errors.New("Not a rpc call")
+	ErrRecordTooLarge = errors.New("Rpc record too large")
+)
+
+// maxRecordSize limits the size of the records that will be read
+const maxRecordSize = 1024 * 1024
+
+type rpcCall struct {
+	XID     uint32
+	Program uint32
+	Version uint32
+	Proc    uint32
+
+	CredFlavor  uint32
+	MachineName string
+	UID         uint32
+	GID         uint32
+
+	// args contains the procedure arguments
+	args *xdrReader
+}
+
+func parseCall(data []byte) (*rpcCall, error) {
+	r := &xdrReader{data: data}
+
+	c := &rpcCall{
+		XID: r.Uint32(),
+	}
+
+	if r.Uint32() != msgCall {
+		return nil, ErrNotCall
+	}
+
+	// rpc version
+	r.Uint32()
+
+	c.Program = r.Uint32()
+	c.Version = r.Uint32()
+	c.Proc = r.Uint32()
+
+	c.CredFlavor = r.Uint32()
+	cred := r.Opaque()
+
+	// verifier
+	r.Uint32()
+	r.Opaque()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if c.CredFlavor == authUnix {
+		cr := &xdrReader{data: cred}
+
+		// stamp
+		cr.Uint32()
+
+		c.MachineName = cr.Str()
+		c.UID = cr.Uint32()
+		c.GID = cr.Uint32()
+	}
+
+	c.args = r
+	return c, nil
+}
+
+// reply returns the accepted reply to the call, with the accept status and
+// the procedure results.
+func reply(xid uint32, stat uint32, results []byte) []byte {
+	w := &xdrWriter{}
+	w.Uint32(xid)
+	w.Uint32(msgReply)
+	w.Uint32(replyAccepted)
+
+	// verifier
+	w.Uint32(authNone)
+	w.Uint32(0)
+
+	w.Uint32(stat)
+	w.Write(results)
+
+	return w.Bytes()
+}
+
+// mismatch returns the supported version range, for prog mismatch replies.
+func mismatch(low, high uint32) []byte {
+	w := &xdrWriter{}
+	w.Uint32(low)
+	w.Uint32(high)
+	return w.Bytes()
+}
+
+// readRecord reads a record marked (RFC 5531, section 11) rpc message.
+func readRecord(r io.Reader) ([]byte, error) {
+	record := []byte{}
+
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+
+		h := binary.BigEndian.Uint32(header)
+		size := int(h & 0x7fffffff)
+
+		if len(record)+size > maxRecordSize {
+			return nil, ErrRecordTooLarge
+		}
+
+		fragment := make([]byte, size)
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return nil, err
+		}
+
+		record = append(record, fragment...)
+
+		if h&0x80000000 != 0 {
+			return record, nil
+		}
+	}
+}
+
+func writeRecord(w io.Writer, data []byte) error {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, 0x80000000|uint32(len(data)))
+
+	_, err := w.Write(append(header, data...))
+	return err
+}
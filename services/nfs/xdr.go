@@ -0,0 +1,111 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrShortMessage = errors.New("Short xdr message")
+
+// maxOpaque limits the size of variable length data
+const maxOpaque = 64 * 1024
+
+// xdrReader decodes xdr (RFC 4506) data, the first error is kept and
+// subsequent reads return zero values.
+type xdrReader struct {
+	data []byte
+	err  error
+}
+
+func (r *xdrReader) Uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+
+	if len(r.data) < 4 {
+		r.err = ErrShortMessage
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *xdrReader) Uint64() uint64 {
+	hi := r.Uint32()
+	lo := r.Uint32()
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+func (r *xdrReader) Opaque() []byte {
+	n := int(r.Uint32())
+	if r.err != nil {
+		return nil
+	}
+
+	// opaque data is padded to a multiple of four bytes
+	padded := (n + 3) &^ 3
+	if n > maxOpaque || len(r.data) < padded {
+		r.err = ErrShortMessage
+		return nil
+	}
+
+	v := r.data[:n]
+	r.data = r.data[padded:]
+	return v
+}
+
+func (r *xdrReader) Str() string {
+	return string(r.Opaque())
+}
+
+type xdrWriter struct {
+	bytes.Buffer
+}
+
+func (w *xdrWriter) Uint32(v uint32) {
+	b := [4]byte{}
+	binary.BigEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *xdrWriter) Uint64(v uint64) {
+	w.Uint32(uint32(v >> 32))
+	w.Uint32(uint32(v))
+}
+
+func (w *xdrWriter) Bool(v bool) {
+	if v {
+		w.Uint32(1)
+	} else {
+		w.Uint32(0)
+	}
+}
+
+func (w *xdrWriter) Opaque(v []byte) {
+	w.Uint32(uint32(len(v)))
+	w.Write(v)
+
+	if pad := (4 - len(v)%4) % 4; pad > 0 {
+		w.Write(make([]byte, pad))
+	}
+}
+
+func (w *xdrWriter) Str(v string) {
+	w.Opaque([]byte(v))
+}
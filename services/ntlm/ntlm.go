@@ -0,0 +1,378 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package ntlm implements the server side of the NTLMSSP exchange, far
+// enough to capture the negotiate, challenge and authenticate messages and
+// to format the responses as NetNTLMv1/v2 hashes.
+package ntlm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+var signature = []byte("NTLMSSP\x00")
+
+const (
+	MessageNegotiate    = 1
+	MessageChallenge    = 2
+	MessageAuthenticate = 3
+)
+
+const (
+	FlagUnicode                 = 0x00000001
+	FlagOEM                     = 0x00000002
+	FlagRequestTarget           = 0x00000004
+	FlagNTLM                    = 0x00000200
+	FlagAlwaysSign              = 0x00008000
+	FlagTargetTypeDomain        = 0x00010000
+	FlagExtendedSessionSecurity = 0x00080000
+	FlagTargetInfo              = 0x00800000
+	FlagVersion                 = 0x02000000
+	Flag128                     = 0x20000000
+	FlagKeyExchange             = 0x40000000
+	Flag56                      = 0x80000000
+)
+
+// challengeFlags are the flags of the challenge message, as sent by windows
+// servers.
+const challengeFlags uint32 = FlagUnicode | FlagRequestTarget | FlagNTLM | FlagAlwaysSign |
+	FlagTargetTypeDomain | FlagExtendedSessionSecurity | FlagTargetInfo | FlagVersion |
+	Flag128 | FlagKeyExchange | Flag56
+
+const (
+	avEOL             = 0
+	avNbComputerName  = 1
+	avNbDomainName    = 2
+	avDNSComputerName = 3
+	avDNSDomainName   = 4
+	avTimestamp       = 7
+)
+
+var (
+	ErrInvalidMessage = errors.New("Invalid NTLMSSP message")
+)
+
+// IsNTLMSSP returns true if the data starts with the NTLMSSP signature.
+func IsNTLMSSP(data []byte) bool {
+	return bytes.HasPrefix(data, signature)
+}
+
+// MessageType returns the type of the NTLMSSP message.
+func MessageType(data []byte) (uint32, error) {
+	if len(data) < 12 || !IsNTLMSSP(data) {
+		return 0, ErrInvalidMessage
+	}
+
+	return binary.LittleEndian.Uint32(data[8:12]), nil
+}
+
+// field returns the payload referenced by the len/maxlen/offset field at
+// position i.
+func field(data []byte, i int) ([]byte, error) {
+	if len(data) < i+8 {
+		return nil, ErrInvalidMessage
+	}
+
+	l := int(binary.LittleEndian.Uint16(data[i : i+2]))
+	offset := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+
+	if l == 0 {
+		return []byte{}, nil
+	}
+
+	if offset < 0 || offset+l > len(data) {
+		return nil, ErrInvalidMessage
+	}
+
+	return data[offset : offset+l], nil
+}
+
+func decodeString(data []byte, unicode bool) string {
+	if !unicode {
+		return string(data)
+	}
+
+	u := make([]uint16, len(data)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+
+	return string(utf16.Decode(u))
+}
+
+func encodeString(s string) []byte {
+	u := utf16.Encode([]rune(s))
+
+	data := make([]byte, len(u)*2)
+	for i, v := range u {
+		binary.LittleEndian.PutUint16(data[i*2:], v)
+	}
+
+	return data
+}
+
+// Negotiate contains the fields of the negotiate (type 1) message.
+type Negotiate struct {
+	Flags       uint32
+	Domain      string
+	Workstation string
+
+	// Version is the version of the operating system of the client, like
+	// 10.0.19041, if sent
+	Version string
+}
+
+// ParseNegotiate parses a negotiate (type 1) message.
+func ParseNegotiate(data []byte) (*Negotiate, error) {
+	if t, err := MessageType(data); err != nil {
+		return nil, err
+	} else if t != MessageNegotiate || len(data) < 16 {
+		return nil, ErrInvalidMessage
+	}
+
+	n := &Negotiate{
+		Flags: binary.LittleEndian.Uint32(data[12:16]),
+	}
+
+	// domain and workstation are optional, and omitted by most clients
+	if domain, err := field(data, 16); err == nil {
+		n.Domain = string(domain)
+	}
+
+	if workstation, err := field(data, 24); err == nil {
+		n.Workstation = string(workstation)
+	}
+
+	if n.Flags&FlagVersion != 0 && len(data) >= 40 {
+		n.Version = fmt.Sprintf("%d.%d.%d", data[32], data[33], binary.LittleEndian.Uint16(data[34:36]))
+	}
+
+	return n, nil
+}
+
+// Server holds the state of a single NTLMSSP exchange.
+type Server struct {
+	// Domain and Computer are the names announced in the challenge
+	Domain   string
+	Computer string
+
+	Challenge [8]byte
+
+	negotiate []byte
+	challenge []byte
+}
+
+// NewServer returns a Server with a random challenge.
+func NewServer(domain, computer string) *Server {
+	s := &Server{
+		Domain:   domain,
+		Computer: computer,
+	}
+
+	rand.Read(s.Challenge[:])
+	return s
+}
+
+func avPair(id uint16, value []byte) []byte {
+	b := make([]byte, 4, 4+len(value))
+	binary.LittleEndian.PutUint16(b[0:], id)
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(value)))
+	return append(b, value...)
+}
+
+// filetime returns the time as windows filetime.
+func filetime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(t.UnixNano()/100+116444736000000000))
+	return b
+}
+
+// ChallengeMessage returns the challenge (type 2) message in response to the
+// negotiate (type 1) message.
+func (s *Server) ChallengeMessage(negotiate []byte) ([]byte, error) {
+	if _, err := ParseNegotiate(negotiate); err != nil {
+		return nil, err
+	}
+
+	s.negotiate = negotiate
+
+	domain := strings.ToUpper(s.Domain)
+	computer := strings.ToUpper(s.Computer)
+	dnsDomain := strings.ToLower(s.Domain) + ".local"
+
+	targetName := encodeString(domain)
+
+	targetInfo := &bytes.Buffer{}
+	targetInfo.Write(avPair(avNbDomainName, encodeString(domain)))
+	targetInfo.Write(avPair(avNbComputerName, encodeString(computer)))
+	targetInfo.Write(avPair(avDNSDomainName, encodeString(dnsDomain)))
+	targetInfo.Write(avPair(avDNSComputerName, encodeString(strings.ToLower(s.Computer)+"."+dnsDomain)))
+	targetInfo.Write(avPair(avTimestamp, filetime(time.Now())))
+	targetInfo.Write(avPair(avEOL, nil))
+
+	const headerSize = 56
+
+	msg := make([]byte, headerSize)
+	copy(msg, signature)
+	binary.LittleEndian.PutUint32(msg[8:], MessageChallenge)
+
+	binary.LittleEndian.PutUint16(msg[12:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint16(msg[14:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint32(msg[16:], headerSize)
+
+	binary.LittleEndian.PutUint32(msg[20:], challengeFlags)
+	copy(msg[24:32], s.Challenge[:])
+
+	binary.LittleEndian.PutUint16(msg[40:], uint16(targetInfo.Len()))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(targetInfo.Len()))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(headerSize+len(targetName)))
+
+	// version: windows 10.0 build 17763, ntlm revision 15
+	copy(msg[48:56], []byte{10, 0, 0x63, 0x45, 0, 0, 0, 15})
+
+	msg = append(msg, targetName...)
+	msg = append(msg, targetInfo.Bytes()...)
+
+	s.challenge = msg
+	return msg, nil
+}
+
+// Authenticate contains the fields of the authenticate (type 3) message.
+type Authenticate struct {
+	Flags       uint32
+	Domain      string
+	User        string
+	Workstation string
+
+	LMResponse []byte
+	NTResponse []byte
+
+	// Challenge is the server challenge the responses were computed for
+	Challenge [8]byte
+}
+
+// Version returns the NetNTLM version of the response, 1 or 2, or 0 for
+// anonymous authentication.
+func (a *Authenticate) Version() int {
+	switch {
+	case len(a.NTResponse) == 0:
+		return 0
+	case len(a.NTResponse) == 24:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Hash returns the response formatted as NetNTLMv1 or NetNTLMv2 hash, as
+// accepted by password crackers.
+func (a *Authenticate) Hash() string {
+	challenge := hex.EncodeToString(a.Challenge[:])
+
+	switch a.Version() {
+	case 1:
+		return fmt.Sprintf("%s::%s:%s:%s:%s", a.User, a.Domain, hex.EncodeToString(a.LMResponse), hex.EncodeToString(a.NTResponse), challenge)
+	case 2:
+		if len(a.NTResponse) < 16 {
+			return ""
+		}
+
+		return fmt.Sprintf("%s::%s:%s:%s:%s", a.User, a.Domain, challenge, hex.EncodeToString(a.NTResponse[:16]), hex.EncodeToString(a.NTResponse[16:]))
+	}
+
+	return ""
+}
+
+// Options returns the event options of the authenticate message.
+func (a *Authenticate) Options() event.Option {
+	return func(e event.Event) {
+		e.Store("ntlm.domain", a.Domain)
+		e.Store("ntlm.username", a.User)
+		e.Store("ntlm.workstation", a.Workstation)
+		e.Store("ntlm.flags", fmt.Sprintf("%08x", a.Flags))
+		e.Store("ntlm.server-challenge", hex.EncodeToString(a.Challenge[:]))
+
+		switch a.Version() {
+		case 1:
+			e.Store("ntlm.netntlmv1", a.Hash())
+		case 2:
+			e.Store("ntlm.netntlmv2", a.Hash())
+		default:
+			e.Store("ntlm.anonymous", true)
+		}
+	}
+}
+
+// ParseAuthenticate parses the authenticate (type 3) message, in response to
+// the challenge of the server.
+func (s *Server) ParseAuthenticate(data []byte) (*Authenticate, error) {
+	if t, err := MessageType(data); err != nil {
+		return nil, err
+	} else if t != MessageAuthenticate || len(data) < 64 {
+		return nil, ErrInvalidMessage
+	}
+
+	a := &Authenticate{
+		Flags:     binary.LittleEndian.Uint32(data[60:64]),
+		Challenge: s.Challenge,
+	}
+
+	unicode := a.Flags&FlagUnicode != 0
+
+	var err error
+	if a.LMResponse, err = field(data, 12); err != nil {
+		return nil, err
+	}
+
+	if a.NTResponse, err = field(data, 20); err != nil {
+		return nil, err
+	}
+
+	// a NetNTLMv2 response starts with the 16 byte proof
+	if n := len(a.NTResponse); n > 0 && n < 16 {
+		return nil, ErrInvalidMessage
+	}
+
+	for _, f := range []struct {
+		offset int
+		v      *string
+	}{
+		{28, &a.Domain},
+		{36, &a.User},
+		{44, &a.Workstation},
+	} {
+		b, err := field(data, f.offset)
+		if err != nil {
+			return nil, err
+		}
+
+		*f.v = decodeString(b, unicode)
+	}
+
+	return a, nil
+}
+
+// Messages returns the negotiate and challenge messages of the exchange.
+func (s *Server) Messages() (negotiate []byte, challenge []byte) {
+	return s.negotiate, s.challenge
+}
@@ -0,0 +1,145 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ntlm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func negotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, signature)
+	binary.LittleEndian.PutUint32(msg[8:], MessageNegotiate)
+	binary.LittleEndian.PutUint32(msg[12:], FlagUnicode|FlagNTLM|FlagRequestTarget)
+	return msg
+}
+
+// authenticateMessage builds an authenticate message with the fields in the
+// payload, in the order lm, nt, domain, user, workstation.
+func authenticateMessage(lm, nt []byte, domain, user, workstation string) []byte {
+	msg := make([]byte, 64)
+	copy(msg, signature)
+	binary.LittleEndian.PutUint32(msg[8:], MessageAuthenticate)
+	binary.LittleEndian.PutUint32(msg[60:], FlagUnicode)
+
+	for i, v := range [][]byte{lm, nt, encodeString(domain), encodeString(user), encodeString(workstation)} {
+		offset := 12 + i*8
+		binary.LittleEndian.PutUint16(msg[offset:], uint16(len(v)))
+		binary.LittleEndian.PutUint16(msg[offset+2:], uint16(len(v)))
+		binary.LittleEndian.PutUint32(msg[offset+4:], uint32(len(msg)))
+		msg = append(msg, v...)
+	}
+
+	return msg
+}
+
+func TestParseNegotiateVersion(t *testing.T) {
+	msg := negotiateMessage()
+	binary.LittleEndian.PutUint32(msg[12:], FlagUnicode|FlagNTLM|FlagVersion)
+	msg = append(msg, 10, 0, 0x61, 0x4a, 0, 0, 0, 15)
+
+	n, err := ParseNegotiate(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.Version != "10.0.19041" {
+		t.Errorf("Expected version 10.0.19041, got %q", n.Version)
+	}
+}
+
+func TestExchangeNetNTLMv2(t *testing.T) {
+	s := NewServer("corp", "web01")
+	copy(s.Challenge[:], []byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	challenge, err := s.ChallengeMessage(negotiateMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tp, err := MessageType(challenge); err != nil || tp != MessageChallenge {
+		t.Fatalf("Expected challenge message, got %d (%v)", tp, err)
+	}
+
+	if !bytes.Equal(challenge[24:32], s.Challenge[:]) {
+		t.Errorf("Expected server challenge in challenge message")
+	}
+
+	nt := append(bytes.Repeat([]byte{0xaa}, 16), bytes.Repeat([]byte{0xbb}, 32)...)
+
+	a, err := s.ParseAuthenticate(authenticateMessage(make([]byte, 24), nt, "CORP", "alice", "WS01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.User != "alice" || a.Domain != "CORP" || a.Workstation != "WS01" {
+		t.Errorf("Unexpected fields: %+v", a)
+	}
+
+	if a.Version() != 2 {
+		t.Errorf("Expected NetNTLMv2, got %d", a.Version())
+	}
+
+	expected := "alice::CORP:0102030405060708:" + strings.Repeat("aa", 16) + ":" + strings.Repeat("bb", 32)
+	if h := a.Hash(); h != expected {
+		t.Errorf("Expected %s, got %s", expected, h)
+	}
+}
+
+func TestNetNTLMv1(t *testing.T) {
+	s := NewServer("corp", "web01")
+
+	lm := bytes.Repeat([]byte{0x11}, 24)
+	nt := bytes.Repeat([]byte{0x22}, 24)
+
+	a, err := s.ParseAuthenticate(authenticateMessage(lm, nt, "CORP", "bob", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "bob::CORP:" + hex.EncodeToString(lm) + ":" + hex.EncodeToString(nt) + ":" + hex.EncodeToString(s.Challenge[:])
+	if h := a.Hash(); h != expected {
+		t.Errorf("Expected %s, got %s", expected, h)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	s := NewServer("corp", "web01")
+
+	if _, err := s.ChallengeMessage([]byte("NTLMSSP\x00")); err == nil {
+		t.Errorf("Expected error for truncated negotiate message")
+	}
+
+	msg := authenticateMessage(nil, nil, "CORP", "bob", "")
+	binary.LittleEndian.PutUint32(msg[36+4:], 0xffff)
+
+	if _, err := s.ParseAuthenticate(msg); err == nil {
+		t.Errorf("Expected error for out of bounds field")
+	}
+
+	msg = authenticateMessage(nil, bytes.Repeat([]byte{0x22}, 15), "CORP", "bob", "")
+
+	if _, err := s.ParseAuthenticate(msg); err != ErrInvalidMessage {
+		t.Errorf("Expected error for short NTLMv2 response, got %v", err)
+	}
+
+	a := &Authenticate{NTResponse: []byte{0x22}}
+	if h := a.Hash(); h != "" {
+		t.Errorf("Expected no hash of short NTLMv2 response, got %s", h)
+	}
+}
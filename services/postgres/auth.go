@@ -0,0 +1,120 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package postgres
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// md5Response returns the response of the md5 authentication:
+// "md5" + md5(md5(password + username) + salt).
+func md5Response(username, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + username))
+
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+const scramIterations = 4096
+
+// scram is the exchange of the SCRAM-SHA-256 authentication.
+type scram struct {
+	salt []byte
+
+	clientFirstBare string
+	serverFirst     string
+	clientFinal     string
+}
+
+// parseAttributes parses the comma separated attributes of a SCRAM
+// message, like r=nonce,p=proof.
+func parseAttributes(msg string) map[string]string {
+	attrs := map[string]string{}
+
+	for _, part := range strings.Split(msg, ",") {
+		if len(part) > 2 && part[1] == '=' {
+			attrs[part[:1]] = part[2:]
+		}
+	}
+
+	return attrs
+}
+
+// clientFirst handles the first message of the client, and returns the
+// first message of the server.
+func (s *scram) clientFirst(msg string, nonce string) string {
+	// strip the gs2 header, channel binding isn't offered
+	parts := strings.SplitN(msg, ",", 3)
+	if len(parts) == 3 {
+		s.clientFirstBare = parts[2]
+	}
+
+	s.serverFirst = "r=" + parseAttributes(s.clientFirstBare)["r"] + nonce +
+		",s=" + base64.StdEncoding.EncodeToString(s.salt) +
+		",i=4096"
+
+	return s.serverFirst
+}
+
+func (s *scram) authMessage() string {
+	withoutProof := s.clientFinal
+	if i := strings.LastIndex(withoutProof, ",p="); i >= 0 {
+		withoutProof = withoutProof[:i]
+	}
+
+	return s.clientFirstBare + "," + s.serverFirst + "," + withoutProof
+}
+
+func scramHMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// verify returns true if the proof of the client is the proof of the
+// password.
+func (s *scram) verify(password string) bool {
+	proof, err := base64.StdEncoding.DecodeString(parseAttributes(s.clientFinal)["p"])
+	if err != nil || len(proof) != sha256.Size {
+		return false
+	}
+
+	salted := pbkdf2.Key([]byte(password), s.salt, scramIterations, sha256.Size, sha256.New)
+
+	clientKey := scramHMAC(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	signature := scramHMAC(storedKey[:], s.authMessage())
+
+	for i := range signature {
+		signature[i] ^= clientKey[i]
+	}
+
+	return hmac.Equal(signature, proof)
+}
+
+// serverFinal returns the final message of the server, which proves the
+// server knows the password as well.
+func (s *scram) serverFinal(password string) string {
+	salted := pbkdf2.Key([]byte(password), s.salt, scramIterations, sha256.Size, sha256.New)
+
+	serverKey := scramHMAC(salted, "Server Key")
+	return "v=" + base64.StdEncoding.EncodeToString(scramHMAC(serverKey, s.authMessage()))
+}
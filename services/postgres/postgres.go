@@ -0,0 +1,624 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/authpolicy"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/postgres")
+
+var (
+	_ = services.Register("postgres", Postgres)
+)
+
+var errMalformed = errors.New("Malformed message")
+
+// Authentication methods.
+const (
+	MethodPassword = "password"
+	MethodMD5      = "md5"
+	MethodSCRAM    = "scram-sha-256"
+)
+
+/*
+Configuration example:
+
+[service.postgres]
+type="postgres"
+version="13.4"
+auth-method="md5"
+credentials=["postgres:postgres"]
+
+[[service.postgres.query]]
+match="^select \\* from users"
+columns=["id", "name"]
+rows=[["1", "admin"]]
+*/
+
+// Postgres returns the postgres service. The service handles the startup
+// message, records the authentication attempts and answers simple queries
+// with canned result sets.
+func Postgres(options ...services.ServicerFunc) services.Servicer {
+	s := &postgresService{
+		Config: Config{
+			Version:    "13.4",
+			AuthMethod: MethodMD5,
+			Databases:  []string{"postgres", "template0", "template1"},
+			Config: authpolicy.Config{
+				Credentials: []string{
+					"postgres:postgres",
+					"postgres:password",
+					"postgres:123456",
+					"admin:admin",
+				},
+			},
+		},
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	s.policy = authpolicy.MustNew(s.Config.Config)
+
+	for _, q := range s.Queries {
+		re, err := regexp.Compile("(?i)" + q.Match)
+		if err != nil {
+			log.Errorf("Invalid query %q, ignoring: %s", q.Match, err.Error())
+			continue
+		}
+
+		s.queries = append(s.queries, query{Query: q, re: re})
+	}
+
+	return s
+}
+
+// Query is a canned response to the queries matching the case insensitive
+// regular expression.
+type Query struct {
+	Match string `toml:"match"`
+
+	Columns []string   `toml:"columns"`
+	Rows    [][]string `toml:"rows"`
+
+	// Error is the message of the error returned instead of the rows
+	Error string `toml:"error"`
+}
+
+type query struct {
+	Query
+
+	re *regexp.Regexp
+}
+
+type Config struct {
+	Version string `toml:"version"`
+
+	// AuthMethod is md5, scram-sha-256 or password, the latter asks the
+	// clients for the password in cleartext
+	AuthMethod string `toml:"auth-method"`
+
+	// Databases are the databases that exist, connecting to other
+	// databases fails after the authentication
+	Databases []string `toml:"databases"`
+
+	// Queries are checked before the builtin responses
+	Queries []Query `toml:"query"`
+
+	authpolicy.Config
+}
+
+type postgresService struct {
+	Config
+
+	queries []query
+
+	policy *authpolicy.Policy
+
+	ch pushers.Channel
+}
+
+func (s *postgresService) SetChannel(c pushers.Channel) {
+	s.ch = c
+}
+
+func (s *postgresService) versionString() string {
+	return fmt.Sprintf("PostgreSQL %s on x86_64-pc-linux-gnu, compiled by gcc (Debian 8.3.0-6) 8.3.0, 64-bit", s.Version)
+}
+
+func random(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// session is the state of an authenticated session.
+type session struct {
+	username string
+	database string
+	pid      uint32
+}
+
+// startup reads the startup message, ssl and gss encryption requests are
+// declined. It returns nil parameters for cancel requests.
+func startup(c *messageConn, conn net.Conn) (map[string]string, error) {
+	for i := 0; i < 3; i++ {
+		body, err := c.readStartup()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(body) < 4 {
+			return nil, errMalformed
+		}
+
+		switch code := binary.BigEndian.Uint32(body); code {
+		case sslRequest, gssEncRequest:
+			if _, err := conn.Write([]byte{'N'}); err != nil {
+				return nil, err
+			}
+		case cancelRequest:
+			return nil, nil
+		case protocolVersion3:
+			return parseStartup(body[4:]), nil
+		default:
+			return nil, c.writeError("FATAL", "0A000", fmt.Sprintf("unsupported frontend protocol %d.%d: server supports 3.0 to 3.0", code>>16, code&0xffff))
+		}
+	}
+
+	return nil, errMalformed
+}
+
+// readPassword reads a password message, the SASL messages are password
+// messages as well.
+func (c *messageConn) readPassword() ([]byte, error) {
+	t, body, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if t != 'p' {
+		return nil, fmt.Errorf("Expected password message, got %q", t)
+	}
+
+	return body, nil
+}
+
+func (s *postgresService) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("postgres"),
+		connOptions,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+	}
+
+	send := func(t string, o ...event.Option) {
+		o = append([]event.Option{event.Type(t)}, o...)
+
+		s.ch.Send(event.New(
+			append(o, options...)...,
+		))
+	}
+
+	c := newMessageConn(conn)
+
+	params, err := startup(c, conn)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	} else if params == nil {
+		send("cancel-request")
+		return nil
+	}
+
+	sess := &session{
+		username: params["user"],
+		database: params["database"],
+		pid:      binary.BigEndian.Uint32(random(4)) % 32768,
+	}
+
+	if sess.database == "" {
+		sess.database = sess.username
+	}
+
+	options = append(options,
+		event.Custom("postgres.username", sess.username),
+		event.Custom("postgres.database", sess.database),
+	)
+
+	if name, ok := params["application_name"]; ok {
+		options = append(options, event.Custom("postgres.application-name", name))
+	}
+
+	accepted, authOptions, final, err := s.authenticate(c, conn.RemoteAddr(), sess.username)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	send("authentication", append(authOptions, event.Custom("postgres.accepted", accepted))...)
+
+	if !accepted {
+		return c.writeError("FATAL", "28P01", fmt.Sprintf("password authentication failed for user \"%s\"", sess.username))
+	}
+
+	if final != "" {
+		if err := c.writeAuth(authSASLFinal, []byte(final)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeAuth(authOK, nil); err != nil {
+		return err
+	}
+
+	if !s.exists(sess.database) {
+		return c.writeError("FATAL", "3D000", fmt.Sprintf("database \"%s\" does not exist", sess.database))
+	}
+
+	for _, p := range [][2]string{
+		{"application_name", params["application_name"]},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"integer_datetimes", "on"},
+		{"IntervalStyle", "postgres"},
+		{"is_superuser", "on"},
+		{"server_encoding", "UTF8"},
+		{"server_version", s.Version},
+		{"session_authorization", sess.username},
+		{"standard_conforming_strings", "on"},
+		{"TimeZone", "Etc/UTC"},
+	} {
+		if err := c.writeParameterStatus(p[0], p[1]); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeMessage('K', append(appendInt32(nil, sess.pid), random(4)...)); err != nil {
+		return err
+	}
+
+	if err := c.writeReady(); err != nil {
+		return err
+	}
+
+	for {
+		t, body, err := c.readMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch t {
+		case 'X':
+			return nil
+		case 'Q':
+			q, _ := cString(body)
+
+			send("query", event.Custom("postgres.query", q))
+
+			if err := s.query(c, sess, q); err != nil {
+				return err
+			}
+
+			err = c.writeReady()
+		case 'P':
+			// the extended query protocol isn't supported, the query
+			// of the parse message is recorded and the messages until
+			// the sync are ignored
+			_, rest := cString(body)
+			q, _ := cString(rest)
+
+			send("query",
+				event.Custom("postgres.query", q),
+				event.Custom("postgres.extended", true),
+			)
+
+			err = c.writeError("ERROR", "0A000", "extended query protocol not supported")
+		case 'B', 'D', 'E', 'C', 'H':
+		case 'S':
+			err = c.writeReady()
+		default:
+			return c.writeError("FATAL", "08P01", fmt.Sprintf("invalid frontend message type %d", t))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// authenticate requests the password with the configured method. For scram
+// it returns the final message of the server as well, which can only be
+// computed when the accepted password is known.
+func (s *postgresService) authenticate(c *messageConn, addr net.Addr, username string) (bool, []event.Option, string, error) {
+	options := []event.Option{
+		event.Custom("postgres.auth-method", s.AuthMethod),
+	}
+
+	switch s.AuthMethod {
+	case MethodPassword:
+		if err := c.writeAuth(authCleartextPassword, nil); err != nil {
+			return false, nil, "", err
+		}
+
+		body, err := c.readPassword()
+		if err != nil {
+			return false, nil, "", err
+		}
+
+		password, _ := cString(body)
+
+		options = append(options, event.Custom("postgres.password", password))
+
+		return s.policy.Accept(addr, username, password), options, "", nil
+	case MethodSCRAM:
+		if err := c.writeAuth(authSASL, appendString(appendString(nil, "SCRAM-SHA-256"), "")); err != nil {
+			return false, nil, "", err
+		}
+
+		body, err := c.readPassword()
+		if err != nil {
+			return false, nil, "", err
+		}
+
+		mechanism, rest := cString(body)
+		if mechanism != "SCRAM-SHA-256" || len(rest) < 4 {
+			return false, nil, "", errMalformed
+		}
+
+		sc := &scram{salt: random(16)}
+
+		serverFirst := sc.clientFirst(string(rest[4:]), base64.StdEncoding.EncodeToString(random(18)))
+		if err := c.writeAuth(authSASLContinue, []byte(serverFirst)); err != nil {
+			return false, nil, "", err
+		}
+
+		body, err = c.readPassword()
+		if err != nil {
+			return false, nil, "", err
+		}
+
+		sc.clientFinal = string(body)
+
+		options = append(options,
+			event.Custom("postgres.salt", hex.EncodeToString(sc.salt)),
+			event.Custom("postgres.response", sc.clientFinal),
+		)
+
+		// the server proves to know the password as well, without a
+		// matching password the client will abort the authentication
+		password := ""
+		accepted := s.policy.AcceptFunc(addr, func(u, pw string) bool {
+			if u == username && sc.verify(pw) {
+				password = pw
+				return true
+			}
+
+			return false
+		})
+
+		return accepted, options, sc.serverFinal(password), nil
+	}
+
+	salt := random(4)
+
+	if err := c.writeAuth(authMD5Password, salt); err != nil {
+		return false, nil, "", err
+	}
+
+	body, err := c.readPassword()
+	if err != nil {
+		return false, nil, "", err
+	}
+
+	response, _ := cString(body)
+
+	// the response can be cracked offline, the hash is in the format of
+	// hashcat (mode 11100)
+	options = append(options,
+		event.Custom("postgres.salt", hex.EncodeToString(salt)),
+		event.Custom("postgres.response", response),
+		event.Custom("postgres.hash", fmt.Sprintf("$postgres$%s*%x*%s", username, salt, strings.TrimPrefix(response, "md5"))),
+	)
+
+	accepted := s.policy.AcceptFunc(addr, func(u, pw string) bool {
+		return u == username && md5Response(u, pw, salt) == response
+	})
+
+	return accepted, options, "", nil
+}
+
+func (s *postgresService) exists(database string) bool {
+	if len(s.Databases) == 0 {
+		return true
+	}
+
+	for _, db := range s.Databases {
+		if db == database {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	databasesRegexp = regexp.MustCompile(`(?i)^select\s+datname\s+from\s+(pg_catalog\.)?pg_database`)
+	aliasRegexp     = regexp.MustCompile(`(?i)\s+as\s+`)
+)
+
+// query answers the simple query with the configured responses first, and
+// the builtin responses after. Other queries complete without rows.
+func (s *postgresService) query(c *messageConn, sess *session, q string) error {
+	q = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(q), ";"))
+	if q == "" {
+		return c.writeMessage('I', nil)
+	}
+
+	for _, cq := range s.queries {
+		if !cq.re.MatchString(q) {
+			continue
+		}
+
+		if cq.Error != "" {
+			return c.writeError("ERROR", "42601", cq.Error)
+		}
+
+		return c.writeRows(cq.Columns, cq.Rows)
+	}
+
+	fields := strings.Fields(strings.ToLower(q))
+
+	switch {
+	case databasesRegexp.MatchString(q):
+		rows := [][]string{}
+		for _, db := range s.Databases {
+			rows = append(rows, []string{db})
+		}
+
+		return c.writeRows([]string{"datname"}, rows)
+	case len(fields) == 2 && fields[0] == "show":
+		if value, ok := s.setting(sess, fields[1]); ok {
+			return c.writeRows([]string{fields[1]}, [][]string{{value}})
+		}
+
+		return c.writeError("ERROR", "42704", fmt.Sprintf("unrecognized configuration parameter \"%s\"", fields[1]))
+	case fields[0] == "select":
+		if columns, row, ok := s.selectExpressions(sess, q[len("select"):]); ok {
+			return c.writeRows(columns, [][]string{row})
+		}
+	}
+
+	tag := strings.ToUpper(fields[0])
+
+	switch tag {
+	case "INSERT":
+		tag += " 0 0"
+	case "SELECT", "UPDATE", "DELETE":
+		tag += " 0"
+	case "CREATE", "DROP", "ALTER":
+		if len(fields) > 1 {
+			tag += " " + strings.ToUpper(fields[1])
+		}
+	}
+
+	return c.writeComplete(tag)
+}
+
+// selectExpressions evaluates the select of known functions, like select
+// version() of scanners.
+func (s *postgresService) selectExpressions(sess *session, exprs string) ([]string, []string, bool) {
+	columns, row := []string{}, []string{}
+
+	for _, expr := range strings.Split(strings.TrimSpace(exprs), ",") {
+		expr = strings.TrimSpace(expr)
+
+		name := ""
+		if parts := aliasRegexp.Split(expr, 2); len(parts) == 2 {
+			expr, name = parts[0], strings.Trim(parts[1], `"`)
+		}
+
+		column, value, ok := s.function(sess, strings.ToLower(strings.Replace(expr, " ", "", -1)))
+		if !ok {
+			return nil, nil, false
+		}
+
+		if name == "" {
+			name = column
+		}
+
+		columns = append(columns, name)
+		row = append(row, value)
+	}
+
+	return columns, row, true
+}
+
+// function returns the column name and the value of the function.
+func (s *postgresService) function(sess *session, expr string) (string, string, bool) {
+	switch expr {
+	case "version()":
+		return "version", s.versionString(), true
+	case "current_database()":
+		return "current_database", sess.database, true
+	case "current_user", "user", "session_user", "current_role":
+		return expr, sess.username, true
+	case "current_schema", "current_schema()":
+		return "current_schema", "public", true
+	case "pg_backend_pid()":
+		return "pg_backend_pid", fmt.Sprintf("%d", sess.pid), true
+	}
+
+	// literals, like select 1 of connection checks
+	if expr != "" && strings.Trim(expr, "0123456789") == "" {
+		return "?column?", expr, true
+	}
+
+	return "", "", false
+}
+
+// setting returns the value of the configuration parameter of show.
+func (s *postgresService) setting(sess *session, name string) (string, bool) {
+	switch name {
+	case "server_version":
+		return s.Version, true
+	case "server_encoding", "client_encoding":
+		return "UTF8", true
+	case "timezone":
+		return "Etc/UTC", true
+	case "datestyle":
+		return "ISO, MDY", true
+	case "is_superuser":
+		return "on", true
+	case "transaction_isolation":
+		return "read committed", true
+	case "search_path":
+		return `"$user", public`, true
+	case "session_authorization":
+		return sess.username, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,320 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/utils/harness"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// connect connects to the service and sends the startup message, it
+// returns the connection of the client.
+func connect(t *testing.T, events *harness.Recorder, params []string, options ...services.ServicerFunc) (*messageConn, net.Conn) {
+	s := Postgres(options...)
+	s.SetChannel(events)
+
+	server, client := net.Pipe()
+
+	go s.Handle(context.TODO(), server)
+
+	c := newMessageConn(client)
+
+	body := appendInt32(nil, protocolVersion3)
+	for _, p := range params {
+		body = appendString(body, p)
+	}
+
+	body = append(body, 0)
+
+	if _, err := client.Write(append(appendInt32(nil, uint32(len(body)+4)), body...)); err != nil {
+		t.Fatal(err)
+	}
+
+	return c, client
+}
+
+// expect reads the next message, which should be of the type.
+func expect(t *testing.T, c *messageConn, typ byte) []byte {
+	m, body, err := c.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m != typ {
+		t.Fatalf("Expected message %c, got %c %q", typ, m, body)
+	}
+
+	return body
+}
+
+// ready reads the messages until ready for query.
+func ready(t *testing.T, c *messageConn) {
+	for {
+		if m, _, err := c.readMessage(); err != nil {
+			t.Fatal(err)
+		} else if m == 'Z' {
+			return
+		}
+	}
+}
+
+// rows reads the data rows of a simple query.
+func rows(t *testing.T, c *messageConn) [][]string {
+	expect(t, c, 'T')
+
+	result := [][]string{}
+	for {
+		m, body, err := c.readMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m != 'D' {
+			ready(t, c)
+			return result
+		}
+
+		row := []string{}
+		for body = body[2:]; len(body) > 0; {
+			n := int(binary.BigEndian.Uint32(body))
+			row = append(row, string(body[4:4+n]))
+			body = body[4+n:]
+		}
+
+		result = append(result, row)
+	}
+}
+
+// clientProof returns the proof of the client of the scram exchange.
+func clientProof(sc *scram, password string) []byte {
+	salted := pbkdf2.Key([]byte(password), sc.salt, scramIterations, sha256.Size, sha256.New)
+
+	clientKey := scramHMAC(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := scramHMAC(storedKey[:], sc.authMessage())
+
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	return proof
+}
+
+func TestPostgresMD5(t *testing.T) {
+	events := harness.NewRecorder()
+
+	c, client := connect(t, events, []string{"user", "postgres", "database", "postgres"})
+	defer client.Close()
+
+	body := expect(t, c, 'R')
+	if binary.BigEndian.Uint32(body) != authMD5Password {
+		t.Fatalf("Expected md5 authentication, got %q", body)
+	}
+
+	salt := body[4:]
+
+	if err := c.writeMessage('p', appendString(nil, md5Response("postgres", "secret", salt))); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := expect(t, c, 'E'); !strings.Contains(string(body), `password authentication failed for user "postgres"`) {
+		t.Errorf("Expected authentication failure, got %q", body)
+	}
+
+	recorded, err := events.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := recorded[0]
+	if e.Get("type") != "authentication" || e.Get("postgres.username") != "postgres" || e.Get("postgres.database") != "postgres" {
+		t.Errorf("Expected authentication event of postgres, got %s %s", e.Get("type"), e.Get("postgres.username"))
+	}
+
+	if !strings.HasPrefix(e.Get("postgres.hash"), "$postgres$postgres*"+e.Get("postgres.salt")+"*") {
+		t.Errorf("Expected hash of salt and response, got %s", e.Get("postgres.hash"))
+	}
+}
+
+func TestPostgresQuery(t *testing.T) {
+	events := harness.NewRecorder()
+
+	s := Postgres()
+	s.SetChannel(events)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	// ssl is declined
+	if _, err := client.Write(appendInt32(appendInt32(nil, 8), sslRequest)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 1)
+	if _, err := client.Read(b); err != nil || b[0] != 'N' {
+		t.Fatalf("Expected ssl to be declined, got %q", b)
+	}
+
+	body := appendString(appendString(appendInt32(nil, protocolVersion3), "user"), "postgres")
+	body = append(body, 0)
+
+	if _, err := client.Write(append(appendInt32(nil, uint32(len(body)+4)), body...)); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newMessageConn(client)
+
+	salt := expect(t, c, 'R')[4:]
+
+	if err := c.writeMessage('p', appendString(nil, md5Response("postgres", "postgres", salt))); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := expect(t, c, 'R'); binary.BigEndian.Uint32(body) != authOK {
+		t.Fatalf("Expected authentication ok, got %q", body)
+	}
+
+	ready(t, c)
+
+	if err := c.writeMessage('Q', appendString(nil, "SELECT version();")); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := rows(t, c); len(result) != 1 || !strings.HasPrefix(result[0][0], "PostgreSQL 13.4 on") {
+		t.Errorf("Expected version, got %q", result)
+	}
+
+	if err := c.writeMessage('Q', appendString(nil, "select datname from pg_database")); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := rows(t, c); len(result) != 3 || result[0][0] != "postgres" {
+		t.Errorf("Expected databases, got %q", result)
+	}
+
+	recorded, err := events.Wait(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := recorded[1]; e.Get("type") != "query" || e.Get("postgres.query") != "SELECT version();" {
+		t.Errorf("Expected query event, got %s %s", e.Get("type"), e.Get("postgres.query"))
+	}
+}
+
+func TestPostgresPassword(t *testing.T) {
+	events := harness.NewRecorder()
+
+	c, client := connect(t, events, []string{"user", "admin", "database", "shop"}, func(s services.Servicer) error {
+		s.(*postgresService).AuthMethod = MethodPassword
+		return nil
+	})
+	defer client.Close()
+
+	if body := expect(t, c, 'R'); binary.BigEndian.Uint32(body) != authCleartextPassword {
+		t.Fatalf("Expected cleartext authentication, got %q", body)
+	}
+
+	if err := c.writeMessage('p', appendString(nil, "admin")); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, c, 'R')
+
+	if body := expect(t, c, 'E'); !strings.Contains(string(body), `database "shop" does not exist`) {
+		t.Errorf("Expected unknown database, got %q", body)
+	}
+
+	recorded, err := events.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := recorded[0]; e.Get("postgres.password") != "admin" || e.Get("postgres.database") != "shop" {
+		t.Errorf("Expected password of admin, got %s", e.Get("postgres.password"))
+	}
+}
+
+func TestPostgresSCRAM(t *testing.T) {
+	events := harness.NewRecorder()
+
+	c, client := connect(t, events, []string{"user", "postgres"}, func(s services.Servicer) error {
+		s.(*postgresService).AuthMethod = MethodSCRAM
+		return nil
+	})
+	defer client.Close()
+
+	if body := expect(t, c, 'R'); binary.BigEndian.Uint32(body) != authSASL {
+		t.Fatalf("Expected sasl authentication, got %q", body)
+	}
+
+	sc := &scram{}
+
+	clientFirst := "n,,n=,r=rOprNGfwEbeRWgbNEkqO"
+	body := appendString(nil, "SCRAM-SHA-256")
+	body = appendInt32(body, uint32(len(clientFirst)))
+	body = append(body, clientFirst...)
+
+	if err := c.writeMessage('p', body); err != nil {
+		t.Fatal(err)
+	}
+
+	body = expect(t, c, 'R')
+	if binary.BigEndian.Uint32(body) != authSASLContinue {
+		t.Fatalf("Expected sasl continue, got %q", body)
+	}
+
+	sc.clientFirstBare = "n=,r=rOprNGfwEbeRWgbNEkqO"
+	sc.serverFirst = string(body[4:])
+
+	attrs := parseAttributes(sc.serverFirst)
+	sc.salt, _ = base64.StdEncoding.DecodeString(attrs["s"])
+
+	sc.clientFinal = "c=biws,r=" + attrs["r"]
+	sc.clientFinal += ",p=" + base64.StdEncoding.EncodeToString(clientProof(sc, "postgres"))
+
+	if err := c.writeMessage('p', []byte(sc.clientFinal)); err != nil {
+		t.Fatal(err)
+	}
+
+	body = expect(t, c, 'R')
+	if binary.BigEndian.Uint32(body) != authSASLFinal || string(body[4:]) != sc.serverFinal("postgres") {
+		t.Fatalf("Expected server signature, got %q", body)
+	}
+
+	if body := expect(t, c, 'R'); binary.BigEndian.Uint32(body) != authOK {
+		t.Fatalf("Expected authentication ok, got %q", body)
+	}
+
+	recorded, err := events.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e := recorded[0]; !strings.Contains(e.Get("postgres.response"), ",p=") {
+		t.Errorf("Expected client final message, got %s", e.Get("postgres.response"))
+	}
+}
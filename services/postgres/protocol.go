@@ -0,0 +1,214 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrMessageTooLarge = errors.New("Message too large")
+
+// maxMessageSize limits the size of the messages that are read.
+const maxMessageSize = 1 << 20
+
+// Codes of the startup message.
+const (
+	protocolVersion3 = 196608
+	sslRequest       = 80877103
+	gssEncRequest    = 80877104
+	cancelRequest    = 80877102
+)
+
+// Authentication requests.
+const (
+	authOK                = 0
+	authCleartextPassword = 3
+	authMD5Password       = 5
+	authSASL              = 10
+	authSASLContinue      = 11
+	authSASLFinal         = 12
+)
+
+// oidText is the type of all columns of the result sets.
+const oidText = 25
+
+// messageConn reads and writes the messages of the protocol.
+type messageConn struct {
+	br *bufio.Reader
+	w  io.Writer
+}
+
+func newMessageConn(rw io.ReadWriter) *messageConn {
+	return &messageConn{
+		br: bufio.NewReader(rw),
+		w:  rw,
+	}
+}
+
+func (c *messageConn) readBody() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.br, header); err != nil {
+		return nil, err
+	}
+
+	size := int(binary.BigEndian.Uint32(header))
+	if size < 4 || size > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(c.br, body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// readStartup reads a startup message, which is not preceded by a type.
+func (c *messageConn) readStartup() ([]byte, error) {
+	return c.readBody()
+}
+
+// readMessage reads a message and its type.
+func (c *messageConn) readMessage() (byte, []byte, error) {
+	t, err := c.br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	body, err := c.readBody()
+	return t, body, err
+}
+
+func (c *messageConn) writeMessage(t byte, body []byte) error {
+	buf := make([]byte, 5, 5+len(body))
+	buf[0] = t
+	binary.BigEndian.PutUint32(buf[1:], uint32(4+len(body)))
+
+	_, err := c.w.Write(append(buf, body...))
+	return err
+}
+
+func (c *messageConn) writeAuth(code uint32, data []byte) error {
+	return c.writeMessage('R', append(appendInt32(nil, code), data...))
+}
+
+func (c *messageConn) writeParameterStatus(name, value string) error {
+	return c.writeMessage('S', appendString(appendString(nil, name), value))
+}
+
+func (c *messageConn) writeReady() error {
+	return c.writeMessage('Z', []byte{'I'})
+}
+
+// writeError writes an error response, fatal errors end the session.
+func (c *messageConn) writeError(severity, code, message string) error {
+	buf := []byte{}
+	buf = appendString(append(buf, 'S'), severity)
+	buf = appendString(append(buf, 'V'), severity)
+	buf = appendString(append(buf, 'C'), code)
+	buf = appendString(append(buf, 'M'), message)
+	buf = append(buf, 0)
+
+	return c.writeMessage('E', buf)
+}
+
+// writeRows writes the row description, the data rows and the command
+// completion of a select.
+func (c *messageConn) writeRows(columns []string, rows [][]string) error {
+	buf := appendInt16(nil, uint16(len(columns)))
+	for _, column := range columns {
+		buf = appendString(buf, column)
+		buf = appendInt32(buf, 0)
+		buf = appendInt16(buf, 0)
+		buf = appendInt32(buf, oidText)
+		buf = appendInt16(buf, 0xffff)
+		buf = appendInt32(buf, 0xffffffff)
+		buf = appendInt16(buf, 0)
+	}
+
+	if err := c.writeMessage('T', buf); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		buf := appendInt16(nil, uint16(len(columns)))
+		for i := range columns {
+			if i < len(row) {
+				buf = appendInt32(buf, uint32(len(row[i])))
+				buf = append(buf, row[i]...)
+			} else {
+				// null
+				buf = appendInt32(buf, 0xffffffff)
+			}
+		}
+
+		if err := c.writeMessage('D', buf); err != nil {
+			return err
+		}
+	}
+
+	return c.writeComplete(fmt.Sprintf("SELECT %d", len(rows)))
+}
+
+func (c *messageConn) writeComplete(tag string) error {
+	return c.writeMessage('C', appendString(nil, tag))
+}
+
+func appendInt16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendInt32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(append(buf, s...), 0)
+}
+
+// cString returns the null terminated string at the start of the buffer,
+// and the remainder of the buffer.
+func cString(buf []byte) (string, []byte) {
+	i := bytes.IndexByte(buf, 0)
+	if i < 0 {
+		return string(buf), nil
+	}
+
+	return string(buf[:i]), buf[i+1:]
+}
+
+// parseStartup parses the parameters of a startup message of protocol 3.
+func parseStartup(body []byte) map[string]string {
+	params := map[string]string{}
+
+	for len(body) > 0 {
+		var key, value string
+
+		key, body = cString(body)
+		if key == "" {
+			break
+		}
+
+		value, body = cString(body)
+		params[key] = value
+	}
+
+	return params
+}
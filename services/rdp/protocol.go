@@ -0,0 +1,331 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rdp
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	x224ConnectionRequest = 0xe0
+	x224ConnectionConfirm = 0xd0
+)
+
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rdpbcgr
+const (
+	typeNegotiationRequest  = 0x01
+	typeNegotiationResponse = 0x02
+	typeNegotiationFailure  = 0x03
+
+	protocolRDP      = 0x00
+	protocolSSL      = 0x01
+	protocolHybrid   = 0x02
+	protocolRDSTLS   = 0x04
+	protocolHybridEx = 0x08
+
+	failureHybridRequired = 0x05
+)
+
+var (
+	ErrInvalidTPKT  = errors.New("Invalid TPKT header")
+	ErrInvalidX224  = errors.New("Invalid X.224 connection request")
+	ErrNoClientData = errors.New("No client core data in connect initial")
+)
+
+type connectionRequest struct {
+	// Cookie is the username of the mstshash cookie, truncated by the
+	// clients
+	Cookie string
+
+	// RoutingToken is the token of the load balancers, like msts=...
+	RoutingToken string
+
+	Flags              uint8
+	RequestedProtocols uint32
+}
+
+// readConnectionRequest reads the tpkt encapsulated x.224 connection request.
+func readConnectionRequest(r io.Reader) (*connectionRequest, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != 0x03 {
+		return nil, ErrInvalidTPKT
+	}
+
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 11 {
+		return nil, ErrInvalidTPKT
+	}
+
+	data := make([]byte, length-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	if data[1] != x224ConnectionRequest {
+		return nil, ErrInvalidX224
+	}
+
+	cr := &connectionRequest{}
+
+	// skip length indicator, code, dst-ref, src-ref and class
+	data = data[7:]
+
+	if i := bytes.Index(data, []byte("\r\n")); i >= 0 && bytes.HasPrefix(data, []byte("Cookie: ")) {
+		if line := string(data[:i]); strings.HasPrefix(line, "Cookie: mstshash=") {
+			cr.Cookie = strings.TrimPrefix(line, "Cookie: mstshash=")
+		} else {
+			cr.RoutingToken = strings.TrimPrefix(line, "Cookie: ")
+		}
+
+		data = data[i+2:]
+	}
+
+	if len(data) >= 8 && data[0] == typeNegotiationRequest {
+		cr.Flags = data[1]
+		cr.RequestedProtocols = binary.LittleEndian.Uint32(data[4:8])
+	}
+
+	return cr, nil
+}
+
+// clientCoreData contains the fields of the client core data (TS_UD_CS_CORE)
+// of the mcs connect initial, which identify the client.
+type clientCoreData struct {
+	Version        uint32
+	DesktopWidth   uint16
+	DesktopHeight  uint16
+	KeyboardLayout uint32
+	ClientBuild    uint32
+	ClientName     string
+	KeyboardType   uint32
+}
+
+// clientCoreDataLength is the length of the mandatory fields of the client
+// core data, including the user data header.
+const clientCoreDataLength = 132
+
+// readClientCoreData reads the mcs connect initial, and returns the client
+// core data of its gcc conference create request.
+func readClientCoreData(r io.Reader) (*clientCoreData, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != 0x03 {
+		return nil, ErrInvalidTPKT
+	}
+
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 4 {
+		return nil, ErrInvalidTPKT
+	}
+
+	data := make([]byte, length-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return parseClientCoreData(data)
+}
+
+// parseClientCoreData finds the client core data in the user data, it
+// starts with the type 0xc001 and the version 0x0008xxxx.
+func parseClientCoreData(data []byte) (*clientCoreData, error) {
+	for i := 0; i+clientCoreDataLength <= len(data); i++ {
+		if data[i] != 0x01 || data[i+1] != 0xc0 || data[i+6] != 0x08 || data[i+7] != 0x00 {
+			continue
+		}
+
+		if int(binary.LittleEndian.Uint16(data[i+2:])) < clientCoreDataLength {
+			continue
+		}
+
+		core := data[i+4:]
+
+		name := []uint16{}
+		for j := 20; j < 52; j += 2 {
+			c := binary.LittleEndian.Uint16(core[j:])
+			if c == 0 {
+				break
+			}
+
+			name = append(name, c)
+		}
+
+		return &clientCoreData{
+			Version:        binary.LittleEndian.Uint32(core[0:]),
+			DesktopWidth:   binary.LittleEndian.Uint16(core[4:]),
+			DesktopHeight:  binary.LittleEndian.Uint16(core[6:]),
+			KeyboardLayout: binary.LittleEndian.Uint32(core[12:]),
+			ClientBuild:    binary.LittleEndian.Uint32(core[16:]),
+			ClientName:     string(utf16.Decode(name)),
+			KeyboardType:   binary.LittleEndian.Uint32(core[52:]),
+		}, nil
+	}
+
+	return nil, ErrNoClientData
+}
+
+func tpkt(data []byte) []byte {
+	header := []byte{0x03, 0x00, 0x00, 0x00}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)+4))
+	return append(header, data...)
+}
+
+// connectionConfirm returns the x.224 connection confirm with the selected
+// protocol.
+func connectionConfirm(selected uint32) []byte {
+	data := []byte{
+		0x0e, x224ConnectionConfirm, 0x00, 0x00, 0x12, 0x34, 0x00,
+		typeNegotiationResponse, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	binary.LittleEndian.PutUint32(data[11:], selected)
+	return tpkt(data)
+}
+
+// negotiationFailure returns the x.224 connection confirm with the failure
+// code.
+func negotiationFailure(code uint32) []byte {
+	data := []byte{
+		0x0e, x224ConnectionConfirm, 0x00, 0x00, 0x12, 0x34, 0x00,
+		typeNegotiationFailure, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	binary.LittleEndian.PutUint32(data[11:], code)
+	return tpkt(data)
+}
+
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-cssp
+type negoToken struct {
+	Token []byte `asn1:"explicit,tag:0"`
+}
+
+type tsRequest struct {
+	Version     int         `asn1:"explicit,tag:0"`
+	NegoTokens  []negoToken `asn1:"explicit,optional,tag:1"`
+	AuthInfo    []byte      `asn1:"explicit,optional,tag:2"`
+	PubKeyAuth  []byte      `asn1:"explicit,optional,tag:3"`
+	ErrorCode   int32       `asn1:"explicit,optional,tag:4"`
+	ClientNonce []byte      `asn1:"explicit,optional,tag:5"`
+}
+
+func (r *tsRequest) token() []byte {
+	if len(r.NegoTokens) == 0 {
+		return nil
+	}
+
+	return r.NegoTokens[0].Token
+}
+
+// statusLogonFailure is the NTSTATUS of a failed logon.
+const statusLogonFailure uint32 = 0xc000006d
+
+// maxTSRequestSize limits the size of the requests that will be read.
+const maxTSRequestSize = 64 * 1024
+
+// readDER reads a single der encoded element.
+func readDER(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+
+	if header[1]&0x80 != 0 {
+		n := int(header[1] & 0x7f)
+		if n == 0 || n > 3 {
+			return nil, asn1.StructuralError{Msg: "unsupported length"}
+		}
+
+		lb := make([]byte, n)
+		if _, err := io.ReadFull(r, lb); err != nil {
+			return nil, err
+		}
+
+		header = append(header, lb...)
+
+		length = 0
+		for _, b := range lb {
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > maxTSRequestSize {
+		return nil, asn1.StructuralError{Msg: "request too large"}
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return append(header, data...), nil
+}
+
+func readTSRequest(r io.Reader) (*tsRequest, error) {
+	data, err := readDER(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &tsRequest{}
+	if _, err := asn1.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func writeTSRequest(w io.Writer, version int, token []byte) error {
+	data, err := asn1.Marshal(tsRequest{
+		Version: version,
+		NegoTokens: []negoToken{
+			{Token: token},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// writeTSError writes the NTSTATUS code, which is encoded as a signed
+// integer.
+func writeTSError(w io.Writer, version int, code uint32) error {
+	data, err := asn1.Marshal(tsRequest{
+		Version:   version,
+		ErrorCode: int32(code),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,334 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rdp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	tls "github.com/honeytrap/honeytrap/services/ja3/crypto/tls"
+	"github.com/honeytrap/honeytrap/services/ntlm"
+)
+
+var log = logging.MustGetLogger("services/rdp")
+
+var (
+	_ = services.Register("rdp", RDP)
+)
+
+// RDP returns the rdp service. In NLA mode (the default) the service
+// requires CredSSP, and completes the NTLM exchange far enough to capture
+// the NetNTLM response of the client.
+func RDP(options ...services.ServicerFunc) services.Servicer {
+	s := &rdpService{
+		NLA:      true,
+		Domain:   "CORP",
+		Computer: "TS01",
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+type rdpService struct {
+	c pushers.Channel
+
+	NLA      bool   `toml:"nla"`
+	Domain   string `toml:"domain"`
+	Computer string `toml:"computer"`
+
+	m    sync.Mutex
+	cert *tls.Certificate
+}
+
+func (s *rdpService) SetChannel(c pushers.Channel) {
+	s.c = c
+}
+
+func (s *rdpService) CanHandle(payload []byte) bool {
+	// tpkt version 3, followed by a x.224 connection request
+	return len(payload) > 5 && payload[0] == 0x03 && payload[1] == 0x00 && payload[5] == x224ConnectionRequest
+}
+
+// certificate returns the self signed certificate of the service, it is
+// generated on first use.
+func (s *rdpService) certificate() (*tls.Certificate, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.cert != nil {
+		return s.cert, nil
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{
+			CommonName: s.Computer,
+		},
+		NotBefore:   time.Now().AddDate(0, -1, 0),
+		NotAfter:    time.Now().AddDate(0, 6, 0),
+		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cert = &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+	}
+
+	return s.cert, nil
+}
+
+func (s *rdpService) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	cr, err := readConnectionRequest(conn)
+	if err != nil {
+		return err
+	}
+
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("rdp"),
+		connOptions,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("rdp.cookie", cr.Cookie),
+		event.Custom("rdp.requested-protocols", protocolNames(cr.RequestedProtocols)),
+		event.Custom("rdp.negotiation-flags", cr.Flags),
+	}
+
+	if cr.RoutingToken != "" {
+		options = append(options, event.Custom("rdp.routing-token", cr.RoutingToken))
+	}
+
+	selected := uint32(protocolRDP)
+
+	switch {
+	case s.NLA && cr.RequestedProtocols&protocolHybrid != 0:
+		selected = protocolHybrid
+	case s.NLA:
+		// the client doesn't support nla
+		s.c.Send(event.New(
+			append(options,
+				event.Type("connection-request"),
+				event.Custom("rdp.selected-protocol", "none"),
+			)...,
+		))
+
+		_, err := conn.Write(negotiationFailure(failureHybridRequired))
+		return err
+	case cr.RequestedProtocols&protocolSSL != 0:
+		selected = protocolSSL
+	}
+
+	options = append(options, event.Custom("rdp.selected-protocol", protocolNames(selected)[0]))
+
+	s.c.Send(event.New(
+		append(options, event.Type("connection-request"))...,
+	))
+
+	if _, err := conn.Write(connectionConfirm(selected)); err != nil {
+		return err
+	}
+
+	if selected == protocolRDP {
+		// standard rdp security is not supported beyond the connect
+		// initial of the client, which is sent in clear text
+		return s.clientData(conn, options)
+	}
+
+	ja3Digest := ""
+
+	tlsConn := tls.Server(conn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			ja3Digest = hello.JA3Digest()
+			return s.certificate()
+		},
+	})
+
+	if err := tlsConn.Handshake(); err != nil {
+		s.c.Send(event.New(
+			append(options,
+				event.Type("handshake-failed"),
+				event.Custom("rdp.ja3-digest", ja3Digest),
+			)...,
+		))
+
+		return err
+	}
+
+	options = append(options, event.Custom("rdp.ja3-digest", ja3Digest))
+
+	if selected != protocolHybrid {
+		s.c.Send(event.New(
+			append(options, event.Type("tls-established"))...,
+		))
+
+		return s.clientData(tlsConn, options)
+	}
+
+	return s.credSSP(tlsConn, options)
+}
+
+// clientData reads the mcs connect initial, its client core data
+// identifies the client by name, build and keyboard layout.
+func (s *rdpService) clientData(conn net.Conn, options []event.Option) error {
+	cd, err := readClientCoreData(conn)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	s.c.Send(event.New(
+		append(options,
+			event.Type("client-data"),
+			event.Custom("rdp.client-name", cd.ClientName),
+			event.Custom("rdp.client-build", cd.ClientBuild),
+			event.Custom("rdp.client-version", fmt.Sprintf("%08x", cd.Version)),
+			event.Custom("rdp.keyboard-layout", fmt.Sprintf("%08x", cd.KeyboardLayout)),
+			event.Custom("rdp.keyboard-type", cd.KeyboardType),
+			event.Custom("rdp.desktop-size", fmt.Sprintf("%dx%d", cd.DesktopWidth, cd.DesktopHeight)),
+		)...,
+	))
+
+	return nil
+}
+
+// credSSP handles the CredSSP exchange until the NTLM authenticate message
+// has been received.
+func (s *rdpService) credSSP(conn net.Conn, options []event.Option) error {
+	server := ntlm.NewServer(s.Domain, s.Computer)
+
+	req, err := readTSRequest(conn)
+	if err != nil {
+		return err
+	}
+
+	negotiate := req.token()
+	if !ntlm.IsNTLMSSP(negotiate) {
+		// kerberos or an unsupported spnego mechanism
+		s.c.Send(event.New(
+			append(options,
+				event.Type("credssp-unsupported"),
+				event.Custom("rdp.credssp-version", req.Version),
+				event.Custom("rdp.nego-token", hex.EncodeToString(negotiate)),
+			)...,
+		))
+
+		return nil
+	}
+
+	challenge, err := server.ChallengeMessage(negotiate)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTSRequest(conn, req.Version, challenge); err != nil {
+		return err
+	}
+
+	req, err = readTSRequest(conn)
+	if err != nil {
+		return err
+	}
+
+	authenticate := req.token()
+
+	a, err := server.ParseAuthenticate(authenticate)
+	if err != nil {
+		return err
+	}
+
+	options = append(options,
+		event.Type("credssp-authentication"),
+		event.Custom("rdp.credssp-version", req.Version),
+		event.Custom("ntlm.negotiate", hex.EncodeToString(negotiate)),
+		event.Custom("ntlm.challenge", hex.EncodeToString(challenge)),
+		event.Custom("ntlm.authenticate", hex.EncodeToString(authenticate)),
+		a.Options(),
+	)
+
+	if n, err := ntlm.ParseNegotiate(negotiate); err == nil {
+		options = append(options, event.Custom("rdp.ntlm-negotiate-flags", fmt.Sprintf("%08x", n.Flags)))
+
+		// the version of windows of the client
+		if n.Version != "" {
+			options = append(options, event.Custom("rdp.client-os-version", n.Version))
+		}
+	}
+
+	s.c.Send(event.New(options...))
+
+	return writeTSError(conn, req.Version, statusLogonFailure)
+}
+
+func protocolNames(protocols uint32) []string {
+	names := []string{}
+
+	for _, p := range []struct {
+		flag uint32
+		name string
+	}{
+		{protocolSSL, "ssl"},
+		{protocolHybrid, "hybrid"},
+		{protocolRDSTLS, "rdstls"},
+		{protocolHybridEx, "hybrid-ex"},
+	} {
+		if protocols&p.flag != 0 {
+			names = append(names, p.name)
+		}
+	}
+
+	if len(names) == 0 {
+		names = append(names, "rdp")
+	}
+
+	return names
+}
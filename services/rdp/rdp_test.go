@@ -0,0 +1,220 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rdp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+)
+
+// connectionRequestPacket returns a connection request as sent by mstsc.
+func connectionRequestPacket(cookie string, protocols uint32) []byte {
+	data := []byte{0x00, x224ConnectionRequest, 0x00, 0x00, 0x00, 0x00, 0x00}
+	data = append(data, []byte("Cookie: mstshash="+cookie+"\r\n")...)
+
+	neg := []byte{typeNegotiationRequest, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00}
+	binary.LittleEndian.PutUint32(neg[4:], protocols)
+	data = append(data, neg...)
+
+	data[0] = byte(len(data) - 1)
+	return tpkt(data)
+}
+
+func TestReadConnectionRequest(t *testing.T) {
+	packet := connectionRequestPacket("administrator", protocolSSL|protocolHybrid)
+
+	if !RDP().(services.CanHandlerer).CanHandle(packet) {
+		t.Fatal("Expected service to handle connection request")
+	}
+
+	cr, err := readConnectionRequest(bytes.NewReader(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cr.Cookie != "administrator" {
+		t.Errorf("Expected cookie administrator, got %s", cr.Cookie)
+	}
+
+	if cr.RequestedProtocols != protocolSSL|protocolHybrid {
+		t.Errorf("Expected requested protocols 3, got %d", cr.RequestedProtocols)
+	}
+}
+
+func TestRoutingToken(t *testing.T) {
+	data := []byte{0x00, x224ConnectionRequest, 0x00, 0x00, 0x00, 0x00, 0x00}
+	data = append(data, []byte("Cookie: msts=3640205228.15629.0000\r\n")...)
+	data[0] = byte(len(data) - 1)
+
+	cr, err := readConnectionRequest(bytes.NewReader(tpkt(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cr.Cookie != "" || cr.RoutingToken != "msts=3640205228.15629.0000" {
+		t.Errorf("Expected routing token, got cookie %q and token %q", cr.Cookie, cr.RoutingToken)
+	}
+}
+
+// connectInitialPacket returns a connect initial with the client core data,
+// the gcc encoding that precedes it is not parsed.
+func connectInitialPacket(name string, build uint32, layout uint32) []byte {
+	core := make([]byte, 132)
+	binary.LittleEndian.PutUint16(core[0:], 0xc001)
+	binary.LittleEndian.PutUint16(core[2:], 216)
+	binary.LittleEndian.PutUint32(core[4:], 0x0008000c)
+	binary.LittleEndian.PutUint16(core[8:], 1920)
+	binary.LittleEndian.PutUint16(core[10:], 1080)
+	binary.LittleEndian.PutUint32(core[16:], layout)
+	binary.LittleEndian.PutUint32(core[20:], build)
+
+	for i, c := range name {
+		binary.LittleEndian.PutUint16(core[24+i*2:], uint16(c))
+	}
+
+	binary.LittleEndian.PutUint32(core[56:], 4)
+
+	data := []byte{0x02, 0xf0, 0x80, 0x7f, 0x65, 0x82, 0x01, 0x94, 0x04, 0x01, 0x01}
+	data = append(data, bytes.Repeat([]byte{0x00}, 40)...)
+	data = append(data, core...)
+
+	return tpkt(data)
+}
+
+func TestClientData(t *testing.T) {
+	c := make(eventChannel, 10)
+
+	s := RDP(func(s services.Servicer) error {
+		s.(*rdpService).NLA = false
+		return nil
+	})
+	s.SetChannel(pushers.Channel(c))
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	if _, err := client.Write(connectionRequestPacket("hello", protocolRDP)); err != nil {
+		t.Fatal(err)
+	}
+
+	response := make([]byte, 19)
+	if _, err := client.Read(response); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := <-c; e.Get("rdp.selected-protocol") != "rdp" {
+		t.Errorf("Expected standard rdp security, got %s", e.Get("rdp.selected-protocol"))
+	}
+
+	if _, err := client.Write(connectInitialPacket("KALI", 2600, 0x409)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-c
+	if e.Get("type") != "client-data" || e.Get("rdp.client-name") != "KALI" {
+		t.Errorf("Expected client data of KALI, got %s %q", e.Get("type"), e.Get("rdp.client-name"))
+	}
+
+	if e.Get("rdp.keyboard-layout") != "00000409" || e.Get("rdp.desktop-size") != "1920x1080" || e.Get("rdp.client-version") != "0008000c" {
+		t.Errorf("Expected client fields, got %s %s %s", e.Get("rdp.keyboard-layout"), e.Get("rdp.desktop-size"), e.Get("rdp.client-version"))
+	}
+}
+
+func TestTSRequest(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	token := []byte("NTLMSSP\x00\x02\x00\x00\x00")
+	if err := writeTSRequest(buf, 6, token); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := readTSRequest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Version != 6 {
+		t.Errorf("Expected version 6, got %d", req.Version)
+	}
+
+	if !bytes.Equal(req.token(), token) {
+		t.Errorf("Expected token %x, got %x", token, req.token())
+	}
+}
+
+func TestTSError(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	if err := writeTSError(buf, 6, statusLogonFailure); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := readTSRequest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uint32(req.ErrorCode) != statusLogonFailure {
+		t.Errorf("Expected error code %#x, got %#x", statusLogonFailure, uint32(req.ErrorCode))
+	}
+}
+
+type eventChannel chan event.Event
+
+func (c eventChannel) Send(e event.Event) {
+	c <- e
+}
+
+func TestNLARequired(t *testing.T) {
+	c := make(eventChannel, 10)
+
+	s := RDP()
+	s.SetChannel(pushers.Channel(c))
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go s.Handle(context.TODO(), server)
+
+	if _, err := client.Write(connectionRequestPacket("test", protocolSSL)); err != nil {
+		t.Fatal(err)
+	}
+
+	response := make([]byte, 19)
+	if _, err := client.Read(response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response[11] != typeNegotiationFailure || response[15] != failureHybridRequired {
+		t.Errorf("Expected negotiation failure, got %x", response)
+	}
+
+	e := <-c
+	if e.Get("rdp.cookie") != "test" {
+		t.Errorf("Expected cookie test, got %s", e.Get("rdp.cookie"))
+	}
+
+	if e.Get("rdp.selected-protocol") != "none" {
+		t.Errorf("Expected no selected protocol, got %s", e.Get("rdp.selected-protocol"))
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rsync
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/rsync")
+
+var (
+	_ = services.Register("rsync", Rsync)
+)
+
+var ErrLineTooLong = errors.New("Line too long")
+
+// maxLineLength limits the length of the lines and arguments that are read
+const maxLineLength = 4096
+
+// Rsync returns the rsync daemon service. The service lists the configured
+// modules, captures the challenge responses for protected modules and
+// records the arguments of transfer requests.
+func Rsync(options ...services.ServicerFunc) services.Servicer {
+	s := &rsyncService{
+		Config: Config{
+			Version: "31.0",
+			Modules: []Module{
+				{Name: "backup", Comment: "nightly backups", Users: []string{"backup"}},
+				{Name: "www", Comment: "web root"},
+			},
+		},
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+// Module is a fake module advertised by the daemon, modules with users
+// require authentication.
+type Module struct {
+	Name    string   `toml:"name"`
+	Comment string   `toml:"comment"`
+	Users   []string `toml:"users"`
+}
+
+type Config struct {
+	Version string `toml:"version"`
+	MOTD    string `toml:"motd"`
+
+	Modules []Module `toml:"module"`
+}
+
+type rsyncService struct {
+	Config
+
+	ch pushers.Channel
+}
+
+func (s *rsyncService) SetChannel(c pushers.Channel) {
+	s.ch = c
+}
+
+func (s *rsyncService) CanHandle(payload []byte) bool {
+	return strings.HasPrefix(string(payload), "@RSYNCD:")
+}
+
+func (s *rsyncService) module(name string) (*Module, bool) {
+	for i := range s.Modules {
+		if s.Modules[i].Name == name {
+			return &s.Modules[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// readToken reads a line, or a null terminated argument.
+func readToken(br *bufio.Reader) (string, error) {
+	token := []byte{}
+
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		if b == '\n' || b == 0 {
+			return strings.TrimSuffix(string(token), "\r"), nil
+		}
+
+		if len(token) >= maxLineLength {
+			return "", ErrLineTooLong
+		}
+
+		token = append(token, b)
+	}
+}
+
+func challenge() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func (s *rsyncService) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("rsync"),
+		connOptions,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+	}
+
+	send := func(t string, o ...event.Option) {
+		o = append([]event.Option{event.Type(t)}, o...)
+
+		s.ch.Send(event.New(
+			append(o, options...)...,
+		))
+	}
+
+	if _, err := fmt.Fprintf(conn, "@RSYNCD: %s\n", s.Version); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(conn)
+
+	greeting, err := readToken(br)
+	if err != nil {
+		return err
+	}
+
+	version := strings.TrimSpace(strings.TrimPrefix(greeting, "@RSYNCD:"))
+	options = append(options, event.Custom("rsync.client-version", version))
+
+	name, err := readToken(br)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if s.MOTD != "" {
+		if _, err := fmt.Fprintf(conn, "%s\n", s.MOTD); err != nil {
+			return err
+		}
+	}
+
+	if name == "" || name == "#list" {
+		send("module-list")
+
+		for _, m := range s.Modules {
+			if _, err := fmt.Fprintf(conn, "%-15s\t%s\n", m.Name, m.Comment); err != nil {
+				return err
+			}
+		}
+
+		_, err := fmt.Fprint(conn, "@RSYNCD: EXIT\n")
+		return err
+	}
+
+	options = append(options, event.Custom("rsync.module", name))
+
+	m, ok := s.module(name)
+	if !ok {
+		send("unknown-module")
+
+		_, err := fmt.Fprintf(conn, "@ERROR: Unknown module '%s'\n", name)
+		return err
+	}
+
+	if len(m.Users) > 0 {
+		c := challenge()
+
+		if _, err := fmt.Fprintf(conn, "@RSYNCD: AUTHREQD %s\n", c); err != nil {
+			return err
+		}
+
+		line, err := readToken(br)
+		if err != nil {
+			return err
+		}
+
+		username, response := line, ""
+		if parts := strings.SplitN(line, " ", 2); len(parts) == 2 {
+			username, response = parts[0], parts[1]
+		}
+
+		// the response is the md4 or md5 hash of the password and
+		// challenge, depending on the protocol version
+		send("authentication",
+			event.Custom("rsync.username", username),
+			event.Custom("rsync.challenge", c),
+			event.Custom("rsync.response", response),
+		)
+
+		_, err = fmt.Fprintf(conn, "@ERROR: auth failed on module %s\n", name)
+		return err
+	}
+
+	if _, err := fmt.Fprint(conn, "@RSYNCD: OK\n"); err != nil {
+		return err
+	}
+
+	// the arguments of the transfer, terminated by an empty argument
+	args := []string{}
+
+	for {
+		arg, err := readToken(br)
+		if err != nil {
+			return err
+		}
+
+		if arg == "" {
+			break
+		}
+
+		args = append(args, arg)
+	}
+
+	direction := "upload"
+	path := ""
+
+	for _, arg := range args {
+		if arg == "--sender" {
+			direction = "download"
+		} else if !strings.HasPrefix(arg, "-") && arg != "." {
+			path = arg
+		}
+	}
+
+	send("transfer",
+		event.Custom("rsync.args", args),
+		event.Custom("rsync.direction", direction),
+		event.Custom("rsync.path", path),
+	)
+
+	// the transfer isn't served
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rsync
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+type eventChannel chan event.Event
+
+func (c eventChannel) Send(e event.Event) {
+	c <- e
+}
+
+// session connects to the service, and returns the reader of the responses.
+func session(t *testing.T, events eventChannel) (net.Conn, *bufio.Reader) {
+	s := Rsync()
+	s.SetChannel(pushers.Channel(events))
+
+	server, client := net.Pipe()
+
+	go s.Handle(context.TODO(), server)
+
+	br := bufio.NewReader(client)
+	if line, _ := br.ReadString('\n'); line != "@RSYNCD: 31.0\n" {
+		t.Fatalf("Expected greeting, got %q", line)
+	}
+
+	return client, br
+}
+
+func TestRsyncList(t *testing.T) {
+	events := make(eventChannel, 10)
+
+	client, br := session(t, events)
+	defer client.Close()
+
+	if _, err := client.Write([]byte("@RSYNCD: 30.0\n\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if line == "@RSYNCD: EXIT\n" {
+			break
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "backup") {
+		t.Errorf("Expected module list, got %q", lines)
+	}
+
+	if e := <-events; e.Get("type") != "module-list" || e.Get("rsync.client-version") != "30.0" {
+		t.Errorf("Expected module-list event, got %s", e.Get("type"))
+	}
+}
+
+func TestRsyncAuth(t *testing.T) {
+	events := make(eventChannel, 10)
+
+	client, br := session(t, events)
+	defer client.Close()
+
+	if _, err := client.Write([]byte("@RSYNCD: 31.0\nbackup\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	line, _ := br.ReadString('\n')
+	if !strings.HasPrefix(line, "@RSYNCD: AUTHREQD ") {
+		t.Fatalf("Expected authentication request, got %q", line)
+	}
+
+	if _, err := client.Write([]byte("root Xh3Tq9mWCoMXA5lF0P1IUw\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if line, _ := br.ReadString('\n'); !strings.HasPrefix(line, "@ERROR: auth failed") {
+		t.Errorf("Expected auth failure, got %q", line)
+	}
+
+	e := <-events
+	if e.Get("type") != "authentication" || e.Get("rsync.username") != "root" || e.Get("rsync.response") != "Xh3Tq9mWCoMXA5lF0P1IUw" {
+		t.Errorf("Expected authentication event for root, got %s %s", e.Get("type"), e.Get("rsync.username"))
+	}
+
+	if e.Get("rsync.challenge") != strings.TrimSpace(strings.TrimPrefix(line, "@RSYNCD: AUTHREQD ")) {
+		t.Errorf("Expected challenge to be recorded")
+	}
+}
+
+func TestRsyncTransfer(t *testing.T) {
+	events := make(eventChannel, 10)
+
+	client, br := session(t, events)
+	defer client.Close()
+
+	if _, err := client.Write([]byte("@RSYNCD: 31.0\nwww\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if line, _ := br.ReadString('\n'); line != "@RSYNCD: OK\n" {
+		t.Fatalf("Expected ok, got %q", line)
+	}
+
+	if _, err := client.Write([]byte("--server\x00--sender\x00-vlogDtpre.iLsfxC\x00.\x00www/\x00\x00")); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-events
+	if e.Get("type") != "transfer" || e.Get("rsync.direction") != "download" || e.Get("rsync.path") != "www/" {
+		t.Errorf("Expected download of www/, got %s %s %s", e.Get("type"), e.Get("rsync.direction"), e.Get("rsync.path"))
+	}
+}
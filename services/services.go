@@ -14,6 +14,7 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"net"
 
@@ -95,6 +96,20 @@ func WithConfig(c toml.Primitive, decoder TomlDecoder) ServicerFunc {
 	}
 }
 
+// WithDefaults sets the configuration values of the service, the values are
+// applied like a configuration section. Later options take precedence.
+func WithDefaults(values map[string]interface{}) ServicerFunc {
+	return func(s Servicer) error {
+		buf := &bytes.Buffer{}
+		if err := toml.NewEncoder(buf).Encode(values); err != nil {
+			return err
+		}
+
+		_, err := toml.Decode(buf.String(), s)
+		return err
+	}
+}
+
 var (
 	SensorLow = event.Sensor("services")
 
@@ -0,0 +1,47 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package services
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestWithDefaults(t *testing.T) {
+	c := struct {
+		Service toml.Primitive `toml:"service"`
+	}{}
+
+	md, err := toml.Decode("[service]\nserver = \"nginx\"\n", &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := HTTP(
+		WithDefaults(map[string]interface{}{
+			"server": "Microsoft-IIS/10.0",
+			"ntlm":   true,
+		}),
+		WithConfig(c.Service, &md),
+	).(*httpService)
+
+	if s.Server != "nginx" {
+		t.Errorf("Expected configuration to take precedence, got %s", s.Server)
+	}
+
+	if !s.NTLM {
+		t.Error("Expected ntlm to be enabled by the defaults")
+	}
+}
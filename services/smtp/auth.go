@@ -0,0 +1,227 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package smtp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// Supported authentication mechanisms.
+const (
+	authPlain   = "PLAIN"
+	authLogin   = "LOGIN"
+	authCramMD5 = "CRAM-MD5"
+)
+
+var defaultAuthMechanisms = []string{authPlain, authLogin, authCramMD5}
+
+// Auth contains an authentication exchange, every attempt is rejected.
+type Auth struct {
+	Mechanism string
+
+	// Identity is the authorization identity of PLAIN
+	Identity string
+	Username string
+	Password string
+
+	// Challenge and Digest are the CRAM-MD5 challenge and response, the
+	// password can be recovered by cracking the digest.
+	Challenge string
+	Digest    string
+
+	// Exchange contains the lines sent by the client
+	Exchange []string
+}
+
+// Options returns the event options of the exchange.
+func (a Auth) Options() []event.Option {
+	options := []event.Option{
+		event.Custom("smtp.mechanism", a.Mechanism),
+		event.Custom("smtp.username", a.Username),
+		event.Custom("smtp.exchange", a.Exchange),
+	}
+
+	if a.Mechanism == authCramMD5 {
+		return append(options,
+			event.Custom("smtp.cram-md5.challenge", a.Challenge),
+			event.Custom("smtp.cram-md5.digest", a.Digest),
+		)
+	}
+
+	if a.Identity != "" {
+		options = append(options, event.Custom("smtp.identity", a.Identity))
+	}
+
+	return append(options, event.Custom("smtp.password", a.Password))
+}
+
+func (c *conn) supportsAuth(mechanism string) bool {
+	for _, m := range c.server.AuthMechanisms {
+		if strings.EqualFold(m, mechanism) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readResponse sends the base64 encoded challenge and returns the decoded
+// response of the client.
+func (c *conn) readResponse(a *Auth, challenge string) (string, error) {
+	c.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+
+	line, err := c.ReadLine()
+	if err != nil {
+		return "", err
+	}
+
+	a.Exchange = append(a.Exchange, line)
+
+	if line == "*" {
+		return "", errAuthCancelled
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		return "", errAuthInvalid
+	}
+
+	return string(data), nil
+}
+
+type authError string
+
+func (e authError) Error() string { return string(e) }
+
+const (
+	errAuthCancelled   = authError("authentication cancelled")
+	errAuthInvalid     = authError("invalid base64 data")
+	errAuthUnsupported = authError("unsupported mechanism")
+)
+
+// challenge returns a CRAM-MD5 challenge in the form of rfc 2195.
+func (c *conn) challenge() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<31))
+	if err != nil {
+		n = big.NewInt(0)
+	}
+
+	return fmt.Sprintf("<%d.%d@%s>", n, time.Now().Unix(), c.server.Host)
+}
+
+// authenticate reads the exchange of the AUTH command.
+func (c *conn) authenticate(line string) (*Auth, error) {
+	args := strings.Fields(line)
+	if len(args) < 2 {
+		return nil, errAuthInvalid
+	}
+
+	a := &Auth{
+		Mechanism: strings.ToUpper(args[1]),
+		Exchange:  []string{line},
+	}
+
+	if !c.supportsAuth(a.Mechanism) {
+		return a, errAuthUnsupported
+	}
+
+	// the initial response may be part of the command
+	initial := ""
+	if len(args) > 2 {
+		data, err := base64.StdEncoding.DecodeString(args[2])
+		if err != nil {
+			return a, errAuthInvalid
+		}
+
+		initial = string(data)
+	}
+
+	var err error
+
+	switch a.Mechanism {
+	case authPlain:
+		if len(args) < 3 {
+			if initial, err = c.readResponse(a, ""); err != nil {
+				return a, err
+			}
+		}
+
+		parts := strings.SplitN(initial, "\x00", 3)
+		if len(parts) != 3 {
+			return a, errAuthInvalid
+		}
+
+		a.Identity, a.Username, a.Password = parts[0], parts[1], parts[2]
+	case authLogin:
+		a.Username = initial
+
+		if len(args) < 3 {
+			if a.Username, err = c.readResponse(a, "Username:"); err != nil {
+				return a, err
+			}
+		}
+
+		if a.Password, err = c.readResponse(a, "Password:"); err != nil {
+			return a, err
+		}
+	case authCramMD5:
+		a.Challenge = c.challenge()
+
+		response, err := c.readResponse(a, a.Challenge)
+		if err != nil {
+			return a, err
+		}
+
+		idx := strings.LastIndex(response, " ")
+		if idx < 0 {
+			return a, errAuthInvalid
+		}
+
+		a.Username, a.Digest = response[:idx], response[idx+1:]
+	}
+
+	return a, nil
+}
+
+func authState(line string) stateFn {
+	return func(c *conn) stateFn {
+		a, err := c.authenticate(line)
+
+		if a != nil && err != errAuthUnsupported {
+			c.emit(event.Type("auth"), event.NewWith(a.Options()...))
+		}
+
+		switch {
+		case err == errAuthCancelled:
+			c.PrintfLine("501 5.7.0 Authentication cancelled")
+		case err == errAuthInvalid:
+			c.PrintfLine("501 5.5.2 Cannot decode response")
+		case err == errAuthUnsupported:
+			c.PrintfLine("504 5.5.4 Unrecognized authentication type")
+		case err != nil:
+			return errorState("[auth] %s", err.Error())
+		default:
+			c.PrintfLine("535 5.7.8 Authentication credentials invalid")
+		}
+
+		return loopState
+	}
+}
@@ -15,7 +15,6 @@ package smtp
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -23,10 +22,15 @@ import (
 	"net/textproto"
 	"strconv"
 	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
 )
 
 const (
 	loopTreshold = 100
+
+	// maxRecipients is the maximum number of recipients of a message
+	maxRecipients = 100
 	cmdSupported = "HELO EHLO STARTTLS RCPT DATA RSET MAIL QUIT HELP AUTH BDAT NOOP QUIT"
 )
 
@@ -36,8 +40,11 @@ type conn struct {
 	domain string
 	msg    *Message
 	server *Server
-	rcv    chan string
 	i      int
+
+	// emit sends an event of the connection
+	emit func(...event.Option)
+	tls  bool
 }
 
 func (c *conn) newMessage() *Message {
@@ -55,9 +62,6 @@ func (c *conn) RemoteAddr() net.Addr {
 type stateFn func(c *conn) stateFn
 
 func (c *conn) PrintfLine(format string, args ...interface{}) error {
-	fmt.Printf("< ")
-	fmt.Printf(format, args...)
-	fmt.Println("")
 	return c.Text.PrintfLine(format, args...)
 }
 
@@ -67,15 +71,72 @@ func (c *conn) ReadLine() (string, error) {
 		return s, err
 	}
 
-	fmt.Printf("> ")
-	fmt.Println(s)
-
-	//send line to log channel
-	c.rcv <- s
+	c.emit(
+		event.Type("input"),
+		event.Custom("smtp.line", s),
+	)
 
 	return s, nil
 }
 
+// parsePath returns the address of the path of the MAIL FROM and RCPT TO
+// commands, eg. <user@example.com> SIZE=1000.
+func parsePath(line string, cmd string) string {
+	arg := strings.TrimSpace(line[len(cmd):])
+	arg = strings.TrimSpace(strings.TrimPrefix(arg, ":"))
+
+	if strings.HasPrefix(arg, "<") {
+		if i := strings.IndexByte(arg, '>'); i > 0 {
+			return arg[1:i]
+		}
+	}
+
+	if i := strings.IndexByte(arg, ' '); i >= 0 {
+		arg = arg[:i]
+	}
+
+	return strings.Trim(arg, "<>")
+}
+
+// local returns true if the server accepts mail for the address, mail for
+// other addresses is relayed.
+func (s *Server) local(address string) bool {
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		// local mailboxes, eg. postmaster
+		return true
+	}
+
+	domain := strings.ToLower(address[i+1:])
+	if strings.EqualFold(domain, s.Host) {
+		return true
+	}
+
+	for _, d := range s.Domains {
+		if strings.EqualFold(domain, d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deliver pretends to queue the message for delivery.
+func (c *conn) deliver() {
+	id := strings.ToUpper(c.msg.Hash)
+	if len(id) > 10 {
+		id = id[:10]
+	}
+
+	c.emit(c.msg.Options()...)
+
+	serverHandler{c.server}.Serve(*c.msg)
+
+	c.PrintfLine("250 2.0.0 Ok: queued as %s", id)
+
+	c.msg = c.newMessage()
+}
+
 func startState(c *conn) stateFn {
 	c.PrintfLine("220 %s", c.server.Banner)
 	return helloState
@@ -119,8 +180,33 @@ func mailFromState(c *conn) stateFn {
 		c.msg = c.newMessage()
 		return loopState
 	} else if isCommand(line, "RCPT TO") {
+		rcpt := parsePath(line, "RCPT TO")
 
-		c.PrintfLine("250 Ok")
+		if len(c.msg.To) >= maxRecipients {
+			c.PrintfLine("452 4.5.3 Error: too many recipients")
+			return mailFromState
+		}
+
+		if c.server.local(rcpt) {
+		} else if !c.server.Relay {
+			c.emit(
+				event.Type("relay-denied"),
+				event.Custom("smtp.mail-from", c.msg.From),
+				event.Custom("smtp.rcpt", rcpt),
+			)
+
+			c.PrintfLine("554 5.7.1 <%s>: Relay access denied", rcpt)
+			return mailFromState
+		} else {
+			c.msg.Relay = true
+		}
+
+		c.msg.To = append(c.msg.To, rcpt)
+
+		c.PrintfLine("250 2.1.5 Ok")
+		return mailFromState
+	} else if (isCommand(line, "BDAT") || isCommand(line, "DATA")) && len(c.msg.To) == 0 {
+		c.PrintfLine("554 5.5.1 Error: no valid recipients")
 		return mailFromState
 	} else if isCommand(line, "BDAT") {
 		parts := strings.Split(line, " ")
@@ -140,31 +226,20 @@ func mailFromState(c *conn) stateFn {
 			return mailFromState
 		}
 
-		hasher := sha1.New()
-		if err := c.msg.Read(io.TeeReader(c.msg.Buffer, hasher)); err != nil {
+		if err := c.msg.Read(c.msg.Buffer); err != nil {
 			return errorState("[bdat]: error %s", err)
 		}
 
-		c.PrintfLine("250 Ok : queued as +%x", hasher.Sum(nil))
-
-		serverHandler{c.server}.Serve(*c.msg)
-
-		c.msg = c.newMessage()
+		c.deliver()
 		return loopState
 	} else if isCommand(line, "DATA") {
 		c.PrintfLine("354 Enter message, ending with \".\" on a line by itself")
 
-		hasher := sha1.New()
-		err := c.msg.Read(io.TeeReader(c.Text.DotReader(), hasher))
-		if err != nil {
+		if err := c.msg.Read(c.Text.DotReader()); err != nil {
 			return errorState("[data]: error %s", err)
 		}
 
-		c.PrintfLine("250 Ok : queued as +%x", hasher.Sum(nil))
-
-		serverHandler{c.server}.Serve(*c.msg)
-
-		c.msg = c.newMessage()
+		c.deliver()
 		return loopState
 	} else if isCommand(line, "HELP") {
 		c.PrintfLine("214 Following SMTP commands are supported:")
@@ -192,17 +267,18 @@ func loopState(c *conn) stateFn {
 	}
 
 	if isCommand(line, "MAIL FROM") {
-		//c.msg.From, _ = mail.ParseAddress(line[10:])
-		c.PrintfLine("250 Ok")
+		c.msg.From = parsePath(line, "MAIL FROM")
+
+		c.PrintfLine("250 2.1.0 Ok")
 		return mailFromState
 	} else if isCommand(line, "STARTTLS") {
-		c.PrintfLine("220 Ready to start TLS")
-
-		if c.server.tlsConfig == nil {
+		if c.server.tlsConfig == nil || c.tls {
 			c.PrintfLine("500 5.3.3. Unrecognized Command.")
-			return helloState
+			return loopState
 		}
 
+		c.PrintfLine("220 Ready to start TLS")
+
 		tlsConn := tls.Server(c.rwc, c.server.tlsConfig)
 
 		if err := tlsConn.Handshake(); err != nil {
@@ -211,7 +287,17 @@ func loopState(c *conn) stateFn {
 		}
 
 		c.Text = textproto.NewConn(tlsConn)
+		c.tls = true
+
+		c.emit(
+			event.Type("starttls"),
+			event.Custom("smtp.tls-version", fmt.Sprintf("%#04x", tlsConn.ConnectionState().Version)),
+			event.Custom("smtp.tls-server-name", tlsConn.ConnectionState().ServerName),
+		)
+
 		return helloState
+	} else if isCommand(line, "AUTH") {
+		return authState(line)
 	} else if isCommand(line, "RSET") {
 		c.msg = c.newMessage()
 		c.PrintfLine("250 Ok")
@@ -250,6 +336,19 @@ func helloState(c *conn) stateFn {
 		return errorState("[helloState] ReadLine error. %s", err.Error())
 	}
 
+	if isCommand(line, "HELO") || isCommand(line, "EHLO") {
+		fp := fingerprint(line)
+
+		c.emit(
+			event.Type("hello"),
+			event.Custom("smtp.hello.verb", fp.Verb),
+			event.Custom("smtp.hello.domain", fp.Domain),
+			event.Custom("smtp.hello.kind", fp.Kind),
+			event.Custom("smtp.client", fp.Client),
+			event.Custom("smtp.fingerprint", fp.Hash),
+		)
+	}
+
 	if isCommand(line, "HELO") {
 		domain, err := parseHelloArgument(line)
 		if err != nil {
@@ -272,10 +371,14 @@ func helloState(c *conn) stateFn {
 		c.PrintfLine("250-SIZE 35882577")
 		c.PrintfLine("250-8BITMIME")
 
-		if c.server.tlsConfig != nil {
+		if c.server.tlsConfig != nil && !c.tls {
 			c.PrintfLine("250-STARTTLS")
 		}
 
+		if len(c.server.AuthMechanisms) > 0 {
+			c.PrintfLine("250-AUTH %s", strings.Join(c.server.AuthMechanisms, " "))
+		}
+
 		c.PrintfLine("250-HELP")
 		c.PrintfLine("250-ENHANCEDSTATUSCODES")
 		c.PrintfLine("250-PIPELINING")
@@ -0,0 +1,114 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package smtp
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Fingerprint identifies the client software by the way it greets.
+type Fingerprint struct {
+	// Verb is the greeting command as sent, eg. ehlo or EHLO
+	Verb string
+
+	// Domain is the argument of the greeting
+	Domain string
+
+	// Kind is the kind of the domain: literal, fqdn, hostname or empty
+	Kind string
+
+	// Client is the client software, if known
+	Client string
+
+	// Hash identifies the greeting independent of the domain
+	Hash string
+}
+
+// clientSignatures map the verb and domain of a greeting to client software,
+// the first signature that matches wins.
+var clientSignatures = []struct {
+	Client string
+	Match  func(verb, domain string) bool
+}{
+	{"nmap", func(verb, domain string) bool {
+		return domain == "nmap.scanme.org"
+	}},
+	{"python-smtplib", func(verb, domain string) bool {
+		// smtplib is the only common client greeting in lower case
+		return verb == "ehlo" || verb == "helo"
+	}},
+	{"go-net-smtp", func(verb, domain string) bool {
+		return verb == "EHLO" && domain == "localhost"
+	}},
+	{"mass-mailer", func(verb, domain string) bool {
+		return strings.EqualFold(domain, "user") || strings.EqualFold(domain, "localhost.localdomain")
+	}},
+	{"thunderbird", func(verb, domain string) bool {
+		return verb == "EHLO" && domainKind(domain) == "literal"
+	}},
+	{"outlook", func(verb, domain string) bool {
+		// outlook greets with the upper case netbios name of the computer
+		return verb == "EHLO" && domainKind(domain) == "hostname" && domain == strings.ToUpper(domain)
+	}},
+}
+
+func domainKind(domain string) string {
+	switch {
+	case domain == "":
+		return "empty"
+	case strings.HasPrefix(domain, "[") && strings.HasSuffix(domain, "]"):
+		return "literal"
+	case net.ParseIP(domain) != nil:
+		return "ip"
+	case strings.Contains(domain, "."):
+		return "fqdn"
+	default:
+		return "hostname"
+	}
+}
+
+// fingerprint returns the fingerprint of the greeting line.
+func fingerprint(line string) Fingerprint {
+	verb, domain := line, ""
+	if idx := strings.IndexRune(line, ' '); idx >= 0 {
+		verb, domain = line[:idx], strings.TrimSpace(line[idx+1:])
+	}
+
+	fp := Fingerprint{
+		Verb:   verb,
+		Domain: domain,
+		Kind:   domainKind(domain),
+		Client: "unknown",
+	}
+
+	for _, s := range clientSignatures {
+		if s.Match(verb, domain) {
+			fp.Client = s.Client
+			break
+		}
+	}
+
+	// the spacing of the line is part of the fingerprint, some clients add
+	// trailing whitespace
+	trailing := len(line) - len(strings.TrimRight(line, " \t"))
+
+	sum := md5.Sum([]byte(strings.Join([]string{verb, fp.Kind, strconv.Itoa(trailing)}, ",")))
+	fp.Hash = hex.EncodeToString(sum[:])
+
+	return fp
+}
@@ -15,11 +15,23 @@ package smtp
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net/mail"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
 )
 
+// maxAttachments is the maximum number of attachments hashed per message.
+const maxAttachments = 20
+
 // Message smtp message
 type Message struct {
 	Header mail.Header
@@ -27,6 +39,24 @@ type Message struct {
 	Buffer *bytes.Buffer
 
 	Body *bytes.Buffer
+
+	// From and To are the sender and recipients of the envelope
+	From string
+	To   []string
+
+	// Relay is set when any of the recipients is not local
+	Relay bool
+
+	// Hash is the sha256 of the data of the message
+	Hash string
+}
+
+// Attachment is an attachment of a message.
+type Attachment struct {
+	Name        string
+	ContentType string
+	Size        int
+	SHA256      string
 }
 
 func (m *Message) Read(r io.Reader) error {
@@ -35,6 +65,9 @@ func (m *Message) Read(r io.Reader) error {
 		return err
 	}
 
+	hash := sha256.Sum256(buff)
+	m.Hash = hex.EncodeToString(hash[:])
+
 	msg, err := mail.ReadMessage(bytes.NewReader(buff))
 	if err != nil {
 		m.Body = bytes.NewBuffer(buff)
@@ -51,3 +84,102 @@ func (m *Message) Read(r io.Reader) error {
 	m.Body = bytes.NewBuffer(buff)
 	return err
 }
+
+// decode decodes the content transfer encoding of the part.
+func decode(r io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+
+	return r
+}
+
+// attachments walks the (nested) multipart body of the message and hashes
+// the parts with a filename or attachment disposition.
+func attachments(contentType string, body io.Reader, found []Attachment) []Attachment {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return found
+	}
+
+	mr := multipart.NewReader(body, params["boundary"])
+
+	for len(found) < maxAttachments {
+		part, err := mr.NextPart()
+		if err != nil {
+			return found
+		}
+
+		partType := part.Header.Get("Content-Type")
+		if strings.HasPrefix(strings.ToLower(partType), "multipart/") {
+			found = attachments(partType, part, found)
+			continue
+		}
+
+		disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if part.FileName() == "" && disposition != "attachment" {
+			continue
+		}
+
+		hash := sha256.New()
+
+		n, err := io.Copy(hash, decode(part, part.Header.Get("Content-Transfer-Encoding")))
+		if err != nil {
+			log.Debugf("Error decoding attachment %s: %s", part.FileName(), err.Error())
+		}
+
+		found = append(found, Attachment{
+			Name:        part.FileName(),
+			ContentType: partType,
+			Size:        int(n),
+			SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+
+	return found
+}
+
+// Attachments returns the attachments of the message.
+func (m *Message) Attachments() []Attachment {
+	if m.Header == nil {
+		return nil
+	}
+
+	return attachments(m.Header.Get("Content-Type"), bytes.NewReader(m.Body.Bytes()), nil)
+}
+
+// Options returns the options of the event of the message.
+func (m *Message) Options() []event.Option {
+	options := []event.Option{
+		event.Type("email"),
+		event.Custom("smtp.mail-from", m.From),
+		event.Custom("smtp.rcpt-to", m.To),
+		event.Custom("smtp.relay", m.Relay),
+		event.Custom("smtp.body", m.Body.String()),
+		event.Custom("smtp.message.sha256", m.Hash),
+	}
+
+	for key, values := range m.Header {
+		options = append(options, event.Custom("smtp."+key, strings.Join(values, ",")))
+	}
+
+	if found := m.Attachments(); len(found) > 0 {
+		names, types, hashes := []string{}, []string{}, []string{}
+		for _, a := range found {
+			names = append(names, a.Name)
+			types = append(types, a.ContentType)
+			hashes = append(hashes, a.SHA256)
+		}
+
+		options = append(options,
+			event.Custom("smtp.attachments.name", names),
+			event.Custom("smtp.attachments.type", types),
+			event.Custom("smtp.attachments.sha256", hashes),
+		)
+	}
+
+	return options
+}
@@ -17,6 +17,8 @@ import (
 	"crypto/tls"
 	"net"
 	"sync"
+
+	"github.com/honeytrap/honeytrap/event"
 )
 
 type Handler interface {
@@ -58,17 +60,29 @@ func (mux *ServeMux) Serve(msg Message) error {
 type Server struct {
 	Banner string
 
+	// Host is the hostname used in the CRAM-MD5 challenges
+	Host string
+
+	// AuthMechanisms are the advertised authentication mechanisms
+	AuthMechanisms []string
+
+	// Domains are the domains mail is accepted for, besides the host
+	Domains []string
+
+	// Relay accepts mail for other domains, as an open relay
+	Relay bool
+
 	Handler Handler
 
 	tlsConfig *tls.Config
 }
 
-func (s *Server) newConn(rwc net.Conn, recv chan string) *conn {
+func (s *Server) newConn(rwc net.Conn) *conn {
 	c := &conn{
 		server: s,
 		rwc:    rwc,
-		rcv:    recv,
 		i:      0,
+		emit:   func(...event.Option) {},
 	}
 
 	c.msg = c.newMessage()
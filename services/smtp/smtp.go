@@ -15,9 +15,9 @@ package smtp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net"
-	"strings"
 	"time"
 
 	"github.com/honeytrap/honeytrap/event"
@@ -44,10 +44,11 @@ func SMTP(options ...services.ServicerFunc) services.Servicer {
 				Host:           "remailer.ru",
 				Name:           "SMTP",
 			},
+			AuthMechanisms: defaultAuthMechanisms,
+			Relay:          true,
 			srv: &Server{
 				tlsConfig: nil,
 			},
-			receiveChan: make(chan Message),
 		},
 	}
 
@@ -56,7 +57,14 @@ func SMTP(options ...services.ServicerFunc) services.Servicer {
 		o(s)
 	}
 
-	if store, err := getStorage(); err != nil {
+	if s.Certificate != "" {
+		cert, err := tls.LoadX509KeyPair(s.Certificate, s.Key)
+		if err != nil {
+			log.Errorf("Could not load certificate: %s", err.Error())
+		} else {
+			s.srv.tlsConf(&cert)
+		}
+	} else if store, err := getStorage(); err != nil {
 		log.Errorf("Could not initialize storage: %s", err.Error())
 	} else {
 
@@ -76,13 +84,10 @@ func SMTP(options ...services.ServicerFunc) services.Servicer {
 	}
 
 	s.srv.Banner = banner.String()
-
-	handler := HandleFunc(func(msg Message) error {
-		s.receiveChan <- msg
-		return nil
-	})
-
-	s.srv.Handler = handler
+	s.srv.Host = s.Host
+	s.srv.AuthMechanisms = s.AuthMechanisms
+	s.srv.Domains = s.Domains
+	s.srv.Relay = s.Relay
 
 	return s
 }
@@ -98,9 +103,21 @@ type bannerData struct {
 type Config struct {
 	bannerData
 
-	srv *Server
+	// Certificate and Key are the files of the certificate offered with
+	// STARTTLS, a self signed certificate is used when not set.
+	Certificate string `toml:"certificate"`
+	Key         string `toml:"key"`
+
+	AuthMechanisms []string `toml:"auth-mechanisms"`
 
-	receiveChan chan Message
+	// Domains are the domains mail is accepted for, besides the host
+	Domains []string `toml:"domains"`
+
+	// Relay pretends to be an open relay, mail for other domains is
+	// accepted and reported as queued. It is refused otherwise.
+	Relay bool `toml:"relay"`
+
+	srv *Server
 }
 
 type Service struct {
@@ -120,52 +137,19 @@ func (s *Service) Handle(ctx context.Context, conn net.Conn) error {
 		return errors.New("Can't set ReadDeadline on connection")
 	}
 
-	rcvLine := make(chan string)
-
-	// Wait for a message and send it into the eventbus
-	go func() {
-		for {
-			select {
-			case message := <-s.receiveChan:
-				header := []event.Option{}
-
-				for key, values := range message.Header {
-					var vals strings.Builder
-
-					for _, s := range values {
-						if vals.Len() > 0 {
-							_, _ = vals.WriteRune(',')
-						}
-						_, _ = vals.WriteString(s)
-					}
-
-					header = append(header, event.Custom("smtp."+key, vals.String()))
-				}
-
-				s.ch.Send(event.New(
-					services.EventOptions,
-					event.Category("smtp"),
-					event.Type("email"),
-					event.SourceAddr(conn.RemoteAddr()),
-					event.DestinationAddr(conn.LocalAddr()),
-					event.Custom("smtp.body", message.Body.String()),
-					event.NewWith(header...),
-				))
-			case line := <-rcvLine:
-				s.ch.Send(event.New(
-					services.EventOptions,
-					event.Category("smtp"),
-					event.Type("input"),
-					event.SourceAddr(conn.RemoteAddr()),
-					event.DestinationAddr(conn.LocalAddr()),
-					event.Custom("smtp.line", line),
-				))
-			}
-		}
-	}()
-
 	//Create new smtp server connection
-	c := s.srv.newConn(conn, rcvLine)
+	c := s.srv.newConn(conn)
+	c.emit = func(options ...event.Option) {
+		s.ch.Send(event.New(
+			services.EventOptions,
+			event.Category("smtp"),
+			event.SourceAddr(conn.RemoteAddr()),
+			event.DestinationAddr(conn.LocalAddr()),
+			event.Custom("smtp.tls", c.tls),
+			event.NewWith(options...),
+		))
+	}
+
 	// Start server loop
 	c.serve()
 	return nil
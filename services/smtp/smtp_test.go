@@ -15,12 +15,16 @@ package smtp
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/smtp"
 	"os"
+	"sync"
 	"testing"
 
+	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
 	"github.com/honeytrap/honeytrap/storage"
 )
 
@@ -32,8 +36,18 @@ const (
 )
 
 func TestMain(m *testing.M) {
-	storage.SetDataDir("")
-	os.Exit(m.Run())
+	dir, err := ioutil.TempDir("", "smtp")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	storage.SetDataDir(dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
 }
 
 func TestSMTP(t *testing.T) {
@@ -110,3 +124,252 @@ func TestSMTP(t *testing.T) {
 	// Check if data is received.
 	// with file channel?
 }
+
+type collector struct {
+	m      sync.Mutex
+	events []event.Event
+}
+
+func (c *collector) Send(e event.Event) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.events = append(c.events, e)
+}
+
+func (c *collector) find(typ string) []event.Event {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	found := []event.Event{}
+	for _, e := range c.events {
+		if e.Get("type") == typ {
+			found = append(found, e)
+		}
+	}
+
+	return found
+}
+
+func TestAuth(t *testing.T) {
+	tests := []struct {
+		auth     smtp.Auth
+		username string
+		password string
+	}{
+		{smtp.PlainAuth("", "admin", "secret", hostname), "admin", "secret"},
+		{smtp.CRAMMD5Auth("admin", "secret"), "admin", ""},
+	}
+
+	for _, test := range tests {
+		client, server := net.Pipe()
+
+		s := SMTP().(*Service)
+
+		c := &collector{}
+		s.SetChannel(c)
+
+		go s.Handle(nil, server)
+
+		smtpClient, err := smtp.NewClient(client, hostname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := smtpClient.StartTLS(s.srv.tlsConfig); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := smtpClient.Auth(test.auth); err == nil {
+			t.Errorf("Expected authentication to fail")
+		}
+
+		client.Close()
+
+		events := c.find("auth")
+		if len(events) != 1 {
+			t.Fatalf("Expected 1 auth event, got %d", len(events))
+		}
+
+		e := events[0]
+		if e.Get("smtp.username") != test.username {
+			t.Errorf("Expected username %s, got %s", test.username, e.Get("smtp.username"))
+		}
+
+		if e.Get("smtp.password") != test.password {
+			t.Errorf("Expected password %s, got %s", test.password, e.Get("smtp.password"))
+		}
+
+		tls := false
+		e.Range(func(k, v interface{}) bool {
+			if k == "smtp.tls" {
+				tls, _ = v.(bool)
+			}
+
+			return true
+		})
+
+		if !tls {
+			t.Errorf("Expected authentication over tls")
+		}
+
+		if len(c.find("hello")) != 2 {
+			t.Errorf("Expected a hello event before and after starttls")
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		line   string
+		client string
+		kind   string
+	}{
+		{"ehlo mail.example.com", "python-smtplib", "fqdn"},
+		{"EHLO localhost", "go-net-smtp", "hostname"},
+		{"EHLO [192.168.1.10]", "thunderbird", "literal"},
+		{"EHLO DESKTOP-1A2B3C", "outlook", "hostname"},
+		{"HELO User", "mass-mailer", "hostname"},
+		{"EHLO nmap.scanme.org", "nmap", "fqdn"},
+		{"EHLO mx.example.org", "unknown", "fqdn"},
+	}
+
+	for _, test := range tests {
+		fp := fingerprint(test.line)
+		if fp.Client != test.client {
+			t.Errorf("%s: expected client %s, got %s", test.line, test.client, fp.Client)
+		}
+
+		if fp.Kind != test.kind {
+			t.Errorf("%s: expected kind %s, got %s", test.line, test.kind, fp.Kind)
+		}
+	}
+
+	if fingerprint("EHLO a.example.com").Hash != fingerprint("EHLO b.example.org").Hash {
+		t.Errorf("Expected the hash to be independent of the domain")
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		line    string
+		cmd     string
+		address string
+	}{
+		{"MAIL FROM:<sender@example.com>", "MAIL FROM", "sender@example.com"},
+		{"MAIL FROM:<sender@example.com> SIZE=1000 BODY=8BITMIME", "MAIL FROM", "sender@example.com"},
+		{"mail from: sender@example.com", "MAIL FROM", "sender@example.com"},
+		{"MAIL FROM:<>", "MAIL FROM", ""},
+		{"RCPT TO:<postmaster>", "RCPT TO", "postmaster"},
+	}
+
+	for _, test := range tests {
+		if address := parsePath(test.line, test.cmd); address != test.address {
+			t.Errorf("%s: expected %q, got %q", test.line, test.address, address)
+		}
+	}
+}
+
+const multipartBody = "Subject: invoice\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"See the attached invoice.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.exe\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"TVqQAAMAAAAEAAAA\r\n" +
+	"--b1--\r\n"
+
+func TestRelay(t *testing.T) {
+	for _, relay := range []bool{true, false} {
+		client, server := net.Pipe()
+
+		s := SMTP(func(s services.Servicer) error {
+			s.(*Service).Relay = relay
+			return nil
+		}).(*Service)
+
+		c := &collector{}
+		s.SetChannel(c)
+
+		go s.Handle(nil, server)
+
+		smtpClient, err := smtp.NewClient(client, hostname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := smtpClient.Mail(sender); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := smtpClient.Rcpt("postmaster"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := smtpClient.Rcpt(recipient); (err == nil) != relay {
+			t.Errorf("Expected relaying to be accepted: %t, got %v", relay, err)
+		}
+
+		wc, err := smtpClient.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Fprint(wc, multipartBody)
+
+		if err := wc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		smtpClient.Quit()
+		client.Close()
+
+		if denied := c.find("relay-denied"); (len(denied) == 0) == !relay {
+			t.Errorf("Expected relay-denied event: %t, got %d", !relay, len(denied))
+		}
+
+		emails := c.find("email")
+		if len(emails) != 1 {
+			t.Fatalf("Expected 1 email event, got %d", len(emails))
+		}
+
+		e := emails[0]
+		if e.Get("smtp.mail-from") != sender {
+			t.Errorf("Expected sender %s, got %s", sender, e.Get("smtp.mail-from"))
+		}
+
+		values := map[string]interface{}{}
+		e.Range(func(k, v interface{}) bool {
+			values[k.(string)] = v
+			return true
+		})
+
+		to := []string{"postmaster"}
+		if relay {
+			to = append(to, recipient)
+		}
+
+		if fmt.Sprint(values["smtp.rcpt-to"]) != fmt.Sprint(to) {
+			t.Errorf("Expected recipients %v, got %v", to, values["smtp.rcpt-to"])
+		}
+
+		if values["smtp.relay"] != relay {
+			t.Errorf("Expected relay %t, got %v", relay, values["smtp.relay"])
+		}
+
+		names, _ := values["smtp.attachments.name"].([]string)
+		hashes, _ := values["smtp.attachments.sha256"].([]string)
+
+		// sha256 of the decoded attachment
+		if len(names) != 1 || names[0] != "invoice.exe" || len(hashes) != 1 || hashes[0] != "d930a77b4e5a6df96f8be687f754be90fa6da7939406d214814a80393847ce1b" {
+			t.Errorf("Unexpected attachments %v %v", names, hashes)
+		}
+	}
+}
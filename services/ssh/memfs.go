@@ -0,0 +1,278 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ssh
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/content"
+)
+
+var (
+	errNotExist = errors.New("No such file")
+	errExist    = errors.New("File exists")
+	errNotDir   = errors.New("Not a directory")
+	errIsDir    = errors.New("Is a directory")
+	errNotEmpty = errors.New("Directory not empty")
+	errNoSpace  = errors.New("No space left on device")
+)
+
+// memFile is a file or directory of the memory filesystem.
+type memFile struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+	data    []byte
+}
+
+func (f *memFile) IsDir() bool {
+	return f.mode.IsDir()
+}
+
+// memFS is the filesystem presented to the sftp clients of a session, the
+// uploaded files are kept in memory.
+type memFS struct {
+	home  string
+	files map[string]*memFile
+
+	// size is the total size of the files, limited to max
+	size, max int
+}
+
+// fsDate is the modification time of the files of the filesystem layout.
+var fsDate = time.Date(2017, 11, 19, 19, 40, 44, 0, time.UTC)
+
+// newMemFS returns a filesystem with the layout of an ubuntu server.
+func newMemFS(max int) *memFS {
+	fs := &memFS{
+		home:  "/root",
+		files: map[string]*memFile{},
+		max:   max,
+	}
+
+	for _, dir := range []string{"/", "/bin", "/boot", "/dev", "/dev/shm", "/etc", "/home", "/lib", "/opt", "/proc", "/root", "/root/.ssh", "/run", "/sbin", "/srv", "/tmp", "/usr", "/usr/bin", "/usr/local", "/usr/sbin", "/var", "/var/log", "/var/tmp"} {
+		fs.files[dir] = &memFile{name: path.Base(dir), mode: os.ModeDir | 0755, modTime: fsDate}
+	}
+
+	fs.files["/tmp"].mode = os.ModeDir | os.ModeSticky | 0777
+
+	for name, data := range map[string]string{
+		"/etc/hostname": "host\n",
+		"/etc/issue":    "Ubuntu 16.04.1 LTS \\n \\l\n",
+		"/etc/passwd":   "root:x:0:0:root:/root:/bin/bash\ndaemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin\nwww-data:x:33:33:www-data:/var/www:/usr/sbin/nologin\n",
+		"/root/.bashrc": "# ~/.bashrc: executed by bash(1) for non-login shells.\n",
+	} {
+		fs.files[name] = &memFile{name: path.Base(name), mode: 0644, modTime: fsDate, data: []byte(data)}
+	}
+
+	// the file trees of the content packs are added to the layout
+	content.Default().Walk("files", func(name string, f *content.File) {
+		name = path.Join("/", name)
+
+		for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+			if _, ok := fs.files[dir]; !ok {
+				fs.files[dir] = &memFile{name: path.Base(dir), mode: os.ModeDir | 0755, modTime: f.ModTime}
+			}
+		}
+
+		if existing, ok := fs.files[name]; ok && existing.IsDir() {
+			return
+		}
+
+		// the data of the pack is shared by the sessions
+		fs.files[name] = &memFile{name: path.Base(name), mode: 0644, modTime: f.ModTime, data: append([]byte{}, f.Data...)}
+	})
+
+	// the files of the layout don't count towards the maximum size
+	for _, f := range fs.files {
+		fs.size += len(f.data)
+	}
+
+	fs.max += fs.size
+
+	return fs
+}
+
+// resolve returns the absolute path, relative paths are relative to the
+// home directory.
+func (fs *memFS) resolve(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(fs.home, p)
+	}
+
+	return path.Clean(p)
+}
+
+func (fs *memFS) stat(p string) (*memFile, error) {
+	f, ok := fs.files[fs.resolve(p)]
+	if !ok {
+		return nil, errNotExist
+	}
+
+	return f, nil
+}
+
+// parent returns the directory of the path, the directory has to exist.
+func (fs *memFS) parent(p string) error {
+	dir, ok := fs.files[path.Dir(p)]
+	if !ok {
+		return errNotExist
+	} else if !dir.IsDir() {
+		return errNotDir
+	}
+
+	return nil
+}
+
+// list returns the files of the directory, sorted by name.
+func (fs *memFS) list(p string) ([]*memFile, error) {
+	p = fs.resolve(p)
+
+	if f, ok := fs.files[p]; !ok {
+		return nil, errNotExist
+	} else if !f.IsDir() {
+		return nil, errNotDir
+	}
+
+	files := []*memFile{}
+	for name, f := range fs.files {
+		if name != "/" && path.Dir(name) == p {
+			files = append(files, f)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+
+	return files, nil
+}
+
+// create returns the file, the file is created when it doesn't exist.
+func (fs *memFS) create(p string, truncate, exclusive bool) (*memFile, error) {
+	p = fs.resolve(p)
+
+	if f, ok := fs.files[p]; !ok {
+	} else if exclusive {
+		return nil, errExist
+	} else if f.IsDir() {
+		return nil, errIsDir
+	} else {
+		if truncate {
+			fs.size -= len(f.data)
+			f.data = nil
+		}
+
+		return f, nil
+	}
+
+	if err := fs.parent(p); err != nil {
+		return nil, err
+	}
+
+	f := &memFile{name: path.Base(p), mode: 0644, modTime: time.Now()}
+	fs.files[p] = f
+	return f, nil
+}
+
+// write writes the data at the offset of the file.
+func (fs *memFS) write(f *memFile, offset int64, data []byte) error {
+	end := int(offset) + len(data)
+	if offset < 0 || end < 0 {
+		return errNoSpace
+	}
+
+	if grow := end - len(f.data); grow > 0 {
+		if fs.size+grow > fs.max {
+			return errNoSpace
+		}
+
+		fs.size += grow
+		f.data = append(f.data, make([]byte, grow)...)
+	}
+
+	copy(f.data[offset:], data)
+	f.modTime = time.Now()
+	return nil
+}
+
+func (fs *memFS) mkdir(p string) error {
+	p = fs.resolve(p)
+
+	if _, ok := fs.files[p]; ok {
+		return errExist
+	} else if err := fs.parent(p); err != nil {
+		return err
+	}
+
+	fs.files[p] = &memFile{name: path.Base(p), mode: os.ModeDir | 0755, modTime: time.Now()}
+	return nil
+}
+
+func (fs *memFS) remove(p string, dir bool) error {
+	p = fs.resolve(p)
+
+	f, ok := fs.files[p]
+	if !ok || p == "/" {
+		return errNotExist
+	} else if dir && !f.IsDir() {
+		return errNotDir
+	} else if !dir && f.IsDir() {
+		return errIsDir
+	}
+
+	if dir {
+		if files, _ := fs.list(p); len(files) > 0 {
+			return errNotEmpty
+		}
+	}
+
+	fs.size -= len(f.data)
+	delete(fs.files, p)
+	return nil
+}
+
+func (fs *memFS) rename(from, to string) error {
+	from, to = fs.resolve(from), fs.resolve(to)
+
+	f, ok := fs.files[from]
+	if !ok || from == "/" {
+		return errNotExist
+	} else if _, ok := fs.files[to]; ok {
+		return errExist
+	} else if err := fs.parent(to); err != nil {
+		return err
+	} else if strings.HasPrefix(to, from+"/") {
+		return errNotDir
+	}
+
+	// the files of directories are moved along
+	for name, child := range fs.files {
+		if strings.HasPrefix(name, from+"/") {
+			delete(fs.files, name)
+			fs.files[to+strings.TrimPrefix(name, from)] = child
+		}
+	}
+
+	delete(fs.files, from)
+
+	f.name = path.Base(to)
+	fs.files[to] = f
+	return nil
+}
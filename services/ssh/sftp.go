@@ -0,0 +1,570 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// sftp packet types, https://tools.ietf.org/html/draft-ietf-secsh-filexfer-02
+const (
+	sshFxpInit     = 1
+	sshFxpVersion  = 2
+	sshFxpOpen     = 3
+	sshFxpClose    = 4
+	sshFxpRead     = 5
+	sshFxpWrite    = 6
+	sshFxpLstat    = 7
+	sshFxpFstat    = 8
+	sshFxpSetstat  = 9
+	sshFxpFsetstat = 10
+	sshFxpOpendir  = 11
+	sshFxpReaddir  = 12
+	sshFxpRemove   = 13
+	sshFxpMkdir    = 14
+	sshFxpRmdir    = 15
+	sshFxpRealpath = 16
+	sshFxpStat     = 17
+	sshFxpRename   = 18
+	sshFxpReadlink = 19
+	sshFxpSymlink  = 20
+	sshFxpStatus   = 101
+	sshFxpHandle   = 102
+	sshFxpData     = 103
+	sshFxpName     = 104
+	sshFxpAttrs    = 105
+)
+
+// sftp status codes
+const (
+	sshFxOk               = 0
+	sshFxEOF              = 1
+	sshFxNoSuchFile       = 2
+	sshFxPermissionDenied = 3
+	sshFxFailure          = 4
+	sshFxBadMessage       = 5
+	sshFxOpUnsupported    = 8
+)
+
+// sftp open flags
+const (
+	sshFxfRead   = 0x01
+	sshFxfWrite  = 0x02
+	sshFxfAppend = 0x04
+	sshFxfCreat  = 0x08
+	sshFxfTrunc  = 0x10
+	sshFxfExcl   = 0x20
+)
+
+// sftp attribute flags
+const (
+	sshFileXferAttrSize        = 0x01
+	sshFileXferAttrUIDGID      = 0x02
+	sshFileXferAttrPermissions = 0x04
+	sshFileXferAttrACModTime   = 0x08
+	sshFileXferAttrExtended    = 0x80000000
+)
+
+// maxPacketSize is the maximum size of the packets accepted.
+const maxPacketSize = 256 * 1024
+
+// traversalPatterns are the patterns of paths trying to escape the
+// directory or reach sensitive files.
+var traversalPatterns = []struct {
+	Name    string
+	Matches func(p string) bool
+}{
+	{"dot-dot", func(p string) bool {
+		for _, part := range strings.Split(strings.Replace(p, "\\", "/", -1), "/") {
+			if part == ".." {
+				return true
+			}
+		}
+
+		return false
+	}},
+	{"encoded", func(p string) bool {
+		p = strings.ToLower(p)
+		return strings.Contains(p, "%2e") || strings.Contains(p, "%2f") || strings.Contains(p, "%5c")
+	}},
+	{"backslash", func(p string) bool { return strings.Contains(p, "\\") }},
+	{"null-byte", func(p string) bool { return strings.Contains(p, "\x00") }},
+	{"sensitive", func(p string) bool {
+		for _, prefix := range []string{"/etc/shadow", "/etc/sudoers", "/proc/", "/root/.ssh/", "/dev/"} {
+			if strings.HasPrefix(p, prefix) {
+				return true
+			}
+		}
+
+		return false
+	}},
+}
+
+// traversal returns the names of the traversal patterns of the path, p is
+// the path as requested and resolved the path it resolves to.
+func traversal(p, resolved string) []string {
+	names := []string{}
+
+	for _, pattern := range traversalPatterns {
+		if pattern.Matches(p) || (pattern.Name == "sensitive" && pattern.Matches(resolved)) {
+			names = append(names, pattern.Name)
+		}
+	}
+
+	return names
+}
+
+type sftpHandle struct {
+	path string
+	file *memFile
+
+	// dir is a handle of a directory, listed after the first read
+	dir    bool
+	listed bool
+
+	// written is true when the file has been written to
+	written bool
+}
+
+// sftpServer serves the sftp subsystem on the channel, with the memory
+// filesystem.
+type sftpServer struct {
+	rw io.ReadWriter
+	fs *memFS
+
+	handles map[string]*sftpHandle
+	next    int
+
+	// send sends the event, with the options of the session
+	send func(options ...event.Option)
+}
+
+func newSFTPServer(rw io.ReadWriter, maxUpload int, send func(options ...event.Option)) *sftpServer {
+	return &sftpServer{
+		rw:      rw,
+		fs:      newMemFS(maxUpload),
+		handles: map[string]*sftpHandle{},
+		send:    send,
+	}
+}
+
+func (s *sftpServer) readPacket() ([]byte, error) {
+	var length uint32
+	if err := binary.Read(s.rw, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	if length == 0 || length > maxPacketSize {
+		return nil, fmt.Errorf("Invalid sftp packet length %d", length)
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(s.rw, packet); err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+// sftpPacket encodes a packet.
+type sftpPacket struct {
+	bytes.Buffer
+}
+
+func newSFTPPacket(t byte, id uint32) *sftpPacket {
+	p := &sftpPacket{}
+	p.WriteByte(t)
+	p.uint32(id)
+	return p
+}
+
+func (p *sftpPacket) uint32(v uint32) {
+	binary.Write(&p.Buffer, binary.BigEndian, v)
+}
+
+func (p *sftpPacket) uint64(v uint64) {
+	binary.Write(&p.Buffer, binary.BigEndian, v)
+}
+
+func (p *sftpPacket) string(s string) {
+	p.uint32(uint32(len(s)))
+	p.WriteString(s)
+}
+
+func (p *sftpPacket) attrs(f *memFile) {
+	p.uint32(sshFileXferAttrSize | sshFileXferAttrUIDGID | sshFileXferAttrPermissions | sshFileXferAttrACModTime)
+	p.uint64(uint64(len(f.data)))
+	p.uint32(0)
+	p.uint32(0)
+	p.uint32(permissions(f))
+	p.uint32(uint32(f.modTime.Unix()))
+	p.uint32(uint32(f.modTime.Unix()))
+}
+
+// permissions returns the unix mode of the file.
+func permissions(f *memFile) uint32 {
+	mode := uint32(f.mode.Perm())
+	if f.mode&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+
+	if f.IsDir() {
+		return mode | 040000
+	}
+
+	return mode | 0100000
+}
+
+// longname returns the ls -l line of the file.
+func longname(f *memFile) string {
+	links, size := 1, len(f.data)
+	if f.IsDir() {
+		links, size = 2, 4096
+	}
+
+	return fmt.Sprintf("%s %4d root     root     %8d %s %s", f.mode.String(), links, size, f.modTime.Format("Jan _2 15:04"), f.name)
+}
+
+func (s *sftpServer) write(p *sftpPacket) error {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(p.Len()))
+
+	_, err := s.rw.Write(append(length, p.Bytes()...))
+	return err
+}
+
+func (s *sftpServer) status(id uint32, code uint32, message string) error {
+	p := newSFTPPacket(sshFxpStatus, id)
+	p.uint32(code)
+	p.string(message)
+	p.string("en")
+	return s.write(p)
+}
+
+// statusError returns the status of the error of the filesystem.
+func (s *sftpServer) statusError(id uint32, err error) error {
+	switch err {
+	case nil:
+		return s.status(id, sshFxOk, "Success")
+	case errNotExist:
+		return s.status(id, sshFxNoSuchFile, err.Error())
+	}
+
+	return s.status(id, sshFxFailure, err.Error())
+}
+
+func (s *sftpServer) handle(h *sftpHandle) string {
+	s.next++
+
+	name := strconv.Itoa(s.next)
+	s.handles[name] = h
+	return name
+}
+
+// operation sends the event of the operation, with the traversal patterns
+// of the path.
+func (s *sftpServer) operation(op string, p string, options ...event.Option) {
+	options = append(options,
+		event.Type("sftp-"+op),
+		event.Custom("ssh.sftp.path", p),
+	)
+
+	if patterns := traversal(p, s.fs.resolve(p)); len(patterns) > 0 {
+		options = append(options, event.Custom("ssh.sftp.traversal", patterns))
+	}
+
+	s.send(options...)
+}
+
+// upload sends the event of the uploaded file, with the file as payload.
+func (s *sftpServer) upload(h *sftpHandle) {
+	hash := sha256.Sum256(h.file.data)
+
+	s.send(
+		event.Type("sftp-upload"),
+		event.Custom("ssh.sftp.path", h.path),
+		event.Custom("ssh.sftp.size", len(h.file.data)),
+		event.Custom("ssh.sftp.sha256", hex.EncodeToString(hash[:])),
+		event.Payload(h.file.data),
+	)
+}
+
+// Serve serves the requests until the client closes the channel.
+func (s *sftpServer) Serve() error {
+	defer func() {
+		// files not closed by the client are captured as well
+		for _, h := range s.handles {
+			if h.written {
+				s.upload(h)
+			}
+		}
+	}()
+
+	for {
+		packet, err := s.readPacket()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := s.serve(packet); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *sftpServer) serve(packet []byte) error {
+	d := PayloadDecoder(packet)
+
+	t := d.Byte()
+	if t == sshFxpInit {
+		p := &sftpPacket{}
+		p.WriteByte(sshFxpVersion)
+		p.uint32(3)
+		return s.write(p)
+	}
+
+	id := d.Uint32()
+
+	switch t {
+	case sshFxpRealpath:
+		p := d.String()
+		if d.LastError() != nil {
+			break
+		}
+
+		if patterns := traversal(p, s.fs.resolve(p)); len(patterns) > 0 {
+			s.operation("realpath", p)
+		}
+
+		r := newSFTPPacket(sshFxpName, id)
+		r.uint32(1)
+		r.string(s.fs.resolve(p))
+		r.string(s.fs.resolve(p))
+		r.uint32(0)
+		return s.write(r)
+	case sshFxpStat, sshFxpLstat:
+		p := d.String()
+		if d.LastError() != nil {
+			break
+		}
+
+		if patterns := traversal(p, s.fs.resolve(p)); len(patterns) > 0 {
+			s.operation("stat", p)
+		}
+
+		f, err := s.fs.stat(p)
+		if err != nil {
+			return s.statusError(id, err)
+		}
+
+		r := newSFTPPacket(sshFxpAttrs, id)
+		r.attrs(f)
+		return s.write(r)
+	case sshFxpFstat:
+		h, ok := s.handles[d.String()]
+		if !ok || h.file == nil {
+			return s.status(id, sshFxFailure, "Invalid handle")
+		}
+
+		r := newSFTPPacket(sshFxpAttrs, id)
+		r.attrs(h.file)
+		return s.write(r)
+	case sshFxpOpendir:
+		p := d.String()
+		if d.LastError() != nil {
+			break
+		}
+
+		s.operation("opendir", p)
+
+		f, err := s.fs.stat(p)
+		if err != nil {
+			return s.statusError(id, err)
+		} else if !f.IsDir() {
+			return s.statusError(id, errNotDir)
+		}
+
+		r := newSFTPPacket(sshFxpHandle, id)
+		r.string(s.handle(&sftpHandle{path: s.fs.resolve(p), file: f, dir: true}))
+		return s.write(r)
+	case sshFxpReaddir:
+		h, ok := s.handles[d.String()]
+		if !ok || !h.dir {
+			return s.status(id, sshFxFailure, "Invalid handle")
+		} else if h.listed {
+			return s.status(id, sshFxEOF, "End of file")
+		}
+
+		h.listed = true
+
+		files, err := s.fs.list(h.path)
+		if err != nil {
+			return s.statusError(id, err)
+		}
+
+		r := newSFTPPacket(sshFxpName, id)
+		r.uint32(uint32(len(files)))
+		for _, f := range files {
+			r.string(f.name)
+			r.string(longname(f))
+			r.attrs(f)
+		}
+
+		return s.write(r)
+	case sshFxpOpen:
+		p := d.String()
+		pflags := d.Uint32()
+		if d.LastError() != nil {
+			break
+		}
+
+		mode := "read"
+		if pflags&sshFxfWrite != 0 {
+			mode = "write"
+		}
+
+		s.operation("open", p, event.Custom("ssh.sftp.mode", mode))
+
+		var f *memFile
+		var err error
+
+		if pflags&sshFxfWrite == 0 {
+			f, err = s.fs.stat(p)
+			if err == nil && f.IsDir() {
+				err = errIsDir
+			}
+		} else if pflags&sshFxfCreat != 0 {
+			f, err = s.fs.create(p, pflags&sshFxfTrunc != 0, pflags&sshFxfExcl != 0)
+		} else if f, err = s.fs.stat(p); err == nil && pflags&sshFxfTrunc != 0 {
+			f, err = s.fs.create(p, true, false)
+		}
+
+		if err != nil {
+			return s.statusError(id, err)
+		}
+
+		r := newSFTPPacket(sshFxpHandle, id)
+		r.string(s.handle(&sftpHandle{path: s.fs.resolve(p), file: f}))
+		return s.write(r)
+	case sshFxpRead:
+		h, ok := s.handles[d.String()]
+		offset := uint64(d.Uint32())<<32 | uint64(d.Uint32())
+		length := d.Uint32()
+
+		if d.LastError() != nil {
+			break
+		} else if !ok || h.dir {
+			return s.status(id, sshFxFailure, "Invalid handle")
+		} else if offset >= uint64(len(h.file.data)) {
+			return s.status(id, sshFxEOF, "End of file")
+		}
+
+		end := offset + uint64(length)
+		if end > uint64(len(h.file.data)) {
+			end = uint64(len(h.file.data))
+		}
+
+		r := newSFTPPacket(sshFxpData, id)
+		r.string(string(h.file.data[offset:end]))
+		return s.write(r)
+	case sshFxpWrite:
+		h, ok := s.handles[d.String()]
+		offset := uint64(d.Uint32())<<32 | uint64(d.Uint32())
+		data := d.String()
+
+		if d.LastError() != nil {
+			break
+		} else if !ok || h.dir {
+			return s.status(id, sshFxFailure, "Invalid handle")
+		}
+
+		h.written = true
+		return s.statusError(id, s.fs.write(h.file, int64(offset), []byte(data)))
+	case sshFxpClose:
+		name := d.String()
+
+		h, ok := s.handles[name]
+		if !ok {
+			return s.status(id, sshFxFailure, "Invalid handle")
+		}
+
+		delete(s.handles, name)
+
+		if h.written {
+			s.upload(h)
+		}
+
+		return s.statusError(id, nil)
+	case sshFxpSetstat, sshFxpFsetstat:
+		// permissions and times are accepted, but not kept
+		return s.statusError(id, nil)
+	case sshFxpRemove, sshFxpRmdir, sshFxpMkdir:
+		p := d.String()
+		if d.LastError() != nil {
+			break
+		}
+
+		var err error
+
+		switch t {
+		case sshFxpRemove:
+			s.operation("remove", p)
+			err = s.fs.remove(p, false)
+		case sshFxpRmdir:
+			s.operation("rmdir", p)
+			err = s.fs.remove(p, true)
+		case sshFxpMkdir:
+			s.operation("mkdir", p)
+			err = s.fs.mkdir(p)
+		}
+
+		return s.statusError(id, err)
+	case sshFxpRename:
+		from, to := d.String(), d.String()
+		if d.LastError() != nil {
+			break
+		}
+
+		s.operation("rename", from, event.Custom("ssh.sftp.target", to))
+		return s.statusError(id, s.fs.rename(from, to))
+	case sshFxpReadlink, sshFxpSymlink:
+		p := d.String()
+		if d.LastError() != nil {
+			break
+		}
+
+		if t == sshFxpSymlink {
+			s.operation("symlink", p, event.Custom("ssh.sftp.target", d.String()))
+			return s.status(id, sshFxPermissionDenied, "Permission denied")
+		}
+
+		return s.statusError(id, errNotExist)
+	default:
+		return s.status(id, sshFxOpUnsupported, "Operation unsupported")
+	}
+
+	return s.status(id, sshFxBadMessage, "Bad message")
+}
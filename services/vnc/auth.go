@@ -0,0 +1,56 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vnc
+
+import (
+	"crypto/des"
+	"crypto/rand"
+)
+
+const authVNC = 2
+
+// newChallenge returns a random vnc authentication challenge.
+func newChallenge() []byte {
+	challenge := make([]byte, 16)
+	rand.Read(challenge)
+	return challenge
+}
+
+// vncAuthResponse returns the response a client will send for the challenge
+// when using password. The password is truncated to 8 bytes, and vnc
+// reverses the bits of every byte of the des key.
+func vncAuthResponse(challenge []byte, password string) []byte {
+	key := make([]byte, 8)
+	copy(key, password)
+
+	for i, b := range key {
+		var r byte
+		for j := uint(0); j < 8; j++ {
+			r |= ((b >> j) & 1) << (7 - j)
+		}
+		key[i] = r
+	}
+
+	cipher, err := des.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+
+	response := make([]byte, len(challenge))
+	for i := 0; i+8 <= len(challenge); i += 8 {
+		cipher.Encrypt(response[i:i+8], challenge[i:i+8])
+	}
+
+	return response
+}
@@ -15,11 +15,15 @@ package vnc
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"image"
+	"io"
 	"net"
 	"sync"
+
+	"github.com/honeytrap/honeytrap/services/authpolicy"
 )
 
 const (
@@ -75,6 +79,11 @@ type LockableImage struct {
 type Conn struct {
 	serverName string
 
+	// policy enables vnc authentication when set, onAuth will be called
+	// with the response of the client and the result.
+	policy *authpolicy.Policy
+	onAuth func(response []byte, accepted bool)
+
 	c      net.Conn
 	br     *bufio.Reader
 	bw     *bufio.Writer
@@ -144,6 +153,55 @@ func (c *Conn) failf(format string, args ...interface{}) {
 	panic(fmt.Sprintf(format, args...))
 }
 
+// authenticate performs vnc authentication, the response of the client is
+// verified against the credentials of the policy.
+func (c *Conn) authenticate(ver string) {
+	if ver >= v7 {
+		c.bw.WriteString("\x01\x02")
+		c.flush()
+		wanted := c.readByte("6.1.2:client requested security-type")
+		if wanted != authVNC {
+			c.failf("client wanted auth type %d, not VNC", int(wanted))
+		}
+	} else {
+		c.w(uint32(authVNC))
+		c.flush()
+	}
+
+	challenge := newChallenge()
+	c.bw.Write(challenge)
+	c.flush()
+
+	response := make([]byte, 16)
+	if _, err := io.ReadFull(c.br, response); err != nil {
+		c.failf("reading vnc authentication response: %v", err)
+	}
+
+	accepted := c.policy.AcceptFunc(c.c.RemoteAddr(), func(username, password string) bool {
+		return bytes.Equal(vncAuthResponse(challenge, password), response)
+	})
+
+	if c.onAuth != nil {
+		c.onAuth(response, accepted)
+	}
+
+	if accepted {
+		c.w(uint32(statusOK))
+		c.flush()
+		return
+	}
+
+	c.w(uint32(statusFailed))
+	if ver >= v8 {
+		reason := "Authentication failed"
+		c.w(uint32(len(reason)))
+		c.bw.WriteString(reason)
+	}
+	c.flush()
+
+	c.failf("authentication failed")
+}
+
 func (c *Conn) serve() {
 	defer c.c.Close()
 	defer close(c.fbupc)
@@ -171,7 +229,9 @@ func (c *Conn) serve() {
 	}
 
 	// Auth
-	if ver >= v7 {
+	if c.policy != nil {
+		c.authenticate(ver)
+	} else if ver >= v7 {
 		// Just 1 auth type supported: 1 (no auth)
 		c.bw.WriteString("\x01\x01")
 		c.flush()
@@ -185,7 +245,7 @@ func (c *Conn) serve() {
 		c.flush()
 	}
 
-	if ver >= v8 {
+	if ver >= v8 && c.policy == nil {
 		// 6.1.3. SecurityResult
 		c.w(uint32(statusOK))
 		c.flush()
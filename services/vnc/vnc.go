@@ -15,6 +15,7 @@ package vnc
 
 import (
 	"context"
+	"encoding/hex"
 	"net"
 	"os"
 	"path/filepath"
@@ -27,6 +28,7 @@ import (
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/services"
+	"github.com/honeytrap/honeytrap/services/authpolicy"
 )
 
 var log = logging.MustGetLogger("services/vnc")
@@ -41,6 +43,10 @@ func Vnc(options ...services.ServicerFunc) services.Servicer {
 		o(s)
 	}
 
+	if s.AuthPolicy != "" {
+		s.policy = authpolicy.MustNew(s.Config)
+	}
+
 	if pwd, err := os.Getwd(); err != nil {
 	} else if !filepath.IsAbs(s.ImagePath) {
 		s.ImagePath = filepath.Join(pwd, s.ImagePath)
@@ -74,6 +80,12 @@ type vncService struct {
 
 	ImagePath  string `toml:"image"`
 	ServerName string `toml:"server-name"`
+
+	// vnc authentication will be requested if an auth-policy has been
+	// configured, otherwise clients will connect without authentication.
+	authpolicy.Config
+
+	policy *authpolicy.Policy
 }
 
 func (s *vncService) SetChannel(c pushers.Channel) {
@@ -87,6 +99,19 @@ func (s *vncService) Handle(ctx context.Context, conn net.Conn) error {
 
 	c := newConn(bounds.Dx(), bounds.Dy(), conn)
 	c.serverName = s.ServerName
+	c.policy = s.policy
+	c.onAuth = func(response []byte, accepted bool) {
+		s.c.Send(event.New(
+			event.Sensor("vnc"),
+			event.Service("vnc"),
+			event.Category("vnc"),
+			event.Type("vnc-authentication"),
+			event.SourceAddr(conn.RemoteAddr()),
+			event.DestinationAddr(conn.LocalAddr()),
+			event.Custom("vnc.response", hex.EncodeToString(response)),
+			event.Custom("vnc.accepted", accepted),
+		))
+	}
 
 	go c.serve()
 
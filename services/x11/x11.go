@@ -0,0 +1,450 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package x11
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("services/x11")
+
+var (
+	_ = services.Register("x11", X11)
+)
+
+var (
+	ErrInvalidByteOrder = errors.New("Invalid byte order")
+	ErrRequestTooLarge  = errors.New("Request too large")
+)
+
+// maxRequestLength is the maximum request length, in 4 byte units, that is
+// announced to the clients
+const maxRequestLength = 0xffff
+
+// X11 returns the x11 service. The service completes the connection setup
+// with a single screen, records the authentication and reports extension
+// queries and the requests used for keylogging and screenshots.
+func X11(options ...services.ServicerFunc) services.Servicer {
+	s := &x11Service{
+		Config: Config{
+			Vendor:      "The X.Org Foundation",
+			Release:     12004000,
+			Width:       1920,
+			Height:      1080,
+			RequireAuth: false,
+		},
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	return s
+}
+
+type Config struct {
+	Vendor  string `toml:"vendor"`
+	Release uint32 `toml:"release"`
+
+	Width  uint16 `toml:"width"`
+	Height uint16 `toml:"height"`
+
+	// RequireAuth rejects connections without authorization data, by
+	// default the server is open like a misconfigured xhost +.
+	RequireAuth bool `toml:"require-auth"`
+}
+
+type x11Service struct {
+	Config
+
+	ch pushers.Channel
+}
+
+func (s *x11Service) SetChannel(c pushers.Channel) {
+	s.ch = c
+}
+
+func (s *x11Service) CanHandle(payload []byte) bool {
+	// byte order, followed by protocol version 11
+	if len(payload) < 4 {
+		return false
+	}
+
+	switch payload[0] {
+	case 'B':
+		return payload[2] == 0 && payload[3] == 11
+	case 'l':
+		return payload[2] == 11 && payload[3] == 0
+	}
+
+	return false
+}
+
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+type setupRequest struct {
+	order binary.ByteOrder
+
+	Major, Minor uint16
+
+	AuthName string
+	AuthData []byte
+}
+
+func readSetup(r io.Reader) (*setupRequest, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	req := &setupRequest{}
+
+	switch header[0] {
+	case 'B':
+		req.order = binary.BigEndian
+	case 'l':
+		req.order = binary.LittleEndian
+	default:
+		return nil, ErrInvalidByteOrder
+	}
+
+	req.Major = req.order.Uint16(header[2:])
+	req.Minor = req.order.Uint16(header[4:])
+
+	nameLen := int(req.order.Uint16(header[6:]))
+	dataLen := int(req.order.Uint16(header[8:]))
+
+	data := make([]byte, nameLen+pad(nameLen)+dataLen+pad(dataLen))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	req.AuthName = string(data[:nameLen])
+	req.AuthData = data[nameLen+pad(nameLen) : nameLen+pad(nameLen)+dataLen]
+	return req, nil
+}
+
+// setupFailed returns the failed connection setup reply.
+func setupFailed(order binary.ByteOrder, reason string) []byte {
+	b := make([]byte, 8, 8+len(reason)+pad(len(reason)))
+	b[0] = 0
+	b[1] = byte(len(reason))
+	order.PutUint16(b[2:], 11)
+	order.PutUint16(b[4:], 0)
+	order.PutUint16(b[6:], uint16((len(reason)+pad(len(reason)))/4))
+
+	b = append(b, reason...)
+	return append(b, make([]byte, pad(len(reason)))...)
+}
+
+// setupSuccess returns the connection setup reply with a single 24 bit
+// screen.
+func (s *x11Service) setupSuccess(order binary.ByteOrder) []byte {
+	vendor := []byte(s.Vendor)
+	vendor = append(vendor, make([]byte, pad(len(vendor)))...)
+
+	data := make([]byte, 32)
+	order.PutUint32(data[0:], s.Release)
+	order.PutUint32(data[4:], 0x00400000) // resource id base
+	order.PutUint32(data[8:], 0x001fffff) // resource id mask
+	order.PutUint32(data[12:], 256)       // motion buffer size
+	order.PutUint16(data[16:], uint16(len(s.Vendor)))
+	order.PutUint16(data[18:], maxRequestLength)
+	data[20] = 1 // screens
+	data[21] = 1 // pixmap formats
+	data[22] = 0 // image byte order, lsb first
+	data[23] = 0 // bitmap bit order
+	data[24] = 32
+	data[25] = 32
+	data[26] = 8   // min keycode
+	data[27] = 255 // max keycode
+
+	data = append(data, vendor...)
+
+	// pixmap format: depth 24, 32 bits per pixel, scanline pad 32
+	data = append(data, 24, 32, 32, 0, 0, 0, 0, 0)
+
+	screen := make([]byte, 40)
+	order.PutUint32(screen[0:], 0x000001e0)  // root window
+	order.PutUint32(screen[4:], 0x00000020)  // default colormap
+	order.PutUint32(screen[8:], 0x00ffffff)  // white pixel
+	order.PutUint32(screen[12:], 0x00000000) // black pixel
+	order.PutUint32(screen[16:], 0x00fa8033) // current input masks
+	order.PutUint16(screen[20:], s.Width)
+	order.PutUint16(screen[22:], s.Height)
+	order.PutUint16(screen[24:], uint16(uint32(s.Width)*254/960))
+	order.PutUint16(screen[26:], uint16(uint32(s.Height)*254/960))
+	order.PutUint16(screen[28:], 1)
+	order.PutUint16(screen[30:], 1)
+	order.PutUint32(screen[32:], 0x00000021) // root visual
+	screen[36] = 0                           // backing stores
+	screen[37] = 0                           // save unders
+	screen[38] = 24                          // root depth
+	screen[39] = 1                           // allowed depths
+
+	// depth 24 with a single true color visual
+	depth := make([]byte, 8+24)
+	depth[0] = 24
+	order.PutUint16(depth[2:], 1)
+	order.PutUint32(depth[8:], 0x00000021)
+	depth[12] = 4 // true color
+	depth[13] = 8 // bits per rgb value
+	order.PutUint16(depth[14:], 256)
+	order.PutUint32(depth[16:], 0x00ff0000)
+	order.PutUint32(depth[20:], 0x0000ff00)
+	order.PutUint32(depth[24:], 0x000000ff)
+
+	data = append(data, screen...)
+	data = append(data, depth...)
+
+	header := make([]byte, 8)
+	header[0] = 1
+	order.PutUint16(header[2:], 11)
+	order.PutUint16(header[4:], 0)
+	order.PutUint16(header[6:], uint16(len(data)/4))
+
+	return append(header, data...)
+}
+
+const (
+	opGrabKeyboard   = 31
+	opGrabKey        = 33
+	opQueryKeymap    = 44
+	opGetImage       = 73
+	opQueryExtension = 98
+	opListExtensions = 99
+)
+
+var requestNames = map[byte]string{
+	1: "CreateWindow", 2: "ChangeWindowAttributes", 3: "GetWindowAttributes",
+	8: "MapWindow", 12: "ConfigureWindow", 14: "GetGeometry", 15: "QueryTree",
+	16: "InternAtom", 17: "GetAtomName", 18: "ChangeProperty", 20: "GetProperty",
+	21: "ListProperties", 23: "GetSelectionOwner", 25: "SendEvent",
+	26: "GrabPointer", 31: "GrabKeyboard", 33: "GrabKey", 38: "QueryPointer",
+	43: "GetInputFocus", 44: "QueryKeymap", 45: "OpenFont", 47: "QueryFont",
+	49: "ListFonts", 53: "CreatePixmap", 55: "CreateGC", 73: "GetImage",
+	84: "AllocColor", 91: "QueryColors", 98: "QueryExtension", 99: "ListExtensions",
+	101: "GetKeyboardMapping", 103: "GetKeyboardControl", 110: "ListHosts",
+	119: "GetModifierMapping", 127: "NoOperation",
+}
+
+// repliedRequests are the requests that expect a reply, unsupported
+// requests get an implementation error.
+var repliedRequests = map[byte]bool{
+	3: true, 14: true, 15: true, 16: true, 17: true, 20: true, 21: true, 23: true,
+	26: true, 38: true, 39: true, 40: true, 43: true, 47: true, 48: true, 49: true,
+	50: true, 52: true, 83: true, 84: true, 85: true, 86: true, 87: true, 91: true,
+	92: true, 97: true, 101: true, 103: true, 106: true, 108: true, 110: true,
+	116: true, 117: true, 118: true, 119: true,
+}
+
+func requestName(opcode byte) string {
+	if name, ok := requestNames[opcode]; ok {
+		return name
+	}
+
+	if opcode >= 128 {
+		return "extension"
+	}
+
+	return "unknown"
+}
+
+// x11Error returns an error response.
+func x11Error(order binary.ByteOrder, code byte, seq uint16, opcode byte) []byte {
+	b := make([]byte, 32)
+	b[0] = 0
+	b[1] = code
+	order.PutUint16(b[2:], seq)
+	b[10] = opcode
+	return b
+}
+
+// x11Reply returns a reply with the data, padded to 32 bytes.
+func x11Reply(order binary.ByteOrder, seq uint16, detail byte, data []byte) []byte {
+	b := make([]byte, 8, 32)
+	b[0] = 1
+	b[1] = detail
+	order.PutUint16(b[2:], seq)
+
+	b = append(b, data...)
+
+	if len(b) < 32 {
+		b = append(b, make([]byte, 32-len(b))...)
+	}
+
+	b = append(b, make([]byte, pad(len(b)))...)
+	order.PutUint32(b[4:], uint32((len(b)-32)/4))
+	return b
+}
+
+const (
+	errorAccess         = 10
+	errorImplementation = 17
+)
+
+func (s *x11Service) Handle(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	var connOptions event.Option = nil
+
+	if ec, ok := conn.(*event.Conn); ok {
+		connOptions = ec.Options()
+	}
+
+	options := []event.Option{
+		services.EventOptions,
+		event.Category("x11"),
+		connOptions,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+	}
+
+	send := func(t string, o ...event.Option) {
+		o = append([]event.Option{event.Type(t)}, o...)
+
+		s.ch.Send(event.New(
+			append(o, options...)...,
+		))
+	}
+
+	br := bufio.NewReader(conn)
+
+	setup, err := readSetup(br)
+	if err != nil {
+		return err
+	}
+
+	authenticated := !s.RequireAuth || len(setup.AuthData) > 0
+
+	send("connection-setup",
+		event.Custom("x11.protocol-version", int(setup.Major)),
+		event.Custom("x11.auth-name", setup.AuthName),
+		event.Custom("x11.auth-data", hex.EncodeToString(setup.AuthData)),
+		event.Custom("x11.authenticated", authenticated),
+	)
+
+	if !authenticated {
+		_, err := conn.Write(setupFailed(setup.order, "No protocol specified\n"))
+		return err
+	}
+
+	if _, err := conn.Write(s.setupSuccess(setup.order)); err != nil {
+		return err
+	}
+
+	order := setup.order
+
+	requests := []string{}
+	seen := map[string]bool{}
+
+	defer func() {
+		send("session",
+			event.Custom("x11.requests", requests),
+		)
+	}()
+
+	for seq := uint16(1); ; seq++ {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		opcode := header[0]
+
+		length := int(order.Uint16(header[2:])) * 4
+		if length < 4 {
+			// big requests aren't supported
+			return ErrRequestTooLarge
+		}
+
+		body := make([]byte, length-4)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return err
+		}
+
+		name := requestName(opcode)
+		if !seen[name] {
+			seen[name] = true
+			requests = append(requests, name)
+		}
+
+		var resp []byte
+
+		switch opcode {
+		case opQueryExtension:
+			extension := ""
+			if len(body) >= 4 {
+				n := int(order.Uint16(body[0:]))
+				if 4+n <= len(body) {
+					extension = string(body[4 : 4+n])
+				}
+			}
+
+			send("extension-query",
+				event.Custom("x11.extension", extension),
+			)
+
+			// extensions aren't present
+			resp = x11Reply(order, seq, 0, nil)
+		case opListExtensions:
+			resp = x11Reply(order, seq, 0, nil)
+		case opGetImage:
+			send("screenshot",
+				event.Custom("x11.request", name),
+			)
+
+			resp = x11Error(order, errorAccess, seq, opcode)
+		case opGrabKeyboard, opGrabKey, opQueryKeymap:
+			send("keylogging",
+				event.Custom("x11.request", name),
+			)
+
+			switch opcode {
+			case opGrabKeyboard:
+				// grab status success
+				resp = x11Reply(order, seq, 0, nil)
+			case opQueryKeymap:
+				resp = x11Reply(order, seq, 0, make([]byte, 32))
+			}
+		default:
+			if repliedRequests[opcode] || opcode >= 128 {
+				resp = x11Error(order, errorImplementation, seq, opcode)
+			}
+		}
+
+		if resp == nil {
+			continue
+		}
+
+		if _, err := conn.Write(resp); err != nil {
+			return err
+		}
+	}
+}
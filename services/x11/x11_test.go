@@ -0,0 +1,138 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package x11
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+type eventChannel chan event.Event
+
+func (c eventChannel) Send(e event.Event) {
+	c <- e
+}
+
+func TestX11(t *testing.T) {
+	events := make(eventChannel, 10)
+
+	s := X11()
+	s.SetChannel(pushers.Channel(events))
+
+	server, client := net.Pipe()
+
+	done := make(chan error)
+	go func() {
+		done <- s.Handle(context.TODO(), server)
+	}()
+
+	le := binary.LittleEndian
+
+	authName := "MIT-MAGIC-COOKIE-1"
+	cookie := make([]byte, 16)
+
+	setup := make([]byte, 12)
+	setup[0] = 'l'
+	le.PutUint16(setup[2:], 11)
+	le.PutUint16(setup[6:], uint16(len(authName)))
+	le.PutUint16(setup[8:], uint16(len(cookie)))
+	setup = append(setup, authName...)
+	setup = append(setup, 0, 0)
+	setup = append(setup, cookie...)
+
+	if _, err := client.Write(setup); err != nil {
+		t.Fatal(err)
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(client, header); err != nil {
+		t.Fatal(err)
+	}
+
+	if header[0] != 1 {
+		t.Fatalf("Expected setup success, got %d", header[0])
+	}
+
+	if _, err := io.ReadFull(client, make([]byte, int(le.Uint16(header[6:]))*4)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-events
+	if e.Get("type") != "connection-setup" || e.Get("x11.auth-name") != authName {
+		t.Errorf("Expected connection-setup event, got %s %s", e.Get("type"), e.Get("x11.auth-name"))
+	}
+
+	// query extension
+	name := "RECORD"
+	req := []byte{opQueryExtension, 0, 0, 0, byte(len(name)), 0, 0, 0}
+	req = append(req, name...)
+	req = append(req, 0, 0)
+	le.PutUint16(req[2:], uint16(len(req)/4))
+
+	if _, err := client.Write(req); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := make([]byte, 32)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply[0] != 1 || le.Uint16(reply[2:]) != 1 || reply[8] != 0 {
+		t.Errorf("Expected extension not present reply, got %x", reply)
+	}
+
+	e = <-events
+	if e.Get("type") != "extension-query" || e.Get("x11.extension") != "RECORD" {
+		t.Errorf("Expected extension-query for RECORD, got %s %s", e.Get("type"), e.Get("x11.extension"))
+	}
+
+	// get image of the root window
+	req = make([]byte, 20)
+	req[0] = opGetImage
+	req[1] = 2
+	le.PutUint16(req[2:], 5)
+
+	if _, err := client.Write(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply[0] != 0 || reply[1] != errorAccess {
+		t.Errorf("Expected access error, got %x", reply[:2])
+	}
+
+	if e := <-events; e.Get("type") != "screenshot" {
+		t.Errorf("Expected screenshot event, got %s", e.Get("type"))
+	}
+
+	client.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if e := <-events; e.Get("type") != "session" {
+		t.Errorf("Expected session event, got %s", e.Get("type"))
+	}
+}
@@ -0,0 +1,403 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package severity scores the events and assigns their severity, so the
+// alerting channels, the dashboard and the reports agree on what is
+// important. The score is the sum of the weights of the matching rules,
+// and of the score returned by an optional lua script.
+package severity
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	logging "github.com/op/go-logging"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var log = logging.MustGetLogger("honeytrap:severity")
+
+func init() {
+	event.RegisterField(event.Field{Name: "severity", Type: event.TypeString, Description: "Severity of the event: info, low, medium, high or critical"})
+	event.RegisterField(event.Field{Name: "severity.score", Type: event.TypeNumber, Description: "Score the severity is derived from"})
+	event.RegisterField(event.Field{Name: "severity.rules", Type: event.TypeArray, Description: "Names of the scoring rules that matched"})
+}
+
+// Severities, from low to high.
+const (
+	Info     = "info"
+	Low      = "low"
+	Medium   = "medium"
+	High     = "high"
+	Critical = "critical"
+)
+
+var levels = []string{Info, Low, Medium, High, Critical}
+
+// Compare returns a negative number if severity a is lower than b, zero
+// if equal and a positive number if higher. Unknown severities are lower
+// than all others.
+func Compare(a, b string) int {
+	return rank(a) - rank(b)
+}
+
+func rank(s string) int {
+	for i, l := range levels {
+		if l == s {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Rule adds its weight to the score of the events it matches. All
+// conditions of a rule have to match.
+type Rule struct {
+	Name string `toml:"name"`
+
+	Services   []string `toml:"services"`
+	Categories []string `toml:"categories"`
+
+	// Signatures are the identifiers of matched signatures, one has to
+	// have been matched
+	Signatures []string `toml:"signatures"`
+
+	// Field and Match match the value of a field, eg. a threat intel tag
+	// or a correlation result, a field without match has to be present
+	Field string `toml:"field"`
+	Match string `toml:"match"`
+
+	Weight float64 `toml:"weight"`
+
+	re *regexp.Regexp
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// values returns the values of the field, lists are flattened.
+func values(e event.Event, field string) ([]string, bool) {
+	result := []string{}
+	found := false
+
+	e.Range(func(key, value interface{}) bool {
+		if key != field {
+			return true
+		}
+
+		found = true
+
+		switch v := value.(type) {
+		case []string:
+			result = v
+		case string:
+			result = []string{v}
+		default:
+			result = []string{fmt.Sprint(v)}
+		}
+
+		return false
+	})
+
+	return result, found
+}
+
+// Matches returns true if the rule matches the event.
+func (r *Rule) Matches(e event.Event) bool {
+	if len(r.Services) > 0 && !contains(r.Services, e.Get("service")) {
+		return false
+	}
+
+	if len(r.Categories) > 0 && !contains(r.Categories, e.Get("category")) {
+		return false
+	}
+
+	if len(r.Signatures) > 0 {
+		ids, _ := values(e, "signature.id")
+
+		found := false
+		for _, id := range ids {
+			if contains(r.Signatures, id) {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if r.Field == "" {
+		return true
+	}
+
+	vs, ok := values(e, r.Field)
+	if !ok {
+		return false
+	} else if r.re == nil {
+		return true
+	}
+
+	for _, v := range vs {
+		if r.re.MatchString(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Thresholds are the minimum scores of the severities, lower scores are
+// info.
+type Thresholds struct {
+	Low      float64 `toml:"low"`
+	Medium   float64 `toml:"medium"`
+	High     float64 `toml:"high"`
+	Critical float64 `toml:"critical"`
+}
+
+// Severity returns the severity of the score.
+func (t Thresholds) Severity(score float64) string {
+	switch {
+	case score >= t.Critical:
+		return Critical
+	case score >= t.High:
+		return High
+	case score >= t.Medium:
+		return Medium
+	case score >= t.Low:
+		return Low
+	}
+
+	return Info
+}
+
+// Scorer assigns the severity of the events.
+type Scorer struct {
+	Enabled bool `toml:"enabled"`
+
+	Rules []*Rule `toml:"rule"`
+
+	Thresholds Thresholds `toml:"thresholds"`
+
+	// Script is a lua script, relative to the data directory, with a
+	// score(event) function returning the score to add. The event is a
+	// table of the fields of the event.
+	Script string `toml:"script"`
+
+	dataDir string
+
+	m     sync.Mutex
+	state *lua.LState
+}
+
+// New returns a new Scorer.
+func New(options ...func(*Scorer) error) (*Scorer, error) {
+	s := &Scorer{
+		Rules: []*Rule{},
+		Thresholds: Thresholds{
+			Low:      10,
+			Medium:   30,
+			High:     60,
+			Critical: 90,
+		},
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(s); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range s.Rules {
+		if r.Match == "" {
+			continue
+		}
+
+		if r.Field == "" {
+			return nil, fmt.Errorf("Rule %s has a match without field", r.Name)
+		}
+
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid match of rule %s: %s", r.Name, err.Error())
+		}
+
+		r.re = re
+	}
+
+	if s.Script != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the severity configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Scorer) error {
+	return func(s *Scorer) error {
+		return decoder.PrimitiveDecode(c, s)
+	}
+}
+
+// WithDataDir sets the data directory the script is loaded from.
+func WithDataDir(dataDir string) func(*Scorer) error {
+	return func(s *Scorer) error {
+		s.dataDir = dataDir
+		return nil
+	}
+}
+
+func (s *Scorer) load() error {
+	p := s.Script
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(s.dataDir, p)
+	}
+
+	L := lua.NewState()
+
+	if err := L.DoFile(p); err != nil {
+		L.Close()
+		return fmt.Errorf("Error loading severity script %s: %s", p, err.Error())
+	}
+
+	if L.GetGlobal("score").Type() != lua.LTFunction {
+		L.Close()
+		return fmt.Errorf("Severity script %s has no score function", p)
+	}
+
+	s.state = L
+	return nil
+}
+
+// script returns the score of the script for the event.
+func (s *Scorer) script(e event.Event) (float64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	table := s.state.NewTable()
+
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok {
+			return true
+		}
+
+		switch v := value.(type) {
+		case string:
+			table.RawSetString(k, lua.LString(v))
+		case bool:
+			table.RawSetString(k, lua.LBool(v))
+		case int:
+			table.RawSetString(k, lua.LNumber(v))
+		case float64:
+			table.RawSetString(k, lua.LNumber(v))
+		case []string:
+			list := s.state.NewTable()
+			for _, item := range v {
+				list.Append(lua.LString(item))
+			}
+
+			table.RawSetString(k, list)
+		default:
+			table.RawSetString(k, lua.LString(fmt.Sprint(v)))
+		}
+
+		return true
+	})
+
+	if err := s.state.CallByParam(lua.P{
+		Fn:      s.state.GetGlobal("score"),
+		NRet:    1,
+		Protect: true,
+	}, table); err != nil {
+		return 0, err
+	}
+
+	ret := s.state.Get(-1)
+	s.state.Pop(1)
+
+	if n, ok := ret.(lua.LNumber); ok {
+		return float64(n), nil
+	}
+
+	return 0, nil
+}
+
+// Score returns the score of the event, and the names of the matching
+// rules.
+func (s *Scorer) Score(e event.Event) (float64, []string) {
+	score := 0.0
+	matched := []string{}
+
+	for _, r := range s.Rules {
+		if !r.Matches(e) {
+			continue
+		}
+
+		score += r.Weight
+
+		if r.Name != "" {
+			matched = append(matched, r.Name)
+		}
+	}
+
+	if s.state != nil {
+		v, err := s.script(e)
+		if err != nil {
+			log.Errorf("Error running severity script: %s", err.Error())
+		}
+
+		score += v
+	}
+
+	return score, matched
+}
+
+// Send assigns the severity to the event, a higher severity assigned
+// earlier (eg. by the canaries) is kept.
+func (s *Scorer) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	score, matched := s.Score(e)
+
+	e.Store("severity.score", score)
+
+	if len(matched) > 0 {
+		e.Store("severity.rules", matched)
+	}
+
+	if severity := s.Thresholds.Severity(score); Compare(severity, e.Get("severity")) > 0 {
+		e.Store("severity", severity)
+	}
+}
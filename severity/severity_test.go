@@ -0,0 +1,115 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package severity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestScorer(t *testing.T) {
+	s, err := New(func(s *Scorer) error {
+		s.Rules = []*Rule{
+			{Name: "ssh", Services: []string{"ssh"}, Weight: 5},
+			{Name: "mirai", Signatures: []string{"mirai-scan"}, Weight: 30},
+			{Name: "exploit", Field: "signature.cve", Match: "^CVE-", Weight: 40},
+			{Name: "spray", Field: "credentials.kind", Match: "spray", Weight: 20},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		options  []event.Option
+		severity string
+	}{
+		{[]event.Option{event.Service("http")}, Info},
+		{[]event.Option{event.Service("ssh")}, Info},
+		{[]event.Option{event.Service("ssh"), event.Custom("signature.id", []string{"mirai-scan"})}, Medium},
+		{[]event.Option{event.Custom("signature.id", []string{"mirai-scan"}), event.Custom("signature.cve", []string{"CVE-2017-17215"})}, High},
+		{[]event.Option{event.Custom("signature.id", []string{"mirai-scan"}), event.Custom("signature.cve", []string{"CVE-2017-17215"}), event.Custom("credentials.kind", "spray")}, Critical},
+		// a higher severity assigned earlier is kept
+		{[]event.Option{event.Service("ssh"), event.Custom("severity", Critical)}, Critical},
+	}
+
+	for i, test := range tests {
+		e := event.New(test.options...)
+		s.Send(e)
+
+		if v := e.Get("severity"); v != test.severity {
+			t.Errorf("Test %d: expected severity %s, got %s", i, test.severity, v)
+		}
+	}
+
+	if _, err := New(func(s *Scorer) error {
+		s.Rules = []*Rule{{Name: "invalid", Match: "x"}}
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for match without field")
+	}
+}
+
+func TestScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "severity")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	script := `
+function score(event)
+	if event["source-ip"] == "192.0.2.1" then
+		return 100
+	end
+
+	return 0
+end
+`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "severity.lua"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(
+		WithDataDir(dir),
+		func(s *Scorer) error {
+			s.Script = "severity.lua"
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := event.New(event.Custom("source-ip", "192.0.2.1"))
+	s.Send(e)
+
+	if v := e.Get("severity"); v != Critical {
+		t.Errorf("Expected critical severity from script, got %s", v)
+	}
+
+	e = event.New(event.Custom("source-ip", "192.0.2.2"))
+	s.Send(e)
+
+	if v := e.Get("severity"); v != Info {
+		t.Errorf("Expected info severity, got %s", v)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package signatures tags events with CVE identifiers and exploit kits,
+// using a signature pack that is maintained as toml files in the data
+// directory. The signature pack is reloaded when the files change.
+package signatures
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:signatures")
+
+func init() {
+	event.RegisterField(event.Field{Name: "signature.id", Type: event.TypeArray, Description: "Identifiers of the matched signatures"})
+	event.RegisterField(event.Field{Name: "signature.cve", Type: event.TypeArray, Description: "CVEs of the matched signatures"})
+	event.RegisterField(event.Field{Name: "signature.exploit-kit", Type: event.TypeArray, Description: "Exploit kits of the matched signatures"})
+}
+
+// Signature matches a pattern against the fields of an event.
+type Signature struct {
+	ID string `toml:"id"`
+
+	// Category is a regular expression matched against the event category,
+	// if empty the signature matches all categories.
+	Category string `toml:"category"`
+
+	// Fields are the event fields the pattern is matched against, wildcards
+	// are supported (eg. http.*). Defaults to the payload, binary patterns
+	// can be matched against payload-hex.
+	Fields []string `toml:"fields"`
+
+	Pattern string `toml:"pattern"`
+
+	CVE        []string `toml:"cve"`
+	ExploitKit string   `toml:"exploit-kit"`
+
+	category *regexp.Regexp
+	pattern  *regexp.Regexp
+}
+
+func (s *Signature) compile() error {
+	if s.ID == "" {
+		return fmt.Errorf("Signature id not set")
+	}
+
+	if s.Pattern == "" {
+		return fmt.Errorf("Signature %s: pattern not set", s.ID)
+	}
+
+	var err error
+	if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+		return fmt.Errorf("Signature %s: %s", s.ID, err.Error())
+	}
+
+	if s.Category != "" {
+		if s.category, err = regexp.Compile(s.Category); err != nil {
+			return fmt.Errorf("Signature %s: %s", s.ID, err.Error())
+		}
+	}
+
+	if len(s.Fields) == 0 {
+		s.Fields = []string{"payload"}
+	}
+
+	return nil
+}
+
+func (s *Signature) field(key string) bool {
+	for _, f := range s.Fields {
+		if ok, _ := path.Match(f, key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match returns true if the signature matches the event.
+func (s *Signature) Match(e event.Event) bool {
+	if s.category != nil && !s.category.MatchString(e.Get("category")) {
+		return false
+	}
+
+	matched := false
+
+	e.Range(func(key, value interface{}) bool {
+		k, ok := key.(string)
+		if !ok || !s.field(k) {
+			return true
+		}
+
+		switch v := value.(type) {
+		case string:
+			matched = s.pattern.MatchString(v)
+		case []byte:
+			matched = s.pattern.Match(v)
+		case []string:
+			for _, vv := range v {
+				if matched = s.pattern.MatchString(vv); matched {
+					break
+				}
+			}
+		default:
+			matched = s.pattern.MatchString(fmt.Sprint(v))
+		}
+
+		return !matched
+	})
+
+	return matched
+}
+
+var builtin = []*Signature{}
+
+// Register adds a built-in signature, that is applied in addition to the
+// signature pack. Register panics when the signature is invalid.
+func Register(s *Signature) *Signature {
+	if err := s.compile(); err != nil {
+		panic(err)
+	}
+
+	builtin = append(builtin, s)
+	return s
+}
+
+// Load reads all signatures from the toml files in the directory.
+func Load(dir string) ([]*Signature, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	signatures := []*Signature{}
+
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		pack := struct {
+			Signatures []*Signature `toml:"signature"`
+		}{}
+
+		if _, err := toml.Decode(string(data), &pack); err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %s", name, err.Error())
+		}
+
+		for _, s := range pack.Signatures {
+			if err := s.compile(); err != nil {
+				return nil, fmt.Errorf("Error parsing %s: %s", name, err.Error())
+			}
+
+			signatures = append(signatures, s)
+		}
+	}
+
+	return signatures, nil
+}
+
+// Tagger attaches the matching signatures to the events it receives. The
+// tagger should be subscribed to the bus before the channels, so the tags
+// are available to all channels.
+type Tagger struct {
+	Directory      string       `toml:"directory"`
+	ReloadInterval config.Delay `toml:"reload-interval"`
+
+	m          sync.RWMutex
+	signatures []*Signature
+
+	// modification state of the signature files of the last load
+	state string
+}
+
+// New returns a new Tagger.
+func New(options ...func(*Tagger) error) (*Tagger, error) {
+	t := &Tagger{
+		Directory:      "signatures",
+		ReloadInterval: config.Delay(time.Minute),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(t); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the signatures configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Tagger) error {
+	return func(t *Tagger) error {
+		return decoder.PrimitiveDecode(c, t)
+	}
+}
+
+// WithDataDir resolves the signature directory relative to the data directory.
+func WithDataDir(dataDir string) func(*Tagger) error {
+	return func(t *Tagger) error {
+		if !filepath.IsAbs(t.Directory) {
+			t.Directory = filepath.Join(dataDir, t.Directory)
+		}
+
+		return nil
+	}
+}
+
+// modState returns a description of the names, sizes and modification
+// times of the signature files, to detect changes.
+func (t *Tagger) modState() string {
+	files, _ := filepath.Glob(filepath.Join(t.Directory, "*.toml"))
+	sort.Strings(files)
+
+	parts := []string{}
+	for _, name := range files {
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", name, fi.Size(), fi.ModTime().UnixNano()))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// Reload loads the signature pack if the files have been changed.
+func (t *Tagger) Reload() error {
+	state := t.modState()
+
+	t.m.RLock()
+	changed := state != t.state
+	t.m.RUnlock()
+
+	if !changed {
+		return nil
+	}
+
+	signatures, err := Load(t.Directory)
+	if err != nil {
+		return err
+	}
+
+	t.m.Lock()
+	t.signatures = signatures
+	t.state = state
+	t.m.Unlock()
+
+	log.Infof("Loaded %d signatures from %s", len(signatures), t.Directory)
+	return nil
+}
+
+// Send attaches the ids, cves and exploit kits of the matching signatures
+// to the event.
+func (t *Tagger) Send(e event.Event) {
+	t.m.RLock()
+	signatures := append(builtin[:len(builtin):len(builtin)], t.signatures...)
+	t.m.RUnlock()
+
+	ids := []string{}
+	cves := []string{}
+	kits := []string{}
+
+	for _, s := range signatures {
+		if !s.Match(e) {
+			continue
+		}
+
+		ids = append(ids, s.ID)
+		cves = append(cves, s.CVE...)
+
+		if s.ExploitKit != "" {
+			kits = append(kits, s.ExploitKit)
+		}
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	e.Store("signature.id", ids)
+
+	if len(cves) > 0 {
+		e.Store("signature.cve", cves)
+	}
+
+	if len(kits) > 0 {
+		e.Store("signature.exploit-kit", kits)
+	}
+}
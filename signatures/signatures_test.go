@@ -0,0 +1,169 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package signatures
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+const pack = `
+[[signature]]
+id = "struts-ognl"
+category = "http"
+fields = ["http.*"]
+pattern = "%\\{.*#_memberAccess"
+cve = ["CVE-2017-5638"]
+
+[[signature]]
+id = "eternalblue"
+fields = ["payload-hex"]
+pattern = "ff534d42"
+cve = ["CVE-2017-0144"]
+exploit-kit = "EternalBlue"
+`
+
+func lookup(e event.Event, key string) interface{} {
+	var value interface{}
+
+	e.Range(func(k, v interface{}) bool {
+		if k == key {
+			value = v
+			return false
+		}
+
+		return true
+	})
+
+	return value
+}
+
+func newTagger(t *testing.T, dir string) *Tagger {
+	tagger, err := New(func(t *Tagger) error {
+		t.Directory = dir
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tagger.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	return tagger
+}
+
+func TestTagger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signatures")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "pack.toml"), []byte(pack), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tagger := newTagger(t, dir)
+
+	e := event.New(
+		event.Category("http"),
+		event.Custom("http.url", "/index.action?%{(#_memberAccess['allowStaticMethodAccess']=true)}"),
+	)
+
+	tagger.Send(e)
+
+	if v := lookup(e, "signature.cve"); !reflect.DeepEqual(v, []string{"CVE-2017-5638"}) {
+		t.Errorf("Expected CVE-2017-5638, got %v", v)
+	}
+
+	// category does not match
+	e = event.New(
+		event.Category("ldap"),
+		event.Custom("http.url", "/index.action?%{(#_memberAccess['allowStaticMethodAccess']=true)}"),
+	)
+
+	tagger.Send(e)
+
+	if v := lookup(e, "signature.id"); v != nil {
+		t.Errorf("Expected no signatures, got %v", v)
+	}
+
+	e = event.New(
+		event.Category("smb"),
+		event.Payload([]byte("\x00\x00\x00\x85\xffSMBr")),
+	)
+
+	tagger.Send(e)
+
+	if v := lookup(e, "signature.exploit-kit"); !reflect.DeepEqual(v, []string{"EternalBlue"}) {
+		t.Errorf("Expected EternalBlue, got %v", v)
+	}
+}
+
+func TestTaggerReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signatures")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	tagger := newTagger(t, dir)
+
+	e := event.New(event.Payload([]byte("\xffSMB")))
+	tagger.Send(e)
+
+	if v := lookup(e, "signature.id"); v != nil {
+		t.Errorf("Expected no signatures, got %v", v)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "pack.toml"), []byte(pack), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tagger.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	tagger.Send(e)
+
+	if v := lookup(e, "signature.id"); !reflect.DeepEqual(v, []string{"eternalblue"}) {
+		t.Errorf("Expected eternalblue, got %v", v)
+	}
+}
+
+func TestInvalidSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signatures")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "pack.toml"), []byte("[[signature]]\nid = \"x\"\npattern = \"(\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(dir); err == nil {
+		t.Errorf("Expected error for invalid pattern")
+	}
+}
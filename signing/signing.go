@@ -0,0 +1,347 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package signing makes the event log tamper-evident. Every event is
+// chained to the previous event by hash and signed with the ed25519 key of
+// the sensor, before it is delivered to the channels.
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+	"golang.org/x/crypto/ed25519"
+)
+
+var log = logging.MustGetLogger("honeytrap:signing")
+
+const (
+	FieldSequence  = "chain.sequence"
+	FieldPrevious  = "chain.previous"
+	FieldHash      = "chain.hash"
+	FieldSignature = "chain.signature"
+	FieldKeyID     = "chain.key-id"
+)
+
+func init() {
+	event.RegisterField(event.Field{Name: FieldSequence, Type: event.TypeInteger, Description: "Sequence number of the event in the chain"})
+	event.RegisterField(event.Field{Name: FieldPrevious, Type: event.TypeString, Description: "Hash of the previous event in the chain"})
+	event.RegisterField(event.Field{Name: FieldHash, Type: event.TypeString, Description: "Hash of the event"})
+	event.RegisterField(event.Field{Name: FieldSignature, Type: event.TypeString, Description: "Ed25519 signature of the hash"})
+	event.RegisterField(event.Field{Name: FieldKeyID, Type: event.TypeString, Description: "Identifier of the signing key"})
+}
+
+// Signer chains and signs the events it receives. The signer should be
+// subscribed to the bus before the channels, so all channels receive the
+// signed events.
+type Signer struct {
+	key ed25519.PrivateKey
+
+	storage storage.Storage
+
+	m        sync.Mutex
+	sequence uint64
+	previous []byte
+}
+
+// New returns a new Signer.
+func New(options ...func(*Signer) error) (*Signer, error) {
+	s := &Signer{
+		previous: make([]byte, sha256.Size),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.key == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		s.key = key
+	}
+
+	return s, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the signing configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Signer) error {
+	return func(s *Signer) error {
+		return decoder.PrimitiveDecode(c, s)
+	}
+}
+
+// WithKey sets the key the events will be signed with.
+func WithKey(key ed25519.PrivateKey) func(*Signer) error {
+	return func(s *Signer) error {
+		s.key = key
+		return nil
+	}
+}
+
+// WithStorage loads the key and the head of the chain from the storage, the
+// key will be generated and persisted when it doesn't exist yet. The head of
+// the chain is persisted for every event, so the chain continues after a
+// restart.
+func WithStorage(st storage.Storage) func(*Signer) error {
+	return func(s *Signer) error {
+		s.storage = st
+
+		if data, err := st.Get("private-key"); err == nil && len(data) == ed25519.PrivateKeySize {
+			s.key = ed25519.PrivateKey(data)
+		} else {
+			log.Debugf("Could not load signing key, generating one.")
+
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return err
+			}
+
+			if err := st.Set("private-key", key); err != nil {
+				return err
+			}
+
+			s.key = key
+		}
+
+		if data, err := st.Get("head"); err == nil && len(data) == 8+sha256.Size {
+			s.sequence = binary.BigEndian.Uint64(data[:8])
+			s.previous = data[8:]
+		}
+
+		return nil
+	}
+}
+
+// PublicKey returns the public key to verify the signatures with.
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// KeyID returns the identifier of the signing key.
+func (s *Signer) KeyID() string {
+	return KeyID(s.PublicKey())
+}
+
+// KeyID returns the identifier of the public key.
+func KeyID(pub ed25519.PublicKey) string {
+	hash := sha256.Sum256(pub)
+	return hex.EncodeToString(hash[:8])
+}
+
+// unsigned returns whether the field is left out of the hash, these are the
+// chain fields and the fields stamped on the events per channel (the token
+// and labels), after the events have been signed.
+func unsigned(k string) bool {
+	return strings.HasPrefix(k, "chain.") || strings.HasPrefix(k, "labels.") || k == "token"
+}
+
+// Digest returns the hash of the event fields (excluding the chain fields
+// and the fields set per channel) chained to the previous hash. The fields
+// are encoded as json with sorted keys, so exported events can be verified.
+func Digest(fields map[string]interface{}, previous []byte, sequence uint64) ([]byte, error) {
+	m := map[string]interface{}{}
+	for k, v := range fields {
+		if unsigned(k) {
+			continue
+		}
+
+		m[k] = v
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// normalize the values to their json representation
+	m = map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	if data, err = json.Marshal(m); err != nil {
+		return nil, err
+	}
+
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, sequence)
+
+	h := sha256.New()
+	h.Write(previous)
+	h.Write(seq)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// Send chains and signs the event.
+func (s *Signer) Send(e event.Event) {
+	fields := map[string]interface{}{}
+	e.Range(func(key, value interface{}) bool {
+		if k, ok := key.(string); ok {
+			fields[k] = value
+		}
+
+		return true
+	})
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	sequence := s.sequence + 1
+
+	digest, err := Digest(fields, s.previous, sequence)
+	if err != nil {
+		log.Errorf("Error hashing event: %s", err.Error())
+		return
+	}
+
+	e.Store(FieldSequence, sequence)
+	e.Store(FieldPrevious, hex.EncodeToString(s.previous))
+	e.Store(FieldHash, hex.EncodeToString(digest))
+	e.Store(FieldSignature, hex.EncodeToString(ed25519.Sign(s.key, digest)))
+	e.Store(FieldKeyID, s.KeyID())
+
+	s.sequence = sequence
+	s.previous = digest
+
+	if s.storage == nil {
+		return
+	}
+
+	head := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(head, sequence)
+
+	if err := s.storage.Set("head", append(head, digest...)); err != nil {
+		log.Errorf("Error persisting chain head: %s", err.Error())
+	}
+}
+
+func stringField(fields map[string]interface{}, key string) (string, error) {
+	v, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("Field %s missing", key)
+	}
+
+	return v, nil
+}
+
+// Verify verifies the hash, signature and chaining of the events, in the
+// order they were signed. The events should be a complete log, events
+// removed by channel filters will break the chain.
+func Verify(pub ed25519.PublicKey, events []map[string]interface{}) error {
+	var previous []byte
+
+	for i, fields := range events {
+		seq, ok := fields[FieldSequence].(float64)
+		if !ok {
+			return fmt.Errorf("Event %d: field %s missing", i, FieldSequence)
+		}
+
+		prevHex, err := stringField(fields, FieldPrevious)
+		if err != nil {
+			return fmt.Errorf("Event %d: %s", i, err.Error())
+		}
+
+		prev, err := hex.DecodeString(prevHex)
+		if err != nil {
+			return fmt.Errorf("Event %d: %s", i, err.Error())
+		}
+
+		if previous != nil && !bytes.Equal(previous, prev) {
+			return fmt.Errorf("Event %d: chain broken, previous hash doesn't match", i)
+		}
+
+		digest, err := Digest(fields, prev, uint64(seq))
+		if err != nil {
+			return fmt.Errorf("Event %d: %s", i, err.Error())
+		}
+
+		hashHex, err := stringField(fields, FieldHash)
+		if err != nil {
+			return fmt.Errorf("Event %d: %s", i, err.Error())
+		}
+
+		if hex.EncodeToString(digest) != hashHex {
+			return fmt.Errorf("Event %d: hash doesn't match, event has been modified", i)
+		}
+
+		sigHex, err := stringField(fields, FieldSignature)
+		if err != nil {
+			return fmt.Errorf("Event %d: %s", i, err.Error())
+		}
+
+		sig, err := hex.DecodeString(sigHex)
+		if err != nil {
+			return fmt.Errorf("Event %d: %s", i, err.Error())
+		}
+
+		if !ed25519.Verify(pub, digest, sig) {
+			return fmt.Errorf("Event %d: invalid signature", i)
+		}
+
+		previous = digest
+	}
+
+	return nil
+}
+
+// VerifyJSON verifies a log of newline delimited json events, as written by
+// the file channel.
+func VerifyJSON(pub ed25519.PublicKey, r io.Reader) error {
+	events := []map[string]interface{}{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			return err
+		}
+
+		events = append(events, fields)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return Verify(pub, events)
+}
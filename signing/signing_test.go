@@ -0,0 +1,141 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package signing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"golang.org/x/crypto/ed25519"
+)
+
+type memoryStorage map[string][]byte
+
+func (s memoryStorage) Get(key string) ([]byte, error) {
+	v, ok := s[key]
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return v, nil
+}
+
+func (s memoryStorage) Set(key string, data []byte) error {
+	s[key] = append([]byte{}, data...)
+	return nil
+}
+
+var errNotFound = errors.New("not found")
+
+// export writes the events as newline delimited json, like the file channel.
+func export(t *testing.T, events []event.Event) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+
+	for _, e := range events {
+		if err := json.NewEncoder(buf).Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf
+}
+
+func signedEvents(t *testing.T, s *Signer, n int) []event.Event {
+	events := []event.Event{}
+
+	for i := 0; i < n; i++ {
+		e := event.New(
+			event.Category("ssh"),
+			event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234 + i}),
+			event.Custom("ssh.password", "toor"),
+			event.Payload([]byte("uname -a")),
+		)
+
+		s.Send(e)
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestSignAndVerify(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := signedEvents(t, s, 3)
+
+	if err := VerifyJSON(s.PublicKey(), export(t, events)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := signedEvents(t, s, 3)
+
+	data := export(t, events).String()
+
+	// modified event
+	tampered := strings.Replace(data, "toor", "root", 1)
+	if err := VerifyJSON(s.PublicKey(), strings.NewReader(tampered)); err == nil {
+		t.Errorf("Expected modified event to fail verification")
+	}
+
+	// removed event
+	lines := strings.SplitAfter(data, "\n")
+	removed := lines[0] + lines[2]
+	if err := VerifyJSON(s.PublicKey(), strings.NewReader(removed)); err == nil {
+		t.Errorf("Expected removed event to fail verification")
+	}
+
+	// other key
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := VerifyJSON(pub, strings.NewReader(data)); err == nil {
+		t.Errorf("Expected verification with other key to fail")
+	}
+}
+
+func TestChainContinuesAfterRestart(t *testing.T) {
+	st := memoryStorage{}
+
+	s, err := New(WithStorage(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := signedEvents(t, s, 2)
+
+	s, err = New(WithStorage(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events = append(events, signedEvents(t, s, 2)...)
+
+	if err := VerifyJSON(s.PublicKey(), export(t, events)); err != nil {
+		t.Fatal(err)
+	}
+}
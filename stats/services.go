@@ -0,0 +1,223 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// CredentialCount contains the number of attempts of a credential.
+type CredentialCount struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Count    int    `json:"count"`
+}
+
+// ServiceStats contains the rolling statistics of a service.
+type ServiceStats struct {
+	Service string `json:"service"`
+
+	Connections    int               `json:"connections"`
+	UniqueSources  int               `json:"unique-sources"`
+	TopCredentials []CredentialCount `json:"top-credentials"`
+
+	// AverageSessionLength is the average session length in seconds
+	AverageSessionLength float64 `json:"average-session-length"`
+}
+
+type credential struct {
+	username string
+	password string
+}
+
+// serviceBucket contains the counters of a service within an hour.
+type serviceBucket struct {
+	connections int
+	sources     map[string]struct{}
+	credentials map[credential]int
+
+	sessions int
+	duration float64
+}
+
+// sendService updates the service counters of the hour with the event.
+func (s *Stats) sendService(service string, hour time.Time, e event.Event) {
+	buckets, ok := s.services[service]
+	if !ok {
+		buckets = map[time.Time]*serviceBucket{}
+		s.services[service] = buckets
+	}
+
+	b, ok := buckets[hour]
+	if !ok {
+		b = &serviceBucket{
+			sources:     map[string]struct{}{},
+			credentials: map[credential]int{},
+		}
+
+		buckets[hour] = b
+	}
+
+	if ip := e.Get("source-ip"); ip != "" {
+		b.sources[ip] = struct{}{}
+	}
+
+	c := credential{}
+
+	e.Range(func(k, v interface{}) bool {
+		key := fmt.Sprint(k)
+
+		switch {
+		case strings.HasSuffix(key, ".username"):
+			c.username = fmt.Sprint(v)
+		case strings.HasSuffix(key, ".password"):
+			c.password = fmt.Sprint(v)
+		}
+
+		return true
+	})
+
+	if c.username != "" || c.password != "" {
+		b.credentials[c]++
+	}
+
+	if e.Get("type") != "SERVICE:ENDED" {
+		return
+	}
+
+	b.connections++
+
+	if duration, err := strconv.ParseFloat(get(e, "session.duration"), 64); err == nil {
+		b.sessions++
+		b.duration += duration
+	}
+}
+
+// Services returns the names of the services with statistics.
+func (s *Stats) Services() []string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	services := []string{}
+	for service := range s.services {
+		services = append(services, service)
+	}
+
+	sort.Strings(services)
+	return services
+}
+
+// Service returns the rolling statistics of the service with the n most
+// used credentials, if n is zero all credentials will be returned.
+func (s *Stats) Service(service string, n int) (ServiceStats, bool) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	buckets, ok := s.services[service]
+	if !ok {
+		return ServiceStats{}, false
+	}
+
+	return s.serviceStats(service, buckets, n), true
+}
+
+// ServiceStats returns the rolling statistics of all services.
+func (s *Stats) ServiceStats(n int) []ServiceStats {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	all := []ServiceStats{}
+	for service, buckets := range s.services {
+		all = append(all, s.serviceStats(service, buckets, n))
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Service < all[j].Service
+	})
+
+	return all
+}
+
+func (s *Stats) serviceStats(service string, buckets map[time.Time]*serviceBucket, n int) ServiceStats {
+	now := s.now().UTC()
+
+	sources := map[string]struct{}{}
+	credentials := map[credential]int{}
+
+	sessions := 0
+	duration := float64(0)
+
+	ss := ServiceStats{
+		Service:        service,
+		TopCredentials: []CredentialCount{},
+	}
+
+	for hour, b := range buckets {
+		if now.Sub(hour) > time.Duration(s.ServiceRetention)*time.Hour {
+			continue
+		}
+
+		ss.Connections += b.connections
+
+		for ip := range b.sources {
+			sources[ip] = struct{}{}
+		}
+
+		for c, count := range b.credentials {
+			credentials[c] += count
+		}
+
+		sessions += b.sessions
+		duration += b.duration
+	}
+
+	ss.UniqueSources = len(sources)
+
+	if sessions > 0 {
+		ss.AverageSessionLength = duration / float64(sessions)
+	}
+
+	for c, count := range credentials {
+		ss.TopCredentials = append(ss.TopCredentials, CredentialCount{
+			Username: c.username,
+			Password: c.password,
+			Count:    count,
+		})
+	}
+
+	sort.Slice(ss.TopCredentials, func(i, j int) bool {
+		a, b := ss.TopCredentials[i], ss.TopCredentials[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+
+		if a.Username != b.Username {
+			return a.Username < b.Username
+		}
+
+		return a.Password < b.Password
+	})
+
+	if n > 0 && len(ss.TopCredentials) > n {
+		ss.TopCredentials = ss.TopCredentials[:n]
+	}
+
+	return ss
+}
@@ -0,0 +1,319 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package stats maintains aggregated counters of the events seen by
+// honeytrap. The counters are updated incrementally for every event, so
+// consumers can query them without scanning the raw events.
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+const (
+	// DefaultHourlyRetention is the number of hours events per hour are kept
+	DefaultHourlyRetention = 48
+
+	// DefaultDailyRetention is the number of days unique sources per day are kept
+	DefaultDailyRetention = 30
+
+	// DefaultServiceRetention is the number of hours of the rolling service statistics
+	DefaultServiceRetention = 24
+
+	// MaxFingerprints is the number of distinct client fingerprints counted,
+	// fingerprints seen after the limit is reached are ignored
+	MaxFingerprints = 10000
+)
+
+// HourCount contains the number of events per service within an hour.
+type HourCount struct {
+	Hour     time.Time      `json:"hour"`
+	Services map[string]int `json:"services"`
+}
+
+// DayCount contains the number of unique sources within a day.
+type DayCount struct {
+	Day     time.Time `json:"day"`
+	Sources int       `json:"sources"`
+}
+
+// PortCount contains the number of events for a destination port.
+type PortCount struct {
+	Port  string `json:"port"`
+	Count int    `json:"count"`
+}
+
+// FingerprintCount contains the number of events of a client fingerprint.
+type FingerprintCount struct {
+	Fingerprint string `json:"fingerprint"`
+
+	// Description is the fingerprint before hashing, eg. the header order
+	Description string `json:"description"`
+	Count       int    `json:"count"`
+}
+
+// Stats contains the aggregated counters.
+type Stats struct {
+	m sync.RWMutex
+
+	HourlyRetention  int
+	DailyRetention   int
+	ServiceRetention int
+
+	hourly   map[time.Time]map[string]int
+	daily    map[time.Time]map[string]struct{}
+	ports    map[string]int
+	services map[string]map[time.Time]*serviceBucket
+
+	fingerprints map[string]*FingerprintCount
+
+	now func() time.Time
+}
+
+// New returns a new Stats.
+func New() *Stats {
+	return &Stats{
+		HourlyRetention:  DefaultHourlyRetention,
+		DailyRetention:   DefaultDailyRetention,
+		ServiceRetention: DefaultServiceRetention,
+
+		hourly:   map[time.Time]map[string]int{},
+		daily:    map[time.Time]map[string]struct{}{},
+		ports:    map[string]int{},
+		services: map[string]map[time.Time]*serviceBucket{},
+
+		fingerprints: map[string]*FingerprintCount{},
+
+		now: time.Now,
+	}
+}
+
+func get(e event.Event, key string) string {
+	value := ""
+
+	e.Range(func(k, v interface{}) bool {
+		if k != key {
+			return true
+		}
+
+		value = fmt.Sprint(v)
+		return false
+	})
+
+	return value
+}
+
+// Send updates the counters with the event.
+func (s *Stats) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	now := s.now().UTC()
+
+	hour := now.Truncate(time.Hour)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	service := e.Get("service")
+	if service == "" {
+		service = e.Get("category")
+	}
+
+	if service == "" {
+		service = "unknown"
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.hourly[hour]; !ok {
+		s.hourly[hour] = map[string]int{}
+		s.expire(now)
+	}
+
+	s.hourly[hour][service]++
+
+	if ip := e.Get("source-ip"); ip != "" {
+		if _, ok := s.daily[day]; !ok {
+			s.daily[day] = map[string]struct{}{}
+		}
+
+		s.daily[day][ip] = struct{}{}
+	}
+
+	if port := get(e, "destination-port"); port != "" {
+		s.ports[port]++
+	}
+
+	if fp := e.Get("http.fingerprint"); fp != "" {
+		s.sendFingerprint(fp, e)
+	}
+
+	s.sendService(service, hour, e)
+}
+
+func (s *Stats) sendFingerprint(fp string, e event.Event) {
+	fc, ok := s.fingerprints[fp]
+	if !ok {
+		if len(s.fingerprints) >= MaxFingerprints {
+			return
+		}
+
+		description := e.Get("http.h2-fingerprint")
+		if description == "" {
+			description = e.Get("http.proto") + "|" + e.Get("http.header-order")
+		}
+
+		fc = &FingerprintCount{
+			Fingerprint: fp,
+			Description: description,
+		}
+
+		s.fingerprints[fp] = fc
+	}
+
+	fc.Count++
+}
+
+// expire removes the buckets outside the retention period.
+func (s *Stats) expire(now time.Time) {
+	for hour := range s.hourly {
+		if now.Sub(hour) > time.Duration(s.HourlyRetention)*time.Hour {
+			delete(s.hourly, hour)
+		}
+	}
+
+	for day := range s.daily {
+		if now.Sub(day) > time.Duration(s.DailyRetention)*24*time.Hour {
+			delete(s.daily, day)
+		}
+	}
+
+	for service, buckets := range s.services {
+		for hour := range buckets {
+			if now.Sub(hour) > time.Duration(s.ServiceRetention)*time.Hour {
+				delete(buckets, hour)
+			}
+		}
+
+		if len(buckets) == 0 {
+			delete(s.services, service)
+		}
+	}
+}
+
+// EventsPerHour returns the number of events per service per hour, oldest first.
+func (s *Stats) EventsPerHour() []HourCount {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	counts := []HourCount{}
+	for hour, services := range s.hourly {
+		hc := HourCount{
+			Hour:     hour,
+			Services: map[string]int{},
+		}
+
+		for service, count := range services {
+			hc.Services[service] = count
+		}
+
+		counts = append(counts, hc)
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Hour.Before(counts[j].Hour)
+	})
+
+	return counts
+}
+
+// UniqueSourcesPerDay returns the number of unique sources per day, oldest first.
+func (s *Stats) UniqueSourcesPerDay() []DayCount {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	counts := []DayCount{}
+	for day, sources := range s.daily {
+		counts = append(counts, DayCount{
+			Day:     day,
+			Sources: len(sources),
+		})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Day.Before(counts[j].Day)
+	})
+
+	return counts
+}
+
+// TopPorts returns the n destination ports with the most events, if n is
+// zero all ports will be returned.
+func (s *Stats) TopPorts(n int) []PortCount {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	counts := []PortCount{}
+	for port, count := range s.ports {
+		counts = append(counts, PortCount{
+			Port:  port,
+			Count: count,
+		})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+
+		return counts[i].Port < counts[j].Port
+	})
+
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+
+	return counts
+}
+
+// TopFingerprints returns the n http client fingerprints with the most
+// events, if n is zero all fingerprints will be returned.
+func (s *Stats) TopFingerprints(n int) []FingerprintCount {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	counts := []FingerprintCount{}
+	for _, fc := range s.fingerprints {
+		counts = append(counts, *fc)
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+
+		return counts[i].Fingerprint < counts[j].Fingerprint
+	})
+
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+
+	return counts
+}
@@ -0,0 +1,168 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stats
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestStats(t *testing.T) {
+	now := time.Date(2019, 1, 2, 10, 30, 0, 0, time.UTC)
+
+	s := New()
+	s.now = func() time.Time { return now }
+
+	send := func(service, ip string, port int) {
+		s.Send(event.New(
+			event.Service(service),
+			event.SourceAddr(&net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}),
+			event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.100"), Port: port}),
+		))
+	}
+
+	send("ssh", "192.0.2.1", 22)
+	send("ssh", "192.0.2.1", 22)
+	send("telnet", "192.0.2.2", 23)
+
+	now = now.Add(time.Hour)
+	send("ssh", "192.0.2.3", 22)
+
+	// heartbeats are ignored
+	s.Send(event.New(event.Category("heartbeat")))
+
+	hours := s.EventsPerHour()
+	if len(hours) != 2 {
+		t.Fatalf("Expected 2 hours, got %d", len(hours))
+	}
+
+	if hours[0].Services["ssh"] != 2 || hours[0].Services["telnet"] != 1 {
+		t.Errorf("Unexpected counts for first hour: %v", hours[0].Services)
+	}
+
+	if hours[1].Services["ssh"] != 1 {
+		t.Errorf("Unexpected counts for second hour: %v", hours[1].Services)
+	}
+
+	days := s.UniqueSourcesPerDay()
+	if len(days) != 1 || days[0].Sources != 3 {
+		t.Errorf("Unexpected unique sources: %v", days)
+	}
+
+	ports := s.TopPorts(1)
+	if len(ports) != 1 || ports[0].Port != "22" || ports[0].Count != 3 {
+		t.Errorf("Unexpected top ports: %v", ports)
+	}
+}
+
+func TestStatsRetention(t *testing.T) {
+	now := time.Date(2019, 1, 2, 10, 30, 0, 0, time.UTC)
+
+	s := New()
+	s.HourlyRetention = 2
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		s.Send(event.New(event.Service("ssh")))
+		now = now.Add(time.Hour)
+	}
+
+	if hours := s.EventsPerHour(); len(hours) != 2 {
+		t.Errorf("Expected 2 hours, got %d", len(hours))
+	}
+}
+
+func TestServiceStats(t *testing.T) {
+	now := time.Date(2019, 1, 2, 10, 30, 0, 0, time.UTC)
+
+	s := New()
+	s.now = func() time.Time { return now }
+
+	login := func(ip, username, password string) {
+		s.Send(event.New(
+			event.Service("ssh"),
+			event.SourceAddr(&net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}),
+			event.Custom("ssh.username", username),
+			event.Custom("ssh.password", password),
+		))
+	}
+
+	ended := func(ip string, duration float64) {
+		s.Send(event.New(
+			event.Service("ssh"),
+			event.ServiceEnded,
+			event.SourceAddr(&net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}),
+			event.Custom("session.duration", duration),
+		))
+	}
+
+	login("192.0.2.1", "root", "root")
+	login("192.0.2.1", "root", "root")
+	login("192.0.2.2", "admin", "1234")
+	ended("192.0.2.1", 10)
+	ended("192.0.2.2", 20)
+
+	ss, ok := s.Service("ssh", 1)
+	if !ok {
+		t.Fatal("Expected statistics of ssh")
+	}
+
+	if ss.Connections != 2 || ss.UniqueSources != 2 || ss.AverageSessionLength != 15 {
+		t.Errorf("Unexpected service statistics: %+v", ss)
+	}
+
+	if len(ss.TopCredentials) != 1 || ss.TopCredentials[0].Username != "root" || ss.TopCredentials[0].Count != 2 {
+		t.Errorf("Unexpected top credentials: %v", ss.TopCredentials)
+	}
+
+	// statistics outside the window are ignored
+	now = now.Add(time.Duration(DefaultServiceRetention+1) * time.Hour)
+
+	if ss, _ := s.Service("ssh", 0); ss.Connections != 0 {
+		t.Errorf("Expected expired statistics, got %+v", ss)
+	}
+
+	if _, ok := s.Service("telnet", 0); ok {
+		t.Error("Expected no statistics of telnet")
+	}
+}
+
+func TestTopFingerprints(t *testing.T) {
+	s := New()
+
+	send := func(fp, order string) {
+		s.Send(event.New(
+			event.Service("http"),
+			event.Custom("http.proto", "HTTP/1.1"),
+			event.Custom("http.header-order", order),
+			event.Custom("http.fingerprint", fp),
+		))
+	}
+
+	send("a", "Host,User-Agent")
+	send("b", "host,accept")
+	send("b", "host,accept")
+
+	fps := s.TopFingerprints(1)
+	if len(fps) != 1 {
+		t.Fatalf("Expected 1 fingerprint, got %d", len(fps))
+	}
+
+	if fps[0].Fingerprint != "b" || fps[0].Count != 2 || fps[0].Description != "HTTP/1.1|host,accept" {
+		t.Errorf("Unexpected top fingerprint: %#v", fps[0])
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package tracing records the journeys of the events through the pipeline:
+// the enrichers applied, the filters matched and the channels the events
+// entered and were delivered to, with their timings. The journeys of the
+// last events are kept in a ring buffer, so the question why an event
+// didn't reach a channel can be answered from the journey of the event.
+//
+// Tracing slows down the pipeline and is meant for debugging only.
+package tracing
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// Stages of the pipeline.
+const (
+	StageBus      = "bus"
+	StageEnricher = "enricher"
+	StageFilter   = "filter"
+	StageChannel  = "channel"
+	StageDelivery = "delivery"
+)
+
+// Results of the steps.
+const (
+	ResultPublished = "published"
+	ResultApplied   = "applied"
+	ResultMatched   = "matched"
+	ResultRejected  = "rejected"
+	ResultEntered   = "entered"
+	ResultDelivered = "delivered"
+)
+
+// maxSteps limits the steps of a journey, events sent repeatedly with the
+// same id would grow their journey otherwise.
+const maxSteps = 256
+
+// Step is a stage of the journey of an event.
+type Step struct {
+	// Offset is the time since the event was published on the bus
+	Offset string `json:"offset"`
+
+	Stage  string `json:"stage"`
+	Name   string `json:"name"`
+	Result string `json:"result"`
+
+	// Duration is the time the stage took, for channels it includes the
+	// layers of the channel after it
+	Duration string `json:"duration,omitempty"`
+
+	// Fields are the fields the enricher added or changed
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Journey is the path of an event through the pipeline.
+type Journey struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Category string    `json:"category"`
+	Type     string    `json:"type"`
+	Service  string    `json:"service,omitempty"`
+
+	Steps []Step `json:"steps"`
+}
+
+// Delivered returns true if the event was delivered to the channel.
+func (j Journey) Delivered(channel string) bool {
+	for _, s := range j.Steps {
+		if s.Stage == StageDelivery && s.Name == channel {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (j *Journey) copy() Journey {
+	c := *j
+	c.Steps = append([]Step{}, j.Steps...)
+	return c
+}
+
+// Query selects the journeys.
+type Query struct {
+	// ID selects the journey of the event
+	ID string
+
+	// Undelivered selects the journeys of the events that weren't
+	// delivered to the channel
+	Undelivered string
+
+	// Limit is the maximum number of journeys, zero is unlimited
+	Limit int
+}
+
+// Tracer records the journeys of the events. The methods of a nil Tracer
+// don't record, so the pipeline can be wrapped regardless of whether
+// tracing is enabled.
+type Tracer struct {
+	Enabled bool `toml:"enabled"`
+
+	// Size is the number of journeys kept
+	Size int `toml:"size"`
+
+	m sync.Mutex
+
+	journeys map[string]*Journey
+
+	// ring contains the ids of the journeys in the order they started,
+	// next is the position of the oldest journey
+	ring []string
+	next int
+
+	now func() time.Time
+}
+
+// New returns a new Tracer.
+func New(options ...func(*Tracer) error) (*Tracer, error) {
+	t := &Tracer{
+		Size: 1000,
+
+		journeys: map[string]*Journey{},
+
+		now: time.Now,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(t); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.Size <= 0 {
+		return nil, fmt.Errorf("Invalid size %d of tracing, should be positive", t.Size)
+	}
+
+	t.ring = make([]string, t.Size)
+
+	return t, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the tracing configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Tracer) error {
+	return func(t *Tracer) error {
+		return decoder.PrimitiveDecode(c, t)
+	}
+}
+
+// Send starts the journey of the event. The tracer should be subscribed to
+// the bus first, events without id are stamped with an id to follow them
+// by.
+func (t *Tracer) Send(e event.Event) {
+	id := e.Get("id")
+	if id == "" {
+		id = event.NewID()
+		e.Store("id", id)
+	}
+
+	now := t.now()
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	j, ok := t.journeys[id]
+	if !ok {
+		j = &Journey{
+			ID:       id,
+			Time:     now,
+			Category: e.Get("category"),
+			Type:     e.Get("type"),
+			Service:  e.Get("service"),
+		}
+
+		if old := t.ring[t.next]; old != "" {
+			delete(t.journeys, old)
+		}
+
+		t.ring[t.next] = id
+		t.next = (t.next + 1) % len(t.ring)
+
+		t.journeys[id] = j
+	}
+
+	t.add(j, Step{
+		Offset: now.Sub(j.Time).String(),
+		Stage:  StageBus,
+		Name:   "bus",
+		Result: ResultPublished,
+	})
+}
+
+func (t *Tracer) add(j *Journey, s Step) {
+	if len(j.Steps) >= maxSteps {
+		return
+	}
+
+	j.Steps = append(j.Steps, s)
+}
+
+// record adds the step to the journey of the event, events of which the
+// journey isn't kept anymore are ignored.
+func (t *Tracer) record(id string, start time.Time, s Step) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	j, ok := t.journeys[id]
+	if !ok {
+		return
+	}
+
+	s.Offset = start.Sub(j.Time).String()
+
+	t.add(j, s)
+}
+
+// Channel returns a channel that records the event passing the stage of the
+// pipeline. Enrichers record the fields they changed, the other stages are
+// recorded with the result of the stage.
+func (t *Tracer) Channel(stage, name string, c pushers.Channel) pushers.Channel {
+	if t == nil {
+		return c
+	}
+
+	return &tracingChannel{
+		Channel: c,
+		tracer:  t,
+		stage:   stage,
+		name:    name,
+	}
+}
+
+type tracingChannel struct {
+	pushers.Channel
+
+	tracer *Tracer
+	stage  string
+	name   string
+}
+
+func (tc *tracingChannel) Send(e event.Event) {
+	id := e.Get("id")
+	if id == "" {
+		tc.Channel.Send(e)
+		return
+	}
+
+	var before map[string]string
+	if tc.stage == StageEnricher {
+		before = fields(e)
+	}
+
+	start := tc.tracer.now()
+	tc.Channel.Send(e)
+	duration := tc.tracer.now().Sub(start)
+
+	s := Step{
+		Stage:    tc.stage,
+		Name:     tc.name,
+		Result:   ResultEntered,
+		Duration: duration.String(),
+	}
+
+	switch tc.stage {
+	case StageEnricher:
+		s.Result = ResultApplied
+
+		for k, v := range fields(e) {
+			if prev, ok := before[k]; !ok || prev != v {
+				s.Fields = append(s.Fields, k)
+			}
+		}
+
+		sort.Strings(s.Fields)
+	case StageDelivery:
+		s.Result = ResultDelivered
+	}
+
+	tc.tracer.record(id, start, s)
+}
+
+func fields(e event.Event) map[string]string {
+	m := map[string]string{}
+
+	e.Range(func(key, value interface{}) bool {
+		if k, ok := key.(string); ok {
+			m[k] = fmt.Sprint(value)
+		}
+
+		return true
+	})
+
+	return m
+}
+
+// Filter returns a filter that records whether the event matched the
+// filter.
+func (t *Tracer) Filter(name string, fn pushers.FilterFunc) pushers.FilterFunc {
+	if t == nil {
+		return fn
+	}
+
+	return func(e event.Event) bool {
+		start := t.now()
+		ok := fn(e)
+
+		if id := e.Get("id"); id != "" {
+			result := ResultRejected
+			if ok {
+				result = ResultMatched
+			}
+
+			t.record(id, start, Step{
+				Stage:  StageFilter,
+				Name:   name,
+				Result: result,
+			})
+		}
+
+		return ok
+	}
+}
+
+// Journeys returns the journeys selected by the query, newest first.
+func (t *Tracer) Journeys(q Query) []Journey {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	result := []Journey{}
+
+	for i := 1; i <= len(t.ring); i++ {
+		if q.Limit > 0 && len(result) >= q.Limit {
+			break
+		}
+
+		id := t.ring[(t.next-i+len(t.ring))%len(t.ring)]
+		if id == "" {
+			break
+		}
+
+		if q.ID != "" && q.ID != id {
+			continue
+		}
+
+		j := t.journeys[id]
+		if q.Undelivered != "" && j.Delivered(q.Undelivered) {
+			continue
+		}
+
+		result = append(result, j.copy())
+	}
+
+	return result
+}
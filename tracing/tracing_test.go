@@ -0,0 +1,131 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tracing
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/utils/harness"
+)
+
+func newTracer(t *testing.T, size int) *Tracer {
+	tr, err := New(func(tr *Tracer) error {
+		tr.Size = size
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tr
+}
+
+func TestJourney(t *testing.T) {
+	tr := newTracer(t, 10)
+
+	delivered := harness.NewRecorder()
+
+	enricher := tr.Channel(StageEnricher, "severity", harness.NewRecorder(event.Custom("severity", "high")))
+	backend := tr.Channel(StageDelivery, "elasticsearch", delivered)
+
+	channel := pushers.FilterChannel(backend, tr.Filter("elasticsearch/categories", pushers.RegexFilterFunc("category", []string{"ssh"})))
+
+	for _, category := range []string{"ssh", "telnet"} {
+		e := event.New(event.Category(category), event.Type("login"))
+
+		tr.Send(e)
+		enricher.Send(e)
+		channel.Send(e)
+	}
+
+	if n := len(delivered.Events()); n != 1 {
+		t.Fatalf("Expected 1 delivered event, got %d", n)
+	}
+
+	journeys := tr.Journeys(Query{})
+	if len(journeys) != 2 {
+		t.Fatalf("Expected 2 journeys, got %d", len(journeys))
+	}
+
+	// newest first
+	if journeys[0].Category != "telnet" || journeys[1].Category != "ssh" {
+		t.Fatalf("Expected telnet and ssh journeys, got %s and %s", journeys[0].Category, journeys[1].Category)
+	}
+
+	results := func(j Journey) []string {
+		r := []string{}
+		for _, s := range j.Steps {
+			r = append(r, s.Stage+":"+s.Result)
+		}
+		return r
+	}
+
+	if r := results(journeys[1]); !reflect.DeepEqual(r, []string{"bus:published", "enricher:applied", "filter:matched", "delivery:delivered"}) {
+		t.Errorf("Unexpected steps of delivered event: %v", r)
+	}
+
+	if r := results(journeys[0]); !reflect.DeepEqual(r, []string{"bus:published", "enricher:applied", "filter:rejected"}) {
+		t.Errorf("Unexpected steps of filtered event: %v", r)
+	}
+
+	if f := journeys[0].Steps[1].Fields; !reflect.DeepEqual(f, []string{"severity"}) {
+		t.Errorf("Expected severity field changed by enricher, got %v", f)
+	}
+
+	undelivered := tr.Journeys(Query{Undelivered: "elasticsearch"})
+	if len(undelivered) != 1 || undelivered[0].Category != "telnet" {
+		t.Errorf("Expected the telnet event undelivered, got %v", undelivered)
+	}
+
+	if j := tr.Journeys(Query{ID: journeys[1].ID}); len(j) != 1 || j[0].ID != journeys[1].ID {
+		t.Errorf("Expected journey %s, got %v", journeys[1].ID, j)
+	}
+}
+
+func TestRing(t *testing.T) {
+	tr := newTracer(t, 3)
+
+	for i := 0; i < 5; i++ {
+		tr.Send(event.New(event.Category("test")))
+	}
+
+	journeys := tr.Journeys(Query{})
+	if len(journeys) != 3 {
+		t.Fatalf("Expected 3 journeys, got %d", len(journeys))
+	}
+
+	if len(tr.journeys) != 3 {
+		t.Errorf("Expected evicted journeys to be removed, got %d", len(tr.journeys))
+	}
+
+	if j := tr.Journeys(Query{Limit: 2}); len(j) != 2 || j[0].ID != journeys[0].ID {
+		t.Errorf("Expected the 2 newest journeys, got %v", j)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *Tracer
+
+	c := harness.NewRecorder()
+	if r, ok := tr.Channel(StageChannel, "test", c).(*harness.Recorder); !ok || r != c {
+		t.Errorf("Expected channel unchanged without tracer")
+	}
+
+	if fn := tr.Filter("test", nil); fn != nil {
+		t.Errorf("Expected filter unchanged without tracer")
+	}
+}
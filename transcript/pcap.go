@@ -0,0 +1,336 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package transcript
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// maxSegmentSize is the maximum payload of the synthesized tcp segments
+const maxSegmentSize = 1460
+
+var (
+	clientMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	serverMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+type packet struct {
+	time time.Time
+	data []byte
+}
+
+// endpoint is one side of the synthesized connection.
+type endpoint struct {
+	mac  net.HardwareAddr
+	ip   net.IP
+	port int
+
+	// seq is the next sequence number of the endpoint
+	seq uint32
+}
+
+type synthesizer struct {
+	t *Transcript
+
+	client, server *endpoint
+
+	// now is the timestamp of the last packet, packets with the same
+	// record time are spaced a microsecond apart to keep their order
+	now time.Time
+
+	packets []packet
+}
+
+func (s *synthesizer) at(t time.Time) time.Time {
+	if !t.After(s.now) {
+		t = s.now.Add(time.Microsecond)
+	}
+
+	s.now = t
+	return t
+}
+
+func (s *synthesizer) network(src, dst *endpoint) (gopacket.SerializableLayer, gopacket.NetworkLayer, layers.EthernetType) {
+	if src.ip.To4() != nil && dst.ip.To4() != nil {
+		ip := &layers.IPv4{
+			Version: 4,
+			TTL:     64,
+			SrcIP:   src.ip.To4(),
+			DstIP:   dst.ip.To4(),
+		}
+
+		if s.t.Network == "udp" {
+			ip.Protocol = layers.IPProtocolUDP
+		} else {
+			ip.Protocol = layers.IPProtocolTCP
+		}
+
+		return ip, ip, layers.EthernetTypeIPv4
+	}
+
+	ip := &layers.IPv6{
+		Version:  6,
+		HopLimit: 64,
+		SrcIP:    src.ip.To16(),
+		DstIP:    dst.ip.To16(),
+	}
+
+	if s.t.Network == "udp" {
+		ip.NextHeader = layers.IPProtocolUDP
+	} else {
+		ip.NextHeader = layers.IPProtocolTCP
+	}
+
+	return ip, ip, layers.EthernetTypeIPv6
+}
+
+func (s *synthesizer) write(t time.Time, src, dst *endpoint, transport gopacket.SerializableLayer, payload []byte) error {
+	ip, nl, ethernetType := s.network(src, dst)
+
+	switch v := transport.(type) {
+	case *layers.TCP:
+		v.SetNetworkLayerForChecksum(nl)
+	case *layers.UDP:
+		v.SetNetworkLayerForChecksum(nl)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	},
+		&layers.Ethernet{
+			SrcMAC:       src.mac,
+			DstMAC:       dst.mac,
+			EthernetType: ethernetType,
+		},
+		ip,
+		transport,
+		gopacket.Payload(payload),
+	); err != nil {
+		return err
+	}
+
+	s.packets = append(s.packets, packet{
+		time: s.at(t),
+		data: buf.Bytes(),
+	})
+
+	return nil
+}
+
+func (s *synthesizer) tcp(t time.Time, src, dst *endpoint, payload []byte, syn, ack, psh, fin bool) error {
+	segment := &layers.TCP{
+		SrcPort: layers.TCPPort(src.port),
+		DstPort: layers.TCPPort(dst.port),
+		Seq:     src.seq,
+		SYN:     syn,
+		ACK:     ack,
+		PSH:     psh,
+		FIN:     fin,
+		Window:  65535,
+	}
+
+	if ack {
+		segment.Ack = dst.seq
+	}
+
+	if err := s.write(t, src, dst, segment, payload); err != nil {
+		return err
+	}
+
+	src.seq += uint32(len(payload))
+	if syn || fin {
+		src.seq++
+	}
+
+	return nil
+}
+
+func (s *synthesizer) synthesizeTCP() error {
+	c, sv := s.client, s.server
+
+	// handshake
+	if err := s.tcp(s.t.Start, c, sv, nil, true, false, false, false); err != nil {
+		return err
+	}
+
+	if err := s.tcp(s.t.Start, sv, c, nil, true, true, false, false); err != nil {
+		return err
+	}
+
+	if err := s.tcp(s.t.Start, c, sv, nil, false, true, false, false); err != nil {
+		return err
+	}
+
+	for _, r := range s.t.Records {
+		src, dst := c, sv
+		if r.Direction == DirectionServer {
+			src, dst = sv, c
+		}
+
+		for data := r.Data; len(data) > 0; {
+			n := len(data)
+			if n > maxSegmentSize {
+				n = maxSegmentSize
+			}
+
+			if err := s.tcp(r.Time, src, dst, data[:n], false, true, true, false); err != nil {
+				return err
+			}
+
+			data = data[n:]
+		}
+
+		if err := s.tcp(r.Time, dst, src, nil, false, true, false, false); err != nil {
+			return err
+		}
+	}
+
+	end := s.t.End
+	if end.IsZero() {
+		end = s.now
+	}
+
+	// the honeypot closes the connection
+	if err := s.tcp(end, sv, c, nil, false, true, false, true); err != nil {
+		return err
+	}
+
+	if err := s.tcp(end, c, sv, nil, false, true, false, true); err != nil {
+		return err
+	}
+
+	return s.tcp(end, sv, c, nil, false, true, false, false)
+}
+
+func (s *synthesizer) synthesizeUDP() error {
+	for _, r := range s.t.Records {
+		src, dst := s.client, s.server
+		if r.Direction == DirectionServer {
+			src, dst = s.server, s.client
+		}
+
+		datagram := &layers.UDP{
+			SrcPort: layers.UDPPort(src.port),
+			DstPort: layers.UDPPort(dst.port),
+		}
+
+		if err := s.write(r.Time, src, dst, datagram, r.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isn returns the initial sequence number of an endpoint, derived from the
+// transcript id so the exports of a transcript are identical.
+func isn(id string, side string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(id + side))
+	return h.Sum32()
+}
+
+func synthesize(t *Transcript) ([]packet, error) {
+	// the pseudonyms can't be written as addresses, and the real addresses
+	// aren't kept
+	if t.Pseudonymized {
+		return nil, ErrPseudonymized
+	}
+
+	clientIP, serverIP := net.ParseIP(t.SourceIP), net.ParseIP(t.DestinationIP)
+	if clientIP == nil || serverIP == nil {
+		return nil, fmt.Errorf("Transcript %s: invalid addresses %s and %s", t.ID, t.SourceIP, t.DestinationIP)
+	}
+
+	// the addresses should be of the same family
+	if (clientIP.To4() == nil) != (serverIP.To4() == nil) {
+		clientIP, serverIP = clientIP.To16(), serverIP.To16()
+	}
+
+	s := &synthesizer{
+		t: t,
+		client: &endpoint{
+			mac:  clientMAC,
+			ip:   clientIP,
+			port: t.SourcePort,
+			seq:  isn(t.ID, DirectionClient),
+		},
+		server: &endpoint{
+			mac:  serverMAC,
+			ip:   serverIP,
+			port: t.DestinationPort,
+			seq:  isn(t.ID, DirectionServer),
+		},
+		now: t.Start.Add(-time.Microsecond),
+	}
+
+	var err error
+	if t.Network == "udp" {
+		err = s.synthesizeUDP()
+	} else {
+		err = s.synthesizeTCP()
+	}
+
+	return s.packets, err
+}
+
+// WritePCAP writes the transcripts as pcap, with the packets of the
+// sessions ordered by time. Tcp sessions are synthesized with a handshake,
+// acknowledgements and a close of the connection.
+func WritePCAP(w io.Writer, transcripts ...*Transcript) error {
+	packets := []packet{}
+
+	for _, t := range transcripts {
+		p, err := synthesize(t)
+		if err != nil {
+			return err
+		}
+
+		packets = append(packets, p...)
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].time.Before(packets[j].time)
+	})
+
+	pw := pcapgo.NewWriter(w)
+
+	if err := pw.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		return err
+	}
+
+	for _, p := range packets {
+		if err := pw.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     p.time,
+			CaptureLength: len(p.data),
+			Length:        len(p.data),
+		}, p.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
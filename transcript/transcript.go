@@ -0,0 +1,361 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package transcript records the data exchanged in the sessions handled by
+// the services, and converts the recorded transcripts into pcap files.
+package transcript
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	logging "github.com/op/go-logging"
+	"github.com/rs/xid"
+)
+
+var log = logging.MustGetLogger("honeytrap:transcript")
+
+var ErrInvalidID = errors.New("Invalid transcript id")
+
+var ErrPseudonymized = errors.New("Transcript source is pseudonymized, pcap export refused")
+
+var validID = regexp.MustCompile(`^[0-9a-z]+$`)
+
+// Direction of the recorded data.
+const (
+	DirectionClient = "client"
+	DirectionServer = "server"
+)
+
+// Record is the data of a single read or write.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Data      []byte    `json:"data"`
+}
+
+// Transcript contains the data of a session, in the order it was exchanged.
+type Transcript struct {
+	ID      string `json:"id"`
+	Service string `json:"service"`
+	Network string `json:"network"`
+
+	SourceIP        string `json:"source-ip"`
+	SourcePort      int    `json:"source-port"`
+	DestinationIP   string `json:"destination-ip"`
+	DestinationPort int    `json:"destination-port"`
+
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Truncated is set when the session exceeded the maximum size
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Pseudonymized is set when the source ip is a pseudonym of privacy
+	// mode
+	Pseudonymized bool `json:"pseudonymized,omitempty"`
+
+	Records []Record `json:"records"`
+}
+
+// Summary describes a stored transcript.
+type Summary struct {
+	ID      string    `json:"id"`
+	Service string    `json:"service"`
+	Source  string    `json:"source"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Records int       `json:"records"`
+}
+
+// ReadFile reads a transcript file.
+func ReadFile(p string) (*Transcript, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transcript{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Store records the sessions and stores the transcripts in the data
+// directory.
+type Store struct {
+	Enabled bool `toml:"enabled"`
+
+	// Directory is relative to the data directory
+	Directory string `toml:"directory"`
+
+	// MaxSize is the maximum number of bytes recorded per session
+	MaxSize int `toml:"max-size"`
+
+	dataDir string
+
+	pseudonymize func(string) string
+}
+
+// New returns a new Store.
+func New(options ...func(*Store) error) (*Store, error) {
+	s := &Store{
+		Directory: "transcripts",
+		MaxSize:   1024 * 1024,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the transcript configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Store) error {
+	return func(s *Store) error {
+		return decoder.PrimitiveDecode(c, s)
+	}
+}
+
+// WithDataDir sets the data directory the transcripts are stored in.
+func WithDataDir(dataDir string) func(*Store) error {
+	return func(s *Store) error {
+		s.dataDir = dataDir
+		return nil
+	}
+}
+
+// WithPseudonymizer pseudonymizes the source ips of the transcripts, as
+// privacy mode pseudonymizes the events.
+func WithPseudonymizer(fn func(string) string) func(*Store) error {
+	return func(s *Store) error {
+		s.pseudonymize = fn
+		return nil
+	}
+}
+
+func (s *Store) dir() string {
+	if filepath.IsAbs(s.Directory) {
+		return s.Directory
+	}
+
+	return filepath.Join(s.dataDir, s.Directory)
+}
+
+func (s *Store) path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", ErrInvalidID
+	}
+
+	return filepath.Join(s.dir(), id+".json"), nil
+}
+
+func (s *Store) save(t *Transcript) error {
+	if err := os.MkdirAll(s.dir(), 0750); err != nil {
+		return err
+	}
+
+	p, err := s.path(t.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, data, 0640)
+}
+
+// Load returns the transcript with the id.
+func (s *Store) Load(id string) (*Transcript, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadFile(p)
+}
+
+// List returns the summaries of the stored transcripts, newest first.
+func (s *Store) List() ([]Summary, error) {
+	files, err := ioutil.ReadDir(s.dir())
+	if os.IsNotExist(err) {
+		return []Summary{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := []Summary{}
+
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+
+		t, err := ReadFile(filepath.Join(s.dir(), fi.Name()))
+		if err != nil {
+			log.Errorf("Error reading transcript %s: %s", fi.Name(), err.Error())
+			continue
+		}
+
+		result = append(result, Summary{
+			ID:      t.ID,
+			Service: t.Service,
+			Source:  net.JoinHostPort(t.SourceIP, strconv.Itoa(t.SourcePort)),
+			Start:   t.Start,
+			End:     t.End,
+			Records: len(t.Records),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.After(result[j].Start)
+	})
+
+	return result, nil
+}
+
+func addr(a net.Addr) (string, int) {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		return v.IP.String(), v.Port
+	case *net.UDPAddr:
+		return v.IP.String(), v.Port
+	}
+
+	return "", 0
+}
+
+// Record returns a connection that records the data read and written, the
+// transcript is stored when the connection is closed.
+func (s *Store) Record(conn net.Conn, service string) *Conn {
+	t := &Transcript{
+		ID:      xid.New().String(),
+		Service: service,
+		Network: conn.RemoteAddr().Network(),
+		Start:   time.Now(),
+		Records: []Record{},
+	}
+
+	t.SourceIP, t.SourcePort = addr(conn.RemoteAddr())
+	t.DestinationIP, t.DestinationPort = addr(conn.LocalAddr())
+
+	if s.pseudonymize != nil {
+		t.SourceIP = s.pseudonymize(t.SourceIP)
+		t.Pseudonymized = true
+	}
+
+	return &Conn{
+		Conn:  conn,
+		store: s,
+		t:     t,
+	}
+}
+
+// Conn records the data of the connection.
+type Conn struct {
+	net.Conn
+
+	store *Store
+
+	m    sync.Mutex
+	t    *Transcript
+	size int
+
+	once sync.Once
+}
+
+// ID returns the id of the transcript.
+func (c *Conn) ID() string {
+	return c.t.ID
+}
+
+func (c *Conn) record(direction string, data []byte) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.size+len(data) > c.store.MaxSize {
+		c.t.Truncated = true
+		return
+	}
+
+	c.size += len(data)
+
+	c.t.Records = append(c.t.Records, Record{
+		Time:      time.Now(),
+		Direction: direction,
+		Data:      append([]byte{}, data...),
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(DirectionClient, b[:n])
+	}
+
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record(DirectionServer, b[:n])
+	}
+
+	return n, err
+}
+
+// Close closes the connection and stores the transcript.
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+
+	c.once.Do(func() {
+		c.m.Lock()
+		defer c.m.Unlock()
+
+		c.t.End = time.Now()
+
+		if len(c.t.Records) == 0 {
+			return
+		}
+
+		if err := c.store.save(c.t); err != nil {
+			log.Errorf("Error storing transcript %s: %s", c.t.ID, err.Error())
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,242 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package transcript
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, err := New(WithDataDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.MaxSize = 16
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	go func() {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+
+		defer client.Close()
+
+		client.Write([]byte("USER root\r\n"))
+		io.Copy(ioutil.Discard, client)
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := s.Record(conn, "ftp")
+
+	buf := make([]byte, 11)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Write([]byte("331 OK\r\n"))
+	c.Close()
+
+	tr, err := s.Load(c.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.Service != "ftp" || tr.SourceIP != "127.0.0.1" || tr.DestinationPort != l.Addr().(*net.TCPAddr).Port {
+		t.Errorf("Unexpected transcript %+v", tr)
+	}
+
+	// the response exceeds the maximum size
+	if len(tr.Records) != 1 || !tr.Truncated || tr.Records[0].Direction != DirectionClient {
+		t.Fatalf("Unexpected records %+v", tr.Records)
+	}
+
+	summaries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(summaries) != 1 || summaries[0].ID != c.ID() {
+		t.Errorf("Unexpected summaries %+v", summaries)
+	}
+
+	if _, err := s.Load("../../etc/passwd"); err != ErrInvalidID {
+		t.Errorf("Expected ErrInvalidID, got %v", err)
+	}
+}
+
+func TestRecordPseudonymized(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, err := New(WithDataDir(dir), WithPseudonymizer(func(ip string) string {
+		return "anon-" + strings.Replace(ip, ".", "", -1)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	go func() {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+
+		defer client.Close()
+
+		client.Write([]byte("USER root\r\n"))
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := s.Record(conn, "ftp")
+
+	buf := make([]byte, 11)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+
+	tr, err := s.Load(c.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.SourceIP != "anon-127001" || !tr.Pseudonymized {
+		t.Errorf("Expected pseudonymized source, got %s", tr.SourceIP)
+	}
+
+	if err := WritePCAP(ioutil.Discard, tr); err != ErrPseudonymized {
+		t.Errorf("Expected ErrPseudonymized, got %v", err)
+	}
+}
+
+func TestWritePCAP(t *testing.T) {
+	start := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tr := &Transcript{
+		ID:              "test",
+		Network:         "tcp",
+		SourceIP:        "198.51.100.7",
+		SourcePort:      51234,
+		DestinationIP:   "192.0.2.10",
+		DestinationPort: 23,
+		Start:           start,
+		End:             start.Add(3 * time.Second),
+		Records: []Record{
+			{Time: start.Add(time.Second), Direction: DirectionServer, Data: []byte("login: ")},
+			{Time: start.Add(2 * time.Second), Direction: DirectionClient, Data: bytes.Repeat([]byte("A"), 2000)},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WritePCAP(buf, tr); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := pcapgo.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	client, server := []byte{}, []byte{}
+
+	var last time.Time
+
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if ci.Timestamp.Before(last) {
+			t.Errorf("Packets not ordered by time")
+		}
+
+		last = ci.Timestamp
+		count++
+
+		p := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+		if err := p.ErrorLayer(); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		ip := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		tcp := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+
+		if ip.SrcIP.String() == "198.51.100.7" && tcp.SrcPort == 51234 {
+			client = append(client, tcp.Payload...)
+		} else if ip.SrcIP.String() == "192.0.2.10" && tcp.SrcPort == 23 {
+			server = append(server, tcp.Payload...)
+		} else {
+			t.Fatalf("Unexpected addresses %s:%d", ip.SrcIP, tcp.SrcPort)
+		}
+	}
+
+	// handshake, 1 server and 2 client segments with their acks, and close
+	if count != 3+2+3+3 {
+		t.Errorf("Expected 11 packets, got %d", count)
+	}
+
+	if string(server) != "login: " || string(client) != strings.Repeat("A", 2000) {
+		t.Errorf("Unexpected payloads %q and %d bytes", server, len(client))
+	}
+}
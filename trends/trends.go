@@ -0,0 +1,316 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package trends maintains the long term trends of the events. The events
+// are counted per service and per country into hourly and daily rollups,
+// the hourly rollups are kept for a month and the daily rollups for a
+// year, so the trends are available without keeping the raw events.
+package trends
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/storage"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:trends")
+
+// Resolutions of the rollups.
+const (
+	Hourly = "hour"
+	Daily  = "day"
+)
+
+// ErrUnknownResolution is returned for resolutions other than hour and day.
+var ErrUnknownResolution = errors.New("Unknown resolution, expected hour or day")
+
+// Bucket contains the counters of an hour or a day.
+type Bucket struct {
+	Time time.Time `json:"time"`
+
+	Events    int            `json:"events"`
+	Services  map[string]int `json:"services"`
+	Countries map[string]int `json:"countries"`
+}
+
+func newBucket(t time.Time) *Bucket {
+	return &Bucket{
+		Time:      t,
+		Services:  map[string]int{},
+		Countries: map[string]int{},
+	}
+}
+
+func (b *Bucket) copy() Bucket {
+	c := *b
+
+	c.Services = map[string]int{}
+	for k, v := range b.Services {
+		c.Services[k] = v
+	}
+
+	c.Countries = map[string]int{}
+	for k, v := range b.Countries {
+		c.Countries[k] = v
+	}
+
+	return c
+}
+
+// Store counts the events into the rollups.
+type Store struct {
+	// HourlyRetention and DailyRetention are the times the rollups are
+	// kept
+	HourlyRetention config.Delay `toml:"hourly-retention"`
+	DailyRetention  config.Delay `toml:"daily-retention"`
+
+	// MaxKeys limits the number of services and countries per bucket,
+	// keys seen after the limit is reached are counted as other
+	MaxKeys int `toml:"max-keys"`
+
+	// FlushInterval is the interval the rollups are persisted
+	FlushInterval config.Delay `toml:"flush-interval"`
+
+	storage storage.Storage
+	country func(net.IP) string
+
+	m      sync.RWMutex
+	hourly map[time.Time]*Bucket
+	daily  map[time.Time]*Bucket
+
+	now func() time.Time
+}
+
+// New returns a new Store.
+func New(options ...func(*Store) error) (*Store, error) {
+	s := &Store{
+		HourlyRetention: config.Delay(30 * 24 * time.Hour),
+		DailyRetention:  config.Delay(365 * 24 * time.Hour),
+		MaxKeys:         500,
+		FlushInterval:   config.Delay(5 * time.Minute),
+
+		country: func(net.IP) string { return "" },
+
+		hourly: map[time.Time]*Bucket{},
+		daily:  map[time.Time]*Bucket{},
+
+		now: time.Now,
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.storage != nil {
+		if err := s.load(); err != nil {
+			log.Errorf("Error loading trends: %s", err.Error())
+		}
+	}
+
+	return s, nil
+}
+
+type TomlDecoder interface {
+	PrimitiveDecode(primValue toml.Primitive, v interface{}) error
+}
+
+// WithConfig decodes the trends configuration.
+func WithConfig(c toml.Primitive, decoder TomlDecoder) func(*Store) error {
+	return func(s *Store) error {
+		return decoder.PrimitiveDecode(c, s)
+	}
+}
+
+// WithStorage persists the rollups in the storage.
+func WithStorage(st storage.Storage) func(*Store) error {
+	return func(s *Store) error {
+		s.storage = st
+		return nil
+	}
+}
+
+// WithCountry sets the lookup of the country of the sources, for events
+// that haven't been enriched with the country.
+func WithCountry(fn func(net.IP) string) func(*Store) error {
+	return func(s *Store) error {
+		s.country = fn
+		return nil
+	}
+}
+
+func (s *Store) load() error {
+	for key, buckets := range map[string]map[time.Time]*Bucket{
+		Hourly: s.hourly,
+		Daily:  s.daily,
+	} {
+		data, err := s.storage.Get(key)
+		if err != nil || len(data) == 0 {
+			// nothing stored yet
+			continue
+		}
+
+		stored := []*Bucket{}
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+
+		for _, b := range stored {
+			buckets[b.Time] = b
+		}
+	}
+
+	return nil
+}
+
+// Flush persists the rollups, and removes the rollups outside the
+// retention.
+func (s *Store) Flush() error {
+	s.m.Lock()
+	s.expire(s.now().UTC())
+	s.m.Unlock()
+
+	if s.storage == nil {
+		return nil
+	}
+
+	for _, resolution := range []string{Hourly, Daily} {
+		buckets, _ := s.Series(resolution, time.Time{})
+
+		data, err := json.Marshal(buckets)
+		if err != nil {
+			return err
+		}
+
+		if err := s.storage.Set(resolution, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) expire(now time.Time) {
+	for t := range s.hourly {
+		if now.Sub(t) > s.HourlyRetention.Duration() {
+			delete(s.hourly, t)
+		}
+	}
+
+	for t := range s.daily {
+		if now.Sub(t) > s.DailyRetention.Duration() {
+			delete(s.daily, t)
+		}
+	}
+}
+
+// count increments the counter of the key, new keys beyond the maximum are
+// counted as other.
+func (s *Store) count(m map[string]int, key string) {
+	if _, ok := m[key]; !ok && len(m) >= s.MaxKeys {
+		key = "other"
+	}
+
+	m[key]++
+}
+
+func (s *Store) add(buckets map[time.Time]*Bucket, t time.Time, service, country string) {
+	b, ok := buckets[t]
+	if !ok {
+		b = newBucket(t)
+		buckets[t] = b
+	}
+
+	b.Events++
+
+	s.count(b.Services, service)
+
+	if country != "" {
+		s.count(b.Countries, country)
+	}
+}
+
+// Send counts the event into the rollups of the current hour and day.
+func (s *Store) Send(e event.Event) {
+	if e.Get("category") == "heartbeat" {
+		return
+	}
+
+	service := e.Get("service")
+	if service == "" {
+		service = e.Get("category")
+	}
+
+	if service == "" {
+		service = "unknown"
+	}
+
+	country := e.Get("source.country.isocode")
+	if ip := net.ParseIP(e.Get("source-ip")); country == "" && ip != nil {
+		country = s.country(ip)
+	}
+
+	now := s.now().UTC()
+
+	hour := now.Truncate(time.Hour)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.add(s.hourly, hour, service, country)
+	s.add(s.daily, day, service, country)
+}
+
+// Series returns the rollups of the resolution since the time, oldest
+// first.
+func (s *Store) Series(resolution string, since time.Time) ([]Bucket, error) {
+	var buckets map[time.Time]*Bucket
+
+	switch resolution {
+	case Hourly:
+		buckets = s.hourly
+	case Daily:
+		buckets = s.daily
+	default:
+		return nil, ErrUnknownResolution
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	result := []Bucket{}
+	for t, b := range buckets {
+		if t.Before(since) {
+			continue
+		}
+
+		result = append(result, b.copy())
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trends
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// memoryStorage is an in memory storage.
+type memoryStorage map[string][]byte
+
+func (m memoryStorage) Get(key string) ([]byte, error) {
+	return m[key], nil
+}
+
+func (m memoryStorage) Set(key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func newStore(t *testing.T, st memoryStorage, now *time.Time) *Store {
+	s, err := New(
+		WithStorage(st),
+		WithCountry(func(ip net.IP) string {
+			if ip.Equal(net.ParseIP("198.51.100.7")) {
+				return "NL"
+			}
+
+			return ""
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.now = func() time.Time {
+		return *now
+	}
+
+	return s
+}
+
+func TestSeries(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 30, 0, 0, time.UTC)
+	s := newStore(t, memoryStorage{}, &now)
+
+	s.Send(event.New(event.Service("ssh"), event.SourceIP(net.ParseIP("198.51.100.7"))))
+	s.Send(event.New(event.Service("ssh"), event.Custom("source.country.isocode", "DE")))
+
+	now = now.Add(time.Hour)
+
+	s.Send(event.New(event.Category("http")))
+	s.Send(event.New(event.Category("heartbeat")))
+
+	hourly, err := s.Series(Hourly, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hourly) != 2 || hourly[0].Events != 2 || hourly[1].Events != 1 {
+		t.Fatalf("Expected two hours, got %+v", hourly)
+	}
+
+	if hourly[0].Services["ssh"] != 2 || hourly[0].Countries["NL"] != 1 || hourly[0].Countries["DE"] != 1 {
+		t.Errorf("Unexpected counters %+v", hourly[0])
+	}
+
+	daily, _ := s.Series(Daily, time.Time{})
+	if len(daily) != 1 || daily[0].Events != 3 || daily[0].Services["http"] != 1 {
+		t.Errorf("Expected the day to contain all events, got %+v", daily)
+	}
+
+	if since, _ := s.Series(Hourly, now.Truncate(time.Hour)); len(since) != 1 {
+		t.Errorf("Expected one hour since now, got %d", len(since))
+	}
+
+	if _, err := s.Series("week", time.Time{}); err != ErrUnknownResolution {
+		t.Errorf("Expected unknown resolution, got %v", err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	st := memoryStorage{}
+
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newStore(t, st, &now)
+
+	s.Send(event.New(event.Service("ssh")))
+
+	// the hourly rollups expire first
+	now = now.Add(60 * 24 * time.Hour)
+
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if hourly, _ := s.Series(Hourly, time.Time{}); len(hourly) != 0 {
+		t.Errorf("Expected the hourly rollups to expire, got %d", len(hourly))
+	}
+
+	// the daily rollups are persisted
+	s = newStore(t, st, &now)
+
+	daily, _ := s.Series(Daily, time.Time{})
+	if len(daily) != 1 || daily[0].Services["ssh"] != 1 {
+		t.Errorf("Expected the daily rollup to be loaded, got %+v", daily)
+	}
+
+	now = now.Add(365 * 24 * time.Hour)
+	s.Flush()
+
+	if daily, _ := s.Series(Daily, time.Time{}); len(daily) != 0 {
+		t.Errorf("Expected the daily rollups to expire after a year, got %d", len(daily))
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newStore(t, memoryStorage{}, &now)
+
+	s.MaxKeys = 1
+
+	s.Send(event.New(event.Service("ssh")))
+	s.Send(event.New(event.Service("http")))
+
+	hourly, _ := s.Series(Hourly, time.Time{})
+	if hourly[0].Services["ssh"] != 1 || hourly[0].Services["other"] != 1 {
+		t.Errorf("Expected the services beyond the limit to be counted as other, got %v", hourly[0].Services)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package harness
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// TCPBackend is a fake tcp server recording the data it receives, eg. for
+// channels sending to syslog or a socket.
+type TCPBackend struct {
+	*FaultListener
+
+	m     sync.Mutex
+	data  bytes.Buffer
+	conns int
+
+	notify chan struct{}
+}
+
+// NewTCPBackend starts a tcp backend, the faults are injected into the
+// accepted connections.
+func NewTCPBackend(f Faults) (*TCPBackend, error) {
+	l, err := Listen("tcp", f)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &TCPBackend{
+		FaultListener: l,
+		notify:        make(chan struct{}, 1),
+	}
+
+	go b.serve()
+
+	return b, nil
+}
+
+func (b *TCPBackend) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *TCPBackend) serve() {
+	for {
+		conn, err := b.Accept()
+		if err != nil {
+			return
+		}
+
+		b.m.Lock()
+		b.conns++
+		b.m.Unlock()
+
+		go b.receive(conn)
+	}
+}
+
+func (b *TCPBackend) receive(conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := conn.Read(buf)
+
+		b.m.Lock()
+		b.data.Write(buf[:n])
+		b.m.Unlock()
+
+		b.signal()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Addr returns the address of the backend.
+func (b *TCPBackend) Addr() string {
+	return b.FaultListener.Addr().String()
+}
+
+// Received returns the data received on all connections.
+func (b *TCPBackend) Received() []byte {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return append([]byte{}, b.data.Bytes()...)
+}
+
+// Connections returns the number of connections accepted, without the
+// dropped connections.
+func (b *TCPBackend) Connections() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.conns
+}
+
+// WaitFor waits until the condition holds for the received data.
+func (b *TCPBackend) WaitFor(cond func(data []byte) bool, timeout time.Duration) error {
+	return waitFor(b.notify, func() bool {
+		return cond(b.Received())
+	}, timeout)
+}
+
+// Request is a request received by the HTTPBackend, the body is
+// decompressed.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// HTTPBackend is a fake http server recording the requests, eg. for
+// channels posting to a collector.
+type HTTPBackend struct {
+	*httptest.Server
+
+	// Latency delays the responses
+	Latency time.Duration
+
+	// Fail responds the first Fail requests with Status
+	Fail   int
+	Status int
+
+	handler http.Handler
+
+	m        sync.Mutex
+	requests []Request
+
+	notify chan struct{}
+}
+
+// NewHTTPBackend starts an http backend, the requests are handled by the
+// handler after being recorded. Requests are responded with 200 OK when
+// the handler is nil.
+func NewHTTPBackend(handler http.Handler) *HTTPBackend {
+	b := &HTTPBackend{
+		Status:  http.StatusServiceUnavailable,
+		handler: handler,
+		notify:  make(chan struct{}, 1),
+	}
+
+	b.Server = httptest.NewServer(b)
+	return b
+}
+
+func (b *HTTPBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		if gr, err := gzip.NewReader(r.Body); err == nil {
+			body = gr
+		}
+	}
+
+	data, _ := ioutil.ReadAll(body)
+
+	b.m.Lock()
+	b.requests = append(b.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header,
+		Body:   data,
+	})
+	fail := len(b.requests) <= b.Fail
+	b.m.Unlock()
+
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+
+	if b.Latency > 0 {
+		time.Sleep(b.Latency)
+	}
+
+	if fail {
+		w.WriteHeader(b.Status)
+		return
+	}
+
+	if b.handler == nil {
+		return
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	r.Header.Del("Content-Encoding")
+
+	b.handler.ServeHTTP(w, r)
+}
+
+// Requests returns the recorded requests.
+func (b *HTTPBackend) Requests() []Request {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return append([]Request{}, b.requests...)
+}
+
+// Wait waits until at least n requests have been received.
+func (b *HTTPBackend) Wait(n int, timeout time.Duration) ([]Request, error) {
+	err := waitFor(b.notify, func() bool {
+		return len(b.Requests()) >= n
+	}, timeout)
+
+	return b.Requests(), err
+}
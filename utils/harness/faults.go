@@ -0,0 +1,210 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package harness
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by the connections when a fault is injected.
+var ErrInjected = errors.New("Injected fault")
+
+// Faults configures the faults injected into connections. The zero value
+// injects no faults.
+type Faults struct {
+	// Latency is added before every read and write, with up to Jitter
+	// added randomly
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Chunk splits the writes into chunks of at most Chunk bytes, each
+	// chunk is delayed, to test the framing of the peer
+	Chunk int
+
+	// WriteLimit and ReadLimit disconnect the connection after the number
+	// of bytes has been written or read, the write crossing the limit is
+	// written partially
+	WriteLimit int
+	ReadLimit  int
+
+	// Drop closes the first Drop connections accepted immediately
+	Drop int
+
+	// Seed seeds the jitter, for reproducible runs
+	Seed int64
+}
+
+// FaultConn is a connection with injected faults.
+type FaultConn struct {
+	net.Conn
+
+	Faults
+
+	m       sync.Mutex
+	rand    *rand.Rand
+	written int
+	read    int
+}
+
+// WithFaults returns the connection with the faults injected.
+func WithFaults(conn net.Conn, f Faults) *FaultConn {
+	return &FaultConn{
+		Conn:   conn,
+		Faults: f,
+		rand:   rand.New(rand.NewSource(f.Seed)),
+	}
+}
+
+func (c *FaultConn) delay() {
+	d := c.Latency
+
+	if c.Jitter > 0 {
+		c.m.Lock()
+		d += time.Duration(c.rand.Int63n(int64(c.Jitter)))
+		c.m.Unlock()
+	}
+
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Read reads from the connection, the connection is closed when the read
+// limit has been reached.
+func (c *FaultConn) Read(b []byte) (int, error) {
+	c.delay()
+
+	c.m.Lock()
+	remaining := c.ReadLimit - c.read
+	c.m.Unlock()
+
+	if c.ReadLimit > 0 && remaining <= 0 {
+		c.Conn.Close()
+		return 0, io.EOF
+	}
+
+	if c.ReadLimit > 0 && len(b) > remaining {
+		b = b[:remaining]
+	}
+
+	n, err := c.Conn.Read(b)
+
+	c.m.Lock()
+	c.read += n
+	c.m.Unlock()
+
+	return n, err
+}
+
+// Write writes to the connection in chunks, the connection is closed after
+// a partial write when the write limit is reached.
+func (c *FaultConn) Write(b []byte) (int, error) {
+	written := 0
+
+	for len(b) > 0 {
+		c.delay()
+
+		chunk := b
+		if c.Chunk > 0 && len(chunk) > c.Chunk {
+			chunk = chunk[:c.Chunk]
+		}
+
+		c.m.Lock()
+		remaining := c.WriteLimit - c.written
+		c.m.Unlock()
+
+		limited := false
+		if c.WriteLimit > 0 && len(chunk) >= remaining {
+			chunk = chunk[:remaining]
+			limited = true
+		}
+
+		n, err := c.Conn.Write(chunk)
+
+		c.m.Lock()
+		c.written += n
+		c.m.Unlock()
+
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+
+		if limited {
+			c.Conn.Close()
+			return written, ErrInjected
+		}
+
+		b = b[n:]
+	}
+
+	return written, nil
+}
+
+// Pipe returns the ends of an in memory connection, with the faults
+// injected into the server end.
+func Pipe(f Faults) (server net.Conn, client net.Conn) {
+	s, c := net.Pipe()
+	return WithFaults(s, f), c
+}
+
+// FaultListener injects the faults into the accepted connections.
+type FaultListener struct {
+	net.Listener
+
+	Faults
+
+	m        sync.Mutex
+	accepted int
+}
+
+// Accept accepts the next connection, the first connections are dropped
+// as configured.
+func (l *FaultListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		l.m.Lock()
+		l.accepted++
+		drop := l.accepted <= l.Drop
+		l.m.Unlock()
+
+		if drop {
+			conn.Close()
+			continue
+		}
+
+		return WithFaults(conn, l.Faults), nil
+	}
+}
+
+// Listen listens on a random port of the loopback address, with the faults
+// injected into the accepted connections.
+func Listen(network string, f Faults) (*FaultListener, error) {
+	l, err := net.Listen(network, "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	return &FaultListener{Listener: l, Faults: f}, nil
+}
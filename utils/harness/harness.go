@@ -0,0 +1,100 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package harness helps testing channels and services: fake backends that
+// record what they receive, fault injection (latency, disconnects, partial
+// writes) on connections, a recording channel and golden event fixtures.
+package harness
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// Recorder is a channel that records the events sent to it.
+type Recorder struct {
+	m      sync.Mutex
+	events []event.Event
+
+	options []event.Option
+
+	notify chan struct{}
+}
+
+// NewRecorder returns a new Recorder, the options are applied to the events
+// it receives, eg. to act as an enricher.
+func NewRecorder(options ...event.Option) *Recorder {
+	return &Recorder{
+		options: options,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Send records the event.
+func (r *Recorder) Send(e event.Event) {
+	event.Apply(e, r.options...)
+
+	r.m.Lock()
+	r.events = append(r.events, e)
+	r.m.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Events returns the recorded events.
+func (r *Recorder) Events() []event.Event {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]event.Event{}, r.events...)
+}
+
+// Wait waits until at least n events have been recorded, and returns the
+// events.
+func (r *Recorder) Wait(n int, timeout time.Duration) ([]event.Event, error) {
+	deadline := time.After(timeout)
+
+	for {
+		if events := r.Events(); len(events) >= n {
+			return events, nil
+		}
+
+		select {
+		case <-r.notify:
+		case <-deadline:
+			return r.Events(), fmt.Errorf("Expected %d events, got %d", n, len(r.Events()))
+		}
+	}
+}
+
+// waitFor calls the condition until it returns true, or the timeout
+// expires.
+func waitFor(notify <-chan struct{}, cond func() bool, timeout time.Duration) error {
+	deadline := time.After(timeout)
+
+	for !cond() {
+		select {
+		case <-notify:
+		case <-deadline:
+			return fmt.Errorf("Timeout after %s", timeout)
+		}
+	}
+
+	return nil
+}
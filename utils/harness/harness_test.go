@@ -0,0 +1,186 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package harness
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestPartialWrite(t *testing.T) {
+	server, client := Pipe(Faults{Chunk: 3, WriteLimit: 8})
+
+	received := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(client)
+		received <- data
+	}()
+
+	n, err := server.Write([]byte("hello world"))
+	if err != ErrInjected || n != 8 {
+		t.Errorf("Expected partial write of 8 bytes, got %d: %v", n, err)
+	}
+
+	if data := <-received; string(data) != "hello wo" {
+		t.Errorf("Expected partial data, got %q", data)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	server, client := Pipe(Faults{ReadLimit: 5})
+
+	go client.Write([]byte("hello world"))
+
+	data, err := ioutil.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "hello" {
+		t.Errorf("Expected 5 bytes read, got %q", data)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	server, client := Pipe(Faults{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond})
+
+	go io.Copy(ioutil.Discard, client)
+
+	start := time.Now()
+
+	if _, err := server.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Expected latency, write took %s", d)
+	}
+}
+
+func TestTCPBackend(t *testing.T) {
+	b, err := NewTCPBackend(Faults{Drop: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	// the first connection is dropped
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", b.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		conn.Write([]byte("line\n"))
+	}
+
+	if err := b.WaitFor(func(data []byte) bool {
+		return string(data) == "line\n"
+	}, 5*time.Second); err != nil {
+		t.Fatalf("Expected data of the second connection, got %q", b.Received())
+	}
+
+	if b.Connections() != 1 {
+		t.Errorf("Expected 1 connection, got %d", b.Connections())
+	}
+}
+
+func TestHTTPBackend(t *testing.T) {
+	b := NewHTTPBackend(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	defer b.Close()
+
+	b.Fail = 1
+
+	for _, expected := range []int{http.StatusServiceUnavailable, http.StatusAccepted} {
+		resp, err := http.Post(b.URL+"/ingest", "text/plain", strings.NewReader("event"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Errorf("Expected status %d, got %d", expected, resp.StatusCode)
+		}
+	}
+
+	requests, err := b.Wait(2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests[1].Path != "/ingest" || string(requests[1].Body) != "event" {
+		t.Errorf("Expected recorded request, got %#v", requests[1])
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+
+	go func() {
+		for _, f := range Fixtures() {
+			r.Send(f.Event)
+		}
+	}()
+
+	events, err := r.Wait(len(Fixtures()), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if events[0].Get("ssh.username") != "root" {
+		t.Errorf("Expected ssh fixture first, got %v", events[0])
+	}
+
+	if _, err := r.Wait(len(events)+1, 10*time.Millisecond); err == nil {
+		t.Errorf("Expected timeout")
+	}
+
+	// the options are applied to the received events
+	enricher := NewRecorder(event.Custom("severity", "high"))
+
+	e := event.New()
+	enricher.Send(e)
+
+	if e.Get("severity") != "high" {
+		t.Errorf("Expected the options to be applied, got %v", e)
+	}
+
+	date := time.Time{}
+
+	FixtureEvent("critical").Range(func(k, v interface{}) bool {
+		if k == "date" {
+			date, _ = v.(time.Time)
+		}
+
+		return true
+	})
+
+	if !date.Equal(FixtureDate) {
+		t.Errorf("Expected fixture date, got %s", date)
+	}
+}
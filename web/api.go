@@ -0,0 +1,229 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/transcript"
+)
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error encoding response: %s", err.Error())
+	}
+}
+
+// topParam returns the value of the top query parameter, or def when not set.
+func topParam(r *http.Request, def int) (int, error) {
+	return topValue(r.URL.Query().Get("top"), def)
+}
+
+func topValue(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(v)
+}
+
+func (web *web) serveStats(w http.ResponseWriter, r *http.Request) {
+	top, err := topParam(r, 10)
+	if err != nil {
+		http.Error(w, "invalid top parameter", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"events-per-hour":        web.stats.EventsPerHour(),
+		"unique-sources-per-day": web.stats.UniqueSourcesPerDay(),
+		"top-ports":              web.stats.TopPorts(top),
+		"top-fingerprints":       web.stats.TopFingerprints(top),
+	})
+}
+
+func (web *web) serveStatsEvents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, web.stats.EventsPerHour())
+}
+
+func (web *web) serveStatsSources(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, web.stats.UniqueSourcesPerDay())
+}
+
+func (web *web) serveStatsPorts(w http.ResponseWriter, r *http.Request) {
+	top, err := topParam(r, 10)
+	if err != nil {
+		http.Error(w, "invalid top parameter", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, web.stats.TopPorts(top))
+}
+
+func (web *web) serveStatsFingerprints(w http.ResponseWriter, r *http.Request) {
+	top, err := topParam(r, 10)
+	if err != nil {
+		http.Error(w, "invalid top parameter", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, web.stats.TopFingerprints(top))
+}
+
+// serveServices serves the names of the services, or the rolling
+// statistics of a service at /api/services/<name>/stats.
+func (web *web) serveServices(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/services/")
+	if rest == "" || rest == r.URL.Path {
+		writeJSON(w, web.stats.Services())
+		return
+	}
+
+	if !strings.HasSuffix(rest, "/stats") {
+		http.NotFound(w, r)
+		return
+	}
+
+	top, err := topParam(r, 10)
+	if err != nil {
+		http.Error(w, "invalid top parameter", http.StatusBadRequest)
+		return
+	}
+
+	ss, ok := web.stats.Service(strings.TrimSuffix(rest, "/stats"), top)
+	if !ok {
+		http.Error(w, "service not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, ss)
+}
+
+// serveGraph serves the attack graph, or the attack paths of a single
+// source with ?source=<ip>.
+func (web *web) serveGraph(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, web.graph.Graph(r.URL.Query().Get("source")))
+}
+
+func (web *web) serveCredentials(w http.ResponseWriter, r *http.Request) {
+	if web.credentials == nil {
+		http.Error(w, "credential tracking not enabled", http.StatusNotFound)
+		return
+	}
+
+	persona := strings.TrimPrefix(r.URL.Path, "/api/credentials/")
+	if persona == "" || persona == r.URL.Path {
+		writeJSON(w, web.credentials.Summaries())
+		return
+	}
+
+	top, err := topParam(r, 0)
+	if err != nil {
+		http.Error(w, "invalid top parameter", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, web.credentials.Credentials(persona, top))
+}
+
+func (web *web) serveJobs(w http.ResponseWriter, r *http.Request) {
+	if web.scheduler == nil {
+		http.Error(w, "scheduler not enabled", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, web.scheduler.Status())
+}
+
+func (web *web) serveTranscripts(w http.ResponseWriter, r *http.Request) {
+	if web.transcripts == nil {
+		http.Error(w, "transcripts not enabled", http.StatusNotFound)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/transcripts/")
+	if id == "" || id == r.URL.Path {
+		summaries, err := web.transcripts.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, summaries)
+		return
+	}
+
+	pcap := strings.HasSuffix(id, ".pcap")
+
+	t, err := web.transcripts.Load(strings.TrimSuffix(id, ".pcap"))
+	if err == transcript.ErrInvalidID || os.IsNotExist(err) {
+		http.Error(w, "transcript not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !pcap {
+		writeJSON(w, t)
+		return
+	}
+
+	if t.Pseudonymized {
+		http.Error(w, transcript.ErrPseudonymized.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", t.ID+".pcap"))
+
+	if err := transcript.WritePCAP(w, t); err != nil {
+		log.Errorf("Error writing pcap of transcript %s: %s", t.ID, err.Error())
+	}
+}
+
+func (web *web) apiHandler() http.Handler {
+	handler := http.NewServeMux()
+
+	handler.HandleFunc("/api/stats", web.require(RoleViewer, false, web.serveStats))
+	handler.HandleFunc("/api/stats/events", web.require(RoleViewer, false, web.serveStatsEvents))
+	handler.HandleFunc("/api/stats/sources", web.require(RoleViewer, false, web.serveStatsSources))
+	handler.HandleFunc("/api/stats/ports", web.require(RoleViewer, false, web.serveStatsPorts))
+	handler.HandleFunc("/api/stats/fingerprints", web.require(RoleViewer, false, web.serveStatsFingerprints))
+	handler.HandleFunc("/api/services", web.require(RoleViewer, false, web.serveServices))
+	handler.HandleFunc("/api/services/", web.require(RoleViewer, false, web.serveServices))
+	handler.HandleFunc("/api/graph", web.require(RoleViewer, false, web.serveGraph))
+	handler.HandleFunc("/api/credentials", web.require(RoleOperator, true, web.serveCredentials))
+	handler.HandleFunc("/api/credentials/", web.require(RoleOperator, true, web.serveCredentials))
+	handler.HandleFunc("/api/jobs", web.require(RoleViewer, false, web.serveJobs))
+	handler.HandleFunc("/api/transcripts", web.require(RoleOperator, true, web.serveTranscripts))
+	handler.HandleFunc("/api/transcripts/", web.require(RoleOperator, true, web.serveTranscripts))
+
+	web.handleV1(handler)
+
+	mux := http.NewServeMux()
+
+	// the token is issued after authentication by the token handler itself
+	mux.HandleFunc("/api/token", web.serveToken)
+	mux.Handle("/", web.protect(handler))
+
+	return mux
+}
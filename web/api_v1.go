@@ -0,0 +1,450 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/cmd"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/listener/agent"
+	"github.com/honeytrap/honeytrap/severity"
+	"github.com/honeytrap/honeytrap/tracing"
+	"github.com/honeytrap/honeytrap/trends"
+)
+
+// defaultLimit is the number of events returned when no limit is given.
+const defaultLimit = 100
+
+// eventQuery filters the recent events served by the v1 api.
+type eventQuery struct {
+	Category string
+	Type     string
+	Service  string
+	Source   string
+	Severity string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+
+	// Offset is the number of matching events skipped, to page through
+	// the history
+	Offset int
+
+	// Sessions returns only the events of ended sessions
+	Sessions bool
+
+	// Session is the session id of the connection, or of the services, eg.
+	// ssh.sessionid
+	Session string
+
+	// ID is the id of the event, and Attack the id of the attack
+	ID     string
+	Attack string
+}
+
+func parseEventQuery(r *http.Request) (eventQuery, error) {
+	return parseEventValues(r.URL.Query())
+}
+
+// parseEventValues parses the query parameters of the events.
+func parseEventValues(q url.Values) (eventQuery, error) {
+	eq := eventQuery{
+		Category: q.Get("category"),
+		Type:     q.Get("type"),
+		Service:  q.Get("service"),
+		Source:   q.Get("source"),
+		Severity: q.Get("severity"),
+		Session:  q.Get("session"),
+		ID:       q.Get("id"),
+		Attack:   q.Get("attack"),
+		Limit:    defaultLimit,
+	}
+
+	if v := q.Get("limit"); v == "" {
+	} else if limit, err := strconv.Atoi(v); err != nil || limit < 0 {
+		return eq, errInvalidParameter("limit")
+	} else {
+		eq.Limit = limit
+	}
+
+	if v := q.Get("offset"); v == "" {
+	} else if offset, err := strconv.Atoi(v); err != nil || offset < 0 {
+		return eq, errInvalidParameter("offset")
+	} else {
+		eq.Offset = offset
+	}
+
+	if eq.Severity != "" && severity.Compare(eq.Severity, severity.Info) < 0 {
+		return eq, errInvalidParameter("severity")
+	}
+
+	if v := q.Get("since"); v == "" {
+	} else if since, err := time.Parse(time.RFC3339, v); err != nil {
+		return eq, errInvalidParameter("since")
+	} else {
+		eq.Since = since
+	}
+
+	if v := q.Get("until"); v == "" {
+	} else if until, err := time.Parse(time.RFC3339, v); err != nil {
+		return eq, errInvalidParameter("until")
+	} else {
+		eq.Until = until
+	}
+
+	return eq, nil
+}
+
+type errInvalidParameter string
+
+func (e errInvalidParameter) Error() string {
+	return "invalid " + string(e) + " parameter"
+}
+
+func (eq eventQuery) Match(e event.Event) bool {
+	if eq.Category != "" && e.Get("category") != eq.Category {
+		return false
+	}
+
+	if eq.Type != "" && e.Get("type") != eq.Type {
+		return false
+	}
+
+	if eq.Service != "" && e.Get("service") != eq.Service {
+		return false
+	}
+
+	if eq.Source != "" && e.Get("source-ip") != eq.Source {
+		return false
+	}
+
+	// severity is the minimum severity
+	if eq.Severity != "" && severity.Compare(e.Get("severity"), eq.Severity) < 0 {
+		return false
+	}
+
+	if eq.Sessions && e.Get("type") != "SERVICE:ENDED" {
+		return false
+	}
+
+	if eq.Session != "" && sessionID(e) != eq.Session && e.Get("session.id") != eq.Session {
+		return false
+	}
+
+	if eq.ID != "" && e.Get("id") != eq.ID {
+		return false
+	}
+
+	if eq.Attack != "" && e.Get("attack.id") != eq.Attack {
+		return false
+	}
+
+	if eq.Since.IsZero() && eq.Until.IsZero() {
+		return true
+	}
+
+	date := eventDate(e)
+
+	if !eq.Since.IsZero() && !date.After(eq.Since) {
+		return false
+	}
+
+	if !eq.Until.IsZero() && !date.Before(eq.Until) {
+		return false
+	}
+
+	return true
+}
+
+// recentEvents returns the matching events, the most recent event first.
+// The events are read from the store when persisted.
+func (web *web) recentEvents(eq eventQuery) ([]event.Event, error) {
+	if web.store != nil {
+		return web.store.Events(eq)
+	}
+
+	matched := []event.Event{}
+
+	web.events.Range(func(v interface{}) bool {
+		if e, ok := v.(event.Event); ok && eq.Match(e) {
+			matched = append(matched, e)
+		}
+
+		return true
+	})
+
+	// the events are appended in order of arrival
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+
+	if eq.Offset >= len(matched) {
+		return []event.Event{}, nil
+	}
+
+	matched = matched[eq.Offset:]
+
+	if eq.Limit > 0 && len(matched) > eq.Limit {
+		matched = matched[:eq.Limit]
+	}
+
+	return matched, nil
+}
+
+// serveEvents serves the events of the query.
+func (web *web) serveEvents(w http.ResponseWriter, eq eventQuery) {
+	events, err := web.recentEvents(eq)
+	if err != nil {
+		log.Errorf("Error retrieving events: %s", err.Error())
+		http.Error(w, "error retrieving events", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, events)
+}
+
+// serveEventsV1 serves the recent events, filtered by the category, type,
+// service, source, severity, session, id, attack, since, until, limit and
+// offset query parameters.
+func (web *web) serveEventsV1(w http.ResponseWriter, r *http.Request) {
+	eq, err := parseEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	web.serveEvents(w, eq)
+}
+
+// serveSessionsV1 serves the recent ended sessions, with their duration,
+// transferred bytes and transcript.
+func (web *web) serveSessionsV1(w http.ResponseWriter, r *http.Request) {
+	eq, err := parseEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	eq.Sessions = true
+
+	web.serveEvents(w, eq)
+}
+
+func (web *web) serveStatsV1(w http.ResponseWriter, r *http.Request) {
+	top, err := topParam(r, 10)
+	if err != nil {
+		http.Error(w, "invalid top parameter", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"events-per-hour":        web.stats.EventsPerHour(),
+		"unique-sources-per-day": web.stats.UniqueSourcesPerDay(),
+		"top-ports":              web.stats.TopPorts(top),
+		"top-fingerprints":       web.stats.TopFingerprints(top),
+		"hot-countries":          web.hotCountries,
+	})
+}
+
+func (web *web) serveCountriesV1(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, web.hotCountries)
+}
+
+// serveMetadataV1 serves the version and uptime of the sensor.
+func (web *web) serveMetadataV1(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"start":         web.start,
+		"uptime":        int64(time.Since(web.start).Seconds()),
+		"version":       cmd.Version,
+		"release_tag":   cmd.ReleaseTag,
+		"commitid":      cmd.CommitID,
+		"shortcommitid": cmd.ShortCommitID,
+	})
+}
+
+// serveC2V1 serves the inventory of the c2 servers and their liveness.
+func (web *web) serveC2V1(w http.ResponseWriter, r *http.Request) {
+	if web.c2 == nil {
+		http.Error(w, "c2 tracking not enabled", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, web.c2.Servers())
+}
+
+// trendSeries returns the rollups of the resolution parameter, hour or day,
+// since the since parameter.
+func (web *web) trendSeries(q url.Values) ([]trends.Bucket, error) {
+	if web.trends == nil {
+		return nil, errTrendsDisabled
+	}
+
+	resolution := q.Get("resolution")
+	if resolution == "" {
+		resolution = trends.Daily
+	}
+
+	since := time.Time{}
+
+	if v := q.Get("since"); v == "" {
+	} else if t, err := time.Parse(time.RFC3339, v); err != nil {
+		return nil, errInvalidParameter("since")
+	} else {
+		since = t
+	}
+
+	series, err := web.trends.Series(resolution, since)
+	if err == trends.ErrUnknownResolution {
+		return nil, errInvalidParameter("resolution")
+	}
+
+	return series, err
+}
+
+// serveTrendsV1 serves the hourly or daily rollups of the events per service
+// and country, for the trend charts.
+func (web *web) serveTrendsV1(w http.ResponseWriter, r *http.Request) {
+	series, err := web.trendSeries(r.URL.Query())
+	if err == errTrendsDisabled {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, series)
+}
+
+// serveAgentsV1 serves the connected agents.
+func (web *web) serveAgentsV1(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, agent.Agents())
+}
+
+// agentCommandTimeout is the time the agent has to reply to a command.
+const agentCommandTimeout = 30 * time.Second
+
+// serveAgentCommandV1 sends a command to an agent and serves the result.
+// Commands change the sensors, and are only accepted with authentication
+// enabled.
+func (web *web) serveAgentCommandV1(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if web.authMode() == AuthNone {
+		http.Error(w, "agent commands require authentication", http.StatusForbidden)
+		return
+	}
+
+	// a json body can't be posted cross origin without a preflight
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	req := struct {
+		Agent   string   `json:"agent"`
+		Command string   `json:"command"`
+		Args    []string `json:"args"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid command", http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("Agent command %s %v for agent %s requested by %s", req.Command, req.Args, req.Agent, r.RemoteAddr)
+
+	result, err := agent.Send(req.Agent, req.Command, req.Args, agentCommandTimeout)
+
+	detail := strings.TrimSpace(req.Command + " " + strings.Join(req.Args, " "))
+	if err != nil {
+		detail += ": " + err.Error()
+	} else if result.Error != "" {
+		detail += ": " + result.Error
+	}
+
+	web.audit(r.RemoteAddr, principalFrom(r), "agent-command", req.Agent, detail)
+	switch err {
+	case nil:
+	case agent.ErrAgentNotConnected:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case agent.ErrCommandNotAllowed, agent.ErrCommandsNotSupported:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case agent.ErrCommandTimeout:
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"id":     result.ID,
+		"error":  result.Error,
+		"output": result.Output,
+	})
+}
+
+// serveTraceV1 serves the journeys of the last events through the pipeline,
+// newest first. The journey of a single event is selected by id, the
+// journeys of the events that didn't reach a channel by undelivered.
+func (web *web) serveTraceV1(w http.ResponseWriter, r *http.Request) {
+	if web.tracer == nil {
+		http.Error(w, "tracing not enabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+
+	limit, err := topValue(q.Get("limit"), 100)
+	if err != nil || limit < 1 {
+		http.Error(w, errInvalidParameter("limit").Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, web.tracer.Journeys(tracing.Query{
+		ID:          q.Get("id"),
+		Undelivered: q.Get("undelivered"),
+		Limit:       limit,
+	}))
+}
+
+// handleV1 registers the versioned api, which can be queried without
+// maintaining a websocket connection.
+func (web *web) handleV1(handler *http.ServeMux) {
+	handler.HandleFunc("/api/v1/events", web.require(RoleOperator, true, web.serveEventsV1))
+	handler.HandleFunc("/api/v1/sessions", web.require(RoleOperator, true, web.serveSessionsV1))
+	handler.HandleFunc("/api/v1/replays", web.require(RoleOperator, true, web.serveReplaysV1))
+	handler.HandleFunc("/api/v1/replays/", web.require(RoleOperator, true, web.serveReplaysV1))
+	handler.HandleFunc("/api/v1/stats", web.require(RoleViewer, false, web.serveStatsV1))
+	handler.HandleFunc("/api/v1/countries", web.require(RoleViewer, false, web.serveCountriesV1))
+	handler.HandleFunc("/api/v1/metadata", web.require(RoleViewer, false, web.serveMetadataV1))
+	handler.HandleFunc("/api/v1/c2", web.require(RoleViewer, false, web.serveC2V1))
+	handler.HandleFunc("/api/v1/trends", web.require(RoleViewer, false, web.serveTrendsV1))
+	handler.HandleFunc("/api/v1/agents", web.require(RoleViewer, false, web.serveAgentsV1))
+	handler.HandleFunc("/api/v1/agents/command", web.require(RoleAdmin, false, web.serveAgentCommandV1))
+	handler.HandleFunc("/api/v1/audit", web.require(RoleAdmin, false, web.serveAuditV1))
+	handler.HandleFunc("/api/v1/diagnostics/trace", web.require(RoleAdmin, false, web.serveTraceV1))
+}
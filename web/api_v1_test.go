@@ -0,0 +1,224 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/tracing"
+)
+
+func TestEventsV1(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.events.Append(event.New(event.Category("ssh"), event.Service("ssh")))
+	w.events.Append(event.New(event.Category("http"), event.Service("http")))
+	w.events.Append(event.New(event.Category("ssh"), event.Service("ssh"), event.ServiceEnded,
+		event.Custom("id", "01ARYZ6S41TSV4RRFFQ69G5FAV"),
+		event.Custom("session.id", "01ARYZ6S41TSV4RRFFQ69G5FAW"),
+		event.Custom("attack.id", "01ARYZ6S41TSV4RRFFQ69G5FAX"),
+	))
+
+	get := func(url string) []map[string]interface{} {
+		rec := httptest.NewRecorder()
+		w.apiHandler().ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected ok, got %d", url, rec.Code)
+		}
+
+		result := []map[string]interface{}{}
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		return result
+	}
+
+	if events := get("/api/v1/events"); len(events) != 3 || events[0]["type"] != "SERVICE:ENDED" {
+		t.Errorf("Expected all events, most recent first, got %v", events)
+	}
+
+	if events := get("/api/v1/events?category=ssh&limit=1"); len(events) != 1 || events[0]["category"] != "ssh" {
+		t.Errorf("Expected one ssh event, got %v", events)
+	}
+
+	if sessions := get("/api/v1/sessions"); len(sessions) != 1 {
+		t.Errorf("Expected one session, got %v", sessions)
+	}
+
+	for _, q := range []string{"id=01ARYZ6S41TSV4RRFFQ69G5FAV", "session=01ARYZ6S41TSV4RRFFQ69G5FAW", "attack=01ARYZ6S41TSV4RRFFQ69G5FAX"} {
+		if events := get("/api/v1/events?" + q); len(events) != 1 || events[0]["type"] != "SERVICE:ENDED" {
+			t.Errorf("%s: expected the correlated event, got %v", q, events)
+		}
+	}
+
+	if events := get("/api/v1/events?since=2100-01-01T00:00:00Z"); len(events) != 0 {
+		t.Errorf("Expected no events in the future, got %v", events)
+	}
+
+	rec := httptest.NewRecorder()
+	w.apiHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/events?since=yesterday", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid since, got %d", rec.Code)
+	}
+}
+
+func TestReplaysV1(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	at := func(d time.Duration) event.Option {
+		return event.Custom("date", start.Add(d))
+	}
+
+	w.events.Append(event.New(at(0), event.Category("ssh"), event.SourceIP(net.ParseIP("10.0.0.1")), event.Type("password-authentication"), event.Custom("ssh.sessionid", "a"), event.Custom("ssh.username", "root")))
+	w.events.Append(event.New(at(time.Second), event.Category("http"), event.Type("request"), event.Custom("http.sessionid", "b"), event.Custom("http.method", "GET"), event.Custom("http.url", "/")))
+	w.events.Append(event.New(at(3*time.Second), event.Category("ssh"), event.Custom("ssh.sessionid", "a"), event.Custom("ssh.command", "uname -a")))
+	// events can arrive out of order
+	w.events.Append(event.New(at(2*time.Second), event.Category("ssh"), event.Custom("ssh.sessionid", "a"), event.Payload([]byte("data"))))
+
+	get := func(url string, v interface{}) int {
+		rec := httptest.NewRecorder()
+		w.apiHandler().ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return rec.Code
+	}
+
+	sessions := []sessionReplay{}
+	if code := get("/api/v1/replays", &sessions); code != http.StatusOK {
+		t.Fatalf("Expected ok, got %d", code)
+	}
+
+	if len(sessions) != 2 || sessions[0].Session != "a" || sessions[0].Count != 3 || sessions[1].Session != "b" {
+		t.Errorf("Expected sessions a and b, got %+v", sessions)
+	}
+
+	if sessions[0].Source != "10.0.0.1" || !sessions[0].End.Equal(start.Add(3*time.Second)) {
+		t.Errorf("Expected source and end of session a, got %+v", sessions[0])
+	}
+
+	replay := struct {
+		Steps []struct {
+			Offset float64
+			Kind   string
+			Data   string
+		}
+	}{}
+
+	if code := get("/api/v1/replays/a", &replay); code != http.StatusOK {
+		t.Fatalf("Expected ok, got %d", code)
+	}
+
+	expected := []struct {
+		Offset float64
+		Kind   string
+		Data   string
+	}{
+		{0, "authentication", "root"},
+		{2, "payload", "data"},
+		{3, "command", "uname -a"},
+	}
+
+	if len(replay.Steps) != len(expected) {
+		t.Fatalf("Expected %d steps, got %+v", len(expected), replay.Steps)
+	}
+
+	for i, step := range replay.Steps {
+		if step != expected[i] {
+			t.Errorf("Step %d: expected %+v, got %+v", i, expected[i], step)
+		}
+	}
+
+	if code := get("/api/v1/replays/b", &replay); code != http.StatusOK || replay.Steps[0].Data != "GET /" {
+		t.Errorf("Expected http request, got %d %+v", code, replay.Steps)
+	}
+
+	if code := get("/api/v1/replays/c", &replay); code != http.StatusNotFound {
+		t.Errorf("Expected not found, got %d", code)
+	}
+}
+
+func TestTraceV1(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(url string, v interface{}) int {
+		rec := httptest.NewRecorder()
+		w.apiHandler().ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return rec.Code
+	}
+
+	if code := get("/api/v1/diagnostics/trace", nil); code != http.StatusNotFound {
+		t.Fatalf("Expected not found without tracer, got %d", code)
+	}
+
+	if w.tracer, err = tracing.New(); err != nil {
+		t.Fatal(err)
+	}
+
+	delivered := w.tracer.Channel(tracing.StageDelivery, "file", pushers.NewBusChannel())
+
+	for _, category := range []string{"ssh", "http"} {
+		e := event.New(event.Category(category))
+
+		w.tracer.Send(e)
+		if category == "ssh" {
+			delivered.Send(e)
+		}
+	}
+
+	journeys := []tracing.Journey{}
+	if code := get("/api/v1/diagnostics/trace?undelivered=file", &journeys); code != http.StatusOK {
+		t.Fatalf("Expected ok, got %d", code)
+	}
+
+	if len(journeys) != 1 || journeys[0].Category != "http" {
+		t.Errorf("Expected the journey of the http event, got %+v", journeys)
+	}
+
+	if code := get("/api/v1/diagnostics/trace?limit=x", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid limit, got %d", code)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// overlayFS serves the files of the local directory, and falls back to the
+// bundled assets for files that don't exist locally.
+type overlayFS struct {
+	local   http.FileSystem
+	bundled http.FileSystem
+}
+
+func (o *overlayFS) Open(name string) (http.File, error) {
+	if f, err := o.local.Open(name); err == nil {
+		return f, nil
+	}
+
+	return o.bundled.Open(name)
+}
+
+// assets returns the bundled assets, overridden by the files in the assets
+// directory when it exists.
+func (web *web) assets(bundled http.FileSystem) http.FileSystem {
+	if web.Assets == "" {
+		return bundled
+	}
+
+	dir := web.Assets
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(web.dataDir, dir)
+	}
+
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return bundled
+	}
+
+	log.Infof("Serving web assets from %s", dir)
+
+	return &overlayFS{
+		local:   http.Dir(dir),
+		bundled: bundled,
+	}
+}
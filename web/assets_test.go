@@ -0,0 +1,101 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssets(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dataDir)
+
+	bundledDir, err := ioutil.TempDir("", "bundled")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(bundledDir)
+
+	for p, content := range map[string]string{
+		filepath.Join(bundledDir, "index.html"):     "bundled index",
+		filepath.Join(bundledDir, "app.js"):         "bundled app",
+		filepath.Join(dataDir, "web", "index.html"): "custom index",
+		filepath.Join(dataDir, "secret.txt"):        "secret",
+	} {
+		os.MkdirAll(filepath.Dir(p), 0755)
+
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := New(WithDataDir(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.FileServer(w.assets(http.Dir(bundledDir)))
+
+	get := func(url string) (int, string) {
+		rec := httptest.NewRecorder()
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = url
+
+		handler.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, body := get("/index.html"); code != http.StatusMovedPermanently {
+		// index.html is redirected to the directory
+		t.Errorf("Expected redirect of index.html, got %d %s", code, body)
+	}
+
+	if _, body := get("/"); body != "custom index" {
+		t.Errorf("Expected override of the bundled index, got %q", body)
+	}
+
+	if _, body := get("/app.js"); body != "bundled app" {
+		t.Errorf("Expected bundled asset without override, got %q", body)
+	}
+
+	// files outside the assets directory aren't served
+	for _, name := range []string{"/../secret.txt", "../secret.txt", "/web/../../secret.txt"} {
+		if code, body := get(name); code == http.StatusOK || body == "secret" {
+			t.Errorf("Expected %s not to be served, got %d %q", name, code, body)
+		}
+
+		if f, err := w.assets(http.Dir(bundledDir)).Open(name); err == nil {
+			f.Close()
+			t.Errorf("Expected %s not to be opened", name)
+		}
+	}
+
+	// without the assets directory the bundled assets are served
+	w.Assets = "missing"
+
+	if fs := w.assets(http.Dir(bundledDir)); fs != http.Dir(bundledDir) {
+		t.Errorf("Expected bundled assets without assets directory")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditEntry is an entry of the audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Role   string    `json:"role"`
+	Remote string    `json:"remote"`
+
+	// Action is export, agent-command or denied
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Detail string `json:"detail,omitempty"`
+
+	// Hash chains the entries, it is the hash of the hash of the previous
+	// entry and the entry itself. Changing or removing entries breaks the
+	// chain.
+	Hash string `json:"hash"`
+}
+
+func (e auditEntry) hash(prev string) string {
+	e.Hash = ""
+
+	data, _ := json.Marshal(e)
+
+	hash := sha256.Sum256(append([]byte(prev), data...))
+	return hex.EncodeToString(hash[:])
+}
+
+// auditLog is an append-only log of the changes of the sensors and the
+// exports of data, one json entry per line.
+type auditLog struct {
+	path string
+
+	m    sync.Mutex
+	f    *os.File
+	last string
+}
+
+// openAuditLog opens the audit log for appending, the chain of the existing
+// entries is verified.
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+
+	entries, err := a.entries()
+	if os.IsNotExist(err) {
+	} else if err != nil {
+		return nil, err
+	}
+
+	if n := len(entries); n > 0 {
+		a.last = entries[n-1].Hash
+	}
+
+	if err := verifyAudit(entries); err != nil {
+		log.Errorf("Error verifying audit log %s: %s", path, err.Error())
+	}
+
+	if a.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// verifyAudit verifies the chain of the entries.
+func verifyAudit(entries []auditEntry) error {
+	prev := ""
+	for i, e := range entries {
+		if e.hash(prev) != e.Hash {
+			return fmt.Errorf("Audit log has been modified at entry %d", i+1)
+		}
+
+		prev = e.Hash
+	}
+
+	return nil
+}
+
+func (a *auditLog) record(e auditEntry) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	e.Hash = e.hash(a.last)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	a.last = e.Hash
+
+	return a.f.Sync()
+}
+
+// entries returns all entries of the log, oldest first.
+func (a *auditLog) entries() ([]auditEntry, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	entries := []auditEntry{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		e := auditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// setupAudit opens the audit log in the data directory, there is no audit
+// log without data directory.
+func (web *web) setupAudit() error {
+	if web.AuditLog == "" || web.dataDir == "" {
+		return nil
+	}
+
+	a, err := openAuditLog(filepath.Join(web.dataDir, web.AuditLog))
+	if err != nil {
+		return err
+	}
+
+	web.auditLog = a
+	return nil
+}
+
+// audit records the action of the principal.
+func (web *web) audit(remote string, p principal, action, target, detail string) {
+	log.Infof("Audit: %s (%s) from %s: %s %s %s", p.Name, p.Role, remote, action, target, detail)
+
+	if web.auditLog == nil {
+		return
+	}
+
+	if err := web.auditLog.record(auditEntry{
+		Time:   time.Now().UTC(),
+		User:   p.Name,
+		Role:   p.Role,
+		Remote: remote,
+		Action: action,
+		Target: target,
+		Detail: detail,
+	}); err != nil {
+		log.Errorf("Error writing audit log: %s", err.Error())
+	}
+}
+
+// serveAuditV1 serves the last entries of the audit log, newest first.
+func (web *web) serveAuditV1(w http.ResponseWriter, r *http.Request) {
+	if web.auditLog == nil {
+		http.Error(w, "audit log not enabled", http.StatusNotFound)
+		return
+	}
+
+	limit, err := topValue(r.URL.Query().Get("limit"), 100)
+	if err != nil || limit < 1 {
+		http.Error(w, errInvalidParameter("limit").Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := web.auditLog.entries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := []auditEntry{}
+	for i := len(entries) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, entries[i])
+	}
+
+	writeJSON(w, result)
+}
@@ -0,0 +1,160 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	w, err := New(WithDataDir(dir), func(w *web) error {
+		w.Auth = AuthBasic
+		w.Users = []User{
+			{Name: "victor", Password: "v", Role: RoleViewer},
+			{Name: "olivia", Password: "o", Role: RoleOperator},
+			{Name: "adam", Password: "a", Role: RoleAdmin},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(user, password, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.SetBasicAuth(user, password)
+
+		rec := httptest.NewRecorder()
+		w.apiHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, c := range []struct {
+		user, password, path string
+		code                 int
+	}{
+		{"victor", "v", "/api/v1/metadata", http.StatusOK},
+		{"victor", "v", "/api/v1/events", http.StatusForbidden},
+		{"victor", "wrong", "/api/v1/metadata", http.StatusUnauthorized},
+		{"olivia", "o", "/api/v1/events", http.StatusOK},
+		{"olivia", "o", "/api/v1/audit", http.StatusForbidden},
+		{"adam", "a", "/api/v1/events", http.StatusOK},
+	} {
+		if rec := get(c.user, c.password, c.path); rec.Code != c.code {
+			t.Errorf("Expected %d for %s %s, got %d", c.code, c.user, c.path, rec.Code)
+		}
+	}
+
+	rec := get("adam", "a", "/api/v1/audit")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the audit log, got %d", rec.Code)
+	}
+
+	entries := []auditEntry{}
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+
+	// the denials and exports, newest first
+	expected := []string{"export adam", "denied olivia", "export olivia", "denied victor"}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d audit entries, got %v", len(expected), entries)
+	}
+
+	for i, e := range entries {
+		if e.Action+" "+e.User != expected[i] {
+			t.Errorf("Expected audit entry %q, got %q", expected[i], e.Action+" "+e.User)
+		}
+	}
+
+	if _, err := New(func(w *web) error {
+		w.Users = []User{{Name: "mallory", Password: "m", Role: "root"}}
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for unknown role")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.record(auditEntry{User: "alice", Action: "export", Target: "/api/v1/events"})
+	a.record(auditEntry{User: "alice", Action: "export", Target: "/api/v1/sessions"})
+
+	// the chain continues after reopening
+	a.f.Close()
+
+	if a, err = openAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+
+	a.record(auditEntry{User: "bob", Action: "agent-command", Target: "agent-1"})
+	a.f.Close()
+
+	entries, err := a.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+
+	if err := verifyAudit(entries); err != nil {
+		t.Errorf("Expected valid chain, got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(strings.Replace(string(data), "alice", "mallory", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, err = a.entries(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyAudit(entries); err == nil {
+		t.Errorf("Expected modified entry to break the chain")
+	}
+}
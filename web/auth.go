@@ -0,0 +1,304 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/proxy"
+)
+
+var (
+	ErrInvalidToken = errors.New("Invalid websocket token")
+	ErrTokenExpired = errors.New("Websocket token expired")
+)
+
+// Authentication of the api and the websocket.
+const (
+	AuthNone  = "none"
+	AuthBasic = "basic"
+	AuthToken = "token"
+	AuthOIDC  = "oidc"
+)
+
+// authMode returns the configured authentication, basic authentication is
+// implied by a password.
+func (web *web) authMode() string {
+	if web.Auth != "" {
+		return web.Auth
+	}
+
+	if web.Password != "" {
+		return AuthBasic
+	}
+
+	return AuthNone
+}
+
+// setupAuth validates the authentication configuration.
+func (web *web) setupAuth() error {
+	switch web.authMode() {
+	case AuthNone:
+		// without authentication anyone could request a token
+		if web.RequireToken {
+			return errors.New("Websocket token requires authentication, set auth to basic, token or oidc")
+		}
+	case AuthBasic:
+		if web.Password == "" && !web.hasUsers(func(u User) bool { return u.Password != "" }) {
+			return errors.New("Password of basic authentication not set")
+		}
+	case AuthToken:
+		if len(web.Tokens) == 0 && !web.hasUsers(func(u User) bool { return u.Token != "" }) {
+			return errors.New("Tokens of token authentication not set")
+		}
+	case AuthOIDC:
+		p, err := proxy.New(web.Proxy)
+		if err != nil {
+			return err
+		}
+
+		client := &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy: p,
+			},
+		}
+
+		if web.oidc, err = newOIDCVerifier(web.OIDC, client); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown authentication %s, expected none, basic, token or oidc", web.Auth)
+	}
+
+	for _, u := range web.Users {
+		if err := u.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasUsers returns true if any of the users matches.
+func (web *web) hasUsers(fn func(User) bool) bool {
+	for _, u := range web.Users {
+		if fn(u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenRequired returns true if a token is required to open the websocket.
+func (web *web) tokenRequired() bool {
+	return web.RequireToken || web.authMode() != AuthNone
+}
+
+// bearerToken returns the token of the authorization header, or of the
+// X-Auth-Token header.
+func bearerToken(r *http.Request) string {
+	if v := r.Header.Get("Authorization"); strings.HasPrefix(v, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+	}
+
+	return r.Header.Get("X-Auth-Token")
+}
+
+// sameOrigin returns true if the origin is the host the request was sent to.
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, host)
+}
+
+func (web *web) sign(payload string) string {
+	mac := hmac.New(sha256.New, web.tokenSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueToken returns a token of the principal that is valid until the
+// expiry.
+func (web *web) issueToken(p principal, expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10) + ":" + p.Role + ":" + p.Name
+	return payload + "." + web.sign(payload)
+}
+
+// validateToken validates the signature and the expiry of the token, and
+// returns the principal the token was issued to.
+func (web *web) validateToken(token string, now time.Time) (principal, error) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return principal{}, ErrInvalidToken
+	}
+
+	if !hmac.Equal([]byte(web.sign(token[:i])), []byte(token[i+1:])) {
+		return principal{}, ErrInvalidToken
+	}
+
+	parts := strings.SplitN(token[:i], ":", 3)
+	if len(parts) != 3 {
+		return principal{}, ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return principal{}, ErrInvalidToken
+	}
+
+	if now.Unix() > expires {
+		return principal{}, ErrTokenExpired
+	}
+
+	return principal{Name: parts[2], Role: parts[1]}, nil
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authenticate returns the principal of the credentials of the configured
+// authentication. The configured username and tokens are admins, users
+// have the role they are configured with.
+func (web *web) authenticate(r *http.Request) (principal, bool) {
+	switch web.authMode() {
+	case AuthNone:
+		return anonymous, true
+	case AuthBasic:
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return principal{}, false
+		}
+
+		if web.Password != "" && equal(username, web.Username) && equal(password, web.Password) {
+			return principal{Name: username, Role: RoleAdmin}, true
+		}
+
+		for _, u := range web.Users {
+			if u.Password != "" && equal(username, u.Name) && equal(password, u.Password) {
+				return principal{Name: u.Name, Role: u.Role}, true
+			}
+		}
+	case AuthToken:
+		token := bearerToken(r)
+		if token == "" {
+			return principal{}, false
+		}
+
+		for _, t := range web.Tokens {
+			if equal(token, t) {
+				return principal{Name: "token", Role: RoleAdmin}, true
+			}
+		}
+
+		for _, u := range web.Users {
+			if u.Token != "" && equal(token, u.Token) {
+				return principal{Name: u.Name, Role: u.Role}, true
+			}
+		}
+	case AuthOIDC:
+		token := bearerToken(r)
+		if token == "" {
+			return principal{}, false
+		}
+
+		id, err := web.oidc.Verify(token, time.Now())
+		if err != nil {
+			log.Debugf("Refused id token from %s: %s", r.RemoteAddr, err.Error())
+			return principal{}, false
+		}
+
+		if id.Role == "" {
+			log.Debugf("Refused id token of %s from %s: no role", id.Name, r.RemoteAddr)
+			return principal{}, false
+		}
+
+		return principal{Name: id.Name, Role: id.Role}, true
+	}
+
+	return principal{}, false
+}
+
+// unauthorized asks the client to authenticate.
+func (web *web) unauthorized(w http.ResponseWriter) {
+	if web.authMode() == AuthBasic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="honeytrap"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="honeytrap"`)
+	}
+
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// protect requires authentication for the handler, the websocket tokens
+// issued to the dashboard are accepted as well. The principal is passed in
+// the context of the request.
+func (web *web) protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := web.authenticate(r)
+		if !ok {
+			token := bearerToken(r)
+			if token == "" {
+				web.unauthorized(w)
+				return
+			}
+
+			var err error
+			if p, err = web.validateToken(token, time.Now()); err != nil {
+				web.unauthorized(w)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, withPrincipal(r, p))
+	})
+}
+
+// serveToken issues a websocket token after the dashboard login.
+func (web *web) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, ok := web.authenticate(r)
+	if !ok {
+		web.unauthorized(w)
+		return
+	}
+
+	expires := time.Now().Add(web.TokenTTL.Duration())
+
+	writeJSON(w, map[string]interface{}{
+		"token":   web.issueToken(p, expires),
+		"expires": expires,
+		"user":    p.Name,
+		"role":    p.Role,
+	})
+}
@@ -0,0 +1,216 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToken(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	alice := principal{Name: "alice", Role: RoleOperator}
+
+	token := w.issueToken(alice, now.Add(time.Minute))
+
+	if p, err := w.validateToken(token, now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	} else if p != alice {
+		t.Errorf("Expected principal %v, got %v", alice, p)
+	}
+
+	if _, err := w.validateToken(token, now.Add(2*time.Minute)); err != ErrTokenExpired {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+
+	// extending the expiry invalidates the signature
+	forged := w.issueToken(alice, now.Add(time.Hour))[:10] + token[10:]
+	if _, err := w.validateToken(forged, now); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+
+	// as does changing the role
+	forged = strings.Replace(token, RoleOperator, RoleAdmin, 1)
+	if _, err := w.validateToken(forged, now); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+
+	other, _ := New()
+	if _, err := other.validateToken(token, now); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for other secret, got %v", err)
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Username = "admin"
+	w.Password = "secret"
+
+	// the websocket is refused without token
+	rec := httptest.NewRecorder()
+	w.ServeWS(rec, httptest.NewRequest("GET", "/ws", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected websocket to be refused, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	w.apiHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/token", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected unauthorized, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/api/token", nil)
+	req.SetBasicAuth("admin", "secret")
+
+	rec = httptest.NewRecorder()
+	w.apiHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected token, got %d", rec.Code)
+	}
+
+	result := struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := w.validateToken(result.Token, time.Now()); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	} else if p.Name != "admin" || p.Role != RoleAdmin {
+		t.Errorf("Expected token of the admin, got %v", p)
+	}
+}
+
+func TestTokenWithoutAuth(t *testing.T) {
+	if _, err := New(func(w *web) error {
+		w.Auth = AuthNone
+		w.RequireToken = true
+		return nil
+	}); err == nil {
+		t.Fatal("Expected error for websocket token without authentication")
+	}
+
+	w, err := New(func(w *web) error {
+		w.RequireToken = true
+		w.Username = "admin"
+		w.Password = "secret"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	w.apiHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/token", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected unauthorized, got %d", rec.Code)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	w, err := New(func(w *web) error {
+		w.Auth = AuthToken
+		w.Tokens = []string{"secret-token"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(header, value string) int {
+		req := httptest.NewRequest("GET", "/api/v1/metadata", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		w.apiHandler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized without token, got %d", code)
+	}
+
+	if code := get("Authorization", "Bearer wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized with wrong token, got %d", code)
+	}
+
+	if code := get("Authorization", "Bearer secret-token"); code != http.StatusOK {
+		t.Errorf("Expected ok with api token, got %d", code)
+	}
+
+	if code := get("X-Auth-Token", "secret-token"); code != http.StatusOK {
+		t.Errorf("Expected ok with api token header, got %d", code)
+	}
+
+	// the websocket tokens of the dashboard are accepted
+	if code := get("Authorization", "Bearer "+w.issueToken(principal{Name: "alice", Role: RoleViewer}, time.Now().Add(time.Minute))); code != http.StatusOK {
+		t.Errorf("Expected ok with websocket token, got %d", code)
+	}
+
+	if _, err := New(func(w *web) error {
+		w.Auth = AuthToken
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for token authentication without tokens")
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	w, err := New(func(w *web) error {
+		w.Password = "secret"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "http://honeytrap.example.com/ws", nil)
+
+	req.Header.Set("Origin", "http://honeytrap.example.com")
+	if !w.upgrader().CheckOrigin(req) {
+		t.Errorf("Expected origin of the dashboard to be allowed")
+	}
+
+	req.Header.Set("Origin", "http://evil.example.com")
+	if w.upgrader().CheckOrigin(req) {
+		t.Errorf("Expected other origin to be refused")
+	}
+
+	w.CORSOrigins = []string{"http://evil.example.com"}
+	if !w.upgrader().CheckOrigin(req) {
+		t.Errorf("Expected allowed origin to be allowed")
+	}
+}
@@ -16,9 +16,11 @@ package web
 import (
 	"bytes"
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/honeytrap/honeytrap/event"
 )
 
 const (
@@ -31,8 +33,9 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait
 	pingPeriod = 1 * time.Second
 
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
+	// Maximum message size allowed from peer, large enough for the
+	// subscriptions
+	maxMessageSize = 4096
 )
 
 type connection struct {
@@ -40,14 +43,129 @@ type connection struct {
 
 	web *web
 
+	// principal is the user of the token the connection was opened with
+	principal principal
+	remote    string
+
 	send chan json.Marshaler
+
+	m sync.Mutex
+
+	// subscription filters the events, all events are sent when nil
+	subscription *subscription
+
+	// closing is set when the server closes the connection
+	closing bool
+}
+
+// close asks the client to close the connection, the connection is closed
+// when the client doesn't respond in time.
+func (c *connection) close() {
+	c.m.Lock()
+	c.closing = true
+	c.m.Unlock()
+
+	deadline := time.Now().Add(writeWait)
+
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server stopping"), deadline)
+	c.ws.SetReadDeadline(deadline)
+}
+
+func (c *connection) isClosing() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.closing
+}
+
+// subscribed returns true if the message should be sent to the client, the
+// events are filtered by the subscription of the client. The events contain
+// the captured credentials and payloads, like the exports of the api they
+// are only sent to operators.
+func (c *connection) subscribed(msg json.Marshaler) bool {
+	m, ok := msg.(*Message)
+	if !ok || m.Type != "event" {
+		return true
+	}
+
+	e, ok := m.Data.(event.Event)
+	if !ok {
+		return true
+	}
+
+	if !c.principal.allowed(RoleOperator) {
+		return false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.subscription == nil || c.subscription.Match(e)
+}
+
+// handleMessage handles the messages of the client, the client can
+// (un)subscribe to a selection of the events and query the history.
+func (c *connection) handleMessage(message []byte) {
+	msg := struct {
+		Type string          `json:"type"`
+		ID   json.RawMessage `json:"id"`
+		Data json.RawMessage `json:"data"`
+	}{}
+
+	if err := json.Unmarshal(message, &msg); err != nil {
+		log.Errorf("Error decoding websocket message: %s", err.Error())
+		return
+	}
+
+	var s *subscription
+
+	switch msg.Type {
+	case "subscribe":
+		s = &subscription{}
+		if err := json.Unmarshal(msg.Data, s); err != nil {
+			log.Errorf("Error decoding subscription: %s", err.Error())
+			return
+		}
+	case "unsubscribe":
+	case "query":
+		c.handleQuery(msg.ID, msg.Data)
+		return
+	default:
+		log.Errorf("Unsupported websocket message type: %s", msg.Type)
+		return
+	}
+
+	c.m.Lock()
+	c.subscription = s
+	c.m.Unlock()
+
+	// the recent events are replaced by the events of the subscription
+	c.send <- Data("events", c.recentEvents(s))
+}
+
+// recentEvents returns the recent events matching the subscription, viewers
+// don't receive events.
+func (c *connection) recentEvents(s *subscription) interface{} {
+	if !c.principal.allowed(RoleOperator) {
+		return []event.Event{}
+	}
+
+	if s == nil {
+		return c.web.events
+	}
+
+	return s.filterEvents(c.web.events)
 }
 
 func (c *connection) readPump() {
 	c.ws.SetReadLimit(maxMessageSize)
 	c.ws.SetReadDeadline(time.Now().Add(pongWait))
 	c.ws.SetPongHandler(func(string) error {
-		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		// the deadline of a closing connection isn't extended
+		if !c.isClosing() {
+			c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		}
+
 		return nil
 	})
 
@@ -61,7 +179,7 @@ func (c *connection) readPump() {
 			break
 		}
 
-		_ = message
+		c.handleMessage(message)
 	}
 }
 
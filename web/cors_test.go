@@ -0,0 +1,57 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.CORSOrigins = []string{"https://portal.example.com"}
+
+	h := w.cors(w.apiHandler())
+
+	req := httptest.NewRequest("OPTIONS", "/api/jobs", nil)
+	req.Header.Set("Origin", "https://portal.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected preflight to succeed, got %d", rec.Code)
+	}
+
+	if v := rec.Header().Get("Access-Control-Allow-Origin"); v != "https://portal.example.com" {
+		t.Errorf("Expected allowed origin, got %q", v)
+	}
+
+	req = httptest.NewRequest("OPTIONS", "/api/jobs", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if v := rec.Header().Get("Access-Control-Allow-Origin"); v != "" {
+		t.Errorf("Expected origin to be refused, got %q", v)
+	}
+}
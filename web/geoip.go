@@ -0,0 +1,267 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/proxy"
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+var (
+	ErrNoLicenseKey     = errors.New("A MaxMind license key is required to download the GeoLite2 database")
+	ErrChecksumMismatch = errors.New("Checksum of the GeoLite2 database doesn't match")
+)
+
+// GeoIPConfig configures the download of the GeoLite2 database, used to
+// resolve the countries of the sources.
+type GeoIPConfig struct {
+	// LicenseKey is the license key of the MaxMind account
+	LicenseKey string `toml:"license-key"`
+
+	// URL is the download endpoint
+	URL string `toml:"url"`
+
+	// Edition is the database edition, GeoLite2-Country or GeoLite2-City
+	// which adds the city and location of the sources
+	Edition string `toml:"edition"`
+
+	// ASN downloads the GeoLite2-ASN database as well, to resolve the
+	// autonomous systems of the sources
+	ASN bool `toml:"asn"`
+
+	// Interval is the time between updates of the database
+	Interval config.Delay `toml:"interval"`
+}
+
+// asnEdition is the edition of the autonomous systems database.
+const asnEdition = "GeoLite2-ASN"
+
+func (c GeoIPConfig) downloadURL(edition, suffix string) string {
+	v := url.Values{}
+	v.Set("edition_id", edition)
+	v.Set("license_key", c.LicenseKey)
+	v.Set("suffix", suffix)
+
+	return c.URL + "?" + v.Encode()
+}
+
+func (web *web) get(client *http.Client, u string) (*http.Response, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		// the error contains the url, with the license key
+		return nil, fmt.Errorf("Error downloading GeoLite2 database from %s", web.GeoIP.URL)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Unexpected status code %d downloading GeoLite2 database", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// checksum returns the sha256 checksum of the archive of the database.
+func (web *web) checksum(client *http.Client, edition string) (string, error) {
+	resp, err := web.get(client, web.GeoIP.downloadURL(edition, "tar.gz.sha256"))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+
+	// the checksum is followed by the name of the archive
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", errors.New("Empty checksum of GeoLite2 database")
+	}
+
+	return strings.ToLower(fields[0]), nil
+}
+
+// download downloads the GeoLite2 database edition through the configured
+// proxy, the checksum of the archive is verified before the database is
+// extracted to dest.
+func (web *web) download(edition, dest string) error {
+	if web.GeoIP.LicenseKey == "" {
+		return ErrNoLicenseKey
+	}
+
+	p, err := proxy.New(web.Proxy)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: p,
+		},
+	}
+
+	checksum, err := web.checksum(client, edition)
+	if err != nil {
+		return err
+	}
+
+	resp, err := web.get(client, web.GeoIP.downloadURL(edition, "tar.gz"))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	archive, err := ioutil.TempFile("", "geolite2")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(io.MultiWriter(archive, hash), resp.Body); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return ErrChecksumMismatch
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return extractDatabase(archive, dest)
+}
+
+// extractDatabase extracts the database from the archive, the database is
+// in a directory named after the edition and release date.
+func extractDatabase(r io.Reader, dest string) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errors.New("No database found in GeoLite2 archive")
+		} else if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".mmdb") {
+			continue
+		}
+
+		f, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		_, err = io.Copy(f, tr)
+		return err
+	}
+}
+
+// geoDB is a GeoLite2 database in the data dir. The databases are
+// downloaded here, the sources are resolved by the server before the
+// events reach the channels.
+type geoDB struct {
+	edition string
+	path    string
+}
+
+// databases returns the configured databases.
+func (web *web) databases() []*geoDB {
+	dbs := []*geoDB{}
+
+	for _, g := range []*geoDB{web.geoip, web.asn} {
+		if g != nil {
+			dbs = append(dbs, g)
+		}
+	}
+
+	return dbs
+}
+
+// refresh downloads the database and replaces the database in the data
+// dir.
+func (web *web) refresh(g *geoDB) error {
+	tmpPath := g.path + ".download"
+
+	if err := web.download(g.edition, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// verify the download before replacing the current database
+	db, err := maxminddb.Open(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	db.Close()
+
+	return os.Rename(tmpPath, g.path)
+}
+
+// RefreshGeoIP downloads the GeoLite2 databases and replaces the databases
+// in the data dir.
+func (web *web) RefreshGeoIP() error {
+	for _, g := range web.databases() {
+		if err := web.refresh(g); err != nil {
+			return fmt.Errorf("Error refreshing %s: %s", g.edition, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// fetch downloads the databases that are missing, the databases are
+// refreshed by the geoip job.
+func (web *web) fetch() {
+	for _, g := range web.databases() {
+		if _, err := os.Stat(g.path); !os.IsNotExist(err) {
+		} else if err := web.download(g.edition, g.path); err != nil {
+			log.Errorf("Error downloading %s database: %s", g.edition, err.Error())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeoIPDownload(t *testing.T) {
+	content := []byte("database")
+
+	buff := &bytes.Buffer{}
+
+	gzw := gzip.NewWriter(buff)
+	tw := tar.NewWriter(gzw)
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-Country_20190101/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-Country_20190101/GeoLite2-Country.mmdb", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	gzw.Close()
+
+	archive := buff.Bytes()
+	checksum := fmt.Sprintf("%x", sha256.Sum256(archive))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("license_key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Query().Get("suffix") {
+		case "tar.gz":
+			w.Write(archive)
+		case "tar.gz.sha256":
+			fmt.Fprintf(w, "%s  GeoLite2-Country_20190101.tar.gz\n", checksum)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	dest := filepath.Join(dir, "GeoLite2-Country.mmdb")
+
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.GeoIP.URL = ts.URL
+
+	if err := w.download("GeoLite2-Country", dest); err != ErrNoLicenseKey {
+		t.Fatalf("Expected license key error, got %v", err)
+	}
+
+	w.GeoIP.LicenseKey = "key"
+
+	if err := w.download("GeoLite2-Country", dest); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(dest); !bytes.Equal(data, content) {
+		t.Errorf("Expected the database to be extracted, got %q", data)
+	}
+
+	checksum = fmt.Sprintf("%x", sha256.Sum256(nil))
+
+	if err := w.download("GeoLite2-Country", dest); err != ErrChecksumMismatch {
+		t.Errorf("Expected checksum mismatch, got %v", err)
+	}
+}
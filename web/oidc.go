@@ -0,0 +1,337 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("Invalid id token")
+	ErrIDTokenExpired = errors.New("Id token expired")
+	ErrUnknownKey     = errors.New("Id token signed with unknown key")
+)
+
+// OIDCConfig configures the validation of the id tokens of an OpenID
+// Connect provider.
+type OIDCConfig struct {
+	// Issuer is the url of the provider, the keys are discovered from
+	// the provider configuration
+	Issuer string `toml:"issuer"`
+
+	// ClientID is the expected audience of the tokens
+	ClientID string `toml:"client-id"`
+
+	// RoleClaim is the claim with the role or roles of the user, nested
+	// claims are separated by dots, eg. realm_access.roles
+	RoleClaim string `toml:"role-claim"`
+
+	// DefaultRole is the role of users without role claim, users without
+	// role are refused when empty
+	DefaultRole string `toml:"default-role"`
+}
+
+// keysRefreshInterval limits the refreshes of the keys of the provider,
+// tokens signed with unknown keys would cause a refresh otherwise.
+const keysRefreshInterval = 5 * time.Minute
+
+// oidcVerifier verifies RS256 signed id tokens against the keys of the
+// provider.
+type oidcVerifier struct {
+	config OIDCConfig
+	client *http.Client
+
+	m         sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	refreshed time.Time
+}
+
+func newOIDCVerifier(config OIDCConfig, client *http.Client) (*oidcVerifier, error) {
+	if config.Issuer == "" {
+		return nil, errors.New("Issuer of oidc authentication not set")
+	}
+
+	if config.ClientID == "" {
+		return nil, errors.New("Client id of oidc authentication not set")
+	}
+
+	if config.DefaultRole != "" && roleRank(config.DefaultRole) < 0 {
+		return nil, fmt.Errorf("Unknown default role %q of oidc authentication", config.DefaultRole)
+	}
+
+	return &oidcVerifier{
+		config: config,
+		client: client,
+		keys:   map[string]*rsa.PublicKey{},
+	}, nil
+}
+
+func (v *oidcVerifier) get(url string, dest interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status code %d for %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// refresh retrieves the keys of the provider, using the jwks uri of the
+// provider configuration.
+func (v *oidcVerifier) refresh() error {
+	discovery := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+
+	if err := v.get(strings.TrimSuffix(v.config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return err
+	}
+
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+
+	if err := v.get(discovery.JWKSURI, &jwks); err != nil {
+		return err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	v.keys = keys
+	return nil
+}
+
+// key returns the key with the id, the keys are refreshed for unknown ids
+// to follow key rotation of the provider.
+func (v *oidcVerifier) key(kid string, now time.Time) (*rsa.PublicKey, error) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	if now.Sub(v.refreshed) < keysRefreshInterval {
+		return nil, ErrUnknownKey
+	}
+
+	v.refreshed = now
+
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// audience is a single audience or a list of audiences.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// idToken is the user of a verified id token.
+type idToken struct {
+	Name string
+	Role string
+}
+
+// claim returns the value of the claim, nested claims are separated by dots.
+func claim(claims map[string]interface{}, name string) interface{} {
+	var v interface{} = claims
+
+	for _, key := range strings.Split(name, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		v = m[key]
+	}
+
+	return v
+}
+
+// role returns the highest role of the role claim, or the default role.
+func (v *oidcVerifier) role(claims map[string]interface{}) string {
+	role := v.config.DefaultRole
+
+	values := []interface{}{}
+	switch value := claim(claims, v.config.RoleClaim).(type) {
+	case string:
+		values = append(values, value)
+	case []interface{}:
+		values = value
+	}
+
+	for _, value := range values {
+		if s, ok := value.(string); ok && roleRank(s) > roleRank(role) {
+			role = s
+		}
+	}
+
+	return role
+}
+
+// Verify verifies the signature, issuer, audience and expiry of the token,
+// and returns the name and role of the user.
+func (v *oidcVerifier) Verify(token string, now time.Time) (idToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	if header.Alg != "RS256" {
+		return idToken{}, fmt.Errorf("Unsupported id token algorithm %q", header.Alg)
+	}
+
+	key, err := v.key(header.Kid, now)
+	if err != nil {
+		return idToken{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	claims := struct {
+		Issuer    string   `json:"iss"`
+		Audience  audience `json:"aud"`
+		Expires   int64    `json:"exp"`
+		NotBefore int64    `json:"nbf"`
+	}{}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	if claims.Issuer != v.config.Issuer {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	if !contains(claims.Audience, v.config.ClientID) {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	if now.Unix() > claims.Expires {
+		return idToken{}, ErrIDTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	all := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &all); err != nil {
+		return idToken{}, ErrInvalidIDToken
+	}
+
+	id := idToken{Role: v.role(all)}
+
+	for _, name := range []string{"email", "preferred_username", "sub"} {
+		if s, ok := all[name].(string); ok && s != "" {
+			id.Name = s
+			break
+		}
+	}
+
+	return id, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signIDToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	payload := encode(map[string]string{"alg": "RS256", "kid": kid}) + "." + encode(claims)
+
+	hashed := sha256.Sum256([]byte(payload))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var issuer string
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			writeJSON(w, map[string]string{"jwks_uri": issuer + "/keys"})
+		case "/keys":
+			writeJSON(w, map[string]interface{}{
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"kid": "1",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer provider.Close()
+
+	issuer = provider.URL
+
+	v, err := newOIDCVerifier(OIDCConfig{Issuer: issuer, ClientID: "honeytrap"}, provider.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	claims := func(aud interface{}, exp time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer,
+			"aud": aud,
+			"exp": exp.Unix(),
+		}
+	}
+
+	if _, err := v.Verify(signIDToken(t, key, "1", claims("honeytrap", now.Add(time.Hour))), now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+
+	if _, err := v.Verify(signIDToken(t, key, "1", claims([]string{"other", "honeytrap"}, now.Add(time.Hour))), now); err != nil {
+		t.Errorf("Expected valid token with multiple audiences, got %v", err)
+	}
+
+	if _, err := v.Verify(signIDToken(t, key, "1", claims("other", now.Add(time.Hour))), now); err != ErrInvalidIDToken {
+		t.Errorf("Expected ErrInvalidIDToken for other audience, got %v", err)
+	}
+
+	if _, err := v.Verify(signIDToken(t, key, "1", claims("honeytrap", now.Add(-time.Hour))), now); err != ErrIDTokenExpired {
+		t.Errorf("Expected ErrIDTokenExpired, got %v", err)
+	}
+
+	if _, err := v.Verify(signIDToken(t, key, "2", claims("honeytrap", now.Add(time.Hour))), now); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := v.Verify(signIDToken(t, other, "1", claims("honeytrap", now.Add(time.Hour))), now); err != ErrInvalidIDToken {
+		t.Errorf("Expected ErrInvalidIDToken for other key, got %v", err)
+	}
+
+	v.config.RoleClaim = "realm_access.roles"
+	v.config.DefaultRole = RoleViewer
+
+	c := claims("honeytrap", now.Add(time.Hour))
+	c["email"] = "alice@example.com"
+	c["realm_access"] = map[string]interface{}{"roles": []string{"offline_access", "operator"}}
+
+	if id, err := v.Verify(signIDToken(t, key, "1", c), now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	} else if id.Name != "alice@example.com" || id.Role != RoleOperator {
+		t.Errorf("Expected alice with the operator role, got %v", id)
+	}
+
+	if id, _ := v.Verify(signIDToken(t, key, "1", claims("honeytrap", now.Add(time.Hour))), now); id.Role != RoleViewer {
+		t.Errorf("Expected the default role, got %v", id)
+	}
+}
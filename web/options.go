@@ -18,7 +18,14 @@ import (
 	"path/filepath"
 
 	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/c2"
+	"github.com/honeytrap/honeytrap/credentials"
 	"github.com/honeytrap/honeytrap/pushers/eventbus"
+	"github.com/honeytrap/honeytrap/scheduler"
+	"github.com/honeytrap/honeytrap/stats"
+	"github.com/honeytrap/honeytrap/tracing"
+	"github.com/honeytrap/honeytrap/transcript"
+	"github.com/honeytrap/honeytrap/trends"
 )
 
 func WithEventBus(bus *eventbus.EventBus) func(*web) error {
@@ -28,6 +35,64 @@ func WithEventBus(bus *eventbus.EventBus) func(*web) error {
 	}
 }
 
+// WithStats sets the aggregated counters served by the stats api.
+func WithStats(s *stats.Stats) func(*web) error {
+	return func(w *web) error {
+		w.stats = s
+		return nil
+	}
+}
+
+// WithCredentials sets the credential tracker served by the credentials api.
+func WithCredentials(t *credentials.Tracker) func(*web) error {
+	return func(w *web) error {
+		w.credentials = t
+		return nil
+	}
+}
+
+// WithC2 sets the tracker of the c2 servers served by the c2 api.
+func WithC2(t *c2.Tracker) func(*web) error {
+	return func(w *web) error {
+		w.c2 = t
+		return nil
+	}
+}
+
+// WithScheduler sets the scheduler of the background jobs served by the jobs
+// api.
+func WithScheduler(s *scheduler.Scheduler) func(*web) error {
+	return func(w *web) error {
+		w.scheduler = s
+		return nil
+	}
+}
+
+// WithTranscripts sets the transcript store served by the transcripts api.
+func WithTranscripts(s *transcript.Store) func(*web) error {
+	return func(w *web) error {
+		w.transcripts = s
+		return nil
+	}
+}
+
+// WithTracer sets the tracer of the event pipeline served by the
+// diagnostics api.
+func WithTracer(t *tracing.Tracer) func(*web) error {
+	return func(w *web) error {
+		w.tracer = t
+		return nil
+	}
+}
+
+// WithTrends sets the trend store served by the trends api.
+func WithTrends(s *trends.Store) func(*web) error {
+	return func(w *web) error {
+		w.trends = s
+		return nil
+	}
+}
+
 func WithDataDir(dataDir string) func(*web) error {
 	return func(w *web) error {
 		w.dataDir = dataDir
@@ -0,0 +1,200 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/honeytrap/honeytrap/transcript"
+)
+
+// query is a request of the dashboard over the websocket, the response
+// carries the id of the request:
+//
+//	{"type": "query", "id": 1, "data": {"method": "events", "params": {"category": "ssh", "since": "2019-06-01T00:00:00Z"}}}
+//	{"type": "response", "id": 1, "data": [...]}
+//
+// Failed queries are answered with {"type": "response", "id": 1, "error": "..."}.
+type query struct {
+	// Method is events, sessions, replay, service_stats, transcript or
+	// trends
+	Method string `json:"method"`
+
+	// Params are the parameters of the method, the events, sessions and
+	// replay methods accept the query parameters of the v1 api
+	Params map[string]interface{} `json:"params"`
+}
+
+// response is the response to a query.
+type response struct {
+	ID    json.RawMessage `json:"id"`
+	Data  interface{}     `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+func (r response) MarshalJSON() ([]byte, error) {
+	type alias response
+
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		alias
+	}{
+		Type:  "response",
+		alias: alias(r),
+	})
+}
+
+var (
+	errUnknownMethod       = errors.New("unknown method")
+	errNotFound            = errors.New("not found")
+	errTranscriptsDisabled = errors.New("transcripts not enabled")
+	errTrendsDisabled      = errors.New("trends not enabled")
+	errForbidden           = errors.New("forbidden")
+)
+
+// exports are the methods that export data, they require the operator role.
+var exports = map[string]bool{
+	"events":     true,
+	"sessions":   true,
+	"replay":     true,
+	"transcript": true,
+}
+
+// values returns the parameters as query parameters.
+func (q query) values() url.Values {
+	v := url.Values{}
+
+	for key, value := range q.Params {
+		switch value.(type) {
+		case string, float64, bool:
+			v.Set(key, fmt.Sprint(value))
+		}
+	}
+
+	return v
+}
+
+// handleQuery answers the query of the dashboard.
+func (c *connection) handleQuery(id json.RawMessage, data json.RawMessage) {
+	resp := response{ID: id}
+
+	q := query{}
+	if err := json.Unmarshal(data, &q); err != nil {
+		resp.Error = "invalid query"
+		c.send <- resp
+		return
+	}
+
+	target := "query " + q.Method
+	if params := q.values().Encode(); params != "" {
+		target += "?" + params
+	}
+
+	if !exports[q.Method] {
+	} else if !c.principal.allowed(RoleOperator) {
+		c.web.audit(c.remote, c.principal, "denied", target, RoleOperator+" role required")
+
+		resp.Error = errForbidden.Error()
+		c.send <- resp
+		return
+	} else {
+		c.web.audit(c.remote, c.principal, "export", target, "")
+	}
+
+	if result, err := c.web.query(q); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Data = result
+	}
+
+	c.send <- resp
+}
+
+// query returns the result of the query.
+func (web *web) query(q query) (interface{}, error) {
+	params := q.values()
+
+	switch q.Method {
+	case "events", "sessions":
+		eq, err := parseEventValues(params)
+		if err != nil {
+			return nil, err
+		}
+
+		eq.Sessions = q.Method == "sessions"
+
+		return web.recentEvents(eq)
+	case "replay":
+		eq, err := parseEventValues(params)
+		if err != nil {
+			return nil, err
+		}
+
+		if eq.Session == "" {
+			return web.sessionReplays(eq)
+		}
+
+		sr, err := web.sessionReplay(eq.Session, eq)
+		if err != nil {
+			return nil, err
+		} else if sr == nil {
+			return nil, errNotFound
+		}
+
+		return sr, nil
+	case "service_stats":
+		top, err := topValue(params.Get("top"), serviceStatsTop)
+		if err != nil {
+			return nil, errInvalidParameter("top")
+		}
+
+		service := params.Get("service")
+		if service == "" {
+			return web.stats.ServiceStats(top), nil
+		}
+
+		ss, ok := web.stats.Service(service, top)
+		if !ok {
+			return nil, errNotFound
+		}
+
+		return ss, nil
+	case "trends":
+		return web.trendSeries(params)
+	case "transcript":
+		if web.transcripts == nil {
+			return nil, errTranscriptsDisabled
+		}
+
+		id := params.Get("id")
+		if id == "" {
+			return web.transcripts.List()
+		}
+
+		t, err := web.transcripts.Load(id)
+		if err == transcript.ErrInvalidID || os.IsNotExist(err) {
+			return nil, errNotFound
+		} else if err != nil {
+			return nil, err
+		}
+
+		return t, nil
+	}
+
+	return nil, errUnknownMethod
+}
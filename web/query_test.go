@@ -0,0 +1,138 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/trends"
+)
+
+func TestQuery(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	w.events.Append(event.New(event.Category("ssh"), event.Custom("date", day.Add(-time.Hour)), event.Custom("ssh.sessionid", "s1")))
+	w.events.Append(event.New(event.Category("ssh"), event.Custom("date", day.Add(time.Hour)), event.Custom("ssh.sessionid", "s1")))
+	w.events.Append(event.New(event.Category("http"), event.Custom("date", day.Add(2*time.Hour))))
+
+	c := &connection{
+		web:       w,
+		principal: anonymous,
+		send:      make(chan json.Marshaler, 10),
+	}
+
+	query := func(message string) map[string]interface{} {
+		c.handleMessage([]byte(message))
+
+		data, err := json.Marshal(<-c.send)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := map[string]interface{}{}
+		if err := json.Unmarshal(data, &result); err != nil {
+			t.Fatal(err)
+		}
+
+		if result["type"] != "response" {
+			t.Fatalf("Expected response, got %v", result)
+		}
+
+		return result
+	}
+
+	// historical range
+	r := query(`{"type": "query", "id": 1, "data": {"method": "events", "params": {"since": "2019-06-01T00:00:00Z", "until": "2019-06-01T01:30:00Z"}}}`)
+	if r["id"] != float64(1) {
+		t.Errorf("Expected the id of the request, got %v", r["id"])
+	}
+
+	if events, _ := r["data"].([]interface{}); len(events) != 1 {
+		t.Errorf("Expected one event in the range, got %v", r["data"])
+	}
+
+	r = query(`{"type": "query", "id": "replay-1", "data": {"method": "replay", "params": {"session": "s1"}}}`)
+	if replay, _ := r["data"].(map[string]interface{}); r["id"] != "replay-1" || replay["count"] != float64(2) {
+		t.Errorf("Expected the replay of the session, got %v", r)
+	}
+
+	r = query(`{"type": "query", "id": 2, "data": {"method": "events", "params": {"limit": 1}}}`)
+	if events, _ := r["data"].([]interface{}); len(events) != 1 {
+		t.Errorf("Expected one event, got %v", r["data"])
+	}
+
+	r = query(`{"type": "query", "id": 3, "data": {"method": "events", "params": {"since": "yesterday"}}}`)
+	if r["error"] != "invalid since parameter" {
+		t.Errorf("Expected error for invalid parameter, got %v", r)
+	}
+
+	r = query(`{"type": "query", "id": 4, "data": {"method": "replay", "params": {"session": "unknown"}}}`)
+	if r["error"] != "not found" {
+		t.Errorf("Expected not found, got %v", r)
+	}
+
+	r = query(`{"type": "query", "id": 5, "data": {"method": "transcript", "params": {"id": "t1"}}}`)
+	if r["error"] != "transcripts not enabled" {
+		t.Errorf("Expected transcripts not enabled, got %v", r)
+	}
+
+	r = query(`{"type": "query", "id": 6, "data": {"method": "drop"}}`)
+	if r["error"] != "unknown method" {
+		t.Errorf("Expected unknown method, got %v", r)
+	}
+
+	r = query(`{"type": "query", "id": 7, "data": {"method": "service_stats"}}`)
+	if _, ok := r["data"].([]interface{}); !ok {
+		t.Errorf("Expected service stats, got %v", r)
+	}
+
+	r = query(`{"type": "query", "id": 8, "data": {"method": "trends"}}`)
+	if r["error"] != "trends not enabled" {
+		t.Errorf("Expected trends not enabled, got %v", r)
+	}
+
+	w.trends, _ = trends.New()
+	w.trends.Send(event.New(event.Category("ssh")))
+
+	r = query(`{"type": "query", "id": 9, "data": {"method": "trends", "params": {"resolution": "hour"}}}`)
+	if series, _ := r["data"].([]interface{}); len(series) != 1 {
+		t.Errorf("Expected the hourly rollup, got %v", r)
+	}
+
+	r = query(`{"type": "query", "id": 10, "data": {"method": "trends", "params": {"resolution": "week"}}}`)
+	if r["error"] != "invalid resolution parameter" {
+		t.Errorf("Expected error for invalid resolution, got %v", r)
+	}
+
+	// viewers can't export the events
+	c.principal = principal{Name: "alice", Role: RoleViewer}
+
+	r = query(`{"type": "query", "id": 11, "data": {"method": "events"}}`)
+	if r["error"] != "forbidden" {
+		t.Errorf("Expected forbidden for viewer, got %v", r)
+	}
+
+	r = query(`{"type": "query", "id": 12, "data": {"method": "service_stats"}}`)
+	if _, ok := r["data"].([]interface{}); !ok {
+		t.Errorf("Expected service stats for viewer, got %v", r)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// maxReplaySteps limits the number of events of a replay.
+const maxReplaySteps = 10000
+
+// sessionID returns the session id of the event, the services store the
+// id of the session as <category>.sessionid.
+func sessionID(e event.Event) string {
+	id := ""
+
+	e.Range(func(k, v interface{}) bool {
+		if key, ok := k.(string); !ok || !strings.HasSuffix(key, ".sessionid") {
+			return true
+		}
+
+		id, _ = v.(string)
+		return false
+	})
+
+	return id
+}
+
+// eventDate returns the date of the event.
+func eventDate(e event.Event) time.Time {
+	date := time.Time{}
+
+	e.Range(func(k, v interface{}) bool {
+		if k != "date" {
+			return true
+		}
+
+		date, _ = v.(time.Time)
+		return false
+	})
+
+	return date
+}
+
+// replayStep is a step of the session, with the action of the attacker.
+type replayStep struct {
+	// Offset is the number of seconds since the start of the session
+	Offset float64   `json:"offset"`
+	Date   time.Time `json:"date"`
+
+	// Kind is the kind of action: command, request, authentication,
+	// payload or event
+	Kind string `json:"kind"`
+	Data string `json:"data"`
+
+	Event event.Event `json:"event"`
+}
+
+// step returns the action of the event, commands typed in ssh and telnet,
+// http requests and the payloads of the services.
+func step(e event.Event) (string, string) {
+	command := ""
+
+	e.Range(func(k, v interface{}) bool {
+		key, ok := k.(string)
+		if !ok {
+			return true
+		}
+
+		if strings.HasSuffix(key, ".command") || strings.HasSuffix(key, ".exec") {
+			command, _ = v.(string)
+			return command == ""
+		}
+
+		return true
+	})
+
+	typ := e.Get("type")
+
+	switch {
+	case command != "":
+		return "command", command
+	case typ == "request" && e.Has("http.method"):
+		return "request", e.Get("http.method") + " " + e.Get("http.url")
+	case strings.HasSuffix(typ, "-authentication") || typ == "login":
+		return "authentication", e.Get(e.Get("category") + ".username")
+	case e.Get("payload") != "":
+		return "payload", e.Get("payload")
+	}
+
+	return "event", typ
+}
+
+// sessionReplay contains the steps of a session, in order.
+type sessionReplay struct {
+	Session  string    `json:"session"`
+	Category string    `json:"category"`
+	Source   string    `json:"source"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+
+	Steps []replayStep `json:"steps,omitempty"`
+
+	// Count is the number of steps, the steps are omitted in the list of
+	// sessions
+	Count int `json:"count"`
+}
+
+func (sr *sessionReplay) add(e event.Event) {
+	date := eventDate(e)
+
+	if sr.Start.IsZero() || date.Before(sr.Start) {
+		sr.Start = date
+	}
+
+	if date.After(sr.End) {
+		sr.End = date
+	}
+
+	if sr.Category == "" {
+		sr.Category = e.Get("category")
+	}
+
+	if sr.Source == "" {
+		sr.Source = e.Get("source-ip")
+	}
+
+	sr.Count++
+}
+
+// replay returns the replay of the events of the session, the events are
+// the most recent event first.
+func replay(id string, events []event.Event) *sessionReplay {
+	sr := &sessionReplay{
+		Session: id,
+		Steps:   []replayStep{},
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		sr.add(events[i])
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+
+		kind, data := step(e)
+
+		date := eventDate(e)
+
+		sr.Steps = append(sr.Steps, replayStep{
+			Offset: date.Sub(sr.Start).Seconds(),
+			Date:   date,
+			Kind:   kind,
+			Data:   data,
+			Event:  e,
+		})
+	}
+
+	// events of concurrent goroutines can arrive out of order
+	sort.SliceStable(sr.Steps, func(i, j int) bool {
+		return sr.Steps[i].Date.Before(sr.Steps[j].Date)
+	})
+
+	return sr
+}
+
+// replays groups the events by session, the most recently active session
+// first.
+func replays(events []event.Event) []*sessionReplay {
+	sessions := map[string]*sessionReplay{}
+
+	for _, e := range events {
+		id := sessionID(e)
+		if id == "" {
+			continue
+		}
+
+		sr, ok := sessions[id]
+		if !ok {
+			sr = &sessionReplay{Session: id}
+			sessions[id] = sr
+		}
+
+		sr.add(e)
+	}
+
+	result := make([]*sessionReplay, 0, len(sessions))
+	for _, sr := range sessions {
+		result = append(result, sr)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].End.Equal(result[j].End) {
+			return result[i].End.After(result[j].End)
+		}
+
+		return result[i].Session < result[j].Session
+	})
+
+	return result
+}
+
+// serveReplaysV1 serves the sessions of the services, grouped by session
+// id, or with /api/v1/replays/<id> the ordered steps of a single session:
+// the commands typed in ssh and telnet, the http requests and the payloads.
+// The sessions are filtered by the query parameters of the events.
+func (web *web) serveReplaysV1(w http.ResponseWriter, r *http.Request) {
+	eq, err := parseEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/replays")
+	id = strings.Trim(id, "/")
+
+	if id == "" {
+		sessions, err := web.sessionReplays(eq)
+		if err != nil {
+			log.Errorf("Error retrieving events: %s", err.Error())
+			http.Error(w, "error retrieving events", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, sessions)
+		return
+	}
+
+	sr, err := web.sessionReplay(id, eq)
+	if err != nil {
+		log.Errorf("Error retrieving events: %s", err.Error())
+		http.Error(w, "error retrieving events", http.StatusInternalServerError)
+		return
+	} else if sr == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, sr)
+}
+
+// sessionReplays returns the sessions of the events matching the query, the
+// limit and offset of the query apply to the sessions.
+func (web *web) sessionReplays(eq eventQuery) ([]*sessionReplay, error) {
+	limit, offset := eq.Limit, eq.Offset
+
+	eq.Limit, eq.Offset = 0, 0
+
+	events, err := web.recentEvents(eq)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := replays(events)
+
+	if offset >= len(sessions) {
+		sessions = sessions[:0]
+	} else {
+		sessions = sessions[offset:]
+	}
+
+	if limit > 0 && len(sessions) > limit {
+		sessions = sessions[:limit]
+	}
+
+	return sessions, nil
+}
+
+// sessionReplay returns the replay of the session, nil if the session has
+// no events matching the query.
+func (web *web) sessionReplay(id string, eq eventQuery) (*sessionReplay, error) {
+	eq.Session = id
+	eq.Limit = maxReplaySteps
+	eq.Offset = 0
+
+	events, err := web.recentEvents(eq)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	return replay(id, events), nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Roles of the users of the api, each role has the permissions of the roles
+// before it. Viewers see the dashboard and the statistics, operators can
+// export the events, sessions, transcripts and credentials, and admins can
+// change the sensors and read the audit log.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roles = []string{RoleViewer, RoleOperator, RoleAdmin}
+
+// roleRank returns the rank of the role, -1 for unknown roles.
+func roleRank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// User is a user of basic or token authentication, authenticated either by
+// name and password or by token.
+type User struct {
+	Name     string `toml:"name"`
+	Password string `toml:"password"`
+	Token    string `toml:"token"`
+
+	// Role is viewer, operator or admin
+	Role string `toml:"role"`
+}
+
+func (u User) validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("Name of user not set")
+	}
+
+	if u.Password == "" && u.Token == "" {
+		return fmt.Errorf("Password or token of user %s not set", u.Name)
+	}
+
+	if roleRank(u.Role) < 0 {
+		return fmt.Errorf("Unknown role %q of user %s, expected viewer, operator or admin", u.Role, u.Name)
+	}
+
+	return nil
+}
+
+// principal is the authenticated user of a request.
+type principal struct {
+	Name string
+	Role string
+}
+
+// anonymous is the principal without authentication, the interface is
+// expected to be protected by other means.
+var anonymous = principal{Name: "anonymous", Role: RoleAdmin}
+
+// allowed returns true if the principal has the permissions of the role.
+func (p principal) allowed(role string) bool {
+	return roleRank(p.Role) >= roleRank(role) && roleRank(p.Role) >= 0
+}
+
+type principalKey struct{}
+
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// principalFrom returns the principal of the authenticated request.
+func principalFrom(r *http.Request) principal {
+	if p, ok := r.Context().Value(principalKey{}).(principal); ok {
+		return p
+	}
+
+	return principal{}
+}
+
+// require requires the role for the handler. Data exports are recorded in
+// the audit log, as are refused requests.
+func (web *web) require(role string, export bool, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFrom(r)
+
+		if !p.allowed(role) {
+			web.audit(r.RemoteAddr, p, "denied", r.URL.RequestURI(), role+" role required")
+
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		if export {
+			web.audit(r.RemoteAddr, p, "export", r.URL.RequestURI(), "")
+		}
+
+		h(w, r)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// eventStore persists the events shown by the dashboard, the history is
+// kept across restarts.
+type eventStore interface {
+	Append(e event.Event) error
+
+	// Events returns the matching events, the most recent event first
+	Events(eq eventQuery) ([]event.Event, error)
+
+	Close() error
+}
+
+// StoreConfig configures the persistence of the events.
+type StoreConfig struct {
+	// Type is the type of store: memory or bolt, the events are only kept
+	// in memory by default
+	Type string `toml:"type"`
+
+	// Path is the database file, relative to the data directory
+	Path string `toml:"path"`
+
+	// MaxEvents is the number of events kept, older events are removed
+	MaxEvents int `toml:"max-events"`
+}
+
+// openEventStore returns the configured store, nil for the memory store.
+func openEventStore(c StoreConfig, dataDir string) (eventStore, error) {
+	switch c.Type {
+	case "", "memory":
+		return nil, nil
+	case "bolt":
+		p := c.Path
+		if p == "" {
+			p = "web-events.db"
+		}
+
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dataDir, p)
+		}
+
+		return newBoltStore(p, c.MaxEvents)
+	}
+
+	return nil, fmt.Errorf("Unknown event store type: %s", c.Type)
+}
+
+// setupStore opens the event store, the recent events of the dashboard are
+// restored from the store.
+func (web *web) setupStore() error {
+	store, err := openEventStore(web.Store, web.dataDir)
+	if err != nil {
+		return err
+	} else if store == nil {
+		return nil
+	}
+
+	events, err := store.Events(eventQuery{Limit: 1000})
+	if err != nil {
+		store.Close()
+		return err
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		web.events.Append(events[i])
+	}
+
+	web.store = store
+	return nil
+}
+
+var eventsBucket = []byte("events")
+
+// boltStore keeps the events in a bolt database, keyed by sequence.
+type boltStore struct {
+	db *bolt.DB
+
+	max int
+
+	m     sync.Mutex
+	count int
+}
+
+func newBoltStore(path string, max int) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &boltStore{
+		db:  db,
+		max: max,
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(eventsBucket)
+		if err != nil {
+			return err
+		}
+
+		s.count = b.Stats().KeyN
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *boltStore) Append(e event.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+
+		s.count++
+
+		if s.max <= 0 {
+			return nil
+		}
+
+		// remove the oldest events
+		c := b.Cursor()
+		for s.count > s.max {
+			if k, _ := c.First(); k == nil {
+				break
+			} else if err := c.Delete(); err != nil {
+				return err
+			}
+
+			s.count--
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) Events(eq eventQuery) ([]event.Event, error) {
+	events := []event.Event{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventsBucket).Cursor()
+
+		skipped := 0
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			e, err := decodeEvent(v)
+			if err != nil {
+				log.Errorf("Error decoding stored event %x: %s", k, err.Error())
+				continue
+			}
+
+			if !eq.Match(e) {
+				continue
+			}
+
+			if skipped < eq.Offset {
+				skipped++
+				continue
+			}
+
+			events = append(events, e)
+
+			if eq.Limit > 0 && len(events) >= eq.Limit {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return events, err
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// decodeEvent decodes a stored event, the date and lists are restored to
+// the types of the original event.
+func decodeEvent(data []byte) (event.Event, error) {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return event.Event{}, err
+	}
+
+	for k, v := range m {
+		switch v := v.(type) {
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					break
+				}
+
+				values = append(values, s)
+			}
+
+			if len(values) == len(v) {
+				m[k] = values
+			}
+		case string:
+			if k != "date" {
+				continue
+			}
+
+			if date, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				m[k] = date
+			}
+		}
+	}
+
+	return event.New(event.CopyFrom(m)), nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.db")
+
+	s, err := newBoltStore(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Now()
+
+	for _, service := range []string{"ssh", "http", "telnet", "smtp"} {
+		if err := s.Append(event.New(event.Service(service), event.Custom("signature.id", []string{"a", "b"}))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Close()
+
+	// the events are kept after a restart
+	s, err = newBoltStore(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	events, err := s.Events(eventQuery{Since: since.Add(-time.Second), Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || events[0].Get("service") != "telnet" || events[1].Get("service") != "http" {
+		t.Fatalf("Expected telnet and http events, got %v", events)
+	}
+
+	events[0].Range(func(k, v interface{}) bool {
+		if k != "signature.id" {
+			return true
+		}
+
+		if ids, ok := v.([]string); !ok || len(ids) != 2 {
+			t.Errorf("Expected signature ids to be restored, got %#v", v)
+		}
+
+		return false
+	})
+
+	if events, _ := s.Events(eventQuery{}); len(events) != 3 {
+		t.Errorf("Expected 3 events to be kept, got %d", len(events))
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// subscription filters the events pushed to a websocket client. The client
+// subscribes by sending:
+//
+//	{"type": "subscribe", "data": {"categories": ["ssh"], "ports": [22]}}
+//
+// and receives all events again after sending {"type": "unsubscribe"}. An
+// event has to match one of the values of each of the set filters.
+type subscription struct {
+	Categories []string `json:"categories"`
+	Services   []string `json:"services"`
+	Ports      []int    `json:"ports"`
+
+	// Countries are the iso codes of the countries of the sources
+	Countries []string `json:"countries"`
+}
+
+// destinationPort returns the destination port of the event, the type
+// depends on the origin of the event.
+func destinationPort(e event.Event) string {
+	port := ""
+
+	e.Range(func(k, v interface{}) bool {
+		if k != "destination-port" {
+			return true
+		}
+
+		port = fmt.Sprint(v)
+		return false
+	})
+
+	return port
+}
+
+// Match returns true if the event matches the subscription.
+func (s *subscription) Match(e event.Event) bool {
+	if len(s.Categories) > 0 && !contains(s.Categories, e.Get("category")) {
+		return false
+	}
+
+	if len(s.Services) > 0 && !contains(s.Services, e.Get("service")) {
+		return false
+	}
+
+	if len(s.Ports) > 0 {
+		port := destinationPort(e)
+
+		found := false
+		for _, p := range s.Ports {
+			if strconv.Itoa(p) == port {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(s.Countries) == 0 {
+		return true
+	}
+
+	isoCode := e.Get("source.country.isocode")
+
+	for _, c := range s.Countries {
+		if strings.EqualFold(c, isoCode) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterEvents returns the events of the array matching the subscription.
+func (s *subscription) filterEvents(sa *SafeArray) []event.Event {
+	events := []event.Event{}
+
+	sa.Range(func(v interface{}) bool {
+		if e, ok := v.(event.Event); ok && s.Match(e) {
+			events = append(events, e)
+		}
+
+		return true
+	})
+
+	return events
+}
@@ -0,0 +1,61 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestSubscription(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ssh := event.New(event.Category("ssh"), event.DestinationPort(22), event.Custom("source.country.isocode", "NL"))
+	http := event.New(event.Category("http"), event.Custom("destination-port", 80))
+
+	w.events.Append(ssh)
+	w.events.Append(http)
+
+	c := &connection{
+		web:       w,
+		principal: anonymous,
+		send:      make(chan json.Marshaler, 10),
+	}
+
+	c.handleMessage([]byte(`{"type": "subscribe", "data": {"ports": [22], "countries": ["nl"]}}`))
+
+	if events := (<-c.send).(*Message).Data.([]event.Event); len(events) != 1 || events[0].Get("category") != "ssh" {
+		t.Errorf("Expected the ssh event, got %v", events)
+	}
+
+	if !c.subscribed(Data("event", ssh)) || c.subscribed(Data("event", http)) {
+		t.Errorf("Expected only the ssh event to be sent")
+	}
+
+	if !c.subscribed(Data("hot_countries", w.hotCountries)) {
+		t.Errorf("Expected other messages to be sent")
+	}
+
+	c.handleMessage([]byte(`{"type": "unsubscribe"}`))
+	<-c.send
+
+	if !c.subscribed(Data("event", http)) {
+		t.Errorf("Expected all events after unsubscribing")
+	}
+}
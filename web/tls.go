@@ -0,0 +1,93 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig configures https for the dashboard and the websocket, using a
+// certificate or certificates provisioned by acme (eg. Let's Encrypt).
+type TLSConfig struct {
+	// Cert and Key are the files of the certificate
+	Cert string `toml:"tls_cert"`
+	Key  string `toml:"tls_key"`
+
+	// ACMEHosts are the host names certificates are provisioned for, the
+	// tls-alpn-01 challenge is answered on the web listener
+	ACMEHosts []string `toml:"acme_hosts"`
+
+	// ACMEEmail is the contact address of the acme account
+	ACMEEmail string `toml:"acme_email"`
+
+	// ACMEDirectory is the directory url of the acme server, Let's Encrypt
+	// is used when not set
+	ACMEDirectory string `toml:"acme_directory"`
+
+	// ACMECache is the directory, relative to the data directory, the
+	// account and certificates are cached in
+	ACMECache string `toml:"acme_cache"`
+}
+
+// Enabled returns true if https has been configured.
+func (c TLSConfig) Enabled() bool {
+	return c.Cert != "" || c.Key != "" || len(c.ACMEHosts) > 0
+}
+
+// ServerConfig returns the tls configuration of the listener.
+func (c TLSConfig) ServerConfig(dataDir string) (*tls.Config, error) {
+	if len(c.ACMEHosts) > 0 {
+		if c.Cert != "" || c.Key != "" {
+			return nil, errors.New("Certificate and acme are mutually exclusive")
+		}
+
+		cache := c.ACMECache
+		if cache == "" {
+			cache = "acme"
+		}
+
+		if !filepath.IsAbs(cache) {
+			cache = filepath.Join(dataDir, cache)
+		}
+
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.ACMEHosts...),
+			Cache:      autocert.DirCache(cache),
+			Email:      c.ACMEEmail,
+		}
+
+		if c.ACMEDirectory != "" {
+			m.Client = &acme.Client{DirectoryURL: c.ACMEDirectory}
+		}
+
+		return m.TLSConfig(), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading certificate: %s", err.Error())
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"honeytrap.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	var config struct {
+		Web toml.Primitive `toml:"web"`
+	}
+
+	md, err := toml.Decode(`
+[web]
+enabled = true
+tls_cert = "`+certFile+`"
+tls_key = "`+keyFile+`"
+`, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(WithConfig(config.Web, &md))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !w.TLSConfig.Enabled() {
+		t.Fatalf("Expected tls to be enabled")
+	}
+
+	c, err := w.TLSConfig.ServerConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Certificates) != 1 {
+		t.Errorf("Expected certificate to be loaded")
+	}
+
+	c, err = TLSConfig{ACMEHosts: []string{"honeytrap.example.com"}}.ServerConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.GetCertificate == nil {
+		t.Errorf("Expected certificates to be provisioned by acme")
+	}
+
+	if _, err := (TLSConfig{Cert: certFile, Key: keyFile, ACMEHosts: []string{"honeytrap.example.com"}}).ServerConfig(dir); err == nil {
+		t.Errorf("Expected error for certificate and acme")
+	}
+}
@@ -14,83 +14,149 @@
 package web
 
 import (
-	"compress/gzip"
+	"context"
+	"crypto/rand"
 	"encoding/json"
-	"io"
 	"net"
 	"net/http"
-	"os"
 	"path"
+	"sync"
 	"time"
 
+	"github.com/honeytrap/honeytrap/c2"
 	"github.com/honeytrap/honeytrap/cmd"
 	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/credentials"
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/graph"
 	"github.com/honeytrap/honeytrap/pushers/eventbus"
+	"github.com/honeytrap/honeytrap/scheduler"
+	"github.com/honeytrap/honeytrap/stats"
+	"github.com/honeytrap/honeytrap/tracing"
+	"github.com/honeytrap/honeytrap/transcript"
+	"github.com/honeytrap/honeytrap/trends"
 
 	assetfs "github.com/elazarl/go-bindata-assetfs"
 	"github.com/gorilla/websocket"
 	assets "github.com/honeytrap/honeytrap-web"
 	logging "github.com/op/go-logging"
-	maxminddb "github.com/oschwald/maxminddb-golang"
 )
 
 var log = logging.MustGetLogger("web")
 
-func AcceptAllOrigins(r *http.Request) bool { return true }
+type web struct {
+	config *config.Config
 
-func download(url string, dest string) error {
-	client := &http.Client{}
+	dataDir string
 
-	req, err := http.NewRequest("GET", geoLiteURL, nil)
-	if err != nil {
-		return err
-	}
+	ListenAddress string `toml:"listen"`
+	Enabled       bool   `toml:"enabled"`
 
-	var resp *http.Response
-	if resp, err = client.Do(req); err != nil {
-		return err
-	}
+	// Proxy is used to download the GeoLite2 database
+	Proxy string `toml:"proxy"`
 
-	defer resp.Body.Close()
+	// GeoIP configures the download of the GeoLite2 database
+	GeoIP GeoIPConfig `toml:"geoip"`
 
-	gzf, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return err
-	}
-	defer gzf.Close()
+	// Assets is the directory, relative to the data directory, with files
+	// that override the bundled assets
+	Assets string `toml:"assets"`
 
-	f, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
+	// Auth is the authentication of the api and the websocket: none,
+	// basic, token or oidc. It defaults to basic when a password is set.
+	Auth string `toml:"auth"`
 
-	defer f.Close()
+	// Username and Password are the credentials of basic authentication
+	Username string `toml:"username"`
+	Password string `toml:"password"`
 
-	_, err = io.Copy(f, gzf)
-	return err
-}
+	// Tokens are the api tokens of token authentication
+	Tokens []string `toml:"tokens"`
 
-const geoLiteURL = "http://geolite.maxmind.com/download/geoip/database/GeoLite2-City.mmdb.gz"
+	// Users are the users of basic and token authentication with their
+	// roles, the username and tokens above have the admin role
+	Users []User `toml:"users"`
 
-type web struct {
-	config *config.Config
+	// OIDC configures the provider of oidc authentication
+	OIDC OIDCConfig `toml:"oidc"`
 
-	dataDir string
+	// RequireToken requires a token to open the websocket, this is
+	// implied when authentication is enabled and refused without
+	RequireToken bool         `toml:"ws-token"`
+	TokenTTL     config.Delay `toml:"token-ttl"`
 
-	ListenAddress string `toml:"listen"`
-	Enabled       bool   `toml:"enabled"`
+	// Headless serves only the api and the websocket, without the
+	// bundled dashboard
+	Headless bool `toml:"headless"`
+
+	// TLSConfig serves the dashboard and the websocket over https
+	TLSConfig
+
+	// CORSOrigins are the origins of other frontends that may use the api
+	// and the websocket, "*" allows all origins. With authentication
+	// enabled and no origins, only the origin of the dashboard is allowed.
+	CORSOrigins []string `toml:"cors-origins"`
+
+	// Store persists the events, the dashboard shows the history after
+	// restarts
+	Store StoreConfig `toml:"store"`
+
+	// AuditLog is the file the exports and the changes of the sensors are
+	// recorded in, relative to the data directory
+	AuditLog string `toml:"audit-log"`
+
+	store eventStore
+
+	auditLog *auditLog
+
+	tokenSecret []byte
+
+	oidc *oidcVerifier
 
 	eb *eventbus.EventBus
 
+	stats *stats.Stats
+	graph *graph.Correlator
+
+	credentials *credentials.Tracker
+
+	c2 *c2.Tracker
+
+	scheduler *scheduler.Scheduler
+
+	transcripts *transcript.Store
+
+	trends *trends.Store
+
+	tracer *tracing.Tracer
+
+	geoip *geoDB
+	asn   *geoDB
+
 	start time.Time
 
+	server *http.Server
+
+	// lifecycle serializes starting and stopping
+	lifecycle sync.Mutex
+
+	// m guards the event channel, which is closed when the web interface
+	// is stopped
+	m          sync.RWMutex
+	running    bool
+	subscribed bool
+
+	// done is closed when the web interface is stopped, drained when the
+	// queued events have been handled
+	done    chan struct{}
+	drained chan struct{}
+
+	// wg waits for the websocket connections to close
+	wg sync.WaitGroup
+
 	eventCh   chan event.Event
 	messageCh chan json.Marshaler
 
-	// Registered connections.
-	connections map[*connection]bool
-
 	// Register requests from the connections.
 	register chan *connection
 
@@ -105,14 +171,30 @@ func New(options ...func(*web) error) (*web, error) {
 	hc := web{
 		eb: nil,
 
+		stats: stats.New(),
+		graph: graph.New(),
+
 		start: time.Now(),
 
 		ListenAddress: "127.0.0.1:8089",
 		Enabled:       false,
+		Assets:        "web",
+		TokenTTL:      config.Delay(time.Minute),
+		AuditLog:      "audit.log",
+
+		OIDC: OIDCConfig{
+			RoleClaim:   "roles",
+			DefaultRole: RoleViewer,
+		},
+
+		GeoIP: GeoIPConfig{
+			URL:      "https://download.maxmind.com/app/geoip_download",
+			Edition:  "GeoLite2-Country",
+			Interval: config.Delay(7 * 24 * time.Hour),
+		},
 
-		register:    make(chan *connection),
-		unregister:  make(chan *connection),
-		connections: make(map[*connection]bool),
+		register:   make(chan *connection),
+		unregister: make(chan *connection),
 
 		eventCh:   nil,
 		messageCh: make(chan json.Marshaler),
@@ -127,26 +209,67 @@ func New(options ...func(*web) error) (*web, error) {
 		}
 	}
 
+	// tokens are signed with a secret per run, and expire at restart
+	hc.tokenSecret = make([]byte, 32)
+	if _, err := rand.Read(hc.tokenSecret); err != nil {
+		return nil, err
+	}
+
+	if err := hc.setupAuth(); err != nil {
+		return nil, err
+	}
+
+	if err := hc.setupStore(); err != nil {
+		return nil, err
+	}
+
+	if err := hc.setupAudit(); err != nil {
+		return nil, err
+	}
+
 	return &hc, nil
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+func (web *web) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+
+			if len(web.CORSOrigins) > 0 {
+				return web.allowedOrigin(origin)
+			}
+
+			// an exposed dashboard only accepts its own origin
+			return web.authMode() == AuthNone || sameOrigin(origin, r.Host)
+		},
+	}
 }
 
+// SetEventBus sets the event bus, the web interface subscribes to the bus
+// when it is started.
 func (web *web) SetEventBus(eb *eventbus.EventBus) {
-	eb.Subscribe(web)
+	web.eb = eb
 }
 
+// Start starts the web interface, the web interface can be started again
+// after it has been stopped.
 func (web *web) Start() {
 	if !web.Enabled {
 		return
 	}
 
+	web.lifecycle.Lock()
+	defer web.lifecycle.Unlock()
+
+	if web.running {
+		return
+	}
+
 	handler := http.NewServeMux()
 
 	server := &http.Server{
@@ -154,23 +277,90 @@ func (web *web) Start() {
 		Handler: handler,
 	}
 
-	sh := http.FileServer(&assetfs.AssetFS{
-		Asset:     assets.Asset,
-		AssetDir:  assets.AssetDir,
-		AssetInfo: assets.AssetInfo,
-		Prefix:    assets.Prefix,
-	})
+	if web.TLSConfig.Enabled() {
+		config, err := web.TLSConfig.ServerConfig(web.dataDir)
+		if err != nil {
+			log.Errorf("Error configuring https of web interface: %s", err.Error())
+			return
+		}
+
+		server.TLSConfig = config
+	}
 
 	handler.HandleFunc("/ws", web.ServeWS)
-	handler.Handle("/", sh)
+	handler.Handle("/api/", web.cors(web.apiHandler()))
+
+	if web.authMode() != AuthNone {
+		log.Infof("Web module authentication: %s", web.authMode())
+	}
+
+	if web.Headless {
+		log.Info("Web module running headless, serving only the api")
+	} else {
+		sh := http.FileServer(web.assets(&assetfs.AssetFS{
+			Asset:     assets.Asset,
+			AssetDir:  assets.AssetDir,
+			AssetInfo: assets.AssetInfo,
+			Prefix:    assets.Prefix,
+		}))
+
+		// the assets contain no data, they're only protected when the
+		// browser can authenticate itself
+		if web.authMode() == AuthBasic {
+			sh = web.protect(sh)
+		}
+
+		handler.Handle("/", sh)
+	}
+
+	ln, err := net.Listen("tcp", web.ListenAddress)
+	if err != nil {
+		log.Errorf("Error running web interface: %s", err.Error())
+		return
+	}
+
+	// the store is closed when the web interface is stopped
+	if web.store == nil {
+		store, err := openEventStore(web.Store, web.dataDir)
+		if err != nil {
+			log.Errorf("Error opening event store: %s", err.Error())
+		}
+
+		web.store = store
+	}
+
+	done := make(chan struct{})
+	drained := make(chan struct{})
+
+	// broadcast sends the message to the websocket connections, until the
+	// web interface is stopped
+	broadcast := func(msg json.Marshaler) {
+		select {
+		case web.messageCh <- msg:
+		case <-done:
+		}
+	}
+
+	store := web.store
 
 	eventCh := make(chan event.Event)
 
 	go func(ch chan event.Event) {
+		defer close(drained)
+
 		for evt := range ch {
 			web.events.Append(evt)
 
-			web.messageCh <- Data("event", evt)
+			if store == nil {
+			} else if err := store.Append(evt); err != nil {
+				log.Errorf("Error storing event: %s", err.Error())
+			}
+
+			broadcast(Data("event", evt))
+
+			if update := web.graph.Correlate(evt); !update.Empty() {
+				broadcast(Data("attack_graph", update))
+			}
 
 			isoCode := evt.Get("source.country.isocode")
 			if isoCode == "" {
@@ -201,43 +391,181 @@ func (web *web) Start() {
 				})
 			}
 
-			web.messageCh <- Data("hot_countries", web.hotCountries)
+			broadcast(Data("hot_countries", web.hotCountries))
 		}
 	}(eventCh)
 
-	eventCh = resolver(web.dataDir, eventCh)
+	// the databases are kept when the web interface is restarted
+	if web.geoip == nil {
+		web.geoip = &geoDB{
+			edition: web.GeoIP.Edition,
+			path:    path.Join(web.dataDir, web.GeoIP.Edition+".mmdb"),
+		}
+	}
+
+	if web.GeoIP.ASN && web.asn == nil {
+		web.asn = &geoDB{
+			edition: asnEdition,
+			path:    path.Join(web.dataDir, asnEdition+".mmdb"),
+		}
+	}
+
+	web.fetch()
+
 	eventCh = filter(eventCh)
 
+	web.m.Lock()
 	web.eventCh = eventCh
+	web.server = server
+	web.done = done
+	web.drained = drained
+	web.running = true
+	web.m.Unlock()
+
+	// the web interface stays subscribed when it is restarted, events are
+	// dropped while it is stopped
+	if web.eb != nil && !web.subscribed {
+		web.eb.Subscribe(web)
+		web.subscribed = true
+	}
 
-	go web.run()
+	go web.run(done)
+	go web.feedServiceStats(done)
 
 	go func() {
-		log.Infof("Web interface started: %s", web.ListenAddress)
+		var err error
+
+		if server.TLSConfig != nil {
+			log.Infof("Web interface started: https://%s", ln.Addr().String())
+
+			// the certificates are provided by the tls configuration
+			err = server.ServeTLS(ln, "", "")
+		} else {
+			log.Infof("Web interface started: %s", ln.Addr().String())
+
+			err = server.Serve(ln)
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Error running web interface: %s", err.Error())
+		}
+	}()
+}
+
+// Stop stops the web interface. The queued events are handled before the
+// websocket connections are closed and the http server is shut down, ctx
+// limits the time to wait for the connections.
+func (web *web) Stop(ctx context.Context) error {
+	web.lifecycle.Lock()
+	defer web.lifecycle.Unlock()
+
+	web.m.Lock()
+
+	if !web.running {
+		web.m.Unlock()
+		return nil
+	}
+
+	web.running = false
+	close(web.eventCh)
+
+	web.m.Unlock()
+
+	select {
+	case <-web.drained:
+	case <-ctx.Done():
+	}
+
+	close(web.done)
+
+	err := web.server.Shutdown(ctx)
 
-		server.ListenAndServe()
+	// the websocket connections are hijacked, the server doesn't wait for
+	// them
+	closed := make(chan struct{})
+	go func() {
+		web.wg.Wait()
+		close(closed)
 	}()
+
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	if web.store != nil {
+		if serr := web.store.Close(); serr != nil && err == nil {
+			err = serr
+		}
+
+		web.store = nil
+	}
+
+	log.Info("Web interface stopped")
+	return err
 }
 
-func (web *web) run() {
+// run sends the messages to the registered connections, the connections
+// are closed when the web interface is stopped.
+func (web *web) run(done chan struct{}) {
+	connections := map[*connection]bool{}
+
 	for {
 		select {
+		case <-done:
+			for c := range connections {
+				c.close()
+			}
+
+			return
 		case c := <-web.register:
-			web.connections[c] = true
+			connections[c] = true
 		case c := <-web.unregister:
-			if _, ok := web.connections[c]; ok {
-				delete(web.connections, c)
+			if _, ok := connections[c]; ok {
+				delete(connections, c)
 
 				close(c.send)
 			}
 		case msg := <-web.messageCh:
-			for c := range web.connections {
+			for c := range connections {
+				if !c.subscribed(msg) {
+					continue
+				}
+
 				c.send <- msg
 			}
 		}
 	}
 }
 
+// serviceStatsTop is the number of credentials in the service statistics
+// feed of the dashboard
+const serviceStatsTop = 5
+
+// feedServiceStats periodically sends the service statistics to the
+// dashboard.
+func (web *web) feedServiceStats(done chan struct{}) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		select {
+		case web.messageCh <- Data("service_stats", web.stats.ServiceStats(serviceStatsTop)):
+		case <-done:
+			return
+		}
+	}
+}
+
 type Message struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
@@ -260,9 +588,9 @@ func Data(t string, data interface{}) json.Marshaler {
 func filter(outCh chan event.Event) chan event.Event {
 	ch := make(chan event.Event)
 	go func() {
-		for {
-			evt := <-ch
+		defer close(outCh)
 
+		for evt := range ch {
 			if category := evt.Get("category"); category == "heartbeat" {
 				continue
 			}
@@ -274,85 +602,75 @@ func filter(outCh chan event.Event) chan event.Event {
 	return ch
 }
 
-func resolver(dataDir string, outCh chan event.Event) chan event.Event {
-	dbPath := path.Join(dataDir, "GeoLite2-Country.mmdb")
+func (web *web) Send(evt event.Event) {
+	web.m.RLock()
+	defer web.m.RUnlock()
 
-	_, err := os.Stat(dbPath)
-	if os.IsNotExist(err) {
-		err = download(geoLiteURL, dbPath)
-		if err != nil {
-			log.Fatal(err)
-			return outCh
-		}
+	if !web.running {
+		return
 	}
 
-	ch := make(chan event.Event)
-	go func() {
-		db, err := maxminddb.Open(dbPath)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		defer db.Close()
-
-		for {
-			evt := <-ch
-
-			v := evt.Get("source-ip")
-			if v == "" {
-				outCh <- evt
-				continue
-			}
-
-			ip := net.ParseIP(v)
+	web.eventCh <- evt
+}
 
-			var record struct {
-				Country struct {
-					ISOCode string `maxminddb:"iso_code"`
-				} `maxminddb:"country"`
-			}
+func (web *web) ServeWS(w http.ResponseWriter, r *http.Request) {
+	web.wg.Add(1)
+	defer web.wg.Done()
 
-			if err = db.Lookup(ip, &record); err != nil {
-				log.Error("Error looking up country for: %s", err.Error())
+	web.m.RLock()
+	done := web.done
+	web.m.RUnlock()
 
-				outCh <- evt
-				continue
-			}
+	p := anonymous
 
-			evt.Store("source.country.isocode", record.Country.ISOCode)
-			outCh <- evt
+	if web.tokenRequired() {
+		var err error
+		if p, err = web.validateToken(r.URL.Query().Get("token"), time.Now()); err != nil {
+			log.Debugf("Refused websocket connection from %s: %s", r.RemoteAddr, err.Error())
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
 		}
-	}()
-
-	return ch
-}
-
-func (web *web) Send(evt event.Event) {
-	web.eventCh <- evt
-}
+	}
 
-func (web *web) ServeWS(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
+	ws, err := web.upgrader().Upgrade(w, r, nil)
 	if err != nil {
 		log.Errorf("Could not upgrade connection: %s", err.Error())
 		return
 	}
 
+	// the events are withheld from viewers, the stream is audited like the
+	// exports of the api
+	if !p.allowed(RoleOperator) {
+		web.audit(r.RemoteAddr, p, "denied", "websocket events", RoleOperator+" role required")
+	} else {
+		web.audit(r.RemoteAddr, p, "export", "websocket events", "")
+	}
+
 	c := &connection{
-		ws:   ws,
-		web:  web,
-		send: make(chan json.Marshaler, 100),
+		ws:        ws,
+		web:       web,
+		principal: p,
+		remote:    r.RemoteAddr,
+		send:      make(chan json.Marshaler, 100),
 	}
 
 	log.Info("Connection upgraded.")
 	defer func() {
-		c.web.unregister <- c
+		select {
+		case c.web.unregister <- c:
+		case <-done:
+		}
+
 		c.ws.Close()
 
 		log.Info("Connection closed")
 	}()
 
-	web.register <- c
+	select {
+	case web.register <- c:
+	case <-done:
+		return
+	}
 
 	c.send <- Data("metadata", Metadata{
 		Start:         web.start,
@@ -362,8 +680,10 @@ func (web *web) ServeWS(w http.ResponseWriter, r *http.Request) {
 		ShortCommitID: cmd.ShortCommitID,
 	})
 
-	c.send <- Data("events", web.events)
+	c.send <- Data("events", c.recentEvents(nil))
 	c.send <- Data("hot_countries", web.hotCountries)
+	c.send <- Data("service_stats", web.stats.ServiceStats(serviceStatsTop))
+	c.send <- Data("attack_graph", web.graph.Graph(""))
 
 	go c.writePump()
 	c.readPump()
@@ -0,0 +1,240 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// freeAddress returns a local address that isn't in use.
+func freeAddress(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+// readMessage reads messages until a message of the type is received.
+func readMessage(t *testing.T, ws *websocket.Conn, typ string) {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := struct {
+			Type string `json:"type"`
+		}{}
+
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Type == typ {
+			return
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	addr := freeAddress(t)
+
+	w, err := New(func(w *web) error {
+		w.Enabled = true
+		w.Headless = true
+		w.ListenAddress = addr
+		w.dataDir = dir
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w.Start()
+
+		ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readMessage(t, ws, "metadata")
+
+		w.Send(event.New(event.Category("ssh")))
+
+		readMessage(t, ws, "event")
+
+		// the client answers the close message of the server
+		go func() {
+			for {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		if err := w.Stop(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+
+		if _, err := net.Dial("tcp", addr); err == nil {
+			t.Fatal("Expected listener to be closed")
+		}
+
+		// events are dropped while stopped
+		w.Send(event.New(event.Category("ssh")))
+
+		ws.Close()
+	}
+}
+
+func TestViewerWebsocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	addr := freeAddress(t)
+
+	w, err := New(func(w *web) error {
+		w.Enabled = true
+		w.Headless = true
+		w.ListenAddress = addr
+		w.Auth = AuthToken
+		w.Tokens = []string{"secret-token"}
+		w.dataDir = dir
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Start()
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		w.Stop(ctx)
+	}()
+
+	w.events.Append(event.New(event.Category("ssh"), event.Custom("ssh.password", "toor")))
+
+	dial := func(role string) *websocket.Conn {
+		token := w.issueToken(principal{Name: role, Role: role}, time.Now().Add(time.Minute))
+
+		ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws?token="+token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ws
+	}
+
+	// events reads the recent events sent after connecting
+	events := func(ws *websocket.Conn) []interface{} {
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		for {
+			msg := struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}{}
+
+			if err := ws.ReadJSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.Type != "events" {
+				continue
+			}
+
+			recent := []interface{}{}
+			if err := json.Unmarshal(msg.Data, &recent); err != nil {
+				t.Fatal(err)
+			}
+
+			return recent
+		}
+	}
+
+	viewer := dial(RoleViewer)
+	defer viewer.Close()
+
+	if recent := events(viewer); len(recent) != 0 {
+		t.Errorf("Expected no events for viewers, got %v", recent)
+	}
+
+	operator := dial(RoleOperator)
+	defer operator.Close()
+
+	if recent := events(operator); len(recent) != 1 {
+		t.Errorf("Expected the recent events for operators, got %v", recent)
+	}
+
+	// the live stream is withheld from viewers
+	e := event.New(event.Category("ssh"))
+
+	if (&connection{principal: principal{Role: RoleViewer}}).subscribed(Data("event", e)) {
+		t.Error("Expected events not to be sent to viewers")
+	}
+
+	if !(&connection{principal: principal{Role: RoleOperator}}).subscribed(Data("event", e)) {
+		t.Error("Expected events to be sent to operators")
+	}
+
+	entries, err := w.auditLog.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := map[string]string{}
+	for _, entry := range entries {
+		if entry.Target == "websocket events" {
+			actions[entry.User] = entry.Action
+		}
+	}
+
+	if actions[RoleViewer] != "denied" || actions[RoleOperator] != "export" {
+		t.Errorf("Expected the websocket to be audited, got %v", actions)
+	}
+}